@@ -8,6 +8,7 @@
 const R2_BUCKET_NAME = 'your-bucket-name';
 const SIGNING_SECRET = 'your-signing-secret';
 const IMGPROXY_URL = 'https://your-imgproxy-instance.com';
+const ORIGIN_URL = 'https://api.mikeodnis.dev';
 const CACHE_TTL_IMMUTABLE = 31536000; // 1 year
 const CACHE_TTL_PRIVATE = 3600; // 1 hour
 
@@ -45,6 +46,17 @@ async function handleRequest(request) {
     return handlePublicAsset(request, url);
   }
 
+  // robots.txt, security.txt and other well-known files are configured
+  // on the service
+  if (path.startsWith('/.well-known/') || /^\/[^/]+\.txt$/.test(path)) {
+    return handleWellKnown(request, url);
+  }
+
+  // Static site deploys are served by the service, which knows the live one
+  if (path.startsWith('/deploys/') || path.startsWith('/latest/')) {
+    return handleDeploy(request, url);
+  }
+
   // Default: return 404
   return new Response('Not Found', { status: 404 });
 }
@@ -71,9 +83,23 @@ async function handlePublicAsset(request, url) {
       return new Response('Not Found', { status: 404 });
     }
 
+    // Drafts, archived assets and scheduled assets outside their publish
+    // window are hidden
+    const state = object.customMetadata?.state;
+    const publishAt = Date.parse(object.customMetadata?.['publish-at'] ?? '');
+    const unpublishAt = Date.parse(object.customMetadata?.['unpublish-at'] ?? '');
+    const now = Date.now();
+    if (state === 'draft' || state === 'archived' || now < publishAt || now >= unpublishAt) {
+      return new Response('Not Found', {
+        status: 404,
+        headers: { 'Cache-Control': 'no-store' },
+      });
+    }
+
     const headers = new Headers();
     object.writeHttpMetadata(headers);
     headers.set('etag', object.httpEtag);
+    sandboxHTML(headers, objectKey);
     
     // Immutable cache for assets with content hash
     if (objectKey.includes('/assets/')) {
@@ -81,6 +107,13 @@ async function handlePublicAsset(request, url) {
       headers.set('CDN-Cache-Control', `public, max-age=${CACHE_TTL_IMMUTABLE}`);
     }
 
+    // Don't cache past the unpublish time
+    if (!Number.isNaN(unpublishAt)) {
+      const remaining = Math.max(0, Math.floor((unpublishAt - now) / 1000));
+      headers.set('Cache-Control', `public, max-age=${remaining}`);
+      headers.set('CDN-Cache-Control', `public, max-age=${remaining}`);
+    }
+
     // CORS headers
     headers.set('Access-Control-Allow-Origin', '*');
     headers.set('Access-Control-Allow-Methods', 'GET, HEAD, OPTIONS');
@@ -99,6 +132,49 @@ async function handlePublicAsset(request, url) {
   }
 }
 
+/**
+ * Handle well-known file requests from the service, cached as it says
+ */
+async function handleWellKnown(request, url) {
+  try {
+    return await fetch(`${ORIGIN_URL}${url.pathname}`, {
+      method: request.method,
+      cf: { cacheEverything: true },
+    });
+  } catch (error) {
+    return new Response('Bad Gateway', { status: 502 });
+  }
+}
+
+/**
+ * Handle static site deploy requests from the service. Conditional and
+ * range headers go along, so /latest/ revalidates by ETag as the service
+ * asks and deploy files stay cached as immutable.
+ */
+async function handleDeploy(request, url) {
+  try {
+    return await fetch(`${ORIGIN_URL}${url.pathname}${url.search}`, {
+      method: request.method,
+      headers: request.headers,
+      cf: { cacheEverything: true },
+    });
+  } catch (error) {
+    return new Response('Bad Gateway', { status: 502 });
+  }
+}
+
+/**
+ * Serve uploaded HTML in a sandbox, so it can't script this origin or read
+ * its cookies. Deploys are sites published by the admin and run as is.
+ */
+function sandboxHTML(headers, objectKey) {
+  const type = (headers.get('content-type') ?? '').split(';')[0].trim().toLowerCase();
+  if ((type === 'text/html' || type === 'application/xhtml+xml') && !objectKey.startsWith('deploys/')) {
+    headers.set('Content-Security-Policy', 'sandbox');
+    headers.set('X-Content-Type-Options', 'nosniff');
+  }
+}
+
 /**
  * Handle private asset requests with signature validation
  */
@@ -141,6 +217,7 @@ async function handlePrivateAsset(request, url) {
     object.writeHttpMetadata(headers);
     headers.set('etag', object.httpEtag);
     headers.set('Cache-Control', `private, max-age=${CACHE_TTL_PRIVATE}`);
+    sandboxHTML(headers, objectKey);
     
     return new Response(object.body, {
       headers,
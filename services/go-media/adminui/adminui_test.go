@@ -0,0 +1,43 @@
+package adminui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler("/admin/")
+
+	tests := []struct {
+		path        string
+		want        int
+		contentType string
+		body        string
+	}{
+		{"/admin/", http.StatusOK, "text/html", "<title>CDN Admin</title>"},
+		{"/admin/app.js", http.StatusOK, "javascript", "Bearer"},
+		{"/admin/app.css", http.StatusOK, "text/css", ""},
+		{"/admin/missing.js", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.Contains(ct, tt.contentType) {
+			t.Errorf("GET %s Content-Type = %q, want %s", tt.path, ct, tt.contentType)
+		}
+		if !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("GET %s body missing %q", tt.path, tt.body)
+		}
+		if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self'") {
+			t.Errorf("GET %s Content-Security-Policy = %q", tt.path, csp)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+"use strict";
+
+// Public URL of an object, as returned by uploads and accepted by purge
+const publicBase = "https://cdn.mikeodnis.dev/";
+const tokenKey = "cdn-admin-token";
+const previewable = /\.(png|jpe?g|gif|webp|svg|avif)$/i;
+
+// Whether deleted objects go to the trash, found out at sign-in
+let trashEnabled = false;
+// Cursor of the next page of the trash listing
+let trashCursor = "";
+
+const $ = (id) => document.getElementById(id);
+
+function setStatus(message, isError = false) {
+  $("status").textContent = message;
+  $("status").className = isError ? "error" : "";
+}
+
+// api calls the JSON API with the admin token and returns the decoded body
+async function api(path, options = {}) {
+  const headers = new Headers(options.headers);
+  headers.set("Authorization", "Bearer " + sessionStorage.getItem(tokenKey));
+  const resp = await fetch(path, { ...options, headers });
+
+  let body = null;
+  if ((resp.headers.get("Content-Type") || "").includes("application/json")) {
+    body = await resp.json();
+  }
+  if (!resp.ok) {
+    const err = new Error((body && (body.message || body.error)) || (await resp.text().catch(() => "")) || resp.statusText);
+    err.status = resp.status;
+    err.code = body && body.code;
+    throw err;
+  }
+  return body;
+}
+
+function formatBytes(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function button(label, onClick) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  b.addEventListener("click", onClick);
+  return b;
+}
+
+// Sign-in: the token is checked against an admin-only endpoint and kept
+// for this tab only
+async function signIn(token) {
+  sessionStorage.setItem(tokenKey, token);
+  try {
+    await api("/v1/admin/usage");
+  } catch (err) {
+    // 501 means usage reporting is off, but the token was accepted
+    if (err.status !== 501) {
+      sessionStorage.removeItem(tokenKey);
+      throw err;
+    }
+  }
+  // 501 means the trash is off, and deletes are final
+  trashEnabled = await api("/v1/admin/trash?limit=1").then(() => true, () => false);
+  $("login").hidden = true;
+  $("nav").hidden = false;
+  showTab("assets");
+  listAssets();
+}
+
+function signOut() {
+  sessionStorage.removeItem(tokenKey);
+  location.reload();
+}
+
+function showTab(name) {
+  for (const section of ["assets", "upload", "trash", "usage"]) {
+    $(section).hidden = section !== name;
+  }
+  for (const b of document.querySelectorAll("nav [data-tab]")) {
+    b.classList.toggle("active", b.dataset.tab === name);
+  }
+  setStatus("");
+}
+
+async function listAssets() {
+  const prefix = $("prefix").value.trim();
+  const rows = $("asset-rows");
+  setStatus("Loading…");
+  try {
+    const objects = await api("/v1/media/list?prefix=" + encodeURIComponent(prefix));
+    rows.replaceChildren(...objects.map(assetRow));
+    $("list-note").textContent = objects.length >= 100 ? "Showing the first 100 objects; narrow the prefix to see more." : "";
+    setStatus(objects.length ? "" : "No objects under this prefix.");
+  } catch (err) {
+    setStatus("Listing failed: " + err.message, true);
+  }
+}
+
+function assetRow(obj) {
+  const tr = document.createElement("tr");
+  const cell = (content, className) => {
+    const td = document.createElement("td");
+    if (className) td.className = className;
+    if (content instanceof Node) td.append(content);
+    else td.textContent = content;
+    tr.append(td);
+    return td;
+  };
+
+  if (previewable.test(obj.Key)) {
+    const img = document.createElement("img");
+    img.className = "preview";
+    img.loading = "lazy";
+    img.alt = "";
+    img.src = "/v1/media/assets/" + obj.Key.split("/").map(encodeURIComponent).join("/");
+    cell(img);
+  } else {
+    cell("");
+  }
+  cell(obj.Key, "key");
+  cell(formatBytes(obj.Size));
+  cell(new Date(obj.LastModified).toLocaleString());
+
+  const actions = cell("", "actions");
+  actions.append(
+    button("Copy URL", () => navigator.clipboard.writeText(publicBase + obj.Key).then(() => setStatus("Copied " + publicBase + obj.Key))),
+    button("Sign", () => openSignDialog(obj.Key)),
+    button("Purge", () => purge(obj.Key)),
+    button("Delete", () => remove(obj.Key, tr)),
+  );
+  return tr;
+}
+
+async function purge(key) {
+  try {
+    await api("/v1/media/purge", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ files: [publicBase + key] }),
+    });
+    setStatus("Purged " + key + " from the edge cache.");
+  } catch (err) {
+    setStatus("Purge failed: " + err.message, true);
+  }
+}
+
+async function remove(key, row) {
+  const consequence = trashEnabled ? "It can be restored from the trash." : "This cannot be undone.";
+  if (!confirm("Delete " + key + "? " + consequence)) return;
+  try {
+    await api("/v1/media/delete/" + key.split("/").map(encodeURIComponent).join("/"), { method: "DELETE" });
+    row.remove();
+    setStatus("Deleted " + key + ".");
+  } catch (err) {
+    setStatus("Delete failed: " + err.message, true);
+  }
+}
+
+function openSignDialog(key) {
+  $("sign-key").textContent = key;
+  $("signed-url").value = "";
+  $("sign-dialog").showModal();
+}
+
+async function createSignedURL(event) {
+  event.preventDefault();
+  try {
+    const resp = await api("/v1/media/sign", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ path: $("sign-key").textContent, expires_in: Number($("expires").value) }),
+    });
+    $("signed-url").value = resp.url;
+    $("signed-url").select();
+  } catch (err) {
+    $("signed-url").value = "Failed: " + err.message;
+  }
+}
+
+async function upload(event) {
+  event.preventDefault();
+  const results = $("upload-results");
+  results.replaceChildren();
+
+  for (const file of $("files").files) {
+    const li = document.createElement("li");
+    li.textContent = file.name + ": uploading…";
+    results.append(li);
+
+    const form = new FormData();
+    form.append("file", file);
+    try {
+      const resp = await api("/v1/media/upload", { method: "POST", body: form });
+      li.textContent = file.name + " → " + resp.url;
+    } catch (err) {
+      li.textContent = file.name + ": " + err.message;
+    }
+  }
+  $("upload-form").reset();
+}
+
+// listTrash shows the first page of deleted objects under the prefix,
+// or with more set the next page, and the space the trash holds
+async function listTrash(more = false) {
+  const prefix = $("trash-prefix").value.trim();
+  const rows = $("trash-rows");
+  if (!more) trashCursor = "";
+  setStatus("Loading…");
+  try {
+    const page = await api("/v1/admin/trash?prefix=" + encodeURIComponent(prefix) + (trashCursor ? "&cursor=" + encodeURIComponent(trashCursor) : ""));
+    const items = page.items.map(trashRow);
+    if (more) rows.append(...items);
+    else rows.replaceChildren(...items);
+    trashCursor = page.next_cursor || "";
+    $("trash-more").hidden = !trashCursor;
+    setStatus(rows.children.length ? "" : "No deleted objects under this prefix.");
+  } catch (err) {
+    rows.replaceChildren();
+    $("trash-more").hidden = true;
+    setStatus(err.status === 501 ? "The trash is not enabled." : "Listing failed: " + err.message, err.status !== 501);
+    return;
+  }
+  if (!more) showTrashUsage();
+}
+
+async function showTrashUsage() {
+  try {
+    const usage = await api("/v1/admin/trash/usage");
+    $("trash-usage").textContent = usage.objects.toLocaleString() + " objects holding " + formatBytes(usage.bytes) + " in the trash.";
+  } catch (err) {
+    $("trash-usage").textContent = "";
+  }
+}
+
+function trashRow(item) {
+  const tr = document.createElement("tr");
+  for (const [content, className] of [[item.key, "key"], [formatBytes(item.size)], [new Date(item.deleted_at).toLocaleString()]]) {
+    const td = document.createElement("td");
+    if (className) td.className = className;
+    td.textContent = content;
+    tr.append(td);
+  }
+  const actions = document.createElement("td");
+  actions.className = "actions";
+  actions.append(button("Restore", () => restore({ keys: [item.key] }, tr)));
+  tr.append(actions);
+  return tr;
+}
+
+// restore puts the selected deleted objects back. A row restored on its
+// own is removed; after a prefix the listing is loaded again.
+async function restore(selection, row) {
+  try {
+    const resp = await api("/v1/admin/trash/restore", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ ...selection, overwrite: $("trash-overwrite").checked }),
+    });
+    const skipped = (resp.skipped || []).length;
+    const failed = (resp.failed || []).length;
+    let message = "Restored " + resp.restored + " object" + (resp.restored === 1 ? "" : "s") + ".";
+    if (skipped) message += " Skipped " + skipped + " with a newer object at the key.";
+    if (failed) message += " " + failed + " failed: " + resp.failed[0].key + ": " + resp.failed[0].error;
+    if (row && resp.restored) row.remove();
+    if (!row) await listTrash();
+    else showTrashUsage();
+    setStatus(message, failed > 0);
+  } catch (err) {
+    setStatus("Restore failed: " + err.message, true);
+  }
+}
+
+function restoreAll() {
+  const prefix = $("trash-prefix").value.trim();
+  if (!prefix) {
+    setStatus("Enter the prefix to restore.", true);
+    return;
+  }
+  if (!confirm("Restore every deleted object under " + prefix + "?")) return;
+  restore({ prefix });
+}
+
+async function showUsage(event) {
+  if (event) event.preventDefault();
+  const period = $("period").value;
+  const stats = $("usage-stats");
+  try {
+    const usage = await api("/v1/admin/usage?estimate=true" + (period ? "&period=" + period : ""));
+    const entries = [
+      ["Period", usage.period],
+      ["Storage", formatBytes(usage.storage_bytes)],
+      ["Egress", formatBytes(usage.egress_bytes)],
+      ["Requests", usage.requests.toLocaleString()],
+      ["Class A operations", usage.class_a_operations.toLocaleString()],
+      ["Class B operations", usage.class_b_operations.toLocaleString()],
+    ];
+    if (usage.estimated_cost) {
+      entries.push(["Estimated cost", usage.estimated_cost.total.toFixed(2) + " " + usage.estimated_cost.currency]);
+    }
+    stats.replaceChildren(...entries.flatMap(([name, value]) => {
+      const dt = document.createElement("dt");
+      const dd = document.createElement("dd");
+      dt.textContent = name;
+      dd.textContent = value;
+      return [dt, dd];
+    }));
+    setStatus("");
+  } catch (err) {
+    stats.replaceChildren();
+    setStatus(err.status === 501 ? "Usage reporting is not enabled." : "Usage failed: " + err.message, err.status !== 501);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("login-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    signIn($("token").value).catch((err) => setStatus("Sign-in failed: " + err.message, true));
+  });
+  $("logout").addEventListener("click", signOut);
+  $("list-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    listAssets();
+  });
+  $("upload-form").addEventListener("submit", upload);
+  $("usage-form").addEventListener("submit", showUsage);
+  $("trash-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    listTrash();
+  });
+  $("trash-more").addEventListener("click", () => listTrash(true));
+  $("trash-restore-all").addEventListener("click", restoreAll);
+  $("sign-create").addEventListener("click", createSignedURL);
+
+  for (const b of document.querySelectorAll("nav [data-tab]")) {
+    b.addEventListener("click", () => {
+      showTab(b.dataset.tab);
+      if (b.dataset.tab === "usage") showUsage();
+      if (b.dataset.tab === "trash") listTrash();
+    });
+  }
+
+  if (sessionStorage.getItem(tokenKey)) {
+    signIn(sessionStorage.getItem(tokenKey)).catch(() => setStatus("Session expired, sign in again.", true));
+  }
+});
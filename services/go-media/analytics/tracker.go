@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+// Tracker buffers per-key request and byte counters in memory and
+// periodically flushes them into the metadata index, so serving a
+// request never touches the index lock.
+type Tracker struct {
+	mu      sync.Mutex
+	pending map[string]*counter
+	idx     *index.Index
+	usage   *Usage
+}
+
+type counter struct {
+	requests   int64
+	bytes      int64
+	lastAccess time.Time
+}
+
+// AssetStats is the combined (flushed + pending) usage of a single key
+type AssetStats struct {
+	Key         string    `json:"key"`
+	Requests    int64     `json:"requests"`
+	BytesServed int64     `json:"bytes_served"`
+	LastAccess  time.Time `json:"last_access,omitempty"`
+}
+
+// NewTracker creates a tracker flushing into idx. usage may be nil to
+// disable monthly usage accounting.
+func NewTracker(idx *index.Index, usage *Usage) *Tracker {
+	return &Tracker{
+		pending: make(map[string]*counter),
+		idx:     idx,
+		usage:   usage,
+	}
+}
+
+// Record counts one request for key that served n bytes
+func (t *Tracker) Record(key string, n int64) {
+	if t == nil {
+		return
+	}
+
+	t.usage.RecordRequest(n)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.pending[key]
+	if !ok {
+		c = &counter{}
+		t.pending[key] = c
+	}
+	c.requests++
+	c.bytes += n
+	c.lastAccess = time.Now().UTC()
+}
+
+// Flush moves buffered counters into the index. Counters of keys no
+// longer indexed, deleted since they were served, are dropped.
+func (t *Tracker) Flush() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]*counter)
+	t.mu.Unlock()
+
+	for key, c := range pending {
+		c := c
+		t.idx.UpdateIfPresent(key, func(e *index.Entry) {
+			e.Stats.Requests += c.requests
+			e.Stats.BytesServed += c.bytes
+			if c.lastAccess.After(e.Stats.LastAccess) {
+				e.Stats.LastAccess = c.lastAccess
+			}
+		})
+	}
+}
+
+// Run flushes counters and saves the index every interval until ctx is
+// cancelled, then performs a final flush
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.flushAndSave()
+		case <-ctx.Done():
+			t.flushAndSave()
+			return
+		}
+	}
+}
+
+func (t *Tracker) flushAndSave() {
+	t.Flush()
+	if err := t.idx.Save(); err != nil {
+		log.Printf("Analytics: failed to save index: %v", err)
+	}
+
+	_, storageBytes := t.idx.Totals()
+	t.usage.SetStorageBytes(storageBytes)
+	if err := t.usage.Save(); err != nil {
+		log.Printf("Analytics: failed to save usage: %v", err)
+	}
+}
+
+// Stats returns usage for key including counters not yet flushed
+func (t *Tracker) Stats(key string) AssetStats {
+	stats := AssetStats{Key: key}
+	if e, ok := t.idx.Get(key); ok {
+		stats.Requests = e.Stats.Requests
+		stats.BytesServed = e.Stats.BytesServed
+		stats.LastAccess = e.Stats.LastAccess
+	}
+
+	t.mu.Lock()
+	if c, ok := t.pending[key]; ok {
+		stats.Requests += c.requests
+		stats.BytesServed += c.bytes
+		if c.lastAccess.After(stats.LastAccess) {
+			stats.LastAccess = c.lastAccess
+		}
+	}
+	t.mu.Unlock()
+
+	return stats
+}
+
+// Top returns the n most used keys under prefix, ranked by request
+// count or, if byBytes is set, by bytes served
+func (t *Tracker) Top(prefix string, n int, byBytes bool) []AssetStats {
+	t.Flush()
+
+	entries := t.idx.List(prefix)
+	stats := make([]AssetStats, 0, len(entries))
+	for _, e := range entries {
+		if e.Stats.Requests == 0 {
+			continue
+		}
+		stats = append(stats, AssetStats{
+			Key:         e.Key,
+			Requests:    e.Stats.Requests,
+			BytesServed: e.Stats.BytesServed,
+			LastAccess:  e.Stats.LastAccess,
+		})
+	}
+
+	sort.SliceStable(stats, func(a, b int) bool {
+		if byBytes {
+			return stats[a].BytesServed > stats[b].BytesServed
+		}
+		return stats[a].Requests > stats[b].Requests
+	})
+
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
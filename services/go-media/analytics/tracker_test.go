@@ -0,0 +1,69 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+func TestTrackerStatsIncludePending(t *testing.T) {
+	idx, _ := index.Open("")
+	tracker := NewTracker(idx, nil)
+	idx.Update("assets/a.png", func(e *index.Entry) { e.Size = 100 })
+
+	tracker.Record("assets/a.png", 100)
+	tracker.Record("assets/a.png", 50)
+	tracker.Flush()
+	tracker.Record("assets/a.png", 25)
+
+	stats := tracker.Stats("assets/a.png")
+	if stats.Requests != 3 {
+		t.Errorf("Requests = %d, want 3", stats.Requests)
+	}
+	if stats.BytesServed != 175 {
+		t.Errorf("BytesServed = %d, want 175", stats.BytesServed)
+	}
+
+	entry, _ := idx.Get("assets/a.png")
+	if entry.Stats.Requests != 2 {
+		t.Errorf("flushed Requests = %d, want 2", entry.Stats.Requests)
+	}
+}
+
+func TestTrackerFlushSkipsDeletedKeys(t *testing.T) {
+	idx, _ := index.Open("")
+	tracker := NewTracker(idx, nil)
+
+	tracker.Record("assets/gone.png", 100)
+	tracker.Flush()
+	if _, ok := idx.Get("assets/gone.png"); ok {
+		t.Error("Flush() recreated the entry of a deleted key")
+	}
+}
+
+func TestTrackerTop(t *testing.T) {
+	idx, _ := index.Open("")
+	tracker := NewTracker(idx, nil)
+	for _, key := range []string{"assets/popular.png", "assets/large.mp4", "other/file.txt"} {
+		idx.Update(key, func(e *index.Entry) {})
+	}
+
+	for i := 0; i < 3; i++ {
+		tracker.Record("assets/popular.png", 10)
+	}
+	tracker.Record("assets/large.mp4", 1000)
+	tracker.Record("other/file.txt", 1)
+
+	top := tracker.Top("assets/", 10, false)
+	if len(top) != 2 {
+		t.Fatalf("got %d entries, want 2", len(top))
+	}
+	if top[0].Key != "assets/popular.png" {
+		t.Errorf("top by requests = %s, want assets/popular.png", top[0].Key)
+	}
+
+	top = tracker.Top("", 1, true)
+	if len(top) != 1 || top[0].Key != "assets/large.mp4" {
+		t.Errorf("top by bytes = %+v, want assets/large.mp4", top)
+	}
+}
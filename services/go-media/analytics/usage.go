@@ -0,0 +1,201 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/fsutil"
+)
+
+// PeriodFormat is the layout of billing period identifiers (e.g. 2024-06)
+const PeriodFormat = "2006-01"
+
+// R2 operation classes, see https://developers.cloudflare.com/r2/pricing/
+var operationClasses = map[string]string{
+	"PutObject":               "class_a",
+	"CopyObject":              "class_a",
+	"ListObjectsV2":           "class_a",
+	"CreateMultipartUpload":   "class_a",
+	"UploadPart":              "class_a",
+	"CompleteMultipartUpload": "class_a",
+	"ListMultipartUploads":    "class_a",
+	"ListParts":               "class_a",
+	"GetObject":               "class_b",
+	"HeadObject":              "class_b",
+	"HeadBucket":              "class_b",
+	"DeleteObject":            "free",
+	"AbortMultipartUpload":    "free",
+}
+
+// Published R2 list prices (USD) and monthly free tier
+const (
+	storagePricePerGBMonth = 0.015
+	classAPricePerMillion  = 4.50
+	classBPricePerMillion  = 0.36
+	storageFreeGB          = 10
+	classAFreeOperations   = 1_000_000
+	classBFreeOperations   = 10_000_000
+	bytesPerGB             = 1 << 30
+	operationsPerMillion   = 1_000_000
+)
+
+// PeriodUsage aggregates usage for one calendar month (UTC)
+type PeriodUsage struct {
+	Period       string           `json:"period"`
+	StorageBytes int64            `json:"storage_bytes"`
+	EgressBytes  int64            `json:"egress_bytes"`
+	Requests     int64            `json:"requests"`
+	ClassA       int64            `json:"class_a_operations"`
+	ClassB       int64            `json:"class_b_operations"`
+	Free         int64            `json:"free_operations"`
+	Operations   map[string]int64 `json:"operations"`
+}
+
+// CostEstimate is an approximate R2 bill for a period
+type CostEstimate struct {
+	Currency string  `json:"currency"`
+	Storage  float64 `json:"storage"`
+	ClassA   float64 `json:"class_a"`
+	ClassB   float64 `json:"class_b"`
+	Egress   float64 `json:"egress"`
+	Total    float64 `json:"total"`
+}
+
+// Usage tracks monthly storage, egress and R2 operation counts,
+// persisted as a JSON file so reports survive restarts
+type Usage struct {
+	mu      sync.Mutex
+	path    string
+	periods map[string]*PeriodUsage
+	now     func() time.Time
+}
+
+// OpenUsage loads usage history from path. An empty path keeps usage in
+// memory only.
+func OpenUsage(path string) (*Usage, error) {
+	u := &Usage{
+		path:    path,
+		periods: make(map[string]*PeriodUsage),
+		now:     time.Now,
+	}
+
+	if path == "" {
+		return u, nil
+	}
+
+	if _, err := fsutil.ReadJSON(path, &u.periods); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// current returns the usage for the current month. Callers must hold u.mu.
+func (u *Usage) current() *PeriodUsage {
+	period := u.now().UTC().Format(PeriodFormat)
+	p, ok := u.periods[period]
+	if !ok {
+		p = &PeriodUsage{Period: period, Operations: make(map[string]int64)}
+		u.periods[period] = p
+	}
+	return p
+}
+
+// RecordOperation counts one R2 API call by operation name
+func (u *Usage) RecordOperation(op string) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	p := u.current()
+	p.Operations[op]++
+	switch operationClasses[op] {
+	case "class_a":
+		p.ClassA++
+	case "class_b":
+		p.ClassB++
+	default:
+		p.Free++
+	}
+}
+
+// RecordRequest counts one served request and its egress bytes
+func (u *Usage) RecordRequest(bytes int64) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	p := u.current()
+	p.Requests++
+	p.EgressBytes += bytes
+}
+
+// SetStorageBytes records the current total stored bytes for this month
+func (u *Usage) SetStorageBytes(bytes int64) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.current().StorageBytes = bytes
+}
+
+// Period returns a copy of the usage for period (YYYY-MM)
+func (u *Usage) Period(period string) (PeriodUsage, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	p, ok := u.periods[period]
+	if !ok {
+		return PeriodUsage{}, false
+	}
+
+	copied := *p
+	copied.Operations = make(map[string]int64, len(p.Operations))
+	for op, n := range p.Operations {
+		copied.Operations[op] = n
+	}
+	return copied, true
+}
+
+// Save persists usage history
+func (u *Usage) Save() error {
+	if u == nil || u.path == "" {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return fsutil.WriteJSON(u.path, u.periods)
+}
+
+// EstimateCost applies R2 list prices and the monthly free tier to p.
+// Egress from R2 is free; the field is kept so reports stay comparable
+// with other providers.
+func EstimateCost(p PeriodUsage) CostEstimate {
+	storageGB := float64(p.StorageBytes)/bytesPerGB - storageFreeGB
+	classA := float64(p.ClassA-classAFreeOperations) / operationsPerMillion
+	classB := float64(p.ClassB-classBFreeOperations) / operationsPerMillion
+
+	est := CostEstimate{
+		Currency: "USD",
+		Storage:  roundCents(max(storageGB, 0) * storagePricePerGBMonth),
+		ClassA:   roundCents(max(classA, 0) * classAPricePerMillion),
+		ClassB:   roundCents(max(classB, 0) * classBPricePerMillion),
+	}
+	est.Total = roundCents(est.Storage + est.ClassA + est.ClassB + est.Egress)
+	return est
+}
+
+func roundCents(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageClassifiesOperations(t *testing.T) {
+	u, _ := OpenUsage("")
+	u.now = func() time.Time { return time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC) }
+
+	u.RecordOperation("PutObject")
+	u.RecordOperation("ListObjectsV2")
+	u.RecordOperation("GetObject")
+	u.RecordOperation("DeleteObject")
+	u.RecordRequest(512)
+
+	p, ok := u.Period("2024-06")
+	if !ok {
+		t.Fatal("expected usage for 2024-06")
+	}
+	if p.ClassA != 2 || p.ClassB != 1 || p.Free != 1 {
+		t.Errorf("got class A=%d B=%d free=%d, want 2/1/1", p.ClassA, p.ClassB, p.Free)
+	}
+	if p.EgressBytes != 512 || p.Requests != 1 {
+		t.Errorf("got egress=%d requests=%d, want 512/1", p.EgressBytes, p.Requests)
+	}
+
+	if _, ok := u.Period("2024-05"); ok {
+		t.Error("expected no usage for 2024-05")
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage PeriodUsage
+		want  float64
+	}{
+		{
+			name:  "within free tier",
+			usage: PeriodUsage{StorageBytes: 5 << 30, ClassA: 1000, ClassB: 1000},
+			want:  0,
+		},
+		{
+			name:  "over free tier",
+			usage: PeriodUsage{StorageBytes: 110 << 30, ClassA: 3_000_000, ClassB: 20_000_000},
+			want:  1.5 + 9 + 3.6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EstimateCost(tt.usage)
+			if got.Total != tt.want {
+				t.Errorf("EstimateCost() total = %v, want %v", got.Total, tt.want)
+			}
+		})
+	}
+}
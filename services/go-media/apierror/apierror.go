@@ -0,0 +1,292 @@
+// Package apierror writes the service's error responses. Every error
+// carries a stable, machine-readable code alongside its message, so
+// clients branch on the code rather than on wording that may change, and
+// the ID of the request, so a report can be matched to the logs.
+//
+// Where Negotiate is in front of the handler, clients that send Accept:
+// application/problem+json get the same errors as RFC 9457 problem
+// details instead, as do requests marked with WithProblems. Messages are
+// in the language Accept-Language prefers, when there is a catalog for
+// it; codes never change with the language.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RequestIDHeader carries the ID of each request and its response
+const RequestIDHeader = "X-Request-ID"
+
+// ProblemContentType is the media type of RFC 9457 problem details
+const ProblemContentType = "application/problem+json"
+
+// TypeBase prefixes the code of a problem to make its type URI. Tag URIs
+// (RFC 4151) name a type without promising a page at the address.
+const TypeBase = "tag:mikeodnis.dev,2024:cdn/errors/"
+
+// Codes are part of the API: once published, a code keeps its meaning.
+// Conditions without a code of their own get the one for their status.
+const (
+	// By status
+	InvalidRequest      = "invalid_request"
+	ValidationFailed    = "validation_failed"
+	Unauthorized        = "unauthorized"
+	Forbidden           = "forbidden"
+	NotFound            = "not_found"
+	MethodNotAllowed    = "method_not_allowed"
+	Conflict            = "conflict"
+	LengthRequired      = "length_required"
+	PayloadTooLarge     = "payload_too_large"
+	RangeNotSatisfiable = "range_not_satisfiable"
+	RateLimited         = "rate_limited"
+	Internal            = "internal_error"
+	NotEnabled          = "not_enabled"
+	Upstream            = "upstream_error"
+	Unavailable         = "unavailable"
+
+	// Objects and uploads
+	AssetNotFound         = "asset_not_found"
+	AssetChanged          = "asset_changed"
+	ReservedKey           = "reserved_key"
+	InvalidFilename       = "invalid_filename"
+	FileTypeNotAllowed    = "file_type_not_allowed"
+	FileTooLarge          = "file_too_large"
+	UploadNotFound        = "upload_not_found"
+	UploadTokenRequired   = "upload_token_required"
+	UploadTokenInvalid    = "upload_token_invalid"
+	UploadTokenUsed       = "upload_token_used"
+	ContentTypeNotAllowed = "content_type_not_allowed"
+	EncryptionKeyMismatch = "encryption_key_mismatch"
+
+	// Deleting a prefix
+	ConfirmationRequired = "confirmation_required"
+	ConfirmationInvalid  = "confirmation_invalid"
+	PrefixChanged        = "prefix_changed"
+
+	// Signed URLs
+	SignatureInvalid     = "signature_invalid"
+	SignatureExpired     = "signature_expired"
+	SignatureNotYetValid = "signature_not_yet_valid"
+
+	// Everything else
+	CollectionNotFound = "collection_not_found"
+	DeployNotFound     = "deploy_not_found"
+	DomainNotFound     = "domain_not_found"
+	DomainExists       = "domain_exists"
+	DomainUnverified   = "domain_unverified"
+	TransformsBusy     = "transforms_busy"
+	ServerBusy         = "server_busy"
+	ShuttingDown       = "shutting_down"
+	ReadOnly           = "read_only"
+	AdminDisabled      = "admin_disabled"
+	InjectedFault      = "injected_fault"
+)
+
+// Response is the body of every error response
+type Response struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Error repeats Message, in English, for clients that predate codes
+	Error     string                 `json:"error"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Problem is the body of an error response as RFC 9457 problem details.
+// Code, RequestID and Details are extension members with the same
+// meaning as in Response.
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail"`
+	Instance  string                 `json:"instance,omitempty"`
+	Code      string                 `json:"code"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Write answers with status and an error with code and message
+func Write(w http.ResponseWriter, status int, code, message string) {
+	WriteDetails(w, status, code, message, nil)
+}
+
+// WriteDetails is Write with details about the error, such as which of
+// several keys were missing
+func WriteDetails(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	h := w.Header()
+	nw := negotiated(w)
+	localized, lang := message, DefaultLanguage
+	if nw != nil {
+		localized, lang = translate(nw.lang, message)
+	}
+	var body interface{} = Response{
+		Code:      code,
+		Message:   localized,
+		Error:     message,
+		RequestID: h.Get(RequestIDHeader),
+		Details:   details,
+	}
+	contentType := "application/json"
+	if nw != nil && nw.problem {
+		// The title follows the detail, so the two are in one language
+		title, _ := translate(lang, http.StatusText(status))
+		body = Problem{
+			Type:      TypeBase + code,
+			Title:     title,
+			Status:    status,
+			Detail:    localized,
+			Instance:  nw.instance,
+			Code:      code,
+			RequestID: h.Get(RequestIDHeader),
+			Details:   details,
+		}
+		contentType = ProblemContentType
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Language", lang)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Add("Vary", "Accept, Accept-Language")
+	// Set for a body that no longer follows
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Negotiate makes errors written behind it follow the request's Accept
+// and Accept-Language headers: problem details for requests that prefer
+// application/problem+json to application/json, and messages in the
+// preferred language among Languages. It may be applied again behind a
+// handler, such as http.TimeoutHandler, that hides the writer it was
+// given.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if negotiated(w) == nil {
+			instance, always := r.Context().Value(problemsKey{}).(string)
+			if !always {
+				instance = r.URL.Path
+			}
+			nw := &negotiatedWriter{
+				ResponseWriter: w,
+				problem:        always || prefersProblem(r.Header.Get("Accept")),
+				lang:           language(r.Header.Get("Accept-Language")),
+				instance:       instance,
+			}
+			if nw.problem || nw.lang != DefaultLanguage {
+				w = nw
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type problemsKey struct{}
+
+// WithProblems marks a request's context so that Negotiate answers it
+// with problem details whatever its Accept header, giving instance as
+// the path they are about: for versions of the API that have no other
+// error format and are served by rewriting the path.
+func WithProblems(ctx context.Context, instance string) context.Context {
+	return context.WithValue(ctx, problemsKey{}, instance)
+}
+
+// negotiatedWriter carries the form of the errors a response asked for
+type negotiatedWriter struct {
+	http.ResponseWriter
+	problem  bool
+	lang     string
+	instance string
+}
+
+func (nw *negotiatedWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
+
+// negotiated returns the negotiatedWriter w is or wraps, if any
+func negotiated(w http.ResponseWriter) *negotiatedWriter {
+	for {
+		switch ww := w.(type) {
+		case *negotiatedWriter:
+			return ww
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// prefersProblem reports whether an Accept header asks for problem
+// details: application/problem+json is listed with a q above zero and
+// at least that of application/json. Wildcards don't count, so clients
+// that accept anything keep the plain JSON errors.
+func prefersProblem(accept string) bool {
+	problem, plain := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := quality(params)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case ProblemContentType:
+			problem = q
+		case "application/json":
+			plain = q
+		}
+	}
+	return problem > 0 && problem >= plain
+}
+
+// quality returns the q parameter among the params of an Accept or
+// Accept-Language entry, 1 by default
+func quality(params string) float64 {
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return q
+}
+
+// CodeFor returns the code for an error with status and nothing more
+// specific to say
+func CodeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return InvalidRequest
+	case http.StatusUnauthorized:
+		return Unauthorized
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusLengthRequired:
+		return LengthRequired
+	case http.StatusRequestEntityTooLarge:
+		return PayloadTooLarge
+	case http.StatusRequestedRangeNotSatisfiable:
+		return RangeNotSatisfiable
+	case http.StatusTooManyRequests:
+		return RateLimited
+	case http.StatusNotImplemented:
+		return NotEnabled
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return Upstream
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	}
+	if status >= 500 {
+		return Internal
+	}
+	return InvalidRequest
+}
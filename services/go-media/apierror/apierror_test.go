@@ -0,0 +1,105 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set(RequestIDHeader, "req-1")
+	w.Header().Set("Content-Length", "1234")
+	WriteDetails(w, http.StatusNotFound, AssetNotFound, "objects not found: a.png", map[string]interface{}{"keys": []string{"a.png"}})
+
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Content-Length") != "" {
+		t.Errorf("status %d, headers %v", w.Code, w.Header())
+	}
+	var got struct {
+		Code      string              `json:"code"`
+		Message   string              `json:"message"`
+		Error     string              `json:"error"`
+		RequestID string              `json:"request_id"`
+		Details   map[string][]string `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "asset_not_found" || got.Message != "objects not found: a.png" || got.Error != got.Message || got.RequestID != "req-1" || got.Details["keys"][0] != "a.png" {
+		t.Errorf("body = %+v", got)
+	}
+}
+
+func TestProblem(t *testing.T) {
+	handler := Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, "req-1")
+		Write(w, http.StatusForbidden, SignatureExpired, "Signature expired")
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/media/private/a.png", nil)
+	req.Header.Set("Accept", "application/problem+json, application/json;q=0.9")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Content-Type") != ProblemContentType || w.Header().Get("Vary") != "Accept, Accept-Language" {
+		t.Errorf("headers %v", w.Header())
+	}
+	var got Problem
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := Problem{
+		Type:      TypeBase + "signature_expired",
+		Title:     "Forbidden",
+		Status:    http.StatusForbidden,
+		Detail:    "Signature expired",
+		Instance:  "/v1/media/private/a.png",
+		Code:      "signature_expired",
+		RequestID: "req-1",
+	}
+	if got.Details != nil || got.Type != want.Type || got.Title != want.Title || got.Status != want.Status || got.Detail != want.Detail ||
+		got.Instance != want.Instance || got.Code != want.Code || got.RequestID != want.RequestID {
+		t.Errorf("body = %+v, want %+v", got, want)
+	}
+
+	req.Header.Set("Accept", "*/*")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Accept: */* got %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestPrefersProblem(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                         false,
+		"*/*":                      false,
+		"application/json":         false,
+		"application/problem+json": true,
+		"Application/Problem+JSON": true,
+		"application/json, application/problem+json":       true,
+		"application/json, application/problem+json;q=0.5": false,
+		"application/problem+json;q=0":                     false,
+		"text/html, application/problem+json;q=0.1":        true,
+	} {
+		if got := prefersProblem(accept); got != want {
+			t.Errorf("prefersProblem(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestCodeFor(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusBadRequest:          InvalidRequest,
+		http.StatusNotFound:            NotFound,
+		http.StatusTooManyRequests:     RateLimited,
+		http.StatusNotImplemented:      NotEnabled,
+		http.StatusGatewayTimeout:      Upstream,
+		http.StatusInsufficientStorage: Internal,
+		http.StatusTeapot:              InvalidRequest,
+	} {
+		if got := CodeFor(status); got != want {
+			t.Errorf("CodeFor(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
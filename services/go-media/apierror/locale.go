@@ -0,0 +1,85 @@
+package apierror
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Catalogs translate error messages, and the status texts that title
+// problem details, from English. Each file in locales is named for its
+// language and maps English messages to their translation. Messages
+// without one, such as those that quote a value, stay in English.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// DefaultLanguage is the language of messages without a catalog
+const DefaultLanguage = "en"
+
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]map[string]string, len(files))
+	for _, f := range files {
+		data, err := locales.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic("apierror: " + f.Name() + ": " + err.Error())
+		}
+		catalogs[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = catalog
+	}
+	return catalogs
+}
+
+// Languages returns the languages error messages are available in,
+// DefaultLanguage first
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return append([]string{DefaultLanguage}, langs...)
+}
+
+// translate returns message in lang and lang or, without a translation,
+// message as it is and DefaultLanguage
+func translate(lang, message string) (string, string) {
+	if t, ok := catalogs[lang][message]; ok {
+		return t, lang
+	}
+	return message, DefaultLanguage
+}
+
+// language returns the language an Accept-Language header prefers among
+// those with a catalog, or DefaultLanguage. A tag such as pt-BR falls
+// back to its primary language, pt.
+func language(acceptLanguage string) string {
+	best, bestQ := DefaultLanguage, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		q := quality(params)
+		if q <= bestQ {
+			continue
+		}
+		primary, _, _ := strings.Cut(tag, "-")
+		for _, lang := range []string{tag, primary} {
+			if _, ok := catalogs[lang]; ok || lang == DefaultLanguage {
+				best, bestQ = lang, q
+				break
+			}
+		}
+	}
+	return best
+}
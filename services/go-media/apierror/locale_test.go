@@ -0,0 +1,132 @@
+package apierror
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLanguage(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                        "en",
+		"es":                      "es",
+		"pt-BR,pt;q=0.9":          "pt",
+		"FR-ca":                   "fr",
+		"ja, de;q=0.5":            "de",
+		"en-US, de;q=0.8":         "en",
+		"de;q=0.5, fr;q=0.7":      "fr",
+		"es;q=0, ja":              "en",
+		"zh-Hant, *;q=0.1":        "en",
+		"de-CH;q=0.9, es-MX;q=1":  "es",
+		"it, pt-PT;q=0.3, en;q=0": "pt",
+	} {
+		if got := language(header); got != want {
+			t.Errorf("language(%q) = %s, want %s", header, got, want)
+		}
+	}
+}
+
+func TestLocalized(t *testing.T) {
+	handler := Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message := r.URL.Query().Get("message")
+		Write(w, http.StatusNotFound, AssetNotFound, message)
+	}))
+	serve := func(message, accept, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/?message="+message, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("Asset+not+found", "", "es-ES")
+	var resp Response
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Code != AssetNotFound || resp.Message != "Recurso no encontrado" || resp.Error != "Asset not found" || w.Header().Get("Content-Language") != "es" {
+		t.Errorf("Spanish error = %+v, Content-Language %s", resp, w.Header().Get("Content-Language"))
+	}
+
+	// Without a translation, the message and its title stay in English
+	w = serve("No+such+key:+a.png", ProblemContentType, "de")
+	var problem Problem
+	json.NewDecoder(w.Body).Decode(&problem)
+	if problem.Detail != "No such key: a.png" || problem.Title != "Not Found" || w.Header().Get("Content-Language") != "en" {
+		t.Errorf("untranslated problem = %+v, Content-Language %s", problem, w.Header().Get("Content-Language"))
+	}
+
+	w = serve("Asset+not+found", ProblemContentType, "fr")
+	problem = Problem{}
+	json.NewDecoder(w.Body).Decode(&problem)
+	if problem.Detail != "Ressource introuvable" || problem.Title != "Introuvable" || problem.Code != AssetNotFound {
+		t.Errorf("French problem = %+v", problem)
+	}
+}
+
+// Every message written as a literal somewhere in the service, and the
+// title of every status with a code, has a translation in every catalog
+func TestCatalogsComplete(t *testing.T) {
+	messages := map[string]string{}
+	for _, status := range []int{400, 401, 403, 404, 405, 409, 411, 413, 416, 428, 429, 500, 501, 502, 503, 504} {
+		messages[http.StatusText(status)] = "status " + strconv.Itoa(status)
+	}
+	err := filepath.Walk("..", func(path string, info os.FileInfo, err error) error {
+		if err != nil || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 4 {
+				return true
+			}
+			var name string
+			switch fn := call.Fun.(type) {
+			case *ast.Ident:
+				name = fn.Name
+			case *ast.SelectorExpr:
+				name = fn.Sel.Name
+			}
+			if name != "respondError" && name != "Write" && name != "WriteDetails" {
+				return true
+			}
+			if lit, ok := call.Args[3].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				message, _ := strconv.Unquote(lit.Value)
+				messages[message] = fset.Position(lit.Pos()).String()
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) < 50 {
+		t.Fatalf("found only %d messages; did the calls change?", len(messages))
+	}
+
+	for lang, catalog := range catalogs {
+		for message, at := range messages {
+			if catalog[message] == "" {
+				t.Errorf("%s: no translation of %q (%s)", lang, message, at)
+			}
+		}
+		for message := range catalog {
+			if _, ok := messages[message]; !ok {
+				t.Errorf("%s: %q is no longer a message", lang, message)
+			}
+		}
+	}
+}
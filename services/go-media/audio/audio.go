@@ -0,0 +1,252 @@
+// Package audio converts audio assets for the variants requested with
+// ?format=, ?bitrate= and ?normalize=, such as an MP3 of a WAV master at
+// a podcast's loudness. The work is done by ffmpeg.
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultBitrate is the bitrate, in kbps, of output none is asked for
+const DefaultBitrate = 128
+
+// DefaultLoudness is the integrated loudness, in LUFS, normalized audio
+// is brought to when none is configured: the usual target for podcasts,
+// measured as EBU R128 does
+const DefaultLoudness = -16
+
+// Bitrates are the bitrates, in kbps, requests may ask for, so a request
+// can't make a variant for every number
+var Bitrates = []int{64, 96, 128, 160, 192, 256, 320}
+
+// format is an output format and how ffmpeg writes it
+type format struct {
+	codec       string
+	ext         string
+	contentType string
+	sampleRate  string // after normalizing, which resamples
+}
+
+// formats are the output formats
+var formats = map[string]format{
+	"mp3": {codec: "libmp3lame", ext: ".mp3", contentType: "audio/mpeg", sampleRate: "44100"},
+	"ogg": {codec: "libopus", ext: ".ogg", contentType: "audio/ogg", sampleRate: "48000"},
+	"aac": {codec: "aac", ext: ".m4a", contentType: "audio/mp4", sampleRate: "44100"},
+}
+
+// sourceExts are the file types converted, with the output format that
+// keeps theirs, if any
+var sourceExts = map[string]string{
+	".mp3": "mp3", ".ogg": "ogg", ".oga": "ogg", ".opus": "ogg", ".m4a": "aac", ".aac": "aac",
+	".wav": "", ".flac": "",
+}
+
+var ErrInvalidOptions = errors.New("invalid audio options")
+
+// Options is one conversion. The zero value changes nothing.
+type Options struct {
+	Format    string // output format; empty keeps the source's
+	Bitrate   int    // kbps; 0 is DefaultBitrate
+	Normalize bool   // bring the loudness to the configured target
+}
+
+// IsZero reports whether o asks for no conversion
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// ContentType returns the media type of the output, or "" when it keeps
+// the source's format
+func (o Options) ContentType() string {
+	return formats[o.Format].contentType
+}
+
+// Source reports whether key's file type is one audio is converted from
+func Source(key string) bool {
+	_, ok := sourceExts[strings.ToLower(path.Ext(key))]
+	return ok
+}
+
+// ParseQuery reads Options from format, bitrate (such as 128k) and
+// normalize. It returns the zero Options when none of them is set.
+func ParseQuery(q url.Values) (Options, error) {
+	var o Options
+	if f := strings.ToLower(q.Get("format")); f != "" {
+		if _, ok := formats[f]; !ok {
+			return Options{}, fmt.Errorf("%w: format must be mp3, ogg or aac", ErrInvalidOptions)
+		}
+		o.Format = f
+	}
+	if b := q.Get("bitrate"); b != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(b), "k"))
+		if err != nil || !contains(Bitrates, n) {
+			return Options{}, fmt.Errorf("%w: bitrate must be one of %s", ErrInvalidOptions, bitrateList())
+		}
+		o.Bitrate = n
+	}
+	switch q.Get("normalize") {
+	case "", "0", "false":
+	case "1", "true":
+		o.Normalize = true
+	default:
+		return Options{}, fmt.Errorf("%w: normalize must be 1 or 0", ErrInvalidOptions)
+	}
+	if o.Bitrate == DefaultBitrate {
+		o.Bitrate = 0
+	}
+	return o, nil
+}
+
+func bitrateList() string {
+	var s []string
+	for _, b := range Bitrates {
+		s = append(s, strconv.Itoa(b)+"k")
+	}
+	return strings.Join(s, ", ")
+}
+
+// For returns o with the format filled in from key when it keeps the
+// source's, which WAV and FLAC sources can't
+func (o Options) For(key string) (Options, error) {
+	if o.Format != "" || o.IsZero() {
+		return o, nil
+	}
+	o.Format = sourceExts[strings.ToLower(path.Ext(key))]
+	if o.Format == "" {
+		return Options{}, fmt.Errorf("%w: %s sources need a format", ErrInvalidOptions, path.Ext(key))
+	}
+	return o, nil
+}
+
+// Fingerprint names o, whose format is set, in keys and ETags, such as
+// "mp3-b192-norm". ParseFingerprint reverses it.
+func (o Options) Fingerprint() string {
+	parts := []string{o.Format}
+	if o.Bitrate > 0 {
+		parts = append(parts, "b"+strconv.Itoa(o.Bitrate))
+	}
+	if o.Normalize {
+		parts = append(parts, "norm")
+	}
+	return strings.Join(parts, "-")
+}
+
+// Query returns the query parameters ParseQuery reads o from
+func (o Options) Query() url.Values {
+	q := url.Values{"format": {o.Format}}
+	if o.Bitrate > 0 {
+		q.Set("bitrate", strconv.Itoa(o.Bitrate)+"k")
+	}
+	if o.Normalize {
+		q.Set("normalize", "1")
+	}
+	return q
+}
+
+// ParseFingerprint returns the Options named by fingerprint
+func ParseFingerprint(fingerprint string) (Options, bool) {
+	parts := strings.Split(fingerprint, "-")
+	q := url.Values{"format": {parts[0]}}
+	for _, part := range parts[1:] {
+		switch {
+		case part == "norm":
+			q.Set("normalize", "1")
+		case strings.HasPrefix(part, "b"):
+			q.Set("bitrate", part[1:])
+		default:
+			return Options{}, false
+		}
+	}
+	o, err := ParseQuery(q)
+	if err != nil || o.Format == "" || o.Fingerprint() != fingerprint {
+		return Options{}, false
+	}
+	return o, true
+}
+
+func contains[T comparable](list []T, v T) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Args returns the ffmpeg arguments converting input to output with o,
+// whose format is set. Normalizing is ffmpeg's single-pass loudnorm,
+// to loudness LUFS with peaks at most -1.5 dBTP.
+func Args(o Options, loudness int, input, output string) []string {
+	f := formats[o.Format]
+	bitrate := o.Bitrate
+	if bitrate == 0 {
+		bitrate = DefaultBitrate
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", input,
+		"-map", "0:a:0", "-vn"}
+	if o.Normalize {
+		args = append(args,
+			"-af", "loudnorm=I="+strconv.Itoa(loudness)+":TP=-1.5:LRA=11",
+			"-ar", f.sampleRate)
+	}
+	args = append(args, "-c:a", f.codec, "-b:a", strconv.Itoa(bitrate)+"k")
+	if o.Format == "aac" {
+		// Let players start before the whole file has arrived
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, output)
+}
+
+// Converter runs ffmpeg
+type Converter struct {
+	// FFmpeg is the binary's path, looked up on the PATH when empty
+	FFmpeg string
+	// Loudness is the target of normalizing, in LUFS; 0 is
+	// DefaultLoudness
+	Loudness int
+}
+
+// Convert converts src with o, whose format is set, returning the output
+// and its content type
+func (c Converter) Convert(ctx context.Context, src []byte, o Options) ([]byte, string, error) {
+	dir, err := os.MkdirTemp("", "audio")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	input, output := filepath.Join(dir, "in"), filepath.Join(dir, "out"+formats[o.Format].ext)
+	if err := os.WriteFile(input, src, 0o600); err != nil {
+		return nil, "", err
+	}
+
+	loudness := c.Loudness
+	if loudness == 0 {
+		loudness = DefaultLoudness
+	}
+	name := c.FFmpeg
+	if name == "" {
+		name = "ffmpeg"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, Args(o, loudness, input, output)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := bytes.TrimSpace(stderr.Bytes())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return nil, "", fmt.Errorf("ffmpeg: %w: %s", err, msg)
+	}
+	out, err := os.ReadFile(output)
+	return out, o.ContentType(), err
+}
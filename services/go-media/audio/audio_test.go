@@ -0,0 +1,93 @@
+package audio
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Options
+		err   bool
+	}{
+		{query: "", want: Options{}},
+		{query: "format=mp3", want: Options{Format: "mp3"}},
+		{query: "format=OGG&bitrate=96k", want: Options{Format: "ogg", Bitrate: 96}},
+		{query: "format=aac&bitrate=192", want: Options{Format: "aac", Bitrate: 192}},
+		// The default bitrate is dropped, so both share a variant
+		{query: "format=mp3&bitrate=128k", want: Options{Format: "mp3"}},
+		{query: "normalize=1", want: Options{Normalize: true}},
+		{query: "normalize=0", want: Options{}},
+		{query: "format=wav", err: true},
+		{query: "format=mp3&bitrate=100k", err: true},
+		{query: "format=mp3&bitrate=loud", err: true},
+		{query: "normalize=yes", err: true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := ParseQuery(q)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("%s: err = %v, want ErrInvalidOptions", tt.query, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %+v, %v; want %+v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestOptionsFor(t *testing.T) {
+	o, err := Options{Normalize: true}.For("episodes/42.m4a")
+	if err != nil || o.Format != "aac" {
+		t.Errorf("normalizing an .m4a = %+v, %v; want aac", o, err)
+	}
+	if _, err := (Options{Bitrate: 64}).For("masters/42.wav"); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("a WAV without a format: %v", err)
+	}
+	if o, err := (Options{}).For("masters/42.wav"); err != nil || !o.IsZero() {
+		t.Errorf("no options = %+v, %v", o, err)
+	}
+}
+
+func TestFingerprintRoundTrip(t *testing.T) {
+	for _, o := range []Options{
+		{Format: "mp3"},
+		{Format: "ogg", Bitrate: 64},
+		{Format: "aac", Bitrate: 320, Normalize: true},
+		{Format: "mp3", Normalize: true},
+	} {
+		fp := o.Fingerprint()
+		if strings.ContainsAny(fp, "_/") {
+			t.Errorf("fingerprint %q can't name a variant", fp)
+		}
+		got, ok := ParseFingerprint(fp)
+		if !ok || got != o {
+			t.Errorf("ParseFingerprint(%q) = %+v, %v; want %+v", fp, got, ok, o)
+		}
+		q, err := ParseQuery(o.Query())
+		if err != nil || q != o {
+			t.Errorf("ParseQuery(%v) = %+v, %v; want %+v", o.Query(), q, err, o)
+		}
+	}
+	for _, fp := range []string{"mp3-b128", "flac", "mp3-b100", "mp3-loud", "w300-webp"} {
+		if o, ok := ParseFingerprint(fp); ok {
+			t.Errorf("ParseFingerprint(%q) = %+v", fp, o)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	args := strings.Join(Args(Options{Format: "mp3", Bitrate: 192, Normalize: true}, -16, "in", "out.mp3"), " ")
+	if want := "-map 0:a:0 -vn -af loudnorm=I=-16:TP=-1.5:LRA=11 -ar 44100 -c:a libmp3lame -b:a 192k out.mp3"; !strings.HasSuffix(args, want) {
+		t.Errorf("args = %s\nwant suffix %s", args, want)
+	}
+	args = strings.Join(Args(Options{Format: "aac"}, -16, "in", "out.m4a"), " ")
+	if want := "-map 0:a:0 -vn -c:a aac -b:a 128k -movflags +faststart out.m4a"; !strings.HasSuffix(args, want) {
+		t.Errorf("args = %s\nwant suffix %s", args, want)
+	}
+}
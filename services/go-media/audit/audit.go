@@ -0,0 +1,233 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a single audited operation
+type Record struct {
+	Time      time.Time         `json:"time"`
+	Action    string            `json:"action"`
+	Key       string            `json:"key,omitempty"`
+	Actor     string            `json:"actor"`
+	SourceIP  string            `json:"source_ip"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Status    int               `json:"status"`
+	Result    string            `json:"result"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Filter selects records in Query. Zero values match everything.
+type Filter struct {
+	Action string
+	Key    string
+	Actor  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// ObjectWriter is the subset of the storage client used to ship audit
+// batches into the bucket
+type ObjectWriter interface {
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string) error
+}
+
+// Log is an append-only audit log backed by a local NDJSON file, with
+// optional shipping of batches to NDJSON objects in the bucket
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	shipper ObjectWriter
+	prefix  string
+	batch   []Record
+}
+
+// Open opens (or creates) the audit log at path for appending
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &Log{path: path, file: file}, nil
+}
+
+// ShipTo additionally copies records to NDJSON objects under prefix in
+// the bucket, one object per flush
+func (l *Log) ShipTo(w ObjectWriter, prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.shipper = w
+	l.prefix = strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// Append writes rec to the log
+func (l *Log) Append(rec Record) {
+	if l == nil {
+		return
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Audit: failed to marshal record: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Audit: failed to write record: %v", err)
+	}
+	if l.shipper != nil {
+		l.batch = append(l.batch, rec)
+	}
+}
+
+// Query scans the log and returns matching records, newest first
+func (l *Log) Query(f Filter) ([]Record, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	records := make([]Record, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if f.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	// Newest first
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[:f.Limit]
+	}
+	return records, nil
+}
+
+func (f Filter) matches(rec Record) bool {
+	if f.Action != "" && rec.Action != f.Action {
+		return false
+	}
+	if f.Key != "" && !strings.HasPrefix(rec.Key, f.Key) {
+		return false
+	}
+	if f.Actor != "" && rec.Actor != f.Actor {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && rec.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Flush ships buffered records to the bucket, if shipping is enabled
+func (l *Log) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	batch := l.batch
+	l.batch = nil
+	shipper, prefix := l.shipper, l.prefix
+	l.mu.Unlock()
+
+	if shipper == nil || len(batch) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range batch {
+		enc.Encode(rec)
+	}
+
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s%s/%s.ndjson", prefix, now.Format("2006/01/02"), now.Format("150405.000000000"))
+	if err := shipper.PutObject(ctx, key, &buf, "application/x-ndjson", nil); err != nil {
+		// Put the batch back so the next flush retries it
+		l.mu.Lock()
+		l.batch = append(batch, l.batch...)
+		l.mu.Unlock()
+		return fmt.Errorf("failed to ship audit batch: %w", err)
+	}
+
+	return nil
+}
+
+// Run flushes shipped batches every interval until ctx is cancelled
+func (l *Log) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Flush(ctx); err != nil {
+				log.Printf("Audit: %v", err)
+			}
+		case <-ctx.Done():
+			// The request context is gone; use a fresh one for the last flush
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := l.Flush(flushCtx); err != nil {
+				log.Printf("Audit: %v", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// Close closes the underlying file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// ClientIP returns the originating client address, preferring the first
+// X-Forwarded-For entry set by the reverse proxy
+func ClientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
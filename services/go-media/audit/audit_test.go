@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeWriter struct {
+	keys   []string
+	bodies []string
+}
+
+func (f *fakeWriter) PutObject(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string) error {
+	data, _ := io.ReadAll(body)
+	f.keys = append(f.keys, key)
+	f.bodies = append(f.bodies, string(data))
+	return nil
+}
+
+func openTestLog(t *testing.T) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit.ndjson"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestMiddlewareRecordsOutcome(t *testing.T) {
+	l := openTestLog(t)
+
+	ok := l.Middleware("asset.upload")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Annotate(r, "assets/abc.png", map[string]string{"filename": "cat.png"})
+		w.WriteHeader(http.StatusOK)
+	}))
+	failed := l.Middleware("asset.delete")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest("POST", "/v1/media/upload", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	ok.ServeHTTP(httptest.NewRecorder(), req)
+	failed.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/v1/media/delete/x", nil))
+
+	records, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	// Newest first
+	del, up := records[0], records[1]
+	if del.Action != "asset.delete" || del.Result != "failure" || del.Actor != "anonymous" {
+		t.Errorf("unexpected delete record: %+v", del)
+	}
+	if up.Key != "assets/abc.png" || up.SourceIP != "203.0.113.7" || up.Details["filename"] != "cat.png" {
+		t.Errorf("unexpected upload record: %+v", up)
+	}
+	if !strings.HasPrefix(up.Actor, "token:") || strings.Contains(up.Actor, "secret") {
+		t.Errorf("actor should be a token fingerprint, got %q", up.Actor)
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	l := openTestLog(t)
+	l.Append(Record{Action: "asset.upload", Key: "assets/a.png"})
+	l.Append(Record{Action: "asset.upload", Key: "docs/b.pdf"})
+	l.Append(Record{Action: "cache.purge"})
+
+	records, _ := l.Query(Filter{Action: "asset.upload", Key: "assets/"})
+	if len(records) != 1 || records[0].Key != "assets/a.png" {
+		t.Errorf("unexpected filtered records: %+v", records)
+	}
+
+	records, _ = l.Query(Filter{Limit: 2})
+	if len(records) != 2 || records[0].Action != "cache.purge" {
+		t.Errorf("limit should keep the newest records, got %+v", records)
+	}
+}
+
+func TestFlushShipsBatch(t *testing.T) {
+	l := openTestLog(t)
+	w := &fakeWriter{}
+	l.ShipTo(w, "logs/audit/")
+
+	l.Append(Record{Action: "asset.upload", Key: "assets/a.png"})
+	l.Append(Record{Action: "asset.delete", Key: "assets/a.png"})
+
+	if err := l.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(w.keys) != 1 || !strings.HasPrefix(w.keys[0], "logs/audit/") {
+		t.Fatalf("unexpected shipped keys: %v", w.keys)
+	}
+	if strings.Count(w.bodies[0], "\n") != 2 {
+		t.Errorf("expected 2 NDJSON lines, got %q", w.bodies[0])
+	}
+
+	// Nothing pending: no new object
+	l.Flush(context.Background())
+	if len(w.keys) != 1 {
+		t.Errorf("empty flush should not write an object")
+	}
+}
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type contextKey struct{}
+
+// annotation carries handler-supplied audit fields back to the middleware
+type annotation struct {
+	key     string
+	details map[string]string
+}
+
+// Annotate sets the object key and extra details recorded for the
+// current request. It is a no-op for requests that are not audited.
+func Annotate(r *http.Request, key string, details map[string]string) {
+	a, ok := r.Context().Value(contextKey{}).(*annotation)
+	if !ok {
+		return
+	}
+	if key != "" {
+		a.key = key
+	}
+	for k, v := range details {
+		if a.details == nil {
+			a.details = make(map[string]string)
+		}
+		a.details[k] = v
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Middleware records one audit entry per request under action, including
+// rejected and failed attempts. The key defaults to the {path} route
+// variable and can be overridden by the handler via Annotate.
+func (l *Log) Middleware(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			a := &annotation{key: mux.Vars(r)["path"]}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), contextKey{}, a)))
+
+			result := "success"
+			if rec.status >= 400 {
+				result = "failure"
+			}
+
+			l.Append(Record{
+				Time:      time.Now().UTC(),
+				Action:    action,
+				Key:       a.key,
+				Actor:     Actor(r),
+				SourceIP:  ClientIP(r),
+				UserAgent: r.UserAgent(),
+				Status:    rec.status,
+				Result:    result,
+				Details:   a.details,
+			})
+		})
+	}
+}
+
+// Actor identifies the credential used for a request without recording
+// the secret itself: bearer tokens are reduced to a short fingerprint and
+// Basic credentials to the user name.
+func Actor(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return "basic:" + user
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "anonymous"
+	}
+	return TokenActor(strings.TrimPrefix(auth, "Bearer "))
+}
+
+// TokenActor reduces a bearer token to the fingerprint recorded as actor
+func TokenActor(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// ParseFilter builds a Filter from query parameters: action, key (prefix),
+// actor, since and until (RFC 3339), and limit (default 100)
+func ParseFilter(q map[string][]string) (Filter, error) {
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	f := Filter{
+		Action: get("action"),
+		Key:    get("key"),
+		Actor:  get("actor"),
+		Limit:  100,
+	}
+
+	if v := get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("since must be an RFC 3339 timestamp")
+		}
+		f.Since = t
+	}
+	if v := get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("until must be an RFC 3339 timestamp")
+		}
+		f.Until = t
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			return f, fmt.Errorf("limit must be between 1 and 10000")
+		}
+		f.Limit = n
+	}
+
+	return f, nil
+}
@@ -0,0 +1,232 @@
+// Package bucketevents consumes R2 event notifications from a Cloudflare
+// Queue, so objects written to the bucket by other systems are seen by
+// the service as if they had been uploaded through it.
+package bucketevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// batchSize is the most messages pulled at once
+	batchSize = 50
+
+	// visibilityTimeout is how long pulled messages are hidden from other
+	// consumers before they are redelivered unacknowledged
+	visibilityTimeout = 60 * time.Second
+
+	// idleWait is the pause after an empty or failed pull
+	idleWait = 5 * time.Second
+
+	// retryDelay postpones a message whose change failed to apply
+	retryDelay = 30 * time.Second
+)
+
+// R2 notification actions
+const (
+	ActionPut               = "PutObject"
+	ActionCopy              = "CopyObject"
+	ActionCompleteMultipart = "CompleteMultipartUpload"
+	ActionDelete            = "DeleteObject"
+	ActionLifecycleDeletion = "LifecycleDeletion"
+)
+
+// Notification is the message R2 sends to a queue for a change to an
+// object
+type Notification struct {
+	Account string `json:"account"`
+	Bucket  string `json:"bucket"`
+	Action  string `json:"action"`
+	Object  struct {
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+		ETag string `json:"eTag"`
+	} `json:"object"`
+	EventTime time.Time `json:"eventTime"`
+}
+
+// Deleted reports whether the notification is for a removed object
+func (n Notification) Deleted() bool {
+	return n.Action == ActionDelete || n.Action == ActionLifecycleDeletion
+}
+
+// Config locates the queue R2 sends the bucket's notifications to
+type Config struct {
+	AccountID string
+	APIToken  string
+	QueueID   string
+	// Bucket filters out notifications for other buckets sharing the queue
+	Bucket string
+	// APIBase overrides the Cloudflare API endpoint, for tests
+	APIBase string
+}
+
+// Apply handles one notification. An error has the message redelivered
+// later.
+type Apply func(ctx context.Context, n Notification) error
+
+// Consumer pulls notifications from a queue with the HTTP pull API and
+// acknowledges each once it has been applied
+type Consumer struct {
+	cfg   Config
+	apply Apply
+	http  *http.Client
+}
+
+// NewConsumer returns a consumer for cfg, or nil if no queue is set
+func NewConsumer(cfg Config, apply Apply) *Consumer {
+	if cfg.QueueID == "" {
+		return nil
+	}
+	if cfg.APIBase == "" {
+		cfg.APIBase = "https://api.cloudflare.com/client/v4"
+	}
+	return &Consumer{cfg: cfg, apply: apply, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Run consumes notifications until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		n, err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Bucket events: %v", err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+		select {
+		case <-time.After(idleWait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type message struct {
+	ID      string          `json:"id"`
+	Body    json.RawMessage `json:"body"`
+	LeaseID string          `json:"lease_id"`
+}
+
+type lease struct {
+	LeaseID      string `json:"lease_id"`
+	DelaySeconds int    `json:"delay_seconds,omitempty"`
+}
+
+// consume pulls and applies one batch, returning its size
+func (c *Consumer) consume(ctx context.Context) (int, error) {
+	var pulled struct {
+		Messages []message `json:"messages"`
+	}
+	err := c.call(ctx, "/pull", map[string]int{
+		"batch_size":            batchSize,
+		"visibility_timeout_ms": int(visibilityTimeout / time.Millisecond),
+	}, &pulled)
+	if err != nil || len(pulled.Messages) == 0 {
+		return 0, err
+	}
+
+	acks, retries := []lease{}, []lease{}
+	for _, m := range pulled.Messages {
+		n, err := decode(m.Body)
+		if err != nil {
+			// Redelivery won't make it parse
+			log.Printf("Bucket events: dropping message %s: %v", m.ID, err)
+			acks = append(acks, lease{LeaseID: m.LeaseID})
+			continue
+		}
+		if c.cfg.Bucket == "" || n.Bucket == "" || n.Bucket == c.cfg.Bucket {
+			if err := c.apply(ctx, n); err != nil {
+				log.Printf("Bucket events: %s %s: %v", n.Action, n.Object.Key, err)
+				retries = append(retries, lease{LeaseID: m.LeaseID, DelaySeconds: int(retryDelay / time.Second)})
+				continue
+			}
+		}
+		acks = append(acks, lease{LeaseID: m.LeaseID})
+	}
+
+	return len(pulled.Messages), c.call(ctx, "/ack", map[string][]lease{"acks": acks, "retries": retries}, nil)
+}
+
+// decode parses a message body, which the pull API returns as the JSON
+// notification or as a string holding it, plain or base64-encoded
+func decode(body json.RawMessage) (Notification, error) {
+	var n Notification
+	raw := []byte(body)
+	var s string
+	if json.Unmarshal(body, &s) == nil {
+		raw = []byte(s)
+		if !strings.HasPrefix(strings.TrimSpace(s), "{") {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return n, fmt.Errorf("body is neither JSON nor base64")
+			}
+			raw = b
+		}
+	}
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return n, err
+	}
+	if n.Object.Key == "" || n.Action == "" {
+		return n, fmt.Errorf("not an R2 event notification")
+	}
+	return n, nil
+}
+
+type apiResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// call posts to the queue's messages endpoint and decodes the result
+// into out, if set
+func (c *Consumer) call(ctx context.Context, op string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/accounts/%s/queues/%s/messages%s", c.cfg.APIBase, c.cfg.AccountID, c.cfg.QueueID, op)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.cfg.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var ar apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return fmt.Errorf("queue %s: status %d", op, resp.StatusCode)
+	}
+	if !ar.Success || resp.StatusCode >= 300 {
+		msg := "unknown error"
+		if len(ar.Errors) > 0 {
+			msg = fmt.Sprintf("%s (code %d)", ar.Errors[0].Message, ar.Errors[0].Code)
+		}
+		return fmt.Errorf("queue %s: status %d: %s", op, resp.StatusCode, msg)
+	}
+	if out != nil && len(ar.Result) > 0 {
+		return json.Unmarshal(ar.Result, out)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package bucketevents
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const putNotification = `{"account":"acct","bucket":"media","action":"PutObject","object":{"key":"assets/a.png","size":3,"eTag":"abc"},"eventTime":"2024-05-24T19:36:44.379Z"}`
+
+func TestDecode(t *testing.T) {
+	quoted, _ := json.Marshal(putNotification)
+	encoded, _ := json.Marshal(base64.StdEncoding.EncodeToString([]byte(putNotification)))
+	for name, body := range map[string]string{"object": putNotification, "string": string(quoted), "base64": string(encoded)} {
+		n, err := decode(json.RawMessage(body))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if n.Action != ActionPut || n.Object.Key != "assets/a.png" || n.Object.Size != 3 || n.Deleted() {
+			t.Errorf("%s: decoded %+v", name, n)
+		}
+	}
+
+	for _, body := range []string{`{"hello":"world"}`, `"not base64!"`, `[1]`} {
+		if _, err := decode(json.RawMessage(body)); err == nil {
+			t.Errorf("decode(%s) should fail", body)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	otherBucket := `{"bucket":"other","action":"DeleteObject","object":{"key":"x"}}`
+	failing := `{"bucket":"media","action":"DeleteObject","object":{"key":"fail"}}`
+
+	var acked struct {
+		Acks    []lease `json:"acks"`
+		Retries []lease `json:"retries"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/acct/queues/q1/messages/pull":
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": map[string]interface{}{
+				"messages": []map[string]interface{}{
+					{"id": "1", "lease_id": "l1", "body": json.RawMessage(putNotification)},
+					{"id": "2", "lease_id": "l2", "body": json.RawMessage(otherBucket)},
+					{"id": "3", "lease_id": "l3", "body": json.RawMessage(failing)},
+					{"id": "4", "lease_id": "l4", "body": "garbage"},
+				},
+			}})
+		case "/accounts/acct/queues/q1/messages/ack":
+			json.NewDecoder(r.Body).Decode(&acked)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	var applied []string
+	c := NewConsumer(Config{AccountID: "acct", APIToken: "t", QueueID: "q1", Bucket: "media", APIBase: srv.URL},
+		func(ctx context.Context, n Notification) error {
+			applied = append(applied, n.Object.Key)
+			if n.Object.Key == "fail" {
+				return errors.New("boom")
+			}
+			return nil
+		})
+
+	n, err := c.consume(context.Background())
+	if err != nil || n != 4 {
+		t.Fatalf("consume = %d, %v", n, err)
+	}
+	if len(applied) != 2 || applied[0] != "assets/a.png" || applied[1] != "fail" {
+		t.Errorf("applied %v, want the put and the failing delete", applied)
+	}
+
+	ids := func(ls []lease) (s []string) {
+		for _, l := range ls {
+			s = append(s, l.LeaseID)
+		}
+		return s
+	}
+	if got := ids(acked.Acks); len(got) != 3 || got[0] != "l1" || got[1] != "l2" || got[2] != "l4" {
+		t.Errorf("acked %v, want l1 l2 l4", got)
+	}
+	if got := ids(acked.Retries); len(got) != 1 || got[0] != "l3" {
+		t.Errorf("retried %v, want l3", got)
+	}
+}
+
+func TestNewConsumerDisabled(t *testing.T) {
+	if NewConsumer(Config{}, nil) != nil {
+		t.Error("NewConsumer without a queue should return nil")
+	}
+}
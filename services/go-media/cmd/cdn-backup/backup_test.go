@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/s3fake"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// fakeIndex serves the admin index endpoints from a map
+type fakeIndex struct {
+	mu      sync.Mutex
+	entries map[string]index.Entry
+}
+
+func (f *fakeIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path != "/v1/admin/index" || r.Header.Get("Authorization") != "Bearer admin" {
+		http.NotFound(w, r)
+		return
+	}
+	var snap handlers.IndexSnapshot
+	switch r.Method {
+	case http.MethodGet:
+		snap.Entries = []index.Entry{}
+		for key, e := range f.entries {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				snap.Entries = append(snap.Entries, e)
+			}
+		}
+		json.NewEncoder(w).Encode(snap)
+	case http.MethodPost:
+		json.NewDecoder(r.Body).Decode(&snap)
+		for _, e := range snap.Entries {
+			f.entries[e.Key] = e
+		}
+		json.NewEncoder(w).Encode(handlers.IndexImportResponse{Imported: len(snap.Entries)})
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	bucket := s3fake.New("assets")
+	bucketServer := httptest.NewServer(bucket)
+	defer bucketServer.Close()
+	idx := &fakeIndex{entries: map[string]index.Entry{}}
+	api := httptest.NewServer(idx)
+	defer api.Close()
+
+	env := map[string]string{
+		"R2_ACCESS_KEY_ID":     "test",
+		"R2_SECRET_ACCESS_KEY": "test",
+		"R2_BUCKET_NAME":       "assets",
+		"R2_ENDPOINT":          bucketServer.URL,
+		"CDN_SERVER":           api.URL,
+		"CDN_TOKEN":            "admin",
+	}
+	repo := t.TempDir()
+	cdnBackup := func(at time.Time, args ...string) string {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr, func(k string) string { return env[k] }, func() time.Time { return at }); code != 0 {
+			t.Fatalf("cdn-backup %v: exit %d: %s", args, code, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	r2, err := storage.NewR2Client(storage.R2Config{AccessKeyID: "test", SecretAccessKey: "test", BucketName: "assets", Endpoint: bucketServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	put := func(key, content string) {
+		t.Helper()
+		if err := r2.PutObject(ctx, key, strings.NewReader(content), "text/plain", map[string]string{"origin": content}); err != nil {
+			t.Fatal(err)
+		}
+		idx.entries[key] = index.Entry{Key: key, Size: int64(len(content)), ContentType: "text/plain", Text: content}
+	}
+	put("images/a.txt", "first a")
+	put("images/b.txt", "first b")
+	put("docs/c.txt", "first c")
+
+	first := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	if out := cdnBackup(first, "backup", repo); !strings.Contains(out, "3 objects") || !strings.Contains(out, "3 new") {
+		t.Errorf("first backup: %s", out)
+	}
+
+	// The second snapshot only stores what changed
+	put("images/a.txt", "second a")
+	if err := r2.DeleteObject(ctx, "images/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	delete(idx.entries, "images/b.txt")
+	put("docs/c.txt", "second c")
+	second := first.Add(24 * time.Hour)
+	if out := cdnBackup(second, "backup", repo); !strings.Contains(out, "2 objects") || !strings.Contains(out, "2 new") {
+		t.Errorf("second backup: %s", out)
+	}
+	if out := cdnBackup(second, "snapshots", repo); out != "20261001T000000Z\n20261002T000000Z\n" {
+		t.Errorf("snapshots = %q", out)
+	}
+
+	// Back to the first day, under images/ only; the changed a.txt is
+	// left alone without -overwrite
+	at := first.Add(time.Hour).Format(time.RFC3339)
+	if out := cdnBackup(second, "restore", "-prefix", "images/", "-at", at, repo); !strings.Contains(out, "1 restored, 0 unchanged, 1 skipped, 1 index entries") {
+		t.Errorf("restore: %s", out)
+	}
+	if data, _ := bucket.Object("images/b.txt"); string(data) != "first b" {
+		t.Errorf("images/b.txt = %q, want it restored", data)
+	}
+	if data, _ := bucket.Object("images/a.txt"); string(data) != "second a" {
+		t.Errorf("images/a.txt = %q, want it kept", data)
+	}
+
+	if out := cdnBackup(second, "restore", "-prefix", "images/", "-at", at, "-overwrite", repo); !strings.Contains(out, "1 restored, 1 unchanged") {
+		t.Errorf("restore -overwrite: %s", out)
+	}
+	if data, _ := bucket.Object("images/a.txt"); string(data) != "first a" {
+		t.Errorf("images/a.txt = %q, want it overwritten", data)
+	}
+	if data, _ := bucket.Object("docs/c.txt"); string(data) != "second c" {
+		t.Errorf("docs/c.txt = %q, want it outside the restore", data)
+	}
+	head, err := r2.HeadObject(ctx, "images/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Metadata["origin"] != "first b" {
+		t.Errorf("images/b.txt metadata = %v", head.Metadata)
+	}
+
+	// Index entries come back with the restored objects' ETags
+	for key, text := range map[string]string{"images/a.txt": "first a", "images/b.txt": "first b"} {
+		e, ok := idx.entries[key]
+		head, err := r2.HeadObject(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || e.Text != text || `"`+e.ETag+`"` != *head.ETag {
+			t.Errorf("index entry for %s = %+v, object ETag %s", key, e, *head.ETag)
+		}
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	repo := dirRepository(t.TempDir())
+	ctx := context.Background()
+	for _, id := range []string{"20261001T000000Z", "20261002T000000Z"} {
+		if err := repo.put(ctx, snapshotName(id), strings.NewReader(`{"id":"`+id+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id   string
+		at   time.Time
+		want string
+	}{
+		{"", time.Time{}, "20261002T000000Z"},
+		{"", time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC), "20261001T000000Z"},
+		{"", time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC), "20261002T000000Z"},
+		{"", time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC), ""},
+		{"20261001T000000Z", time.Time{}, "20261001T000000Z"},
+	}
+	for _, tt := range tests {
+		snap, err := findSnapshot(ctx, repo, tt.id, tt.at)
+		got := ""
+		if err == nil {
+			got = snap.ID
+		}
+		if got != tt.want {
+			t.Errorf("findSnapshot(%q, %v) = %q, %v; want %q", tt.id, tt.at, got, err, tt.want)
+		}
+	}
+}
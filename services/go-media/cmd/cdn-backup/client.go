@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+// maxImportBytes bounds the JSON of one index import request, below the
+// service's default body limit
+const maxImportBytes = 512 << 10
+
+// client reads and restores the metadata index through the admin API
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) *client {
+	return &client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (c *client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		message := strings.TrimSpace(string(data))
+		var errResp handlers.ErrorResponse
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+			message = errResp.Error
+		}
+		return fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, path, err)
+	}
+	return nil
+}
+
+// exportIndex returns the index entries under prefix
+func (c *client) exportIndex(ctx context.Context, prefix string) ([]index.Entry, error) {
+	var snap handlers.IndexSnapshot
+	err := c.do(ctx, http.MethodGet, "/v1/admin/index?prefix="+url.QueryEscape(prefix), nil, &snap)
+	return snap.Entries, err
+}
+
+// importIndex puts entries back, in requests of at most maxImportBytes
+func (c *client) importIndex(ctx context.Context, entries []index.Entry) error {
+	var (
+		batch []index.Entry
+		size  int
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var resp handlers.IndexImportResponse
+		err := c.do(ctx, http.MethodPost, "/v1/admin/index", handlers.IndexSnapshot{Entries: batch}, &resp)
+		batch, size = nil, 0
+		return err
+	}
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if size+len(data) > maxImportBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, e)
+		size += len(data) + 1
+	}
+	return flush()
+}
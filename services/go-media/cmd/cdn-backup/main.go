@@ -0,0 +1,216 @@
+// Command cdn-backup snapshots the objects of the go-media bucket, with
+// their metadata and index entries, to a local directory or another
+// bucket, and restores them by prefix or point in time:
+//
+//	cdn-backup backup -prefix images/ s3://media-backups/daily
+//	cdn-backup restore -prefix images/ -at 2026-10-01T00:00:00Z s3://media-backups/daily
+//
+// Objects are read from the bucket directly, so sealed objects are saved
+// as stored, still encrypted. Contents already saved by an earlier
+// snapshot aren't copied again.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+const usage = `Usage: cdn-backup [-server URL] [-token TOKEN] <command> [flags] <repository>
+
+Commands:
+  backup [-prefix P] [-parallel N]    save objects under prefix as a new snapshot
+  snapshots                           list snapshots
+  restore [flags]                     put objects back from a snapshot
+
+The repository is a local directory or s3://bucket/prefix. Index entries
+are saved and restored through the admin API when a token is given.
+Run 'cdn-backup <command> -h' for command flags.
+Environment: R2_ACCESS_KEY_ID, R2_SECRET_ACCESS_KEY, R2_BUCKET_NAME,
+R2_ENDPOINT, BACKUP_ACCESS_KEY_ID, BACKUP_SECRET_ACCESS_KEY,
+BACKUP_ENDPOINT, CDN_SERVER, CDN_TOKEN
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.Getenv, time.Now))
+}
+
+func run(args []string, stdout, stderr io.Writer, getenv func(string) string, now func() time.Time) int {
+	global := flag.NewFlagSet("cdn-backup", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() { fmt.Fprint(stderr, usage) }
+	server := global.String("server", envOr(getenv, "CDN_SERVER", "http://localhost:8080"), "go-media base URL")
+	token := global.String("token", getenv("CDN_TOKEN"), "admin token; without one the index is left out")
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var api *client
+	if *token != "" {
+		api = newClient(*server, *token)
+	}
+	cmd, cmdArgs := global.Arg(0), global.Args()[1:]
+	fs := flag.NewFlagSet("cdn-backup "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	// Workers print concurrently
+	var outMu sync.Mutex
+	printf := func(format string, a ...interface{}) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		fmt.Fprintf(stdout, format, a...)
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "cdn-backup %s: %v\n", cmd, err)
+		return 1
+	}
+	failures := func(failed []failure) int {
+		for _, f := range failed {
+			fmt.Fprintf(stderr, "cdn-backup %s: %s: %s\n", cmd, f.Key, f.Error)
+		}
+		if len(failed) > 0 {
+			fmt.Fprintf(stderr, "cdn-backup %s: %d failed\n", cmd, len(failed))
+			return 1
+		}
+		return 0
+	}
+	bucket := func() (*storage.R2Client, error) {
+		return storage.NewR2Client(storage.R2Config{
+			AccessKeyID:     getenv("R2_ACCESS_KEY_ID"),
+			SecretAccessKey: getenv("R2_SECRET_ACCESS_KEY"),
+			BucketName:      getenv("R2_BUCKET_NAME"),
+			Endpoint:        getenv("R2_ENDPOINT"),
+		})
+	}
+
+	switch cmd {
+	case "backup":
+		opts := backupOptions{now: now()}
+		fs.StringVar(&opts.prefix, "prefix", "", "only save keys with this prefix")
+		fs.IntVar(&opts.parallel, "parallel", 8, "objects saved at once")
+		if !parseArgs(fs, cmdArgs, "<repository>", exactlyOne) {
+			return 2
+		}
+		repo, err := openRepository(fs.Arg(0), getenv)
+		if err != nil {
+			return fail(err)
+		}
+		source, err := bucket()
+		if err != nil {
+			return fail(err)
+		}
+		result, err := backup(ctx, source, getenv("R2_BUCKET_NAME"), repo, api, opts)
+		if err != nil {
+			return fail(err)
+		}
+		snap := result.Snapshot
+		fmt.Fprintf(stdout, "snapshot %s: %d objects, %d bytes, %d new, %d index entries\n", snap.ID, len(snap.Objects), result.Bytes, result.New, len(snap.Index))
+		return failures(snap.Failed)
+
+	case "snapshots":
+		if !parseArgs(fs, cmdArgs, "<repository>", exactlyOne) {
+			return 2
+		}
+		repo, err := openRepository(fs.Arg(0), getenv)
+		if err != nil {
+			return fail(err)
+		}
+		ids, err := snapshotIDs(ctx, repo)
+		if err != nil {
+			return fail(err)
+		}
+		for _, id := range ids {
+			fmt.Fprintln(stdout, id)
+		}
+		return 0
+
+	case "restore":
+		opts := restoreOptions{printf: printf}
+		fs.StringVar(&opts.prefix, "prefix", "", "only restore keys with this prefix")
+		at := fs.String("at", "", "restore the last snapshot taken at or before this RFC 3339 time")
+		id := fs.String("snapshot", "", "restore this snapshot (default the latest)")
+		fs.BoolVar(&opts.overwrite, "overwrite", false, "replace objects changed since the snapshot")
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "report what would be restored")
+		fs.IntVar(&opts.parallel, "parallel", 8, "objects restored at once")
+		if !parseArgs(fs, cmdArgs, "<repository>", exactlyOne) {
+			return 2
+		}
+		var when time.Time
+		if *at != "" {
+			var err error
+			if when, err = time.Parse(time.RFC3339, *at); err != nil {
+				return fail(fmt.Errorf("-at: %w", err))
+			}
+		}
+		repo, err := openRepository(fs.Arg(0), getenv)
+		if err != nil {
+			return fail(err)
+		}
+		snap, err := findSnapshot(ctx, repo, *id, when)
+		if err != nil {
+			return fail(err)
+		}
+		target, err := bucket()
+		if err != nil {
+			return fail(err)
+		}
+		result, err := restore(ctx, target, repo, api, snap, opts)
+		if err != nil {
+			return fail(err)
+		}
+		for _, key := range result.Skipped {
+			printf("skipped %s: changed since the snapshot\n", key)
+		}
+		fmt.Fprintf(stdout, "snapshot %s: %d restored, %d unchanged, %d skipped, %d index entries\n", snap.ID, result.Restored, result.Unchanged, len(result.Skipped), result.Indexed)
+		if api == nil && !opts.dryRun && result.Restored > 0 {
+			fmt.Fprintln(stdout, "no token given: reconcile the index (POST /v1/admin/reconcile) to index the restored objects")
+		}
+		return failures(result.Failed)
+
+	default:
+		fmt.Fprintf(stderr, "cdn-backup: unknown command %q\n\n", cmd)
+		global.Usage()
+		return 2
+	}
+}
+
+// parseArgs parses command flags and checks the positional argument count,
+// printing usage on failure
+func parseArgs(fs *flag.FlagSet, args []string, argsUsage string, validCount func(n int) bool) bool {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s\n", fs.Name(), argsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if !validCount(fs.NArg()) {
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+func exactlyOne(n int) bool { return n == 1 }
+
+func envOr(getenv func(string) string, key, fallback string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// repository holds snapshots and the object contents they refer to,
+// under slash-separated names
+type repository interface {
+	put(ctx context.Context, name string, body io.Reader) error
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	exists(ctx context.Context, name string) (bool, error)
+	// list returns the names under prefix, sorted
+	list(ctx context.Context, prefix string) ([]string, error)
+}
+
+// openRepository opens spec, a local directory or s3://bucket/prefix.
+// A bucket is reached with the BACKUP_ settings, each defaulting to the
+// R2_ setting of the bucket backed up.
+func openRepository(spec string, getenv func(string) string) (repository, error) {
+	rest, ok := strings.CutPrefix(spec, "s3://")
+	if !ok {
+		return dirRepository(spec), nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("repository %q names no bucket", spec)
+	}
+	setting := func(name string) string {
+		if v := getenv("BACKUP_" + name); v != "" {
+			return v
+		}
+		return getenv("R2_" + name)
+	}
+	client, err := storage.NewR2Client(storage.R2Config{
+		AccessKeyID:     setting("ACCESS_KEY_ID"),
+		SecretAccessKey: setting("SECRET_ACCESS_KEY"),
+		BucketName:      bucket,
+		Endpoint:        setting("ENDPOINT"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return &bucketRepository{client: client, uploader: storage.NewUploader(client, partSize, 4), prefix: prefix}, nil
+}
+
+// partSize is the part size of multipart uploads to buckets
+const partSize = 16 << 20
+
+// dirRepository is a local directory
+type dirRepository string
+
+func (d dirRepository) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+// put writes name through a temporary file, so an interrupted backup
+// leaves no partial file under the name
+func (d dirRepository) put(ctx context.Context, name string, body io.Reader) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d dirRepository) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+func (d dirRepository) exists(ctx context.Context, name string) (bool, error) {
+	_, err := os.Stat(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d dirRepository) list(ctx context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(d.path(prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".tmp-") {
+			names = append(names, strings.TrimSuffix(prefix, "/")+"/"+e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// bucketRepository is a prefix of a bucket
+type bucketRepository struct {
+	client   *storage.R2Client
+	uploader *storage.Uploader
+	prefix   string
+}
+
+func (b *bucketRepository) put(ctx context.Context, name string, body io.Reader) error {
+	_, err := b.uploader.Upload(ctx, b.prefix+name, body, "application/octet-stream", nil)
+	return err
+}
+
+func (b *bucketRepository) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := b.client.GetObject(ctx, b.prefix+name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (b *bucketRepository) exists(ctx context.Context, name string) (bool, error) {
+	_, err := b.client.HeadObject(ctx, b.prefix+name)
+	if storage.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *bucketRepository) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	opts := storage.ListOptions{Prefix: b.prefix + prefix, MaxKeys: 1000}
+	for {
+		page, err := b.client.ListObjectsPage(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Objects {
+			names = append(names, strings.TrimPrefix(obj.Key, b.prefix))
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// snapshotIDFormat names snapshots by when they were taken, so their
+// names sort in time order
+const snapshotIDFormat = "20060102T150405Z"
+
+// snapshot is the objects under a prefix of the bucket at one time, with
+// their metadata and index entries. Contents are stored apart, once for
+// all the snapshots holding the same version of an object.
+type snapshot struct {
+	ID        string           `json:"id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Bucket    string           `json:"bucket"`
+	Prefix    string           `json:"prefix,omitempty"`
+	Objects   []snapshotObject `json:"objects"`
+	// Index holds the objects' metadata index entries, when the service
+	// was asked for them
+	Index []index.Entry `json:"index,omitempty"`
+	// Failed are objects that couldn't be saved, such as those stored
+	// with a client-supplied key
+	Failed []failure `json:"failed,omitempty"`
+}
+
+// snapshotObject is an object as stored: its raw content, still
+// encrypted if it is sealed, and its metadata
+type snapshotObject struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"last_modified"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	// Blob names the content in the repository
+	Blob string `json:"blob"`
+}
+
+type failure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+func snapshotName(id string) string {
+	return "snapshots/" + id + ".json"
+}
+
+// blobName names the content of one version of an object
+func blobName(key, etag string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + etag))
+	return "objects/" + hex.EncodeToString(sum[:])
+}
+
+// errGone is an object deleted after the listing that found it
+var errGone = errors.New("deleted during the backup")
+
+type backupOptions struct {
+	prefix   string
+	parallel int
+	now      time.Time
+}
+
+// backupResult counts the objects a backup saved, and those of them
+// whose content wasn't in the repository already
+type backupResult struct {
+	Snapshot *snapshot
+	Bytes    int64
+	New      int
+}
+
+// backup saves the objects under opts.prefix of the bucket, and their
+// index entries if api is set, to repo as a new snapshot
+func backup(ctx context.Context, bucket *storage.R2Client, bucketName string, repo repository, api *client, opts backupOptions) (*backupResult, error) {
+	snap := &snapshot{ID: opts.now.UTC().Format(snapshotIDFormat), CreatedAt: opts.now.UTC(), Bucket: bucketName, Prefix: opts.prefix}
+	if ok, err := repo.exists(ctx, snapshotName(snap.ID)); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("snapshot %s already exists", snap.ID)
+	}
+
+	var listed []storage.Object
+	listing := storage.ListOptions{Prefix: opts.prefix, MaxKeys: 1000}
+	for {
+		page, err := bucket.ListObjectsPage(ctx, listing)
+		if err != nil {
+			return nil, fmt.Errorf("listing the bucket: %w", err)
+		}
+		listed = append(listed, page.Objects...)
+		if !page.IsTruncated {
+			break
+		}
+		listing.ContinuationToken = page.NextContinuationToken
+	}
+
+	// The index first: entries for objects changed in the meantime are
+	// corrected when restored
+	if api != nil {
+		entries, err := api.exportIndex(ctx, opts.prefix)
+		if err != nil {
+			return nil, fmt.Errorf("reading the index: %w", err)
+		}
+		snap.Index = entries
+	}
+
+	result := &backupResult{Snapshot: snap}
+	saved := make([]*snapshotObject, len(listed))
+	var mu sync.Mutex
+	forEach(ctx, opts.parallel, len(listed), func(ctx context.Context, i int) {
+		o, stored, err := saveObject(ctx, bucket, repo, listed[i].Key)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case errors.Is(err, errGone):
+		case err != nil:
+			snap.Failed = append(snap.Failed, failure{Key: listed[i].Key, Error: err.Error()})
+		default:
+			saved[i] = o
+			result.Bytes += o.Size
+			if stored {
+				result.New++
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	snap.Objects = make([]snapshotObject, 0, len(saved))
+	for _, o := range saved {
+		if o != nil {
+			snap.Objects = append(snap.Objects, *o)
+		}
+	}
+	sort.Slice(snap.Failed, func(i, j int) bool { return snap.Failed[i].Key < snap.Failed[j].Key })
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	// Written last, so a snapshot is only listed once all it refers to
+	// is stored
+	if err := repo.put(ctx, snapshotName(snap.ID), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// saveObject records key and stores its content, unless an earlier
+// snapshot stored it. stored reports whether it did.
+func saveObject(ctx context.Context, bucket *storage.R2Client, repo repository, key string) (o *snapshotObject, stored bool, err error) {
+	head, err := bucket.HeadObject(ctx, key)
+	if storage.IsNotFound(err) {
+		return nil, false, errGone
+	} else if err != nil {
+		return nil, false, err
+	}
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	o = &snapshotObject{
+		Key:          key,
+		Size:         aws.ToInt64(head.ContentLength),
+		ETag:         etag,
+		LastModified: aws.ToTime(head.LastModified),
+		ContentType:  aws.ToString(head.ContentType),
+		Metadata:     head.Metadata,
+		Blob:         blobName(key, etag),
+	}
+	if ok, err := repo.exists(ctx, o.Blob); err != nil || ok {
+		return o, false, err
+	}
+
+	obj, err := bucket.GetObject(ctx, key)
+	if storage.IsNotFound(err) {
+		return nil, false, errGone
+	} else if err != nil {
+		return nil, false, err
+	}
+	defer obj.Body.Close()
+	if strings.Trim(aws.ToString(obj.ETag), `"`) != etag {
+		return nil, false, errors.New("changed during the backup")
+	}
+	if err := repo.put(ctx, o.Blob, obj.Body); err != nil {
+		return nil, false, err
+	}
+	return o, true, nil
+}
+
+// snapshotIDs lists the repository's snapshots, oldest first
+func snapshotIDs(ctx context.Context, repo repository) ([]string, error) {
+	names, err := repo.list(ctx, "snapshots/")
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, name := range names {
+		id := strings.TrimSuffix(strings.TrimPrefix(name, "snapshots/"), ".json")
+		if _, err := time.Parse(snapshotIDFormat, id); err == nil && strings.HasSuffix(name, ".json") {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// findSnapshot loads snapshot id or, without one, the last taken at or
+// before at; a zero at means the latest
+func findSnapshot(ctx context.Context, repo repository, id string, at time.Time) (*snapshot, error) {
+	if id == "" {
+		ids, err := snapshotIDs(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range ids {
+			taken, _ := time.Parse(snapshotIDFormat, candidate)
+			if at.IsZero() || !taken.After(at) {
+				id = candidate
+			}
+		}
+		switch {
+		case id == "" && at.IsZero():
+			return nil, errors.New("the repository has no snapshots")
+		case id == "":
+			return nil, fmt.Errorf("no snapshot was taken at or before %s", at.UTC().Format(time.RFC3339))
+		}
+	}
+
+	r, err := repo.open(ctx, snapshotName(id))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	defer r.Close()
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+type restoreOptions struct {
+	prefix    string
+	overwrite bool
+	dryRun    bool
+	parallel  int
+	printf    func(format string, a ...interface{})
+}
+
+type restoreResult struct {
+	Restored  int
+	Unchanged int
+	// Skipped have changed since the snapshot and weren't overwritten
+	Skipped []string
+	Failed  []failure
+	// Indexed counts the index entries put back
+	Indexed int
+}
+
+// restore puts the snapshot's objects under opts.prefix back in the
+// bucket, and their index entries if api is set. Objects as they were in
+// the snapshot are left alone, as are objects stored since it was taken.
+func restore(ctx context.Context, bucket *storage.R2Client, repo repository, api *client, snap *snapshot, opts restoreOptions) (*restoreResult, error) {
+	var objects []snapshotObject
+	for _, o := range snap.Objects {
+		if strings.HasPrefix(o.Key, opts.prefix) {
+			objects = append(objects, o)
+		}
+	}
+
+	result := &restoreResult{}
+	uploader := storage.NewUploader(bucket, partSize, 1)
+	// etags are those of the objects now in the bucket as the snapshot
+	// had them, for their index entries
+	etags := map[string]string{}
+	var mu sync.Mutex
+	forEach(ctx, opts.parallel, len(objects), func(ctx context.Context, i int) {
+		o := objects[i]
+		etag, restored, err := restoreObject(ctx, bucket, uploader, repo, o, opts)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case errors.Is(err, errChanged):
+			result.Skipped = append(result.Skipped, o.Key)
+		case err != nil:
+			result.Failed = append(result.Failed, failure{Key: o.Key, Error: err.Error()})
+		case restored:
+			result.Restored++
+			etags[o.Key] = etag
+		default:
+			result.Unchanged++
+			etags[o.Key] = etag
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(result.Skipped)
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Key < result.Failed[j].Key })
+
+	if api == nil || opts.dryRun {
+		return result, nil
+	}
+	var entries []index.Entry
+	for _, e := range snap.Index {
+		if etag, ok := etags[e.Key]; ok {
+			e.ETag = etag
+			e.Orphaned = false
+			entries = append(entries, e)
+		}
+	}
+	if err := api.importIndex(ctx, entries); err != nil {
+		return result, fmt.Errorf("restoring the index: %w", err)
+	}
+	result.Indexed = len(entries)
+	return result, nil
+}
+
+// errChanged is an object changed since the snapshot, left as it is
+var errChanged = errors.New("changed since the snapshot")
+
+// restoreObject writes o back unless the bucket already has it, and
+// returns the ETag it has there. restored reports whether it was written.
+func restoreObject(ctx context.Context, bucket *storage.R2Client, uploader *storage.Uploader, repo repository, o snapshotObject, opts restoreOptions) (etag string, restored bool, err error) {
+	head, err := bucket.HeadObject(ctx, o.Key)
+	switch {
+	case err == nil && strings.Trim(aws.ToString(head.ETag), `"`) == o.ETag:
+		return o.ETag, false, nil
+	case err == nil && !opts.overwrite:
+		return "", false, errChanged
+	case err != nil && !storage.IsNotFound(err):
+		return "", false, err
+	}
+	if opts.dryRun {
+		opts.printf("would restore %s\n", o.Key)
+		return "", true, nil
+	}
+
+	body, err := repo.open(ctx, o.Blob)
+	if err != nil {
+		return "", false, err
+	}
+	defer body.Close()
+	res, err := uploader.Upload(ctx, o.Key, body, o.ContentType, o.Metadata)
+	if err != nil {
+		return "", false, err
+	}
+	// A single-part ETag is the content's MD5, which the copy must match
+	if !strings.Contains(o.ETag, "-") && res.Parts == 1 && res.ETag != o.ETag {
+		return "", false, fmt.Errorf("restored content doesn't match ETag %s; the backup is damaged", o.ETag)
+	}
+	opts.printf("restored %s\n", o.Key)
+	return res.ETag, true, nil
+}
+
+// forEach calls fn for 0 to count-1, n at a time, until ctx is done
+func forEach(ctx context.Context, n, count int, fn func(ctx context.Context, i int)) {
+	if n < 1 {
+		n = 1
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fn(ctx, i)
+			}
+		}()
+	}
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+)
+
+// The kinds of request a run mixes
+const (
+	kindUpload = "upload"
+	kindRange  = "range"
+	kindSigned = "signed"
+)
+
+var kinds = []string{kindUpload, kindRange, kindSigned}
+
+type options struct {
+	server      string
+	token       string
+	duration    time.Duration
+	concurrency int
+	mix         []weighted
+	object      string
+	objectSize  int64
+	uploadSize  int64
+	rangeSize   int64
+	keep        bool
+}
+
+// weighted is a kind of request and how often it is picked relative to
+// the others
+type weighted struct {
+	kind   string
+	weight int
+}
+
+// parseMix parses weights such as "upload=1,range=6,signed=3". Kinds
+// left out aren't sent.
+func parseMix(s string) ([]weighted, error) {
+	var mix []weighted
+	total := 0
+	for _, field := range strings.Split(s, ",") {
+		kind, w, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not kind=weight", field)
+		}
+		known := false
+		for _, k := range kinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown kind %q, want one of %s", kind, strings.Join(kinds, ", "))
+		}
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight of %s must be a non-negative integer", kind)
+		}
+		if weight > 0 {
+			mix = append(mix, weighted{kind, weight})
+			total += weight
+		}
+	}
+	if total == 0 {
+		return nil, errors.New("no kind has a positive weight")
+	}
+	return mix, nil
+}
+
+// pick returns a kind at random by weight
+func pick(mix []weighted, rnd *rand.Rand) string {
+	total := 0
+	for _, m := range mix {
+		total += m.weight
+	}
+	n := rnd.Intn(total)
+	for _, m := range mix {
+		if n < m.weight {
+			return m.kind
+		}
+		n -= m.weight
+	}
+	return mix[len(mix)-1].kind
+}
+
+func includes(mix []weighted, kind string) bool {
+	for _, m := range mix {
+		if m.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// client sends requests to a go-media instance
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Every worker keeps its connection
+	transport.MaxIdleConnsPerHost = 1024
+	// Sizes are measured as served
+	transport.DisableCompression = true
+	return &client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Transport: transport, Timeout: 5 * time.Minute},
+	}
+}
+
+// result is the outcome of one request
+type result struct {
+	latency time.Duration
+	bytes   int64
+	// cause is why the request failed, empty if it didn't
+	cause string
+}
+
+// send makes a request, reading the response to the end, and judges it
+// by whether it answered with want. into, if not nil, is decoded from
+// the body in place of counting it.
+func (c *client) send(ctx context.Context, method, path string, header http.Header, body io.Reader, want int, into interface{}) result {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return result{cause: err.Error()}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), cause: transportCause(err)}
+	}
+	defer resp.Body.Close()
+	var n int64
+	if into != nil && resp.StatusCode == want {
+		err = json.NewDecoder(resp.Body).Decode(into)
+	} else {
+		n, err = io.Copy(io.Discard, resp.Body)
+	}
+	r := result{latency: time.Since(start), bytes: n}
+	switch {
+	case resp.StatusCode != want:
+		r.cause = strconv.Itoa(resp.StatusCode)
+	case err != nil:
+		r.cause = transportCause(err)
+	}
+	return r
+}
+
+// transportCause names a failure to get a response, briefly enough to
+// group by
+func transportCause(err error) string {
+	var netErr interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "connection"
+	}
+}
+
+// upload stores data as a new object named filename
+func (c *client) upload(ctx context.Context, filename string, data []byte, resp *handlers.UploadResponse) result {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", filename)
+	part.Write(data)
+	mw.Close()
+	header := http.Header{"Content-Type": {mw.FormDataContentType()}}
+	r := c.send(ctx, http.MethodPost, "/v1/media/upload", header, &body, http.StatusOK, resp)
+	r.bytes = int64(len(data))
+	return r
+}
+
+// target is the object a run reads
+type target struct {
+	key  string
+	size int64
+	// signed is the path and query of a signed URL for key
+	signed string
+}
+
+// rangeHeader asks for a random range of at most size bytes of t
+func (t *target) rangeHeader(size int64, rnd *rand.Rand) http.Header {
+	size = min(size, t.size)
+	start := rnd.Int63n(t.size - size + 1)
+	return http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, start+size-1)}}
+}
+
+// prepare finds, or uploads, the object the run reads, and signs a URL
+// for it that lasts the run. It returns the keys it uploaded.
+func prepare(ctx context.Context, c *client, opts options, t *target) ([]string, error) {
+	var uploaded []string
+	t.key = opts.object
+	if t.key == "" {
+		data := make([]byte, opts.objectSize)
+		rand.Read(data)
+		var resp handlers.UploadResponse
+		if r := c.upload(ctx, "loadtest.zip", data, &resp); r.cause != "" {
+			return nil, fmt.Errorf("uploading the object to read: %s", r.cause)
+		}
+		t.key = resp.Key
+		uploaded = append(uploaded, t.key)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, c.baseURL+"/v1/media/assets/"+escapeKey(t.key), nil)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return uploaded, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 1 {
+		return uploaded, fmt.Errorf("HEAD %s: %s, %d bytes", t.key, resp.Status, resp.ContentLength)
+	}
+	t.size = resp.ContentLength
+
+	if includes(opts.mix, kindSigned) {
+		sign, _ := json.Marshal(handlers.SignedURLRequest{Path: t.key, ExpiresIn: int64((opts.duration + 10*time.Minute).Seconds())})
+		var signed handlers.SignedURLResponse
+		r := c.send(ctx, http.MethodPost, "/v1/media/sign", http.Header{"Content-Type": {"application/json"}}, bytes.NewReader(sign), http.StatusOK, &signed)
+		if r.cause != "" {
+			return uploaded, fmt.Errorf("signing a URL for %s: %s", t.key, r.cause)
+		}
+		// Fetched from the server under test, whatever host the URL is on
+		u, err := url.Parse(signed.URL)
+		if err != nil {
+			return uploaded, fmt.Errorf("signed URL %q: %w", signed.URL, err)
+		}
+		t.signed = u.RequestURI()
+	}
+	return uploaded, nil
+}
+
+// loadTest runs the load opts describes and reports on it. Progress goes
+// to logw.
+func loadTest(ctx context.Context, c *client, opts options, logw io.Writer) (*report, error) {
+	var t target
+	uploaded, err := prepare(ctx, c, opts, &t)
+	var mu sync.Mutex
+	if !opts.keep {
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			cleanup(c, uploaded, logw)
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(logw, "Reading %s (%d bytes) with %d workers for %s\n", t.key, t.size, opts.concurrency, opts.duration)
+	rec := newRecorder()
+	start := time.Now()
+	deadline := start.Add(opts.duration)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			data := make([]byte, opts.uploadSize)
+			// In-flight requests finish after the deadline; none start
+			for ctx.Err() == nil && time.Now().Before(deadline) {
+				kind := pick(opts.mix, rnd)
+				var r result
+				switch kind {
+				case kindUpload:
+					rnd.Read(data)
+					var resp handlers.UploadResponse
+					r = c.upload(ctx, "loadtest.zip", data, &resp)
+					if resp.Key != "" {
+						mu.Lock()
+						uploaded = append(uploaded, resp.Key)
+						mu.Unlock()
+					}
+				case kindRange:
+					r = c.send(ctx, http.MethodGet, "/v1/media/assets/"+escapeKey(t.key), t.rangeHeader(opts.rangeSize, rnd), nil, http.StatusPartialContent, nil)
+				case kindSigned:
+					r = c.send(ctx, http.MethodGet, t.signed, t.rangeHeader(opts.rangeSize, rnd), nil, http.StatusPartialContent, nil)
+				}
+				// Requests cut short by an interrupt say nothing about the server
+				if ctx.Err() == nil {
+					rec.record(kind, r)
+				}
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+	return rec.report(time.Since(start)), nil
+}
+
+// cleanup deletes the objects a run uploaded, even after an interrupt
+func cleanup(c *client, keys []string, logw io.Writer) {
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	failed := 0
+	for _, key := range keys {
+		if r := c.send(ctx, http.MethodDelete, "/v1/media/delete/"+escapeKey(key), nil, nil, http.StatusOK, nil); r.cause != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(logw, "Failed to delete %d of %d uploaded objects\n", failed, len(keys))
+	}
+}
+
+// escapeKey escapes each segment of an object key for use in a URL path
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
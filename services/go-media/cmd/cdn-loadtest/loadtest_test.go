@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("upload=1, range=0,signed=3")
+	if err != nil || len(mix) != 2 || mix[0] != (weighted{kindUpload, 1}) || mix[1] != (weighted{kindSigned, 3}) {
+		t.Errorf("parseMix() = %v, %v", mix, err)
+	}
+	for _, bad := range []string{"", "range", "range=-1", "delete=1", "upload=0"} {
+		if _, err := parseMix(bad); err == nil {
+			t.Errorf("parseMix(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 200; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 100 * time.Millisecond}, {95, 190 * time.Millisecond}, {99, 198 * time.Millisecond}, {0, time.Millisecond}} {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%g) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+}
+
+// fakeServer stores uploads by content key, and fails every signed fetch
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/media/upload":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		sum := sha256.Sum256(data)
+		key := "assets/" + hex.EncodeToString(sum[:8]) + ".zip"
+		f.objects[key] = data
+		json.NewEncoder(w).Encode(handlers.UploadResponse{Key: key})
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/media/sign":
+		var req handlers.SignedURLRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(handlers.SignedURLResponse{URL: "https://cdn.example.test/v1/media/private/" + req.Path + "?sig=x"})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/media/delete/"):
+		delete(f.objects, strings.TrimPrefix(r.URL.Path, "/v1/media/delete/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/media/assets/"):
+		data, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/v1/media/assets/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	default:
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fake := &fakeServer{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-server", srv.URL, "-duration", "200ms", "-concurrency", "4", "-object-size", "4096", "-upload-size", "512", "-range-size", "1000", "-json"}
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d: %s", code, stderr.String())
+	}
+	var rep report
+	if err := json.Unmarshal(stdout.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	byKind := map[string]kindReport{}
+	for _, k := range rep.Kinds {
+		byKind[k.Kind] = k
+	}
+	if k := byKind[kindRange]; k.Requests == 0 || k.Errors != 0 || k.Bytes != int64(k.Requests)*1000 {
+		t.Errorf("range = %+v", k)
+	}
+	if k := byKind[kindUpload]; k.Requests == 0 || k.Errors != 0 {
+		t.Errorf("upload = %+v", k)
+	}
+	// The fake serves no private paths
+	if k := byKind[kindSigned]; k.Requests == 0 || k.Errors != k.Requests || k.Causes["503"] != k.Requests {
+		t.Errorf("signed = %+v", k)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("%d uploaded objects left behind", len(fake.objects))
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	args = []string{"-server", srv.URL, "-duration", "100ms", "-object-size", "4096", "-mix", "range=1,signed=1", "-max-p99", "1ns", "-max-errors", "10"}
+	if code := run(args, &stdout, &stderr); code != 1 {
+		t.Errorf("run() over budget = %d", code)
+	}
+	for _, want := range []string{"over budget: range p99", "over budget: signed errors 100.00% > 10%"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr lacks %q:\n%s", want, stderr.String())
+		}
+	}
+	if !strings.Contains(stdout.String(), "signed errors: 503×") {
+		t.Errorf("report lacks the error causes:\n%s", stdout.String())
+	}
+}
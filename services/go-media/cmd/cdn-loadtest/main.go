@@ -0,0 +1,112 @@
+// Command cdn-loadtest drives a mix of uploads, ranged reads and signed
+// URL fetches against a running go-media instance and reports latency
+// percentiles and error rates per kind of request. With budgets set it
+// exits non-zero when any is exceeded, so CI can gate deploys on it:
+//
+//	cdn-loadtest -server https://staging.mikeodnis.dev -duration 1m -max-p99 800ms -max-errors 0.5
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: cdn-loadtest [flags]
+
+Runs -concurrency workers for -duration, each sending requests picked by
+the weights in -mix:
+
+  upload   POST /v1/media/upload of -upload-size random bytes
+  range    GET /v1/media/assets/<object> of a random -range-size range
+  signed   GET of a signed URL for <object>, ranged the same way
+
+<object> is -object, else one of -object-size uploaded at the start.
+Objects the run uploads are deleted at the end unless -keep is set.
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cdn-loadtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+		fmt.Fprint(stderr, "\nEnvironment: CDN_SERVER, CDN_TOKEN\n")
+	}
+	var opts options
+	fs.StringVar(&opts.server, "server", envOr("CDN_SERVER", "http://localhost:8080"), "go-media base URL")
+	fs.StringVar(&opts.token, "token", os.Getenv("CDN_TOKEN"), "bearer token sent with every request")
+	fs.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to run")
+	fs.IntVar(&opts.concurrency, "concurrency", 8, "concurrent workers")
+	mix := fs.String("mix", "upload=1,range=6,signed=3", "relative weights of the request kinds")
+	fs.StringVar(&opts.object, "object", "", "existing public object to read, such as a video; uploaded if empty")
+	fs.Int64Var(&opts.objectSize, "object-size", 8<<20, "size of the object uploaded when -object is empty")
+	fs.Int64Var(&opts.uploadSize, "upload-size", 256<<10, "size of each upload")
+	fs.Int64Var(&opts.rangeSize, "range-size", 1<<20, "size of each ranged read")
+	fs.BoolVar(&opts.keep, "keep", false, "keep the objects the run uploads")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	var b budget
+	fs.DurationVar(&b.P50, "max-p50", 0, "fail if any kind's p50 latency exceeds this")
+	fs.DurationVar(&b.P95, "max-p95", 0, "fail if any kind's p95 latency exceeds this")
+	fs.DurationVar(&b.P99, "max-p99", 0, "fail if any kind's p99 latency exceeds this")
+	fs.Float64Var(&b.ErrorPercent, "max-errors", -1, "fail if any kind's error rate exceeds this percentage")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	var err error
+	if opts.mix, err = parseMix(*mix); err != nil {
+		fmt.Fprintf(stderr, "cdn-loadtest: -mix: %v\n", err)
+		return 2
+	}
+	if opts.concurrency < 1 || opts.duration <= 0 || opts.uploadSize < 1 || opts.rangeSize < 1 {
+		fmt.Fprintln(stderr, "cdn-loadtest: -concurrency, -duration, -upload-size and -range-size must be positive")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := loadTest(ctx, newClient(opts.server, opts.token), opts, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "cdn-loadtest: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.print(stdout)
+	}
+
+	if violations := b.check(report); len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Fprintf(stderr, "cdn-loadtest: over budget: %s\n", v)
+		}
+		return 1
+	}
+	return 0
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
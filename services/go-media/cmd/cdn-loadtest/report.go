@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recorder collects the results of a run by kind of request
+type recorder struct {
+	mu      sync.Mutex
+	results map[string][]result
+}
+
+func newRecorder() *recorder {
+	return &recorder{results: map[string][]result{}}
+}
+
+func (rec *recorder) record(kind string, r result) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.results[kind] = append(rec.results[kind], r)
+}
+
+// ms is a latency reported in milliseconds
+type ms time.Duration
+
+func (d ms) MarshalJSON() ([]byte, error) {
+	return json.Marshal(math.Round(float64(d)/float64(time.Millisecond)*100) / 100)
+}
+
+func (d *ms) UnmarshalJSON(b []byte) error {
+	var f float64
+	err := json.Unmarshal(b, &f)
+	*d = ms(f * float64(time.Millisecond))
+	return err
+}
+
+func (d ms) String() string {
+	return time.Duration(d).Round(100 * time.Microsecond).String()
+}
+
+// kindReport sums up the requests of one kind. Latencies are of every
+// request, failed or not.
+type kindReport struct {
+	Kind         string  `json:"kind"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	ErrorPercent float64 `json:"error_percent"`
+	PerSecond    float64 `json:"per_second"`
+	// Bytes is the total uploaded or downloaded by successful requests
+	Bytes int64 `json:"bytes"`
+	P50   ms    `json:"p50_ms"`
+	P95   ms    `json:"p95_ms"`
+	P99   ms    `json:"p99_ms"`
+	Max   ms    `json:"max_ms"`
+	// Causes counts the errors by status code, or by "timeout",
+	// "connection" or "canceled" when there was no response
+	Causes map[string]int `json:"causes,omitempty"`
+}
+
+type report struct {
+	Duration ms           `json:"duration_ms"`
+	Kinds    []kindReport `json:"kinds"`
+}
+
+func (rec *recorder) report(elapsed time.Duration) *report {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rep := &report{Duration: ms(elapsed)}
+	for _, kind := range kinds {
+		results := rec.results[kind]
+		if len(results) == 0 {
+			continue
+		}
+		kr := kindReport{Kind: kind, Requests: len(results), PerSecond: float64(len(results)) / elapsed.Seconds()}
+		latencies := make([]time.Duration, len(results))
+		for i, r := range results {
+			latencies[i] = r.latency
+			if r.cause == "" {
+				kr.Bytes += r.bytes
+				continue
+			}
+			kr.Errors++
+			if kr.Causes == nil {
+				kr.Causes = map[string]int{}
+			}
+			kr.Causes[r.cause]++
+		}
+		kr.ErrorPercent = 100 * float64(kr.Errors) / float64(kr.Requests)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		kr.P50 = ms(percentile(latencies, 50))
+		kr.P95 = ms(percentile(latencies, 95))
+		kr.P99 = ms(percentile(latencies, 99))
+		kr.Max = ms(latencies[len(latencies)-1])
+		rep.Kinds = append(rep.Kinds, kr)
+	}
+	return rep
+}
+
+// percentile returns the nearest-rank pth percentile of sorted
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func (rep *report) print(w io.Writer) {
+	fmt.Fprintf(w, "%-8s %9s %8s %8s %9s %10s %10s %10s %10s\n", "KIND", "REQUESTS", "ERRORS", "REQ/S", "MB", "P50", "P95", "P99", "MAX")
+	for _, k := range rep.Kinds {
+		fmt.Fprintf(w, "%-8s %9d %7.2f%% %8.1f %9.1f %10s %10s %10s %10s\n",
+			k.Kind, k.Requests, k.ErrorPercent, k.PerSecond, float64(k.Bytes)/(1<<20), k.P50, k.P95, k.P99, k.Max)
+	}
+	for _, k := range rep.Kinds {
+		if len(k.Causes) == 0 {
+			continue
+		}
+		causes := make([]string, 0, len(k.Causes))
+		for cause, n := range k.Causes {
+			causes = append(causes, fmt.Sprintf("%s×%d", cause, n))
+		}
+		sort.Strings(causes)
+		fmt.Fprintf(w, "%s errors: %s\n", k.Kind, strings.Join(causes, ", "))
+	}
+}
+
+// budget is the most latency and errors a run may have for every kind of
+// request. Zero latencies and a negative ErrorPercent aren't checked.
+type budget struct {
+	P50, P95, P99 time.Duration
+	ErrorPercent  float64
+}
+
+// check returns how rep goes over b
+func (b budget) check(rep *report) []string {
+	var over []string
+	for _, k := range rep.Kinds {
+		for _, l := range []struct {
+			name       string
+			got, limit time.Duration
+		}{
+			{"p50", time.Duration(k.P50), b.P50},
+			{"p95", time.Duration(k.P95), b.P95},
+			{"p99", time.Duration(k.P99), b.P99},
+		} {
+			if l.limit > 0 && l.got > l.limit {
+				over = append(over, fmt.Sprintf("%s %s %s > %s", k.Kind, l.name, ms(l.got), l.limit))
+			}
+		}
+		if b.ErrorPercent >= 0 && k.ErrorPercent > b.ErrorPercent {
+			over = append(over, fmt.Sprintf("%s errors %.2f%% > %g%%", k.Kind, k.ErrorPercent, b.ErrorPercent))
+		}
+	}
+	return over
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// client talks to the go-media HTTP API
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) *client {
+	return &client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+// apiError is a non-2xx response from the server. Code is empty from
+// servers that predate error codes.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%d %s: %s (%s)", e.Status, http.StatusText(e.Status), e.Message, e.Code)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// escapeKey escapes each segment of an object key for use in a URL path
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (c *client) do(ctx context.Context, method, path string, body io.Reader, contentType string, out interface{}) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		apiErr := &apiError{Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var errResp handlers.ErrorResponse
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+			apiErr.Code, apiErr.Message = errResp.Code, errResp.Error
+		}
+		return resp, apiErr
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	_, err := c.do(ctx, method, path, body, "application/json", out)
+	return err
+}
+
+// Upload streams a local file to the upload endpoint
+func (c *client) Upload(ctx context.Context, path string) (*handlers.UploadResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filepath.Base(path)))
+		// Without a part Content-Type the server sniffs the content
+		if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+			h.Set("Content-Type", ct)
+		}
+		part, err := mw.CreatePart(h)
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	var resp handlers.UploadResponse
+	if _, err := c.do(ctx, http.MethodPost, "/v1/media/upload", pr, mw.FormDataContentType(), &resp); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// List returns objects under prefix
+func (c *client) List(ctx context.Context, prefix string) ([]storage.Object, error) {
+	var objects []storage.Object
+	err := c.doJSON(ctx, http.MethodGet, "/v1/media/list?prefix="+url.QueryEscape(prefix), nil, &objects)
+	return objects, err
+}
+
+// Delete removes an object
+func (c *client) Delete(ctx context.Context, key string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/v1/media/delete/"+escapeKey(key), nil, nil)
+}
+
+// Sign creates a signed URL for a private object
+func (c *client) Sign(ctx context.Context, key string, expiresIn time.Duration) (*handlers.SignedURLResponse, error) {
+	var resp handlers.SignedURLResponse
+	req := handlers.SignedURLRequest{Path: key, ExpiresIn: int64(expiresIn.Seconds())}
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/media/sign", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Purge purges URLs from the Cloudflare cache
+func (c *client) Purge(ctx context.Context, urls []string) error {
+	return c.doJSON(ctx, http.MethodPost, "/v1/media/purge", map[string][]string{"files": urls}, nil)
+}
+
+// Exists checks which of at most existsBatch keys exist
+func (c *client) Exists(ctx context.Context, keys []string) (*handlers.ExistsResponse, error) {
+	var resp handlers.ExistsResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/media/exists", handlers.ExistsRequest{Keys: keys}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Stat returns the headers of a public object, or nil if it doesn't exist
+func (c *client) Stat(ctx context.Context, key string) (http.Header, error) {
+	resp, err := c.do(ctx, http.MethodHead, "/v1/media/assets/"+escapeKey(key), nil, "", nil)
+	if apiErr, ok := err.(*apiError); ok && apiErr.Status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Header, nil
+}
@@ -0,0 +1,232 @@
+// Command cdnctl manages assets on a running go-media instance, e.g. to
+// publish static assets from CI:
+//
+//	cdnctl -server https://api.mikeodnis.dev sync -exclude '*.map' ./dist
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: cdnctl [-server URL] [-token TOKEN] <command> [flags] [args]
+
+Commands:
+  upload [-parallel N] <file>...      upload files and print their URLs
+  sync [flags] <dir>                  upload files under dir not already stored
+  ls [-prefix P] [-json]              list objects
+  rm [-parallel N] <key>...           delete objects
+  sign [-expires D] <key>             create a signed URL for a private object
+  purge <url>...                      purge URLs from the Cloudflare cache
+  stat <key>                          show object headers
+
+Run 'cdnctl <command> -h' for command flags.
+Environment: CDN_SERVER, CDN_TOKEN
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("cdnctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() { fmt.Fprint(stderr, usage) }
+	server := global.String("server", envOr("CDN_SERVER", "http://localhost:8080"), "go-media base URL")
+	token := global.String("token", os.Getenv("CDN_TOKEN"), "bearer token sent with every request")
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := newClient(*server, *token)
+	cmd, cmdArgs := global.Arg(0), global.Args()[1:]
+	fs := flag.NewFlagSet("cdnctl "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	// Workers print concurrently
+	var outMu sync.Mutex
+	printf := func(format string, a ...interface{}) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		fmt.Fprintf(stdout, format, a...)
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "cdnctl %s: %v\n", cmd, err)
+		return 1
+	}
+	failures := func(n int) int {
+		if n > 0 {
+			fmt.Fprintf(stderr, "cdnctl %s: %d failed\n", cmd, n)
+			return 1
+		}
+		return 0
+	}
+
+	switch cmd {
+	case "upload":
+		n := fs.Int("parallel", 4, "concurrent uploads")
+		if !parseArgs(fs, cmdArgs, "<file>...", atLeastOne) {
+			return 2
+		}
+		return failures(parallel(ctx, *n, fs.Args(), func(ctx context.Context, file string) error {
+			resp, err := c.Upload(ctx, file)
+			if err != nil {
+				return err
+			}
+			printf("%s -> %s\n", file, resp.URL)
+			return nil
+		}))
+
+	case "sync":
+		opts := syncOptions{printf: printf}
+		var include, exclude globList
+		fs.IntVar(&opts.parallel, "parallel", 4, "concurrent uploads")
+		fs.Var(&include, "include", "only sync files matching glob (repeatable)")
+		fs.Var(&exclude, "exclude", "skip files matching glob (repeatable)")
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "report what would be uploaded")
+		manifest := fs.String("manifest", "", "write a JSON manifest of file, key and URL to this path")
+		if !parseArgs(fs, cmdArgs, "<dir>", exactlyOne) {
+			return 2
+		}
+		opts.include, opts.exclude = include, exclude
+
+		results, failed := syncDir(ctx, c, fs.Arg(0), opts)
+		if *manifest != "" {
+			sort.Slice(results, func(i, j int) bool { return results[i].File < results[j].File })
+			data, err := json.MarshalIndent(results, "", "  ")
+			if err == nil {
+				err = os.WriteFile(*manifest, append(data, '\n'), 0o644)
+			}
+			if err != nil {
+				return fail(err)
+			}
+		}
+		return failures(failed)
+
+	case "ls":
+		prefix := fs.String("prefix", "", "only list keys with this prefix")
+		asJSON := fs.Bool("json", false, "print JSON")
+		if !parseArgs(fs, cmdArgs, "", none) {
+			return 2
+		}
+		objects, err := c.List(ctx, *prefix)
+		if err != nil {
+			return fail(err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(objects); err != nil {
+				return fail(err)
+			}
+			return 0
+		}
+		for _, o := range objects {
+			fmt.Fprintf(stdout, "%10d  %s  %s\n", o.Size, o.LastModified.UTC().Format(time.RFC3339), o.Key)
+		}
+		return 0
+
+	case "rm":
+		n := fs.Int("parallel", 4, "concurrent deletes")
+		if !parseArgs(fs, cmdArgs, "<key>...", atLeastOne) {
+			return 2
+		}
+		return failures(parallel(ctx, *n, fs.Args(), func(ctx context.Context, key string) error {
+			if err := c.Delete(ctx, key); err != nil {
+				return err
+			}
+			printf("deleted %s\n", key)
+			return nil
+		}))
+
+	case "sign":
+		expires := fs.Duration("expires", time.Hour, "URL lifetime")
+		if !parseArgs(fs, cmdArgs, "<key>", exactlyOne) {
+			return 2
+		}
+		resp, err := c.Sign(ctx, fs.Arg(0), *expires)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Fprintln(stdout, resp.URL)
+		return 0
+
+	case "purge":
+		if !parseArgs(fs, cmdArgs, "<url>...", atLeastOne) {
+			return 2
+		}
+		if err := c.Purge(ctx, fs.Args()); err != nil {
+			return fail(err)
+		}
+		fmt.Fprintf(stdout, "purged %d URLs\n", fs.NArg())
+		return 0
+
+	case "stat":
+		if !parseArgs(fs, cmdArgs, "<key>", exactlyOne) {
+			return 2
+		}
+		header, err := c.Stat(ctx, fs.Arg(0))
+		if err != nil {
+			return fail(err)
+		}
+		if header == nil {
+			return fail(fmt.Errorf("%s not found", fs.Arg(0)))
+		}
+		fmt.Fprintf(stdout, "Key:           %s\n", fs.Arg(0))
+		for _, name := range []string{"Content-Length", "Content-Type", "ETag", "Last-Modified", "Cache-Control"} {
+			if v := header.Get(name); v != "" {
+				fmt.Fprintf(stdout, "%-15s%s\n", name+":", v)
+			}
+		}
+		return 0
+
+	default:
+		fmt.Fprintf(stderr, "cdnctl: unknown command %q\n\n", cmd)
+		global.Usage()
+		return 2
+	}
+}
+
+// parseArgs parses command flags and checks the positional argument count,
+// printing usage on failure
+func parseArgs(fs *flag.FlagSet, args []string, argsUsage string, validCount func(n int) bool) bool {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s\n", fs.Name(), argsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if !validCount(fs.NArg()) {
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+func none(n int) bool       { return n == 0 }
+func exactlyOne(n int) bool { return n == 1 }
+func atLeastOne(n int) bool { return n > 0 }
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// globList is a repeatable -include/-exclude flag
+type globList []string
+
+func (g *globList) String() string { return strings.Join(*g, ",") }
+
+func (g *globList) Set(v string) error {
+	if _, err := path.Match(v, ""); err != nil {
+		return fmt.Errorf("invalid glob %q: %w", v, err)
+	}
+	*g = append(*g, v)
+	return nil
+}
+
+// matchGlob matches a slash-separated relative path. Patterns without a
+// slash match the file name in any directory, e.g. "*.map".
+func matchGlob(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	ok, _ := path.Match(pattern, rel)
+	return ok
+}
+
+// selected reports whether rel passes the include and exclude globs.
+// Excludes win; no includes means everything is included.
+func selected(rel string, include, exclude []string) bool {
+	for _, p := range exclude {
+		if matchGlob(p, rel) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, p := range include {
+		if matchGlob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFiles returns the regular files under dir that pass the globs, as
+// slash-separated paths relative to dir
+func walkFiles(dir string, include, exclude []string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if selected(rel, include, exclude) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// contentKey computes the key the server assigns to an upload: the first
+// 16 hex characters of the SHA-256 plus the lower-cased extension
+func contentKey(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "assets/" + hex.EncodeToString(h.Sum(nil))[:16] + strings.ToLower(filepath.Ext(file)), nil
+}
+
+// parallel runs fn for every item with at most n running at once and
+// returns the number of failures
+func parallel(ctx context.Context, n int, items []string, fn func(ctx context.Context, item string) error) int {
+	if n < 1 {
+		n = 1
+	}
+	work := make(chan string)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if err := fn(ctx, item); err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					fmt.Fprintf(os.Stderr, "%s: %v\n", item, err)
+				}
+			}
+		}()
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+// syncResult maps a local file to its published URL
+type syncResult struct {
+	File     string `json:"file"`
+	Key      string `json:"key"`
+	URL      string `json:"url,omitempty"`
+	Uploaded bool   `json:"uploaded"`
+}
+
+// existsBatch is the most keys the server checks per request
+const existsBatch = 1000
+
+// existingKeys asks the server which keys exist, a batch at a time. Keys
+// it couldn't check, including every key when the server predates
+// /v1/media/exists, are left out and checked one by one.
+func existingKeys(ctx context.Context, c *client, keys []string) map[string]bool {
+	exists := make(map[string]bool, len(keys))
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > existsBatch {
+			batch = batch[:existsBatch]
+		}
+		keys = keys[len(batch):]
+
+		resp, err := c.Exists(ctx, batch)
+		if err != nil {
+			return exists
+		}
+		for key := range resp.Found {
+			exists[key] = true
+		}
+		for _, key := range resp.Missing {
+			exists[key] = false
+		}
+	}
+	return exists
+}
+
+// syncDir uploads every selected file under dir whose content is not
+// already stored. Keys are content hashes, so an existing key means the
+// exact bytes are already published.
+func syncDir(ctx context.Context, c *client, dir string, opts syncOptions) ([]syncResult, int) {
+	files, err := walkFiles(dir, opts.include, opts.exclude)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+		return nil, 1
+	}
+
+	// Hash everything first so the keys can be checked in bulk
+	var mu sync.Mutex
+	keys := make(map[string]string, len(files))
+	failed := parallel(ctx, opts.parallel, files, func(ctx context.Context, rel string) error {
+		key, err := contentKey(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		keys[rel] = key
+		mu.Unlock()
+		return nil
+	})
+	hashed := make([]string, 0, len(keys))
+	distinct := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, rel := range files {
+		key, ok := keys[rel]
+		if !ok {
+			continue
+		}
+		hashed = append(hashed, rel)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, key)
+		}
+	}
+	exists := existingKeys(ctx, c, distinct)
+
+	results := make([]syncResult, 0, len(hashed))
+	failed += parallel(ctx, opts.parallel, hashed, func(ctx context.Context, rel string) error {
+		local := filepath.Join(dir, filepath.FromSlash(rel))
+		key := keys[rel]
+		res := syncResult{File: rel, Key: key}
+
+		stored, known := exists[key]
+		if !known {
+			header, err := c.Stat(ctx, key)
+			if err != nil {
+				return err
+			}
+			stored = header != nil
+		}
+		switch {
+		case stored:
+			opts.printf("unchanged %s\n", rel)
+		case opts.dryRun:
+			opts.printf("would upload %s -> %s\n", rel, key)
+		default:
+			resp, err := c.Upload(ctx, local)
+			if err != nil {
+				return err
+			}
+			res.Key, res.URL, res.Uploaded = resp.Key, resp.URL, true
+			opts.printf("uploaded %s -> %s\n", rel, resp.URL)
+		}
+
+		mu.Lock()
+		results = append(results, res)
+		mu.Unlock()
+		return nil
+	})
+	return results, failed
+}
+
+type syncOptions struct {
+	parallel int
+	include  []string
+	exclude  []string
+	dryRun   bool
+	printf   func(format string, args ...interface{})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+)
+
+func TestSelected(t *testing.T) {
+	tests := []struct {
+		rel              string
+		include, exclude []string
+		want             bool
+	}{
+		{"index.html", nil, nil, true},
+		{"js/app.js.map", nil, []string{"*.map"}, false},
+		{"js/app.js", []string{"*.js"}, nil, true},
+		{"css/site.css", []string{"*.js"}, nil, false},
+		{"img/logo.png", []string{"img/*"}, nil, true},
+		{"static/img/logo.png", []string{"img/*"}, nil, false},
+		{"img/logo.png", []string{"img/*"}, []string{"logo.*"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := selected(tt.rel, tt.include, tt.exclude); got != tt.want {
+			t.Errorf("selected(%q, %v, %v) = %v, want %v", tt.rel, tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+// fakeServer stores uploads by content key like the real upload handler.
+// legacy servers predate the bulk existence check.
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string]bool
+	uploads int
+	heads   int
+	legacy  bool
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/media/exists" && !f.legacy:
+		var req handlers.ExistsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := handlers.ExistsResponse{Found: map[string]string{}, Missing: []string{}}
+		for _, key := range req.Keys {
+			if f.objects[key] {
+				resp.Found[key] = "etag"
+			} else {
+				resp.Missing = append(resp.Missing, key)
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case r.Method == http.MethodHead && strings.HasPrefix(r.URL.Path, "/v1/media/assets/"):
+		f.heads++
+		if !f.objects[strings.TrimPrefix(r.URL.Path, "/v1/media/assets/")] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/media/upload":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		sum := sha256.Sum256(data)
+		key := "assets/" + hex.EncodeToString(sum[:])[:16] + filepath.Ext(header.Filename)
+
+		f.objects[key] = true
+		f.uploads++
+		json.NewEncoder(w).Encode(handlers.UploadResponse{URL: "https://cdn.example/" + key, Key: key})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSyncUploadsOnlyNewFiles(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		name := "bulk exists"
+		if legacy {
+			name = "HEAD per file"
+		}
+		t.Run(name, func(t *testing.T) { testSyncUploadsOnlyNewFiles(t, legacy) })
+	}
+}
+
+func testSyncUploadsOnlyNewFiles(t *testing.T, legacy bool) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":    "<html></html>",
+		"js/app.js":     "console.log(1)",
+		"js/app.js.map": "{}",
+		"css/site.css":  "body{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	existing, err := contentKey(filepath.Join(dir, "css", "site.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeServer{objects: map[string]bool{existing: true}, legacy: legacy}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	manifest := filepath.Join(t.TempDir(), "manifest.json")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-server", srv.URL, "sync", "-exclude", "*.map", "-manifest", manifest, dir}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+
+	if fake.uploads != 2 {
+		t.Errorf("uploads = %d, want 2 (index.html, js/app.js)", fake.uploads)
+	}
+	if !strings.Contains(stdout.String(), "unchanged css/site.css") {
+		t.Errorf("output missing unchanged css/site.css:\n%s", stdout.String())
+	}
+
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []syncResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("manifest has %d entries, want 3", len(results))
+	}
+	if results[0].File != "css/site.css" || results[0].Uploaded {
+		t.Errorf("manifest[0] = %+v, want css/site.css not uploaded", results[0])
+	}
+
+	// A second run finds everything already stored
+	stdout.Reset()
+	if code := run([]string{"-server", srv.URL, "sync", "-exclude", "*.map", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("second run() = %d", code)
+	}
+	if fake.uploads != 2 {
+		t.Errorf("uploads after second sync = %d, want 2", fake.uploads)
+	}
+	// Newer servers answer for every file in one request
+	if wantHeads := map[bool]int{false: 0, true: 6}[legacy]; fake.heads != wantHeads {
+		t.Errorf("HEAD requests = %d, want %d", fake.heads, wantHeads)
+	}
+}
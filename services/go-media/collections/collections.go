@@ -0,0 +1,247 @@
+// Package collections keeps named, ordered sets of asset keys, such as a
+// gallery's images or a message's attachments, persisted as a JSON file.
+package collections
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/fsutil"
+)
+
+// MaxKeys caps the keys in one collection, the most a zip bundle holds
+const MaxKeys = 1000
+
+// maxNameLength caps a collection's name
+const maxNameLength = 200
+
+var (
+	ErrNotFound = errors.New("collection not found")
+	ErrInvalid  = errors.New("invalid collection")
+)
+
+// Collection is a named, ordered set of asset keys
+type Collection struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Keys        []string  `json:"keys"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Fields are what a client sets on a collection
+type Fields struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Keys        []string `json:"keys"`
+}
+
+// validate checks f and drops repeated keys, keeping the first of each
+func (f *Fields) validate() error {
+	f.Name = strings.TrimSpace(f.Name)
+	switch {
+	case f.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	case len(f.Name) > maxNameLength:
+		return fmt.Errorf("%w: name is over %d bytes", ErrInvalid, maxNameLength)
+	}
+	keys := make([]string, 0, len(f.Keys))
+	seen := make(map[string]bool, len(f.Keys))
+	for _, key := range f.Keys {
+		if key == "" {
+			return fmt.Errorf("%w: keys can't be empty", ErrInvalid)
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > MaxKeys {
+		return fmt.Errorf("%w: a collection holds at most %d keys", ErrInvalid, MaxKeys)
+	}
+	f.Keys = keys
+	return nil
+}
+
+// Store holds collections by ID. Every change is written to its file
+// before it returns, so none is lost in a crash. An empty path keeps
+// collections in memory only.
+type Store struct {
+	mu          sync.RWMutex
+	path        string
+	collections map[string]*Collection
+	now         func() time.Time
+}
+
+// Open loads the collections saved at path, starting empty if it does
+// not exist
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:        path,
+		collections: make(map[string]*Collection),
+		now:         time.Now,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	var saved []*Collection
+	if _, err := fsutil.ReadJSON(path, &saved); err != nil {
+		return nil, err
+	}
+	for _, c := range saved {
+		s.collections[c.ID] = c
+	}
+	return s, nil
+}
+
+// List returns copies of every collection, sorted by name
+func (s *Store) List() []Collection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Collection, 0, len(s.collections))
+	for _, c := range s.collections {
+		list = append(list, c.copy())
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].Name != list[b].Name {
+			return list[a].Name < list[b].Name
+		}
+		return list[a].ID < list[b].ID
+	})
+	return list
+}
+
+// Get returns a copy of the collection with id
+func (s *Store) Get(id string) (Collection, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.collections[id]
+	if !ok {
+		return Collection{}, false
+	}
+	return c.copy(), true
+}
+
+// Create adds a collection with f and a new ID
+func (s *Store) Create(f Fields) (Collection, error) {
+	if err := f.validate(); err != nil {
+		return Collection{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Collection{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().UTC()
+	c := &Collection{ID: id, Name: f.Name, Description: f.Description, Keys: f.Keys, CreatedAt: now, UpdatedAt: now}
+	s.collections[id] = c
+	if err := s.save(); err != nil {
+		delete(s.collections, id)
+		return Collection{}, err
+	}
+	return c.copy(), nil
+}
+
+// Replace sets the name, description and keys of the collection with id
+func (s *Store) Replace(id string, f Fields) (Collection, error) {
+	if err := f.validate(); err != nil {
+		return Collection{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[id]
+	if !ok {
+		return Collection{}, ErrNotFound
+	}
+	prev := *c
+	c.Name, c.Description, c.Keys = f.Name, f.Description, f.Keys
+	c.UpdatedAt = s.now().UTC()
+	if err := s.save(); err != nil {
+		*c = prev
+		return Collection{}, err
+	}
+	return c.copy(), nil
+}
+
+// Delete removes the collection with id
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.collections, id)
+	if err := s.save(); err != nil {
+		s.collections[id] = c
+		return err
+	}
+	return nil
+}
+
+// RemoveKey drops key from every collection holding it, as when the
+// asset is deleted
+func (s *Store) RemoveKey(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	now := s.now().UTC()
+	for _, c := range s.collections {
+		for i, k := range c.Keys {
+			if k == key {
+				c.Keys = append(c.Keys[:i:i], c.Keys[i+1:]...)
+				c.UpdatedAt = now
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes every collection to the file. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	saved := make([]*Collection, 0, len(s.collections))
+	for _, c := range s.collections {
+		saved = append(saved, c)
+	}
+	sort.Slice(saved, func(a, b int) bool { return saved[a].ID < saved[b].ID })
+	return fsutil.WriteJSON(s.path, saved)
+}
+
+func (c *Collection) copy() Collection {
+	copied := *c
+	copied.Keys = append([]string(nil), c.Keys...)
+	return copied
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "col_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,89 @@
+package collections
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gallery, err := s.Create(Fields{Name: " Launch gallery ", Keys: []string{"img/b.jpg", "img/a.jpg", "img/b.jpg"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !strings.HasPrefix(gallery.ID, "col_") || gallery.Name != "Launch gallery" || strings.Join(gallery.Keys, ",") != "img/b.jpg,img/a.jpg" {
+		t.Errorf("created %+v", gallery)
+	}
+	if _, err := s.Create(Fields{Name: "Attachments", Keys: []string{"docs/q3.pdf", "img/a.jpg"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := s.Replace(gallery.ID, Fields{Name: "Launch gallery", Description: "Press kit", Keys: []string{"img/a.jpg", "img/c.jpg"}})
+	if err != nil || updated.Description != "Press kit" || updated.Keys[1] != "img/c.jpg" || !updated.CreatedAt.Equal(gallery.CreatedAt) {
+		t.Errorf("Replace() = %+v, %v", updated, err)
+	}
+	if err := s.RemoveKey("img/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reloaded.List()
+	if len(list) != 2 || list[0].Name != "Attachments" || list[1].Name != "Launch gallery" {
+		t.Fatalf("List() after reload = %+v", list)
+	}
+	if strings.Join(list[0].Keys, ",") != "docs/q3.pdf" || strings.Join(list[1].Keys, ",") != "img/c.jpg" {
+		t.Errorf("deleted key kept: %v, %v", list[0].Keys, list[1].Keys)
+	}
+
+	if err := reloaded.Delete(gallery.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Get(gallery.ID); ok {
+		t.Error("deleted collection still found")
+	}
+	if err := reloaded.Delete(gallery.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice: %v", err)
+	}
+	if _, err := reloaded.Replace("col_missing", Fields{Name: "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("replacing a missing collection: %v", err)
+	}
+}
+
+func TestFieldsValidate(t *testing.T) {
+	many := make([]string, MaxKeys+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("img/%d.jpg", i)
+	}
+	for _, f := range []Fields{
+		{Name: "  "},
+		{Name: strings.Repeat("n", maxNameLength+1)},
+		{Name: "empty key", Keys: []string{"a.png", ""}},
+		{Name: "too many", Keys: many},
+	} {
+		s, _ := Open("")
+		if _, err := s.Create(f); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Create(%.40q) error = %v, want ErrInvalid", f.Name, err)
+		}
+	}
+}
+
+func TestGetReturnsCopy(t *testing.T) {
+	s, _ := Open("")
+	c, _ := s.Create(Fields{Name: "a", Keys: []string{"x.png"}})
+	got, _ := s.Get(c.ID)
+	got.Keys[0] = "changed.png"
+	if again, _ := s.Get(c.ID); again.Keys[0] != "x.png" {
+		t.Error("changing a returned collection changed the store")
+	}
+}
@@ -0,0 +1,1299 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/WomB0ComB0/cdn/services/go-media/audio"
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/scheduler"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/video"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete service configuration. Values are loaded from
+// an optional YAML, TOML or JSON file and then overridden by the
+// environment variables named in the env tags.
+type Config struct {
+	Port          string `json:"port" env:"PORT"`
+	AppVersion    string `json:"app_version" env:"APP_VERSION"`
+	SigningSecret string `json:"signing_secret" env:"SIGNING_SECRET"`
+	AdminToken    string `json:"admin_token" env:"ADMIN_TOKEN"`
+
+	// StartupCheck controls the boot-time self-check: strict refuses to
+	// start on failure, warn logs and continues, off skips it
+	StartupCheck string `json:"startup_check" env:"STARTUP_CHECK"`
+
+	Server       ServerConfig       `json:"server"`
+	R2           R2Config           `json:"r2"`
+	Cloudflare   CloudflareConfig   `json:"cloudflare"`
+	Data         DataConfig         `json:"data"`
+	Sentry       SentryConfig       `json:"sentry"`
+	Webhooks     WebhookConfig      `json:"webhooks"`
+	Events       EventsConfig       `json:"events"`
+	CORS         CORSConfig         `json:"cors"`
+	GRPC         GRPCConfig         `json:"grpc"`
+	S3           S3Config           `json:"s3"`
+	DAV          DAVConfig          `json:"dav"`
+	Downloads    DownloadConfig     `json:"downloads"`
+	QoS          QoSConfig          `json:"qos"`
+	Transforms   TransformsConfig   `json:"transforms"`
+	Images       ImagesConfig       `json:"images"`
+	Video        VideoConfig        `json:"video"`
+	Audio        AudioConfig        `json:"audio"`
+	Documents    DocumentsConfig    `json:"documents"`
+	Search       SearchConfig       `json:"search"`
+	Metrics      MetricsConfig      `json:"metrics"`
+	BucketEvents BucketEventsConfig `json:"bucket_events"`
+	Jobs         JobsConfig         `json:"jobs"`
+	Encryption   EncryptionConfig   `json:"encryption"`
+	Uploads      UploadsConfig      `json:"uploads"`
+	HTML         HTMLConfig         `json:"html"`
+	URLs         URLsConfig         `json:"urls"`
+	Regions      RegionsConfig      `json:"regions"`
+	WellKnown    WellKnownConfig    `json:"well_known"`
+	Mirror       MirrorConfig       `json:"mirror"`
+	Chaos        ChaosConfig        `json:"chaos"`
+	API          APIConfig          `json:"api"`
+	Trash        TrashConfig        `json:"trash"`
+	Lifecycle    LifecycleConfig    `json:"lifecycle"`
+
+	// Settings below are reloadable on SIGHUP
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	Cache     CacheConfig     `json:"cache"`
+	Listing   ListingConfig   `json:"listing"`
+	Redirects RedirectConfig  `json:"redirects"`
+	Headers   HeadersConfig   `json:"headers"`
+}
+
+type ServerConfig struct {
+	// Listen overrides the port with host:port, unix:/path/to.sock,
+	// systemd or systemd:<FileDescriptorName>
+	Listen string `json:"listen" env:"LISTEN"`
+	// SocketMode is the octal permission of a Unix socket
+	SocketMode string `json:"socket_mode" env:"LISTEN_SOCKET_MODE"`
+
+	// DrainTimeoutSeconds bounds how long shutdown waits for in-flight
+	// uploads before closing connections
+	DrainTimeoutSeconds int `json:"drain_timeout_seconds" env:"DRAIN_TIMEOUT_SECONDS"`
+
+	// H2C accepts cleartext HTTP/2 (prior knowledge or Upgrade), for
+	// proxies that speak HTTP/2 to the backend. Ignored when TLS is on,
+	// where HTTP/2 is negotiated via ALPN.
+	H2C bool `json:"h2c" env:"H2C"`
+
+	// HTTP3 also serves HTTP/3 (QUIC) on the UDP port of the listen
+	// address and advertises it with Alt-Svc. It needs native TLS and a
+	// host:port listen address.
+	HTTP3 bool `json:"http3" env:"HTTP3"`
+
+	// ReadOnly turns the instance into a replica: uploads, deletes, purges
+	// and WebDAV writes are rejected, and jobs that write to the bucket
+	// don't run
+	ReadOnly bool `json:"read_only" env:"READ_ONLY"`
+
+	Timeouts TimeoutConfig `json:"timeouts"`
+	Limits   LimitConfig   `json:"limits"`
+
+	TLS TLSConfig `json:"tls"`
+}
+
+// TimeoutConfig sets per-route timeouts in seconds. JSON APIs are short,
+// uploads and asset streams long; zero disables the asset write timeout.
+type TimeoutConfig struct {
+	ReadHeaderSeconds int `json:"read_header_seconds" env:"READ_HEADER_TIMEOUT_SECONDS"`
+	IdleSeconds       int `json:"idle_seconds" env:"IDLE_TIMEOUT_SECONDS"`
+	APISeconds        int `json:"api_seconds" env:"API_TIMEOUT_SECONDS"`
+	UploadSeconds     int `json:"upload_seconds" env:"UPLOAD_TIMEOUT_SECONDS"`
+	AssetSeconds      int `json:"asset_seconds" env:"ASSET_TIMEOUT_SECONDS"`
+}
+
+type LimitConfig struct {
+	MaxHeaderBytes   int `json:"max_header_bytes" env:"MAX_HEADER_BYTES"`
+	MaxJSONBodyBytes int `json:"max_json_body_bytes" env:"MAX_JSON_BODY_BYTES"`
+	MaxUploadBytes   int `json:"max_upload_bytes" env:"MAX_UPLOAD_BYTES"`
+}
+
+// TLSConfig enables native TLS with either a certificate/key pair or
+// ACME (Let's Encrypt) certificates for the listed hosts
+type TLSConfig struct {
+	CertFile string `json:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile  string `json:"key_file" env:"TLS_KEY_FILE"`
+
+	AutocertHosts    []string `json:"autocert_hosts" env:"TLS_AUTOCERT_HOSTS"`
+	AutocertEmail    string   `json:"autocert_email" env:"TLS_AUTOCERT_EMAIL"`
+	AutocertCacheDir string   `json:"autocert_cache_dir" env:"TLS_AUTOCERT_CACHE_DIR"`
+
+	// HTTPPort serves ACME HTTP-01 challenges and redirects to HTTPS when
+	// autocert is enabled
+	HTTPPort string `json:"http_port" env:"TLS_HTTP_PORT"`
+}
+
+// SocketFileMode returns SocketMode as a file mode (validated on load)
+func (s ServerConfig) SocketFileMode() os.FileMode {
+	mode, _ := strconv.ParseUint(s.SocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// Enabled reports whether the server should terminate TLS itself
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || len(t.AutocertHosts) > 0
+}
+
+type R2Config struct {
+	AccountID       string `json:"account_id" env:"R2_ACCOUNT_ID"`
+	AccessKeyID     string `json:"access_key_id" env:"R2_ACCESS_KEY_ID"`
+	SecretAccessKey string `json:"secret_access_key" env:"R2_SECRET_ACCESS_KEY"`
+	BucketName      string `json:"bucket_name" env:"R2_BUCKET_NAME"`
+	Endpoint        string `json:"endpoint" env:"R2_ENDPOINT"`
+}
+
+type CloudflareConfig struct {
+	ZoneID   string `json:"zone_id" env:"CLOUDFLARE_ZONE_ID"`
+	APIToken string `json:"api_token" env:"CLOUDFLARE_API_TOKEN"`
+
+	// OffloadImages and OffloadVideos also upload images to Cloudflare
+	// Images and videos to Stream, in the R2 account, and return their
+	// URLs. ImagesVariant names the Images variant returned.
+	OffloadImages bool   `json:"offload_images" env:"CLOUDFLARE_OFFLOAD_IMAGES"`
+	OffloadVideos bool   `json:"offload_videos" env:"CLOUDFLARE_OFFLOAD_VIDEOS"`
+	ImagesVariant string `json:"images_variant" env:"CLOUDFLARE_IMAGES_VARIANT"`
+}
+
+type DataConfig struct {
+	IndexPath         string `json:"index_path" env:"INDEX_PATH"`
+	UsagePath         string `json:"usage_path" env:"USAGE_PATH"`
+	CollectionsPath   string `json:"collections_path" env:"COLLECTIONS_PATH"`
+	DeploysPath       string `json:"deploys_path" env:"DEPLOYS_PATH"`
+	DomainsPath       string `json:"domains_path" env:"DOMAINS_PATH"`
+	AuditLogPath      string `json:"audit_log_path" env:"AUDIT_LOG_PATH"`
+	AuditShipToBucket bool   `json:"audit_ship_to_bucket" env:"AUDIT_SHIP_TO_BUCKET"`
+}
+
+type SentryConfig struct {
+	DSN         string `json:"dsn" env:"SENTRY_DSN"`
+	Environment string `json:"environment" env:"SENTRY_ENVIRONMENT"`
+}
+
+type WebhookConfig struct {
+	URLs      []string          `json:"urls" env:"WEBHOOK_URLS"`
+	Secret    string            `json:"secret" env:"WEBHOOK_SECRET"`
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+}
+
+// WebhookEndpoint is a receiver with its own secret and event filter
+type WebhookEndpoint struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type EventsConfig struct {
+	Broker string `json:"broker" env:"EVENT_BROKER"`
+	URL    string `json:"url" env:"EVENT_BROKER_URL"`
+	Topic  string `json:"topic" env:"EVENT_TOPIC"`
+	// Username and Password authenticate to the broker; a NATS password
+	// without a username is a token. Kafka sends them with SASL, one of
+	// plain, scram-sha-256 or scram-sha-512.
+	Username string `json:"username" env:"EVENT_BROKER_USERNAME"`
+	Password string `json:"password" env:"EVENT_BROKER_PASSWORD"`
+	SASL     string `json:"sasl" env:"EVENT_BROKER_SASL"`
+	// TLS connects to the broker with TLS, trusting CAFile if set
+	TLS    bool   `json:"tls" env:"EVENT_BROKER_TLS"`
+	CAFile string `json:"ca_file" env:"EVENT_BROKER_CA_FILE"`
+}
+
+// CORSConfig sets which origins may call each route group from a browser.
+// Asset routes allow GET/HEAD, uploads POST, and the JSON API its own
+// methods. An empty origin list disables CORS for that group.
+type CORSConfig struct {
+	AssetOrigins     []string `json:"asset_origins" env:"CORS_ASSET_ORIGINS"`
+	UploadOrigins    []string `json:"upload_origins" env:"CORS_UPLOAD_ORIGINS"`
+	APIOrigins       []string `json:"api_origins" env:"CORS_API_ORIGINS"`
+	AllowedHeaders   []string `json:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string `json:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	MaxAgeSeconds    int      `json:"max_age_seconds" env:"CORS_MAX_AGE_SECONDS"`
+	AllowCredentials bool     `json:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+}
+
+// GRPCConfig enables the gRPC API for internal services on its own
+// listener (host:port, unix:/path or systemd:<name>). Clients must send
+// Token as a bearer token, so it is required with Listen.
+type GRPCConfig struct {
+	Listen string `json:"listen" env:"GRPC_LISTEN"`
+	Token  string `json:"token" env:"GRPC_TOKEN"`
+}
+
+// S3Config enables the S3-compatible API on its own listener. Requests
+// are authenticated with SigV4 against Keys; the AccessKeyID and
+// SecretAccessKey pair adds one unrestricted key from the environment.
+type S3Config struct {
+	Listen          string  `json:"listen" env:"S3_LISTEN"`
+	Bucket          string  `json:"bucket" env:"S3_BUCKET"`
+	AccessKeyID     string  `json:"access_key_id" env:"S3_ACCESS_KEY_ID"`
+	SecretAccessKey string  `json:"secret_access_key" env:"S3_SECRET_ACCESS_KEY"`
+	Keys            []S3Key `json:"keys"`
+}
+
+// S3Key is an access key for the S3 API. Prefixes restricts it to keys
+// under those prefixes (all keys when empty); MaxObjectBytes and
+// QuotaBytes of 0 mean no limit beyond the server upload limit.
+type S3Key struct {
+	AccessKeyID     string   `json:"access_key_id"`
+	SecretAccessKey string   `json:"secret_access_key"`
+	Prefixes        []string `json:"prefixes"`
+	ReadOnly        bool     `json:"read_only"`
+	MaxObjectBytes  int      `json:"max_object_bytes"`
+	QuotaBytes      int      `json:"quota_bytes"`
+	// Priority is the key's load-shedding class: interactive, standard
+	// (the default) or bulk
+	Priority string `json:"priority"`
+}
+
+// AllKeys returns the configured keys plus the environment key, if set
+func (c S3Config) AllKeys() []S3Key {
+	keys := append([]S3Key(nil), c.Keys...)
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		keys = append(keys, S3Key{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey})
+	}
+	return keys
+}
+
+// DAVConfig enables the WebDAV endpoint under /dav/ for mounting the
+// bucket as a network drive. Clients log in with HTTP Basic auth; an
+// empty password leaves the endpoint disabled.
+type DAVConfig struct {
+	Username string `json:"username" env:"DAV_USERNAME"`
+	Password string `json:"password" env:"DAV_PASSWORD"`
+	ReadOnly bool   `json:"read_only" env:"DAV_READ_ONLY"`
+}
+
+// DownloadConfig splits GETs of objects of at least
+// ParallelThresholdBytes into PartBytes ranges fetched from R2
+// Concurrency at a time, for clients faster than a single R2 stream. A
+// threshold of 0 disables it.
+type DownloadConfig struct {
+	ParallelThresholdBytes int `json:"parallel_threshold_bytes" env:"PARALLEL_DOWNLOAD_THRESHOLD_BYTES"`
+	PartBytes              int `json:"part_bytes" env:"PARALLEL_DOWNLOAD_PART_BYTES"`
+	Concurrency            int `json:"concurrency" env:"PARALLEL_DOWNLOAD_CONCURRENCY"`
+}
+
+// QoSConfig sheds load by priority class once MaxConcurrent requests are
+// in flight (0 disables it). Standard requests may use StandardPercent
+// of the capacity and bulk requests BulkPercent; interactive requests
+// may use all of it.
+type QoSConfig struct {
+	MaxConcurrent   int `json:"max_concurrent" env:"QOS_MAX_CONCURRENT"`
+	StandardPercent int `json:"standard_percent" env:"QOS_STANDARD_PERCENT"`
+	BulkPercent     int `json:"bulk_percent" env:"QOS_BULK_PERCENT"`
+}
+
+// TransformsConfig bounds variant builds such as minified copies: up to
+// Workers run at once and Queue more wait, each for at most
+// QueueWaitSeconds, before requests needing a build get 429
+type TransformsConfig struct {
+	Workers          int `json:"workers" env:"TRANSFORM_WORKERS"`
+	Queue            int `json:"queue" env:"TRANSFORM_QUEUE"`
+	QueueWaitSeconds int `json:"queue_wait_seconds" env:"TRANSFORM_QUEUE_WAIT_SECONDS"`
+}
+
+// ImagesConfig picks the engine that builds image variants: "vips" for
+// libvips (in binaries built with -tags vips), "go" for the pure-Go
+// engine, "auto" for libvips when built in, or "off" to serve images
+// only as uploaded.
+//
+// The rest bound what requests may ask for, so that ?w=20000 can't make
+// the service build huge images: the width and height, the pixels of a
+// box given both, the output formats and, when set, the q values.
+// RequireSignature also refuses variant and font subset URLs that don't
+// carry a signature from /v1/media/images/sign.
+//
+// Color is "srgb" to convert images with a color profile to sRGB, or
+// "preserve" to keep the profile. AutoFocus centres cover crops of images
+// without a focal point on their most detailed area.
+type ImagesConfig struct {
+	Engine    string   `json:"engine" env:"IMAGE_ENGINE"`
+	Color     string   `json:"color" env:"IMAGE_COLOR"`
+	AutoFocus bool     `json:"auto_focus" env:"IMAGE_AUTO_FOCUS"`
+	MaxWidth  int      `json:"max_width" env:"IMAGE_MAX_WIDTH"`
+	MaxHeight int      `json:"max_height" env:"IMAGE_MAX_HEIGHT"`
+	MaxPixels int      `json:"max_pixels" env:"IMAGE_MAX_PIXELS"`
+	Formats   []string `json:"formats" env:"IMAGE_FORMATS"`
+	Qualities []int    `json:"qualities" env:"IMAGE_QUALITIES"`
+
+	RequireSignature bool `json:"require_signature" env:"IMAGE_REQUIRE_SIGNATURE"`
+}
+
+// VideoConfig transcodes uploaded videos to HLS with ffmpeg, Workers at
+// a time, when Transcode is set. Presets (settable in the file only)
+// define the rendition ladders uploads choose from, DefaultPreset the
+// one used when an upload doesn't choose. ExtractTracks also stores their
+// text subtitles as WebVTT and their alternate audio tracks as AAC.
+// SpriteSeconds is the interval between seek-preview thumbnails (0 makes
+// none).
+type VideoConfig struct {
+	Transcode     bool           `json:"transcode" env:"VIDEO_TRANSCODE"`
+	FFmpegPath    string         `json:"ffmpeg_path" env:"VIDEO_FFMPEG_PATH"`
+	FFprobePath   string         `json:"ffprobe_path" env:"VIDEO_FFPROBE_PATH"`
+	DefaultPreset string         `json:"default_preset" env:"VIDEO_DEFAULT_PRESET"`
+	Workers       int            `json:"workers" env:"VIDEO_WORKERS"`
+	ExtractTracks bool           `json:"extract_tracks" env:"VIDEO_EXTRACT_TRACKS"`
+	SpriteSeconds int            `json:"sprite_seconds" env:"VIDEO_SPRITE_SECONDS"`
+	Presets       []video.Preset `json:"presets"`
+}
+
+// AudioConfig converts audio assets on request, with the video
+// settings' ffmpeg, when Convert is set. Normalized variants are brought
+// to LoudnessLUFS, measured as EBU R128 does.
+type AudioConfig struct {
+	Convert      bool `json:"convert" env:"AUDIO_CONVERT"`
+	LoudnessLUFS int  `json:"loudness_lufs" env:"AUDIO_LOUDNESS_LUFS"`
+}
+
+// DocumentsConfig converts office documents to PDF on request with
+// Converter: "libreoffice" runs LibreOfficePath headless, "http" posts
+// them to ConverterURL (such as Gotenberg's LibreOffice route), and ""
+// serves them as uploaded.
+type DocumentsConfig struct {
+	Converter       string `json:"converter" env:"DOCUMENTS_CONVERTER"`
+	LibreOfficePath string `json:"libreoffice_path" env:"DOCUMENTS_LIBREOFFICE_PATH"`
+	ConverterURL    string `json:"converter_url" env:"DOCUMENTS_CONVERTER_URL"`
+}
+
+// SearchConfig indexes the text of PDF, plain text, CSV and JSON assets
+// for full-text search when ExtractText is set, up to MaxTextBytes of
+// each. Assets are found by key either way.
+type SearchConfig struct {
+	ExtractText  bool `json:"extract_text" env:"SEARCH_EXTRACT_TEXT"`
+	MaxTextBytes int  `json:"max_text_bytes" env:"SEARCH_MAX_TEXT_BYTES"`
+}
+
+// MetricsConfig tunes the metrics served at /v1/admin/metrics. A
+// response waiting longer than StallSeconds on the client or on R2 is
+// logged and counted as a stall.
+type MetricsConfig struct {
+	StallSeconds int `json:"stall_seconds" env:"METRICS_STALL_SECONDS"`
+}
+
+// MirrorConfig sends Percent of asset reads also to the deployment at
+// URL, such as one on a new storage backend or version, and compares its
+// responses with this instance's, which alone reach clients. At most
+// Concurrency shadow requests run at once; reads beyond that aren't
+// mirrored.
+type MirrorConfig struct {
+	URL            string `json:"url" env:"MIRROR_URL"`
+	Percent        int    `json:"percent" env:"MIRROR_PERCENT"`
+	TimeoutSeconds int    `json:"timeout_seconds" env:"MIRROR_TIMEOUT_SECONDS"`
+	Concurrency    int    `json:"concurrency" env:"MIRROR_CONCURRENCY"`
+}
+
+// ChaosConfig injects latency and errors into requests, for testing
+// clients' retries and circuit breakers in staging. Rules are settable in
+// the file only, and Enabled in the environment only, so a config file
+// copied from staging never turns faults on.
+type ChaosConfig struct {
+	Enabled bool        `json:"-" env:"CHAOS_ENABLED"`
+	Rules   []ChaosRule `json:"rules"`
+}
+
+// ChaosRule delays the requests whose path starts with Prefix, with one
+// of Methods when given, by LatencyMS plus up to JitterMS, and fails
+// ErrorPercent of them with ErrorStatus (503 by default)
+type ChaosRule struct {
+	Prefix       string   `json:"prefix"`
+	Methods      []string `json:"methods"`
+	LatencyMS    int      `json:"latency_ms"`
+	JitterMS     int      `json:"jitter_ms"`
+	ErrorPercent int      `json:"error_percent"`
+	ErrorStatus  int      `json:"error_status"`
+}
+
+// APIConfig announces the retirement of /v1 in the headers of its JSON
+// API responses, once /v2 is ready for clients to move to. Dates are
+// RFC 3339, such as 2027-01-01T00:00:00Z; MigrationURL is a page on
+// moving to /v2.
+type APIConfig struct {
+	V1Deprecated string `json:"v1_deprecated" env:"API_V1_DEPRECATED"`
+	V1Sunset     string `json:"v1_sunset" env:"API_V1_SUNSET"`
+	MigrationURL string `json:"migration_url" env:"API_MIGRATION_URL"`
+}
+
+// V1Dates returns V1Deprecated and V1Sunset parsed, each zero when unset
+func (a APIConfig) V1Dates() (deprecated, sunset time.Time, err error) {
+	if a.V1Deprecated != "" {
+		if deprecated, err = time.Parse(time.RFC3339, a.V1Deprecated); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("api.v1_deprecated: %w", err)
+		}
+	}
+	if a.V1Sunset != "" {
+		if sunset, err = time.Parse(time.RFC3339, a.V1Sunset); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("api.v1_sunset: %w", err)
+		}
+	}
+	return deprecated, sunset, nil
+}
+
+// TrashConfig moves deleted objects under .trash/ in the bucket, where
+// admins can restore them, rather than deleting them. The trash_purge
+// job deletes them for good RetentionDays later; 0 keeps them forever.
+type TrashConfig struct {
+	Enabled       bool `json:"enabled" env:"TRASH_ENABLED"`
+	RetentionDays int  `json:"retention_days" env:"TRASH_RETENTION_DAYS"`
+}
+
+// LifecycleConfig expires objects by prefix when the lifecycle job runs.
+// GeneratedDays expires the images rendered from request parameters,
+// under generated/, which every new set of parameters adds to; 0 keeps
+// them.
+type LifecycleConfig struct {
+	Rules         []LifecycleRule `json:"rules"`
+	GeneratedDays int             `json:"generated_days" env:"LIFECYCLE_GENERATED_DAYS"`
+}
+
+// LifecycleRule expires the objects under Prefix last written more than
+// Days ago
+type LifecycleRule struct {
+	Prefix string `json:"prefix"`
+	Days   int    `json:"days"`
+}
+
+// BucketEventsConfig consumes the bucket's R2 event notifications from a
+// Cloudflare Queue (pulled with the R2 account ID and Cloudflare API
+// token), so objects written by other systems are indexed and announced
+type BucketEventsConfig struct {
+	QueueID string `json:"queue_id" env:"R2_EVENTS_QUEUE_ID"`
+}
+
+// UploadsConfig controls who may upload and how files are written.
+// RequireToken rejects uploads without a token from POST
+// /v1/media/upload-token. Files over PartBytes are written to R2 as
+// multipart uploads of PartBytes parts, Concurrency at a time.
+// KeyStrategy names uploads that don't pick a strategy themselves: hash,
+// uuid, timestamp or filename.
+type UploadsConfig struct {
+	RequireToken bool   `json:"require_token" env:"UPLOAD_REQUIRE_TOKEN"`
+	KeyStrategy  string `json:"key_strategy" env:"UPLOAD_KEY_STRATEGY"`
+	// MaxChunkedBytes limits a file assembled from chunks through
+	// /v1/media/upload/chunk
+	MaxChunkedBytes int `json:"max_chunked_bytes" env:"UPLOAD_CHUNKED_MAX_BYTES"`
+	PartBytes       int `json:"part_bytes" env:"UPLOAD_PART_BYTES"`
+	Concurrency     int `json:"concurrency" env:"UPLOAD_CONCURRENCY"`
+}
+
+// HTMLConfig governs HTML assets, which a browser would otherwise run
+// with the rights of the origin serving them. Uploads accepts .html and
+// .htm files through the upload APIs. HTML is served with the CSP
+// header, which must sandbox it, and from Origin when set: a separate,
+// cookieless domain pointed at this service, such as
+// https://usercontent.example.com. HTML requests arriving on other hosts
+// are redirected there.
+type HTMLConfig struct {
+	Uploads bool   `json:"uploads" env:"HTML_UPLOADS"`
+	Origin  string `json:"origin" env:"HTML_ORIGIN"`
+	CSP     string `json:"csp" env:"HTML_CSP"`
+}
+
+// URLsConfig sets the URLs handed out for assets. PublicBaseURL is where
+// the edge serves public assets by key. Requests arriving on one of
+// Hosts, other domains routed to the service, get URLs on that host
+// instead. Domains (settable in the file only) give the keys under a
+// prefix, such as a tenant's, URLs on their own domain, which serves the
+// same paths as PublicBaseURL.
+type URLsConfig struct {
+	PublicBaseURL string      `json:"public_base_url" env:"PUBLIC_BASE_URL"`
+	Hosts         []string    `json:"hosts" env:"PUBLIC_HOSTS"`
+	Domains       []URLDomain `json:"domains"`
+}
+
+// URLDomain serves the keys under Prefix from BaseURL, such as
+// https://assets.example.com
+type URLDomain struct {
+	Prefix  string `json:"prefix"`
+	BaseURL string `json:"base_url"`
+}
+
+// RegionsConfig runs the service as one of several regional deployments
+// sharing a bucket and signing secret. Hosts (settable in the file only)
+// lists every region's public base URL and the countries it serves, and
+// Region names this instance's. Signed URLs and manifests point clients
+// at the region serving the ISO 3166 country code that the fronting
+// proxy puts in CountryHeader, and at this instance's region otherwise.
+type RegionsConfig struct {
+	Region        string       `json:"region" env:"REGION"`
+	CountryHeader string       `json:"country_header" env:"REGION_COUNTRY_HEADER"`
+	Hosts         []RegionHost `json:"hosts"`
+}
+
+// RegionHost is the public base URL of a region, such as
+// https://eu.cdn.example.com
+type RegionHost struct {
+	Name      string   `json:"name"`
+	BaseURL   string   `json:"base_url"`
+	Countries []string `json:"countries"`
+}
+
+// WellKnownConfig serves files that crawlers and tools fetch from fixed
+// paths, such as /robots.txt and /.well-known/security.txt, which would
+// otherwise 404. Files are settable in the file only.
+type WellKnownConfig struct {
+	Files []WellKnownFile `json:"files"`
+}
+
+// WellKnownFile is served at Path, at the root or under /.well-known/
+type WellKnownFile struct {
+	Path string `json:"path"`
+	// Body is served as is, as ContentType (by default from Path's
+	// extension, and text/plain otherwise)
+	Body        string `json:"body"`
+	ContentType string `json:"content_type"`
+	// Key serves an object from the bucket instead
+	Key string `json:"key"`
+}
+
+// isOrigin reports whether s is an http or https scheme and host, with
+// nothing after but an optional /
+func isOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && strings.Trim(u.Path, "/") == "" && u.RawQuery == ""
+}
+
+// sandboxes reports whether a Content-Security-Policy has a sandbox
+// directive
+func sandboxes(policy string) bool {
+	for _, directive := range strings.Split(policy, ";") {
+		if fields := strings.Fields(directive); len(fields) > 0 && strings.EqualFold(fields[0], "sandbox") {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptionConfig seals objects under Prefix with per-object data keys
+// wrapped by a master key. MasterKeys are "id:base64key" pairs of 32-byte
+// keys; the first wraps new data keys and the rest, kept after a
+// rotation, only unwrap. Without master keys nothing is encrypted.
+type EncryptionConfig struct {
+	Prefix     string   `json:"prefix" env:"ENCRYPTION_PREFIX"`
+	MasterKeys []string `json:"master_keys" env:"ENCRYPTION_MASTER_KEYS"`
+}
+
+// JobsConfig schedules the background jobs with cron expressions, in
+// UTC; an empty schedule disables a job
+type JobsConfig struct {
+	// RateLimitCleanup forgets idle clients of the upload rate limiter
+	RateLimitCleanup string `json:"rate_limit_cleanup" env:"JOB_RATE_LIMIT_CLEANUP"`
+	// Reconcile walks the bucket to bring the metadata index in line
+	Reconcile string `json:"reconcile" env:"JOB_RECONCILE"`
+	// MultipartGC aborts multipart uploads incomplete for over a day
+	MultipartGC string `json:"multipart_gc" env:"JOB_MULTIPART_GC"`
+	// IntegrityAudit reads back a batch of objects and checks their ETags
+	IntegrityAudit string `json:"integrity_audit" env:"JOB_INTEGRITY_AUDIT"`
+	// Inventory writes a CSV of the index to inventory/<date>.csv
+	Inventory string `json:"inventory" env:"JOB_INVENTORY"`
+	// Publish purges and announces assets whose scheduled publish or
+	// unpublish time has passed
+	Publish string `json:"publish" env:"JOB_PUBLISH"`
+	// Lifecycle expires objects by the lifecycle rules
+	Lifecycle string `json:"lifecycle" env:"JOB_LIFECYCLE"`
+	// TrashPurge deletes objects kept in the trash past its retention
+	TrashPurge string `json:"trash_purge" env:"JOB_TRASH_PURGE"`
+}
+
+// Schedules returns the configured schedule of every job by name
+func (j JobsConfig) Schedules() map[string]string {
+	return map[string]string{
+		"rate_limit_cleanup": j.RateLimitCleanup,
+		"reconcile":          j.Reconcile,
+		"multipart_gc":       j.MultipartGC,
+		"integrity_audit":    j.IntegrityAudit,
+		"inventory":          j.Inventory,
+		"publish":            j.Publish,
+		"lifecycle":          j.Lifecycle,
+		"trash_purge":        j.TrashPurge,
+	}
+}
+
+type RateLimitConfig struct {
+	UploadPerMinute int `json:"upload_per_minute" env:"UPLOAD_RATE_LIMIT"`
+	UploadBurst     int `json:"upload_burst" env:"UPLOAD_RATE_BURST"`
+}
+
+type CacheConfig struct {
+	PublicCacheControl  string      `json:"public_cache_control"`
+	PrivateCacheControl string      `json:"private_cache_control"`
+	Rules               []CacheRule `json:"rules"`
+}
+
+// CacheRule overrides the public Cache-Control header for keys under Prefix.
+// The longest matching prefix wins.
+type CacheRule struct {
+	Prefix       string `json:"prefix"`
+	CacheControl string `json:"cache_control"`
+}
+
+// CacheControlFor returns the Cache-Control header for a public key
+func (c CacheConfig) CacheControlFor(key string) string {
+	best, bestLen := c.PublicCacheControl, -1
+	for _, rule := range c.Rules {
+		if strings.HasPrefix(key, rule.Prefix) && len(rule.Prefix) > bestLen {
+			best, bestLen = rule.CacheControl, len(rule.Prefix)
+		}
+	}
+	return best
+}
+
+// ListingConfig enables browsable directory listings for public prefixes.
+// A request for a key ending in "/" serves its index.html if there is
+// one, and otherwise a listing when the key is under one of Prefixes.
+type ListingConfig struct {
+	Prefixes []string `json:"prefixes" env:"LISTING_PREFIXES"`
+}
+
+// Enabled reports whether the directory dir may be listed
+func (c ListingConfig) Enabled(dir string) bool {
+	for _, p := range c.Prefixes {
+		if strings.HasPrefix(dir, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectConfig holds redirect and rewrite rules for public asset paths,
+// read from a _redirects-style File and/or listed inline. TrailingSlash
+// is "add", "remove" or empty to leave paths alone.
+type RedirectConfig struct {
+	File          string         `json:"file" env:"REDIRECTS_FILE"`
+	TrailingSlash string         `json:"trailing_slash" env:"REDIRECTS_TRAILING_SLASH"`
+	Rules         []RedirectRule `json:"rules"`
+}
+
+// RedirectRule redirects (301, 302, 307, 308) or rewrites (200) requests
+// matching From. Status defaults to 301.
+type RedirectRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
+// HeadersConfig holds extra response headers for assets, read from a
+// _headers-style File and/or listed inline
+type HeadersConfig struct {
+	File  string       `json:"file" env:"HEADERS_FILE"`
+	Rules []HeaderRule `json:"rules"`
+}
+
+// HeaderRule sets Headers on responses for asset paths matching For,
+// such as /fonts/* or *.html
+type HeaderRule struct {
+	For     string            `json:"for"`
+	Headers map[string]string `json:"headers"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Port:         "8080",
+		AppVersion:   "1.0.0",
+		StartupCheck: "strict",
+		Server: ServerConfig{
+			SocketMode:          "0660",
+			DrainTimeoutSeconds: 60,
+			Timeouts: TimeoutConfig{
+				ReadHeaderSeconds: 10,
+				IdleSeconds:       60,
+				APISeconds:        15,
+				UploadSeconds:     600,
+				AssetSeconds:      3600,
+			},
+			Limits: LimitConfig{
+				MaxHeaderBytes:   1 << 20,
+				MaxJSONBodyBytes: 1 << 20,
+				MaxUploadBytes:   100 << 20,
+			},
+			TLS: TLSConfig{
+				AutocertCacheDir: "data/autocert",
+				HTTPPort:         "80",
+			},
+		},
+		Data: DataConfig{
+			IndexPath:       "data/index.json",
+			UsagePath:       "data/usage.json",
+			CollectionsPath: "data/collections.json",
+			DeploysPath:     "data/deploys.json",
+			DomainsPath:     "data/domains.json",
+			AuditLogPath:    "data/audit.ndjson",
+		},
+		Sentry: SentryConfig{
+			Environment: "production",
+		},
+		S3: S3Config{
+			Bucket: "cdn",
+		},
+		DAV: DAVConfig{
+			Username: "cdn",
+		},
+		Downloads: DownloadConfig{
+			PartBytes:   8 << 20,
+			Concurrency: 4,
+		},
+		Encryption: EncryptionConfig{
+			Prefix: "secure/",
+		},
+		Uploads: UploadsConfig{
+			KeyStrategy:     "hash",
+			MaxChunkedBytes: 5 << 30,
+			PartBytes:       8 << 20,
+			Concurrency:     4,
+		},
+		HTML: HTMLConfig{
+			CSP: "sandbox",
+		},
+		URLs: URLsConfig{
+			PublicBaseURL: "https://cdn.mikeodnis.dev",
+		},
+		Regions: RegionsConfig{
+			CountryHeader: "CF-IPCountry",
+		},
+		Jobs: JobsConfig{
+			RateLimitCleanup: "*/5 * * * *",
+			MultipartGC:      "0 3 * * *",
+			Publish:          "* * * * *",
+			Lifecycle:        "0 4 * * *",
+			TrashPurge:       "30 4 * * *",
+		},
+		Trash: TrashConfig{
+			RetentionDays: 30,
+		},
+		Lifecycle: LifecycleConfig{
+			GeneratedDays: 30,
+		},
+		QoS: QoSConfig{
+			StandardPercent: 90,
+			BulkPercent:     60,
+		},
+		Transforms: TransformsConfig{
+			Workers:          4,
+			Queue:            32,
+			QueueWaitSeconds: 10,
+		},
+		Images: ImagesConfig{
+			Engine:    "auto",
+			Color:     "srgb",
+			MaxWidth:  4096,
+			MaxHeight: 4096,
+			MaxPixels: 3840 * 2160,
+			Formats:   []string{"jpeg", "png", "gif", "webp", "avif"},
+		},
+		Video: VideoConfig{
+			FFmpegPath:    "ffmpeg",
+			FFprobePath:   "ffprobe",
+			DefaultPreset: "standard",
+			Workers:       1,
+			ExtractTracks: true,
+			SpriteSeconds: 10,
+			Presets:       video.DefaultPresets(),
+		},
+		Audio: AudioConfig{
+			LoudnessLUFS: audio.DefaultLoudness,
+		},
+		Documents: DocumentsConfig{
+			LibreOfficePath: "soffice",
+		},
+		Search: SearchConfig{
+			MaxTextBytes: 64 << 10,
+		},
+		Metrics: MetricsConfig{
+			StallSeconds: 10,
+		},
+		Mirror: MirrorConfig{
+			Percent:        10,
+			TimeoutSeconds: 10,
+			Concurrency:    16,
+		},
+		CORS: CORSConfig{
+			AssetOrigins:   []string{"*"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Range", "If-None-Match", "If-Match", "X-Requested-With", "X-Encryption-Key", "X-Upload-Token"},
+			ExposedHeaders: []string{"ETag", "Content-Length", "Content-Range", "Accept-Ranges", "Retry-After", "Digest", "X-Amz-Meta-Sha256", "X-Request-ID", "Deprecation", "Sunset", "Link"},
+			MaxAgeSeconds:  600,
+		},
+		RateLimit: RateLimitConfig{
+			UploadPerMinute: 10,
+			UploadBurst:     20,
+		},
+		Cache: CacheConfig{
+			PublicCacheControl:  "public, max-age=31536000, immutable",
+			PrivateCacheControl: "private, max-age=3600",
+		},
+	}
+}
+
+// Load reads the config file at path (if non-empty) over the defaults,
+// applies environment overrides, and validates the result
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("unsupported config file type %q (want .yaml, .toml or .json)", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+
+	// Decode through JSON so one set of struct tags covers every format
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode config: %w", err)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(encoded)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid config in %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides fields tagged with env from non-empty environment
+// variables, recursing into nested structs
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := field.Tag.Get("env")
+		raw := os.Getenv(name)
+		if name == "" || raw == "" {
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			value.SetString(raw)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("%s must be a boolean", name)
+			}
+			value.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer", name)
+			}
+			value.SetInt(int64(n))
+		case reflect.Slice:
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			if field.Type.Elem().Kind() != reflect.Int {
+				value.Set(reflect.ValueOf(items))
+				continue
+			}
+			ints := make([]int, len(items))
+			for i, item := range items {
+				n, err := strconv.Atoi(item)
+				if err != nil {
+					return fmt.Errorf("%s must be a comma-separated list of integers", name)
+				}
+				ints[i] = n
+			}
+			value.Set(reflect.ValueOf(ints))
+		}
+	}
+	return nil
+}
+
+// Validate checks that everything required to serve traffic is present
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.R2.AccessKeyID == "" || c.R2.SecretAccessKey == "" {
+		problems = append(problems, "R2 credentials are required (R2_ACCESS_KEY_ID, R2_SECRET_ACCESS_KEY)")
+	}
+	if c.R2.BucketName == "" {
+		problems = append(problems, "R2 bucket name is required (R2_BUCKET_NAME)")
+	}
+	if c.R2.Endpoint == "" {
+		problems = append(problems, "R2 endpoint is required (R2_ENDPOINT)")
+	}
+	if c.SigningSecret == "" {
+		problems = append(problems, "signing secret is required (SIGNING_SECRET)")
+	}
+	if cf := c.Cloudflare; (cf.OffloadImages || cf.OffloadVideos) && (c.R2.AccountID == "" || cf.APIToken == "") {
+		problems = append(problems, "cloudflare: offloading requires an account ID and API token (R2_ACCOUNT_ID, CLOUDFLARE_API_TOKEN)")
+	}
+	schedules := c.Jobs.Schedules()
+	names := make([]string, 0, len(schedules))
+	for name := range schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if spec := schedules[name]; spec != "" {
+			if _, err := scheduler.Parse(spec); err != nil {
+				problems = append(problems, fmt.Sprintf("jobs.%s: %v", name, err))
+			}
+		}
+	}
+	if c.BucketEvents.QueueID != "" && (c.R2.AccountID == "" || c.Cloudflare.APIToken == "") {
+		problems = append(problems, "bucket_events: the queue requires an account ID and API token (R2_ACCOUNT_ID, CLOUDFLARE_API_TOKEN)")
+	}
+	if len(c.Encryption.MasterKeys) > 0 {
+		if _, err := envelope.NewKeyring(c.Encryption.MasterKeys); err != nil {
+			problems = append(problems, fmt.Sprintf("encryption: %v", err))
+		}
+		if !strings.HasSuffix(c.Encryption.Prefix, "/") {
+			problems = append(problems, fmt.Sprintf("encryption.prefix must end with /, got %q", c.Encryption.Prefix))
+		}
+	}
+	switch c.StartupCheck {
+	case "strict", "warn", "off":
+	default:
+		problems = append(problems, fmt.Sprintf("startup_check must be strict, warn or off, got %q", c.StartupCheck))
+	}
+	if _, err := strconv.ParseUint(c.Server.SocketMode, 8, 32); err != nil {
+		problems = append(problems, fmt.Sprintf("server.socket_mode must be an octal file mode, got %q", c.Server.SocketMode))
+	}
+	t := c.Server.Timeouts
+	if t.ReadHeaderSeconds < 1 || t.IdleSeconds < 1 || t.APISeconds < 1 || t.UploadSeconds < 1 || t.AssetSeconds < 0 {
+		problems = append(problems, "server.timeouts must be positive (asset_seconds may be 0 for no limit)")
+	}
+	l := c.Server.Limits
+	if l.MaxHeaderBytes < 1 || l.MaxJSONBodyBytes < 1 || l.MaxUploadBytes < 1 {
+		problems = append(problems, "server.limits must be positive")
+	}
+	switch c.Uploads.KeyStrategy {
+	case "hash", "uuid", "timestamp", "filename":
+	default:
+		problems = append(problems, "uploads.key_strategy must be hash, uuid, timestamp or filename")
+	}
+	if c.Uploads.MaxChunkedBytes < 1 {
+		problems = append(problems, "uploads.max_chunked_bytes must be positive")
+	}
+	if c.Uploads.PartBytes < storage.MinPartSize || c.Uploads.Concurrency < 1 {
+		problems = append(problems, fmt.Sprintf("uploads: part_bytes must be at least %d (R2's smallest part) and concurrency positive", storage.MinPartSize))
+	}
+	if c.HTML.Uploads && c.HTML.Origin == "" {
+		problems = append(problems, "html.uploads requires html.origin, a separate cookieless domain to serve HTML from")
+	}
+	if c.HTML.Origin != "" && !isOrigin(c.HTML.Origin) {
+		problems = append(problems, fmt.Sprintf("html.origin must be a scheme and host such as https://usercontent.example.com, got %q", c.HTML.Origin))
+	}
+	if !isOrigin(c.URLs.PublicBaseURL) {
+		problems = append(problems, fmt.Sprintf("urls.public_base_url must be a scheme and host such as https://cdn.example.com, got %q", c.URLs.PublicBaseURL))
+	}
+	for _, host := range c.URLs.Hosts {
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			problems = append(problems, fmt.Sprintf("urls.hosts must be bare hostnames such as cdn.example.org, got %q", host))
+		}
+	}
+	for _, d := range c.URLs.Domains {
+		if d.Prefix == "" || strings.HasPrefix(d.Prefix, "/") || !strings.HasSuffix(d.Prefix, "/") || !isOrigin(d.BaseURL) {
+			problems = append(problems, fmt.Sprintf("urls.domains entries require a relative prefix ending in / and a base_url with a scheme and host, got %q %q", d.Prefix, d.BaseURL))
+		}
+	}
+	if !sandboxes(c.HTML.CSP) {
+		problems = append(problems, fmt.Sprintf("html.csp must include a sandbox directive, got %q", c.HTML.CSP))
+	}
+	paths := make(map[string]bool)
+	for _, f := range c.WellKnown.Files {
+		name, ok := strings.CutPrefix(f.Path, "/.well-known/")
+		if !ok {
+			name, ok = strings.CutPrefix(f.Path, "/")
+			ok = ok && !strings.Contains(name, "/")
+		}
+		if !ok || name == "" || strings.HasSuffix(name, "/") || strings.Contains(name, "..") {
+			problems = append(problems, fmt.Sprintf("well_known.files paths must be at the root or under /.well-known/, such as /robots.txt, got %q", f.Path))
+		}
+		if paths[f.Path] {
+			problems = append(problems, fmt.Sprintf("well-known file %s is defined twice", f.Path))
+		}
+		paths[f.Path] = true
+		if (f.Body == "") == (f.Key == "") {
+			problems = append(problems, fmt.Sprintf("well-known file %s requires either a body or a key", f.Path))
+		}
+		// RFC 9116 requires both fields
+		if f.Path == "/.well-known/security.txt" && f.Body != "" && (!strings.Contains(f.Body, "Contact:") || !strings.Contains(f.Body, "Expires:")) {
+			problems = append(problems, "well-known file /.well-known/security.txt requires Contact: and Expires: fields")
+		}
+	}
+	if r := c.Regions; len(r.Hosts) > 0 {
+		if r.CountryHeader == "" {
+			problems = append(problems, "regions.country_header is required with regions.hosts")
+		}
+		names, countries := make(map[string]bool), make(map[string]string)
+		for _, h := range r.Hosts {
+			if h.Name == "" || !isOrigin(h.BaseURL) {
+				problems = append(problems, fmt.Sprintf("regions.hosts entries require a name and a base_url with a scheme and host such as https://eu.cdn.example.com, got %q", h.BaseURL))
+			}
+			if names[h.Name] {
+				problems = append(problems, fmt.Sprintf("region %q is defined twice", h.Name))
+			}
+			names[h.Name] = true
+			for _, cc := range h.Countries {
+				cc = strings.ToUpper(cc)
+				if len(cc) != 2 {
+					problems = append(problems, fmt.Sprintf("region %q: countries must be two-letter codes, got %q", h.Name, cc))
+				} else if other, ok := countries[cc]; ok {
+					problems = append(problems, fmt.Sprintf("country %s is served by both region %q and %q", cc, other, h.Name))
+				}
+				countries[cc] = h.Name
+			}
+		}
+		if !names[r.Region] {
+			problems = append(problems, fmt.Sprintf("regions.region must name one of regions.hosts, got %q", r.Region))
+		}
+	}
+	if c.CORS.AllowCredentials {
+		for _, origins := range [][]string{c.CORS.UploadOrigins, c.CORS.APIOrigins} {
+			for _, o := range origins {
+				if o == "*" {
+					problems = append(problems, "cors: allow_credentials requires explicit origins, not *")
+				}
+			}
+		}
+	}
+	if c.Server.DrainTimeoutSeconds < 0 {
+		problems = append(problems, "server.drain_timeout_seconds must not be negative")
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		problems = append(problems, "server.tls.cert_file and key_file must be set together")
+	}
+	if c.Server.TLS.CertFile != "" && len(c.Server.TLS.AutocertHosts) > 0 {
+		problems = append(problems, "server.tls: use either certificate files or autocert_hosts, not both")
+	}
+	if c.Server.HTTP3 && (!c.Server.TLS.Enabled() || strings.HasPrefix(c.Server.Listen, "unix:") || strings.HasPrefix(c.Server.Listen, "systemd")) {
+		problems = append(problems, "server.http3 requires server.tls and a host:port listen address")
+	}
+	if c.RateLimit.UploadPerMinute < 1 || c.RateLimit.UploadBurst < 1 {
+		problems = append(problems, "rate_limit values must be positive")
+	}
+	if c.Events.Broker != "" && c.Events.Broker != "nats" && c.Events.Broker != "kafka" {
+		problems = append(problems, fmt.Sprintf("events.broker must be nats or kafka, got %q", c.Events.Broker))
+	}
+	switch c.Events.SASL {
+	case "", "plain", "scram-sha-256", "scram-sha-512":
+		if c.Events.SASL != "" && (c.Events.Broker != "kafka" || c.Events.Username == "") {
+			problems = append(problems, "events.sasl requires the kafka broker and events.username")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("events.sasl must be plain, scram-sha-256 or scram-sha-512, got %q", c.Events.SASL))
+	}
+	for _, ep := range c.Webhooks.Endpoints {
+		if ep.URL == "" {
+			problems = append(problems, "webhooks.endpoints entries require a url")
+		}
+	}
+	if c.DAV.Password != "" && c.DAV.Username == "" {
+		problems = append(problems, "dav.username is required when a password is set")
+	}
+	if d := c.Downloads; d.ParallelThresholdBytes < 0 || d.ParallelThresholdBytes > 0 && (d.PartBytes < 1 || d.Concurrency < 1) {
+		problems = append(problems, "downloads: parallel_threshold_bytes must not be negative, and part_bytes and concurrency must be positive")
+	}
+	if q := c.QoS; q.MaxConcurrent < 0 || q.BulkPercent < 0 || q.BulkPercent > q.StandardPercent || q.StandardPercent > 100 {
+		problems = append(problems, "qos: max_concurrent must not be negative, and 0 <= bulk_percent <= standard_percent <= 100")
+	}
+	if t := c.Transforms; t.Workers < 1 || t.Queue < 0 || t.QueueWaitSeconds < 1 {
+		problems = append(problems, "transforms: workers and queue_wait_seconds must be positive, and queue must not be negative")
+	}
+	switch c.Images.Engine {
+	case "auto", "vips", "go", "off":
+	default:
+		problems = append(problems, "images.engine must be auto, vips, go or off")
+	}
+	if col := c.Images.Color; col != "srgb" && col != "preserve" {
+		problems = append(problems, "images.color must be srgb or preserve")
+	}
+	if i := c.Images; i.MaxWidth < 1 || i.MaxHeight < 1 || i.MaxPixels < 1 {
+		problems = append(problems, "images: max_width, max_height and max_pixels must be positive")
+	}
+	for _, f := range c.Images.Formats {
+		switch f {
+		case "jpeg", "png", "gif", "webp", "avif":
+		default:
+			problems = append(problems, fmt.Sprintf("images.formats must be jpeg, png, gif, webp or avif, got %q", f))
+		}
+	}
+	for _, q := range c.Images.Qualities {
+		if q < 1 || q > 100 {
+			problems = append(problems, fmt.Sprintf("images.qualities must be between 1 and 100, got %d", q))
+		}
+	}
+	if c.Video.Workers < 1 || c.Video.SpriteSeconds < 0 {
+		problems = append(problems, "video: workers must be positive, and sprite_seconds must not be negative")
+	}
+	presets := make(map[string]bool)
+	for _, p := range c.Video.Presets {
+		if err := p.Validate(); err != nil {
+			problems = append(problems, "video: "+err.Error())
+		} else if presets[p.Name] {
+			problems = append(problems, fmt.Sprintf("video preset %q is defined twice", p.Name))
+		}
+		presets[p.Name] = true
+	}
+	if !presets[c.Video.DefaultPreset] {
+		problems = append(problems, fmt.Sprintf("video.default_preset %q is not a defined preset", c.Video.DefaultPreset))
+	}
+	if c.Audio.LoudnessLUFS < -70 || c.Audio.LoudnessLUFS > -5 {
+		problems = append(problems, fmt.Sprintf("audio.loudness_lufs must be between -70 and -5, got %d", c.Audio.LoudnessLUFS))
+	}
+	switch c.Documents.Converter {
+	case "", "libreoffice":
+	case "http":
+		if c.Documents.ConverterURL == "" {
+			problems = append(problems, "documents.converter_url is required for the http converter")
+		}
+	default:
+		problems = append(problems, "documents.converter must be libreoffice, http or empty")
+	}
+	// Every indexed asset's text is held in memory and in the snapshot
+	if c.Search.MaxTextBytes < 1 || c.Search.MaxTextBytes > 1<<20 {
+		problems = append(problems, fmt.Sprintf("search.max_text_bytes must be between 1 and %d, got %d", 1<<20, c.Search.MaxTextBytes))
+	}
+	if c.Metrics.StallSeconds < 1 {
+		problems = append(problems, "metrics.stall_seconds must be positive")
+	}
+	if m := c.Mirror; m.URL != "" {
+		if !isOrigin(m.URL) {
+			problems = append(problems, fmt.Sprintf("mirror.url must be a scheme and host such as https://cdn-next.internal, got %q", m.URL))
+		}
+		if m.Percent < 0 || m.Percent > 100 {
+			problems = append(problems, fmt.Sprintf("mirror.percent must be between 0 and 100, got %d", m.Percent))
+		}
+		if m.TimeoutSeconds < 1 || m.Concurrency < 1 {
+			problems = append(problems, "mirror: timeout_seconds and concurrency must be positive")
+		}
+	}
+	if deprecated, sunset, err := c.API.V1Dates(); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		if !sunset.IsZero() && (deprecated.IsZero() || !sunset.After(deprecated)) {
+			problems = append(problems, "api.v1_sunset must come after api.v1_deprecated")
+		}
+		if c.API.MigrationURL != "" && deprecated.IsZero() {
+			problems = append(problems, "api.migration_url is sent only with api.v1_deprecated, which is unset")
+		}
+	}
+	if m := c.API.MigrationURL; m != "" {
+		if u, err := url.Parse(m); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("api.migration_url must be an absolute http(s) URL, got %q", m))
+		}
+	}
+	for _, r := range c.Chaos.Rules {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			problems = append(problems, fmt.Sprintf("chaos.rules prefixes must be request paths such as /v1/media/, got %q", r.Prefix))
+		}
+		if r.LatencyMS < 0 || r.JitterMS < 0 || r.ErrorPercent < 0 || r.ErrorPercent > 100 {
+			problems = append(problems, fmt.Sprintf("chaos rule %s: latency_ms and jitter_ms must not be negative, and error_percent must be between 0 and 100", r.Prefix))
+		}
+		if r.ErrorStatus != 0 && (r.ErrorStatus < 400 || r.ErrorStatus > 599) {
+			problems = append(problems, fmt.Sprintf("chaos rule %s: error_status must be a 4xx or 5xx status, got %d", r.Prefix, r.ErrorStatus))
+		}
+	}
+	if c.Trash.RetentionDays < 0 {
+		problems = append(problems, fmt.Sprintf("trash.retention_days must not be negative, got %d", c.Trash.RetentionDays))
+	}
+	if c.Lifecycle.GeneratedDays < 0 {
+		problems = append(problems, fmt.Sprintf("lifecycle.generated_days must not be negative, got %d", c.Lifecycle.GeneratedDays))
+	}
+	for _, r := range c.Lifecycle.Rules {
+		if r.Prefix == "" || strings.HasPrefix(r.Prefix, "/") {
+			problems = append(problems, fmt.Sprintf("lifecycle.rules prefixes must be relative and not empty, got %q", r.Prefix))
+		}
+		if r.Days < 1 {
+			problems = append(problems, fmt.Sprintf("lifecycle rule %s: days must be at least 1, got %d", r.Prefix, r.Days))
+		}
+	}
+	for _, p := range c.Listing.Prefixes {
+		if p == "" || strings.HasPrefix(p, "/") || !strings.HasSuffix(p, "/") {
+			problems = append(problems, fmt.Sprintf("listing.prefixes must be relative and end in /, got %q", p))
+		}
+	}
+	if ts := c.Redirects.TrailingSlash; ts != "" && ts != "add" && ts != "remove" {
+		problems = append(problems, fmt.Sprintf("redirects.trailing_slash must be add or remove, got %q", ts))
+	}
+	if c.GRPC.Listen != "" && c.GRPC.Token == "" {
+		problems = append(problems, "grpc.listen requires grpc.token (GRPC_TOKEN)")
+	}
+	if c.S3.Listen != "" {
+		keys := c.S3.AllKeys()
+		if c.S3.Bucket == "" || strings.Contains(c.S3.Bucket, "/") {
+			problems = append(problems, fmt.Sprintf("s3.bucket must be a non-empty name without slashes, got %q", c.S3.Bucket))
+		}
+		if len(keys) == 0 {
+			problems = append(problems, "s3 requires at least one access key (S3_ACCESS_KEY_ID or s3.keys)")
+		}
+		seen := make(map[string]bool)
+		for _, k := range keys {
+			switch {
+			case k.AccessKeyID == "" || k.SecretAccessKey == "":
+				problems = append(problems, "s3 keys require both an access_key_id and a secret_access_key")
+			case seen[k.AccessKeyID]:
+				problems = append(problems, fmt.Sprintf("s3 access key %q is defined twice", k.AccessKeyID))
+			case k.MaxObjectBytes < 0 || k.QuotaBytes < 0:
+				problems = append(problems, fmt.Sprintf("s3 access key %q: limits must not be negative", k.AccessKeyID))
+			case k.Priority != "" && k.Priority != "interactive" && k.Priority != "standard" && k.Priority != "bulk":
+				problems = append(problems, fmt.Sprintf("s3 access key %q: priority must be interactive, standard or bulk, got %q", k.AccessKeyID, k.Priority))
+			}
+			seen[k.AccessKeyID] = true
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
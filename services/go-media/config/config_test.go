@@ -0,0 +1,308 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/video"
+)
+
+const yamlConfig = `
+# Example service config
+port: "9090"
+signing_secret: 'file-secret'
+r2:
+  access_key_id: key
+  secret_access_key: secret
+  bucket_name: media
+  endpoint: https://example.r2.cloudflarestorage.com
+webhooks:
+  urls: [https://a.example/hook, "https://b.example/hook"]
+  endpoints:
+    - url: https://c.example/hook
+      events:
+        - asset.uploaded
+rate_limit: # reloadable
+  upload_per_minute: 30 # per client
+cache:
+  rules:
+    - prefix: assets/
+      cache_control: public, max-age=60
+`
+
+const tomlConfig = `
+port = "9090"
+signing_secret = "file-secret"
+
+[r2]
+access_key_id = "key"
+secret_access_key = "secret"
+bucket_name = "media"
+endpoint = "https://example.r2.cloudflarestorage.com"
+
+[webhooks]
+urls = [
+  "https://a.example/hook",
+  "https://b.example/hook",
+]
+
+[[webhooks.endpoints]]
+url = "https://c.example/hook"
+events = ["asset.uploaded"]
+
+[rate_limit]
+upload_per_minute = 30 # per client
+
+[[cache.rules]]
+prefix = "assets/"
+cache_control = "public, max-age=60"
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"PORT", "SIGNING_SECRET", "R2_ACCESS_KEY_ID", "R2_SECRET_ACCESS_KEY", "R2_BUCKET_NAME", "R2_ENDPOINT", "WEBHOOK_URLS", "UPLOAD_RATE_LIMIT", "UPLOAD_RATE_BURST", "EVENT_BROKER", "S3_LISTEN", "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY", "LISTING_PREFIXES", "REDIRECTS_TRAILING_SLASH", "PARALLEL_DOWNLOAD_THRESHOLD_BYTES", "PARALLEL_DOWNLOAD_CONCURRENCY", "QOS_MAX_CONCURRENT", "QOS_STANDARD_PERCENT", "QOS_BULK_PERCENT", "CLOUDFLARE_API_TOKEN", "CLOUDFLARE_OFFLOAD_IMAGES", "R2_EVENTS_QUEUE_ID", "JOB_RATE_LIMIT_CLEANUP", "JOB_RECONCILE", "JOB_MULTIPART_GC", "JOB_INTEGRITY_AUDIT", "JOB_INVENTORY", "ENCRYPTION_PREFIX", "ENCRYPTION_MASTER_KEYS", "UPLOAD_REQUIRE_TOKEN", "UPLOAD_CHUNKED_MAX_BYTES", "UPLOAD_PART_BYTES", "UPLOAD_CONCURRENCY", "METRICS_STALL_SECONDS", "UPLOAD_KEY_STRATEGY", "TRANSFORM_WORKERS", "TRANSFORM_QUEUE", "TRANSFORM_QUEUE_WAIT_SECONDS", "IMAGE_ENGINE", "IMAGE_MAX_WIDTH", "IMAGE_MAX_HEIGHT", "IMAGE_MAX_PIXELS", "IMAGE_FORMATS", "IMAGE_QUALITIES", "IMAGE_REQUIRE_SIGNATURE", "IMAGE_COLOR", "IMAGE_AUTO_FOCUS", "VIDEO_TRANSCODE", "VIDEO_DEFAULT_PRESET", "VIDEO_WORKERS", "VIDEO_EXTRACT_TRACKS", "VIDEO_SPRITE_SECONDS", "AUDIO_CONVERT", "AUDIO_LOUDNESS_LUFS", "DOCUMENTS_CONVERTER", "DOCUMENTS_CONVERTER_URL", "SEARCH_EXTRACT_TEXT", "SEARCH_MAX_TEXT_BYTES", "JOB_PUBLISH", "JOB_LIFECYCLE", "HTML_UPLOADS", "HTML_ORIGIN", "HTML_CSP", "REGION", "REGION_COUNTRY_HEADER", "PUBLIC_BASE_URL", "PUBLIC_HOSTS", "MIRROR_URL", "MIRROR_PERCENT", "MIRROR_TIMEOUT_SECONDS", "MIRROR_CONCURRENCY", "CHAOS_ENABLED", "API_V1_DEPRECATED", "API_V1_SUNSET", "API_MIGRATION_URL", "TRASH_ENABLED", "TRASH_RETENTION_DAYS", "JOB_TRASH_PURGE", "EVENT_BROKER_USERNAME", "EVENT_BROKER_PASSWORD", "EVENT_BROKER_SASL", "EVENT_BROKER_TLS", "EVENT_BROKER_CA_FILE", "HTTP3", "GRPC_LISTEN", "GRPC_TOKEN", "LIFECYCLE_GENERATED_DAYS"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	for _, tt := range []struct{ name, content string }{
+		{"config.yaml", yamlConfig},
+		{"config.toml", tomlConfig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			cfg, err := Load(writeConfig(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if cfg.Port != "9090" || cfg.SigningSecret != "file-secret" {
+				t.Errorf("Port, SigningSecret = %q, %q", cfg.Port, cfg.SigningSecret)
+			}
+			if cfg.R2.BucketName != "media" {
+				t.Errorf("R2.BucketName = %q, want media", cfg.R2.BucketName)
+			}
+			if len(cfg.Webhooks.URLs) != 2 || cfg.Webhooks.URLs[1] != "https://b.example/hook" {
+				t.Errorf("Webhooks.URLs = %v", cfg.Webhooks.URLs)
+			}
+			if len(cfg.Webhooks.Endpoints) != 1 || cfg.Webhooks.Endpoints[0].Events[0] != "asset.uploaded" {
+				t.Errorf("Webhooks.Endpoints = %+v", cfg.Webhooks.Endpoints)
+			}
+			if cfg.RateLimit.UploadPerMinute != 30 || cfg.RateLimit.UploadBurst != 20 {
+				t.Errorf("RateLimit = %+v, want 30/20 (burst defaulted)", cfg.RateLimit)
+			}
+			if got := cfg.Cache.CacheControlFor("assets/a.png"); got != "public, max-age=60" {
+				t.Errorf("CacheControlFor(assets/a.png) = %q", got)
+			}
+			if got := cfg.Cache.CacheControlFor("docs/a.pdf"); got != "public, max-age=31536000, immutable" {
+				t.Errorf("CacheControlFor(docs/a.pdf) = %q", got)
+			}
+		})
+	}
+}
+
+func TestLoadYAMLSyntax(t *testing.T) {
+	clearEnv(t)
+	// Flow mappings and block scalars are YAML like any other
+	cfg, err := Load(writeConfig(t, "config.yaml", yamlConfig+`
+regions: {country_header: X-Country, hosts: []}
+well_known:
+  files:
+    - path: /robots.txt
+      body: |
+        User-agent: *
+        Disallow: /v1/
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Regions.CountryHeader != "X-Country" {
+		t.Errorf("Regions.CountryHeader = %q, want X-Country", cfg.Regions.CountryHeader)
+	}
+	if files := cfg.WellKnown.Files; len(files) != 1 || files[0].Body != "User-agent: *\nDisallow: /v1/\n" {
+		t.Errorf("WellKnown.Files = %+v", files)
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("SIGNING_SECRET", "env-secret")
+	t.Setenv("WEBHOOK_URLS", "https://x.example, https://y.example")
+	t.Setenv("UPLOAD_RATE_BURST", "5")
+
+	cfg, err := Load(writeConfig(t, "config.yaml", yamlConfig))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.SigningSecret != "env-secret" {
+		t.Errorf("SigningSecret = %q, want env-secret", cfg.SigningSecret)
+	}
+	if len(cfg.Webhooks.URLs) != 2 || cfg.Webhooks.URLs[0] != "https://x.example" {
+		t.Errorf("Webhooks.URLs = %v", cfg.Webhooks.URLs)
+	}
+	if cfg.RateLimit.UploadBurst != 5 {
+		t.Errorf("UploadBurst = %d, want 5", cfg.RateLimit.UploadBurst)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		want    string
+	}{
+		{name: "missing required", file: "c.json", content: `{}`, want: "R2 credentials are required"},
+		{name: "unknown field", file: "c.yaml", content: "prot: 80\n", want: "unknown field"},
+		{name: "bad broker", file: "c.yaml", content: yamlConfig + "events:\n  broker: rabbit\n", want: "events.broker"},
+		{name: "grpc without token", file: "c.yaml", content: yamlConfig + "grpc:\n  listen: \":9090\"\n", want: "grpc.listen requires grpc.token"},
+		{name: "http3 without tls", file: "c.yaml", content: yamlConfig + "server:\n  http3: true\n", want: "server.http3"},
+		{name: "bad sasl", file: "c.yaml", content: yamlConfig + "events:\n  broker: kafka\n  username: cdn\n  sasl: gssapi\n", want: "events.sasl"},
+		{name: "sasl with nats", file: "c.yaml", content: yamlConfig + "events:\n  broker: nats\n  username: cdn\n  sasl: plain\n", want: "events.sasl requires"},
+		{name: "bad env int", file: "c.yaml", content: yamlConfig, env: map[string]string{"UPLOAD_RATE_LIMIT": "lots"}, want: "UPLOAD_RATE_LIMIT must be an integer"},
+		{name: "tls cert without key", file: "c.yaml", content: yamlConfig + "server:\n  tls:\n    cert_file: /etc/cdn.pem\n", want: "must be set together"},
+		{name: "s3 without keys", file: "c.yaml", content: yamlConfig + "s3:\n  listen: :9000\n", want: "at least one access key"},
+		{name: "s3 duplicate key", file: "c.yaml", content: yamlConfig + "s3:\n  listen: :9000\n  keys:\n    - access_key_id: a\n      secret_access_key: x\n    - access_key_id: a\n      secret_access_key: y\n", want: "defined twice"},
+		{name: "absolute listing prefix", file: "c.yaml", content: yamlConfig, env: map[string]string{"LISTING_PREFIXES": "/downloads/"}, want: "listing.prefixes"},
+		{name: "bad trailing slash policy", file: "c.yaml", content: yamlConfig, env: map[string]string{"REDIRECTS_TRAILING_SLASH": "always"}, want: "redirects.trailing_slash"},
+		{name: "parallel downloads without concurrency", file: "c.yaml", content: yamlConfig, env: map[string]string{"PARALLEL_DOWNLOAD_THRESHOLD_BYTES": "67108864", "PARALLEL_DOWNLOAD_CONCURRENCY": "0"}, want: "downloads:"},
+		{name: "bulk share above standard", file: "c.yaml", content: yamlConfig, env: map[string]string{"QOS_STANDARD_PERCENT": "50", "QOS_BULK_PERCENT": "80"}, want: "qos:"},
+		{name: "no transform workers", file: "c.yaml", content: yamlConfig, env: map[string]string{"TRANSFORM_WORKERS": "0"}, want: "transforms:"},
+		{name: "unknown image engine", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_ENGINE": "magick"}, want: "images.engine"},
+		{name: "unknown image color", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_COLOR": "p3"}, want: "images.color"},
+		{name: "non-boolean auto focus", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_AUTO_FOCUS": "faces"}, want: "IMAGE_AUTO_FOCUS"},
+		{name: "negative sprite interval", file: "c.yaml", content: yamlConfig, env: map[string]string{"VIDEO_SPRITE_SECONDS": "-5"}, want: "sprite_seconds"},
+		{name: "unknown video preset", file: "c.yaml", content: yamlConfig, env: map[string]string{"VIDEO_DEFAULT_PRESET": "4k"}, want: "default_preset"},
+		{name: "positive loudness", file: "c.yaml", content: yamlConfig, env: map[string]string{"AUDIO_LOUDNESS_LUFS": "16"}, want: "loudness_lufs"},
+		{name: "unknown document converter", file: "c.yaml", content: yamlConfig, env: map[string]string{"DOCUMENTS_CONVERTER": "pandoc"}, want: "documents.converter"},
+		{name: "http converter without URL", file: "c.yaml", content: yamlConfig, env: map[string]string{"DOCUMENTS_CONVERTER": "http"}, want: "converter_url"},
+		{name: "oversized search text", file: "c.yaml", content: yamlConfig, env: map[string]string{"SEARCH_MAX_TEXT_BYTES": "4194304"}, want: "max_text_bytes"},
+		{name: "zero image width", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_MAX_WIDTH": "0"}, want: "max_width"},
+		{name: "unknown image format", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_FORMATS": "jpeg,bmp"}, want: "images.formats"},
+		{name: "image quality out of range", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_QUALITIES": "60,101"}, want: "images.qualities"},
+		{name: "non-integer image quality", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_QUALITIES": "60,high"}, want: "IMAGE_QUALITIES"},
+		{name: "non-boolean image signature", file: "c.yaml", content: yamlConfig, env: map[string]string{"IMAGE_REQUIRE_SIGNATURE": "sometimes"}, want: "IMAGE_REQUIRE_SIGNATURE"},
+		{name: "offload without api token", file: "c.yaml", content: yamlConfig, env: map[string]string{"CLOUDFLARE_OFFLOAD_IMAGES": "true"}, want: "offloading requires"},
+		{name: "events queue without api token", file: "c.yaml", content: yamlConfig, env: map[string]string{"R2_EVENTS_QUEUE_ID": "q1"}, want: "bucket_events:"},
+		{name: "bad master key", file: "c.yaml", content: yamlConfig, env: map[string]string{"ENCRYPTION_MASTER_KEYS": "v1:c2hvcnQ="}, want: "encryption: master key \"v1\""},
+		{name: "unknown key strategy", file: "c.yaml", content: yamlConfig, env: map[string]string{"UPLOAD_KEY_STRATEGY": "sha1"}, want: "uploads.key_strategy"},
+		{name: "zero chunked upload limit", file: "c.yaml", content: yamlConfig, env: map[string]string{"UPLOAD_CHUNKED_MAX_BYTES": "0"}, want: "uploads.max_chunked_bytes"},
+		{name: "upload parts below R2 minimum", file: "c.yaml", content: yamlConfig, env: map[string]string{"UPLOAD_PART_BYTES": "1048576"}, want: "uploads: part_bytes"},
+		{name: "html uploads without origin", file: "c.yaml", content: yamlConfig, env: map[string]string{"HTML_UPLOADS": "true"}, want: "html.uploads requires html.origin"},
+		{name: "html origin with path", file: "c.yaml", content: yamlConfig, env: map[string]string{"HTML_ORIGIN": "https://usercontent.example.com/pages"}, want: "html.origin"},
+		{name: "html policy without sandbox", file: "c.yaml", content: yamlConfig, env: map[string]string{"HTML_CSP": "default-src 'none'"}, want: "html.csp"},
+		{name: "public base url with path", file: "c.yaml", content: yamlConfig, env: map[string]string{"PUBLIC_BASE_URL": "https://cdn.example.com/assets"}, want: "urls.public_base_url"},
+		{name: "public host with scheme", file: "c.yaml", content: yamlConfig, env: map[string]string{"PUBLIC_HOSTS": "https://static.example.org"}, want: "urls.hosts"},
+		{name: "domain prefix without slash", file: "c.yaml", content: yamlConfig + "urls:\n  domains:\n    - prefix: tenants/acme\n      base_url: https://assets.acme.test\n", want: "urls.domains"},
+		{name: "region without base url", file: "c.yaml", content: yamlConfig + "regions:\n  region: eu\n  hosts:\n    - name: eu\n      countries: [DE]\n", want: "regions.hosts entries require"},
+		{name: "country in two regions", file: "c.yaml", content: yamlConfig + "regions:\n  region: eu\n  hosts:\n    - name: eu\n      base_url: https://eu.cdn.example.com\n      countries: [DE, FR]\n    - name: us\n      base_url: https://us.cdn.example.com\n      countries: [us, de]\n", want: "country DE is served by both"},
+		{name: "unlisted instance region", file: "c.yaml", content: yamlConfig + "regions:\n  hosts:\n    - name: eu\n      base_url: https://eu.cdn.example.com\n", want: "regions.region"},
+		{name: "nested well-known path", file: "c.yaml", content: yamlConfig + "well_known:\n  files:\n    - path: /docs/robots.txt\n      body: x\n", want: "well_known.files paths"},
+		{name: "well-known file with body and key", file: "c.yaml", content: yamlConfig + "well_known:\n  files:\n    - path: /robots.txt\n      body: x\n      key: site/robots.txt\n", want: "either a body or a key"},
+		{name: "security.txt without expiry", file: "c.yaml", content: yamlConfig + "well_known:\n  files:\n    - path: /.well-known/security.txt\n      body: \"Contact: mailto:security@example.com\"\n", want: "Contact: and Expires:"},
+		{name: "zero stall threshold", file: "c.yaml", content: yamlConfig, env: map[string]string{"METRICS_STALL_SECONDS": "0"}, want: "metrics.stall_seconds"},
+		{name: "mirror percent above 100", file: "c.yaml", content: yamlConfig, env: map[string]string{"MIRROR_URL": "https://cdn-next.internal", "MIRROR_PERCENT": "150"}, want: "mirror.percent"},
+		{name: "chaos enabled in file", file: "c.yaml", content: yamlConfig + "chaos:\n  enabled: true\n", want: "unknown field"},
+		{name: "chaos rule with success status", file: "c.yaml", content: yamlConfig + "chaos:\n  rules:\n    - prefix: /v1/media/\n      error_percent: 5\n      error_status: 200\n", want: "error_status"},
+		{name: "v1 deprecation not RFC 3339", file: "c.yaml", content: yamlConfig, env: map[string]string{"API_V1_DEPRECATED": "2027-01-01"}, want: "api.v1_deprecated"},
+		{name: "v1 sunset before deprecation", file: "c.yaml", content: yamlConfig, env: map[string]string{"API_V1_DEPRECATED": "2027-01-01T00:00:00Z", "API_V1_SUNSET": "2026-06-01T00:00:00Z"}, want: "api.v1_sunset must come after"},
+		{name: "bad job schedule", file: "c.yaml", content: yamlConfig, env: map[string]string{"JOB_RECONCILE": "0 25 * * *"}, want: "jobs.reconcile: cron expression"},
+		{name: "lifecycle rule without prefix", file: "c.yaml", content: yamlConfig + "lifecycle:\n  rules:\n    - days: 30\n", want: "lifecycle.rules prefixes"},
+		{name: "lifecycle rule without days", file: "c.yaml", content: yamlConfig + "lifecycle:\n  rules:\n    - prefix: tmp/\n", want: "days must be at least 1"},
+		{name: "negative generated expiry", file: "c.yaml", content: yamlConfig, env: map[string]string{"LIFECYCLE_GENERATED_DAYS": "-1"}, want: "lifecycle.generated_days"},
+		{name: "negative trash retention", file: "c.yaml", content: yamlConfig, env: map[string]string{"TRASH_RETENTION_DAYS": "-1"}, want: "trash.retention_days"},
+		{name: "bad publish schedule", file: "c.yaml", content: yamlConfig, env: map[string]string{"JOB_PUBLISH": "every minute"}, want: "jobs.publish"},
+		{name: "unsupported type", file: "c.ini", content: "", want: "unsupported config file type"},
+		{name: "bad yaml indent", file: "c.yaml", content: "r2:\n  bucket_name: a\n    endpoint: b\n", want: "line 3"},
+		{name: "bad toml value", file: "c.toml", content: "port = nope\n", want: "line 1"},
+		{name: "duplicate yaml key", file: "c.yaml", content: yamlConfig + "port: \"80\"\n", want: "already defined"},
+		{name: "unknown field in flow mapping", file: "c.yaml", content: yamlConfig + "server: {prot: 80}\n", want: "unknown field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(writeConfig(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, "config.yaml", yamlConfig)
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	var notified *Config
+	store.OnReload(func(c *Config) { notified = c })
+
+	updated := strings.Replace(yamlConfig, "upload_per_minute: 30", "upload_per_minute: 60", 1)
+	updated = strings.Replace(updated, `port: "9090"`, `port: "7070"`, 1)
+	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	cfg := store.Current()
+	if cfg.RateLimit.UploadPerMinute != 60 {
+		t.Errorf("UploadPerMinute = %d, want 60", cfg.RateLimit.UploadPerMinute)
+	}
+	if cfg.Port != "9090" {
+		t.Errorf("Port = %q, structural settings should not reload", cfg.Port)
+	}
+	if notified != cfg {
+		t.Error("OnReload listener was not called with the new config")
+	}
+
+	// An invalid file keeps the current configuration
+	if err := os.WriteFile(path, []byte("rate_limit:\n  upload_per_minute: 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Error("Reload() should fail for an invalid file")
+	}
+	if store.Current() != cfg {
+		t.Error("failed reload replaced the configuration")
+	}
+}
+
+func TestExampleConfigIsValid(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load("../config.example.yaml")
+	if err != nil {
+		t.Fatalf("config.example.yaml: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Video.Presets, video.DefaultPresets()) {
+		t.Errorf("example video presets differ from the defaults: %+v", cfg.Video.Presets)
+	}
+	// The inline file is a block scalar, as the README documents
+	if files := cfg.WellKnown.Files; len(files) != 1 || files[0].Body != "User-agent: *\nDisallow: /v1/\n" {
+		t.Errorf("example well-known files = %+v", files)
+	}
+}
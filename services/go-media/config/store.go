@@ -0,0 +1,84 @@
+package config
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the active configuration and swaps in reloadable settings
+// when the config file changes
+type Store struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	mu        sync.Mutex
+	listeners []func(*Config)
+}
+
+// NewStore loads and validates the configuration at path
+func NewStore(path string) (*Store, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path}
+	s.current.Store(cfg)
+	return s, nil
+}
+
+// Current returns the active configuration. Callers must not modify it.
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// OnReload registers fn to be called with the new configuration after
+// every successful reload
+func (s *Store) OnReload(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+}
+
+// Reload re-reads the config file and environment. Only the reloadable
+// sections (rate_limit, cache, listing, redirects) take effect; changes to anything else are
+// reported and ignored until the next restart. An invalid file leaves
+// the active configuration untouched.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded, err := Load(s.path)
+	if err != nil {
+		return err
+	}
+
+	old := s.Current()
+	next := *old
+	next.RateLimit = loaded.RateLimit
+	next.Cache = loaded.Cache
+	next.Listing = loaded.Listing
+	next.Redirects = loaded.Redirects
+	next.Headers = loaded.Headers
+
+	// Compare structural settings with the reloadable ones masked out
+	masked := *loaded
+	masked.RateLimit = old.RateLimit
+	masked.Cache = old.Cache
+	masked.Listing = old.Listing
+	masked.Redirects = old.Redirects
+	masked.Headers = old.Headers
+	if !reflect.DeepEqual(&masked, old) {
+		log.Println("Config: structural settings changed; restart required for them to take effect")
+	}
+
+	s.current.Store(&next)
+	for _, fn := range s.listeners {
+		fn(&next)
+	}
+
+	return nil
+}
@@ -0,0 +1,528 @@
+package dav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// dirContentType marks the empty objects that represent directories
+	// created with MKCOL; other directories exist implicitly
+	dirContentType = "application/x-directory"
+
+	// statTTL is how long directory listings answer Stat calls. PROPFIND
+	// stats every entry it lists, which would otherwise cost one HEAD each.
+	statTTL = 5 * time.Second
+
+	// maxDirEntries caps a single directory listing
+	maxDirEntries = 10000
+
+	// maxRemoveObjects bounds the objects deleting one directory removes,
+	// as for DELETE /v1/media/prefix/
+	maxRemoveObjects = 1000
+)
+
+var (
+	errFileTooLarge  = errors.New("file exceeds the upload size limit")
+	errReadOnlyFile  = errors.New("file is open for reading")
+	errWriteOnlyFile = errors.New("file is open for writing")
+	errDirTooLarge   = fmt.Errorf("directory holds more than %d objects; delete it with DELETE /v1/media/prefix/", maxRemoveObjects)
+)
+
+// Store is the subset of asset operations the file system is built on;
+// *handlers.MediaHandler implements it
+type Store interface {
+	ListPage(ctx context.Context, opts storage.ListOptions) (*storage.ListPage, error)
+	HeadAsset(ctx context.Context, key string) (*s3.HeadObjectOutput, error)
+	OpenAssetRange(ctx context.Context, key, byteRange string) (*s3.GetObjectOutput, error)
+	StoreUpload(ctx context.Context, key, filename, contentType string, data []byte) (*handlers.UploadResponse, error)
+	StoreUploadIfMatch(ctx context.Context, key, filename, contentType, ifMatch string, data []byte) (*handlers.UploadResponse, error)
+	RemoveAsset(ctx context.Context, key string) error
+	RecordServed(key string, n int64)
+}
+
+// fileSystem maps WebDAV paths onto bucket keys. Directories are key
+// prefixes ending in "/"; files are buffered in memory while written.
+type fileSystem struct {
+	store       Store
+	maxFileSize int64
+
+	mu    sync.Mutex
+	cache map[string]cachedInfo
+}
+
+type cachedInfo struct {
+	info    *fileInfo
+	expires time.Time
+}
+
+var _ webdav.FileSystem = (*fileSystem)(nil)
+
+// toKey turns a WebDAV path into an object key ("" for the root)
+func toKey(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (fsys *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	key := toKey(name)
+	if key == "" {
+		return os.ErrExist
+	}
+	if handlers.ValidateKey(key+"/") != nil {
+		return os.ErrPermission
+	}
+	if _, err := fsys.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	}
+	if parent, err := fsys.Stat(ctx, path.Dir("/"+key)); err != nil || !parent.IsDir() {
+		return os.ErrNotExist
+	}
+
+	if _, err := fsys.store.StoreUpload(ctx, key+"/", path.Base(key), dirContentType, nil); err != nil {
+		return err
+	}
+	fsys.forget(key)
+	return nil
+}
+
+func (fsys *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	key := toKey(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		if handlers.ValidateKey(key) != nil {
+			return nil, os.ErrPermission
+		}
+		if info, err := fsys.Stat(ctx, name); err == nil && info.IsDir() {
+			return nil, os.ErrPermission
+		}
+		return &writeFile{fsys: fsys, ctx: ctx, key: key}, nil
+	}
+
+	info, err := fsys.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if info.dir {
+		return &dirFile{fsys: fsys, ctx: ctx, key: key, info: info}, nil
+	}
+	return &readFile{fsys: fsys, ctx: ctx, key: key, info: info}, nil
+}
+
+func (fsys *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	key := toKey(name)
+	if handlers.ValidateKey(key) != nil {
+		return os.ErrPermission
+	}
+
+	info, err := fsys.stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer fsys.forget(key)
+
+	if !info.dir {
+		return fsys.store.RemoveAsset(ctx, key)
+	}
+	// Larger directories take the prefix API's dry run and confirmation
+	keys, err := fsys.listAll(ctx, key+"/", maxRemoveObjects)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := fsys.store.RemoveAsset(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename copies objects to their new keys and removes the originals;
+// object storage has no native rename
+func (fsys *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, newKey := toKey(oldName), toKey(newName)
+	if handlers.ValidateKey(oldKey) != nil || handlers.ValidateKey(newKey) != nil || strings.HasPrefix(newKey+"/", oldKey+"/") {
+		return os.ErrPermission
+	}
+
+	info, err := fsys.stat(ctx, oldKey)
+	if err != nil {
+		return err
+	}
+	defer fsys.forget(oldKey)
+	defer fsys.forget(newKey)
+
+	if !info.dir {
+		return fsys.move(ctx, oldKey, newKey)
+	}
+	keys, err := fsys.listAll(ctx, oldKey+"/", 0)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := fsys.move(ctx, k, newKey+strings.TrimPrefix(k, oldKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fsys *fileSystem) move(ctx context.Context, from, to string) error {
+	obj, err := fsys.store.OpenAssetRange(ctx, from, "")
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj.Body, fsys.maxFileSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > fsys.maxFileSize {
+		return errFileTooLarge
+	}
+
+	if _, err := fsys.store.StoreUpload(ctx, to, path.Base(to), aws.ToString(obj.ContentType), data); err != nil {
+		return err
+	}
+	return fsys.store.RemoveAsset(ctx, from)
+}
+
+func (fsys *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fsys.stat(ctx, toKey(name))
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (fsys *fileSystem) stat(ctx context.Context, key string) (*fileInfo, error) {
+	if key == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+	if info := fsys.cached(key); info != nil {
+		return info, nil
+	}
+
+	out, err := fsys.store.HeadAsset(ctx, key)
+	if err == nil {
+		return &fileInfo{
+			name:        path.Base(key),
+			size:        aws.ToInt64(out.ContentLength),
+			modTime:     aws.ToTime(out.LastModified),
+			contentType: aws.ToString(out.ContentType),
+			etag:        aws.ToString(out.ETag),
+		}, nil
+	}
+	if !storage.IsNotFound(err) {
+		return nil, err
+	}
+
+	page, err := fsys.store.ListPage(ctx, storage.ListOptions{Prefix: key + "/", MaxKeys: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Objects) > 0 || len(page.CommonPrefixes) > 0 {
+		return &fileInfo{name: path.Base(key), dir: true}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// readDir lists the direct children of the directory at key
+func (fsys *fileSystem) readDir(ctx context.Context, key string) ([]os.FileInfo, error) {
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+
+	var entries []os.FileInfo
+	opts := storage.ListOptions{Prefix: prefix, Delimiter: "/", MaxKeys: 1000}
+	for len(entries) < maxDirEntries {
+		page, err := fsys.store.ListPage(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.CommonPrefixes {
+			entries = append(entries, fsys.remember(&fileInfo{name: path.Base(p), dir: true}, strings.TrimSuffix(p, "/")))
+		}
+		for _, o := range page.Objects {
+			if o.Key == prefix {
+				continue // this directory's own marker
+			}
+			entries = append(entries, fsys.remember(&fileInfo{
+				name:    path.Base(o.Key),
+				size:    o.Size,
+				modTime: o.LastModified,
+				etag:    o.ETag,
+			}, o.Key))
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+	return entries, nil
+}
+
+// listAll returns every key under prefix, including directory markers.
+// With a limit, more keys than that is errDirTooLarge.
+func (fsys *fileSystem) listAll(ctx context.Context, prefix string, limit int) ([]string, error) {
+	var keys []string
+	opts := storage.ListOptions{Prefix: prefix, MaxKeys: 1000}
+	for {
+		page, err := fsys.store.ListPage(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Objects {
+			keys = append(keys, o.Key)
+		}
+		if limit > 0 && len(keys) > limit {
+			return nil, errDirTooLarge
+		}
+		if !page.IsTruncated {
+			return keys, nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+func (fsys *fileSystem) cached(key string) *fileInfo {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	c, ok := fsys.cache[key]
+	if !ok || time.Now().After(c.expires) {
+		return nil
+	}
+	return c.info
+}
+
+func (fsys *fileSystem) remember(info *fileInfo, key string) *fileInfo {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	now := time.Now()
+	if len(fsys.cache) >= maxDirEntries {
+		for k, c := range fsys.cache {
+			if now.After(c.expires) {
+				delete(fsys.cache, k)
+			}
+		}
+	}
+	if len(fsys.cache) < maxDirEntries {
+		fsys.cache[key] = cachedInfo{info: info, expires: now.Add(statTTL)}
+	}
+	return info
+}
+
+// forget drops cached entries for key and everything below it
+func (fsys *fileSystem) forget(key string) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	for k := range fsys.cache {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(fsys.cache, k)
+		}
+	}
+}
+
+// fileInfo describes an object or directory. It implements webdav's
+// ContentTyper and ETager so listings never read object content.
+type fileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	dir         bool
+	contentType string
+	etag        string
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.contentType != "" {
+		return fi.contentType, nil
+	}
+	if t := mime.TypeByExtension(path.Ext(fi.name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	if strings.HasPrefix(fi.etag, `"`) {
+		return fi.etag, nil
+	}
+	return `"` + fi.etag + `"`, nil
+}
+
+// readFile streams an object, issuing a ranged GET from the current
+// offset on the first Read after opening or seeking
+type readFile struct {
+	fsys   *fileSystem
+	ctx    context.Context
+	key    string
+	info   *fileInfo
+	offset int64
+	body   io.ReadCloser
+	served int64
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		obj, err := f.fsys.store.OpenAssetRange(f.ctx, f.key, fmt.Sprintf("bytes=%d-", f.offset))
+		if err != nil {
+			return 0, err
+		}
+		f.body = obj.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.served += int64(n)
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += f.offset
+	case io.SeekEnd:
+		pos += f.info.size
+	}
+	if pos < 0 {
+		return 0, os.ErrInvalid
+	}
+	if pos != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = pos
+	return pos, nil
+}
+
+func (f *readFile) Close() error {
+	if f.served > 0 {
+		f.fsys.store.RecordServed(f.key, f.served)
+	}
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *readFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *readFile) Stat() (fs.FileInfo, error)               { return f.info, nil }
+func (f *readFile) Write(p []byte) (int, error)              { return 0, errReadOnlyFile }
+
+// dirFile lists a directory on the first Readdir
+type dirFile struct {
+	fsys    *fileSystem
+	ctx     context.Context
+	key     string
+	info    *fileInfo
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (f *dirFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.listed {
+		entries, err := f.fsys.readDir(f.ctx, f.key)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *dirFile) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (f *dirFile) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (f *dirFile) Write(p []byte) (int, error)                  { return 0, os.ErrInvalid }
+func (f *dirFile) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+func (f *dirFile) Close() error                                 { return nil }
+
+// writeFile buffers content and stores it on Close
+type writeFile struct {
+	fsys *fileSystem
+	ctx  context.Context
+	key  string
+	buf  bytes.Buffer
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if int64(f.buf.Len()+len(p)) > f.fsys.maxFileSize {
+		return 0, errFileTooLarge
+	}
+	return f.buf.Write(p)
+}
+
+func (f *writeFile) Close() error {
+	contentType := mime.TypeByExtension(path.Ext(f.key))
+	cond := putConditionFrom(f.ctx)
+	if _, err := f.fsys.store.StoreUploadIfMatch(f.ctx, f.key, path.Base(f.key), contentType, cond.etag(), f.buf.Bytes()); err != nil {
+		if cond != nil && storage.IsPreconditionFailed(err) {
+			cond.failed = true
+		}
+		return err
+	}
+	f.fsys.forget(f.key)
+	return nil
+}
+
+func (f *writeFile) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: path.Base(f.key), size: int64(f.buf.Len()), modTime: time.Now()}, nil
+}
+
+func (f *writeFile) Read(p []byte) (int, error)                   { return 0, errWriteOnlyFile }
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) { return 0, errWriteOnlyFile }
+func (f *writeFile) Readdir(count int) ([]fs.FileInfo, error)     { return nil, os.ErrInvalid }
@@ -0,0 +1,124 @@
+// Package dav serves the bucket over WebDAV so it can be mounted as a
+// network drive. Authentication is left to the router.
+package dav
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"golang.org/x/net/webdav"
+)
+
+// auditActions are the audited WebDAV methods; everything else only reads
+var auditActions = map[string]string{
+	http.MethodPut:    "asset.upload",
+	http.MethodDelete: "asset.delete",
+	"MOVE":            "asset.move",
+	"COPY":            "asset.copy",
+	"MKCOL":           "asset.mkdir",
+}
+
+// Handler serves WebDAV requests under a path prefix
+type Handler struct {
+	dav      *webdav.Handler
+	fs       *fileSystem
+	auditLog *audit.Log
+	readOnly bool
+}
+
+// Option configures optional Handler behavior
+type Option func(*Handler)
+
+// WithAuditLog records writes, deletes, moves and copies
+func WithAuditLog(l *audit.Log) Option {
+	return func(h *Handler) {
+		h.auditLog = l
+	}
+}
+
+// WithReadOnly rejects every method that would modify the bucket
+func WithReadOnly(readOnly bool) Option {
+	return func(h *Handler) {
+		h.readOnly = readOnly
+	}
+}
+
+// WithMaxFileSize limits the size of files written (and moved) through
+// WebDAV, which are buffered in memory
+func WithMaxFileSize(n int64) Option {
+	return func(h *Handler) {
+		h.fs.maxFileSize = n
+	}
+}
+
+// NewHandler creates a WebDAV handler for paths under prefix (e.g. "/dav")
+func NewHandler(prefix string, store Store, opts ...Option) *Handler {
+	fsys := &fileSystem{store: store, maxFileSize: 100 << 20, cache: make(map[string]cachedInfo)}
+	h := &Handler{
+		fs: fsys,
+		dav: &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fsys,
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action, mutating := auditActions[r.Method]
+	if !mutating {
+		if h.readOnly && (r.Method == "PROPPATCH" || r.Method == "LOCK") {
+			http.Error(w, "WebDAV is read-only", http.StatusForbidden)
+			return
+		}
+		h.dav.ServeHTTP(w, r)
+		return
+	}
+
+	h.auditLog.Middleware(action)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		details := map[string]string{"transport": "webdav"}
+		if dest, err := url.Parse(r.Header.Get("Destination")); err == nil && dest.Path != "" {
+			details["destination"] = h.key(dest.Path)
+		}
+		audit.Annotate(r, h.key(r.URL.Path), details)
+
+		if h.readOnly {
+			http.Error(w, "WebDAV is read-only", http.StatusForbidden)
+			return
+		}
+		// webdav maps filesystem errors to a different status per method,
+		// so reserved keys are refused here as well as in the filesystem
+		for _, key := range []string{h.key(r.URL.Path), details["destination"]} {
+			if err := handlers.ValidateKey(key); errors.Is(err, handlers.ErrReservedKey) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		if v := r.Header.Get("If-Match"); v != "" && r.Method == http.MethodPut {
+			// Only overwrite the object the client last read
+			cond := &putCondition{ifMatch: v}
+			r = r.WithContext(withPutCondition(r.Context(), cond))
+			w = &preconditionWriter{ResponseWriter: w, cond: cond}
+		}
+		h.dav.ServeHTTP(w, r)
+	})).ServeHTTP(w, r)
+}
+
+// key returns the object key a request path refers to
+func (h *Handler) key(urlPath string) string {
+	return toKey(strings.TrimPrefix(urlPath, h.dav.Prefix))
+}
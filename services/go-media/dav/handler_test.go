@@ -0,0 +1,260 @@
+package dav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// memStore is an in-memory Store with S3 listing semantics
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memStore) ListPage(ctx context.Context, opts storage.ListOptions) (*storage.ListPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	page := &storage.ListPage{}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, opts.Prefix) {
+			continue
+		}
+		if opts.Delimiter != "" {
+			if i := strings.Index(k[len(opts.Prefix):], opts.Delimiter); i >= 0 {
+				p := k[:len(opts.Prefix)+i+1]
+				if !seen[p] {
+					seen[p] = true
+					page.CommonPrefixes = append(page.CommonPrefixes, p)
+				}
+				continue
+			}
+		}
+		page.Objects = append(page.Objects, storage.Object{Key: k, Size: int64(len(m.objects[k])), LastModified: time.Now()})
+	}
+	return page, nil
+}
+
+func (m *memStore) HeadAsset(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NotFound"}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data))), ETag: aws.String(etag(data)), LastModified: aws.Time(time.Now())}, nil
+}
+
+func (m *memStore) OpenAssetRange(ctx context.Context, key, byteRange string) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey"}
+	}
+	var start int
+	if byteRange != "" {
+		start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(byteRange, "bytes="), "-"))
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data[start:]))}, nil
+}
+
+func (m *memStore) StoreUpload(ctx context.Context, key, filename, contentType string, data []byte) (*handlers.UploadResponse, error) {
+	return m.StoreUploadIfMatch(ctx, key, filename, contentType, "", data)
+}
+
+func (m *memStore) StoreUploadIfMatch(ctx context.Context, key, filename, contentType, ifMatch string, data []byte) (*handlers.UploadResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.objects[key]; ifMatch != "" && (!ok || ifMatch != etag(current)) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	m.objects[key] = append([]byte(nil), data...)
+	return &handlers.UploadResponse{Key: key}, nil
+}
+
+func etag(data []byte) string {
+	return `"` + strconv.Itoa(len(data)) + `"`
+}
+
+func (m *memStore) RemoveAsset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStore) RecordServed(key string, n int64) {}
+
+func (m *memStore) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	store := &memStore{objects: map[string][]byte{"static/site.css": []byte("body{}")}}
+	h := NewHandler("/dav", store)
+
+	steps := []struct {
+		method, target, body string
+		headers              map[string]string
+		want                 int
+	}{
+		{"MKCOL", "/dav/designs", "", nil, http.StatusCreated},
+		{"MKCOL", "/dav/missing/child", "", nil, http.StatusConflict},
+		{"PUT", "/dav/designs/logo.svg", "<svg/>", nil, http.StatusCreated},
+		{"GET", "/dav/designs/logo.svg", "", nil, http.StatusOK},
+		{"PROPFIND", "/dav/", "", map[string]string{"Depth": "1"}, http.StatusMultiStatus},
+		{"MOVE", "/dav/designs", "", map[string]string{"Destination": "/dav/final"}, http.StatusCreated},
+		{"DELETE", "/dav/static", "", nil, http.StatusNoContent},
+		{"GET", "/dav/static/site.css", "", nil, http.StatusNotFound},
+	}
+
+	for _, s := range steps {
+		rec := do(t, h, s.method, s.target, s.body, s.headers)
+		if rec.Code != s.want {
+			t.Fatalf("%s %s = %d, want %d: %s", s.method, s.target, rec.Code, s.want, rec.Body.String())
+		}
+		switch {
+		case s.method == "GET" && s.want == http.StatusOK && rec.Body.String() != "<svg/>":
+			t.Errorf("GET body = %q, want <svg/>", rec.Body.String())
+		case s.method == "PROPFIND":
+			for _, href := range []string{"/dav/designs/", "/dav/static/"} {
+				if !strings.Contains(rec.Body.String(), href) {
+					t.Errorf("PROPFIND listing missing %s:\n%s", href, rec.Body.String())
+				}
+			}
+		}
+	}
+
+	want := []string{"final/", "final/logo.svg"}
+	if got := store.keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+func TestHandlerReadOnly(t *testing.T) {
+	store := &memStore{objects: map[string][]byte{"static/site.css": []byte("body{}")}}
+	h := NewHandler("/dav", store, WithReadOnly(true))
+
+	if rec := do(t, h, "GET", "/dav/static/site.css", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET = %d, want 200", rec.Code)
+	}
+	for _, method := range []string{"PUT", "DELETE", "MKCOL"} {
+		if rec := do(t, h, method, "/dav/static/site.css", "x", nil); rec.Code != http.StatusForbidden {
+			t.Errorf("%s = %d, want 403", method, rec.Code)
+		}
+	}
+	if len(store.keys()) != 1 {
+		t.Errorf("read-only handler modified the store: %v", store.keys())
+	}
+}
+
+func TestHandlerIfMatch(t *testing.T) {
+	store := &memStore{objects: map[string][]byte{"static/site.css": []byte("body{}")}}
+	h := NewHandler("/dav", store)
+
+	rec := do(t, h, "PUT", "/dav/static/site.css", "a{}", map[string]string{"If-Match": `"99"`})
+	if rec.Code != http.StatusPreconditionFailed || rec.Body.String() != "Precondition Failed" {
+		t.Errorf("stale If-Match = %d %q, want 412", rec.Code, rec.Body.String())
+	}
+	if got := string(store.objects["static/site.css"]); got != "body{}" {
+		t.Errorf("stale write replaced the object with %q", got)
+	}
+
+	rec = do(t, h, "PUT", "/dav/static/site.css", "a{}", map[string]string{"If-Match": etag([]byte("body{}"))})
+	if rec.Code != http.StatusCreated {
+		t.Errorf("current If-Match = %d, want 201", rec.Code)
+	}
+	if got := string(store.objects["static/site.css"]); got != "a{}" {
+		t.Errorf("object = %q, want a{}", got)
+	}
+}
+
+func TestHandlerDeleteLimit(t *testing.T) {
+	store := &memStore{objects: map[string][]byte{}}
+	for i := 0; i <= maxRemoveObjects; i++ {
+		store.objects["big/"+strconv.Itoa(i)] = []byte("x")
+	}
+	store.objects["small/a"] = []byte("x")
+	h := NewHandler("/dav", store)
+
+	if rec := do(t, h, "DELETE", "/dav/big", "", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE of %d objects = %d, want 405", maxRemoveObjects+1, rec.Code)
+	}
+	if n := len(store.keys()); n != maxRemoveObjects+2 {
+		t.Errorf("%d objects left, want all %d", n, maxRemoveObjects+2)
+	}
+	if rec := do(t, h, "DELETE", "/dav/small", "", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE of a small directory = %d, want 204", rec.Code)
+	}
+}
+
+func TestHandlerReservedKeys(t *testing.T) {
+	store := &memStore{objects: map[string][]byte{"logs/access.log": []byte("GET /"), "static/site.css": []byte("body{}")}}
+	h := NewHandler("/dav", store)
+
+	steps := []struct {
+		method, target string
+		headers        map[string]string
+	}{
+		{"PUT", "/dav/logs/fake.log", nil},
+		{"DELETE", "/dav/logs", nil},
+		{"MKCOL", "/dav/.trash", nil},
+		{"COPY", "/dav/static/site.css", map[string]string{"Destination": "/dav/_min/site.css"}},
+		{"MOVE", "/dav/static/site.css", map[string]string{"Destination": "/dav/derived/site.css"}},
+	}
+	for _, s := range steps {
+		if rec := do(t, h, s.method, s.target, "x", s.headers); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s = %d, want 403", s.method, s.target, rec.Code)
+		}
+	}
+	want := []string{"logs/access.log", "static/site.css"}
+	if got := store.keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
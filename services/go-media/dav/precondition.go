@@ -0,0 +1,62 @@
+package dav
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// putCondition carries a PUT's If-Match header to the file it writes,
+// and records whether the store refused the write because the object
+// had changed
+type putCondition struct {
+	ifMatch string
+	failed  bool
+}
+
+type putConditionKey struct{}
+
+func withPutCondition(ctx context.Context, cond *putCondition) context.Context {
+	return context.WithValue(ctx, putConditionKey{}, cond)
+}
+
+// putConditionFrom returns the condition on a write in ctx, if any
+func putConditionFrom(ctx context.Context) *putCondition {
+	cond, _ := ctx.Value(putConditionKey{}).(*putCondition)
+	return cond
+}
+
+// etag returns the ETag the write is conditional on, or "" for an
+// unconditional write
+func (c *putCondition) etag() string {
+	if c == nil {
+		return ""
+	}
+	return c.ifMatch
+}
+
+// preconditionWriter answers 412 Precondition Failed where the WebDAV
+// handler, which reports every failed write as 405, would answer 405
+// for a write refused by its If-Match
+type preconditionWriter struct {
+	http.ResponseWriter
+	cond     *putCondition
+	replaced bool
+}
+
+func (w *preconditionWriter) WriteHeader(status int) {
+	if w.cond.failed {
+		status, w.replaced = http.StatusPreconditionFailed, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *preconditionWriter) Write(p []byte) (int, error) {
+	if w.replaced {
+		// The handler's body is the text of the status it meant to send
+		w.replaced = false
+		io.WriteString(w.ResponseWriter, http.StatusText(http.StatusPreconditionFailed))
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
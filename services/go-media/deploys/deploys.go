@@ -0,0 +1,174 @@
+// Package deploys records numbered, immutable snapshots of a static site
+// and which of them is live, persisted as a JSON file.
+package deploys
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/fsutil"
+)
+
+var ErrNotFound = errors.New("deploy not found")
+
+// Deploy is one snapshot of the site, its files stored under
+// deploys/<ID>/
+type Deploy struct {
+	ID        int       `json:"id"`
+	Message   string    `json:"message,omitempty"`
+	Files     int       `json:"files"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	// Live is set on the deploy served at /latest/
+	Live bool `json:"live"`
+}
+
+// saved is the file's layout
+type saved struct {
+	Next    int      `json:"next"`
+	Live    int      `json:"live,omitempty"`
+	Deploys []Deploy `json:"deploys"`
+}
+
+// Store holds deploys by ID. Every change is written to its file before
+// it returns, so none is lost in a crash. An empty path keeps deploys in
+// memory only.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	next    int
+	live    int
+	deploys map[int]Deploy
+	now     func() time.Time
+}
+
+// Open loads the deploys saved at path, starting empty if it does not
+// exist
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		next:    1,
+		deploys: make(map[int]Deploy),
+		now:     time.Now,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	var file saved
+	found, err := fsutil.ReadJSON(path, &file)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		s.next, s.live = max(file.Next, 1), file.Live
+		for _, d := range file.Deploys {
+			s.deploys[d.ID] = d
+		}
+	}
+	return s, nil
+}
+
+// Reserve returns the ID for a new deploy, never handed out again even if
+// the deploy is not added
+func (s *Store) Reserve() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.next
+	s.next++
+	if err := s.save(); err != nil {
+		s.next--
+		return 0, err
+	}
+	return id, nil
+}
+
+// Add records d, whose files are in place, making it live if activate is
+// set
+func (s *Store) Add(d Deploy, activate bool) (Deploy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prevLive := s.live
+	d.CreatedAt = s.now().UTC()
+	d.Live = false
+	s.deploys[d.ID] = d
+	if activate {
+		s.live = d.ID
+	}
+	if err := s.save(); err != nil {
+		delete(s.deploys, d.ID)
+		s.live = prevLive
+		return Deploy{}, err
+	}
+	d.Live = s.live == d.ID
+	return d, nil
+}
+
+// Activate makes the deploy with id live, as a release or a rollback
+func (s *Store) Activate(id int) (Deploy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deploys[id]
+	if !ok {
+		return Deploy{}, ErrNotFound
+	}
+	prevLive := s.live
+	s.live = id
+	if err := s.save(); err != nil {
+		s.live = prevLive
+		return Deploy{}, err
+	}
+	d.Live = true
+	return d, nil
+}
+
+// Get returns the deploy with id
+func (s *Store) Get(id int) (Deploy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.deploys[id]
+	d.Live = ok && s.live == id
+	return d, ok
+}
+
+// Live returns the ID of the live deploy, and false when none is
+func (s *Store) Live() (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.live, s.live != 0
+}
+
+// List returns every deploy, newest first
+func (s *Store) List() []Deploy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Deploy, 0, len(s.deploys))
+	for _, d := range s.deploys {
+		d.Live = s.live == d.ID
+		list = append(list, d)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].ID > list[b].ID })
+	return list
+}
+
+// save writes the store to its file. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	file := saved{Next: s.next, Live: s.live, Deploys: make([]Deploy, 0, len(s.deploys))}
+	for _, d := range s.deploys {
+		d.Live = false
+		file.Deploys = append(file.Deploys, d)
+	}
+	sort.Slice(file.Deploys, func(a, b int) bool { return file.Deploys[a].ID < file.Deploys[b].ID })
+	return fsutil.WriteJSON(s.path, file)
+}
@@ -0,0 +1,64 @@
+package deploys
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploys.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Live(); ok {
+		t.Error("empty store has a live deploy")
+	}
+
+	first, _ := s.Reserve()
+	abandoned, _ := s.Reserve()
+	second, _ := s.Reserve()
+	if first != 1 || abandoned != 2 || second != 3 {
+		t.Fatalf("reserved %d, %d, %d", first, abandoned, second)
+	}
+
+	d, err := s.Add(Deploy{ID: first, Message: "Launch", Files: 3, Size: 1024}, true)
+	if err != nil || !d.Live || d.CreatedAt.IsZero() {
+		t.Fatalf("Add() = %+v, %v", d, err)
+	}
+	// A staged deploy leaves the live one in place
+	if d, err := s.Add(Deploy{ID: second, Files: 4}, false); err != nil || d.Live {
+		t.Fatalf("Add(staged) = %+v, %v", d, err)
+	}
+	if live, _ := s.Live(); live != first {
+		t.Errorf("live = %d, want %d", live, first)
+	}
+
+	if _, err := s.Activate(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Activate(abandoned); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Activate(abandoned) error = %v", err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reloaded.List()
+	if len(list) != 2 || list[0].ID != second || !list[0].Live || list[1].Live || list[1].Message != "Launch" {
+		t.Errorf("reloaded list = %+v", list)
+	}
+	if id, _ := reloaded.Reserve(); id != 4 {
+		t.Errorf("reserved %d after reload, want 4", id)
+	}
+
+	// Rolling back is activating an older deploy
+	if d, err := reloaded.Activate(first); err != nil || !d.Live {
+		t.Fatalf("rollback = %+v, %v", d, err)
+	}
+	if d, ok := reloaded.Get(second); !ok || d.Live {
+		t.Errorf("Get(second) = %+v, %v", d, ok)
+	}
+}
@@ -0,0 +1,206 @@
+// Package documents converts office documents (Word, Excel, PowerPoint
+// and OpenDocument files) to PDF, so browsers can preview them with
+// their built-in PDF viewer. The work is done by a Converter: LibreOffice
+// run headless, or an HTTP service such as Gotenberg.
+package documents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ContentType is the type of what converters return
+const ContentType = "application/pdf"
+
+// maxPDFSize bounds the PDFs read back from a converter
+const maxPDFSize = 256 << 20
+
+// sourceExts are the file types converted
+var sourceExts = map[string]bool{
+	".docx": true, ".xlsx": true, ".pptx": true,
+	".odt": true, ".ods": true, ".odp": true,
+	".doc": true, ".xls": true, ".ppt": true, ".rtf": true,
+}
+
+// Source reports whether key's file type is one converted to PDF
+func Source(key string) bool {
+	return sourceExts[strings.ToLower(path.Ext(key))]
+}
+
+// Converter turns documents into PDFs
+type Converter interface {
+	// Name is the name New opens the converter by
+	Name() string
+	// Convert returns src, a document of the file type ext (such as
+	// ".docx"), as a PDF
+	Convert(ctx context.Context, src []byte, ext string) ([]byte, error)
+}
+
+// Settings configure the converters
+type Settings struct {
+	// LibreOffice is the soffice binary's path, looked up on the PATH
+	// when empty
+	LibreOffice string
+	// URL is where the http converter posts documents
+	URL string
+	// Client sends the http converter's requests; http.DefaultClient
+	// when nil
+	Client *http.Client
+}
+
+var converters = map[string]func(Settings) (Converter, error){
+	"libreoffice": openLibreOffice,
+	"http":        openHTTP,
+}
+
+// Converters returns the names New accepts
+func Converters() []string {
+	var names []string
+	for name := range converters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New opens the converter called name with s
+func New(name string, s Settings) (Converter, error) {
+	open := converters[name]
+	if open == nil {
+		return nil, fmt.Errorf("unknown document converter %q (have %s)", name, strings.Join(Converters(), ", "))
+	}
+	return open(s)
+}
+
+// libreOffice converts with soffice --headless
+type libreOffice struct {
+	bin string
+}
+
+func openLibreOffice(s Settings) (Converter, error) {
+	bin := s.LibreOffice
+	if bin == "" {
+		bin = "soffice"
+	}
+	if _, err := exec.LookPath(bin); err != nil {
+		return nil, fmt.Errorf("libreoffice converter: %w", err)
+	}
+	return libreOffice{bin: bin}, nil
+}
+
+func (libreOffice) Name() string { return "libreoffice" }
+
+// libreOfficeArgs returns the soffice arguments converting input into
+// dir. Each conversion gets its own profile in dir, since LibreOffice
+// won't run twice on one.
+func libreOfficeArgs(dir, input string) []string {
+	return []string{"--headless", "--norestore", "--nolockcheck",
+		"-env:UserInstallation=file://" + filepath.ToSlash(filepath.Join(dir, "profile")),
+		"--convert-to", "pdf", "--outdir", dir, input}
+}
+
+func (c libreOffice) Convert(ctx context.Context, src []byte, ext string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "documents")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "document"+ext)
+	if err := os.WriteFile(input, src, 0o600); err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.bin, libreOfficeArgs(dir, input)...)
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("soffice: %w: %s", err, tail(output.Bytes()))
+	}
+	// soffice exits cleanly when it can't read the file, without
+	// writing anything
+	pdf, err := os.ReadFile(filepath.Join(dir, "document.pdf"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("soffice wrote no PDF: %s", tail(output.Bytes()))
+	}
+	return pdf, err
+}
+
+// httpConverter posts documents as the multipart field "files", as
+// Gotenberg's /forms/libreoffice/convert route takes them, and reads
+// the PDF from the response
+type httpConverter struct {
+	url    string
+	client *http.Client
+}
+
+func openHTTP(s Settings) (Converter, error) {
+	if s.URL == "" {
+		return nil, errors.New("http converter: no URL")
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpConverter{url: s.URL, client: client}, nil
+}
+
+func (httpConverter) Name() string { return "http" }
+
+func (c httpConverter) Convert(ctx context.Context, src []byte, ext string) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("files", "document"+ext)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(src)
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return nil, fmt.Errorf("document converter returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	pdf, err := io.ReadAll(io.LimitReader(resp.Body, maxPDFSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(pdf) > maxPDFSize {
+		return nil, fmt.Errorf("document converter returned over %d bytes", maxPDFSize)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, errors.New("document converter didn't return a PDF")
+	}
+	return pdf, nil
+}
+
+// tail returns the end of a command's output, for errors
+func tail(out []byte) []byte {
+	out = bytes.TrimSpace(out)
+	if len(out) > 500 {
+		out = out[len(out)-500:]
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package documents
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	for key, want := range map[string]bool{
+		"reports/q3.docx":  true,
+		"reports/Q3.XLSX":  true,
+		"decks/launch.odp": true,
+		"reports/q3.pdf":   false,
+		"notes.txt":        false,
+	} {
+		if got := Source(key); got != want {
+			t.Errorf("Source(%s) = %v", key, got)
+		}
+	}
+}
+
+func TestLibreOfficeArgs(t *testing.T) {
+	args := strings.Join(libreOfficeArgs("/tmp/documents1", "/tmp/documents1/document.docx"), " ")
+	want := "--headless --norestore --nolockcheck -env:UserInstallation=file:///tmp/documents1/profile --convert-to pdf --outdir /tmp/documents1 /tmp/documents1/document.docx"
+	if args != want {
+		t.Errorf("args = %s\nwant %s", args, want)
+	}
+}
+
+func TestHTTPConverter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("files")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		switch {
+		case header.Filename != "document.pptx":
+			http.Error(w, "bad filename "+header.Filename, http.StatusBadRequest)
+		case string(data) == "broken":
+			w.Write([]byte("<html>oops</html>"))
+		default:
+			w.Write([]byte("%PDF-1.7\n" + string(data)))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New("http", Settings{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf, err := c.Convert(context.Background(), []byte("slides"), ".pptx")
+	if err != nil || string(pdf) != "%PDF-1.7\nslides" {
+		t.Errorf("Convert = %q, %v", pdf, err)
+	}
+	if _, err := c.Convert(context.Background(), []byte("broken"), ".pptx"); err == nil {
+		t.Error("a response that isn't a PDF was accepted")
+	}
+	if _, err := c.Convert(context.Background(), []byte("sheet"), ".xlsx"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("error for a rejected document = %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("pandoc", Settings{}); err == nil {
+		t.Error("unknown converter opened")
+	}
+	if _, err := New("http", Settings{}); err == nil {
+		t.Error("http converter opened without a URL")
+	}
+}
@@ -0,0 +1,230 @@
+// Package domains records the custom domains that serve a prefix of the
+// bucket, such as a tenant's, and whether their owners have proven
+// control of them, persisted as a JSON file.
+package domains
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/fsutil"
+)
+
+// challengeLabel is prepended to a domain to name its TXT challenge
+// record
+const challengeLabel = "_cdn-challenge."
+
+var (
+	ErrNotFound = errors.New("domain not found")
+	ErrExists   = errors.New("domain already added")
+	ErrInvalid  = errors.New("invalid domain")
+)
+
+// Domain serves the assets under Prefix at its root once verified:
+// https://<Host>/logo.png is the asset <Prefix>logo.png
+type Domain struct {
+	Host   string `json:"host"`
+	Prefix string `json:"prefix"`
+	// Token is the value of the TXT record at Challenge that proves
+	// control of Host
+	Token      string     `json:"token"`
+	Challenge  string     `json:"challenge"`
+	Verified   bool       `json:"verified"`
+	CreatedAt  time.Time  `json:"created_at"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// Key returns the asset a path on the domain serves
+func (d Domain) Key(path string) string {
+	return d.Prefix + strings.TrimPrefix(path, "/")
+}
+
+// normalize lowercases host and checks it is a plain DNS name, and that
+// prefix is a relative one ending in /
+func normalize(host, prefix string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if len(host) > 253 || !strings.Contains(host, ".") {
+		return "", fmt.Errorf("%w: host must be a domain name such as assets.example.com", ErrInvalid)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") ||
+			strings.Trim(label, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return "", fmt.Errorf("%w: host must be a domain name such as assets.example.com", ErrInvalid)
+		}
+	}
+	if prefix == "" || strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") || strings.Contains(prefix, "..") {
+		return "", fmt.Errorf("%w: prefix must be relative and end in /", ErrInvalid)
+	}
+	return host, nil
+}
+
+// Store holds domains by host. Every change is written to its file
+// before it returns, so none is lost in a crash. An empty path keeps
+// domains in memory only.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	domains map[string]Domain
+	now     func() time.Time
+}
+
+// Open loads the domains saved at path, starting empty if it does not
+// exist
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		domains: make(map[string]Domain),
+		now:     time.Now,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	var saved []Domain
+	if _, err := fsutil.ReadJSON(path, &saved); err != nil {
+		return nil, err
+	}
+	for _, d := range saved {
+		s.domains[d.Host] = d
+	}
+	return s, nil
+}
+
+// Add records host as serving prefix, unverified, with a new challenge
+// token
+func (s *Store) Add(host, prefix string) (Domain, error) {
+	host, err := normalize(host, prefix)
+	if err != nil {
+		return Domain{}, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return Domain{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.domains[host]; ok {
+		return Domain{}, ErrExists
+	}
+	d := Domain{Host: host, Prefix: prefix, Token: token, Challenge: challengeLabel + host, CreatedAt: s.now().UTC()}
+	s.domains[host] = d
+	if err := s.save(); err != nil {
+		delete(s.domains, host)
+		return Domain{}, err
+	}
+	return d, nil
+}
+
+// Get returns the domain host
+func (s *Store) Get(host string) (Domain, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.domains[strings.ToLower(host)]
+	return d, ok
+}
+
+// Serving returns the verified domain host, which requests to it are
+// served from
+func (s *Store) Serving(host string) (Domain, bool) {
+	d, ok := s.Get(host)
+	return d, ok && d.Verified
+}
+
+// ServingKey returns the verified domain whose prefix holds key, the
+// longest when several do
+func (s *Store) ServingKey(key string) (Domain, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best Domain
+	for _, d := range s.domains {
+		if d.Verified && strings.HasPrefix(key, d.Prefix) && (len(d.Prefix) > len(best.Prefix) || len(d.Prefix) == len(best.Prefix) && d.Host < best.Host) {
+			best = d
+		}
+	}
+	return best, best.Host != ""
+}
+
+// Verify marks host verified, once its challenge record holds the token
+func (s *Store) Verify(host string) (Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.domains[strings.ToLower(host)]
+	if !ok {
+		return Domain{}, ErrNotFound
+	}
+	if d.Verified {
+		return d, nil
+	}
+	prev := d
+	now := s.now().UTC()
+	d.Verified, d.VerifiedAt = true, &now
+	s.domains[d.Host] = d
+	if err := s.save(); err != nil {
+		s.domains[d.Host] = prev
+		return Domain{}, err
+	}
+	return d, nil
+}
+
+// Remove forgets host, which stops serving at once
+func (s *Store) Remove(host string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host = strings.ToLower(host)
+	d, ok := s.domains[host]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.domains, host)
+	if err := s.save(); err != nil {
+		s.domains[host] = d
+		return err
+	}
+	return nil
+}
+
+// List returns every domain, by host
+func (s *Store) List() []Domain {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Domain, 0, len(s.domains))
+	for _, d := range s.domains {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Host < list[b].Host })
+	return list
+}
+
+// save writes the store to its file. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	list := make([]Domain, 0, len(s.domains))
+	for _, d := range s.domains {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Host < list[b].Host })
+	return fsutil.WriteJSON(s.path, list)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "cdn-verify=" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,65 @@
+package domains
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := s.Add("Assets.Acme.test.", "tenants/acme/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Host != "assets.acme.test" || d.Challenge != "_cdn-challenge.assets.acme.test" || !strings.HasPrefix(d.Token, "cdn-verify=") || d.Verified {
+		t.Errorf("Add() = %+v", d)
+	}
+	if _, err := s.Add("assets.acme.test", "tenants/other/"); !errors.Is(err, ErrExists) {
+		t.Errorf("Add(again) error = %v", err)
+	}
+	for _, bad := range [][2]string{{"localhost", "a/"}, {"-bad.example.com", "a/"}, {"a_b.example.com", "a/"}, {"ok.example.com", "/a/"}, {"ok.example.com", "a"}, {"ok.example.com", "../a/"}} {
+		if _, err := s.Add(bad[0], bad[1]); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Add(%q, %q) error = %v", bad[0], bad[1], err)
+		}
+	}
+
+	// Unverified domains don't serve
+	if _, ok := s.Serving("assets.acme.test"); ok {
+		t.Error("unverified domain serves")
+	}
+	if _, err := s.Verify("ASSETS.acme.test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify("missing.example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Verify(missing) error = %v", err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := reloaded.Serving("assets.acme.test")
+	if !ok || d.VerifiedAt == nil || d.Key("/img/logo.png") != "tenants/acme/img/logo.png" {
+		t.Errorf("Serving() = %+v, %v", d, ok)
+	}
+	if d, ok := reloaded.ServingKey("tenants/acme/img/logo.png"); !ok || d.Host != "assets.acme.test" {
+		t.Errorf("ServingKey() = %+v, %v", d, ok)
+	}
+	if _, ok := reloaded.ServingKey("tenants/globex/logo.png"); ok {
+		t.Error("ServingKey() found a domain outside its prefix")
+	}
+
+	if err := reloaded.Remove("assets.acme.test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.List()) != 0 {
+		t.Errorf("List() = %+v after Remove", reloaded.List())
+	}
+}
@@ -0,0 +1,183 @@
+// Package envelope encrypts objects with envelope encryption: each
+// object gets its own random data key, which is stored alongside the
+// ciphertext wrapped by a master key that never leaves the KMS.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Object metadata describing how an object was sealed
+const (
+	AlgorithmMeta = "enc-alg"
+	KeyIDMeta     = "enc-kid"
+	DataKeyMeta   = "enc-key"
+)
+
+const algorithm = "AES256-GCM"
+
+// Overhead is how much longer a sealed object is than its plaintext:
+// the nonce before the ciphertext and the authentication tag after it
+const Overhead = 12 + 16
+
+// ErrUnknownKey is returned for a data key wrapped by a master key the
+// KMS doesn't have
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KMS wraps and unwraps data keys with master keys it holds
+type KMS interface {
+	// Encrypt wraps a data key with the current master key
+	Encrypt(ctx context.Context, plaintext []byte) (keyID string, ciphertext []byte, err error)
+	// Decrypt unwraps a data key wrapped by the master key keyID
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// Keyring is a KMS holding its master keys in memory. The first key
+// wraps new data keys; the others only unwrap, so a key can be rotated
+// without re-encrypting every object.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring parses master keys given as "id:base64key", each key 32
+// bytes. It returns nil without keys.
+func NewKeyring(specs []string) (*Keyring, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, spec := range specs {
+		id, encoded, ok := strings.Cut(spec, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %d: want id:base64key", i+1)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("master key %q: duplicate id", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %q: want 32 base64-encoded bytes", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if i == 0 {
+			k.current = id
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) Encrypt(ctx context.Context, plaintext []byte) (string, []byte, error) {
+	sealed, err := seal(k.keys[k.current], plaintext)
+	return k.current, sealed, err
+}
+
+func (k *Keyring) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return open(aead, ciphertext)
+}
+
+// Sealer encrypts and decrypts objects with data keys wrapped by a KMS.
+// A nil *Sealer encrypts nothing.
+type Sealer struct {
+	kms KMS
+}
+
+// New returns a Sealer wrapping data keys with kms, or nil if kms is nil
+func New(kms KMS) *Sealer {
+	if kms == nil {
+		return nil
+	}
+	return &Sealer{kms: kms}
+}
+
+// Seal encrypts plaintext under a fresh data key and returns the
+// ciphertext and the object metadata needed to open it
+func (s *Sealer) Seal(ctx context.Context, plaintext []byte) ([]byte, map[string]string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := seal(aead, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyID, wrapped, err := s.kms.Encrypt(ctx, dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+	return ciphertext, map[string]string{
+		AlgorithmMeta: algorithm,
+		KeyIDMeta:     keyID,
+		DataKeyMeta:   base64.StdEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+// Open decrypts an object sealed with the given metadata
+func (s *Sealer) Open(ctx context.Context, ciphertext []byte, meta map[string]string) ([]byte, error) {
+	if alg := meta[AlgorithmMeta]; alg != algorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(meta[DataKeyMeta])
+	if err != nil {
+		return nil, fmt.Errorf("malformed data key: %w", err)
+	}
+	dataKey, err := s.kms.Decrypt(ctx, meta[KeyIDMeta], wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext)
+}
+
+// Sealed reports whether object metadata marks the object as sealed
+func Sealed(meta map[string]string) bool {
+	return meta[AlgorithmMeta] != ""
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce followed by the ciphertext and tag
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,72 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	old, err := NewKeyring([]string{"v1:" + testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("passport scan")
+	ciphertext, meta, err := New(old).Seal(ctx, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != len(plaintext)+Overhead {
+		t.Errorf("ciphertext is %d bytes, want %d", len(ciphertext), len(plaintext)+Overhead)
+	}
+	if !Sealed(meta) || meta[KeyIDMeta] != "v1" || bytes.Contains(ciphertext, plaintext) {
+		t.Fatalf("meta = %v", meta)
+	}
+
+	// After rotation the old key still opens existing objects
+	rotated, err := NewKeyring([]string{"v2:" + testKey(2), "v1:" + testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := New(rotated).Open(ctx, ciphertext, meta)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	if _, meta, _ := New(rotated).Seal(ctx, plaintext); meta[KeyIDMeta] != "v2" {
+		t.Errorf("sealed with %s, want the current key v2", meta[KeyIDMeta])
+	}
+
+	other, _ := NewKeyring([]string{"v2:" + testKey(2)})
+	if _, err := New(other).Open(ctx, ciphertext, meta); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with the key gone: %v, want ErrUnknownKey", err)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := New(old).Open(ctx, ciphertext, meta); err == nil {
+		t.Error("tampered ciphertext opened")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	for _, specs := range [][]string{
+		{"nokey"},
+		{":" + testKey(1)},
+		{"v1:not base64"},
+		{"v1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"v1:" + testKey(1), "v1:" + testKey(2)},
+	} {
+		if _, err := NewKeyring(specs); err == nil {
+			t.Errorf("NewKeyring(%q) should fail", specs)
+		}
+	}
+	if k, err := NewKeyring(nil); k != nil || err != nil {
+		t.Errorf("NewKeyring(nil) = %v, %v", k, err)
+	}
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// producer is a connection to a message broker
+type producer interface {
+	// publish sends payload to topic. key is used for partitioning where
+	// the broker supports it.
+	publish(topic string, key, payload []byte) error
+	close() error
+}
+
+// BrokerConfig selects and configures the message broker
+type BrokerConfig struct {
+	// Kind is "nats" or "kafka"
+	Kind string
+	// URL is a nats:// URL, or a comma-separated list of Kafka bootstrap
+	// brokers (host:port)
+	URL string
+	// Topic is the NATS subject prefix (events go to <topic>.<type>) or
+	// the Kafka topic (events are keyed by asset key)
+	Topic string
+	// Username and Password authenticate to the broker. For NATS a
+	// password alone is a token; Kafka uses them with SASL.
+	Username string
+	Password string
+	// SASL is the Kafka SASL mechanism: plain, scram-sha-256 or
+	// scram-sha-512. Empty disables SASL.
+	SASL string
+	// TLS connects with TLS, verifying the broker against CAFile if set
+	// and the system roots otherwise. A tls:// NATS URL implies it.
+	TLS    bool
+	CAFile string
+}
+
+// tlsConfig returns the TLS settings for connecting to the broker
+func (c BrokerConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CAFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event broker CA file: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("event broker CA file %s holds no certificates", c.CAFile)
+	}
+	return config, nil
+}
+
+// BrokerSink publishes events to NATS or Kafka from a background queue,
+// reconnecting on failure
+type BrokerSink struct {
+	cfg   BrokerConfig
+	dial  func() (producer, error)
+	queue chan Event
+	wg    sync.WaitGroup
+	conn  producer
+}
+
+// NewBrokerSink validates cfg and starts the publishing worker. The
+// connection is established lazily so a broker outage does not prevent
+// the service from starting.
+func NewBrokerSink(cfg BrokerConfig) (*BrokerSink, error) {
+	s := &BrokerSink{
+		cfg:   cfg,
+		queue: make(chan Event, 1000),
+	}
+
+	switch cfg.Kind {
+	case "nats":
+		if cfg.Topic == "" {
+			s.cfg.Topic = "cdn"
+		}
+		s.dial = func() (producer, error) { return dialNATS(cfg) }
+	case "kafka":
+		if cfg.Topic == "" {
+			s.cfg.Topic = "cdn-events"
+		}
+		s.dial = func() (producer, error) { return newKafkaProducer(cfg) }
+	default:
+		return nil, fmt.Errorf("unsupported event broker %q (want nats or kafka)", cfg.Kind)
+	}
+
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("event broker URL is required")
+	}
+	if cfg.SASL != "" && cfg.Kind != "kafka" {
+		return nil, fmt.Errorf("SASL is only supported with kafka")
+	}
+	if _, err := saslMechanism(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.CAFile != "" {
+		if _, err := cfg.tlsConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Publish queues e for delivery
+func (s *BrokerSink) Publish(e Event) {
+	select {
+	case s.queue <- e:
+	default:
+		log.Printf("Event broker: queue full, dropping event %s", e.ID)
+	}
+}
+
+// Close delivers queued events and closes the broker connection
+func (s *BrokerSink) Close() {
+	close(s.queue)
+	s.wg.Wait()
+	if s.conn != nil {
+		s.conn.close()
+	}
+}
+
+func (s *BrokerSink) run() {
+	defer s.wg.Done()
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			log.Printf("Event broker: failed to publish %s %s: %v", e.Type, e.ID, err)
+		}
+	}
+}
+
+func (s *BrokerSink) send(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	topic, key := s.cfg.Topic, []byte(e.ID)
+	if s.cfg.Kind == "nats" {
+		topic = s.cfg.Topic + "." + e.Type
+	}
+	if k, ok := e.Data["key"].(string); ok && k != "" {
+		key = []byte(k)
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		if s.conn == nil {
+			// Only keep a connection that dialled: a failed dial's nil
+			// pointer would make a non-nil producer
+			var conn producer
+			if conn, err = s.dial(); err == nil {
+				s.conn = conn
+			}
+		}
+		if err == nil {
+			if err = s.conn.publish(topic, key, payload); err == nil {
+				return nil
+			}
+			// Drop the connection; the next attempt reconnects
+			s.conn.close()
+			s.conn = nil
+		}
+
+		if attempt == 3 {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 4
+	}
+}
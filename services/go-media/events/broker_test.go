@@ -0,0 +1,103 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATSServer accepts one connection and returns the subject and
+// payload of the first PUB it receives
+func fakeNATSServer(t *testing.T) (string, <-chan [2]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	published := make(chan [2]string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case line == "PING\r\n":
+				conn.Write([]byte("PONG\r\n"))
+			case strings.HasPrefix(line, "PUB "):
+				fields := strings.Fields(line)
+				payload, _ := r.ReadString('\n')
+				published <- [2]string{fields[1], strings.TrimSuffix(payload, "\r\n")}
+			}
+		}
+	}()
+
+	return "nats://" + ln.Addr().String(), published
+}
+
+func TestBrokerSinkPublishesToNATS(t *testing.T) {
+	url, published := fakeNATSServer(t)
+
+	sink, err := NewBrokerSink(BrokerConfig{Kind: "nats", URL: url, Topic: "cdn"})
+	if err != nil {
+		t.Fatalf("NewBrokerSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	sink.Publish(New(AssetUploaded, map[string]interface{}{"key": "assets/a.png"}))
+
+	select {
+	case msg := <-published:
+		if msg[0] != "cdn.asset.uploaded" {
+			t.Errorf("subject = %q, want cdn.asset.uploaded", msg[0])
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(msg[1]), &e); err != nil {
+			t.Fatalf("invalid payload: %v", err)
+		}
+		if e.Data["key"] != "assets/a.png" {
+			t.Errorf("unexpected event data: %+v", e.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for PUB")
+	}
+}
+
+func TestNewBrokerSinkRejectsUnknownKind(t *testing.T) {
+	if _, err := NewBrokerSink(BrokerConfig{Kind: "rabbitmq", URL: "amqp://x"}); err == nil {
+		t.Error("expected error for unsupported broker")
+	}
+}
+
+func TestNewBrokerSinkRejectsBadSettings(t *testing.T) {
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(badCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cfg := range map[string]BrokerConfig{
+		"unknown SASL":   {Kind: "kafka", URL: "kafka:9092", SASL: "gssapi"},
+		"SASL with NATS": {Kind: "nats", URL: "nats://nats:4222", SASL: "plain"},
+		"bad CA file":    {Kind: "nats", URL: "tls://nats:4222", CAFile: badCA},
+		"missing CA":     {Kind: "kafka", URL: "kafka:9092", TLS: true, CAFile: badCA + ".missing"},
+	} {
+		if _, err := NewBrokerSink(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
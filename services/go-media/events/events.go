@@ -0,0 +1,87 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Asset lifecycle event types
+const (
+	AssetUploaded  = "asset.uploaded"
+	AssetDeleted   = "asset.deleted"
+	AssetPurged    = "asset.purged"
+	AssetOffloaded = "asset.offloaded"
+	// AssetTranscoded announces a video's HLS package, once stored
+	AssetTranscoded = "asset.transcoded"
+	// AssetPublished and AssetUnpublished announce the start and end of
+	// an asset's public availability, as scheduled or promoted, archived
+	// or drafted
+	AssetPublished   = "asset.published"
+	AssetUnpublished = "asset.unpublished"
+	// AssetRestored announces a deleted asset put back from the trash
+	AssetRestored = "asset.restored"
+	ScanFlagged   = "scan.flagged"
+	// DeployCreated and DeployActivated announce a site deploy stored,
+	// and one made live
+	DeployCreated   = "deploy.created"
+	DeployActivated = "deploy.activated"
+)
+
+// Event is the JSON envelope delivered to every sink
+type Event struct {
+	ID   string                 `json:"id"`
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// New creates an event with a random ID and the current time
+func New(eventType string, data map[string]interface{}) Event {
+	return Event{
+		ID:   newID(),
+		Type: eventType,
+		Time: time.Now().UTC(),
+		Data: data,
+	}
+}
+
+// Sink receives published events. Publish must not block the caller;
+// sinks queue and deliver asynchronously.
+type Sink interface {
+	Publish(e Event)
+}
+
+// Bus fans events out to every registered sink. A nil *Bus discards
+// events, so handlers can publish unconditionally.
+type Bus struct {
+	sinks []Sink
+}
+
+func NewBus(sinks ...Sink) *Bus {
+	return &Bus{sinks: sinks}
+}
+
+// Add registers another sink
+func (b *Bus) Add(s Sink) {
+	b.sinks = append(b.sinks, s)
+}
+
+// Publish delivers e to all sinks
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	for _, s := range b.sinks {
+		s.Publish(e)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("evt_%d", time.Now().UnixNano())
+	}
+	return "evt_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaProducer writes one record per call and waits for every in-sync
+// replica to acknowledge it. Records with the same key go to the same
+// partition.
+type kafkaProducer struct {
+	w *kafka.Writer
+}
+
+func newKafkaProducer(cfg BrokerConfig) (*kafkaProducer, error) {
+	var brokers []string
+	for _, addr := range strings.Split(cfg.URL, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			brokers = append(brokers, addr)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, errors.New("no Kafka bootstrap brokers configured")
+	}
+
+	mechanism, err := saslMechanism(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := &kafka.Transport{
+		ClientID:    "cdn-go-media",
+		DialTimeout: 5 * time.Second,
+		SASL:        mechanism,
+	}
+	if cfg.TLS || cfg.CAFile != "" {
+		if transport.TLS, err = cfg.tlsConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &kafkaProducer{w: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Records are sent one at a time, so don't wait to fill a batch
+		BatchSize:    1,
+		WriteTimeout: 10 * time.Second,
+		Transport:    transport,
+	}}, nil
+}
+
+// saslMechanism returns the SASL mechanism cfg names, or nil for none
+func saslMechanism(cfg BrokerConfig) (sasl.Mechanism, error) {
+	switch cfg.SASL {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q (want plain, scram-sha-256 or scram-sha-512)", cfg.SASL)
+	}
+}
+
+func (p *kafkaProducer) publish(topic string, key, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return p.w.WriteMessages(ctx, kafka.Message{Topic: topic, Key: key, Value: payload})
+}
+
+func (p *kafkaProducer) close() error {
+	return p.w.Close()
+}
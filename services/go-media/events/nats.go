@@ -0,0 +1,55 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsProducer publishes with the NATS client. Core NATS has no
+// per-message acks, so each publish is flushed: the server has the
+// message once it answers the PING sent after it.
+type natsProducer struct {
+	nc *nats.Conn
+}
+
+// dialNATS connects to cfg.URL. tls:// URLs, EVENT_BROKER_TLS or a CA file
+// require TLS; credentials come from the URL or cfg.
+func dialNATS(cfg BrokerConfig) (*natsProducer, error) {
+	opts := []nats.Option{
+		nats.Name("cdn-go-media"),
+		nats.Timeout(5 * time.Second),
+	}
+	switch {
+	case cfg.Username != "":
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	case cfg.Password != "":
+		opts = append(opts, nats.Token(cfg.Password))
+	}
+	if cfg.TLS || cfg.CAFile != "" {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	nc, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &natsProducer{nc: nc}, nil
+}
+
+func (p *natsProducer) publish(subject string, _ []byte, payload []byte) error {
+	if err := p.nc.Publish(subject, payload); err != nil {
+		return err
+	}
+	return p.nc.FlushTimeout(5 * time.Second)
+}
+
+func (p *natsProducer) close() error {
+	p.nc.Close()
+	return nil
+}
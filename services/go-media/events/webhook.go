@@ -0,0 +1,253 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxDeliveryLog      = 500
+	defaultMaxAttempts  = 5
+	defaultRetryBackoff = time.Second
+)
+
+// Endpoint is a webhook receiver. Events restricts delivery to the listed
+// event types; empty means all events.
+type Endpoint struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (e Endpoint) accepts(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery records the outcome of sending one event to one endpoint
+type Delivery struct {
+	ID           string    `json:"id"`
+	EventID      string    `json:"event_id"`
+	EventType    string    `json:"event_type"`
+	Endpoint     string    `json:"endpoint"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type webhookJob struct {
+	endpoint Endpoint
+	body     []byte
+	delivery *Delivery
+}
+
+// WebhookDispatcher delivers signed JSON events to the configured
+// endpoints with exponential backoff, keeping a log of recent deliveries
+type WebhookDispatcher struct {
+	endpoints   []Endpoint
+	client      *http.Client
+	queue       chan webhookJob
+	maxAttempts int
+	backoff     time.Duration
+	stop        chan struct{}
+	wg          sync.WaitGroup
+
+	mu         sync.Mutex
+	deliveries []*Delivery
+}
+
+// NewWebhookDispatcher starts workers delivering to endpoints
+func NewWebhookDispatcher(endpoints []Endpoint, workers int) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		endpoints:   endpoints,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan webhookJob, 1000),
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultRetryBackoff,
+		stop:        make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+
+	return d
+}
+
+// Publish queues e for every endpoint subscribed to its type
+func (d *WebhookDispatcher) Publish(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Webhooks: failed to marshal event %s: %v", e.ID, err)
+		return
+	}
+
+	for _, ep := range d.endpoints {
+		if !ep.accepts(e.Type) {
+			continue
+		}
+
+		delivery := d.logDelivery(e, ep)
+		select {
+		case d.queue <- webhookJob{endpoint: ep, body: body, delivery: delivery}:
+		default:
+			d.finish(delivery, "failed", 0, "delivery queue full")
+		}
+	}
+}
+
+// Deliveries returns the most recent deliveries, newest first, optionally
+// filtered by status (pending, delivered, failed)
+func (d *WebhookDispatcher) Deliveries(status string, limit int) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]Delivery, 0)
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if status != "" && d.deliveries[i].Status != status {
+			continue
+		}
+		out = append(out, *d.deliveries[i])
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+// Close stops accepting retries and waits for in-flight deliveries
+func (d *WebhookDispatcher) Close() {
+	close(d.stop)
+	close(d.queue)
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) worker() {
+	defer d.wg.Done()
+	for job := range d.queue {
+		d.deliver(job)
+	}
+}
+
+func (d *WebhookDispatcher) deliver(job webhookJob) {
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		code, err := d.send(job)
+		d.mu.Lock()
+		job.delivery.Attempts = attempt
+		d.mu.Unlock()
+
+		if err == nil {
+			d.finish(job.delivery, "delivered", code, "")
+			return
+		}
+		if attempt >= d.maxAttempts {
+			d.finish(job.delivery, "failed", code, err.Error())
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-d.stop:
+			d.finish(job.delivery, "failed", code, "shutdown before retry: "+err.Error())
+			return
+		}
+	}
+}
+
+func (d *WebhookDispatcher) send(job webhookJob) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, job.endpoint.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cdn-go-media-webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", job.delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", job.delivery.ID)
+	if job.endpoint.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+Sign(job.endpoint.Secret, timestamp, job.body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the webhook signature over "<timestamp>.<body>".
+// Receivers should recompute it and reject stale timestamps.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) logDelivery(e Event, ep Endpoint) *Delivery {
+	now := time.Now().UTC()
+	delivery := &Delivery{
+		ID:        "dlv_" + strings.TrimPrefix(newID(), "evt_"),
+		EventID:   e.ID,
+		EventType: e.Type,
+		Endpoint:  ep.URL,
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > maxDeliveryLog {
+		d.deliveries = d.deliveries[len(d.deliveries)-maxDeliveryLog:]
+	}
+	return delivery
+}
+
+func (d *WebhookDispatcher) finish(delivery *Delivery, status string, code int, errMsg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivery.Status = status
+	delivery.ResponseCode = code
+	delivery.Error = errMsg
+	delivery.UpdatedAt = time.Now().UTC()
+
+	if status == "failed" {
+		log.Printf("Webhooks: delivery %s of %s to %s failed: %s", delivery.ID, delivery.EventID, delivery.Endpoint, errMsg)
+	}
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitForStatus(t *testing.T, d *WebhookDispatcher, status string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := d.Deliveries(status, 1); len(got) == 1 {
+			return got[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no delivery reached status %q", status)
+	return Delivery{}
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	d := NewWebhookDispatcher([]Endpoint{{URL: server.URL, Secret: "whsec"}}, 1)
+	defer d.Close()
+
+	d.Publish(New(AssetUploaded, map[string]interface{}{"key": "assets/a.png"}))
+
+	req := <-received
+	delivery := waitForStatus(t, d, "delivered")
+	if delivery.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", delivery.Attempts)
+	}
+
+	sig := req.Header.Get("X-Webhook-Signature")
+	parts := strings.SplitN(sig, ",", 2)
+	if len(parts) != 2 {
+		t.Fatalf("malformed signature header %q", sig)
+	}
+	timestamp := strings.TrimPrefix(parts[0], "t=")
+	if want := "v1=" + Sign("whsec", timestamp, body); parts[1] != want {
+		t.Errorf("signature = %q, want %q", parts[1], want)
+	}
+	if req.Header.Get("X-Webhook-Event") != AssetUploaded {
+		t.Errorf("X-Webhook-Event = %q", req.Header.Get("X-Webhook-Event"))
+	}
+}
+
+func TestWebhookRetriesThenFails(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	d := NewWebhookDispatcher([]Endpoint{{URL: server.URL}}, 1)
+	d.maxAttempts = 3
+	d.backoff = time.Millisecond
+	defer d.Close()
+
+	d.Publish(New(AssetDeleted, nil))
+
+	delivery := waitForStatus(t, d, "failed")
+	if delivery.Attempts != 3 || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("expected 3 attempts, got %d (%d calls)", delivery.Attempts, calls)
+	}
+	if delivery.ResponseCode != http.StatusBadGateway {
+		t.Errorf("ResponseCode = %d, want 502", delivery.ResponseCode)
+	}
+}
+
+func TestEndpointEventFilter(t *testing.T) {
+	d := NewWebhookDispatcher([]Endpoint{{URL: "http://127.0.0.1:0", Events: []string{AssetDeleted}}}, 0)
+
+	d.Publish(New(AssetUploaded, nil))
+	if got := d.Deliveries("", 0); len(got) != 0 {
+		t.Errorf("expected no deliveries for filtered event, got %d", len(got))
+	}
+}
@@ -0,0 +1,280 @@
+package fonts
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Face is a font at a size, the height of its em in pixels
+type Face struct {
+	Font *Font
+	Size float64
+}
+
+func (f Face) scale() float64 {
+	return f.Size / float64(f.Font.unitsPerEm)
+}
+
+// Ascent is the distance from the baseline to the top of a line
+func (f Face) Ascent() float64 {
+	return float64(f.Font.ascent) * f.scale()
+}
+
+// LineHeight is the distance between the baselines of two lines
+func (f Face) LineHeight() float64 {
+	return float64(f.Font.ascent-f.Font.descent+f.Font.lineGap) * f.scale()
+}
+
+// Measure returns the width of s in pixels
+func (f Face) Measure(s string) float64 {
+	w := 0
+	for _, r := range s {
+		w += f.Font.advance(f.Font.Glyph(r))
+	}
+	return float64(w) * f.scale()
+}
+
+// Wrap breaks s into lines no wider than width, between words where it
+// can and within a word too long for a line of its own. Newlines in s
+// start new lines.
+func (f Face) Wrap(s string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			switch {
+			case line == "":
+			case f.Measure(line+" "+word) <= width:
+				line += " " + word
+				continue
+			default:
+				lines = append(lines, line)
+			}
+			for f.Measure(word) > width && utf8.RuneCountInString(word) > 1 {
+				head := f.fitRunes(word, width)
+				lines = append(lines, word[:head])
+				word = word[head:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Truncate shortens s with an ellipsis to fit width
+func (f Face) Truncate(s string, width float64) string {
+	if f.Measure(s) <= width {
+		return s
+	}
+	const ellipsis = "…"
+	n := f.fitRunes(s, width-f.Measure(ellipsis))
+	return strings.TrimRight(s[:n], " ") + ellipsis
+}
+
+// fitRunes returns the length in bytes of the longest prefix of s that
+// fits width, and at least one rune
+func (f Face) fitRunes(s string, width float64) int {
+	w, end := 0.0, 0
+	for i, r := range s {
+		w += float64(f.Font.advance(f.Font.Glyph(r))) * f.scale()
+		if w > width && i > 0 {
+			return i
+		}
+		end = i + utf8.RuneLen(r)
+	}
+	return end
+}
+
+// Draw draws s on dst in c, starting at x on the baseline y
+func (f Face) Draw(dst draw.Image, x, y float64, s string, c color.Color) {
+	scale := f.scale()
+	b := image.Rect(
+		int(math.Floor(x))-1, int(math.Floor(y-float64(f.Font.yMax)*scale))-1,
+		int(math.Ceil(x+f.Measure(s)))+1, int(math.Ceil(y-float64(f.Font.yMin)*scale))+1,
+	).Intersect(dst.Bounds())
+	if b.Empty() {
+		return
+	}
+
+	r := newRasterizer(b.Dx(), b.Dy())
+	pen := x
+	for _, ch := range s {
+		g := f.Font.Glyph(ch)
+		contours, err := f.Font.outline(g, 0)
+		if err == nil {
+			for _, contour := range contours {
+				placed := make([]point, len(contour))
+				for i, p := range contour {
+					placed[i] = point{x: pen + p.x*scale - float64(b.Min.X), y: y - p.y*scale - float64(b.Min.Y), onCurve: p.onCurve}
+				}
+				r.contour(placed)
+			}
+		}
+		pen += float64(f.Font.advance(g)) * scale
+	}
+	draw.DrawMask(dst, b, image.NewUniform(c), image.Point{}, r.mask(b), b.Min, draw.Over)
+}
+
+// rasterizer fills outlines with exact area coverage: each edge adds the
+// signed area it covers to the cells it crosses, and a running sum along
+// each row gives every pixel's coverage
+type rasterizer struct {
+	w, h   int
+	stride int
+	acc    []float64
+}
+
+func newRasterizer(w, h int) *rasterizer {
+	// Edges at the right end spill into two more cells
+	return &rasterizer{w: w, h: h, stride: w + 2, acc: make([]float64, (w+2)*h)}
+}
+
+// contour fills a closed TrueType contour, whose off-curve points are
+// quadratic control points with an on-curve point implied between two
+// in a row
+func (r *rasterizer) contour(c []point) {
+	n := len(c)
+	if n == 0 {
+		return
+	}
+	var start point
+	first := 0
+	switch {
+	case c[0].onCurve:
+		start, first = c[0], 1
+	case c[n-1].onCurve:
+		start = c[n-1]
+	default:
+		start = midpoint(c[n-1], c[0])
+	}
+
+	cur, ctrl, curved := start, point{}, false
+	for k := 0; k < n; k++ {
+		p := c[(first+k)%n]
+		switch {
+		case p.onCurve && curved:
+			r.quad(cur, ctrl, p)
+			cur, curved = p, false
+		case p.onCurve:
+			r.line(cur, p)
+			cur = p
+		case curved:
+			mid := midpoint(ctrl, p)
+			r.quad(cur, ctrl, mid)
+			cur, ctrl = mid, p
+		default:
+			ctrl, curved = p, true
+		}
+	}
+	if curved {
+		r.quad(cur, ctrl, start)
+	} else {
+		r.line(cur, start)
+	}
+}
+
+func midpoint(a, b point) point {
+	return point{x: (a.x + b.x) / 2, y: (a.y + b.y) / 2, onCurve: true}
+}
+
+// quad draws a quadratic Bézier curve as enough lines that the error
+// stays under a third of a pixel
+func (r *rasterizer) quad(p0, p1, p2 point) {
+	dx, dy := p0.x-2*p1.x+p2.x, p0.y-2*p1.y+p2.y
+	devsq := dx*dx + dy*dy
+	if devsq < 0.333 {
+		r.line(p0, p2)
+		return
+	}
+	n := 1 + int(math.Sqrt(math.Sqrt(3*devsq)))
+	prev := p0
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		mt := 1 - t
+		p := point{
+			x: mt*mt*p0.x + 2*mt*t*p1.x + t*t*p2.x,
+			y: mt*mt*p0.y + 2*mt*t*p1.y + t*t*p2.y,
+		}
+		r.line(prev, p)
+		prev = p
+	}
+}
+
+// line adds the signed area left of the edge from p0 to p1 to the cells
+// it crosses. Parts outside the canvas are clamped to its edges, which
+// keeps each row's sum correct.
+func (r *rasterizer) line(p0, p1 point) {
+	if p0.y == p1.y {
+		return
+	}
+	dir := 1.0
+	if p0.y > p1.y {
+		dir = -1
+		p0, p1 = p1, p0
+	}
+	dxdy := (p1.x - p0.x) / (p1.y - p0.y)
+	x := p0.x
+	if p0.y < 0 {
+		x -= p0.y * dxdy
+	}
+	w := float64(r.w)
+	for y := max(0, int(p0.y)); y < min(r.h, int(math.Ceil(p1.y))); y++ {
+		row := r.acc[y*r.stride : (y+1)*r.stride]
+		dy := math.Min(float64(y+1), p1.y) - math.Max(float64(y), p0.y)
+		xnext := x + dxdy*dy
+		d := dy * dir
+
+		x0, x1 := math.Max(0, math.Min(w, x)), math.Max(0, math.Min(w, xnext))
+		if x0 > x1 {
+			x0, x1 = x1, x0
+		}
+		x0floor, x1ceil := math.Floor(x0), math.Ceil(x1)
+		x0i, x1i := int(x0floor), int(x1ceil)
+		if x1i <= x0i+1 {
+			// Within one cell: split by where the edge crosses it on
+			// average
+			xmf := 0.5*(x0+x1) - x0floor
+			row[x0i] += d - d*xmf
+			row[x0i+1] += d * xmf
+		} else {
+			s := 1 / (x1 - x0)
+			x0f := x0 - x0floor
+			a0 := 0.5 * s * (1 - x0f) * (1 - x0f)
+			x1f := x1 - x1ceil + 1
+			am := 0.5 * s * x1f * x1f
+			row[x0i] += d * a0
+			if x1i == x0i+2 {
+				row[x0i+1] += d * (1 - a0 - am)
+			} else {
+				a1 := s * (1.5 - x0f)
+				row[x0i+1] += d * (a1 - a0)
+				for xi := x0i + 2; xi < x1i-1; xi++ {
+					row[xi] += d * s
+				}
+				a2 := a1 + float64(x1i-x0i-3)*s
+				row[x1i-1] += d * (1 - a2 - am)
+			}
+			row[x1i] += d * am
+		}
+		x = xnext
+	}
+}
+
+// mask returns the coverage as an alpha mask placed at bounds
+func (r *rasterizer) mask(bounds image.Rectangle) *image.Alpha {
+	m := image.NewAlpha(bounds)
+	for y := 0; y < r.h; y++ {
+		sum := 0.0
+		for x := 0; x < r.w; x++ {
+			sum += r.acc[y*r.stride+x]
+			a := math.Min(1, math.Abs(sum))
+			m.Pix[y*m.Stride+x] = uint8(a*255 + 0.5)
+		}
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package fonts
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedFonts(t *testing.T) {
+	for _, f := range []*Font{Regular, Bold} {
+		for _, r := range "Aé€Ж" {
+			if !f.HasGlyph(r) {
+				t.Errorf("no glyph for %q", r)
+			}
+		}
+		if f.HasGlyph('中') {
+			t.Error("CJK has a glyph")
+		}
+		// é is a composite of e and an accent
+		if contours, err := f.outline(f.Glyph('é'), 0); err != nil || len(contours) < 2 {
+			t.Errorf("outline(é) = %d contours, %v", len(contours), err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"opentype":  append([]byte("OTTO"), make([]byte, 20)...),
+		"no tables": {0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"truncated": regularTTF[:4096],
+	} {
+		if _, err := Parse(data); !errors.Is(err, ErrInvalidFont) {
+			t.Errorf("%s: error = %v", name, err)
+		}
+	}
+}
+
+func TestMeasureAndWrap(t *testing.T) {
+	face := Face{Font: Regular, Size: 20}
+	if w := face.Measure("ii"); w <= 0 || w >= face.Measure("WW") {
+		t.Errorf("Measure(ii) = %v, Measure(WW) = %v", w, face.Measure("WW"))
+	}
+
+	lines := face.Wrap("The quick brown fox jumps over the lazy dog", 120)
+	if len(lines) < 3 || strings.Join(lines, " ") != "The quick brown fox jumps over the lazy dog" {
+		t.Errorf("Wrap() = %q", lines)
+	}
+	for _, l := range lines {
+		if face.Measure(l) > 120 {
+			t.Errorf("line %q is %v wide", l, face.Measure(l))
+		}
+	}
+	// A word too long for a line is broken within it
+	if lines := face.Wrap("Pneumonoultramicroscopicsilicovolcanoconiosis", 100); len(lines) < 2 || strings.Join(lines, "") != "Pneumonoultramicroscopicsilicovolcanoconiosis" {
+		t.Errorf("Wrap(long word) = %q", lines)
+	}
+	if lines := face.Wrap("one\ntwo", 500); len(lines) != 2 {
+		t.Errorf("Wrap(newline) = %q", lines)
+	}
+
+	if got := face.Truncate("A title far too long for the space", 100); !strings.HasSuffix(got, "…") || face.Measure(got) > 100 {
+		t.Errorf("Truncate() = %q", got)
+	}
+	if got := face.Truncate("Short", 100); got != "Short" {
+		t.Errorf("Truncate(Short) = %q", got)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 40))
+	face := Face{Font: Bold, Size: 32}
+	face.Draw(img, 4, 32, "I", color.White)
+
+	// The stem of the I is solid, the space beside it untouched
+	advance := face.Measure("I")
+	var inside, outside int
+	for y := 10; y < 30; y++ {
+		for x := 0; x < 100; x++ {
+			v := img.GrayAt(x, y).Y
+			switch {
+			case float64(x) > 4+advance+2 && v != 0:
+				outside++
+			case v == 255:
+				inside++
+			}
+		}
+	}
+	if inside == 0 || outside != 0 {
+		t.Errorf("%d solid pixels, %d drawn outside the glyph", inside, outside)
+	}
+
+	// Drawing off the image does nothing
+	face.Draw(img, 200, 200, "off", color.White)
+}
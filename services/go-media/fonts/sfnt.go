@@ -0,0 +1,80 @@
+package fonts
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// sfnt lays tables out as a TrueType font, in the order sfntOrder gives,
+// and sets head's checkSumAdjustment so the whole font sums to the magic
+// number the format asks for
+func sfnt(tables map[string][]byte) []byte {
+	tags := sfntOrder(tables)
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+
+	be := binary.BigEndian
+	font := be.AppendUint32(nil, 0x00010000)
+	font = be.AppendUint16(font, uint16(n))
+	font = be.AppendUint16(font, uint16(searchRange))
+	font = be.AppendUint16(font, uint16(entrySelector))
+	font = be.AppendUint16(font, uint16(16*n-searchRange))
+	offset := 12 + 16*n
+	headAt := -1
+	for _, tag := range tags {
+		t := tables[tag]
+		if tag == "head" && len(t) >= 12 {
+			headAt = offset
+			t = clone(t)
+			be.PutUint32(t[8:], 0)
+			tables[tag] = t
+		}
+		font = append(font, tag...)
+		font = be.AppendUint32(font, checksum(t))
+		font = be.AppendUint32(font, uint32(offset))
+		font = be.AppendUint32(font, uint32(len(t)))
+		offset += (len(t) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		font = append(font, tables[tag]...)
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+	}
+	if headAt >= 0 {
+		be.PutUint32(font[headAt+8:], 0xb1b0afba-checksum(font))
+	}
+	return font
+}
+
+// sfntOrder returns the tags of tables sorted, but for loca, which WOFF2
+// needs right after glyf
+func sfntOrder(tables map[string][]byte) []string {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		if tag != "loca" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	if _, ok := tables["loca"]; ok {
+		i := sort.SearchStrings(tags, "glyf") + 1
+		tags = append(tags[:i], append([]string{"loca"}, tags[i:]...)...)
+	}
+	return tags
+}
+
+// checksum sums b as big-endian 32-bit words, zero padded
+func checksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(b); i += 4 {
+		var word [4]byte
+		copy(word[:], b[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,482 @@
+package fonts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrUnsupportedFont is returned for fonts Subset can't read, such as
+// OpenType fonts with CFF outlines
+var ErrUnsupportedFont = errors.New("unsupported font")
+
+// Source reports whether key names a font Subset reads, by extension.
+// .otf files are only read when they have TrueType outlines.
+func Source(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".ttf", ".otf", ".woff2":
+		return true
+	}
+	return false
+}
+
+// Subsets are the named character ranges a subset can be asked for by,
+// as Google Fonts splits its fonts
+var Subsets = map[string]*unicode.RangeTable{
+	"latin": charRanges(0x0000, 0x00ff, 0x0131, 0x0131, 0x0152, 0x0153, 0x02bb, 0x02bc, 0x02c6, 0x02c6, 0x02da, 0x02da,
+		0x02dc, 0x02dc, 0x0304, 0x0304, 0x0308, 0x0308, 0x0329, 0x0329, 0x2000, 0x206f, 0x2074, 0x2074, 0x20ac, 0x20ac,
+		0x2122, 0x2122, 0x2191, 0x2191, 0x2193, 0x2193, 0x2212, 0x2212, 0x2215, 0x2215, 0xfeff, 0xfeff, 0xfffd, 0xfffd),
+	"latin-ext": charRanges(0x0100, 0x02af, 0x0304, 0x0304, 0x0308, 0x0308, 0x0329, 0x0329, 0x1e00, 0x1e9f, 0x1ef2, 0x1eff,
+		0x2020, 0x2020, 0x20a0, 0x20ab, 0x20ad, 0x20c0, 0x2113, 0x2113, 0x2c60, 0x2c7f, 0xa720, 0xa7ff),
+	"cyrillic": charRanges(0x0301, 0x0301, 0x0400, 0x045f, 0x0490, 0x0491, 0x04b0, 0x04b1, 0x2116, 0x2116),
+	"cyrillic-ext": charRanges(0x0460, 0x052f, 0x1c80, 0x1c88, 0x20b4, 0x20b4, 0x2de0, 0x2dff, 0xa640, 0xa69f,
+		0xfe2e, 0xfe2f),
+	"greek":     charRanges(0x0370, 0x0377, 0x037a, 0x037f, 0x0384, 0x038a, 0x038c, 0x038c, 0x038e, 0x03a1, 0x03a3, 0x03ff),
+	"greek-ext": charRanges(0x1f00, 0x1fff),
+	"vietnamese": charRanges(0x0102, 0x0103, 0x0110, 0x0111, 0x0128, 0x0129, 0x0168, 0x0169, 0x01a0, 0x01a1, 0x01af, 0x01b0,
+		0x0300, 0x0301, 0x0303, 0x0304, 0x0308, 0x0309, 0x0323, 0x0323, 0x0329, 0x0329, 0x1ea0, 0x1ef9, 0x20ab, 0x20ab),
+}
+
+// charRanges builds a table of BMP ranges from first, last pairs in
+// order
+func charRanges(bounds ...uint16) *unicode.RangeTable {
+	t := &unicode.RangeTable{}
+	for i := 0; i < len(bounds); i += 2 {
+		t.R16 = append(t.R16, unicode.Range16{Lo: bounds[i], Hi: bounds[i+1], Stride: 1})
+	}
+	return t
+}
+
+// subsetTables are the tables a subset keeps. Layout tables (GSUB, GPOS,
+// GDEF) index glyphs in ways that would need rewriting, and variation
+// tables describe glyphs that are gone, so subsets drop them: variable
+// fonts become their default instance, and kerning survives only as a
+// legacy kern table.
+var subsetTables = []string{
+	"head", "hhea", "maxp", "OS/2", "hmtx", "cmap", "glyf", "loca", "post", "name",
+	"cvt ", "fpgm", "prep", "gasp", "kern",
+}
+
+// Subset returns a TrueType or WOFF2 font reduced to the characters keep
+// accepts, as WOFF2. Hinting is kept; glyph substitution and positioning
+// aren't.
+func Subset(data []byte, keep func(rune) bool) ([]byte, error) {
+	var tables map[string][]byte
+	var err error
+	switch {
+	case isWOFF2(data):
+		tables, err = decodeWOFF2(data)
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == 0x4f54544f: // "OTTO"
+		return nil, fmt.Errorf("%w: CFF outlines aren't supported", ErrUnsupportedFont)
+	default:
+		tables, err = readTables(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tables["glyf"] == nil && (tables["CFF "] != nil || tables["CFF2"] != nil) {
+		return nil, fmt.Errorf("%w: CFF outlines aren't supported", ErrUnsupportedFont)
+	}
+	f, err := parseTables(tables)
+	if err != nil {
+		return nil, err
+	}
+
+	chars := make(map[rune]int)
+	for _, c := range f.cmap {
+		for r := c.first; r <= c.last; r++ {
+			if keep(r) {
+				if g := f.Glyph(r); g != 0 {
+					chars[r] = g
+				}
+			}
+		}
+	}
+
+	glyphs, err := f.closure(chars)
+	if err != nil {
+		return nil, err
+	}
+	// New glyph indices keep the old order, so .notdef stays 0
+	old := make([]int, 0, len(glyphs))
+	for g := range glyphs {
+		old = append(old, g)
+	}
+	sort.Ints(old)
+	remap := make(map[int]int, len(old))
+	for i, g := range old {
+		remap[g] = i
+	}
+	for r, g := range chars {
+		chars[r] = remap[g]
+	}
+
+	out := make(map[string][]byte)
+	for _, tag := range subsetTables {
+		if t, ok := tables[tag]; ok {
+			out[tag] = t
+		}
+	}
+	glyf, loca, long, err := f.subsetGlyf(old, remap)
+	if err != nil {
+		return nil, err
+	}
+	out["glyf"], out["loca"] = glyf, loca
+	var numHMetrics int
+	out["hmtx"], numHMetrics = f.subsetHmtx(old)
+	if out["cmap"], err = buildCmap(chars); err != nil {
+		return nil, err
+	}
+
+	head := clone(tables["head"])
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment, set by sfnt
+	binary.BigEndian.PutUint16(head[50:], 0) // indexToLocFormat
+	if long {
+		binary.BigEndian.PutUint16(head[50:], 1)
+	}
+	out["head"] = head
+	hhea := clone(tables["hhea"])
+	binary.BigEndian.PutUint16(hhea[34:], uint16(numHMetrics))
+	out["hhea"] = hhea
+	maxp := clone(tables["maxp"])
+	binary.BigEndian.PutUint16(maxp[4:], uint16(len(old)))
+	out["maxp"] = maxp
+	if os2 := tables["OS/2"]; len(os2) >= 68 && len(chars) > 0 {
+		os2 = clone(os2)
+		first, last := rune(0xffff), rune(0)
+		for r := range chars {
+			first, last = min(first, r), max(last, r)
+		}
+		binary.BigEndian.PutUint16(os2[64:], uint16(min(first, 0xffff)))
+		binary.BigEndian.PutUint16(os2[66:], uint16(min(last, 0xffff)))
+		out["OS/2"] = os2
+	}
+	if post := tables["post"]; len(post) >= 32 {
+		// Version 3 has no glyph names
+		post = clone(post[:32])
+		binary.BigEndian.PutUint32(post, 0x00030000)
+		out["post"] = post
+	}
+	delete(out, "kern")
+	if kern := subsetKern(tables["kern"], remap); kern != nil {
+		out["kern"] = kern
+	}
+
+	if out, err = readTables(sfnt(out)); err != nil {
+		return nil, err
+	}
+	return encodeWOFF2(out)
+}
+
+// closure returns the glyphs drawing chars takes: theirs, the parts of
+// composites among them, and .notdef
+func (f *Font) closure(chars map[rune]int) (map[int]bool, error) {
+	glyphs := make(map[int]bool)
+	var add func(g, depth int) error
+	add = func(g, depth int) error {
+		if glyphs[g] || depth > maxComponentDepth {
+			return nil
+		}
+		if g >= f.numGlyphs {
+			return fmt.Errorf("%w: bad glyph %d", ErrInvalidFont, g)
+		}
+		glyphs[g] = true
+		data, err := f.glyphData(g)
+		if err != nil || len(data) < 10 || int16(u16(data, 0)) >= 0 {
+			return err
+		}
+		return forComponents(data, func(at int) error {
+			return add(int(u16(data, at)), depth+1)
+		})
+	}
+	if err := add(0, 0); err != nil {
+		return nil, err
+	}
+	for _, g := range chars {
+		if err := add(g, 0); err != nil {
+			return nil, err
+		}
+	}
+	return glyphs, nil
+}
+
+// glyphData returns the glyf entry of glyph g
+func (f *Font) glyphData(g int) ([]byte, error) {
+	start, end := f.loca[g], f.loca[g+1]
+	if start >= end {
+		return nil, nil
+	}
+	if int(end) > len(f.glyf) {
+		return nil, fmt.Errorf("%w: glyph %d out of bounds", ErrInvalidFont, g)
+	}
+	return f.glyf[start:end], nil
+}
+
+// forComponents calls fn with the offset of each component's glyph index
+// in the composite glyph data
+func forComponents(data []byte, fn func(at int) error) error {
+	for at := 10; ; {
+		if at+4 > len(data) {
+			return fmt.Errorf("%w: truncated composite glyph", ErrInvalidFont)
+		}
+		flags := u16(data, at)
+		if err := fn(at + 2); err != nil {
+			return err
+		}
+		at += 4 + 2
+		if flags&compWords != 0 {
+			at += 2
+		}
+		switch {
+		case flags&compScale != 0:
+			at += 2
+		case flags&compXYScale != 0:
+			at += 4
+		case flags&compTwoByTwo != 0:
+			at += 8
+		}
+		if flags&compMore == 0 {
+			return nil
+		}
+	}
+}
+
+// subsetGlyf copies the glyphs old into a new glyf table, renumbering
+// components, and returns it with its loca table and whether that is
+// the long format
+func (f *Font) subsetGlyf(old []int, remap map[int]int) (glyf, loca []byte, long bool, err error) {
+	offsets := make([]int, 0, len(old)+1)
+	for _, g := range old {
+		offsets = append(offsets, len(glyf))
+		data, err := f.glyphData(g)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		start := len(glyf)
+		glyf = append(glyf, data...)
+		if len(data) >= 10 && int16(u16(data, 0)) < 0 {
+			copied := glyf[start:]
+			if err := forComponents(copied, func(at int) error {
+				binary.BigEndian.PutUint16(copied[at:], uint16(remap[int(u16(copied, at))]))
+				return nil
+			}); err != nil {
+				return nil, nil, false, err
+			}
+		}
+		if len(glyf)%2 != 0 {
+			glyf = append(glyf, 0)
+		}
+	}
+	offsets = append(offsets, len(glyf))
+
+	long = len(glyf) > 0x1fffe
+	for _, off := range offsets {
+		if long {
+			loca = binary.BigEndian.AppendUint32(loca, uint32(off))
+		} else {
+			loca = binary.BigEndian.AppendUint16(loca, uint16(off/2))
+		}
+	}
+	return glyf, loca, long, nil
+}
+
+// subsetHmtx returns the metrics of the glyphs old, with the advances
+// repeated at the end left out, and how many advances it holds
+func (f *Font) subsetHmtx(old []int) ([]byte, int) {
+	lsb := func(g int) uint16 {
+		if g < f.numHMetrics {
+			return u16(f.hmtx, 4*g+2)
+		}
+		return u16(f.hmtx, 4*f.numHMetrics+2*(g-f.numHMetrics))
+	}
+	n := len(old)
+	for n > 1 && f.advance(old[n-2]) == f.advance(old[len(old)-1]) {
+		n--
+	}
+	hmtx := make([]byte, 0, 4*n+2*(len(old)-n))
+	for i, g := range old {
+		if i < n {
+			hmtx = binary.BigEndian.AppendUint16(hmtx, uint16(f.advance(g)))
+		}
+		hmtx = binary.BigEndian.AppendUint16(hmtx, lsb(g))
+	}
+	return hmtx, n
+}
+
+// buildCmap returns a cmap table mapping chars: a format 4 subtable for
+// the Basic Multilingual Plane and, when any character is beyond it, a
+// format 12 subtable for all of them
+func buildCmap(chars map[rune]int) ([]byte, error) {
+	runes := make([]rune, 0, len(chars))
+	for r := range chars {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	// Runs of consecutive characters mapped to consecutive glyphs
+	type group struct {
+		first, last rune
+		glyph       int
+	}
+	var groups []group
+	for _, r := range runes {
+		if n := len(groups); n > 0 && groups[n-1].last == r-1 && groups[n-1].glyph+int(r-groups[n-1].first) == chars[r] {
+			groups[n-1].last = r
+			continue
+		}
+		groups = append(groups, group{first: r, last: r, glyph: chars[r]})
+	}
+
+	// Format 4 takes BMP characters, ending with a segment for 0xffff;
+	// each segment maps by a delta alone
+	var segs []group
+	for _, g := range groups {
+		if g.first >= 0xffff {
+			break
+		}
+		g.last = min(g.last, 0xfffe)
+		segs = append(segs, g)
+	}
+	segs = append(segs, group{first: 0xffff, last: 0xffff, glyph: 0})
+	n := len(segs)
+	size := 16 + 8*n
+	if size > 0xffff {
+		return nil, fmt.Errorf("%w: too many characters for a cmap subtable", ErrUnsupportedFont)
+	}
+	searchRange := 2
+	for searchRange*2 <= 2*n {
+		searchRange *= 2
+	}
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	be := binary.BigEndian
+	format4 := be.AppendUint16(nil, 4)
+	format4 = be.AppendUint16(format4, uint16(size))
+	format4 = be.AppendUint16(format4, 0) // language
+	format4 = be.AppendUint16(format4, uint16(2*n))
+	format4 = be.AppendUint16(format4, uint16(searchRange))
+	format4 = be.AppendUint16(format4, uint16(entrySelector))
+	format4 = be.AppendUint16(format4, uint16(2*n-searchRange))
+	for _, s := range segs {
+		format4 = be.AppendUint16(format4, uint16(s.last))
+	}
+	format4 = be.AppendUint16(format4, 0) // reserved
+	for _, s := range segs {
+		format4 = be.AppendUint16(format4, uint16(s.first))
+	}
+	for _, s := range segs {
+		delta := uint16(s.glyph) - uint16(s.first)
+		if s.first == 0xffff {
+			delta = 1
+		}
+		format4 = be.AppendUint16(format4, delta)
+	}
+	format4 = append(format4, make([]byte, 2*n)...) // idRangeOffset
+
+	subtables := [][]byte{format4}
+	if len(runes) > 0 && runes[len(runes)-1] > 0xffff {
+		format12 := be.AppendUint16(nil, 12)
+		format12 = be.AppendUint16(format12, 0)
+		format12 = be.AppendUint32(format12, uint32(16+12*len(groups)))
+		format12 = be.AppendUint32(format12, 0) // language
+		format12 = be.AppendUint32(format12, uint32(len(groups)))
+		for _, g := range groups {
+			format12 = be.AppendUint32(format12, uint32(g.first))
+			format12 = be.AppendUint32(format12, uint32(g.last))
+			format12 = be.AppendUint32(format12, uint32(g.glyph))
+		}
+		subtables = append(subtables, format12)
+	}
+
+	cmap := be.AppendUint16(nil, 0)
+	cmap = be.AppendUint16(cmap, uint16(len(subtables)))
+	offset := 4 + 8*len(subtables)
+	for i, sub := range subtables {
+		encoding := []uint16{1, 10}[i] // Windows Unicode BMP, full repertoire
+		cmap = be.AppendUint16(cmap, 3)
+		cmap = be.AppendUint16(cmap, encoding)
+		cmap = be.AppendUint32(cmap, uint32(offset))
+		offset += len(sub)
+	}
+	for _, sub := range subtables {
+		cmap = append(cmap, sub...)
+	}
+	return cmap, nil
+}
+
+// subsetKern keeps the pairs of kept glyphs from a version 0 kern table's
+// format 0 subtables, or returns nil if none are left
+func subsetKern(kern []byte, remap map[int]int) []byte {
+	if len(kern) < 4 || u16(kern, 0) != 0 {
+		return nil
+	}
+	type pair struct {
+		left, right int
+		value       uint16
+	}
+	var subtables [][]byte
+	at := 4
+	for i := 0; i < int(u16(kern, 2)) && at+6 <= len(kern); i++ {
+		length, coverage := int(u16(kern, at+2)), u16(kern, at+4)
+		sub := kern[at:min(at+length, len(kern))]
+		at += length
+		if length < 14 || coverage>>8 != 0 {
+			continue
+		}
+		var pairs []pair
+		for j := 0; j < int(u16(sub, 6)) && 14+6*j+6 <= len(sub); j++ {
+			p := sub[14+6*j:]
+			left, okL := remap[int(u16(p, 0))]
+			right, okR := remap[int(u16(p, 2))]
+			if okL && okR {
+				pairs = append(pairs, pair{left, right, u16(p, 4)})
+			}
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].left < pairs[j].left || pairs[i].left == pairs[j].left && pairs[i].right < pairs[j].right
+		})
+		// Subtable lengths are 16 bits, so keep what fits
+		n := min(len(pairs), (0xffff-14)/6)
+		searchRange, entrySelector := 1, 0
+		for searchRange*2 <= n {
+			searchRange *= 2
+			entrySelector++
+		}
+		be := binary.BigEndian
+		out := be.AppendUint16(nil, 0)
+		out = be.AppendUint16(out, uint16(14+6*n))
+		out = be.AppendUint16(out, coverage)
+		out = be.AppendUint16(out, uint16(n))
+		out = be.AppendUint16(out, uint16(6*searchRange))
+		out = be.AppendUint16(out, uint16(entrySelector))
+		out = be.AppendUint16(out, uint16(6*(n-searchRange)))
+		for _, p := range pairs[:n] {
+			out = be.AppendUint16(out, uint16(p.left))
+			out = be.AppendUint16(out, uint16(p.right))
+			out = be.AppendUint16(out, p.value)
+		}
+		subtables = append(subtables, out)
+	}
+	if len(subtables) == 0 {
+		return nil
+	}
+	out := binary.BigEndian.AppendUint16(nil, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(subtables)))
+	for _, sub := range subtables {
+		out = append(out, sub...)
+	}
+	return out
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
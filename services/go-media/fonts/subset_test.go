@@ -0,0 +1,120 @@
+package fonts
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// readSubset decodes a subset back into a font
+func readSubset(t *testing.T, woff2 []byte) (*Font, map[string][]byte) {
+	t.Helper()
+	tables, err := decodeWOFF2(woff2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseTables(tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, tables
+}
+
+func TestSubsetText(t *testing.T) {
+	text := "Héllo, Жук"
+	out, err := Subset(regularTTF, func(r rune) bool { return strings.ContainsRune(text, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > len(regularTTF)/20 {
+		t.Errorf("subset is %d bytes of %d", len(out), len(regularTTF))
+	}
+	f, tables := readSubset(t, out)
+
+	// .notdef, the nine distinct characters and the parts of é
+	if f.numGlyphs < 10 || f.numGlyphs > 14 {
+		t.Errorf("%d glyphs", f.numGlyphs)
+	}
+	for _, r := range text {
+		if !f.HasGlyph(r) {
+			t.Errorf("no glyph for %q", r)
+		}
+		if got, want := f.advance(f.Glyph(r)), Regular.advance(Regular.Glyph(r)); got != want {
+			t.Errorf("advance(%q) = %d, want %d", r, got, want)
+		}
+	}
+	if f.HasGlyph('x') {
+		t.Error("x survived the subset")
+	}
+	if contours, err := f.outline(f.Glyph('é'), 0); err != nil || len(contours) < 2 {
+		t.Errorf("outline(é) = %d contours, %v", len(contours), err)
+	}
+	if tables["GSUB"] != nil || tables["GPOS"] != nil {
+		t.Error("layout tables kept")
+	}
+	if got := checksum(sfnt(tables)); got != 0xb1b0afba {
+		t.Errorf("font checksum = %#x", got)
+	}
+
+	// Faces draw the same from the subset
+	want, got := Face{Font: Regular, Size: 20}, Face{Font: f, Size: 20}
+	if got.Measure(text) != want.Measure(text) {
+		t.Errorf("Measure() = %v, want %v", got.Measure(text), want.Measure(text))
+	}
+}
+
+func TestSubsetRanges(t *testing.T) {
+	latin, err := Subset(regularTTF, func(r rune) bool { return unicode.Is(Subsets["latin"], r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := readSubset(t, latin)
+	for _, r := range "Aé€–" {
+		if !f.HasGlyph(r) {
+			t.Errorf("latin: no glyph for %q", r)
+		}
+	}
+	if f.HasGlyph('Ж') || f.HasGlyph('ā') {
+		t.Error("latin has glyphs outside its range")
+	}
+
+	// WOFF2 in, and only what was in the subset out
+	cyrillic, err := Subset(latin, func(r rune) bool { return unicode.Is(Subsets["cyrillic"], r) || r == 'A' })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := readSubset(t, cyrillic); !f.HasGlyph('A') || f.HasGlyph('Ж') || f.numGlyphs != 2 {
+		t.Errorf("subset of a subset has %d glyphs", f.numGlyphs)
+	}
+}
+
+func TestSubsetErrors(t *testing.T) {
+	keep := func(rune) bool { return true }
+	if _, err := Subset(append([]byte("OTTO"), make([]byte, 20)...), keep); !errors.Is(err, ErrUnsupportedFont) {
+		t.Errorf("CFF: error = %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": regularTTF[:4096],
+		"woff2":     append([]byte("wOF2"), make([]byte, 60)...),
+	} {
+		if _, err := Subset(data, keep); !errors.Is(err, ErrInvalidFont) {
+			t.Errorf("%s: error = %v", name, err)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	for key, want := range map[string]bool{
+		"fonts/Inter.ttf":   true,
+		"fonts/Inter.OTF":   true,
+		"fonts/Inter.woff2": true,
+		"fonts/Inter.woff":  false,
+		"fonts/Inter.css":   false,
+	} {
+		if got := Source(key); got != want {
+			t.Errorf("Source(%q) = %v", key, got)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+// Package fonts reads TrueType fonts and draws text with them, for the
+// images the service renders itself, such as social cards. DejaVu Sans
+// and DejaVu Sans Bold are embedded (see LICENSE-DejaVu), so rendering
+// needs no fonts installed on the host.
+//
+// Only what drawing needs is read: the character map, horizontal metrics
+// and glyph outlines. Kerning, hinting and complex shaping are not
+// applied, which suits short runs of Latin, Greek and Cyrillic text.
+//
+// The package also subsets uploaded TrueType and WOFF2 fonts to the
+// characters a page uses, as WOFF2 (see Subset).
+package fonts
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	//go:embed DejaVuSans.ttf
+	regularTTF []byte
+	//go:embed DejaVuSans-Bold.ttf
+	boldTTF []byte
+
+	// Regular and Bold are the embedded faces
+	Regular = mustParse(regularTTF)
+	Bold    = mustParse(boldTTF)
+)
+
+var ErrInvalidFont = errors.New("invalid TrueType font")
+
+// Font is a parsed TrueType font
+type Font struct {
+	unitsPerEm int
+	// ascent and descent are the typographic extents, in font units;
+	// descent is negative
+	ascent, descent, lineGap int
+	// yMin and yMax bound every glyph
+	yMin, yMax int
+
+	numGlyphs   int
+	numHMetrics int
+	hmtx        []byte
+	loca        []uint32
+	glyf        []byte
+	cmap        charMap
+}
+
+func mustParse(data []byte) *Font {
+	f, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Parse reads a TrueType (glyf-outline) font
+func Parse(data []byte) (*Font, error) {
+	tables, err := readTables(data)
+	if err != nil {
+		return nil, err
+	}
+	return parseTables(tables)
+}
+
+// parseTables reads a font from its tables by tag
+func parseTables(tables map[string][]byte) (*Font, error) {
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "loca", "glyf", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("%w: no %s table", ErrInvalidFont, tag)
+		}
+	}
+
+	f := &Font{hmtx: tables["hmtx"], glyf: tables["glyf"]}
+	head, hhea, maxp := tables["head"], tables["hhea"], tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, fmt.Errorf("%w: truncated header", ErrInvalidFont)
+	}
+	f.unitsPerEm = int(u16(head, 18))
+	if f.unitsPerEm == 0 {
+		return nil, fmt.Errorf("%w: zero units per em", ErrInvalidFont)
+	}
+	f.yMin, f.yMax = int(int16(u16(head, 38))), int(int16(u16(head, 42)))
+	f.ascent, f.descent, f.lineGap = int(int16(u16(hhea, 4))), int(int16(u16(hhea, 6))), int(int16(u16(hhea, 8)))
+	f.numHMetrics = int(u16(hhea, 34))
+	f.numGlyphs = int(u16(maxp, 4))
+	if f.numHMetrics == 0 || len(f.hmtx) < 4*f.numHMetrics {
+		return nil, fmt.Errorf("%w: truncated hmtx table", ErrInvalidFont)
+	}
+
+	loca := tables["loca"]
+	f.loca = make([]uint32, f.numGlyphs+1)
+	long := u16(head, 50) != 0
+	for i := range f.loca {
+		switch {
+		case long && len(loca) >= 4*i+4:
+			f.loca[i] = binary.BigEndian.Uint32(loca[4*i:])
+		case !long && len(loca) >= 2*i+2:
+			f.loca[i] = 2 * uint32(u16(loca, 2*i))
+		default:
+			return nil, fmt.Errorf("%w: truncated loca table", ErrInvalidFont)
+		}
+	}
+
+	var err error
+	if f.cmap, err = readCharMap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readTables returns the font's tables by tag
+func readTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidFont)
+	}
+	switch binary.BigEndian.Uint32(data) {
+	case 0x00010000, 0x74727565: // 1.0, "true"
+	default:
+		return nil, fmt.Errorf("%w: not a TrueType font", ErrInvalidFont)
+	}
+	n := int(u16(data, 4))
+	if len(data) < 12+16*n {
+		return nil, fmt.Errorf("%w: truncated table directory", ErrInvalidFont)
+	}
+	tables := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		rec := data[12+16*i:]
+		offset, length := binary.BigEndian.Uint32(rec[8:]), binary.BigEndian.Uint32(rec[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: table %s out of bounds", ErrInvalidFont, rec[:4])
+		}
+		tables[string(rec[:4])] = data[offset : offset+length]
+	}
+	return tables, nil
+}
+
+// charMap maps characters to glyphs with ranges from a format 4 or 12
+// subtable
+type charMap []charRange
+
+// charRange maps first..last to consecutive glyphs from glyph (format
+// 12), or, in format 4, to the character plus delta, or to the entry in
+// table plus delta when table is set
+type charRange struct {
+	first, last rune
+	glyph       int
+	format4     bool
+	delta       uint16
+	table       []byte
+}
+
+// readCharMap reads the best Unicode subtable: full-repertoire format 12,
+// else BMP format 4
+func readCharMap(cmap []byte) (charMap, error) {
+	if len(cmap) < 4 {
+		return nil, fmt.Errorf("%w: truncated cmap table", ErrInvalidFont)
+	}
+	var bmp, full []byte
+	for i := 0; i < int(u16(cmap, 2)); i++ {
+		rec := 4 + 8*i
+		if len(cmap) < rec+8 {
+			break
+		}
+		platform, encoding := u16(cmap, rec), u16(cmap, rec+2)
+		offset := int(binary.BigEndian.Uint32(cmap[rec+4:]))
+		if offset+4 > len(cmap) || !(platform == 0 || platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+		switch sub := cmap[offset:]; u16(sub, 0) {
+		case 4:
+			bmp = sub
+		case 12:
+			full = sub
+		}
+	}
+	switch {
+	case full != nil:
+		return readFormat12(full)
+	case bmp != nil:
+		return readFormat4(bmp)
+	}
+	return nil, fmt.Errorf("%w: no Unicode character map", ErrInvalidFont)
+}
+
+func readFormat4(sub []byte) (charMap, error) {
+	if len(sub) < 14 {
+		return nil, fmt.Errorf("%w: truncated cmap subtable", ErrInvalidFont)
+	}
+	segs := int(u16(sub, 6)) / 2
+	ends, starts := 14, 16+2*segs
+	deltas, offsets := starts+2*segs, starts+4*segs
+	if len(sub) < offsets+2*segs {
+		return nil, fmt.Errorf("%w: truncated cmap subtable", ErrInvalidFont)
+	}
+	m := make(charMap, 0, segs)
+	for i := 0; i < segs; i++ {
+		first, last := rune(u16(sub, starts+2*i)), rune(u16(sub, ends+2*i))
+		if first > last || first == 0xffff {
+			continue
+		}
+		r := charRange{first: first, last: last, format4: true, delta: u16(sub, deltas+2*i)}
+		if ro := int(u16(sub, offsets+2*i)); ro != 0 {
+			// The offset is from the idRangeOffset entry itself
+			at := offsets + 2*i + ro
+			if at > len(sub) {
+				continue
+			}
+			r.table = sub[at:]
+		}
+		m = append(m, r)
+	}
+	return m, nil
+}
+
+func readFormat12(sub []byte) (charMap, error) {
+	if len(sub) < 16 {
+		return nil, fmt.Errorf("%w: truncated cmap subtable", ErrInvalidFont)
+	}
+	n := int(binary.BigEndian.Uint32(sub[12:]))
+	if n > (len(sub)-16)/12 {
+		return nil, fmt.Errorf("%w: truncated cmap subtable", ErrInvalidFont)
+	}
+	m := make(charMap, 0, n)
+	for i := 0; i < n; i++ {
+		g := sub[16+12*i:]
+		m = append(m, charRange{
+			first: rune(binary.BigEndian.Uint32(g)),
+			last:  rune(binary.BigEndian.Uint32(g[4:])),
+			glyph: int(binary.BigEndian.Uint32(g[8:])),
+		})
+	}
+	return m, nil
+}
+
+// lookup returns the glyph for r, or 0, the missing glyph
+func (m charMap) lookup(r rune) int {
+	i := sort.Search(len(m), func(i int) bool { return m[i].last >= r })
+	if i == len(m) || m[i].first > r {
+		return 0
+	}
+	c := m[i]
+	switch {
+	case !c.format4:
+		return c.glyph + int(r-c.first)
+	case c.table == nil:
+		return int(uint16(r) + c.delta)
+	}
+	at := 2 * int(r-c.first)
+	if at+2 > len(c.table) {
+		return 0
+	}
+	if g := u16(c.table, at); g != 0 {
+		return int(g + c.delta)
+	}
+	return 0
+}
+
+// Glyph returns the glyph index for r; 0 is the font's missing glyph
+func (f *Font) Glyph(r rune) int {
+	g := f.cmap.lookup(r)
+	if g >= f.numGlyphs {
+		return 0
+	}
+	return g
+}
+
+// HasGlyph reports whether the font draws r
+func (f *Font) HasGlyph(r rune) bool {
+	return f.Glyph(r) != 0
+}
+
+// advance returns the advance width of glyph g, in font units
+func (f *Font) advance(g int) int {
+	if g >= f.numHMetrics {
+		g = f.numHMetrics - 1
+	}
+	return int(u16(f.hmtx, 4*g))
+}
+
+// point is a point of an outline, in font units with y up, or in pixels
+// with y down once placed
+type point struct {
+	x, y    float64
+	onCurve bool
+}
+
+// maxComponentDepth bounds composite glyphs made of composites
+const maxComponentDepth = 8
+
+// outline returns the contours of glyph g
+func (f *Font) outline(g, depth int) ([][]point, error) {
+	if g < 0 || g >= f.numGlyphs || depth > maxComponentDepth {
+		return nil, fmt.Errorf("%w: bad glyph %d", ErrInvalidFont, g)
+	}
+	start, end := f.loca[g], f.loca[g+1]
+	if start >= end {
+		return nil, nil // no outline, as for a space
+	}
+	if int(end) > len(f.glyf) || end-start < 10 {
+		return nil, fmt.Errorf("%w: glyph %d out of bounds", ErrInvalidFont, g)
+	}
+	data := f.glyf[start:end]
+	if n := int16(u16(data, 0)); n >= 0 {
+		return simpleOutline(data, int(n))
+	}
+	return f.compositeOutline(data[10:], depth)
+}
+
+func simpleOutline(data []byte, contours int) ([][]point, error) {
+	errTruncated := fmt.Errorf("%w: truncated glyph", ErrInvalidFont)
+	at := 10
+	if len(data) < at+2*contours+2 {
+		return nil, errTruncated
+	}
+	ends := make([]int, contours)
+	points := 0
+	for i := range ends {
+		ends[i] = int(u16(data, at+2*i))
+		if ends[i] < points-1 {
+			return nil, fmt.Errorf("%w: unordered contours", ErrInvalidFont)
+		}
+		points = ends[i] + 1
+	}
+	at += 2 * contours
+	at += 2 + int(u16(data, at)) // instructions
+
+	const (
+		onCurve  = 0x01
+		xShort   = 0x02
+		yShort   = 0x04
+		repeat   = 0x08
+		xSameOrP = 0x10
+		ySameOrP = 0x20
+	)
+	flags := make([]byte, 0, points)
+	for len(flags) < points {
+		if at >= len(data) {
+			return nil, errTruncated
+		}
+		flag := data[at]
+		at++
+		flags = append(flags, flag)
+		if flag&repeat != 0 {
+			if at >= len(data) {
+				return nil, errTruncated
+			}
+			for n := data[at]; n > 0 && len(flags) < points; n-- {
+				flags = append(flags, flag)
+			}
+			at++
+		}
+	}
+
+	pts := make([]point, points)
+	coord := func(short, sameOrPositive byte, set func(p *point, v float64)) error {
+		v := 0
+		for i, flag := range flags {
+			switch {
+			case flag&short != 0:
+				if at >= len(data) {
+					return errTruncated
+				}
+				d := int(data[at])
+				at++
+				if flag&sameOrPositive == 0 {
+					d = -d
+				}
+				v += d
+			case flag&sameOrPositive == 0:
+				if at+2 > len(data) {
+					return errTruncated
+				}
+				v += int(int16(u16(data, at)))
+				at += 2
+			}
+			set(&pts[i], float64(v))
+		}
+		return nil
+	}
+	if err := coord(xShort, xSameOrP, func(p *point, v float64) { p.x = v }); err != nil {
+		return nil, err
+	}
+	if err := coord(yShort, ySameOrP, func(p *point, v float64) { p.y = v }); err != nil {
+		return nil, err
+	}
+
+	out := make([][]point, 0, contours)
+	first := 0
+	for i, end := range ends {
+		c := pts[first : end+1]
+		for j := range c {
+			c[j].onCurve = flags[first+j]&onCurve != 0
+		}
+		if len(c) > 0 {
+			out = append(out, c)
+		}
+		first = ends[i] + 1
+	}
+	return out, nil
+}
+
+func (f *Font) compositeOutline(data []byte, depth int) ([][]point, error) {
+	const (
+		argsAreWords  = 0x0001
+		argsAreXY     = 0x0002
+		haveScale     = 0x0008
+		moreParts     = 0x0020
+		haveXYScale   = 0x0040
+		haveTwoByTwo  = 0x0080
+		componentSize = 4
+	)
+	errTruncated := fmt.Errorf("%w: truncated composite glyph", ErrInvalidFont)
+	var out [][]point
+	for at := 0; ; {
+		if at+componentSize > len(data) {
+			return nil, errTruncated
+		}
+		flags, g := u16(data, at), int(u16(data, at+2))
+		at += componentSize
+
+		var dx, dy float64
+		if flags&argsAreWords != 0 {
+			if at+4 > len(data) {
+				return nil, errTruncated
+			}
+			dx, dy = float64(int16(u16(data, at))), float64(int16(u16(data, at+2)))
+			at += 4
+		} else {
+			if at+2 > len(data) {
+				return nil, errTruncated
+			}
+			dx, dy = float64(int8(data[at])), float64(int8(data[at+1]))
+			at += 2
+		}
+		if flags&argsAreXY == 0 {
+			// Aligning matched points is rare in practice; place the
+			// part unmoved
+			dx, dy = 0, 0
+		}
+
+		a, b, c, d := 1.0, 0.0, 0.0, 1.0
+		f2dot14 := func(i int) float64 { return float64(int16(u16(data, at+2*i))) / (1 << 14) }
+		switch {
+		case flags&haveScale != 0:
+			if at+2 > len(data) {
+				return nil, errTruncated
+			}
+			a = f2dot14(0)
+			d = a
+			at += 2
+		case flags&haveXYScale != 0:
+			if at+4 > len(data) {
+				return nil, errTruncated
+			}
+			a, d = f2dot14(0), f2dot14(1)
+			at += 4
+		case flags&haveTwoByTwo != 0:
+			if at+8 > len(data) {
+				return nil, errTruncated
+			}
+			a, b, c, d = f2dot14(0), f2dot14(1), f2dot14(2), f2dot14(3)
+			at += 8
+		}
+
+		parts, err := f.outline(g, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, contour := range parts {
+			moved := make([]point, len(contour))
+			for i, p := range contour {
+				moved[i] = point{x: a*p.x + c*p.y + dx, y: b*p.x + d*p.y + dy, onCurve: p.onCurve}
+			}
+			out = append(out, moved)
+		}
+		if flags&moreParts == 0 {
+			return out, nil
+		}
+	}
+}
+
+func u16(b []byte, i int) uint16 {
+	if i+2 > len(b) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b[i:])
+}
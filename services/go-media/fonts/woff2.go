@@ -0,0 +1,603 @@
+package fonts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// WOFF2 (https://www.w3.org/TR/WOFF2/) stores a font's tables compressed
+// together with Brotli. Encoders also rewrite glyf, loca and hmtx into a
+// denser form, which decodeWOFF2 reverses; encodeWOFF2 stores every table
+// as it is.
+
+const woff2Signature = 0x774F4632 // "wOF2"
+
+// maxWOFF2Tables bounds the decompressed size of a WOFF2 font
+const maxWOFF2Tables = 128 << 20
+
+// woff2Tags are the tags a table directory entry names by index
+var woff2Tags = [...]string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post", "cvt ", "fpgm", "glyf", "loca", "prep", "CFF ",
+	"VORG", "EBDT", "EBLC", "gasp", "hdmx", "kern", "LTSH", "PCLT", "VDMX", "vhea", "vmtx", "BASE", "GDEF", "GPOS",
+	"GSUB", "EBSC", "JSTF", "MATH", "CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar", "bdat", "bloc",
+	"bsln", "cvar", "fdsc", "feat", "fmtx", "fvar", "gvar", "hsty", "just", "lcar", "mort", "morx", "opbd", "prop",
+	"trak", "Zapf", "Silf", "Glat", "Gloc", "Feat", "Sill",
+}
+
+// isWOFF2 reports whether data starts like a WOFF2 font
+func isWOFF2(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == woff2Signature
+}
+
+// woff2Table is a table directory entry
+type woff2Table struct {
+	tag         string
+	transformed bool
+	origLength  uint32
+	length      uint32 // in the decompressed stream
+}
+
+// decodeWOFF2 returns the tables of a WOFF2 font, with the glyf, loca
+// and hmtx transforms reversed. Font collections aren't read.
+func decodeWOFF2(data []byte) (map[string][]byte, error) {
+	if len(data) < 48 || !isWOFF2(data) {
+		return nil, fmt.Errorf("%w: not a WOFF2 font", ErrInvalidFont)
+	}
+	if binary.BigEndian.Uint32(data[4:]) == 0x74746366 { // "ttcf"
+		return nil, fmt.Errorf("%w: font collections aren't supported", ErrUnsupportedFont)
+	}
+	n := int(u16(data, 12))
+	compressed := int(binary.BigEndian.Uint32(data[20:]))
+
+	r := bytes.NewReader(data[48:])
+	entries := make([]woff2Table, n)
+	var total uint64
+	for i := range entries {
+		flags, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated table directory", ErrInvalidFont)
+		}
+		e := &entries[i]
+		if idx := int(flags & 0x3f); idx < len(woff2Tags) {
+			e.tag = woff2Tags[idx]
+		} else {
+			var tag [4]byte
+			if _, err := io.ReadFull(r, tag[:]); err != nil {
+				return nil, fmt.Errorf("%w: truncated table directory", ErrInvalidFont)
+			}
+			e.tag = string(tag[:])
+		}
+		// Transform 0 is the glyf and loca transform, and none for
+		// other tables; 3 is none for glyf and loca
+		version := flags >> 6
+		if e.tag == "glyf" || e.tag == "loca" {
+			e.transformed = version == 0
+		} else {
+			e.transformed = version != 0
+		}
+		if e.origLength, err = readUIntBase128(r); err != nil {
+			return nil, err
+		}
+		e.length = e.origLength
+		if e.transformed {
+			if e.length, err = readUIntBase128(r); err != nil {
+				return nil, err
+			}
+		}
+		total += uint64(e.length)
+	}
+	if total > maxWOFF2Tables {
+		return nil, fmt.Errorf("%w: tables too large", ErrInvalidFont)
+	}
+
+	start := len(data) - r.Len()
+	if compressed < 0 || start+compressed > len(data) {
+		return nil, fmt.Errorf("%w: truncated compressed data", ErrInvalidFont)
+	}
+	stream := make([]byte, total)
+	br := brotli.NewReader(bytes.NewReader(data[start : start+compressed]))
+	if _, err := io.ReadFull(br, stream); err != nil {
+		return nil, fmt.Errorf("%w: decompressing: %v", ErrInvalidFont, err)
+	}
+
+	tables := make(map[string][]byte, n)
+	transformed := make(map[string]bool, n)
+	at := uint32(0)
+	for _, e := range entries {
+		tables[e.tag] = stream[at : at+e.length]
+		transformed[e.tag] = e.transformed
+		at += e.length
+	}
+
+	var xMins []int16
+	if transformed["glyf"] {
+		glyf, loca, mins, err := decodeGlyfTransform(tables["glyf"])
+		if err != nil {
+			return nil, err
+		}
+		tables["glyf"], tables["loca"], xMins = glyf, loca, mins
+	}
+	if transformed["hmtx"] {
+		if xMins == nil {
+			return nil, fmt.Errorf("%w: transformed hmtx without transformed glyf", ErrInvalidFont)
+		}
+		hmtx, err := decodeHmtxTransform(tables["hmtx"], tables["hhea"], xMins)
+		if err != nil {
+			return nil, err
+		}
+		tables["hmtx"] = hmtx
+	}
+	return tables, nil
+}
+
+// readUIntBase128 reads a WOFF2 variable-length integer: seven bits per
+// byte, high bit set on all but the last
+func readUIntBase128(r io.ByteReader) (uint32, error) {
+	var v uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil || i == 0 && b == 0x80 || v&0xfe000000 != 0 {
+			return 0, fmt.Errorf("%w: bad table length", ErrInvalidFont)
+		}
+		v = v<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: bad table length", ErrInvalidFont)
+}
+
+func appendUIntBase128(b []byte, v uint32) []byte {
+	n := 1
+	for x := v >> 7; x != 0; x >>= 7 {
+		n++
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := byte(v>>(7*i)) & 0x7f
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// stream is one of the substreams of a transformed glyf table
+type stream struct {
+	data []byte
+	at   int
+}
+
+var errTruncatedGlyf = fmt.Errorf("%w: truncated transformed glyf table", ErrInvalidFont)
+
+func (s *stream) bytes(n int) ([]byte, error) {
+	if n < 0 || s.at+n > len(s.data) {
+		return nil, errTruncatedGlyf
+	}
+	b := s.data[s.at : s.at+n]
+	s.at += n
+	return b, nil
+}
+
+func (s *stream) u8() (int, error) {
+	b, err := s.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return int(b[0]), nil
+}
+
+func (s *stream) u16() (int, error) {
+	b, err := s.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(b)), nil
+}
+
+// read255UInt16 reads WOFF2's variable-length 16-bit integer
+func (s *stream) read255UInt16() (int, error) {
+	code, err := s.u8()
+	if err != nil {
+		return 0, err
+	}
+	switch code {
+	case 253:
+		return s.u16()
+	case 254:
+		b, err := s.u8()
+		return b + 2*253, err
+	case 255:
+		b, err := s.u8()
+		return b + 253, err
+	}
+	return code, nil
+}
+
+// Simple glyph flags
+const (
+	flagOnCurve  = 0x01
+	flagXShort   = 0x02
+	flagYShort   = 0x04
+	flagXSame    = 0x10
+	flagYSame    = 0x20
+	flagOverlap  = 0x40
+	compWords    = 0x0001
+	compScale    = 0x0008
+	compMore     = 0x0020
+	compXYScale  = 0x0040
+	compTwoByTwo = 0x0080
+	compInstrs   = 0x0100
+)
+
+// decodeGlyfTransform rebuilds the glyf and loca tables from WOFF2's
+// transformed glyf, and returns the xMin of each glyph for rebuilding
+// hmtx
+func decodeGlyfTransform(t []byte) (glyf, loca []byte, xMins []int16, err error) {
+	if len(t) < 36 {
+		return nil, nil, nil, errTruncatedGlyf
+	}
+	options := u16(t, 2)
+	numGlyphs := int(u16(t, 4))
+	longLoca := u16(t, 6) != 0
+	streams := make([]*stream, 7)
+	at := 36
+	for i := range streams {
+		size := int(binary.BigEndian.Uint32(t[8+4*i:]))
+		if size < 0 || at+size > len(t) {
+			return nil, nil, nil, errTruncatedGlyf
+		}
+		streams[i] = &stream{data: t[at : at+size]}
+		at += size
+	}
+	nContours, nPoints, flagStream, glyphStream, composites, bboxes, instructions :=
+		streams[0], streams[1], streams[2], streams[3], streams[4], streams[5], streams[6]
+
+	bitmapSize := 4 * ((numGlyphs + 31) / 32)
+	bboxBitmap, err := bboxes.bytes(bitmapSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var overlapBitmap []byte
+	if options&1 != 0 {
+		size := (numGlyphs + 7) / 8
+		if at+size > len(t) {
+			return nil, nil, nil, errTruncatedGlyf
+		}
+		overlapBitmap = t[at : at+size]
+	}
+	bit := func(bitmap []byte, i int) bool {
+		return bitmap != nil && bitmap[i>>3]&(0x80>>(i&7)) != 0
+	}
+
+	xMins = make([]int16, numGlyphs)
+	offsets := make([]int, numGlyphs+1)
+	for g := 0; g < numGlyphs; g++ {
+		offsets[g] = len(glyf)
+		contours, err := nContours.u16()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		var bbox []byte
+		if bit(bboxBitmap, g) {
+			if bbox, err = bboxes.bytes(8); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+
+		switch n := int16(contours); {
+		case n == 0:
+			if bbox != nil {
+				return nil, nil, nil, fmt.Errorf("%w: empty glyph with a bounding box", ErrInvalidFont)
+			}
+		case n > 0:
+			glyf, err = appendSimpleGlyph(glyf, int(n), bbox, bit(overlapBitmap, g), nPoints, flagStream, glyphStream, instructions)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			xMins[g] = int16(u16(glyf, offsets[g]+2))
+		default:
+			if bbox == nil {
+				return nil, nil, nil, fmt.Errorf("%w: composite glyph without a bounding box", ErrInvalidFont)
+			}
+			glyf = append(glyf, 0xff, 0xff)
+			glyf = append(glyf, bbox...)
+			xMins[g] = int16(u16(bbox, 0))
+			start := composites.at
+			hasInstructions := false
+			for more := true; more; {
+				flags, err := composites.u16()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				size := 2 + 2 // glyph index and byte arguments
+				if flags&compWords != 0 {
+					size += 2
+				}
+				switch {
+				case flags&compScale != 0:
+					size += 2
+				case flags&compXYScale != 0:
+					size += 4
+				case flags&compTwoByTwo != 0:
+					size += 8
+				}
+				if _, err := composites.bytes(size); err != nil {
+					return nil, nil, nil, err
+				}
+				hasInstructions = hasInstructions || flags&compInstrs != 0
+				more = flags&compMore != 0
+			}
+			glyf = append(glyf, composites.data[start:composites.at]...)
+			if hasInstructions {
+				n, err := glyphStream.read255UInt16()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				code, err := instructions.bytes(n)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				glyf = binary.BigEndian.AppendUint16(glyf, uint16(n))
+				glyf = append(glyf, code...)
+			}
+		}
+		for len(glyf)%4 != 0 {
+			glyf = append(glyf, 0)
+		}
+	}
+	offsets[numGlyphs] = len(glyf)
+
+	for _, off := range offsets {
+		if longLoca {
+			loca = binary.BigEndian.AppendUint32(loca, uint32(off))
+		} else {
+			loca = binary.BigEndian.AppendUint16(loca, uint16(off/2))
+		}
+	}
+	return glyf, loca, xMins, nil
+}
+
+// appendSimpleGlyph decodes a simple glyph of n contours from the
+// transformed streams and appends it to glyf as TrueType stores it
+func appendSimpleGlyph(glyf []byte, n int, bbox []byte, overlap bool, nPoints, flagStream, glyphStream, instructions *stream) ([]byte, error) {
+	ends := make([]int, n)
+	points := 0
+	for i := range ends {
+		count, err := nPoints.read255UInt16()
+		if err != nil {
+			return nil, err
+		}
+		points += count
+		ends[i] = points - 1
+	}
+	flags, err := flagStream.bytes(points)
+	if err != nil {
+		return nil, err
+	}
+
+	xs, ys := make([]int, points), make([]int, points)
+	x, y := 0, 0
+	for i, flag := range flags {
+		dx, dy, err := decodeTriplet(flag&0x7f, glyphStream)
+		if err != nil {
+			return nil, err
+		}
+		x, y = x+dx, y+dy
+		xs[i], ys[i] = x, y
+	}
+	instrLen, err := glyphStream.read255UInt16()
+	if err != nil {
+		return nil, err
+	}
+	code, err := instructions.bytes(instrLen)
+	if err != nil {
+		return nil, err
+	}
+
+	glyf = binary.BigEndian.AppendUint16(glyf, uint16(n))
+	if bbox != nil {
+		glyf = append(glyf, bbox...)
+	} else {
+		xMin, yMin, xMax, yMax := 0, 0, 0, 0
+		for i := range xs {
+			if i == 0 || xs[i] < xMin {
+				xMin = xs[i]
+			}
+			if i == 0 || xs[i] > xMax {
+				xMax = xs[i]
+			}
+			if i == 0 || ys[i] < yMin {
+				yMin = ys[i]
+			}
+			if i == 0 || ys[i] > yMax {
+				yMax = ys[i]
+			}
+		}
+		for _, v := range []int{xMin, yMin, xMax, yMax} {
+			glyf = binary.BigEndian.AppendUint16(glyf, uint16(v))
+		}
+	}
+	for _, end := range ends {
+		glyf = binary.BigEndian.AppendUint16(glyf, uint16(end))
+	}
+	glyf = binary.BigEndian.AppendUint16(glyf, uint16(instrLen))
+	glyf = append(glyf, code...)
+
+	// Flags, then x and y deltas, each as short as it can be
+	var xData, yData []byte
+	prevX, prevY := 0, 0
+	for i, flag := range flags {
+		f := byte(0)
+		if flag&0x80 == 0 {
+			f |= flagOnCurve
+		}
+		if i == 0 && overlap {
+			f |= flagOverlap
+		}
+		var bits byte
+		bits, xData = appendDelta(xData, xs[i]-prevX, flagXShort, flagXSame)
+		f |= bits
+		bits, yData = appendDelta(yData, ys[i]-prevY, flagYShort, flagYSame)
+		f |= bits
+		prevX, prevY = xs[i], ys[i]
+		glyf = append(glyf, f)
+	}
+	glyf = append(glyf, xData...)
+	return append(glyf, yData...), nil
+}
+
+// appendDelta appends a coordinate delta and returns the flag bits
+// describing it
+func appendDelta(data []byte, d int, short, same byte) (byte, []byte) {
+	switch {
+	case d == 0:
+		return same, data
+	case d > 0 && d < 256:
+		return short | same, append(data, byte(d))
+	case d < 0 && d > -256:
+		return short, append(data, byte(-d))
+	}
+	return 0, binary.BigEndian.AppendUint16(data, uint16(int16(d)))
+}
+
+// decodeTriplet reads the point delta WOFF2 encodes as a flag and one to
+// four bytes of glyphStream
+func decodeTriplet(flag byte, glyphStream *stream) (dx, dy int, err error) {
+	withSign := func(f, v int) int {
+		if f&1 != 0 {
+			return v
+		}
+		return -v
+	}
+	f := int(flag)
+	var size int
+	switch {
+	case f < 84:
+		size = 1
+	case f < 120:
+		size = 2
+	case f < 124:
+		size = 3
+	default:
+		size = 4
+	}
+	b, err := glyphStream.bytes(size)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case f < 10:
+		return 0, withSign(f, (f&14)<<7+int(b[0])), nil
+	case f < 20:
+		return withSign(f, ((f-10)&14)<<7+int(b[0])), 0, nil
+	case f < 84:
+		b0, b1 := f-20, int(b[0])
+		return withSign(f, 1+b0&0x30+b1>>4), withSign(f>>1, 1+(b0&0x0c)<<2+b1&0x0f), nil
+	case f < 120:
+		b0 := f - 84
+		return withSign(f, 1+(b0/12)<<8+int(b[0])), withSign(f>>1, 1+((b0%12)>>2)<<8+int(b[1])), nil
+	case f < 124:
+		b2 := int(b[1])
+		return withSign(f, int(b[0])<<4+b2>>4), withSign(f>>1, (b2&0x0f)<<8+int(b[2])), nil
+	}
+	return withSign(f, int(b[0])<<8+int(b[1])), withSign(f>>1, int(b[2])<<8+int(b[3])), nil
+}
+
+// decodeHmtxTransform rebuilds hmtx from WOFF2's transformed hmtx, whose
+// left side bearings may be left out where they equal the glyph's xMin
+func decodeHmtxTransform(t, hhea []byte, xMins []int16) ([]byte, error) {
+	if len(hhea) < 36 || len(t) < 1 {
+		return nil, fmt.Errorf("%w: truncated hmtx table", ErrInvalidFont)
+	}
+	numGlyphs, numHMetrics := len(xMins), int(u16(hhea, 34))
+	if numHMetrics < 1 || numHMetrics > numGlyphs {
+		return nil, fmt.Errorf("%w: bad number of horizontal metrics", ErrInvalidFont)
+	}
+	s := &stream{data: t[1:]}
+	flags := t[0]
+	advances, err := s.bytes(2 * numHMetrics)
+	if err != nil {
+		return nil, err
+	}
+	lsb := func(g int, stored bool) (uint16, error) {
+		if !stored {
+			return uint16(xMins[g]), nil
+		}
+		v, err := s.u16()
+		return uint16(v), err
+	}
+
+	hmtx := make([]byte, 0, 4*numHMetrics+2*(numGlyphs-numHMetrics))
+	for g := 0; g < numHMetrics; g++ {
+		v, err := lsb(g, flags&1 == 0)
+		if err != nil {
+			return nil, err
+		}
+		hmtx = append(hmtx, advances[2*g:2*g+2]...)
+		hmtx = binary.BigEndian.AppendUint16(hmtx, v)
+	}
+	for g := numHMetrics; g < numGlyphs; g++ {
+		v, err := lsb(g, flags&2 == 0)
+		if err != nil {
+			return nil, err
+		}
+		hmtx = binary.BigEndian.AppendUint16(hmtx, v)
+	}
+	return hmtx, nil
+}
+
+// encodeWOFF2 packs TrueType tables as WOFF2, without transforming them
+func encodeWOFF2(tables map[string][]byte) ([]byte, error) {
+	tags := sfntOrder(tables)
+	var dir, data []byte
+	sfntSize := 12 + 16*len(tags)
+	for _, tag := range tags {
+		t := tables[tag]
+		idx := 63
+		for i, known := range woff2Tags {
+			if known == tag {
+				idx = i
+				break
+			}
+		}
+		flags := byte(idx)
+		if tag == "glyf" || tag == "loca" {
+			flags |= 3 << 6 // not transformed
+		}
+		dir = append(dir, flags)
+		if idx == 63 {
+			dir = append(dir, tag...)
+		}
+		dir = appendUIntBase128(dir, uint32(len(t)))
+		data = append(data, t...)
+		sfntSize += (len(t) + 3) &^ 3
+	}
+
+	var compressed bytes.Buffer
+	w := brotli.NewWriterOptions(&compressed, brotli.WriterOptions{Quality: brotli.BestCompression, LGWin: 22})
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	length := (48 + len(dir) + compressed.Len() + 3) &^ 3
+	out := make([]byte, 48, length)
+	be := binary.BigEndian
+	be.PutUint32(out[0:], woff2Signature)
+	be.PutUint32(out[4:], 0x00010000)
+	be.PutUint32(out[8:], uint32(length))
+	be.PutUint16(out[12:], uint16(len(tags)))
+	be.PutUint32(out[16:], uint32(sfntSize))
+	be.PutUint32(out[20:], uint32(compressed.Len()))
+	be.PutUint16(out[24:], 1) // version 1.0
+	out = append(out, dir...)
+	out = append(out, compressed.Bytes()...)
+	return out[:length], nil
+}
@@ -0,0 +1,125 @@
+package fonts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeTriplet(t *testing.T) {
+	for _, tt := range []struct {
+		flag   byte
+		data   []byte
+		dx, dy int
+	}{
+		{1, []byte{100}, 0, 100},
+		{6, []byte{1}, 0, -769},
+		{11, []byte{50}, 50, 0},
+		{23, []byte{0x25}, 3, 6},
+		{84, []byte{0x10, 0x20}, -17, -33},
+		{121, []byte{0x12, 0x34, 0x56}, 291, -1110},
+		{127, []byte{0x01, 0x02, 0x03, 0x04}, 258, 772},
+	} {
+		dx, dy, err := decodeTriplet(tt.flag, &stream{data: tt.data})
+		if err != nil || dx != tt.dx || dy != tt.dy {
+			t.Errorf("decodeTriplet(%d, %v) = %d, %d, %v; want %d, %d", tt.flag, tt.data, dx, dy, err, tt.dx, tt.dy)
+		}
+	}
+	if _, _, err := decodeTriplet(127, &stream{data: []byte{1}}); err == nil {
+		t.Error("short triplet decoded")
+	}
+}
+
+// TestGlyfTransform decodes a transformed glyf table of a triangle and
+// an empty glyph
+func TestGlyfTransform(t *testing.T) {
+	streams := [][]byte{
+		{0, 1, 0, 0}, // contours
+		{3},          // points
+		{1, 11, 0x80 | 0},
+		{100, 50, 100, 0}, // (0,100), (50,100), (50,0); no instructions
+		nil,
+		make([]byte, 4), // no explicit bounding boxes
+		nil,
+	}
+	table := binary.BigEndian.AppendUint32(nil, 0)
+	table = binary.BigEndian.AppendUint16(table, 2)
+	table = binary.BigEndian.AppendUint16(table, 0)
+	for _, s := range streams {
+		table = binary.BigEndian.AppendUint32(table, uint32(len(s)))
+	}
+	table = append(table, bytes.Join(streams, nil)...)
+
+	glyf, loca, xMins, err := decodeGlyfTransform(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end := int(u16(loca, 2)) * 2; len(loca) != 6 || u16(loca, 0) != 0 || end != len(glyf) || u16(loca, 4) != u16(loca, 2) {
+		t.Fatalf("loca = %v for %d bytes of glyf", loca, len(glyf))
+	}
+	if bbox := glyf[2:10]; !bytes.Equal(bbox, []byte{0, 0, 0, 0, 0, 50, 0, 100}) || xMins[0] != 0 {
+		t.Errorf("bounding box = %v", bbox)
+	}
+	contours, err := simpleOutline(glyf, 1)
+	if err != nil || len(contours) != 1 {
+		t.Fatalf("outline = %v, %v", contours, err)
+	}
+	want := []point{{0, 100, true}, {50, 100, true}, {50, 0, false}}
+	for i, p := range contours[0] {
+		if p != want[i] {
+			t.Errorf("point %d = %v, want %v", i, p, want[i])
+		}
+	}
+
+	if _, _, _, err := decodeGlyfTransform(table[:len(table)-3]); err == nil {
+		t.Error("truncated table decoded")
+	}
+}
+
+func TestHmtxTransform(t *testing.T) {
+	hhea := make([]byte, 36)
+	binary.BigEndian.PutUint16(hhea[34:], 2)
+	// Advances 500 and 600; proportional bearings left out, the
+	// monospaced one given
+	hmtx, err := decodeHmtxTransform([]byte{1, 0x01, 0xf4, 0x02, 0x58, 0xff, 0xf6}, hhea, []int16{10, 20, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x01, 0xf4, 0, 10, 0x02, 0x58, 0, 20, 0xff, 0xf6}; !bytes.Equal(hmtx, want) {
+		t.Errorf("hmtx = %v, want %v", hmtx, want)
+	}
+}
+
+func TestWOFF2RoundTrip(t *testing.T) {
+	tables, err := readTables(regularTTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encodeWOFF2(tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out)%4 != 0 || int(binary.BigEndian.Uint32(out[8:])) != len(out) || len(out) >= len(regularTTF)/2 {
+		t.Errorf("WOFF2 is %d bytes, header says %d", len(out), binary.BigEndian.Uint32(out[8:]))
+	}
+	decoded, err := decodeWOFF2(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, data := range tables {
+		if !bytes.Equal(decoded[tag], data) {
+			t.Errorf("%s differs", tag)
+		}
+	}
+
+	var b []byte
+	for _, v := range []uint32{0, 127, 128, 16384, 1<<32 - 1} {
+		b = appendUIntBase128(b[:0], v)
+		if got, err := readUIntBase128(bytes.NewReader(b)); err != nil || got != v {
+			t.Errorf("UIntBase128 %d = %d, %v", v, got, err)
+		}
+	}
+	if _, err := readUIntBase128(bytes.NewReader([]byte{0x80, 1})); err == nil {
+		t.Error("leading zero accepted")
+	}
+}
@@ -0,0 +1,345 @@
+// Package grpcapi serves the MediaService gRPC API defined in
+// proto/media/v1/media.proto.
+package grpcapi
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=module=github.com/WomB0ComB0/cdn/services/go-media/grpcapi --go-grpc_out=. --go-grpc_opt=module=github.com/WomB0ComB0/cdn/services/go-media/grpcapi media/v1/media.proto
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/grpcapi/mediapb"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/reporting"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// chunkSize is the size of content messages sent by Download
+const chunkSize = 64 << 10
+
+// Server implements MediaService on top of the HTTP handlers' asset
+// operations, so both APIs share validation, indexing, events and audit
+type Server struct {
+	mediapb.UnimplementedMediaServiceServer
+
+	media         *handlers.MediaHandler
+	auditLog      *audit.Log
+	reporter      *reporting.Reporter
+	maxUploadSize int64
+	readOnly      bool
+}
+
+// Option configures optional Server dependencies
+type Option func(*Server)
+
+// WithAuditLog records uploads, signs and deletes
+func WithAuditLog(l *audit.Log) Option {
+	return func(s *Server) {
+		s.auditLog = l
+	}
+}
+
+// WithReporter forwards storage errors to an error reporter
+func WithReporter(r *reporting.Reporter) Option {
+	return func(s *Server) {
+		s.reporter = r
+	}
+}
+
+// WithMaxUploadSize limits the size of a single upload
+func WithMaxUploadSize(n int64) Option {
+	return func(s *Server) {
+		s.maxUploadSize = n
+	}
+}
+
+// WithReadOnly rejects uploads and deletes, for read-only replicas
+func WithReadOnly(readOnly bool) Option {
+	return func(s *Server) {
+		s.readOnly = readOnly
+	}
+}
+
+// errReadOnly is returned for writes to a read-only replica
+var errReadOnly = status.Error(codes.FailedPrecondition, "read-only replica; send writes to the primary instance")
+
+// NewServer creates the MediaService implementation
+func NewServer(media *handlers.MediaHandler, opts ...Option) *Server {
+	s := &Server{media: media, maxUploadSize: 100 << 20}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewGRPCServer returns a gRPC server with MediaService registered. The
+// token is required as "authorization: Bearer <token>"; without one,
+// every call is refused.
+func NewGRPCServer(s *Server, token string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	mediapb.RegisterMediaServiceServer(srv, s)
+	return srv
+}
+
+func authorize(ctx context.Context, token string) error {
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "the gRPC API has no token configured")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(v, "Bearer ")), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+// Upload receives the file info followed by content chunks
+func (s *Server) Upload(stream mediapb.MediaService_UploadServer) error {
+	if s.readOnly {
+		return errReadOnly
+	}
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	info := first.GetInfo()
+	if info == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry file info")
+	}
+
+	var buf bytes.Buffer
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if msg.GetInfo() != nil {
+			return status.Error(codes.InvalidArgument, "file info sent twice")
+		}
+		if int64(buf.Len()+len(msg.GetChunk())) > s.maxUploadSize {
+			return status.Errorf(codes.InvalidArgument, "file too large (max %dMB)", s.maxUploadSize>>20)
+		}
+		buf.Write(msg.GetChunk())
+	}
+
+	key, err := handlers.UploadKey(info.GetFilename(), buf.Bytes())
+	if err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		s.audit(ctx, "asset.upload", "", nil, err)
+		return err
+	}
+
+	resp, err := s.media.StoreUpload(context.Background(), key, info.GetFilename(), info.GetContentType(), buf.Bytes())
+	if err != nil {
+		err = s.keyStatus(err, "failed to upload")
+	}
+	s.audit(ctx, "asset.upload", key, map[string]string{"filename": info.GetFilename()}, err)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&mediapb.UploadResponse{Url: resp.URL, Key: resp.Key, Etag: resp.ETag})
+}
+
+// Download streams the object metadata followed by its content
+func (s *Server) Download(req *mediapb.DownloadRequest, stream mediapb.MediaService_DownloadServer) error {
+	if req.GetKey() == "" {
+		return status.Error(codes.InvalidArgument, "key is required")
+	}
+
+	obj, err := s.media.OpenAsset(stream.Context(), req.GetKey())
+	if err != nil {
+		return status.Error(codes.NotFound, "object not found")
+	}
+	defer obj.Body.Close()
+
+	info := &mediapb.ObjectInfo{
+		Key:         req.GetKey(),
+		Size:        aws.ToInt64(obj.ContentLength),
+		ContentType: aws.ToString(obj.ContentType),
+		Etag:        aws.ToString(obj.ETag),
+	}
+	if obj.LastModified != nil {
+		info.LastModified = timestamppb.New(*obj.LastModified)
+	}
+	if err := stream.Send(&mediapb.DownloadResponse{Data: &mediapb.DownloadResponse_Info{Info: info}}); err != nil {
+		return err
+	}
+
+	var served int64
+	defer func() { s.media.RecordServed(req.GetKey(), served) }()
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := obj.Body.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&mediapb.DownloadResponse{Data: &mediapb.DownloadResponse_Chunk{Chunk: buf[:n]}}); err != nil {
+				return err
+			}
+			served += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Error(codes.Unavailable, "storage read failed")
+		}
+	}
+}
+
+// List returns objects under a prefix
+func (s *Server) List(ctx context.Context, req *mediapb.ListRequest) (*mediapb.ListResponse, error) {
+	limit := req.GetLimit()
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	objects, err := s.media.ListObjects(ctx, req.GetPrefix(), limit)
+	if err != nil {
+		s.reporter.CaptureError(nil, err)
+		return nil, status.Error(codes.Internal, "failed to list objects")
+	}
+
+	resp := &mediapb.ListResponse{Objects: make([]*mediapb.ObjectInfo, 0, len(objects))}
+	for _, o := range objects {
+		resp.Objects = append(resp.Objects, &mediapb.ObjectInfo{
+			Key:          o.Key,
+			Size:         o.Size,
+			ContentType:  o.ContentType,
+			Etag:         o.ETag,
+			LastModified: timestamppb.New(o.LastModified),
+		})
+	}
+	return resp, nil
+}
+
+// Sign creates a signed URL for a private object
+func (s *Server) Sign(ctx context.Context, req *mediapb.SignRequest) (*mediapb.SignResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	expiresIn := time.Duration(req.GetExpiresInSeconds()) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+
+	signed := s.media.SignURL(req.GetKey(), expiresIn, handlers.URLOptions{})
+	s.audit(ctx, "url.sign", req.GetKey(), nil, nil)
+
+	return &mediapb.SignResponse{Url: signed.URL, ExpiresAt: timestamppb.New(signed.ExpiresAt)}, nil
+}
+
+// Delete removes an object
+func (s *Server) Delete(ctx context.Context, req *mediapb.DeleteRequest) (*mediapb.DeleteResponse, error) {
+	if s.readOnly {
+		return nil, errReadOnly
+	}
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+
+	err := s.media.RemoveAsset(ctx, req.GetKey())
+	if err != nil {
+		err = s.keyStatus(err, "failed to delete")
+	}
+	s.audit(ctx, "asset.delete", req.GetKey(), nil, err)
+	if err != nil {
+		return nil, err
+	}
+	return &mediapb.DeleteResponse{}, nil
+}
+
+// keyStatus maps a write or delete error to a status: key policy errors
+// are the caller's, anything else is reported and hidden behind msg
+func (s *Server) keyStatus(err error, msg string) error {
+	switch {
+	case errors.Is(err, handlers.ErrReservedKey):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, handlers.ErrInvalidKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.reporter.CaptureError(nil, err)
+	return status.Error(codes.Internal, msg)
+}
+
+// audit records a mutating call with the HTTP-equivalent status so gRPC
+// and HTTP entries can be queried together
+func (s *Server) audit(ctx context.Context, action, key string, details map[string]string, err error) {
+	if s.auditLog == nil {
+		return
+	}
+
+	rec := audit.Record{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Key:     key,
+		Actor:   "anonymous",
+		Status:  httpStatus(status.Code(err)),
+		Result:  "success",
+		Details: map[string]string{"transport": "grpc"},
+	}
+	for k, v := range details {
+		rec.Details[k] = v
+	}
+	if err != nil {
+		rec.Result = "failure"
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			rec.Actor = audit.TokenActor(strings.TrimPrefix(v[0], "Bearer "))
+		}
+		if v := md.Get("user-agent"); len(v) > 0 {
+			rec.UserAgent = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		rec.SourceIP = p.Addr.String()
+	}
+	s.auditLog.Append(rec)
+}
+
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return 200
+	case codes.InvalidArgument:
+		return 400
+	case codes.Unauthenticated:
+		return 401
+	case codes.NotFound:
+		return 404
+	default:
+		return 500
+	}
+}
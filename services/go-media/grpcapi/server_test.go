@@ -0,0 +1,164 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/grpcapi/mediapb"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testToken is the token newTestClient's server requires and its client
+// sends
+const testToken = "internal-token"
+
+// newTestClient returns a client authorized to call a server with opts
+func newTestClient(t *testing.T, opts ...Option) mediapb.MediaServiceClient {
+	t.Helper()
+	withToken := func(ctx context.Context) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+testToken)
+	}
+	return dialTestServer(t, testToken, []grpc.DialOption{
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+			return invoker(withToken(ctx), method, req, reply, cc, callOpts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(withToken(ctx), desc, cc, method, callOpts...)
+		}),
+	}, opts...)
+}
+
+// dialTestServer starts a server requiring token and connects to it
+func dialTestServer(t *testing.T, token string, dialOpts []grpc.DialOption, opts ...Option) mediapb.MediaServiceClient {
+	t.Helper()
+
+	ln := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(NewServer(handlers.NewMediaHandler(nil, "secret"), opts...), token)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return mediapb.NewMediaServiceClient(conn)
+}
+
+func TestAuthorization(t *testing.T) {
+	client := dialTestServer(t, testToken, nil)
+	unconfigured := dialTestServer(t, "", nil)
+	req := &mediapb.SignRequest{Key: "private/report.pdf"}
+
+	tests := []struct {
+		name   string
+		client mediapb.MediaServiceClient
+		auth   string
+		want   codes.Code
+	}{
+		{"missing token", client, "", codes.Unauthenticated},
+		{"wrong token", client, "Bearer nope", codes.Unauthenticated},
+		{"valid token", client, "Bearer internal-token", codes.OK},
+		{"no token configured", unconfigured, "", codes.Unauthenticated},
+		{"any token without one configured", unconfigured, "Bearer ", codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.auth != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.auth)
+		}
+		_, err := tt.client.Sign(ctx, req)
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("%s: code = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	client := newTestClient(t)
+
+	resp, err := client.Sign(context.Background(), &mediapb.SignRequest{Key: "private/report.pdf", ExpiresInSeconds: 60})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if !strings.Contains(resp.Url, "/v1/media/private/private/report.pdf?exp=") || !strings.Contains(resp.Url, "&sig=") {
+		t.Errorf("Url = %q, want signed private URL", resp.Url)
+	}
+	if resp.ExpiresAt == nil {
+		t.Error("ExpiresAt not set")
+	}
+
+	if _, err := client.Sign(context.Background(), &mediapb.SignRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Sign() without key code = %v, want InvalidArgument", status.Code(err))
+	}
+}
+
+func TestUploadValidation(t *testing.T) {
+	client := newTestClient(t)
+
+	tests := []struct {
+		name     string
+		messages []*mediapb.UploadRequest
+		want     string
+	}{
+		{
+			name:     "chunk before info",
+			messages: []*mediapb.UploadRequest{{Data: &mediapb.UploadRequest_Chunk{Chunk: []byte("x")}}},
+			want:     "first message must carry file info",
+		},
+		{
+			name: "disallowed type",
+			messages: []*mediapb.UploadRequest{
+				{Data: &mediapb.UploadRequest_Info{Info: &mediapb.FileInfo{Filename: "run.exe"}}},
+				{Data: &mediapb.UploadRequest_Chunk{Chunk: []byte("MZ")}},
+			},
+			want: handlers.ErrFileTypeNotAllowed.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		stream, err := client.Upload(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range tt.messages {
+			stream.Send(m)
+		}
+		_, err = stream.CloseAndRecv()
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want InvalidArgument %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	client := newTestClient(t, WithReadOnly(true))
+
+	stream, err := client.Upload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&mediapb.UploadRequest{Data: &mediapb.UploadRequest_Info{Info: &mediapb.FileInfo{Filename: "a.txt"}}})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Upload() error = %v, want FailedPrecondition", err)
+	}
+	if _, err := client.Delete(context.Background(), &mediapb.DeleteRequest{Key: "a.txt"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Delete() error = %v, want FailedPrecondition", err)
+	}
+	if _, err := client.Sign(context.Background(), &mediapb.SignRequest{Key: "a.txt"}); err != nil {
+		t.Errorf("Sign() error = %v on a replica", err)
+	}
+}
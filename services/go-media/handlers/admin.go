@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/analytics"
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+)
+
+type UsageResponse struct {
+	analytics.PeriodUsage
+	EstimatedCost *analytics.CostEstimate `json:"estimated_cost,omitempty"`
+}
+
+// Usage reports storage, egress and R2 operation counts for a billing
+// period. Query parameters: period=YYYY-MM (default current month),
+// estimate=true to include an estimated R2 cost.
+func (h *MediaHandler) Usage(w http.ResponseWriter, r *http.Request) {
+	if h.usage == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Usage reporting not enabled")
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = time.Now().UTC().Format(analytics.PeriodFormat)
+	}
+	if _, err := time.Parse(analytics.PeriodFormat, period); err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "period must be formatted as YYYY-MM")
+		return
+	}
+
+	usage, ok := h.usage.Period(period)
+	if !ok {
+		usage = analytics.PeriodUsage{Period: period, Operations: map[string]int64{}}
+	}
+
+	resp := UsageResponse{PeriodUsage: usage}
+	if r.URL.Query().Get("estimate") == "true" {
+		est := analytics.EstimateCost(usage)
+		resp.EstimatedCost = &est
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// AuditLog queries the audit log of mutating operations.
+// Query parameters: action, key (prefix), actor, since, until, limit.
+func (h *MediaHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	if h.auditLog == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Audit log not enabled")
+		return
+	}
+
+	filter, err := audit.ParseFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	records, err := h.auditLog.Query(filter)
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read audit log")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, records)
+}
+
+// WebhookDeliveries lists recent webhook deliveries, newest first.
+// Query parameters: status (pending, delivered, failed), limit (default 100).
+func (h *MediaHandler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if h.webhooks == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Webhooks not configured")
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	respondJSON(w, http.StatusOK, h.webhooks.Deliveries(r.URL.Query().Get("status"), limit))
+}
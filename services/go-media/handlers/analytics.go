@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// AssetAnalytics returns request and byte counters for a single asset
+func (h *MediaHandler) AssetAnalytics(w http.ResponseWriter, r *http.Request) {
+	if h.analytics == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Analytics not enabled")
+		return
+	}
+
+	key := mux.Vars(r)["path"]
+	respondJSON(w, http.StatusOK, h.analytics.Stats(key))
+}
+
+// TopAssets returns the most requested assets, optionally under a prefix.
+// Query parameters: prefix, limit (default 10, max 1000), sort=requests|bytes
+func (h *MediaHandler) TopAssets(w http.ResponseWriter, r *http.Request) {
+	if h.analytics == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Analytics not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit := 10
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	byBytes := false
+	switch query.Get("sort") {
+	case "", "requests":
+	case "bytes":
+		byBytes = true
+	default:
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "sort must be 'requests' or 'bytes'")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, h.analytics.Top(query.Get("prefix"), limit, byBytes))
+}
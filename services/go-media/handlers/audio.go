@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/audio"
+)
+
+// maxAudioSize bounds the audio converted on request; larger files are
+// served as uploaded
+const maxAudioSize = 256 << 20
+
+// audioTimeout bounds one conversion
+const audioTimeout = 10 * time.Minute
+
+// WithAudio converts audio assets asked for with ?format=, ?bitrate= and
+// ?normalize= with c; nil serves them as uploaded
+func WithAudio(c *audio.Converter) Option {
+	return func(h *MediaHandler) {
+		h.audio = c
+	}
+}
+
+// wantsAudio returns the audio variant a request for key asks for. It
+// fails with an audio error for invalid options.
+func (h *MediaHandler) wantsAudio(r *http.Request, key string) (derivation, bool, error) {
+	if h.audio == nil || !audio.Source(key) {
+		return derivation{}, false, nil
+	}
+	o, err := audio.ParseQuery(r.URL.Query())
+	if err == nil {
+		o, err = o.For(key)
+	}
+	if err != nil || o.IsZero() {
+		return derivation{}, false, err
+	}
+	return h.audioDerivation(o), true, nil
+}
+
+// audioDerivation is the derivation applying o
+func (h *MediaHandler) audioDerivation(o audio.Options) derivation {
+	return derivation{transform: o.Fingerprint(), maxSize: maxAudioSize, build: func(src []byte) ([]byte, string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), audioTimeout)
+		defer cancel()
+		return h.audio.Convert(ctx, src, o)
+	}}
+}
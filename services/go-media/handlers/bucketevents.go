@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/bucketevents"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ApplyBucketNotification brings the index up to date with a change
+// made to the bucket, possibly by another system, and publishes the
+// event an upload or delete through the API would have. Changes the
+// index already reflects, such as the service's own writes, and changes
+// older than the index entry publish nothing.
+func (h *MediaHandler) ApplyBucketNotification(ctx context.Context, n bucketevents.Notification) error {
+	key := n.Object.Key
+	if h.index == nil || internalKey(key) {
+		return nil
+	}
+
+	e, indexed := h.index.Get(key)
+	if indexed && e.UpdatedAt.After(n.EventTime) {
+		return nil
+	}
+
+	if n.Deleted() {
+		if !indexed {
+			return nil
+		}
+		h.index.Delete(key)
+		h.events.Publish(events.New(events.AssetDeleted, map[string]interface{}{"key": key, "source": "bucket"}))
+		return nil
+	}
+
+	if indexed && e.ETag == n.Object.ETag {
+		return nil
+	}
+	head, err := h.r2Client.HeadObject(ctx, key)
+	if storage.IsNotFound(err) {
+		// Deleted since; its own notification follows
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	contentType := aws.ToString(head.ContentType)
+	schedule := scheduleFromMetadata(head.Metadata)
+	h.index.Update(key, func(e *index.Entry) {
+		e.State, e.PublishAt, e.UnpublishAt = schedule.State, schedule.PublishAt, schedule.UnpublishAt
+		e.Size = n.Object.Size
+		e.ContentType = contentType
+		e.ETag = n.Object.ETag
+		e.Integrity = ""
+		e.Text = ""
+		e.UpdatedAt = n.EventTime
+	})
+	h.indexTextLater(key, contentType, n.Object.ETag, n.Object.Size)
+	h.events.Publish(events.New(events.AssetUploaded, map[string]interface{}{
+		"key":          key,
+		"url":          h.publicURL(key),
+		"size":         n.Object.Size,
+		"content_type": contentType,
+		"source":       "bucket",
+	}))
+	return nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/bucketevents"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+type recordingSink struct {
+	events []events.Event
+}
+
+func (s *recordingSink) Publish(e events.Event) {
+	s.events = append(s.events, e)
+}
+
+func notification(action, key, etag string, at time.Time) bucketevents.Notification {
+	var n bucketevents.Notification
+	n.Action = action
+	n.Object.Key = key
+	n.Object.ETag = etag
+	n.EventTime = at
+	return n
+}
+
+func TestApplyBucketNotification(t *testing.T) {
+	idx, _ := index.Open("")
+	sink := &recordingSink{}
+	h := NewMediaHandler(nil, "secret", WithIndex(idx), WithEvents(events.NewBus(sink)))
+	ctx := context.Background()
+
+	uploaded := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	idx.Update("assets/a.png", func(e *index.Entry) {
+		e.ETag = "abc"
+		e.UpdatedAt = uploaded
+	})
+
+	// The service's own write, and notifications older than the entry,
+	// change nothing
+	for _, n := range []bucketevents.Notification{
+		notification(bucketevents.ActionPut, "assets/a.png", "abc", uploaded.Add(time.Second)),
+		notification(bucketevents.ActionPut, "assets/a.png", "old", uploaded.Add(-time.Minute)),
+		notification(bucketevents.ActionDelete, "assets/a.png", "", uploaded.Add(-time.Minute)),
+		notification(bucketevents.ActionDelete, "assets/unknown.png", "", uploaded),
+		notification(bucketevents.ActionPut, derivedPrefix+"app.js/m/min", "m", uploaded),
+	} {
+		if err := h.ApplyBucketNotification(ctx, n); err != nil {
+			t.Fatalf("%s %s: %v", n.Action, n.Object.Key, err)
+		}
+	}
+	if _, ok := idx.Get("assets/a.png"); !ok || len(sink.events) != 0 {
+		t.Fatalf("stale notifications changed the index or published %v", sink.events)
+	}
+
+	// A delete made outside the service is applied and announced
+	lifecycle := notification(bucketevents.ActionLifecycleDeletion, "assets/a.png", "", uploaded.Add(time.Hour))
+	if err := h.ApplyBucketNotification(ctx, lifecycle); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Get("assets/a.png"); ok {
+		t.Error("entry still indexed after a lifecycle deletion")
+	}
+	if len(sink.events) != 1 || sink.events[0].Type != events.AssetDeleted || sink.events[0].Data["source"] != "bucket" {
+		t.Errorf("published %+v, want one bucket asset.deleted", sink.events)
+	}
+}
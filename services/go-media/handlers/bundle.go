@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+)
+
+// maxBundleObjects caps how many objects one archive may contain
+const maxBundleObjects = 1000
+
+// BundleRequest selects the objects for an archive: either Keys or every
+// object under Prefix. Name is the suggested download file name.
+type BundleRequest struct {
+	Keys   []string `json:"keys" validate:"dive,required"`
+	Prefix string   `json:"prefix"`
+	Name   string   `json:"name"`
+}
+
+func (req BundleRequest) Check() validation.Errors {
+	return keysOrPrefix(req.Keys, req.Prefix)
+}
+
+// keysOrPrefix checks that a request selects objects by exactly one of
+// keys and prefix
+func keysOrPrefix(keys []string, prefix string) validation.Errors {
+	switch {
+	case len(keys) == 0 && prefix == "":
+		return validation.Errors{{Field: "keys", Rule: "required_without", Message: "is required without prefix"}}
+	case len(keys) > 0 && prefix != "":
+		return validation.Errors{{Field: "prefix", Rule: "excluded_with", Message: "must not be set with keys"}}
+	}
+	return nil
+}
+
+// bundleEntry is one object in an archive
+type bundleEntry struct {
+	Key          string
+	Name         string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// errTooManyObjects is returned when a listing exceeds an archive's limit
+var errTooManyObjects = errors.New("too many objects")
+
+// Bundle streams a zip archive of the requested objects. Objects are
+// fetched and written one at a time, so the archive is never held in
+// memory.
+func (h *MediaHandler) Bundle(w http.ResponseWriter, r *http.Request) {
+	req, entries, ok := h.bundleEntries(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition(req.Name, "bundle.zip"))
+
+	if err := writeZip(r.Context(), w, entries, h.openBundleEntry); err != nil {
+		// Headers are sent; drop the connection so the client sees a
+		// failed download rather than a truncated archive
+		log.Printf("Bundle failed after headers were sent: %v", err)
+		h.reporter.CaptureError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// bundleEntries decodes a BundleRequest and resolves it to the objects
+// to archive, writing an error response and returning false on failure
+func (h *MediaHandler) bundleEntries(w http.ResponseWriter, r *http.Request) (BundleRequest, []bundleEntry, bool) {
+	var req BundleRequest
+	if !decodeJSON(w, r, &req) {
+		return req, nil, false
+	}
+
+	var entries []bundleEntry
+	var err error
+	if req.Prefix != "" {
+		entries, err = h.prefixEntries(r.Context(), req.Prefix, maxBundleObjects)
+	} else {
+		entries, err = h.keyEntries(r.Context(), req.Keys)
+	}
+
+	var notFound *missingKeysError
+	switch {
+	case errors.Is(err, errTooManyObjects):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("A bundle may contain at most %d objects", maxBundleObjects))
+		return req, nil, false
+	case errors.Is(err, ErrInvalidFilename):
+		respondError(w, http.StatusBadRequest, apierror.InvalidFilename, err.Error())
+		return req, nil, false
+	case errors.As(err, &notFound):
+		apierror.WriteDetails(w, http.StatusNotFound, apierror.AssetNotFound, err.Error(), map[string]interface{}{"keys": notFound.keys})
+		return req, nil, false
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return req, nil, false
+	case len(entries) == 0:
+		respondError(w, http.StatusNotFound, apierror.NotFound, "No objects under prefix")
+		return req, nil, false
+	}
+	return req, entries, true
+}
+
+type missingKeysError struct {
+	keys []string
+}
+
+func (e *missingKeysError) Error() string {
+	return "objects not found: " + strings.Join(e.keys, ", ")
+}
+
+// keyEntries looks up each key, so missing objects are reported before
+// any of the archive is sent. Entries are named by their full key.
+func (h *MediaHandler) keyEntries(ctx context.Context, keys []string) ([]bundleEntry, error) {
+	if len(keys) > maxBundleObjects {
+		return nil, errTooManyObjects
+	}
+
+	var entries []bundleEntry
+	var missing []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !validBundleName(key) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFilename, key)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if h.sealed(key) {
+			missing = append(missing, key)
+			continue
+		}
+
+		head, err := h.HeadAsset(ctx, key)
+		switch {
+		case storage.IsNotFound(err):
+			missing = append(missing, key)
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		entry := bundleEntry{Key: key, Name: key}
+		if head.ContentLength != nil {
+			entry.Size = *head.ContentLength
+		}
+		if head.LastModified != nil {
+			entry.LastModified = *head.LastModified
+		}
+		if head.ETag != nil {
+			entry.ETag = *head.ETag
+		}
+		entries = append(entries, entry)
+	}
+	if len(missing) > 0 {
+		return nil, &missingKeysError{keys: missing}
+	}
+	return entries, nil
+}
+
+// prefixEntries lists every object under prefix, up to limit. Entries
+// are named relative to the prefix's directory, so "downloads/" yields
+// "app.zip" rather than "downloads/app.zip".
+func (h *MediaHandler) prefixEntries(ctx context.Context, prefix string, limit int) ([]bundleEntry, error) {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	var entries []bundleEntry
+	opts := storage.ListOptions{Prefix: prefix, MaxKeys: 1000}
+	for {
+		page, err := h.ListPage(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Objects {
+			// Directory markers have no content; encrypted objects
+			// are never archived
+			if strings.HasSuffix(obj.Key, "/") || h.sealed(obj.Key) {
+				continue
+			}
+			name := strings.TrimPrefix(obj.Key, dir)
+			if !validBundleName(name) {
+				continue
+			}
+			if len(entries) == limit {
+				return nil, errTooManyObjects
+			}
+			entries = append(entries, bundleEntry{Key: obj.Key, Name: name, Size: obj.Size, LastModified: obj.LastModified, ETag: obj.ETag})
+		}
+		if !page.IsTruncated {
+			return entries, nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// validBundleName rejects names that would extract outside the target
+// directory
+func validBundleName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// openBundleEntry opens an object for an archive and counts the bytes
+// read towards its analytics
+func (h *MediaHandler) openBundleEntry(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := h.OpenAsset(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &servedReader{ReadCloser: obj.Body, key: key, record: h.RecordServed}, nil
+}
+
+// servedReader records the bytes read from an object when closed
+type servedReader struct {
+	io.ReadCloser
+	key    string
+	n      int64
+	record func(key string, n int64)
+}
+
+func (s *servedReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.n += int64(n)
+	return n, err
+}
+
+func (s *servedReader) Close() error {
+	s.record(s.key, s.n)
+	return s.ReadCloser.Close()
+}
+
+// writeZip writes entries to w as a zip archive, opening each object
+// only while it is being copied. Already-compressed formats are stored
+// rather than deflated again.
+func writeZip(ctx context.Context, w io.Writer, entries []bundleEntry, open func(context.Context, string) (io.ReadCloser, error)) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		method := zip.Deflate
+		if compressedExts[strings.ToLower(path.Ext(e.Name))] {
+			method = zip.Store
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: method, Modified: e.LastModified})
+		if err != nil {
+			return err
+		}
+
+		body, err := open(ctx, e.Key)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", e.Key, err)
+		}
+		_, err = io.Copy(fw, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", e.Key, err)
+		}
+	}
+	return zw.Close()
+}
+
+// compressedExts are formats that gain nothing from deflate
+var compressedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+	".mp4": true, ".webm": true, ".mov": true, ".mp3": true, ".ogg": true, ".m4a": true,
+	".zip": true, ".gz": true, ".br": true, ".zst": true, ".7z": true, ".woff": true, ".woff2": true,
+	".pdf": true,
+}
+
+// contentDisposition returns an attachment header for name, falling
+// back to def when name is empty or unsafe. The name gets def's
+// extension (everything from its first dot) if it lacks it.
+func contentDisposition(name, def string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || strings.ContainsAny(name, "\"\r\n") {
+		name = def
+	}
+	if ext := def[strings.Index(def, "."):]; !strings.HasSuffix(strings.ToLower(name), ext) {
+		name += ext
+	}
+	return fmt.Sprintf("attachment; filename=%q", name)
+}
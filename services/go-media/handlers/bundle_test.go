@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteZip(t *testing.T) {
+	objects := map[string]string{
+		"docs/readme.txt": strings.Repeat("hello ", 100),
+		"docs/img/a.png":  "\x89PNG",
+	}
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []bundleEntry{
+		{Key: "docs/readme.txt", Name: "readme.txt", LastModified: modified},
+		{Key: "docs/img/a.png", Name: "img/a.png", LastModified: modified},
+	}
+	open := func(ctx context.Context, key string) (io.ReadCloser, error) {
+		data, ok := objects[key]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeZip(context.Background(), &buf, entries, open); err != nil {
+		t.Fatalf("writeZip() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("zip has %d files, want 2", len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != entries[i].Name {
+			t.Errorf("file %d name = %q, want %q", i, f.Name, entries[i].Name)
+		}
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != objects[entries[i].Key] {
+			t.Errorf("%s content = %q", f.Name, data)
+		}
+	}
+	if zr.File[0].Method != zip.Deflate || zr.File[1].Method != zip.Store {
+		t.Errorf("methods = %d, %d, want deflate for text and store for png", zr.File[0].Method, zr.File[1].Method)
+	}
+
+	entries = append(entries, bundleEntry{Key: "docs/gone.txt", Name: "gone.txt"})
+	if err := writeZip(context.Background(), io.Discard, entries, open); err == nil || !strings.Contains(err.Error(), "docs/gone.txt") {
+		t.Errorf("writeZip() with a missing object error = %v", err)
+	}
+}
+
+func TestValidBundleName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"assets/a.png", true},
+		{"a..b.txt", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"assets/../../x", false},
+		{`..\x`, false},
+	}
+	for _, tt := range tests {
+		if got := validBundleName(tt.name); got != tt.want {
+			t.Errorf("validBundleName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"", `attachment; filename="bundle.zip"`},
+		{"attachments", `attachment; filename="attachments.zip"`},
+		{"../../photos.zip", `attachment; filename="photos.zip"`},
+		{"a\"b.zip", `attachment; filename="bundle.zip"`},
+	}
+	for _, tt := range tests {
+		if got := contentDisposition(tt.name, "bundle.zip"); got != tt.want {
+			t.Errorf("contentDisposition(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+)
+
+// Chunked uploads: a client starts an upload, PUTs its chunks in any
+// order (retrying any that fail) and finalizes it, and the chunks are
+// assembled by an R2 multipart upload. The upload ID is a signed token
+// naming the multipart upload, so no state is kept between requests.
+
+const (
+	// minChunkSize is R2's smallest part; only the last chunk may be
+	// smaller, and every other chunk must be the same size
+	minChunkSize = storage.MinPartSize
+
+	// maxChunkSize bounds a single chunk request
+	maxChunkSize = 100 << 20
+
+	// maxChunks is R2's part count limit
+	maxChunks = storage.MaxParts
+)
+
+var errInvalidChunkedUpload = errors.New("invalid or expired upload ID")
+
+// WithMaxChunkedSize limits the assembled size of a chunked upload
+func WithMaxChunkedSize(n int64) Option {
+	return func(h *MediaHandler) {
+		h.maxChunkedSize = n
+	}
+}
+
+// ChunkedUploadRequest starts a chunked upload. ContentType defaults to
+// the one for the filename's extension.
+type ChunkedUploadRequest struct {
+	Filename    string `json:"filename" validate:"required"`
+	ContentType string `json:"content_type"`
+	KeyStrategy string `json:"key_strategy"`
+	VideoPreset string `json:"video_preset,omitempty"`
+}
+
+// ChunkedUploadResponse identifies a started chunked upload
+type ChunkedUploadResponse struct {
+	UploadID     string    `json:"upload_id"`
+	Key          string    `json:"key"`
+	MinChunkSize int64     `json:"min_chunk_size"`
+	MaxChunkSize int64     `json:"max_chunk_size"`
+	MaxSize      int64     `json:"max_size"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ChunkedUploadStatus lists the chunks received so far, for a client
+// resuming an upload
+type ChunkedUploadStatus struct {
+	UploadID  string      `json:"upload_id"`
+	Key       string      `json:"key"`
+	Chunks    []ChunkInfo `json:"chunks"`
+	Received  int64       `json:"received"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// ChunkInfo is a received chunk
+type ChunkInfo struct {
+	Number int32  `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+}
+
+// chunkedSession is what an upload ID encodes
+type chunkedSession struct {
+	Key         string `json:"key"`
+	UploadID    string `json:"upload"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	MaxSize     int64  `json:"max"`
+	TokenID     string `json:"token_id,omitempty"`
+	VideoPreset string `json:"video_preset,omitempty"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+// StartChunkedUpload begins a chunked upload. The key can't come from
+// the content, which the service never holds whole, so it is random.
+func (h *MediaHandler) StartChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(customerKeyHeader) != "" {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Client-supplied encryption keys aren't supported for chunked uploads")
+		return
+	}
+	var grant *UploadGrant
+	if token := r.Header.Get(uploadTokenHeader); token != "" {
+		var err error
+		if grant, err = h.parseUploadToken(token); err != nil {
+			respondError(w, http.StatusUnauthorized, apierror.UploadTokenInvalid, err.Error())
+			return
+		}
+	} else if h.requireUploadToken {
+		respondError(w, http.StatusUnauthorized, apierror.UploadTokenRequired, "Upload token required")
+		return
+	}
+
+	var req ChunkedUploadRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	strategy, err := h.uploadKeyStrategy(grant, req.KeyStrategy)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if err := h.checkVideoPreset(req.VideoPreset); err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	key, err := chunkedUploadKey(strategy, req.Filename, h.html.Uploads)
+	switch {
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		respondError(w, http.StatusBadRequest, apierror.FileTypeNotAllowed, "File type not allowed")
+		return
+	case errors.Is(err, ErrInvalidFilename):
+		respondError(w, http.StatusBadRequest, apierror.InvalidFilename, "Invalid filename")
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to start upload")
+		return
+	}
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	session := chunkedSession{
+		Key:         key,
+		Filename:    filepath.Base(req.Filename),
+		ContentType: contentType,
+		MaxSize:     h.maxChunkedSize,
+		VideoPreset: req.VideoPreset,
+		ExpiresAt:   time.Now().Add(multipartMaxAge).Unix(),
+	}
+	if grant != nil {
+		if !grant.allows(contentType) {
+			respondError(w, http.StatusForbidden, apierror.ContentTypeNotAllowed, "Content type not allowed by upload token")
+			return
+		}
+		if !h.usedUploadTokens.claim(grant.ID, time.Unix(grant.ExpiresAt, 0)) {
+			respondError(w, http.StatusUnauthorized, apierror.UploadTokenUsed, errUploadTokenUsed.Error())
+			return
+		}
+		session.Key = grant.Prefix + strings.TrimPrefix(key, "assets/")
+		session.TokenID = grant.ID
+		if grant.MaxSize < session.MaxSize {
+			session.MaxSize = grant.MaxSize
+		}
+	}
+	if err := ValidateKey(session.Key); err != nil {
+		if grant != nil {
+			h.usedUploadTokens.release(grant.ID)
+		}
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	// Parts are stored as sent, so they can't be sealed
+	if h.sealed(session.Key) {
+		if grant != nil {
+			h.usedUploadTokens.release(grant.ID)
+		}
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Chunked uploads can't be stored under the encrypted prefix")
+		return
+	}
+
+	upload, err := h.r2Client.CreateMultipartUpload(r.Context(), session.Key, contentType, nil)
+	if err != nil {
+		if grant != nil {
+			h.usedUploadTokens.release(grant.ID)
+		}
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to start upload")
+		return
+	}
+	session.UploadID = aws.ToString(upload.UploadId)
+
+	respondJSON(w, http.StatusOK, ChunkedUploadResponse{
+		UploadID:     h.signChunkedSession(session),
+		Key:          session.Key,
+		MinChunkSize: minChunkSize,
+		MaxChunkSize: maxChunkSize,
+		MaxSize:      session.MaxSize,
+		ExpiresAt:    time.Unix(session.ExpiresAt, 0).UTC(),
+	})
+}
+
+// chunkedUploadKey is StrategyKey for content not known up front: the
+// content hash becomes a random name
+func chunkedUploadKey(k KeyStrategy, filename string, html bool) (string, error) {
+	if k != KeyHash && k != "" {
+		return strategyKey(k, filename, nil, html)
+	}
+	_, ext, err := uploadName(filename, html)
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "assets/" + hex.EncodeToString(id) + ext, nil
+}
+
+// PutChunk stores chunk n (from 1) of a chunked upload. Sending a chunk
+// again replaces it.
+func (h *MediaHandler) PutChunk(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.chunkedSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(mux.Vars(r)["n"])
+	if err != nil || n < 1 || n > maxChunks {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("Chunk number must be 1-%d", maxChunks))
+		return
+	}
+	limit := int64(maxChunkSize)
+	if session.MaxSize < limit {
+		limit = session.MaxSize
+	}
+	if r.ContentLength < 0 {
+		respondError(w, http.StatusLengthRequired, apierror.LengthRequired, "Content-Length required")
+		return
+	}
+	if r.ContentLength > limit {
+		respondError(w, http.StatusRequestEntityTooLarge, apierror.FileTooLarge, fmt.Sprintf("Chunk too large (max %d bytes)", limit))
+		return
+	}
+
+	part, err := h.r2Client.UploadPart(r.Context(), session.Key, session.UploadID, int32(n), http.MaxBytesReader(w, r.Body, limit), r.ContentLength)
+	switch {
+	case storage.IsNotFound(err):
+		respondError(w, http.StatusNotFound, apierror.UploadNotFound, "Upload not found")
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to store chunk")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ChunkInfo{Number: int32(n), Size: r.ContentLength, ETag: strings.Trim(aws.ToString(part.ETag), `"`)})
+}
+
+// ChunkedUploadStatus reports the chunks received so far
+func (h *MediaHandler) ChunkedUploadStatus(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.chunkedSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	parts, ok := h.listChunks(w, r, session)
+	if !ok {
+		return
+	}
+
+	status := ChunkedUploadStatus{
+		UploadID:  mux.Vars(r)["id"],
+		Key:       session.Key,
+		Chunks:    make([]ChunkInfo, 0, len(parts)),
+		ExpiresAt: time.Unix(session.ExpiresAt, 0).UTC(),
+	}
+	for _, p := range parts {
+		status.Chunks = append(status.Chunks, ChunkInfo{Number: p.Number, Size: p.Size, ETag: strings.Trim(p.ETag, `"`)})
+		status.Received += p.Size
+	}
+	respondJSON(w, http.StatusOK, status)
+}
+
+// CompleteChunkedUpload assembles the chunks into the object, which is
+// then indexed and announced like any other upload
+func (h *MediaHandler) CompleteChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.chunkedSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	details := map[string]string{"filename": session.Filename}
+	if session.TokenID != "" {
+		details["token_id"] = session.TokenID
+	}
+	audit.Annotate(r, session.Key, details)
+
+	parts, ok := h.listChunks(w, r, session)
+	if !ok {
+		return
+	}
+	size, err := checkChunks(parts, session.MaxSize)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = types.CompletedPart{PartNumber: aws.Int32(p.Number), ETag: aws.String(p.ETag)}
+	}
+	etag, err := h.r2Client.CompleteMultipartUpload(ctx, session.Key, session.UploadID, completed)
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to assemble upload")
+		return
+	}
+	if h.index != nil {
+		h.index.Update(session.Key, func(e *index.Entry) {
+			e.Size = size
+			e.ContentType = session.ContentType
+			e.ETag = etag
+			e.Integrity = ""
+			e.Text = ""
+			e.UpdatedAt = time.Now().UTC()
+		})
+		h.indexTextLater(session.Key, session.ContentType, etag, size)
+	}
+
+	url := h.assetURL(withPublicBase(r.Context(), h.baseURL(r)), session.Key, false)
+	h.events.Publish(events.New(events.AssetUploaded, map[string]interface{}{
+		"key":          session.Key,
+		"url":          url,
+		"size":         size,
+		"content_type": session.ContentType,
+		"filename":     session.Filename,
+	}))
+	respondJSON(w, http.StatusOK, UploadResponse{
+		URL:   url,
+		Key:   session.Key,
+		ETag:  etag,
+		Video: h.queueTranscode(session.Key, session.ContentType, etag, session.VideoPreset),
+	})
+}
+
+// AbortChunkedUpload discards a chunked upload and its chunks
+func (h *MediaHandler) AbortChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.chunkedSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := h.r2Client.AbortMultipartUpload(r.Context(), session.Key, session.UploadID); err != nil && !storage.IsNotFound(err) {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to abort upload")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "aborted"})
+}
+
+// checkChunks verifies the chunks can be assembled: numbered 1 to N
+// without gaps, all but the last of one size no smaller than
+// minChunkSize, and together within maxSize, which it returns
+func checkChunks(parts []storage.Part, maxSize int64) (int64, error) {
+	if len(parts) == 0 {
+		return 0, errors.New("no chunks uploaded")
+	}
+	var total int64
+	for i, p := range parts {
+		if p.Number != int32(i+1) {
+			return 0, fmt.Errorf("chunk %d is missing", i+1)
+		}
+		if i < len(parts)-1 {
+			if p.Size < minChunkSize {
+				return 0, fmt.Errorf("chunk %d is smaller than %d bytes; only the last chunk may be", p.Number, minChunkSize)
+			}
+			if p.Size != parts[0].Size {
+				return 0, fmt.Errorf("chunk %d is %d bytes, not %d like chunk 1; only the last chunk may differ", p.Number, p.Size, parts[0].Size)
+			}
+		}
+		total += p.Size
+	}
+	if total > maxSize {
+		return 0, fmt.Errorf("upload is %d bytes, over the %d byte limit", total, maxSize)
+	}
+	return total, nil
+}
+
+func (h *MediaHandler) listChunks(w http.ResponseWriter, r *http.Request, session *chunkedSession) ([]storage.Part, bool) {
+	parts, err := h.r2Client.ListParts(r.Context(), session.Key, session.UploadID)
+	switch {
+	case storage.IsNotFound(err):
+		respondError(w, http.StatusNotFound, apierror.UploadNotFound, "Upload not found")
+		return nil, false
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list chunks")
+		return nil, false
+	}
+	return parts, true
+}
+
+func (h *MediaHandler) chunkedSessionFromRequest(w http.ResponseWriter, r *http.Request) (*chunkedSession, bool) {
+	session, err := h.parseChunkedSession(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, apierror.UploadNotFound, err.Error())
+		return nil, false
+	}
+	return session, true
+}
+
+// signChunkedSession encodes session as an upload ID
+func (h *MediaHandler) signChunkedSession(session chunkedSession) string {
+	payload, _ := json.Marshal(session)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + h.tokenMAC("chunked-upload", encoded)
+}
+
+// parseChunkedSession verifies an upload ID and returns its unexpired
+// session
+func (h *MediaHandler) parseChunkedSession(id string) (*chunkedSession, error) {
+	encoded, sig, ok := strings.Cut(id, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(h.tokenMAC("chunked-upload", encoded))) {
+		return nil, errInvalidChunkedUpload
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidChunkedUpload
+	}
+	var session chunkedSession
+	if err := json.Unmarshal(payload, &session); err != nil || time.Now().Unix() > session.ExpiresAt {
+		return nil, errInvalidChunkedUpload
+	}
+	return &session, nil
+}
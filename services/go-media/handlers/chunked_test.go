@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/gorilla/mux"
+)
+
+func TestChunkedSession(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret")
+	session := chunkedSession{
+		Key:         "assets/0123456789abcdef.mp4",
+		UploadID:    "r2-upload",
+		ContentType: "video/mp4",
+		MaxSize:     1 << 30,
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+	}
+	id := h.signChunkedSession(session)
+
+	got, err := h.parseChunkedSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != session {
+		t.Errorf("session = %+v, want %+v", got, session)
+	}
+
+	if _, err := h.parseChunkedSession(strings.Replace(id, ".", "x.", 1)); err == nil {
+		t.Error("tampered upload ID accepted")
+	}
+	// An upload token isn't an upload ID, though both are signed alike
+	token := h.signUploadToken(UploadGrant{ID: "g", ExpiresAt: session.ExpiresAt})
+	if _, err := h.parseChunkedSession(token); err == nil {
+		t.Error("upload token accepted as an upload ID")
+	}
+	session.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	if _, err := h.parseChunkedSession(h.signChunkedSession(session)); err == nil {
+		t.Error("expired upload ID accepted")
+	}
+}
+
+func TestStartChunkedUploadRejects(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret")
+	start := func(h *MediaHandler, body string, header ...string) int {
+		r := httptest.NewRequest("POST", "/v1/media/upload/chunk", strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.StartChunkedUpload(w, r)
+		return w.Code
+	}
+
+	if code := start(h, `{"filename": "tool.exe"}`); code != http.StatusBadRequest {
+		t.Errorf("disallowed type: status %d, want 400", code)
+	}
+	if code := start(h, `{"filename": "a.mp4", "key_strategy": "random"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown key strategy: status %d, want 400", code)
+	}
+	if code := start(h, `{"filename": "a.mp4", "video_preset": "standard"}`); code != http.StatusBadRequest {
+		t.Errorf("video preset without transcoding: status %d, want 400", code)
+	}
+	if code := start(h, `{"filename": "a.mp4"}`, customerKeyHeader, "a2V5"); code != http.StatusBadRequest {
+		t.Errorf("SSE-C key: status %d, want 400", code)
+	}
+	if code := start(h, `{"filename": "a.mp4"}`, uploadTokenHeader, "bogus"); code != http.StatusUnauthorized {
+		t.Errorf("bad token: status %d, want 401", code)
+	}
+	_, resp := issueUploadToken(t, h, `{"content_types": ["image/*"]}`)
+	if code := start(h, `{"filename": "a.mp4"}`, uploadTokenHeader, resp.Token); code != http.StatusForbidden {
+		t.Errorf("type outside token: status %d, want 403", code)
+	}
+
+	keyring, err := envelope.NewKeyring([]string{"v1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := NewMediaHandler(nil, "test-secret", WithEncryption(envelope.New(keyring), "secure/"))
+	_, resp = issueUploadToken(t, encrypted, `{"prefix": "secure/"}`)
+	if code := start(encrypted, `{"filename": "a.pdf"}`, uploadTokenHeader, resp.Token); code != http.StatusBadRequest {
+		t.Errorf("encrypted prefix: status %d, want 400", code)
+	}
+
+	required := NewMediaHandler(nil, "test-secret", WithUploadTokens(true))
+	if code := start(required, `{"filename": "a.mp4"}`); code != http.StatusUnauthorized {
+		t.Errorf("token required: status %d, want 401", code)
+	}
+}
+
+func TestPutChunkRejects(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret", WithMaxChunkedSize(10))
+	id := h.signChunkedSession(chunkedSession{Key: "assets/a.mp4", UploadID: "u", MaxSize: 10, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	put := func(id, n, body string) int {
+		r := mux.SetURLVars(httptest.NewRequest("PUT", "/v1/media/upload/chunk/"+id+"/"+n, strings.NewReader(body)), map[string]string{"id": id, "n": n})
+		w := httptest.NewRecorder()
+		h.PutChunk(w, r)
+		return w.Code
+	}
+	if code := put("bogus", "1", "x"); code != http.StatusNotFound {
+		t.Errorf("unknown upload: status %d, want 404", code)
+	}
+	if code := put(id, "0", "x"); code != http.StatusBadRequest {
+		t.Errorf("chunk 0: status %d, want 400", code)
+	}
+	if code := put(id, "10001", "x"); code != http.StatusBadRequest {
+		t.Errorf("chunk 10001: status %d, want 400", code)
+	}
+	if code := put(id, "1", "more than ten bytes"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized chunk: status %d, want 413", code)
+	}
+}
+
+func TestCheckChunks(t *testing.T) {
+	const mb = 1 << 20
+	parts := func(sizes ...int64) []storage.Part {
+		var ps []storage.Part
+		for i, s := range sizes {
+			ps = append(ps, storage.Part{Number: int32(i + 1), Size: s})
+		}
+		return ps
+	}
+
+	tests := []struct {
+		name    string
+		parts   []storage.Part
+		max     int64
+		want    int64
+		wantErr string
+	}{
+		{name: "single small chunk", parts: parts(100), max: 1 << 30, want: 100},
+		{name: "equal chunks and a short last", parts: parts(5*mb, 5*mb, 3), max: 1 << 30, want: 10*mb + 3},
+		{name: "none", max: 1 << 30, wantErr: "no chunks"},
+		{name: "gap", parts: []storage.Part{{Number: 1, Size: 5 * mb}, {Number: 3, Size: 1}}, max: 1 << 30, wantErr: "chunk 2 is missing"},
+		{name: "small middle chunk", parts: parts(4*mb, 4*mb), max: 1 << 30, wantErr: "chunk 1 is smaller"},
+		{name: "uneven chunks", parts: parts(6*mb, 5*mb, 1), max: 1 << 30, wantErr: "chunk 2 is"},
+		{name: "over limit", parts: parts(5*mb, 1), max: 5 * mb, wantErr: "over the"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkChunks(tt.parts, tt.max)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("checkChunks() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("checkChunks() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
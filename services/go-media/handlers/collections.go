@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/collections"
+	"github.com/gorilla/mux"
+)
+
+// WithCollections serves the collections API from store
+func WithCollections(store *collections.Store) Option {
+	return func(h *MediaHandler) {
+		h.collections = store
+	}
+}
+
+// collectionsEnabled writes a 501 and returns false when there is no
+// collection store
+func (h *MediaHandler) collectionsEnabled(w http.ResponseWriter) bool {
+	if h.collections == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Collections not enabled")
+		return false
+	}
+	return true
+}
+
+// ListCollections lists every collection, by name
+func (h *MediaHandler) ListCollections(w http.ResponseWriter, r *http.Request) {
+	if !h.collectionsEnabled(w) {
+		return
+	}
+	respondJSON(w, http.StatusOK, h.collections.List())
+}
+
+// CreateCollection adds a collection of the keys in the request, in order
+func (h *MediaHandler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	if !h.collectionsEnabled(w) {
+		return
+	}
+	f, ok := h.collectionFields(w, r)
+	if !ok {
+		return
+	}
+	c, err := h.collections.Create(f)
+	if err != nil {
+		h.collectionError(w, r, err)
+		return
+	}
+	audit.Annotate(r, "", map[string]string{"collection": c.ID, "keys": strconv.Itoa(len(c.Keys))})
+	respondJSON(w, http.StatusCreated, c)
+}
+
+// GetCollection returns a collection
+func (h *MediaHandler) GetCollection(w http.ResponseWriter, r *http.Request) {
+	if !h.collectionsEnabled(w) {
+		return
+	}
+	c, ok := h.collections.Get(mux.Vars(r)["id"])
+	if !ok {
+		h.collectionError(w, r, collections.ErrNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, c)
+}
+
+// UpdateCollection replaces a collection's name, description and keys
+func (h *MediaHandler) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	if !h.collectionsEnabled(w) {
+		return
+	}
+	f, ok := h.collectionFields(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	audit.Annotate(r, "", map[string]string{"collection": id, "keys": strconv.Itoa(len(f.Keys))})
+	c, err := h.collections.Replace(id, f)
+	if err != nil {
+		h.collectionError(w, r, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, c)
+}
+
+// DeleteCollection deletes a collection. Its assets are left alone.
+func (h *MediaHandler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	if !h.collectionsEnabled(w) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	audit.Annotate(r, "", map[string]string{"collection": id})
+	if err := h.collections.Delete(id); err != nil {
+		h.collectionError(w, r, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// CollectionBundle streams a zip archive of a collection's assets, in
+// the collection's order, named by key
+func (h *MediaHandler) CollectionBundle(w http.ResponseWriter, r *http.Request) {
+	if !h.collectionsEnabled(w) {
+		return
+	}
+	c, ok := h.collections.Get(mux.Vars(r)["id"])
+	if !ok {
+		h.collectionError(w, r, collections.ErrNotFound)
+		return
+	}
+	if len(c.Keys) == 0 {
+		respondError(w, http.StatusNotFound, apierror.NotFound, "The collection is empty")
+		return
+	}
+
+	entries, err := h.keyEntries(r.Context(), c.Keys)
+	var notFound *missingKeysError
+	switch {
+	case errors.Is(err, ErrInvalidFilename):
+		respondError(w, http.StatusBadRequest, apierror.InvalidFilename, err.Error())
+		return
+	case errors.As(err, &notFound):
+		apierror.WriteDetails(w, http.StatusNotFound, apierror.AssetNotFound, err.Error(), map[string]interface{}{"keys": notFound.keys})
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to look up the collection's objects")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition(c.Name, "collection.zip"))
+	if err := writeZip(r.Context(), w, entries, h.openBundleEntry); err != nil {
+		log.Printf("Collection bundle failed after headers were sent: %v", err)
+		h.reporter.CaptureError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// collectionFields decodes the fields of a collection from the request
+// body, whose keys must be valid asset keys
+func (h *MediaHandler) collectionFields(w http.ResponseWriter, r *http.Request) (collections.Fields, bool) {
+	var f collections.Fields
+	if !decodeJSON(w, r, &f) {
+		return f, false
+	}
+	for _, key := range f.Keys {
+		if err := ValidateKey(key); err != nil {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+			return f, false
+		}
+	}
+	return f, true
+}
+
+func (h *MediaHandler) collectionError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, collections.ErrNotFound):
+		respondError(w, http.StatusNotFound, apierror.CollectionNotFound, "Collection not found")
+	case errors.Is(err, collections.ErrInvalid):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+	default:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to save collections")
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/collections"
+	"github.com/gorilla/mux"
+)
+
+func TestCollectionsAPI(t *testing.T) {
+	store, _ := collections.Open("")
+	h := NewMediaHandler(nil, "secret", WithCollections(store))
+
+	call := func(handler http.HandlerFunc, method, target, body string, vars map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if vars != nil {
+			r = mux.SetURLVars(r, vars)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	w := call(h.CreateCollection, "POST", "/v1/media/collections", `{"name":"Gallery","keys":["img/b.jpg","img/a.jpg"]}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	var created collections.Collection
+	json.NewDecoder(w.Body).Decode(&created)
+	id := map[string]string{"id": created.ID}
+
+	w = call(h.UpdateCollection, "PUT", "/v1/media/collections/"+created.ID, `{"name":"Gallery","keys":["img/a.jpg"]}`, id)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: status %d: %s", w.Code, w.Body)
+	}
+	w = call(h.GetCollection, "GET", "/v1/media/collections/"+created.ID, "", id)
+	var got collections.Collection
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || strings.Join(got.Keys, ",") != "img/a.jpg" {
+		t.Errorf("get: status %d, keys %v", w.Code, got.Keys)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		vars    map[string]string
+		code    int
+	}{
+		{"invalid key", h.CreateCollection, "POST", `{"name":"x","keys":["../etc/passwd"]}`, nil, http.StatusBadRequest},
+		{"reserved key", h.CreateCollection, "POST", `{"name":"x","keys":["derived/a.png/e/min"]}`, nil, http.StatusBadRequest},
+		{"no name", h.CreateCollection, "POST", `{"keys":["a.png"]}`, nil, http.StatusBadRequest},
+		{"bad body", h.CreateCollection, "POST", `{`, nil, http.StatusBadRequest},
+		{"update unknown", h.UpdateCollection, "PUT", `{"name":"x"}`, map[string]string{"id": "col_missing"}, http.StatusNotFound},
+		{"bundle unknown", h.CollectionBundle, "GET", "", map[string]string{"id": "col_missing"}, http.StatusNotFound},
+	} {
+		if w := call(tt.handler, tt.method, "/v1/media/collections", tt.body, tt.vars); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+	}
+
+	empty, _ := store.Create(collections.Fields{Name: "Empty"})
+	if w := call(h.CollectionBundle, "GET", "/", "", map[string]string{"id": empty.ID}); w.Code != http.StatusNotFound {
+		t.Errorf("bundle of an empty collection: status %d", w.Code)
+	}
+
+	w = call(h.DeleteCollection, "DELETE", "/", "", id)
+	if w.Code != http.StatusOK {
+		t.Errorf("delete: status %d", w.Code)
+	}
+	w = call(h.ListCollections, "GET", "/v1/media/collections", "", nil)
+	var list []collections.Collection
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 1 || list[0].Name != "Empty" {
+		t.Errorf("list after delete = %+v", list)
+	}
+
+	w = call(NewMediaHandler(nil, "secret").ListCollections, "GET", "/", "", nil)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("without a store: status %d", w.Code)
+	}
+}
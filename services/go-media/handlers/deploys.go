@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/deploys"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+)
+
+const (
+	// deployPrefix holds each deploy's files, under deploys/<id>/
+	deployPrefix = "deploys/"
+
+	// maxDeployFiles bounds the files in one deploy
+	maxDeployFiles = 1000
+
+	// deployConcurrency is the number of copies in flight
+	deployConcurrency = 8
+)
+
+// WithDeploys serves the deploys API and the deployed site from store
+func WithDeploys(store *deploys.Store) Option {
+	return func(h *MediaHandler) {
+		h.deploys = store
+	}
+}
+
+// DeployRequest is a site's manifest: the asset served at each of its
+// paths. The assets are copied, so later changes to them don't reach the
+// deploy.
+type DeployRequest struct {
+	Files   map[string]string `json:"files"`
+	Message string            `json:"message,omitempty"`
+	// Stage stores the deploy without making it live
+	Stage bool `json:"stage,omitempty"`
+}
+
+// validate checks every path and source key in the manifest
+func (req DeployRequest) validate() error {
+	switch {
+	case len(req.Files) == 0:
+		return errors.New("files is required")
+	case len(req.Files) > maxDeployFiles:
+		return fmt.Errorf("a deploy holds at most %d files", maxDeployFiles)
+	}
+	for p, src := range req.Files {
+		// Paths are relative to the deploy, so any prefix is theirs
+		if err := ValidateKey(p); err != nil && !errors.Is(err, ErrReservedKey) {
+			return fmt.Errorf("path %q: %w", p, err)
+		}
+		if p[len(p)-1] == '/' {
+			return fmt.Errorf("path %q: directories aren't deployed; use %sindex.html", p, p)
+		}
+		if err := ValidateKey(src); err != nil {
+			return fmt.Errorf("source of %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// errDeploySource is a manifest entry whose asset can't be deployed
+type errDeploySource struct {
+	path, key string
+}
+
+func (e errDeploySource) Error() string {
+	return fmt.Sprintf("the asset %s, for %s, doesn't exist or is encrypted", e.key, e.path)
+}
+
+// deploysEnabled writes a 501 and returns false when there is no deploy
+// store
+func (h *MediaHandler) deploysEnabled(w http.ResponseWriter) bool {
+	if h.deploys == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Deploys not enabled")
+		return false
+	}
+	return true
+}
+
+// ListDeploys lists every deploy, newest first
+func (h *MediaHandler) ListDeploys(w http.ResponseWriter, r *http.Request) {
+	if !h.deploysEnabled(w) {
+		return
+	}
+	respondJSON(w, http.StatusOK, h.deploys.List())
+}
+
+// CreateDeploy copies the assets in a manifest to a new deploy and, unless
+// it is staged, makes it live
+func (h *MediaHandler) CreateDeploy(w http.ResponseWriter, r *http.Request) {
+	if !h.deploysEnabled(w) {
+		return
+	}
+	var req DeployRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	id, err := h.deploys.Reserve()
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to create deploy")
+		return
+	}
+	audit.Annotate(r, deployPrefix+strconv.Itoa(id)+"/", map[string]string{
+		"files": strconv.Itoa(len(req.Files)),
+		"stage": strconv.FormatBool(req.Stage),
+	})
+
+	size, err := h.copyDeploy(r.Context(), id, req.Files)
+	var sourceErr errDeploySource
+	switch {
+	case errors.As(err, &sourceErr):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, sourceErr.Error())
+		return
+	case storage.IsPreconditionFailed(err):
+		respondError(w, http.StatusConflict, apierror.AssetChanged, "An asset changed while it was deployed; retry")
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to copy the deploy's files")
+		return
+	}
+
+	d, err := h.deploys.Add(deploys.Deploy{ID: id, Message: req.Message, Files: len(req.Files), Size: size}, !req.Stage)
+	if err != nil {
+		h.removeDeployFiles(id, req.Files)
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to create deploy")
+		return
+	}
+	log.Printf("Deploys: stored deploy %d, %d files", id, d.Files)
+	h.events.Publish(events.New(events.DeployCreated, map[string]interface{}{"id": id, "files": d.Files, "live": d.Live}))
+	if d.Live {
+		h.events.Publish(events.New(events.DeployActivated, map[string]interface{}{"id": id}))
+	}
+	respondJSON(w, http.StatusCreated, d)
+}
+
+// copyDeploy copies each asset in files to its path under deploy id, a
+// few at a time, and returns their total size. Publishing state is left
+// behind: a deploy's files are public as long as it exists. If any copy
+// fails, those made are deleted.
+func (h *MediaHandler) copyDeploy(ctx context.Context, id int, files map[string]string) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+
+	var (
+		size     int64
+		firstErr error
+	)
+	workpool.Each(paths, deployConcurrency, func(p string) func() {
+		n, err := h.copyDeployFile(ctx, id, p, files[p])
+		return func() {
+			size += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}
+	})
+
+	if firstErr != nil {
+		h.removeDeployFiles(id, files)
+		return 0, firstErr
+	}
+	return size, nil
+}
+
+func (h *MediaHandler) copyDeployFile(ctx context.Context, id int, p, src string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if h.sealed(src) {
+		return 0, errDeploySource{path: p, key: src}
+	}
+	head, err := h.r2Client.HeadObject(ctx, src)
+	if storage.IsNotFound(err) {
+		return 0, errDeploySource{path: p, key: src}
+	} else if err != nil {
+		return 0, err
+	}
+
+	metadata := make(map[string]string, len(head.Metadata))
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	for _, k := range []string{stateMeta, publishAtMeta, unpublishAtMeta} {
+		delete(metadata, k)
+	}
+	dst := deployKey(id, p)
+	if err := h.r2Client.CopyObject(ctx, src, dst, aws.ToString(head.ContentType), metadata, aws.ToString(head.ETag)); err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+// removeDeployFiles deletes whatever was copied of deploy id. It runs
+// apart from the request, which may have been cancelled.
+func (h *MediaHandler) removeDeployFiles(id int, files map[string]string) {
+	ctx := context.Background()
+	for p := range files {
+		if err := h.r2Client.DeleteObject(ctx, deployKey(id, p)); err != nil && !storage.IsNotFound(err) {
+			log.Printf("Deploys: failed to remove %s: %v", deployKey(id, p), err)
+		}
+	}
+}
+
+// ActivateDeploy makes a deploy live, releasing it or rolling back to it
+func (h *MediaHandler) ActivateDeploy(w http.ResponseWriter, r *http.Request) {
+	if !h.deploysEnabled(w) {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, apierror.DeployNotFound, deploys.ErrNotFound.Error())
+		return
+	}
+	audit.Annotate(r, deployPrefix+strconv.Itoa(id)+"/", nil)
+
+	d, err := h.deploys.Activate(id)
+	switch {
+	case errors.Is(err, deploys.ErrNotFound):
+		respondError(w, http.StatusNotFound, apierror.DeployNotFound, err.Error())
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to activate deploy")
+		return
+	}
+	log.Printf("Deploys: deploy %d is live", id)
+	h.events.Publish(events.New(events.DeployActivated, map[string]interface{}{"id": id}))
+	respondJSON(w, http.StatusOK, d)
+}
+
+// ServeDeploy serves a file of a deploy. Deploys never change, so they are
+// cached as immutable assets are.
+func (h *MediaHandler) ServeDeploy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || h.deploys == nil {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	}
+	if _, ok := h.deploys.Get(id); !ok {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	}
+	h.serveDeployFile(w, r, id)
+}
+
+// ServeLatest serves a file of the live deploy. Which deploy that is can
+// change at any moment, so caches revalidate every response, by ETag.
+func (h *MediaHandler) ServeLatest(w http.ResponseWriter, r *http.Request) {
+	var id int
+	var ok bool
+	if h.deploys != nil {
+		id, ok = h.deploys.Live()
+	}
+	if !ok {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	}
+	h.serveDeployFile(&revalidating{ResponseWriter: w}, r, id)
+}
+
+// serveDeployFile serves the path in r from deploy id as a public asset.
+// A path ending in / serves the directory's index.html.
+func (h *MediaHandler) serveDeployFile(w http.ResponseWriter, r *http.Request, id int) {
+	key := deployKey(id, mux.Vars(r)["path"])
+	h.ServeAsset(w, mux.SetURLVars(r, map[string]string{"path": key}))
+}
+
+func deployKey(id int, p string) string {
+	return deployPrefix + strconv.Itoa(id) + "/" + p
+}
+
+// revalidating has caches revalidate a response before each use
+type revalidating struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *revalidating) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *revalidating) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *revalidating) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
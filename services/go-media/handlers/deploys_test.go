@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/deploys"
+)
+
+func TestDeployRequestValidate(t *testing.T) {
+	valid := DeployRequest{Files: map[string]string{
+		"index.html": "site/index.3f9a.html",
+		// Paths may mirror reserved prefixes, which they are relative to
+		"logs/2024.html": "site/logs.html",
+	}}
+	if err := valid.validate(); err != nil {
+		t.Errorf("valid manifest rejected: %v", err)
+	}
+
+	for name, files := range map[string]map[string]string{
+		"empty":              {},
+		"directory path":     {"docs/": "site/docs.html"},
+		"escaping path":      {"../index.html": "site/index.html"},
+		"reserved source":    {"index.html": "deploys/1/index.html"},
+		"invalid source key": {"index.html": "/site/index.html"},
+	} {
+		if err := (DeployRequest{Files: files}).validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestDeployRoutesWithoutDeploy(t *testing.T) {
+	store, _ := deploys.Open("")
+	h := NewMediaHandler(nil, "secret", WithDeploys(store))
+
+	// Nothing is live yet, and deploy 7 doesn't exist
+	w := httptest.NewRecorder()
+	h.ServeLatest(w, mux.SetURLVars(httptest.NewRequest("GET", "/latest/index.html", nil), map[string]string{"path": "index.html"}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/latest/ status = %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeDeploy(w, mux.SetURLVars(httptest.NewRequest("GET", "/deploys/7/index.html", nil), map[string]string{"id": "7", "path": "index.html"}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/deploys/7/ status = %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ActivateDeploy(w, mux.SetURLVars(httptest.NewRequest("POST", "/v1/media/deploys/7/activate", nil), map[string]string{"id": "7"}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("activate status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.CreateDeploy(w, httptest.NewRequest("POST", "/v1/media/deploys", strings.NewReader(`{"files":{}}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("empty deploy status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	NewMediaHandler(nil, "secret").ListDeploys(w, httptest.NewRequest("GET", "/v1/media/deploys", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("disabled status = %d", w.Code)
+	}
+}
+
+func TestRevalidatingWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &revalidating{ResponseWriter: rec}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write([]byte("hello"))
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, no-cache" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("response = %d %q", rec.Code, rec.Body.String())
+	}
+}
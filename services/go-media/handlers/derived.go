@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audio"
+	"github.com/WomB0ComB0/cdn/services/go-media/documents"
+	"github.com/WomB0ComB0/cdn/services/go-media/fonts"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+	"github.com/WomB0ComB0/cdn/services/go-media/minify"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// derivedPrefix holds every variant the service generates from an
+// asset, at derived/<key>/<source ETag>/<transform>. A new version of
+// the source gets new keys, so a variant is never served for content it
+// wasn't built from.
+const derivedPrefix = "derived/"
+
+const (
+	// maxRegenerateObjects bounds the assets one request regenerates
+	maxRegenerateObjects = 1000
+
+	// regenerateConcurrency is the number of assets rebuilt at once
+	regenerateConcurrency = 4
+)
+
+// sourceETagMeta records which version of the original a variant was
+// built from
+const sourceETagMeta = "source-etag"
+
+// derivation builds one kind of variant from the content of its source
+type derivation struct {
+	transform string // fingerprint in the variant's key and ETag
+	maxSize   int64  // larger sources are served as uploaded
+	// build returns the variant and its content type, or "" for the
+	// source's
+	build func(src []byte) ([]byte, string, error)
+	// forSource, when set, adapts the derivation to the source's
+	// metadata before its key is worked out
+	forSource func(metadata map[string]string) derivation
+}
+
+// derivable reports whether variants may be built from key
+func derivable(key string) bool {
+	_, ok := minify.For(key)
+	return ok || imaging.Source(key) || audio.Source(key) || documents.Source(key) || fonts.Source(key)
+}
+
+// derivationFor returns the derivation named transform for key, so
+// stored variants can be rebuilt from their keys. Image variants outside
+// the current limits aren't rebuilt, nor are font subsets by text,
+// whose transforms are hashes.
+func (h *MediaHandler) derivationFor(key, transform string) (derivation, bool) {
+	if transform == "min" {
+		if fn, ok := minify.For(key); ok {
+			return minified(fn), true
+		}
+		return derivation{}, false
+	}
+	if fonts.Source(key) {
+		return fontSubsetFor(transform)
+	}
+	if documents.Source(key) {
+		if h.documents == nil || transform != pdfTransform {
+			return derivation{}, false
+		}
+		return h.documentDerivation(key), true
+	}
+	if audio.Source(key) {
+		o, ok := audio.ParseFingerprint(transform)
+		if h.audio == nil || !ok {
+			return derivation{}, false
+		}
+		return h.audioDerivation(o), true
+	}
+	if h.images == nil || !h.images.Reads(key) {
+		return derivation{}, false
+	}
+	o, ok := imaging.ParseFingerprint(transform)
+	if !ok || h.checkImage(o) != nil {
+		return derivation{}, false
+	}
+	return h.imageDerivation(o), true
+}
+
+// derivedKey returns where the transform variant of key is stored when
+// built from the version with sourceETag
+func derivedKey(key, sourceETag, transform string) string {
+	return derivedPrefix + key + "/" + strings.Trim(sourceETag, `"`) + "/" + transform
+}
+
+// derivedOf reports whether object is a variant of key. The prefix
+// derived/<key>/ also holds the variants of keys under key/, which are
+// one segment deeper.
+func derivedOf(key, object string) bool {
+	rest, ok := strings.CutPrefix(object, derivedPrefix+key+"/")
+	return ok && strings.Count(rest, "/") == 1
+}
+
+// derivedTransform returns the transform a variant object belongs to.
+// Transforms made of several files, such as HLS packages, store the
+// others beside the main one as <transform>_<file>.
+func derivedTransform(object string) string {
+	t, _, _ := strings.Cut(path.Base(object), "_")
+	return t
+}
+
+// WithTransformPool runs variant builds in pool, so a burst of uncached
+// variants queues instead of building all at once
+func WithTransformPool(pool *workpool.Pool) Option {
+	return func(h *MediaHandler) {
+		h.transforms = pool
+	}
+}
+
+// derivedVariant returns the object to serve for the d variant of key:
+// the stored variant of its current version, built first if it is
+// missing, and the transform it was built with. It fails only with
+// workpool.ErrSaturated; any other failure falls back to key itself.
+func (h *MediaHandler) derivedVariant(ctx context.Context, r *http.Request, key string, d derivation) (string, string, error) {
+	src, err := h.r2Client.HeadObject(ctx, key)
+	if err != nil || src.ETag == nil {
+		if err != nil && !storage.IsNotFound(err) {
+			h.reporter.CaptureError(r, err)
+		}
+		return key, "", nil
+	}
+	if d.forSource != nil {
+		d = d.forSource(src.Metadata)
+	}
+
+	variantKey := derivedKey(key, *src.ETag, d.transform)
+	_, err = h.r2Client.HeadObject(ctx, variantKey)
+	switch {
+	case err == nil:
+		return variantKey, d.transform, nil
+	case !storage.IsNotFound(err):
+		h.reporter.CaptureError(r, err)
+		return key, "", nil
+	}
+	if src.ContentLength == nil || *src.ContentLength > d.maxSize {
+		return key, "", nil
+	}
+
+	switch err := h.buildDerived(ctx, key, src, d, variantKey); {
+	case errors.Is(err, workpool.ErrSaturated):
+		return key, "", err
+	case err != nil:
+		// Sources the transform rejects are served unchanged
+		h.reporter.CaptureError(r, err)
+		return key, "", nil
+	}
+	return variantKey, d.transform, nil
+}
+
+// buildDerived builds the d variant of src, the head of key, in the
+// transformation pool and stores it at variantKey
+func (h *MediaHandler) buildDerived(ctx context.Context, key string, src *s3.HeadObjectOutput, d derivation, variantKey string) error {
+	return h.transforms.Do(ctx, func() error {
+		return h.storeDerived(ctx, key, src, d, variantKey)
+	})
+}
+
+func (h *MediaHandler) storeDerived(ctx context.Context, key string, src *s3.HeadObjectOutput, d derivation, variantKey string) error {
+	obj, err := h.r2Client.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj.Body, d.maxSize))
+	if err != nil {
+		return err
+	}
+	out, contentType, err := d.build(data)
+	if err != nil {
+		return fmt.Errorf("building %s variant of %s: %w", d.transform, key, err)
+	}
+	if contentType == "" {
+		contentType = aws.ToString(src.ContentType)
+	}
+	if contentType == "" {
+		contentType = precompressibleTypes[strings.ToLower(path.Ext(key))]
+	}
+	return h.r2Client.PutObject(ctx, variantKey, bytes.NewReader(out), contentType, map[string]string{
+		sourceETagMeta: aws.ToString(src.ETag),
+	})
+}
+
+// removeDerived deletes every variant of key, of any version, and
+// returns the transforms it deleted, each once
+func (h *MediaHandler) removeDerived(ctx context.Context, key string) (removed int, transforms []string, err error) {
+	seen := make(map[string]bool)
+	opts := storage.ListOptions{Prefix: derivedPrefix + key + "/", MaxKeys: 1000}
+	for {
+		page, err := h.ListPage(ctx, opts)
+		if err != nil {
+			return removed, transforms, err
+		}
+		for _, obj := range page.Objects {
+			if !derivedOf(key, obj.Key) {
+				continue
+			}
+			if err := h.r2Client.DeleteObject(ctx, obj.Key); err != nil {
+				return removed, transforms, err
+			}
+			removed++
+			if t := derivedTransform(obj.Key); !seen[t] {
+				seen[t] = true
+				transforms = append(transforms, t)
+			}
+		}
+		if !page.IsTruncated {
+			return removed, transforms, nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// regenerate drops the variants of key and builds the same transforms
+// of its current version again, favicon sets included. Those made by
+// video jobs are counted as built once their job is queued.
+func (h *MediaHandler) regenerate(ctx context.Context, key string) (removed, built int, err error) {
+	removed, transforms, err := h.removeDerived(ctx, key)
+	if err != nil || len(transforms) == 0 {
+		return removed, 0, err
+	}
+	src, err := h.r2Client.HeadObject(ctx, key)
+	if err != nil {
+		return removed, 0, err
+	}
+	var queued videoWork
+	for _, t := range transforms {
+		if work, ok := h.videoWorkFor(t); ok {
+			if !work.empty() && strings.HasPrefix(aws.ToString(src.ContentType), "video/") {
+				queued.presets = append(queued.presets, work.presets...)
+				queued.tracks = queued.tracks || work.tracks
+				built++
+			}
+			continue
+		}
+		if background, ok := faviconBackground(t); ok {
+			if h.images == nil || !h.images.Reads(key) || aws.ToInt64(src.ContentLength) > maxImageSize {
+				continue
+			}
+			if err := h.transforms.Do(ctx, func() error {
+				_, err := h.buildFavicons(ctx, key, src, background)
+				return err
+			}); err != nil {
+				return removed, built, err
+			}
+			built++
+			continue
+		}
+		d, ok := h.derivationFor(key, t)
+		if !ok || src.ContentLength == nil || *src.ContentLength > d.maxSize {
+			continue
+		}
+		if err := h.buildDerived(ctx, key, src, d, derivedKey(key, aws.ToString(src.ETag), d.transform)); err != nil {
+			return removed, built, err
+		}
+		built++
+	}
+	if !queued.empty() {
+		h.queueVideo(key, aws.ToString(src.ETag), queued)
+	}
+	return removed, built, nil
+}
+
+// RegenerateRequest selects the assets whose variants are rebuilt:
+// explicit keys or every object under a prefix
+type RegenerateRequest struct {
+	Keys   []string `json:"keys,omitempty" validate:"dive,required"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+func (req RegenerateRequest) Check() validation.Errors {
+	return keysOrPrefix(req.Keys, req.Prefix)
+}
+
+type RegenerateResponse struct {
+	Assets  int                   `json:"assets"`
+	Removed int                   `json:"removed"`
+	Built   int                   `json:"built"`
+	Failed  []workpool.KeyFailure `json:"failed,omitempty"`
+}
+
+// RegenerateDerived deletes the stored variants of up to
+// maxRegenerateObjects assets and rebuilds them, for after a change to
+// how variants are made. Variants of old versions are deleted too.
+func (h *MediaHandler) RegenerateDerived(w http.ResponseWriter, r *http.Request) {
+	var req RegenerateRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	keys := req.Keys
+	var err error
+	if req.Prefix != "" {
+		var entries []bundleEntry
+		entries, err = h.prefixEntries(r.Context(), req.Prefix, maxRegenerateObjects)
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+	} else if len(keys) > maxRegenerateObjects {
+		err = errTooManyObjects
+	}
+	switch {
+	case errors.Is(err, errTooManyObjects):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("At most %d objects can be regenerated at once", maxRegenerateObjects))
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return
+	case len(keys) == 0:
+		respondError(w, http.StatusNotFound, apierror.NotFound, "No objects under prefix")
+		return
+	}
+	for _, key := range keys {
+		if err := ValidateKey(key); err != nil {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, regenerateAll(r.Context(), keys, h.regenerate))
+}
+
+// regenerateAll rebuilds the variants of every key with regen, a few
+// keys at a time
+func regenerateAll(ctx context.Context, keys []string, regen func(ctx context.Context, key string) (removed, built int, err error)) RegenerateResponse {
+	resp := RegenerateResponse{Assets: len(keys)}
+	workpool.Each(keys, regenerateConcurrency, func(key string) func() {
+		removed, built, err := regen(ctx, key)
+		return func() {
+			resp.Removed += removed
+			resp.Built += built
+			if err != nil {
+				resp.Failed = append(resp.Failed, workpool.KeyFailure{Key: key, Error: err.Error()})
+			}
+		}
+	})
+	return resp
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/audio"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+)
+
+func TestDerivedKey(t *testing.T) {
+	if got, want := derivedKey("static/app.js", `"9b2c"`, "min"), "derived/static/app.js/9b2c/min"; got != want {
+		t.Errorf("derivedKey = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		object string
+		want   bool
+	}{
+		{"derived/static/app.js/9b2c/min", true},
+		{"derived/static/app.js/old/min", true},
+		{"derived/static/app.js/nested.js/9b2c/min", false},
+		{"derived/static/app.jsx/9b2c/min", false},
+		{"static/app.js", false},
+	}
+	for _, tt := range tests {
+		if got := derivedOf("static/app.js", tt.object); got != tt.want {
+			t.Errorf("derivedOf(%q) = %v, want %v", tt.object, got, tt.want)
+		}
+	}
+}
+
+func TestDerivationFor(t *testing.T) {
+	engine, err := imaging.New("go", imaging.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &MediaHandler{images: engine, imageLimits: imaging.Limits{MaxWidth: 1000}, audio: &audio.Converter{}, documents: fakeConverter{}}
+
+	tests := []struct {
+		key, transform string
+		want           bool
+	}{
+		{"static/app.js", "min", true},
+		{"photo.png", "min", false},
+		{"photo.png", "w300-h200-cover", true},
+		{"photo.png", "w300-webp", false}, // the go engine can't write WebP
+		{"photo.png", "w2000", false},     // outside the limits
+		{"static/app.js", "w300", false},
+		{"photo.png", "bogus", false},
+		{"episodes/42.wav", "mp3-b192-norm", true},
+		{"episodes/42.wav", "mp3-b100", false},
+		{"photo.png", "mp3", false},
+		{"reports/q3.docx", "pdf", true},
+		{"reports/q3.docx", "mp3", false},
+		{"photo.png", "pdf", false},
+		{"fonts/Inter.ttf", "subset-cyrillic+latin", true},
+		{"fonts/Inter.woff2", "subset-klingon", false},
+		{"fonts/Inter.ttf", textSubset("Hello").transform, false}, // rebuilt on request
+		{"fonts/Inter.ttf", "min", false},
+	}
+	for _, tt := range tests {
+		d, ok := h.derivationFor(tt.key, tt.transform)
+		if ok != tt.want || ok && d.transform != tt.transform {
+			t.Errorf("derivationFor(%s, %s) = %q, %v, want %v", tt.key, tt.transform, d.transform, ok, tt.want)
+		}
+	}
+	if !derivable("photo.jpg") || !derivable("app.css") || !derivable("episodes/42.flac") || !derivable("reports/q3.pptx") || !derivable("fonts/Inter.woff2") || derivable("notes.txt") {
+		t.Error("derivable disagrees with the minifiable, image, audio, document and font types")
+	}
+}
+
+func TestRegenerateAll(t *testing.T) {
+	regen := func(ctx context.Context, key string) (int, int, error) {
+		if key == "broken.js" {
+			return 1, 0, errors.New("parse error")
+		}
+		return 2, 1, nil
+	}
+	resp := regenerateAll(context.Background(), []string{"a.js", "b.css", "broken.js"}, regen)
+	if resp.Assets != 3 || resp.Removed != 5 || resp.Built != 2 {
+		t.Errorf("counts = %d assets, %d removed, %d built; want 3, 5, 2", resp.Assets, resp.Removed, resp.Built)
+	}
+	if len(resp.Failed) != 1 || resp.Failed[0].Key != "broken.js" {
+		t.Errorf("Failed = %v, want broken.js", resp.Failed)
+	}
+}
+
+// fakeConverter stands in for LibreOffice
+type fakeConverter struct{}
+
+func (fakeConverter) Name() string { return "fake" }
+
+func (fakeConverter) Convert(ctx context.Context, src []byte, ext string) ([]byte, error) {
+	return append([]byte("%PDF-1.7\n"), src...), nil
+}
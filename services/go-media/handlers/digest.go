@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Object metadata holding the hex digests of an upload's content as
+// clients download it, before any encryption
+const (
+	sha256Meta = "sha256"
+	md5Meta    = "md5"
+	// sha384Meta is the digest Subresource Integrity hashes are made of
+	sha384Meta = "sha384"
+)
+
+// contentDigests returns the digest metadata for data
+func contentDigests(data []byte) map[string]string {
+	sha := sha256.Sum256(data)
+	sum := md5.Sum(data)
+	sri := sha512.Sum384(data)
+	return map[string]string{
+		sha256Meta: hex.EncodeToString(sha[:]),
+		md5Meta:    hex.EncodeToString(sum[:]),
+		sha384Meta: hex.EncodeToString(sri[:]),
+	}
+}
+
+// integrity returns the Subresource Integrity hash, as <script> and
+// <link> take it, of the content with the given digest metadata: of its
+// SHA-384, or of its SHA-256 for objects stored before SHA-384s were
+func integrity(digests map[string]string) string {
+	for _, d := range []struct{ meta, name string }{{sha384Meta, "sha384"}, {sha256Meta, "sha256"}} {
+		if sum, err := hex.DecodeString(digests[d.meta]); err == nil && len(sum) > 0 {
+			return d.name + "-" + base64.StdEncoding.EncodeToString(sum)
+		}
+	}
+	return ""
+}
+
+// setDigestHeaders advertises the digests stored with an object, so
+// mirrors can verify what they fetched: the SHA-256 as x-amz-meta-sha256,
+// as S3 clients see it, and both in an RFC 3230 Digest header. Objects
+// stored without digests get neither.
+func setDigestHeaders(w http.ResponseWriter, metadata map[string]string) {
+	var digests []string
+	for _, d := range []struct{ meta, name string }{{sha256Meta, "sha-256"}, {md5Meta, "md5"}} {
+		sum, err := hex.DecodeString(metadata[d.meta])
+		if err != nil || len(sum) == 0 {
+			continue
+		}
+		digests = append(digests, d.name+"="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if sha := metadata[sha256Meta]; sha != "" {
+		w.Header().Set("X-Amz-Meta-Sha256", sha)
+	}
+	if len(digests) > 0 {
+		w.Header().Set("Digest", strings.Join(digests, ","))
+	}
+}
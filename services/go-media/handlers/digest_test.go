@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestDigestHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	setDigestHeaders(w, contentDigests([]byte("hello world")))
+
+	if got, want := w.Header().Get("Digest"), "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=,md5=XrY7u+Ae7tCTyyK7j1rNww=="; got != want {
+		t.Errorf("Digest = %q, want %q", got, want)
+	}
+	if got, want := w.Header().Get("X-Amz-Meta-Sha256"), "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"; got != want {
+		t.Errorf("X-Amz-Meta-Sha256 = %q, want %q", got, want)
+	}
+
+	// Objects uploaded before digests were stored, or by other tools
+	w = httptest.NewRecorder()
+	setDigestHeaders(w, map[string]string{"md5": "not hex"})
+	if d := w.Header().Get("Digest"); d != "" {
+		t.Errorf("Digest = %q for an object without valid digests", d)
+	}
+}
+
+func TestStoredContent(t *testing.T) {
+	data := []byte("hello world")
+	digests := contentDigests(data)
+	md5ETag := aws.String(`"` + digests[md5Meta] + `"`)
+
+	tests := []struct {
+		name string
+		head s3.HeadObjectOutput
+		want bool
+	}{
+		{"same sha256", s3.HeadObjectOutput{ETag: aws.String(`"abc-2"`), Metadata: digests}, true},
+		{"other sha256", s3.HeadObjectOutput{ETag: md5ETag, Metadata: map[string]string{sha256Meta: "00"}}, false},
+		{"older object, ETag is the MD5", s3.HeadObjectOutput{ETag: md5ETag}, true},
+		{"older object, other content", s3.HeadObjectOutput{ETag: aws.String(`"d41d8cd98f00b204e9800998ecf8427e"`)}, false},
+		{"older sealed object", s3.HeadObjectOutput{ETag: md5ETag, Metadata: map[string]string{envelope.AlgorithmMeta: "AES-256-GCM"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storedContent(&tt.head, data); got != tt.want {
+				t.Errorf("storedContent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	digests := contentDigests([]byte("hello world"))
+	if got, want := integrity(digests), "sha384-/b2OdaZ/KfcBpOBAOF4uI5hjA+oQI5IRr5B/y7g1eLPkF8txzmRu/QgZ3YwIjeG9"; got != want {
+		t.Errorf("integrity() = %q, want %q", got, want)
+	}
+
+	// Objects uploaded before SHA-384s were stored
+	delete(digests, sha384Meta)
+	if got, want := integrity(digests), "sha256-uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="; got != want {
+		t.Errorf("integrity() = %q, want %q", got, want)
+	}
+	if got := integrity(map[string]string{md5Meta: digests[md5Meta]}); got != "" {
+		t.Errorf("integrity() = %q without a SHA digest", got)
+	}
+}
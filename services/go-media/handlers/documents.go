@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/documents"
+)
+
+// maxDocumentSize bounds the documents converted on request; larger ones
+// are served as uploaded
+const maxDocumentSize = 64 << 20
+
+// documentTimeout bounds one conversion
+const documentTimeout = 2 * time.Minute
+
+// pdfTransform names the PDF variant of a document
+const pdfTransform = "pdf"
+
+// WithDocumentConverter converts office documents asked for with
+// ?format=pdf with c; nil serves them as uploaded
+func WithDocumentConverter(c documents.Converter) Option {
+	return func(h *MediaHandler) {
+		h.documents = c
+	}
+}
+
+// wantsDocument returns the PDF variant a request for key asks for
+func (h *MediaHandler) wantsDocument(r *http.Request, key string) (derivation, bool) {
+	if h.documents == nil || !documents.Source(key) || r.URL.Query().Get("format") != pdfTransform {
+		return derivation{}, false
+	}
+	return h.documentDerivation(key), true
+}
+
+// documentDerivation is the derivation converting key to PDF
+func (h *MediaHandler) documentDerivation(key string) derivation {
+	ext := strings.ToLower(path.Ext(key))
+	return derivation{transform: pdfTransform, maxSize: maxDocumentSize, build: func(src []byte) ([]byte, string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), documentTimeout)
+		defer cancel()
+		pdf, err := h.documents.Convert(ctx, src, ext)
+		return pdf, documents.ContentType, err
+	}}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/domains"
+)
+
+// dnsTimeout bounds a challenge record lookup
+const dnsTimeout = 5 * time.Second
+
+// WithDomains serves the custom domains in store, and the admin API
+// that manages them
+func WithDomains(store *domains.Store) Option {
+	return func(h *MediaHandler) {
+		h.customDomains = store
+		h.lookupTXT = net.DefaultResolver.LookupTXT
+	}
+}
+
+// domainsEnabled writes a 501 and returns false when there is no domain
+// store
+func (h *MediaHandler) domainsEnabled(w http.ResponseWriter) bool {
+	if h.customDomains == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Custom domains not enabled")
+		return false
+	}
+	return true
+}
+
+// ListDomains lists every custom domain, by host
+func (h *MediaHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	if !h.domainsEnabled(w) {
+		return
+	}
+	respondJSON(w, http.StatusOK, h.customDomains.List())
+}
+
+// AddDomain adds a custom domain for a prefix. It serves nothing until
+// verified: the response names the TXT record that must hold its token.
+func (h *MediaHandler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	if !h.domainsEnabled(w) {
+		return
+	}
+	var req struct {
+		Host   string `json:"host" validate:"required"`
+		Prefix string `json:"prefix" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	audit.Annotate(r, req.Prefix, map[string]string{"host": req.Host})
+	d, err := h.customDomains.Add(req.Host, req.Prefix)
+	if err != nil {
+		h.domainError(w, r, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, d)
+}
+
+// GetDomain returns a custom domain
+func (h *MediaHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
+	if !h.domainsEnabled(w) {
+		return
+	}
+	d, ok := h.customDomains.Get(mux.Vars(r)["host"])
+	if !ok {
+		h.domainError(w, r, domains.ErrNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, d)
+}
+
+// VerifyDomain looks up a custom domain's challenge record and, once it
+// holds the domain's token, starts serving the domain
+func (h *MediaHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	if !h.domainsEnabled(w) {
+		return
+	}
+	d, ok := h.customDomains.Get(mux.Vars(r)["host"])
+	if !ok {
+		h.domainError(w, r, domains.ErrNotFound)
+		return
+	}
+	audit.Annotate(r, d.Prefix, map[string]string{"host": d.Host})
+	if !d.Verified {
+		ctx, cancel := context.WithTimeout(r.Context(), dnsTimeout)
+		defer cancel()
+		records, err := h.lookupTXT(ctx, d.Challenge)
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			respondError(w, http.StatusBadGateway, apierror.Upstream, fmt.Sprintf("Failed to look up %s: %v", d.Challenge, err))
+			return
+		}
+		if !slices.Contains(records, d.Token) {
+			respondError(w, http.StatusConflict, apierror.DomainUnverified, fmt.Sprintf("No TXT record at %s holds the domain's token yet; DNS changes can take a while to be seen", d.Challenge))
+			return
+		}
+	}
+	d, err := h.customDomains.Verify(d.Host)
+	if err != nil {
+		h.domainError(w, r, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, d)
+}
+
+// DeleteDomain removes a custom domain, which stops serving at once. Its
+// assets are left alone.
+func (h *MediaHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	if !h.domainsEnabled(w) {
+		return
+	}
+	host := mux.Vars(r)["host"]
+	audit.Annotate(r, "", map[string]string{"host": host})
+	if err := h.customDomains.Remove(host); err != nil {
+		h.domainError(w, r, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (h *MediaHandler) domainError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, domains.ErrNotFound):
+		respondError(w, http.StatusNotFound, apierror.DomainNotFound, "Domain not found")
+	case errors.Is(err, domains.ErrExists):
+		respondError(w, http.StatusConflict, apierror.DomainExists, "Domain already added")
+	case errors.Is(err, domains.ErrInvalid):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+	default:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to save domains")
+	}
+}
+
+// OnCustomDomain matches requests to a verified custom domain, which
+// ServeCustomDomain serves in place of every other route
+func (h *MediaHandler) OnCustomDomain(r *http.Request, _ *mux.RouteMatch) bool {
+	if h.customDomains == nil {
+		return false
+	}
+	_, ok := h.customDomains.Serving(hostname(r))
+	return ok
+}
+
+// ServeCustomDomain serves the asset a path on a custom domain maps to,
+// under the domain's prefix, as a public asset
+func (h *MediaHandler) ServeCustomDomain(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.customDomains.Serving(hostname(r))
+	if !ok {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		respondError(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+	h.ServeAsset(w, mux.SetURLVars(r, map[string]string{"path": d.Key(r.URL.Path)}))
+}
+
+// hostname is the host of r without its port
+func hostname(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
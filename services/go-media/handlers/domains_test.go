@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/domains"
+)
+
+func TestCustomDomains(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewMediaHandler(nil, "secret").ListDomains(w, httptest.NewRequest("GET", "/v1/admin/domains", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("without a store: status = %d", w.Code)
+	}
+
+	store, _ := domains.Open("")
+	h := NewMediaHandler(nil, "secret", WithDomains(store))
+	var records []string
+	h.lookupTXT = func(_ context.Context, name string) ([]string, error) {
+		if name != "_cdn-challenge.assets.acme.test" {
+			t.Errorf("looked up %s", name)
+		}
+		return records, nil
+	}
+
+	w = httptest.NewRecorder()
+	h.AddDomain(w, httptest.NewRequest("POST", "/v1/admin/domains", strings.NewReader(`{"host": "assets.acme.test", "prefix": "tenants/acme/"}`)))
+	var d domains.Domain
+	json.NewDecoder(w.Body).Decode(&d)
+	if w.Code != http.StatusCreated || d.Token == "" {
+		t.Fatalf("AddDomain() = %d, %+v", w.Code, d)
+	}
+
+	verify := func() int {
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/v1/admin/domains/assets.acme.test/verify", nil), map[string]string{"host": "assets.acme.test"})
+		w := httptest.NewRecorder()
+		h.VerifyDomain(w, r)
+		return w.Code
+	}
+	onDomain := httptest.NewRequest("GET", "/logo.png", nil)
+	onDomain.Host = "assets.acme.test:443"
+
+	records = []string{"v=spf1 -all"}
+	if code := verify(); code != http.StatusConflict {
+		t.Errorf("VerifyDomain() without the record = %d", code)
+	}
+	if h.OnCustomDomain(onDomain, nil) {
+		t.Error("unverified domain matched")
+	}
+	if got := h.publicURL("tenants/acme/logo.png"); got != publicBaseURL+"/tenants/acme/logo.png" {
+		t.Errorf("publicURL() = %s before verifying", got)
+	}
+
+	records = append(records, d.Token)
+	if code := verify(); code != http.StatusOK {
+		t.Errorf("VerifyDomain() = %d", code)
+	}
+	if !h.OnCustomDomain(onDomain, nil) {
+		t.Error("verified domain didn't match")
+	}
+	if got := h.publicURL("tenants/acme/logo.png"); got != "https://assets.acme.test/logo.png" {
+		t.Errorf("publicURL() = %s", got)
+	}
+	if got := h.assetURL(context.Background(), "tenants/acme/logo.png", true); got != publicBaseURL+"/v1/media/private/tenants/acme/logo.png" {
+		t.Errorf("private assetURL() = %s", got)
+	}
+
+	w = httptest.NewRecorder()
+	post := httptest.NewRequest("POST", "/v1/media/upload", nil)
+	post.Host = "assets.acme.test"
+	h.ServeCustomDomain(w, post)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST on a custom domain = %d", w.Code)
+	}
+}
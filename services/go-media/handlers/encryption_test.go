@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestOpenSealed(t *testing.T) {
+	ctx := context.Background()
+	keyring, err := envelope.NewKeyring([]string{"v1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewMediaHandler(nil, "secret", WithEncryption(envelope.New(keyring), "secure/"))
+
+	if !h.sealed("secure/a.pdf") || h.sealed("assets/a.pdf") || h.sealed("securely/a.pdf") {
+		t.Error("sealed() doesn't follow the prefix")
+	}
+
+	plaintext := []byte("tax return")
+	ciphertext, meta, err := h.sealer.Seal(ctx, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(ciphertext)), Metadata: meta}
+	if err := h.openSealed(ctx, obj); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(obj.Body)
+	if !bytes.Equal(got, plaintext) || *obj.ContentLength != int64(len(plaintext)) {
+		t.Errorf("opened %q (length %d), want %q", got, *obj.ContentLength, plaintext)
+	}
+
+	plain := &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(plaintext))}
+	if err := h.openSealed(ctx, plain); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(plain.Body); !bytes.Equal(got, plaintext) {
+		t.Errorf("unencrypted object changed to %q", got)
+	}
+
+	off := NewMediaHandler(nil, "secret")
+	sealedObj := &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(ciphertext)), Metadata: meta}
+	if err := off.openSealed(ctx, sealedObj); !errors.Is(err, errNoSealer) {
+		t.Errorf("opening without keys: %v, want errNoSealer", err)
+	}
+}
+
+func TestCustomerKeyContext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/media/private/assets/a.pdf", nil)
+	ctx, err := customerKeyContext(context.Background(), r)
+	if err != nil || storage.HasCustomerKey(ctx) {
+		t.Fatalf("no header: key %v, err %v", storage.HasCustomerKey(ctx), err)
+	}
+
+	r.Header.Set(customerKeyHeader, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if ctx, err := customerKeyContext(context.Background(), r); err != nil || !storage.HasCustomerKey(ctx) {
+		t.Errorf("valid key: key %v, err %v", storage.HasCustomerKey(ctx), err)
+	}
+
+	r.Header.Set(customerKeyHeader, base64.StdEncoding.EncodeToString([]byte("too short")))
+	if _, err := customerKeyContext(context.Background(), r); !errors.Is(err, storage.ErrInvalidCustomerKey) {
+		t.Errorf("short key: err %v, want ErrInvalidCustomerKey", err)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	// maxExistsKeys bounds the keys one request checks
+	maxExistsKeys = 1000
+
+	// existsConcurrency is the number of HEAD requests in flight
+	existsConcurrency = 16
+)
+
+type ExistsRequest struct {
+	Keys []string `json:"keys" validate:"required,dive,required"`
+}
+
+// ExistsResponse maps each key that exists to its ETag and lists the
+// ones that don't. Keys whose check failed are in Failed, and should be
+// treated as unknown.
+type ExistsResponse struct {
+	Found   map[string]string     `json:"found"`
+	Missing []string              `json:"missing"`
+	Failed  []workpool.KeyFailure `json:"failed,omitempty"`
+}
+
+// Exists checks which of up to maxExistsKeys keys exist, so deploy tools
+// can skip uploading files whose ETag already matches without a HEAD
+// request per file
+func (h *MediaHandler) Exists(w http.ResponseWriter, r *http.Request) {
+	var req ExistsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Keys) > maxExistsKeys {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("At most %d keys can be checked at once", maxExistsKeys))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, checkExists(r.Context(), req.Keys, h.headETag))
+}
+
+// headETag returns the ETag of the object at key
+func (h *MediaHandler) headETag(ctx context.Context, key string) (string, error) {
+	head, err := h.HeadAsset(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(aws.ToString(head.ETag), `"`), nil
+}
+
+// checkExists looks up every distinct key with head, a few at a time
+func checkExists(ctx context.Context, keys []string, head func(ctx context.Context, key string) (string, error)) ExistsResponse {
+	seen := make(map[string]bool, len(keys))
+	var distinct []string
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, key)
+		}
+	}
+
+	resp := ExistsResponse{Found: make(map[string]string), Missing: []string{}}
+	workpool.Each(distinct, existsConcurrency, func(key string) func() {
+		etag, err := head(ctx, key)
+		return func() {
+			switch {
+			case err == nil:
+				resp.Found[key] = etag
+			case storage.IsNotFound(err):
+				resp.Missing = append(resp.Missing, key)
+			default:
+				resp.Failed = append(resp.Failed, workpool.KeyFailure{Key: key, Error: err.Error()})
+			}
+		}
+	})
+
+	sort.Strings(resp.Missing)
+	sort.Slice(resp.Failed, func(i, j int) bool { return resp.Failed[i].Key < resp.Failed[j].Key })
+	return resp
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+)
+
+func TestCheckExists(t *testing.T) {
+	var heads atomic.Int32
+	head := func(ctx context.Context, key string) (string, error) {
+		heads.Add(1)
+		switch key {
+		case "app.js", "logo.png":
+			return "etag-" + key, nil
+		case "flaky.css":
+			return "", errors.New("connection reset")
+		}
+		return "", &smithy.GenericAPIError{Code: "NotFound"}
+	}
+
+	got := checkExists(context.Background(), []string{"new.js", "app.js", "logo.png", "app.js", "flaky.css", "gone.svg"}, head)
+
+	want := ExistsResponse{
+		Found:   map[string]string{"app.js": "etag-app.js", "logo.png": "etag-logo.png"},
+		Missing: []string{"gone.svg", "new.js"},
+		Failed:  []workpool.KeyFailure{{Key: "flaky.css", Error: "connection reset"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkExists() = %+v, want %+v", got, want)
+	}
+	if n := heads.Load(); n != 5 {
+		t.Errorf("%d HEAD requests for 5 distinct keys", n)
+	}
+}
+
+func TestExistsValidation(t *testing.T) {
+	h := &MediaHandler{}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no keys", `{"keys": []}`, "keys is required"},
+		{"empty key", `{"keys": ["a.js", ""]}`, `"field":"keys[1]","rule":"required"`},
+		{"too many", `{"keys": [` + strings.Repeat(`"a",`, maxExistsKeys) + `"a"]}`, "At most 1000"},
+		{"wrong type", `{"keys": "a.js"}`, "keys must be a list"},
+		{"malformed", `{"keys": [`, "Invalid request body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.Exists(w, httptest.NewRequest("POST", "/v1/media/exists", strings.NewReader(tt.body)))
+			if w.Code != 400 || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Exists() = %d %s, want 400 %q", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
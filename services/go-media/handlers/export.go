@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// maxExportObjects bounds the listing an export holds in memory
+const maxExportObjects = 100000
+
+// Export streams every object under ?prefix= as a tar archive, for
+// backups. format=tar.gz (the default) is compressed on the fly; a plain
+// format=tar has a known length and ETag, so interrupted downloads can
+// resume with Range requests.
+func (h *MediaHandler) Export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, format := q.Get("prefix"), q.Get("format")
+	if format == "" {
+		format = "tar.gz"
+	}
+	if format != "tar" && format != "tar.gz" {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "format must be tar or tar.gz")
+		return
+	}
+
+	entries, err := h.prefixEntries(r.Context(), prefix, maxExportObjects)
+	switch {
+	case errors.Is(err, errTooManyObjects):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("An export may contain at most %d objects; use a narrower prefix", maxExportObjects))
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return
+	case len(entries) == 0:
+		respondError(w, http.StatusNotFound, apierror.NotFound, "No objects under prefix")
+		return
+	}
+
+	archive, err := newTarArchive(entries)
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to build archive")
+		return
+	}
+	reader := archive.reader(r.Context(), h.openExportRange)
+	defer reader.Close()
+
+	name := strings.Trim(strings.ReplaceAll(prefix, "/", "-"), "-")
+	w.Header().Set("Content-Disposition", contentDisposition(name, "export."+format))
+
+	if format == "tar" {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("ETag", archive.etag)
+		http.ServeContent(w, r, "", archive.modified, reader)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	if r.Method == http.MethodHead {
+		return
+	}
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, reader); err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		log.Printf("Export failed after headers were sent: %v", err)
+		h.reporter.CaptureError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// openExportRange opens key from offset, counting the bytes read
+// towards its analytics
+func (h *MediaHandler) openExportRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	byteRange := ""
+	if offset > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	}
+	obj, err := h.OpenAssetRange(ctx, key, byteRange)
+	if err != nil {
+		return nil, err
+	}
+	return &servedReader{ReadCloser: obj.Body, key: key, record: h.RecordServed}, nil
+}
+
+// tarArchive is the layout of a tar archive of objects, computed from a
+// listing so any byte range can be produced without generating what
+// comes before it. Each member is its header, the object's bytes and
+// zero padding to the next 512-byte block; two zero blocks end it.
+type tarArchive struct {
+	members  []tarMember
+	size     int64
+	etag     string
+	modified time.Time
+}
+
+type tarMember struct {
+	offset int64
+	header []byte
+	key    string
+	size   int64
+}
+
+// end is the archive offset just past the member's padding
+func (m tarMember) end() int64 {
+	return m.offset + int64(len(m.header)) + m.size + tarPadding(m.size)
+}
+
+func tarPadding(size int64) int64 {
+	return (512 - size%512) % 512
+}
+
+// tarTrailerSize is the two zero blocks ending an archive
+const tarTrailerSize = 1024
+
+func newTarArchive(entries []bundleEntry) (*tarArchive, error) {
+	a := &tarArchive{}
+	sum := sha256.New()
+	for _, e := range entries {
+		var buf bytes.Buffer
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     e.Name,
+			Size:     e.Size,
+			Mode:     0o644,
+			ModTime:  e.LastModified.Truncate(time.Second),
+		}
+		// WriteHeader emits the header blocks immediately; the writer is
+		// discarded before any content is expected
+		if err := tar.NewWriter(&buf).WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("tar header for %s: %w", e.Key, err)
+		}
+
+		m := tarMember{offset: a.size, header: buf.Bytes(), key: e.Key, size: e.Size}
+		a.members = append(a.members, m)
+		a.size = m.end()
+
+		fmt.Fprintf(sum, "%s\x00%s\x00%d\x00%d\x00%s\n", e.Name, e.Key, e.Size, e.LastModified.Unix(), e.ETag)
+		if e.LastModified.After(a.modified) {
+			a.modified = e.LastModified
+		}
+	}
+	a.size += tarTrailerSize
+	a.etag = `"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+	return a, nil
+}
+
+// reader returns a seekable reader over the archive. open fetches an
+// object from a byte offset.
+func (a *tarArchive) reader(ctx context.Context, open func(ctx context.Context, key string, offset int64) (io.ReadCloser, error)) *tarReader {
+	return &tarReader{ctx: ctx, archive: a, open: open}
+}
+
+// tarReader produces archive bytes on demand. Object content is fetched
+// with one ranged read per member, reopened only after a Seek.
+type tarReader struct {
+	ctx     context.Context
+	archive *tarArchive
+	open    func(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+	off     int64
+
+	// body is positioned at archive offset bodyOff
+	body    io.ReadCloser
+	bodyOff int64
+}
+
+func (t *tarReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += t.off
+	case io.SeekEnd:
+		offset += t.archive.size
+	}
+	if offset < 0 {
+		return 0, errors.New("tar: negative position")
+	}
+	t.off = offset
+	return offset, nil
+}
+
+func (t *tarReader) Read(p []byte) (int, error) {
+	if t.off >= t.archive.size {
+		return 0, io.EOF
+	}
+
+	members := t.archive.members
+	i := sort.Search(len(members), func(i int) bool { return members[i].end() > t.off })
+	if i == len(members) {
+		// Trailer
+		n := zeroFill(p, t.archive.size-t.off)
+		t.off += int64(n)
+		return n, nil
+	}
+
+	m := members[i]
+	rel := t.off - m.offset
+	hdrLen := int64(len(m.header))
+	var n int
+	var err error
+	switch {
+	case rel < hdrLen:
+		n = copy(p, m.header[rel:])
+	case rel < hdrLen+m.size:
+		n, err = t.readContent(p, m, rel-hdrLen)
+	default:
+		n = zeroFill(p, m.end()-t.off)
+	}
+	t.off += int64(n)
+	return n, err
+}
+
+// readContent reads member content from dataOff, opening the object if
+// the reader is not already positioned there
+func (t *tarReader) readContent(p []byte, m tarMember, dataOff int64) (int, error) {
+	if t.body == nil || t.bodyOff != t.off {
+		t.Close()
+		body, err := t.open(t.ctx, m.key, dataOff)
+		if err != nil {
+			return 0, fmt.Errorf("open %s: %w", m.key, err)
+		}
+		t.body, t.bodyOff = body, t.off
+	}
+
+	if remaining := m.size - dataOff; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := t.body.Read(p)
+	t.bodyOff += int64(n)
+	if dataOff+int64(n) == m.size {
+		t.Close()
+		return n, nil
+	}
+	if err == io.EOF {
+		if n > 0 {
+			return n, nil
+		}
+		// The object is shorter than it was when listed
+		return 0, fmt.Errorf("%s: %w", m.key, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// Close releases the open object, if any
+func (t *tarReader) Close() error {
+	if t.body == nil {
+		return nil
+	}
+	err := t.body.Close()
+	t.body = nil
+	return err
+}
+
+func zeroFill(p []byte, limit int64) int {
+	if int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p)
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+)
+
+// faviconsTransform starts the transform of a favicon set, followed by
+// its background color. Its main object is the FaviconSet as JSON, and
+// the icons are stored beside it as <transform>_<file>.
+const faviconsTransform = "favicons-"
+
+const defaultFaviconBackground = "ffffff"
+
+// maskableContent is the share of a maskable icon's side its image
+// takes. Platforms crop maskable icons to shapes as small as a circle
+// 80% across, which a square 56% across fits inside.
+const maskableContent = 0.56
+
+// faviconSpec is one icon of a favicon set
+type faviconSpec struct {
+	file string
+	size int
+	// content is the share of the side the image takes, centered
+	content float64
+	// opaque icons are filled with the background; others are
+	// transparent around the image
+	opaque  bool
+	purpose string // manifest purpose, for icons in the manifest
+	rel     string // link relation, for icons linked from HTML
+}
+
+// faviconSpecs are the PNG icons of a favicon set
+var faviconSpecs = []faviconSpec{
+	{file: "favicon-16x16.png", size: 16, content: 1, rel: "icon"},
+	{file: "favicon-32x32.png", size: 32, content: 1, rel: "icon"},
+	{file: "apple-touch-icon.png", size: 180, content: 1, opaque: true, rel: "apple-touch-icon"},
+	{file: "icon-192x192.png", size: 192, content: 1, purpose: "any"},
+	{file: "icon-512x512.png", size: 512, content: 1, purpose: "any"},
+	{file: "maskable-192x192.png", size: 192, content: maskableContent, opaque: true, purpose: "maskable"},
+	{file: "maskable-512x512.png", size: 512, content: maskableContent, opaque: true, purpose: "maskable"},
+}
+
+// faviconICOSizes are the images in favicon.ico
+var faviconICOSizes = []int{16, 32, 48}
+
+// FaviconSet is the icons built from an image, with the markup that uses
+// them
+type FaviconSet struct {
+	Key string `json:"key"`
+	// ICO is favicon.ico, with 16, 32 and 48 pixel images
+	ICO   string        `json:"ico"`
+	Icons []FaviconIcon `json:"icons"`
+	// Manifest is the icons member of a web app manifest
+	Manifest struct {
+		Icons []FaviconIcon `json:"icons"`
+	} `json:"manifest"`
+	// HTML is the <link> elements for a page's <head>
+	HTML string `json:"html"`
+}
+
+type FaviconIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// Favicons builds the favicon and app icon set of an image asset, once
+// per version and background, and returns it. Building it again returns
+// the stored set.
+func (h *MediaHandler) Favicons(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["path"]
+	background, ok := hexColor(r.URL.Query().Get("background"), defaultFaviconBackground)
+	if !ok {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "background must be a hex color such as fff or 1f2937, without the #")
+		return
+	}
+	audit.Annotate(r, key, map[string]string{"background": background})
+
+	if err := ValidateKey(key); err != nil {
+		status, code := http.StatusBadRequest, apierror.InvalidRequest
+		if errors.Is(err, ErrReservedKey) {
+			status, code = http.StatusForbidden, apierror.ReservedKey
+		}
+		respondError(w, status, code, err.Error())
+		return
+	}
+	if h.images == nil || !h.images.Reads(key) || !h.images.Writes("png") {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Favicons are built from JPEG, PNG or GIF images, or another type the image engine reads")
+		return
+	}
+	if h.sealed(key) || h.hidden(key) {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Favicons are public, so they can't be built from encrypted or unpublished assets")
+		return
+	}
+
+	ctx := r.Context()
+	src, err := h.r2Client.HeadObject(ctx, key)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+			return
+		}
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read asset")
+		return
+	}
+	if aws.ToInt64(src.ContentLength) > maxImageSize {
+		respondError(w, http.StatusBadRequest, apierror.FileTooLarge, fmt.Sprintf("Image is over %d MB", maxImageSize>>20))
+		return
+	}
+
+	transform := faviconsTransform + background
+	if obj, err := h.r2Client.GetObject(ctx, derivedKey(key, aws.ToString(src.ETag), transform)); err == nil {
+		defer obj.Body.Close()
+		var set FaviconSet
+		if err := json.NewDecoder(obj.Body).Decode(&set); err == nil {
+			respondJSON(w, http.StatusOK, set)
+			return
+		}
+	}
+
+	var set *FaviconSet
+	err = h.transforms.Do(ctx, func() error {
+		var err error
+		set, err = h.buildFavicons(ctx, key, src, background)
+		return err
+	})
+	switch {
+	case errors.Is(err, workpool.ErrSaturated):
+		w.Header().Set("Retry-After", "1")
+		respondError(w, http.StatusTooManyRequests, apierror.TransformsBusy, "Too many transformations in progress, retry shortly")
+		return
+	case errors.Is(err, errRenderInput):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to build favicons")
+		return
+	}
+	respondJSON(w, http.StatusCreated, set)
+}
+
+// faviconBackground returns the background of a favicon set's
+// transform, and whether transform is one
+func faviconBackground(transform string) (string, bool) {
+	return strings.CutPrefix(transform, faviconsTransform)
+}
+
+// buildFavicons renders the favicon set of src, the head of key, on
+// background and stores it under derived/, the set's JSON last
+func (h *MediaHandler) buildFavicons(ctx context.Context, key string, src *s3.HeadObjectOutput, background string) (*FaviconSet, error) {
+	obj, err := h.r2Client.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(obj.Body, maxImageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	etag := aws.ToString(src.ETag)
+	transform := faviconsTransform + background
+	meta := map[string]string{sourceETagMeta: etag}
+	store := func(file, contentType string, body []byte) (string, error) {
+		objectKey := derivedKey(key, etag, transform+"_"+file)
+		if err := h.r2Client.PutObject(ctx, objectKey, bytes.NewReader(body), contentType, meta); err != nil {
+			return "", err
+		}
+		return h.publicURL(objectKey), nil
+	}
+
+	set := &FaviconSet{Key: key}
+	var links []string
+	for _, s := range faviconSpecs {
+		icon, err := h.renderIcon(data, s, background)
+		if err != nil {
+			return nil, err
+		}
+		url, err := store(s.file, "image/png", icon)
+		if err != nil {
+			return nil, err
+		}
+		sizes := fmt.Sprintf("%dx%d", s.size, s.size)
+		fi := FaviconIcon{Src: url, Sizes: sizes, Type: "image/png", Purpose: s.purpose}
+		set.Icons = append(set.Icons, fi)
+		if s.purpose != "" {
+			set.Manifest.Icons = append(set.Manifest.Icons, fi)
+		}
+		switch s.rel {
+		case "icon":
+			links = append(links, fmt.Sprintf(`<link rel="icon" type="image/png" sizes="%s" href="%s">`, sizes, html.EscapeString(url)))
+		case "apple-touch-icon":
+			links = append(links, fmt.Sprintf(`<link rel="apple-touch-icon" sizes="%s" href="%s">`, sizes, html.EscapeString(url)))
+		}
+	}
+
+	var images [][]byte
+	for _, size := range faviconICOSizes {
+		icon, err := h.renderIcon(data, faviconSpec{size: size, content: 1}, background)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, icon)
+	}
+	if set.ICO, err = store("favicon.ico", "image/x-icon", encodeICO(faviconICOSizes, images)); err != nil {
+		return nil, err
+	}
+	// Browsers that don't understand sizes take the first icon
+	set.HTML = strings.Join(append([]string{fmt.Sprintf(`<link rel="icon" href="%s" sizes="48x48">`, html.EscapeString(set.ICO))}, links...), "\n")
+
+	body, err := json.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.r2Client.PutObject(ctx, derivedKey(key, etag, transform), bytes.NewReader(body), "application/json", meta); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// renderIcon draws the image src scaled into s, centered on a square of
+// its size. Images smaller than the square aren't enlarged.
+func (h *MediaHandler) renderIcon(src []byte, s faviconSpec, background string) ([]byte, error) {
+	box := max(1, int(float64(s.size)*s.content+0.5))
+	scaled, _, err := h.images.Transform(src, imaging.Options{Width: box, Height: box, Format: "png"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errRenderInput, err)
+	}
+	img, err := png.Decode(bytes.NewReader(scaled))
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, s.size, s.size))
+	if s.opaque {
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(rgba(background)), image.Point{}, draw.Src)
+	}
+	b := img.Bounds()
+	at := image.Pt((s.size-b.Dx())/2, (s.size-b.Dy())/2)
+	draw.Draw(canvas, image.Rectangle{at, at.Add(b.Size())}, img, b.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeICO packs PNG images of the given sizes into an ICO file, which
+// every browser since Internet Explorer 11 reads
+func encodeICO(sizes []int, pngs [][]byte) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, [3]uint16{0, 1, uint16(len(pngs))})
+	offset := 6 + 16*len(pngs)
+	for i, p := range pngs {
+		// 0 stands for 256
+		side := uint8(sizes[i])
+		binary.Write(&buf, le, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{side, side, 0, 0, 1, 32, uint32(len(p)), uint32(offset)})
+		offset += len(p)
+	}
+	for _, p := range pngs {
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
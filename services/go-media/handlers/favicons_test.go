@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRenderIcon(t *testing.T) {
+	// A red 100×50 master
+	master := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for i := 0; i < len(master.Pix); i += 4 {
+		copy(master.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	var src bytes.Buffer
+	png.Encode(&src, master)
+	h := &MediaHandler{images: mustEngine(t, "go")}
+
+	for _, tt := range []struct {
+		spec          faviconSpec
+		corner, width color.NRGBA
+	}{
+		// Transparent around the image, which fills the width
+		{faviconSpec{size: 32, content: 1}, color.NRGBA{}, color.NRGBA{255, 0, 0, 255}},
+		// The background around a smaller image
+		{faviconSpec{size: 64, content: maskableContent, opaque: true}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 0, 255, 255}},
+	} {
+		data, err := h.renderIcon(src.Bytes(), tt.spec, "0000ff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := tt.spec.size
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("bounds = %v", b)
+		}
+		model := color.NRGBAModel
+		if got := model.Convert(img.At(0, 0)); got != tt.corner {
+			t.Errorf("%d: corner = %v, want %v", size, got, tt.corner)
+		}
+		if got := model.Convert(img.At(0, size/2)); got != tt.width {
+			t.Errorf("%d: left edge = %v, want %v", size, got, tt.width)
+		}
+		if got := model.Convert(img.At(size/2, size/2)); got != (color.NRGBA{255, 0, 0, 255}) {
+			t.Errorf("%d: center = %v", size, got)
+		}
+	}
+}
+
+func TestEncodeICO(t *testing.T) {
+	ico := encodeICO([]int{16, 256}, [][]byte{[]byte("first"), []byte("second")})
+	le := binary.LittleEndian
+	if le.Uint16(ico[2:]) != 1 || le.Uint16(ico[4:]) != 2 {
+		t.Fatalf("header = %v", ico[:6])
+	}
+	for i, want := range []struct {
+		side byte
+		data string
+	}{{16, "first"}, {0, "second"}} {
+		entry := ico[6+16*i:]
+		size, offset := le.Uint32(entry[8:]), le.Uint32(entry[12:])
+		if entry[0] != want.side || entry[1] != want.side || string(ico[offset:offset+size]) != want.data {
+			t.Errorf("entry %d = %v", i, entry[:16])
+		}
+	}
+}
+
+func TestFaviconsBadRequest(t *testing.T) {
+	h := NewMediaHandler(nil, "secret", WithImageEngine(mustEngine(t, "go")))
+	for _, tt := range []struct {
+		key, query string
+		status     int
+	}{
+		{"brand/logo.png", "background=white", http.StatusBadRequest},
+		{"derived/brand/logo.png", "", http.StatusForbidden},
+		{"brand/logo.pdf", "", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("POST", "/v1/media/favicons/"+tt.key+"?"+tt.query, nil)
+		r = mux.SetURLVars(r, map[string]string{"path": tt.key})
+		w := httptest.NewRecorder()
+		h.Favicons(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s?%s: status = %d, want %d", tt.key, tt.query, w.Code, tt.status)
+		}
+	}
+
+	if bg, ok := faviconBackground(faviconsTransform + "1f2937"); !ok || bg != "1f2937" {
+		t.Errorf("faviconBackground() = %q, %v", bg, ok)
+	}
+}
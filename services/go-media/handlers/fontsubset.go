@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/fonts"
+)
+
+// maxFontSize bounds the fonts subset on request; larger ones are served
+// as uploaded
+const maxFontSize = 32 << 20
+
+// maxSubsetText bounds the characters ?text= asks for
+const maxSubsetText = 1000
+
+const (
+	// subsetTransform starts the transform of a font subset by named
+	// ranges, followed by their names joined with "+"
+	subsetTransform = "subset-"
+	// textTransform starts the transform of a font subset by text,
+	// followed by a hash of its characters
+	textTransform = "text-"
+)
+
+// wantsFontSubset returns the font subset a request for key asks for:
+// ?subset= with named ranges such as latin,cyrillic, or ?text= with the
+// characters to keep. It fails for unknown ranges and for both at once,
+// and with errImageSignature for an unsigned URL when signatures are
+// required, since each new ?text= builds and stores another subset.
+func (h *MediaHandler) wantsFontSubset(r *http.Request, key string) (derivation, bool, error) {
+	d, ok, err := fontSubsetQuery(r.URL.Query(), key)
+	if ok && h.requireImageSignature && !hmac.Equal([]byte(r.URL.Query().Get("s")), []byte(h.fontSignature(key, d))) {
+		return derivation{}, false, errImageSignature
+	}
+	return d, ok, err
+}
+
+// fontSubsetQuery reads the font subset q asks for of key
+func fontSubsetQuery(q url.Values, key string) (derivation, bool, error) {
+	subset, text := q.Get("subset"), q.Get("text")
+	if !fonts.Source(key) || subset == "" && text == "" {
+		return derivation{}, false, nil
+	}
+	if subset != "" && text != "" {
+		return derivation{}, false, errors.New("subset and text can't be combined")
+	}
+
+	if text != "" {
+		if utf8.RuneCountInString(text) > maxSubsetText {
+			return derivation{}, false, fmt.Errorf("text is limited to %d characters", maxSubsetText)
+		}
+		return textSubset(text), true, nil
+	}
+	names := strings.Split(subset, ",")
+	for _, name := range names {
+		if fonts.Subsets[name] == nil {
+			return derivation{}, false, fmt.Errorf("unknown subset %q; subsets are %s", name, strings.Join(subsetNames(), ", "))
+		}
+	}
+	return namedSubset(names), true, nil
+}
+
+// namedSubset is the derivation keeping the named ranges
+func namedSubset(names []string) derivation {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	ranges := make([]*unicode.RangeTable, len(names))
+	for i, name := range names {
+		ranges[i] = fonts.Subsets[name]
+	}
+	return fontSubset(subsetTransform+strings.Join(names, "+"), func(r rune) bool { return unicode.In(r, ranges...) })
+}
+
+// textSubset is the derivation keeping the characters of text. Its
+// transform hashes them, so it can't be rebuilt from its key; it is
+// built again when next asked for.
+func textSubset(text string) derivation {
+	chars := make(map[rune]bool)
+	for _, r := range text {
+		chars[r] = true
+	}
+	sorted := make([]rune, 0, len(chars))
+	for r := range chars {
+		sorted = append(sorted, r)
+	}
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(string(sorted)))
+	return fontSubset(textTransform+hex.EncodeToString(sum[:8]), func(r rune) bool { return chars[r] })
+}
+
+func fontSubset(transform string, keep func(rune) bool) derivation {
+	return derivation{transform: transform, maxSize: maxFontSize, build: func(src []byte) ([]byte, string, error) {
+		out, err := fonts.Subset(src, keep)
+		return out, "font/woff2", err
+	}}
+}
+
+// fontSignature signs the subset d of key. It covers the transform, so
+// the same ranges or characters in any order share a signature.
+func (h *MediaHandler) fontSignature(key string, d derivation) string {
+	return h.tokenMAC("font-subset", key+"?"+d.transform)
+}
+
+// signFontURL answers a signing request for a font subset
+func (h *MediaHandler) signFontURL(w http.ResponseWriter, req ImageURLRequest) {
+	q := url.Values{}
+	if req.Subset != "" {
+		q.Set("subset", req.Subset)
+	}
+	if req.Text != "" {
+		q.Set("text", req.Text)
+	}
+	d, ok, err := fontSubsetQuery(q, req.Path)
+	if err == nil && !ok {
+		err = errors.New("set subset or text")
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	q.Set("s", h.fontSignature(req.Path, d))
+	respondJSON(w, http.StatusOK, ImageURLResponse{URL: h.publicURL(req.Path) + "?" + q.Encode()})
+}
+
+// fontSubsetFor returns the derivation of a named subset transform
+func fontSubsetFor(transform string) (derivation, bool) {
+	rest, ok := strings.CutPrefix(transform, subsetTransform)
+	if !ok {
+		return derivation{}, false
+	}
+	names := strings.Split(rest, "+")
+	for _, name := range names {
+		if fonts.Subsets[name] == nil {
+			return derivation{}, false
+		}
+	}
+	return namedSubset(names), true
+}
+
+// subsetNames returns the named ranges, sorted
+func subsetNames() []string {
+	names := make([]string, 0, len(fonts.Subsets))
+	for name := range fonts.Subsets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
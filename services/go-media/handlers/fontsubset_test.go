@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWantsFontSubset(t *testing.T) {
+	tests := []struct {
+		target, key string
+		transform   string // "" when no subset is wanted
+		wantErr     bool
+	}{
+		{"/v1/media/fonts/Inter.ttf?subset=latin", "fonts/Inter.ttf", "subset-latin", false},
+		{"/v1/media/fonts/Inter.woff2?subset=latin,cyrillic,latin", "fonts/Inter.woff2", "subset-cyrillic+latin", false},
+		{"/v1/media/fonts/Inter.ttf?text=Hello", "fonts/Inter.ttf", "text-", false},
+		{"/v1/media/fonts/Inter.ttf", "fonts/Inter.ttf", "", false},
+		{"/v1/media/fonts/Inter.woff?subset=latin", "fonts/Inter.woff", "", false},
+		{"/v1/media/photo.png?text=Hi", "photo.png", "", false},
+		{"/v1/media/fonts/Inter.ttf?subset=klingon", "fonts/Inter.ttf", "", true},
+		{"/v1/media/fonts/Inter.ttf?subset=latin&text=Hi", "fonts/Inter.ttf", "", true},
+		{"/v1/media/fonts/Inter.ttf?text=" + strings.Repeat("a", maxSubsetText+1), "fonts/Inter.ttf", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		d, ok, err := (&MediaHandler{}).wantsFontSubset(r, tt.key)
+		if (err != nil) != tt.wantErr || ok != (tt.transform != "") || !strings.HasPrefix(d.transform, tt.transform) {
+			t.Errorf("wantsFontSubset(%s) = %q, %v, %v", tt.target, d.transform, ok, err)
+		}
+	}
+
+	// The same characters in any order and number are the same subset
+	a, b := textSubset("Hello"), textSubset("oleH")
+	if a.transform != b.transform || a.transform == textSubset("Help").transform {
+		t.Errorf("text transforms %q, %q", a.transform, b.transform)
+	}
+}
+
+func TestSignedFontSubsets(t *testing.T) {
+	h := &MediaHandler{signingSecret: "test-secret", publicBase: publicBaseURL, requireImageSignature: true}
+	wants := func(target string) error {
+		_, _, err := h.wantsFontSubset(httptest.NewRequest(http.MethodGet, target, nil), "fonts/Inter.ttf")
+		return err
+	}
+	sign := func(body string) (string, int) {
+		w := httptest.NewRecorder()
+		h.SignImageURL(w, httptest.NewRequest(http.MethodPost, "/v1/media/images/sign", strings.NewReader(body)))
+		var resp ImageURLResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.URL, w.Code
+	}
+
+	signed, status := sign(`{"path": "fonts/Inter.ttf", "text": "Hello"}`)
+	u, err := url.Parse(signed)
+	if status != http.StatusOK || err != nil || u.Path != "/fonts/Inter.ttf" {
+		t.Fatalf("sign text subset = %d %s", status, signed)
+	}
+	if err := wants("/fonts/Inter.ttf?" + u.RawQuery); err != nil {
+		t.Errorf("signed text subset refused: %v", err)
+	}
+	// The signature covers the characters, not how they are spelled out
+	s := u.Query().Get("s")
+	if err := wants("/fonts/Inter.ttf?text=oleH&s=" + s); err != nil {
+		t.Errorf("same characters refused: %v", err)
+	}
+	for _, query := range []string{"text=Hello", "text=Help&s=" + s, "subset=latin&s=" + s} {
+		if err := wants("/fonts/Inter.ttf?" + query); !errors.Is(err, errImageSignature) {
+			t.Errorf("%s: err = %v, want errImageSignature", query, err)
+		}
+	}
+
+	if signed, status = sign(`{"path": "fonts/Inter.ttf", "subset": "latin,cyrillic"}`); status != http.StatusOK {
+		t.Fatalf("sign named subset = %d", status)
+	}
+	u, _ = url.Parse(signed)
+	if err := wants("/fonts/Inter.ttf?subset=cyrillic,latin&s=" + u.Query().Get("s")); err != nil {
+		t.Errorf("signed named subset refused: %v", err)
+	}
+	// Requests for the font as uploaded need no signature
+	if err := wants("/fonts/Inter.ttf"); err != nil {
+		t.Errorf("plain request refused: %v", err)
+	}
+
+	for _, body := range []string{`{"path": "fonts/Inter.ttf"}`, `{"path": "fonts/Inter.ttf", "subset": "klingon"}`} {
+		if _, status := sign(body); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, status)
+		}
+	}
+}
+
+func TestFontSubsetBuild(t *testing.T) {
+	ttf, err := os.ReadFile("../fonts/DejaVuSans.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, contentType, err := textSubset("Hello").build(ttf)
+	if err != nil || contentType != "font/woff2" || !bytes.HasPrefix(out, []byte("wOF2")) || len(out) > len(ttf)/50 {
+		t.Errorf("build() = %d bytes of %s, %v", len(out), contentType, err)
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+)
+
+// generatedPrefix caches images the service renders from request
+// parameters rather than from one asset, such as social cards, at
+// generated/<kind>/<hash of the parameters>. The same parameters always
+// find the same object, so each image is rendered once; the lifecycle
+// job expires them after config.LifecycleConfig.GeneratedDays.
+const generatedPrefix = "generated/"
+
+// generatedCacheControl keeps rendered images for a day. Their URLs can
+// name assets that change, so they aren't immutable.
+const generatedCacheControl = "public, max-age=86400"
+
+// WithReadOnly makes the handler a read-only replica's: it doesn't store
+// the images it renders on request
+func WithReadOnly(readOnly bool) Option {
+	return func(h *MediaHandler) {
+		h.readOnly = readOnly
+	}
+}
+
+// errRenderInput is a rendering failure caused by the request, such as an
+// image that can't be decoded
+var errRenderInput = errors.New("can't render the image")
+
+// generatedKey returns where the image of kind rendered from params is
+// cached
+func generatedKey(kind, ext string, params ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(params, "\x00")))
+	return generatedPrefix + kind + "/" + hex.EncodeToString(sum[:16]) + ext
+}
+
+// serveGenerated serves the image cached at key, first rendering it with
+// render, in the transformation pool, and storing it if it is missing. A
+// failure to store it is reported, and the image served anyway. Read-only
+// replicas render missing images without storing them.
+func (h *MediaHandler) serveGenerated(w http.ResponseWriter, r *http.Request, key, contentType string, render func() ([]byte, error)) {
+	ctx := r.Context()
+	w.Header().Set("Cache-Control", generatedCacheControl)
+
+	if obj, err := h.r2Client.GetObject(ctx, key); err == nil {
+		defer obj.Body.Close()
+		if h.checkETag(w, r, obj.ETag) {
+			return
+		}
+		h.setObjectHeaders(w, obj.ETag, obj.ContentType, obj.ContentLength, obj.LastModified)
+		io.Copy(w, obj.Body)
+		return
+	}
+
+	var data []byte
+	err := h.transforms.Do(ctx, func() error {
+		var err error
+		data, err = render()
+		return err
+	})
+	switch {
+	case errors.Is(err, workpool.ErrSaturated):
+		w.Header().Set("Retry-After", "1")
+		respondError(w, http.StatusTooManyRequests, apierror.TransformsBusy, "Too many transformations in progress, retry shortly")
+		return
+	case errors.Is(err, errRenderInput):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to render image")
+		return
+	}
+	if !h.readOnly {
+		if err := h.r2Client.PutObject(ctx, key, bytes.NewReader(data), contentType, nil); err != nil {
+			h.reporter.CaptureError(r, err)
+		}
+	}
+
+	// R2's ETag for an object written in one part is its MD5
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	size := int64(len(data))
+	h.setObjectHeaders(w, &etag, &contentType, &size, nil)
+	w.Write(data)
+}
@@ -1,50 +1,112 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/WomB0ComB0/cdn/services/go-media/storage"
 )
 
+// storageCheckTTL bounds how often readiness probes hit R2
+const storageCheckTTL = 5 * time.Second
+
 type HealthStatus struct {
-	Status      string            `json:"status"`
-	Timestamp   time.Time         `json:"timestamp"`
-	Version     string            `json:"version"`
+	Status       string            `json:"status"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Version      string            `json:"version"`
 	Dependencies map[string]string `json:"dependencies"`
 }
 
-// HealthCheckDetailed performs a deep health check
-func HealthCheckDetailed(r2Client *storage.R2Client) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		status := HealthStatus{
-			Status:       "healthy",
-			Timestamp:    time.Now(),
-			Version:      getEnv("APP_VERSION", "1.0.0"),
-			Dependencies: make(map[string]string),
-		}
-
-		// Check R2 connectivity
-		ctx := r.Context()
-		_, err := r2Client.ListObjects(ctx, "", 1)
-		if err != nil {
-			status.Status = "unhealthy"
-			status.Dependencies["r2"] = "unhealthy: " + err.Error()
-			w.WriteHeader(http.StatusServiceUnavailable)
-		} else {
-			status.Dependencies["r2"] = "healthy"
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(status)
+// Probes serves liveness and readiness endpoints. The service is ready
+// once it has been marked warm, R2 is reachable and it is not draining.
+type Probes struct {
+	version      string
+	checkStorage func(ctx context.Context) error
+	warm         atomic.Bool
+	draining     atomic.Bool
+
+	mu         sync.Mutex
+	storageErr error
+	checkedAt  time.Time
+}
+
+// NewProbes creates probes using checkStorage (typically
+// R2Client.HeadBucket) to verify the bucket is reachable
+func NewProbes(version string, checkStorage func(ctx context.Context) error) *Probes {
+	return &Probes{version: version, checkStorage: checkStorage}
+}
+
+// MarkWarm reports that startup work (index load, cache warm-up) is done
+func (p *Probes) MarkWarm() {
+	p.warm.Store(true)
+}
+
+// SetDraining makes readiness fail so load balancers stop routing new
+// requests while in-flight ones finish
+func (p *Probes) SetDraining(draining bool) {
+	p.draining.Store(draining)
+}
+
+// Draining reports whether the service is shutting down
+func (p *Probes) Draining() bool {
+	return p.draining.Load()
+}
+
+// Liveness reports that the process is up and serving HTTP. It never
+// checks dependencies, so storage outages don't trigger restarts.
+func (p *Probes) Liveness(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// Readiness reports whether the service should receive traffic
+func (p *Probes) Readiness(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{
+		Status:       "ready",
+		Timestamp:    time.Now(),
+		Version:      p.version,
+		Dependencies: make(map[string]string),
+	}
+
+	if err := p.storage(r.Context()); err != nil {
+		status.Status = "not ready"
+		status.Dependencies["r2"] = "unhealthy: " + err.Error()
+	} else {
+		status.Dependencies["r2"] = "healthy"
 	}
+
+	if p.warm.Load() {
+		status.Dependencies["cache"] = "warm"
+	} else {
+		status.Status = "not ready"
+		status.Dependencies["cache"] = "warming"
+	}
+
+	if p.draining.Load() {
+		status.Status = "draining"
+	}
+
+	code := http.StatusOK
+	if status.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	respondJSON(w, code, status)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// storage returns the result of the last R2 check, refreshing it when
+// older than storageCheckTTL
+func (p *Probes) storage(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checkedAt.IsZero() && time.Since(p.checkedAt) < storageCheckTTL {
+		return p.storageErr
 	}
-	return defaultValue
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	p.storageErr = p.checkStorage(ctx)
+	p.checkedAt = time.Now()
+	return p.storageErr
 }
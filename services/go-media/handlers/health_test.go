@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		storageErr error
+		warm       bool
+		draining   bool
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "ready", warm: true, wantCode: http.StatusOK, wantStatus: "ready"},
+		{name: "storage down", storageErr: errors.New("timeout"), warm: true, wantCode: http.StatusServiceUnavailable, wantStatus: "not ready"},
+		{name: "warming", wantCode: http.StatusServiceUnavailable, wantStatus: "not ready"},
+		{name: "draining", warm: true, draining: true, wantCode: http.StatusServiceUnavailable, wantStatus: "draining"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes := NewProbes("test", func(ctx context.Context) error { return tt.storageErr })
+			if tt.warm {
+				probes.MarkWarm()
+			}
+			probes.SetDraining(tt.draining)
+
+			w := httptest.NewRecorder()
+			probes.Readiness(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			var status HealthStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatalf("Failed to parse JSON: %v", err)
+			}
+			if w.Code != tt.wantCode || status.Status != tt.wantStatus {
+				t.Errorf("Readiness() = %d %q, want %d %q", w.Code, status.Status, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadinessCachesStorageCheck(t *testing.T) {
+	calls := 0
+	probes := NewProbes("test", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	probes.MarkWarm()
+
+	for i := 0; i < 3; i++ {
+		probes.Readiness(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+	}
+	if calls != 1 {
+		t.Errorf("storage checked %d times, want 1", calls)
+	}
+}
+
+func TestLivenessIgnoresDependencies(t *testing.T) {
+	probes := NewProbes("test", func(ctx context.Context) error { return errors.New("down") })
+	probes.SetDraining(true)
+
+	w := httptest.NewRecorder()
+	probes.Liveness(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Liveness() = %d, want 200", w.Code)
+	}
+}
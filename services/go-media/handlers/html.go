@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+// defaultHTMLPolicy puts HTML in a unique origin, with scripts, forms,
+// popups and plugins off
+const defaultHTMLPolicy = "sandbox"
+
+// htmlExts are the uploads allowed only when HTML uploads are enabled
+var htmlExts = map[string]bool{".html": true, ".htm": true}
+
+// WithHTML accepts HTML uploads when cfg.Uploads is set and serves HTML
+// assets with cfg.CSP, from cfg.Origin if it is set
+func WithHTML(cfg config.HTMLConfig) Option {
+	return func(h *MediaHandler) {
+		h.html = cfg
+	}
+}
+
+// isHTML reports whether a Content-Type is one browsers render as a page
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// htmlKey reports whether key is served as HTML, by its extension
+func htmlKey(key string) bool {
+	ext := strings.ToLower(path.Ext(key))
+	return htmlExts[ext] || ext == ".xhtml"
+}
+
+// sandboxHTML sets the HTML policy on a response about to serve key, if
+// its Content-Type is HTML. The policy's sandbox denies the page the
+// origin it's served from, so uploaded HTML can't read that origin's
+// cookies or storage or script its other pages, and nosniff keeps other
+// content from being rendered as HTML. Deploys are sites the admin
+// published and are served as they are.
+func (h *MediaHandler) sandboxHTML(w http.ResponseWriter, key string) {
+	if !isHTML(w.Header().Get("Content-Type")) || strings.HasPrefix(key, deployPrefix) {
+		return
+	}
+	policy := h.html.CSP
+	if policy == "" {
+		policy = defaultHTMLPolicy
+	}
+	w.Header().Set("Content-Security-Policy", policy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// redirectHTML sends a request for an HTML asset arriving on another
+// host to the configured HTML origin, and reports whether it did. The
+// asset is HTML by its key's extension or by contentType, the type it is
+// served with where that is known.
+func (h *MediaHandler) redirectHTML(w http.ResponseWriter, r *http.Request, key, contentType string) bool {
+	if h.html.Origin == "" || !(htmlKey(key) || isHTML(contentType)) || strings.HasPrefix(key, deployPrefix) {
+		return false
+	}
+	origin, err := url.Parse(h.html.Origin)
+	if err != nil || strings.EqualFold(r.Host, origin.Host) {
+		return false
+	}
+	http.Redirect(w, r, strings.TrimSuffix(h.html.Origin, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	return true
+}
+
+// htmlURL is where clients fetch the public HTML asset at key: the HTML
+// origin when one is configured, rather than the CDN
+func (h *MediaHandler) htmlURL(ctx context.Context, key string) string {
+	if h.html.Origin == "" {
+		return h.assetURL(ctx, key, false)
+	}
+	return strings.TrimSuffix(h.html.Origin, "/") + "/v1/media/assets/" + key
+}
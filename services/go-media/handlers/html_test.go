@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+func TestSandboxHTML(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+
+	tests := []struct {
+		key, contentType, want string
+	}{
+		{"assets/3f9a1c27.html", "text/html; charset=utf-8", "sandbox"},
+		// The type decides, not the extension
+		{"assets/notes.txt", "text/html", "sandbox"},
+		{"assets/page.xhtml", "application/xhtml+xml", "sandbox"},
+		{"assets/notes.txt", "text/plain", ""},
+		{"deploys/3/index.html", "text/html", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", tt.contentType)
+		h.sandboxHTML(w, tt.key)
+		if got := w.Header().Get("Content-Security-Policy"); got != tt.want {
+			t.Errorf("%s (%s): Content-Security-Policy = %q, want %q", tt.key, tt.contentType, got, tt.want)
+		}
+		if tt.want != "" && w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: X-Content-Type-Options not set", tt.key)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "text/html")
+	NewMediaHandler(nil, "secret", WithHTML(config.HTMLConfig{CSP: "sandbox allow-scripts"})).sandboxHTML(w, "assets/demo.html")
+	if got := w.Header().Get("Content-Security-Policy"); got != "sandbox allow-scripts" {
+		t.Errorf("configured policy = %q", got)
+	}
+}
+
+func TestRedirectHTML(t *testing.T) {
+	h := NewMediaHandler(nil, "secret", WithHTML(config.HTMLConfig{Uploads: true, Origin: "https://usercontent.example.com"}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "https://cdn.mikeodnis.dev/v1/media/private/assets/demo.html?exp=1&sig=x", nil)
+	if !h.redirectHTML(w, r, "assets/demo.html", "") {
+		t.Fatal("HTML on the main host wasn't redirected")
+	}
+	if got := w.Header().Get("Location"); got != "https://usercontent.example.com/v1/media/private/assets/demo.html?exp=1&sig=x" {
+		t.Errorf("Location = %q", got)
+	}
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("status = %d", w.Code)
+	}
+
+	for _, tt := range []struct{ url, key string }{
+		{"https://usercontent.example.com/v1/media/assets/assets/demo.html", "assets/demo.html"},
+		{"https://cdn.mikeodnis.dev/v1/media/assets/assets/photo.png", "assets/photo.png"},
+		{"https://cdn.mikeodnis.dev/deploys/3/index.html", "deploys/3/index.html"},
+	} {
+		if h.redirectHTML(httptest.NewRecorder(), httptest.NewRequest("GET", tt.url, nil), tt.key, "") {
+			t.Errorf("%s redirected", tt.url)
+		}
+	}
+
+	if got := h.htmlURL(context.Background(), "assets/demo.html"); got != "https://usercontent.example.com/v1/media/assets/assets/demo.html" {
+		t.Errorf("htmlURL() = %q", got)
+	}
+}
+
+func TestUploadKeyHTML(t *testing.T) {
+	if _, err := NewMediaHandler(nil, "secret").uploadKey(KeyHash, "demo.html", []byte("<p>hi</p>")); err != ErrFileTypeNotAllowed {
+		t.Errorf("HTML upload without the policy: error = %v", err)
+	}
+	h := NewMediaHandler(nil, "secret", WithHTML(config.HTMLConfig{Uploads: true, Origin: "https://usercontent.example.com"}))
+	for _, name := range []string{"demo.html", "DEMO.HTM"} {
+		if _, err := h.uploadKey(KeyHash, name, []byte("<p>hi</p>")); err != nil {
+			t.Errorf("uploadKey(%s) error = %v", name, err)
+		}
+	}
+	if _, err := chunkedUploadKey(KeyUUID, "demo.html", true); err != nil {
+		t.Errorf("chunkedUploadKey() error = %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/fonts"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxImageSize bounds the images transformed on request; larger ones
+// are served as uploaded
+const maxImageSize = 32 << 20
+
+// focalPointMeta holds an image's focal point, as "x,y" fractions of its
+// width and height, which cover crops keep in view
+const focalPointMeta = "focal-point"
+
+// WithImageEngine builds the image variants asked for with ?w=, ?h=,
+// ?fit=, ?format= and ?q= with e
+func WithImageEngine(e imaging.Engine) Option {
+	return func(h *MediaHandler) {
+		h.images = e
+	}
+}
+
+// WithImageLimits bounds the image variants requests may ask for
+func WithImageLimits(l imaging.Limits) Option {
+	return func(h *MediaHandler) {
+		h.imageLimits = l
+	}
+}
+
+// WithImageSignatures requires image variant and font subset URLs to
+// carry a signature (?s=) from SignImageURL, so only URLs the service
+// handed out build variants
+func WithImageSignatures(require bool) Option {
+	return func(h *MediaHandler) {
+		h.requireImageSignature = require
+	}
+}
+
+var errImageSignature = errors.New("variant URLs must be signed; get one from /v1/media/images/sign")
+
+// imageSignature signs the variant o of key. It covers the normalized
+// options, so equivalent queries share a signature.
+func (h *MediaHandler) imageSignature(key string, o imaging.Options) string {
+	return h.tokenMAC("image-variant", key+"?"+o.Fingerprint())
+}
+
+// ImageURL returns the signed public URL of the variant o of key
+func (h *MediaHandler) ImageURL(key string, o imaging.Options) string {
+	q := o.Query()
+	q.Set("s", h.imageSignature(key, o))
+	return h.publicURL(key) + "?" + q.Encode()
+}
+
+// wantsImage returns the image variant a request for key asks for. It
+// fails with an imaging error for options that are invalid or that the
+// engine can't produce, or that are outside the limits, and with
+// errImageSignature for an unsigned URL when signatures are required.
+//
+// Types browsers can't display, such as HEIC, are converted even when no
+// options are given, to WebP for clients that accept it and JPEG for the
+// rest, unless the request names a format.
+func (h *MediaHandler) wantsImage(r *http.Request, key string) (derivation, bool, error) {
+	if h.images == nil || !h.images.Reads(key) {
+		return derivation{}, false, nil
+	}
+	o, err := imaging.ParseQuery(r.URL.Query())
+	if err != nil {
+		return derivation{}, false, err
+	}
+	if !o.IsZero() && h.requireImageSignature && !hmac.Equal([]byte(r.URL.Query().Get("s")), []byte(h.imageSignature(key, o))) {
+		return derivation{}, false, errImageSignature
+	}
+	if o.Format == "" && imaging.Transcoded(key) {
+		if o.Format = h.transcodeFormat(r, o); o.Format == "" {
+			// The limits allow no format to convert to
+			return derivation{}, false, nil
+		}
+	}
+	if o.IsZero() {
+		return derivation{}, false, nil
+	}
+	if err := h.checkImage(o); err != nil {
+		return derivation{}, false, err
+	}
+	d := h.imageDerivation(o)
+	if o.Fit == imaging.FitCover && !o.Focus.Set {
+		// Crop around the focal point stored with the image, if any
+		d.forSource = func(metadata map[string]string) derivation {
+			focus, err := imaging.ParseFocus(metadata[focalPointMeta])
+			if err != nil {
+				return d
+			}
+			withFocus := o
+			withFocus.Focus = focus
+			return h.imageDerivation(withFocus)
+		}
+	}
+	return d, true, nil
+}
+
+// transcodeFormat picks the format to convert a type browsers can't
+// display to, or "" when the limits or engine allow neither
+func (h *MediaHandler) transcodeFormat(r *http.Request, o imaging.Options) string {
+	candidates := []string{"jpeg"}
+	if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+		candidates = []string{"webp", "jpeg"}
+	}
+	for _, format := range candidates {
+		o.Format = format
+		if h.checkImage(o) == nil {
+			return format
+		}
+	}
+	return ""
+}
+
+// checkImage checks that o is within the limits and that the engine can
+// produce it
+func (h *MediaHandler) checkImage(o imaging.Options) error {
+	if err := h.imageLimits.Allow(o); err != nil {
+		return err
+	}
+	if !h.images.Writes(o.Format) {
+		return fmt.Errorf("%w: %s output needs the vips image engine", imaging.ErrUnsupportedFormat, o.Format)
+	}
+	return nil
+}
+
+// imageDerivation is the derivation applying o
+func (h *MediaHandler) imageDerivation(o imaging.Options) derivation {
+	return derivation{transform: o.Fingerprint(), maxSize: maxImageSize, build: func(src []byte) ([]byte, string, error) {
+		return h.images.Transform(src, o)
+	}}
+}
+
+// ImageURLRequest asks for the URL of an image variant, or with Subset
+// or Text of a font subset
+type ImageURLRequest struct {
+	Path    string   `json:"path" validate:"required"`
+	Width   int      `json:"w,omitempty"`
+	Height  int      `json:"h,omitempty"`
+	Fit     string   `json:"fit,omitempty"`
+	FocusX  *float64 `json:"fp-x,omitempty"`
+	FocusY  *float64 `json:"fp-y,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Quality int      `json:"q,omitempty"`
+	Subset  string   `json:"subset,omitempty"`
+	Text    string   `json:"text,omitempty"`
+}
+
+// ImageURLResponse is a signed image variant URL
+type ImageURLResponse struct {
+	URL string `json:"url"`
+}
+
+// SignImageURL returns the signed URL of an image variant, checked
+// against the limits and the engine, or of a font subset
+func (h *MediaHandler) SignImageURL(w http.ResponseWriter, r *http.Request) {
+	var req ImageURLRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if fonts.Source(req.Path) && ValidateKey(req.Path) == nil {
+		h.signFontURL(w, req)
+		return
+	}
+	if h.images == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Image variants are disabled")
+		return
+	}
+	if !h.images.Reads(req.Path) || ValidateKey(req.Path) != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "path must be an image")
+		return
+	}
+
+	// Read the options as a request would, so the URL passes the same checks
+	q := url.Values{"fit": {req.Fit}, "format": {req.Format}}
+	for name, n := range map[string]int{"w": req.Width, "h": req.Height, "q": req.Quality} {
+		if n != 0 {
+			q.Set(name, strconv.Itoa(n))
+		}
+	}
+	for name, f := range map[string]*float64{"fp-x": req.FocusX, "fp-y": req.FocusY} {
+		if f != nil {
+			q.Set(name, strconv.FormatFloat(*f, 'f', -1, 64))
+		}
+	}
+	o, err := imaging.ParseQuery(q)
+	if err == nil && o.IsZero() {
+		err = fmt.Errorf("%w: set w, h, format or q", imaging.ErrInvalidOptions)
+	}
+	if err == nil {
+		err = h.checkImage(o)
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, ImageURLResponse{URL: h.ImageURL(req.Path, o)})
+}
+
+// FocalPointRequest sets or, with neither x nor y, clears the focal point
+// of an image
+type FocalPointRequest struct {
+	Path string   `json:"path" validate:"required"`
+	X    *float64 `json:"x" validate:"min=0,max=1"`
+	Y    *float64 `json:"y" validate:"min=0,max=1"`
+}
+
+func (req FocalPointRequest) Check() validation.Errors {
+	switch {
+	case req.X != nil && req.Y == nil:
+		return validation.Errors{{Field: "y", Rule: "required_with", Message: "is required with x"}}
+	case req.X == nil && req.Y != nil:
+		return validation.Errors{{Field: "x", Rule: "required_with", Message: "is required with y"}}
+	}
+	return nil
+}
+
+// FocalPointResponse is an image's focal point, empty when it has none
+type FocalPointResponse struct {
+	Path       string `json:"path"`
+	FocalPoint string `json:"focal_point"`
+}
+
+var errNotImage = errors.New("path must be an image")
+
+// SetFocalPoint stores focus as key's focal point, or clears it when
+// focus is nil. Variants built before keep the old crop until they are
+// regenerated.
+func (h *MediaHandler) SetFocalPoint(ctx context.Context, key string, focus *imaging.Focus) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if !imaging.Source(key) {
+		return errNotImage
+	}
+	head, err := h.r2Client.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(head.Metadata)+1)
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	delete(metadata, focalPointMeta)
+	if focus != nil {
+		metadata[focalPointMeta] = focus.String()
+	}
+	return h.r2Client.ReplaceMetadata(ctx, key, aws.ToString(head.ContentType), metadata, aws.ToString(head.ETag))
+}
+
+// UpdateFocalPoint sets or clears the focal point of an image
+func (h *MediaHandler) UpdateFocalPoint(w http.ResponseWriter, r *http.Request) {
+	var req FocalPointRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var focus *imaging.Focus
+	if req.X != nil {
+		f, err := imaging.ParseFocus(strconv.FormatFloat(*req.X, 'f', -1, 64) + "," + strconv.FormatFloat(*req.Y, 'f', -1, 64))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "x and y must be from 0 to 1")
+			return
+		}
+		focus = &f
+	}
+
+	resp := FocalPointResponse{Path: req.Path}
+	if focus != nil {
+		resp.FocalPoint = focus.String()
+	}
+	audit.Annotate(r, req.Path, map[string]string{"focal_point": resp.FocalPoint})
+
+	switch err := h.SetFocalPoint(r.Context(), req.Path, focus); {
+	case err == nil:
+		respondJSON(w, http.StatusOK, resp)
+	case errors.Is(err, ErrReservedKey):
+		respondError(w, http.StatusForbidden, apierror.ReservedKey, err.Error())
+	case errors.Is(err, ErrInvalidKey), errors.Is(err, errNotImage):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+	case storage.IsNotFound(err):
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+	case storage.IsPreconditionFailed(err):
+		respondError(w, http.StatusConflict, apierror.AssetChanged, "The image changed while its focal point was set; retry")
+	default:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to set focal point")
+	}
+}
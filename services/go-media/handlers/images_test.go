@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+)
+
+func TestImageSignatures(t *testing.T) {
+	engine, err := imaging.New("go", imaging.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &MediaHandler{signingSecret: "test-secret", images: engine, requireImageSignature: true}
+
+	signed, err := url.Parse(h.ImageURL("photos/team.jpg", imaging.Options{Width: 400, Height: 400, Fit: imaging.FitCover}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wants := func(key, query string) error {
+		r := httptest.NewRequest(http.MethodGet, "/v1/media/assets/"+key+"?"+query, nil)
+		_, _, err := h.wantsImage(r, key)
+		return err
+	}
+
+	if err := wants("photos/team.jpg", signed.RawQuery); err != nil {
+		t.Errorf("signed URL refused: %v", err)
+	}
+	// Equivalent options share the signature
+	q := signed.Query()
+	q.Set("q", "82")
+	if err := wants("photos/team.jpg", q.Encode()); err != nil {
+		t.Errorf("signed URL with the default quality spelled out refused: %v", err)
+	}
+
+	q = signed.Query()
+	q.Set("w", "4000")
+	for name, query := range map[string]string{
+		"changed width": q.Encode(),
+		"unsigned":      "w=400&h=400&fit=cover",
+	} {
+		if err := wants("photos/team.jpg", query); !errors.Is(err, errImageSignature) {
+			t.Errorf("%s: err = %v, want errImageSignature", name, err)
+		}
+	}
+	if err := wants("photos/other.jpg", signed.RawQuery); !errors.Is(err, errImageSignature) {
+		t.Errorf("signature reused for another key: err = %v, want errImageSignature", err)
+	}
+	// Requests without transformations need no signature
+	if err := wants("photos/team.jpg", ""); err != nil {
+		t.Errorf("plain request refused: %v", err)
+	}
+}
+
+func TestSignImageURL(t *testing.T) {
+	engine, err := imaging.New("go", imaging.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &MediaHandler{signingSecret: "test-secret", publicBase: publicBaseURL, images: engine, imageLimits: imaging.Limits{MaxWidth: 2000}}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"path": "photos/team.jpg", "w": 400, "fit": "cover"}`, http.StatusOK},
+		{`{"path": "photos/team.jpg", "w": 400, "h": 400, "fit": "cover", "fp-x": 0.2}`, http.StatusBadRequest},
+		{`{"path": "photos/team.jpg"}`, http.StatusBadRequest},
+		{`{"path": "photos/team.jpg", "w": 20000}`, http.StatusBadRequest},
+		{`{"path": "photos/team.jpg", "w": -1}`, http.StatusBadRequest},
+		{`{"path": "photos/team.jpg", "format": "webp"}`, http.StatusBadRequest},
+		{`{"path": "notes.txt", "w": 400}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.SignImageURL(w, httptest.NewRequest(http.MethodPost, "/v1/media/images/sign", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp ImageURLResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if want := "https://cdn.mikeodnis.dev/photos/team.jpg?s="; !strings.HasPrefix(resp.URL, want) || !strings.HasSuffix(resp.URL, "&w=400") {
+			t.Errorf("URL = %s, want %s...&w=400", resp.URL, want)
+		}
+	}
+}
+
+// heifEngine stands in for libvips built with libheif
+type heifEngine struct{ webp bool }
+
+func (heifEngine) Name() string           { return "heif" }
+func (heifEngine) Reads(key string) bool  { return imaging.Source(key) }
+func (e heifEngine) Writes(f string) bool { return f != "webp" || e.webp }
+func (heifEngine) Transform(src []byte, o imaging.Options) ([]byte, string, error) {
+	return src, o.ContentType(), nil
+}
+
+func TestTranscodeHEIC(t *testing.T) {
+	tests := []struct {
+		name      string
+		h         *MediaHandler
+		query     string
+		accept    string
+		transform string // "" for none
+	}{
+		{"webp for clients accepting it", &MediaHandler{images: heifEngine{webp: true}}, "", "image/avif,image/webp,*/*", "webp"},
+		{"jpeg for the rest", &MediaHandler{images: heifEngine{webp: true}}, "", "*/*", "jpeg"},
+		{"jpeg without a webp encoder", &MediaHandler{images: heifEngine{}}, "", "image/webp", "jpeg"},
+		{"with a resize", &MediaHandler{images: heifEngine{webp: true}}, "w=300", "", "w300-jpeg"},
+		{"format requested", &MediaHandler{images: heifEngine{webp: true}}, "format=png", "image/webp", "png"},
+		{"no format allowed", &MediaHandler{images: heifEngine{webp: true}, imageLimits: imaging.Limits{Formats: []string{"png"}}}, "", "image/webp", ""},
+		{"engine without libheif", &MediaHandler{images: mustEngine(t, "go")}, "", "image/webp", ""},
+		{"unsigned plain request", &MediaHandler{images: heifEngine{webp: true}, requireImageSignature: true}, "", "", "jpeg"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/media/assets/photos/IMG_0001.HEIC?"+tt.query, nil)
+		r.Header.Set("Accept", tt.accept)
+		d, ok, err := tt.h.wantsImage(r, "photos/IMG_0001.HEIC")
+		if err != nil || ok != (tt.transform != "") || d.transform != tt.transform {
+			t.Errorf("%s: transform %q, %v, %v, want %q", tt.name, d.transform, ok, err, tt.transform)
+		}
+	}
+}
+
+func mustEngine(t *testing.T, name string) imaging.Engine {
+	t.Helper()
+	e, err := imaging.New(name, imaging.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestUploadContentType(t *testing.T) {
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+	tests := []struct {
+		key, declared string
+		data          []byte
+		want          string
+	}{
+		{"a/IMG_0001.HEIC", "", heic, "image/heic"},
+		{"a/IMG_0001.heic", "application/octet-stream", heic, "image/heic"},
+		{"a/IMG_0001.heif", "image/heif-sequence", heic, "image/heif-sequence"},
+		{"a/data.zip", "application/octet-stream", heic, "application/octet-stream"},
+		{"a/notes.txt", "", []byte("hello"), "text/plain; charset=utf-8"},
+		{"a/report.docx", "", []byte("PK\x03\x04\x14\x00\x06\x00"), "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"a/data.zip", "", []byte("PK\x03\x04\x14\x00\x06\x00"), "application/zip"},
+		{"fonts/Inter.woff2", "application/octet-stream", []byte("wOF2\x00\x01\x00\x00"), "font/woff2"},
+	}
+	for _, tt := range tests {
+		if got := uploadContentType(tt.key, tt.declared, tt.data); got != tt.want {
+			t.Errorf("uploadContentType(%s, %q) = %q, want %q", tt.key, tt.declared, got, tt.want)
+		}
+	}
+}
+
+func TestImageFocalPoint(t *testing.T) {
+	h := &MediaHandler{images: mustEngine(t, "go")}
+	wants := func(query string) derivation {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/v1/media/assets/photos/team.jpg?"+query, nil)
+		d, ok, err := h.wantsImage(r, "photos/team.jpg")
+		if !ok || err != nil {
+			t.Fatalf("wantsImage(%s) = %v, %v", query, ok, err)
+		}
+		return d
+	}
+
+	d := wants("w=300&h=200&fit=cover")
+	if d.forSource == nil {
+		t.Fatal("cover crop doesn't read the stored focal point")
+	}
+	if got := d.forSource(map[string]string{focalPointMeta: "0.3,0.6"}).transform; got != "w300-h200-cover-fp300x600" {
+		t.Errorf("with a stored focal point, transform = %s", got)
+	}
+	for _, meta := range []map[string]string{nil, {focalPointMeta: "bogus"}} {
+		if got := d.forSource(meta).transform; got != "w300-h200-cover" {
+			t.Errorf("with metadata %v, transform = %s, want w300-h200-cover", meta, got)
+		}
+	}
+
+	// A focal point in the query wins, and other fits don't crop
+	for _, query := range []string{"w=300&h=200&fit=cover&fp-x=0.5&fp-y=0.1", "w=300&h=200"} {
+		if wants(query).forSource != nil {
+			t.Errorf("%s reads the stored focal point", query)
+		}
+	}
+}
+
+func TestUpdateFocalPointValidation(t *testing.T) {
+	h := &MediaHandler{}
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"path": "photos/team.jpg", "x": 0.3}`, http.StatusBadRequest},
+		{`{"path": "photos/team.jpg", "x": 0.3, "y": 1.2}`, http.StatusBadRequest},
+		{`{"path": "notes.txt", "x": 0.3, "y": 0.6}`, http.StatusBadRequest},
+		{`{"path": "../team.jpg", "x": 0.3, "y": 0.6}`, http.StatusBadRequest},
+		{`{"path": "derived/team.jpg", "x": 0.3, "y": 0.6}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.UpdateFocalPoint(w, httptest.NewRequest(http.MethodPost, "/v1/media/images/focal-point", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.status)
+		}
+	}
+}
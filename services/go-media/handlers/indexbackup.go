@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+)
+
+// IndexSnapshot is the metadata index, or the part of it under a prefix,
+// as cdn-backup saves it with the bucket's objects and restores it
+type IndexSnapshot struct {
+	Entries []index.Entry `json:"entries"`
+}
+
+func (s IndexSnapshot) Check() validation.Errors {
+	var errs validation.Errors
+	for i, e := range s.Entries {
+		if err := ValidateKey(e.Key); err != nil {
+			errs = append(errs, validation.FieldError{Field: fmt.Sprintf("entries[%d].key", i), Rule: "key", Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+type IndexImportResponse struct {
+	Imported int `json:"imported"`
+}
+
+func (h *MediaHandler) indexEnabled(w http.ResponseWriter) bool {
+	if h.index == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Metadata index not enabled")
+		return false
+	}
+	return true
+}
+
+// ExportIndex returns the index entries under ?prefix=, usage counters
+// and extracted text included
+func (h *MediaHandler) ExportIndex(w http.ResponseWriter, r *http.Request) {
+	if !h.indexEnabled(w) {
+		return
+	}
+	respondJSON(w, http.StatusOK, IndexSnapshot{Entries: h.index.List(r.URL.Query().Get("prefix"))})
+}
+
+// ImportIndex puts entries exported by ExportIndex back, replacing those
+// at the same keys. It is for objects restored to the bucket outside the
+// service; their entries are taken as they are, so the ETags must be the
+// restored objects'.
+func (h *MediaHandler) ImportIndex(w http.ResponseWriter, r *http.Request) {
+	if !h.indexEnabled(w) {
+		return
+	}
+	var snap IndexSnapshot
+	if !decodeJSON(w, r, &snap) {
+		return
+	}
+	for _, e := range snap.Entries {
+		h.index.Update(e.Key, func(cur *index.Entry) {
+			*cur = e
+		})
+	}
+	respondJSON(w, http.StatusOK, IndexImportResponse{Imported: len(snap.Entries)})
+}
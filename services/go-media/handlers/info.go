@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/offload"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/mux"
+)
+
+// imageHeaderBytes is how much of an image is read for its dimensions
+const imageHeaderBytes = 64 << 10
+
+// internalMeta is object metadata the service keeps for itself, left out
+// of AssetInfo.Metadata
+var internalMeta = map[string]bool{
+	envelope.AlgorithmMeta: true,
+	envelope.KeyIDMeta:     true,
+	envelope.DataKeyMeta:   true,
+	offloadServiceMeta:     true,
+	offloadIDMeta:          true,
+	sourceETagMeta:         true,
+	sha256Meta:             true,
+	md5Meta:                true,
+	sha384Meta:             true,
+}
+
+// AssetInfo is everything known about an asset, in one document
+type AssetInfo struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified *time.Time        `json:"last_modified,omitempty"`
+	Checksums    map[string]string `json:"checksums,omitempty"`
+	Integrity    string            `json:"integrity,omitempty"`
+	Image        *ImageInfo        `json:"image,omitempty"`
+	Video        *VideoInfo        `json:"video,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Encrypted    bool              `json:"encrypted,omitempty"`
+	CustomerKey  bool              `json:"customer_key,omitempty"`
+	Offload      *offload.Asset    `json:"offload,omitempty"`
+	URLs         AssetURLs         `json:"urls"`
+}
+
+// ImageInfo describes an image asset
+type ImageInfo struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// AssetURLs are where an asset can be fetched. Signed is a template:
+// {exp} and {sig} come from POST /v1/media/sign.
+type AssetURLs struct {
+	Public string `json:"public,omitempty"`
+	Signed string `json:"signed"`
+}
+
+// AssetInfo returns an asset's size, type, checksums, image dimensions,
+// metadata and URLs, saving clients a HEAD, a metadata lookup and a
+// partial download
+func (h *MediaHandler) AssetInfo(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["path"]
+
+	ctx, err := customerKeyContext(r.Context(), r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	head, err := h.HeadAsset(ctx, key)
+	if err != nil {
+		switch {
+		case storage.IsNotFound(err):
+			respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		case storage.HasCustomerKey(ctx):
+			respondError(w, http.StatusForbidden, apierror.EncryptionKeyMismatch, "Encryption key does not match")
+		default:
+			h.reporter.CaptureError(r, err)
+			respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read object")
+		}
+		return
+	}
+
+	base := h.keyBaseURL(key, h.baseURL(r))
+	info := AssetInfo{
+		Key:          key,
+		Size:         aws.ToInt64(head.ContentLength),
+		ContentType:  aws.ToString(head.ContentType),
+		ETag:         strings.Trim(aws.ToString(head.ETag), `"`),
+		LastModified: head.LastModified,
+		Encrypted:    envelope.Sealed(head.Metadata),
+		CustomerKey:  storage.HasCustomerKey(ctx),
+		Offload:      offloadedCopy(head.Metadata),
+		URLs:         AssetURLs{Signed: base + "/v1/media/private/" + key + "?exp={exp}&sig={sig}"},
+	}
+	// Digests recorded at upload, or else a plain single-part upload's
+	// ETag, which is the MD5 of its content
+	for _, meta := range []string{sha256Meta, md5Meta, sha384Meta} {
+		if sum := head.Metadata[meta]; sum != "" {
+			if info.Checksums == nil {
+				info.Checksums = make(map[string]string)
+			}
+			info.Checksums[meta] = sum
+		}
+	}
+	info.Integrity = integrity(info.Checksums)
+	if info.Checksums == nil && !info.Encrypted && !info.CustomerKey && info.ETag != "" && !strings.Contains(info.ETag, "-") {
+		info.Checksums = map[string]string{md5Meta: info.ETag}
+	}
+	if !h.sealed(key) && !info.CustomerKey {
+		info.URLs.Public = h.keyURL(key, base)
+	}
+	for k, v := range head.Metadata {
+		if internalMeta[k] {
+			continue
+		}
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+		info.Metadata[k] = v
+	}
+	if strings.HasPrefix(info.ContentType, "image/") {
+		info.Image = h.imageInfo(ctx, key)
+	}
+	if strings.HasPrefix(info.ContentType, "video/") && info.URLs.Public != "" {
+		info.Video = h.videoInfo(ctx, key, info.ETag)
+	}
+
+	respondJSON(w, http.StatusOK, info)
+}
+
+// imageInfo reads the dimensions from the start of an image, or returns
+// nil for formats it doesn't know or headers it can't find
+func (h *MediaHandler) imageInfo(ctx context.Context, key string) *ImageInfo {
+	obj, err := h.OpenAssetRange(ctx, key, "bytes=0-"+strconv.Itoa(imageHeaderBytes-1))
+	if err != nil {
+		return nil
+	}
+	defer obj.Body.Close()
+	header, err := io.ReadAll(io.LimitReader(obj.Body, imageHeaderBytes))
+	if err != nil {
+		return nil
+	}
+	return decodeImageInfo(header)
+}
+
+// decodeImageInfo reads the dimensions of a PNG, JPEG, GIF or WebP image
+// from its first bytes
+func decodeImageInfo(header []byte) *ImageInfo {
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(header)); err == nil {
+		return &ImageInfo{Format: format, Width: cfg.Width, Height: cfg.Height}
+	}
+	if width, height, err := webpSize(header); err == nil {
+		return &ImageInfo{Format: "webp", Width: width, Height: height}
+	}
+	return nil
+}
+
+var errNotWebP = errors.New("not a WebP image")
+
+// webpSize reads the canvas size from the first chunk of a WebP file
+func webpSize(b []byte) (width, height int, err error) {
+	if len(b) < 30 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return 0, 0, errNotWebP
+	}
+	chunk := b[20:]
+	switch string(b[12:16]) {
+	case "VP8 ":
+		// Lossy: a keyframe start code, then 14-bit dimensions
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errNotWebP
+		}
+		return int(binary.LittleEndian.Uint16(chunk[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(chunk[8:]) & 0x3fff), nil
+	case "VP8L":
+		// Lossless: a signature byte, then 14-bit dimensions minus one
+		if chunk[0] != 0x2f {
+			return 0, 0, errNotWebP
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		// Extended: 24-bit canvas dimensions minus one
+		return (int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16) + 1,
+			(int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16) + 1, nil
+	}
+	return 0, 0, errNotWebP
+}
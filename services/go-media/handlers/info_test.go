@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestDecodeImageInfo(t *testing.T) {
+	var pngImage bytes.Buffer
+	png.Encode(&pngImage, image.NewGray(image.Rect(0, 0, 640, 480)))
+
+	riff := func(chunk string, data ...byte) []byte {
+		b := append([]byte("RIFF\x00\x00\x00\x00WEBP"+chunk+"\x00\x00\x00\x00"), data...)
+		return append(b, make([]byte, 16)...)
+	}
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   *ImageInfo
+	}{
+		{"png", pngImage.Bytes(), &ImageInfo{Format: "png", Width: 640, Height: 480}},
+		// 1920x1080 as 14-bit fields after the keyframe start code
+		{"lossy webp", riff("VP8 ", 0, 0, 0, 0x9d, 0x01, 0x2a, 0x80, 0x07, 0x38, 0x04), &ImageInfo{Format: "webp", Width: 1920, Height: 1080}},
+		// 100x50, stored minus one: 99 | 49<<14
+		{"lossless webp", riff("VP8L", 0x2f, 0x63, 0x40, 0x0c, 0x00), &ImageInfo{Format: "webp", Width: 100, Height: 50}},
+		// 4000x3000, stored minus one in 24 bits
+		{"extended webp", riff("VP8X", 0, 0, 0, 0, 0x9f, 0x0f, 0x00, 0xb7, 0x0b, 0x00), &ImageInfo{Format: "webp", Width: 4000, Height: 3000}},
+		{"truncated", pngImage.Bytes()[:10], nil},
+		{"not an image", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeImageInfo(tt.header)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("decodeImageInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxKeyLength is R2's limit on object keys, in bytes
+const maxKeyLength = 1024
+
+// reservedPrefixes hold objects the service manages itself: deleted
+// objects in the trash, derived renditions and its other internal
+// objects. Clients can't write or delete under them.
+var reservedPrefixes = internalPrefixes
+
+// unsafeKeyChars break the public URLs built from keys, or are mangled
+// on the way through proxies and S3 clients
+const unsafeKeyChars = "\\{}^%`[]\"<>~#|?"
+
+// Key policy errors. ErrInvalidKey is a malformed key; ErrReservedKey one
+// under a reserved prefix.
+var (
+	ErrInvalidKey  = errors.New("invalid key")
+	ErrReservedKey = errors.New("reserved key")
+)
+
+// ValidateKey checks that clients may write or delete key, through any
+// API. Directory markers end in "/".
+func ValidateKey(key string) error {
+	switch {
+	case key == "" || key == "/":
+		return fmt.Errorf("%w: empty", ErrInvalidKey)
+	case len(key) > maxKeyLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidKey, maxKeyLength)
+	case !utf8.ValidString(key):
+		return fmt.Errorf("%w: not UTF-8", ErrInvalidKey)
+	case strings.HasPrefix(key, "/"):
+		return fmt.Errorf("%w: starts with /", ErrInvalidKey)
+	}
+	for _, c := range key {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(unsafeKeyChars, c) {
+			return fmt.Errorf("%w: contains %q", ErrInvalidKey, c)
+		}
+	}
+	for _, seg := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("%w: empty, . or .. path segment", ErrInvalidKey)
+		}
+	}
+	for _, p := range reservedPrefixes {
+		// The prefix's own directory marker, or a file named like it
+		if strings.HasPrefix(key, p) || key+"/" == p {
+			return fmt.Errorf("%w: %s is managed by the service", ErrReservedKey, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{"assets/logo.png", nil},
+		{"docs/guide/", nil},
+		{"assets/café menu.pdf", nil},
+		{"logs.txt", nil},
+		{"derivedwork/a.png", nil},
+		{strings.Repeat("a", maxKeyLength), nil},
+		{"", ErrInvalidKey},
+		{"/", ErrInvalidKey},
+		{"/assets/logo.png", ErrInvalidKey},
+		{strings.Repeat("a", maxKeyLength+1), ErrInvalidKey},
+		{"assets/\xff.png", ErrInvalidKey},
+		{"assets/a\nb.png", ErrInvalidKey},
+		{"assets/a#b.png", ErrInvalidKey},
+		{"assets/50%.png", ErrInvalidKey},
+		{"assets//logo.png", ErrInvalidKey},
+		{"assets/../logo.png", ErrInvalidKey},
+		{"./logo.png", ErrInvalidKey},
+		{".trash/assets/logo.png", ErrReservedKey},
+		{"derived/assets/logo.webp", ErrReservedKey},
+		{"logs/2024/access.log", ErrReservedKey},
+		{"_min/app.js", ErrReservedKey},
+		{"inventory/", ErrReservedKey},
+		{"logs", ErrReservedKey},
+	}
+	for _, tt := range tests {
+		err := ValidateKey(tt.key)
+		if tt.want == nil && err != nil {
+			t.Errorf("ValidateKey(%q) = %v, want nil", tt.key, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("ValidateKey(%q) = %v, want %v", tt.key, err, tt.want)
+		}
+	}
+}
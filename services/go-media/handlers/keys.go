@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// KeyStrategy decides the key an upload is stored under. Every strategy
+// stores under assets/, or the prefix an upload token or encryption
+// sets, and keeps the file's extension.
+type KeyStrategy string
+
+const (
+	// KeyHash names an upload by a hash of its content: identical files
+	// share a key and a changed file gets a new one
+	KeyHash KeyStrategy = "hash"
+	// KeyUUID names an upload with a UUIDv7, which sorts by upload time
+	KeyUUID KeyStrategy = "uuid"
+	// KeyTimestamp names an upload with its UTC upload time and a random
+	// suffix
+	KeyTimestamp KeyStrategy = "timestamp"
+	// KeyFilename keeps the uploaded file's name, so uploading a file of
+	// the same name replaces it in place
+	KeyFilename KeyStrategy = "filename"
+)
+
+var ErrInvalidKeyStrategy = errors.New("key_strategy must be hash, uuid, timestamp or filename")
+
+// ParseKeyStrategy checks a strategy name. The empty string is left for
+// the caller's default.
+func ParseKeyStrategy(s string) (KeyStrategy, error) {
+	switch k := KeyStrategy(s); k {
+	case "", KeyHash, KeyUUID, KeyTimestamp, KeyFilename:
+		return k, nil
+	}
+	return "", ErrInvalidKeyStrategy
+}
+
+// stable reports whether uploading the same file again picks the same
+// key, so the stored copy may already be the one being uploaded
+func (k KeyStrategy) stable() bool {
+	return k == KeyHash || k == KeyFilename
+}
+
+// WithKeyStrategy sets the key strategy of uploads that don't choose one
+func WithKeyStrategy(k KeyStrategy) Option {
+	return func(h *MediaHandler) {
+		h.keyStrategy = k
+	}
+}
+
+// uploadKeyStrategy picks the strategy for an upload: the upload token's,
+// else the one requested, else the default
+func (h *MediaHandler) uploadKeyStrategy(grant *UploadGrant, requested string) (KeyStrategy, error) {
+	if grant != nil && grant.KeyStrategy != "" {
+		return grant.KeyStrategy, nil
+	}
+	k, err := ParseKeyStrategy(requested)
+	if err != nil || k != "" {
+		return k, err
+	}
+	if h.keyStrategy != "" {
+		return h.keyStrategy, nil
+	}
+	return KeyHash, nil
+}
+
+// uploadName validates filename and returns its base name and lower-cased
+// extension. HTML files are allowed only if html is set.
+func uploadName(filename string, html bool) (name, ext string, err error) {
+	ext = strings.ToLower(filepath.Ext(filename))
+	if !allowedUploadExts[ext] && !(html && htmlExts[ext]) {
+		return "", "", ErrFileTypeNotAllowed
+	}
+
+	// Sanitize filename to prevent path traversal
+	name = filepath.Base(filename)
+	if strings.Contains(name, "..") || strings.Contains(name, "/") {
+		return "", "", ErrInvalidFilename
+	}
+	return name, ext, nil
+}
+
+// StrategyKey validates filename and returns the key data is stored under
+// with strategy k. Only KeyHash reads data.
+func StrategyKey(k KeyStrategy, filename string, data []byte) (string, error) {
+	return strategyKey(k, filename, data, false)
+}
+
+// uploadKey is StrategyKey, accepting HTML files when HTML uploads are
+// enabled
+func (h *MediaHandler) uploadKey(k KeyStrategy, filename string, data []byte) (string, error) {
+	return strategyKey(k, filename, data, h.html.Uploads)
+}
+
+func strategyKey(k KeyStrategy, filename string, data []byte, html bool) (string, error) {
+	name, ext, err := uploadName(filename, html)
+	if err != nil {
+		return "", err
+	}
+
+	switch k {
+	case KeyHash, "":
+		sum := sha256.Sum256(data)
+		return fmt.Sprintf("assets/%s%s", hex.EncodeToString(sum[:])[:16], ext), nil
+	case KeyUUID:
+		id, err := uuidV7(time.Now())
+		if err != nil {
+			return "", err
+		}
+		return "assets/" + id + ext, nil
+	case KeyTimestamp:
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("assets/%s-%s%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix), ext), nil
+	case KeyFilename:
+		return "assets/" + name, nil
+	}
+	return "", ErrInvalidKeyStrategy
+}
+
+// uuidV7 returns a random UUID led by the Unix time in milliseconds
+// (RFC 9562)
+func uuidV7(now time.Time) (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		return "", err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = 0x70 | u[6]&0x0f // version 7
+	u[8] = 0x80 | u[8]&0x3f // RFC variant
+
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestStrategyKey(t *testing.T) {
+	tests := []struct {
+		strategy KeyStrategy
+		want     *regexp.Regexp
+	}{
+		{KeyHash, regexp.MustCompile(`^assets/b94d27b9934d3e08\.png$`)},
+		{KeyUUID, regexp.MustCompile(`^assets/[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.png$`)},
+		{KeyTimestamp, regexp.MustCompile(`^assets/\d{8}T\d{6}Z-[0-9a-f]{8}\.png$`)},
+		{KeyFilename, regexp.MustCompile(`^assets/Team Photo\.PNG$`)},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			key, err := StrategyKey(tt.strategy, "photos/Team Photo.PNG", []byte("hello world"))
+			if err != nil || !tt.want.MatchString(key) {
+				t.Errorf("StrategyKey() = %q, %v, want a match for %s", key, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := StrategyKey(KeyFilename, "setup.exe", nil); err != ErrFileTypeNotAllowed {
+		t.Errorf("disallowed type: error %v", err)
+	}
+	if _, err := StrategyKey("sha1", "a.png", nil); err != ErrInvalidKeyStrategy {
+		t.Errorf("unknown strategy: error %v", err)
+	}
+}
+
+func TestUUIDV7SortsByTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	earlier, _ := uuidV7(now)
+	later, _ := uuidV7(now.Add(time.Millisecond))
+	if earlier >= later {
+		t.Errorf("uuidV7 %s is not before %s", earlier, later)
+	}
+	if earlier[:13] != "018f3406-9e00" {
+		t.Errorf("uuidV7 timestamp = %s, want 018f3406-9e00", earlier[:13])
+	}
+}
+
+func TestUploadKeyStrategy(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret", WithKeyStrategy(KeyUUID))
+	tests := []struct {
+		name      string
+		grant     *UploadGrant
+		requested string
+		want      KeyStrategy
+	}{
+		{"default", nil, "", KeyUUID},
+		{"requested", nil, "filename", KeyFilename},
+		{"token decides", &UploadGrant{KeyStrategy: KeyTimestamp}, "filename", KeyTimestamp},
+		{"token leaves it open", &UploadGrant{}, "hash", KeyHash},
+	}
+	for _, tt := range tests {
+		if got, err := h.uploadKeyStrategy(tt.grant, tt.requested); err != nil || got != tt.want {
+			t.Errorf("%s: uploadKeyStrategy() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := h.uploadKeyStrategy(nil, "random"); err != ErrInvalidKeyStrategy {
+		t.Errorf("unknown strategy: error %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// listingPageSize is the number of entries shown per listing page
+const listingPageSize = 1000
+
+// DirectoryListing is the JSON form of a directory listing
+type DirectoryListing struct {
+	Prefix      string         `json:"prefix"`
+	Directories []string       `json:"directories"`
+	Files       []ListingEntry `json:"files"`
+	NextCursor  string         `json:"next_cursor,omitempty"`
+}
+
+// ListingEntry is one object in a directory listing
+type ListingEntry struct {
+	Key          string    `json:"key"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href":  listingHref,
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Index of /{{.Prefix}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; }
+table { border-collapse: collapse; }
+th, td { padding: 0.25rem 1.5rem 0.25rem 0; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Index of /{{.Prefix}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if .Parent}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Directories}}
+<tr><td><a href="{{href .}}">{{.}}</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Files}}
+<tr><td><a href="{{href .Name}}">{{.Name}}</a></td><td class="size">{{bytes .Size}}</td><td>{{.LastModified.UTC.Format "2006-01-02 15:04"}}</td></tr>
+{{- end}}
+</table>
+{{- if .NextCursor}}
+<p><a href="?cursor={{.NextCursor}}">Next page</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// serveDirectory handles a request for a key ending in "/". It returns
+// the key of the directory's index.html when there is one; otherwise it
+// writes a listing (or a 404 when listings are off for dir) and returns
+// false.
+func (h *MediaHandler) serveDirectory(w http.ResponseWriter, r *http.Request, dir string) (string, bool) {
+	ctx := r.Context()
+
+	indexKey := dir + "index.html"
+	if _, err := h.r2Client.HeadObject(ctx, indexKey); err == nil {
+		return indexKey, true
+	} else if !storage.IsNotFound(err) {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read directory")
+		return "", false
+	}
+
+	if h.listingConfig == nil || !h.listingConfig().Enabled(dir) {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return "", false
+	}
+
+	page, err := h.ListPage(ctx, storage.ListOptions{
+		Prefix:            dir,
+		Delimiter:         "/",
+		ContinuationToken: r.URL.Query().Get("cursor"),
+		MaxKeys:           listingPageSize,
+	})
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list directory")
+		return "", false
+	}
+
+	// Drafts and assets outside their publishing window aren't listed
+	listing := newDirectoryListing(dir, page)
+	files := listing.Files[:0]
+	for _, f := range listing.Files {
+		if !h.hidden(f.Key) {
+			files = append(files, f)
+		}
+	}
+	listing.Files = files
+
+	parent := path.Dir(strings.TrimSuffix(dir, "/")) + "/"
+	h.writeListing(w, r, listing, h.listingConfig().Enabled(parent))
+	return "", false
+}
+
+// newDirectoryListing converts a delimited listing page of dir into the
+// entries shown to clients, with names relative to dir
+func newDirectoryListing(dir string, page *storage.ListPage) DirectoryListing {
+	listing := DirectoryListing{
+		Prefix:      dir,
+		Directories: []string{},
+		Files:       []ListingEntry{},
+	}
+	if page.IsTruncated {
+		listing.NextCursor = page.NextContinuationToken
+	}
+	for _, p := range page.CommonPrefixes {
+		listing.Directories = append(listing.Directories, strings.TrimPrefix(p, dir))
+	}
+	for _, obj := range page.Objects {
+		// Skip the directory's own marker object
+		if obj.Key == dir {
+			continue
+		}
+		listing.Files = append(listing.Files, ListingEntry{
+			Key:          obj.Key,
+			Name:         strings.TrimPrefix(obj.Key, dir),
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		})
+	}
+	return listing
+}
+
+// writeListing renders listing as JSON when asked for with ?format=json or
+// an Accept header, and as an HTML page otherwise
+func (h *MediaHandler) writeListing(w http.ResponseWriter, r *http.Request, listing DirectoryListing, parent bool) {
+	// Listings change as files are added, unlike the immutable assets
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Vary", "Accept")
+
+	if wantsJSON(r) {
+		respondJSONConditional(w, r, listing)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	listingTemplate.Execute(w, struct {
+		DirectoryListing
+		Parent bool
+	}{listing, parent})
+}
+
+func wantsJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// listingHref links to a listing entry relative to the current directory.
+// The "./" prefix keeps names containing ":" from reading as a URL scheme.
+func listingHref(name string) string {
+	if dir := strings.TrimSuffix(name, "/"); dir != name {
+		return "./" + url.PathEscape(dir) + "/"
+	}
+	return "./" + url.PathEscape(name)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+func TestListingEnabled(t *testing.T) {
+	cfg := config.ListingConfig{Prefixes: []string{"downloads/", "releases/v1/"}}
+
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"downloads/", true},
+		{"downloads/2024/", true},
+		{"releases/", false},
+		{"releases/v1/", true},
+		{"assets/", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.Enabled(tt.dir); got != tt.want {
+			t.Errorf("Enabled(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestWriteListing(t *testing.T) {
+	page := &storage.ListPage{
+		CommonPrefixes: []string{"downloads/old/"},
+		Objects: []storage.Object{
+			{Key: "downloads/"},
+			{Key: "downloads/<script>.txt", Size: 12, LastModified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+			{Key: "downloads/setup v2.exe", Size: 3 << 20},
+		},
+		IsTruncated:           true,
+		NextContinuationToken: "tok",
+	}
+	listing := newDirectoryListing("downloads/", page)
+	h := &MediaHandler{}
+
+	t.Run("html", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.writeListing(rec, httptest.NewRequest("GET", "/v1/media/assets/downloads/", nil), listing, false)
+
+		body := rec.Body.String()
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("Content-Type = %q, want text/html", ct)
+		}
+		for _, want := range []string{`href="./old/"`, `href="./setup%20v2.exe"`, "&lt;script&gt;.txt", "3.0 MiB", "2024-05-01 12:00", `href="?cursor=tok"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("listing missing %s:\n%s", want, body)
+			}
+		}
+		if strings.Contains(body, "<script>") || strings.Contains(body, `href="../"`) {
+			t.Errorf("listing has unescaped name or parent link:\n%s", body)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/media/assets/downloads/", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		h.writeListing(rec, req, listing, true)
+
+		var got DirectoryListing
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(got.Directories) != 1 || got.Directories[0] != "old/" {
+			t.Errorf("directories = %v, want [old/]", got.Directories)
+		}
+		if len(got.Files) != 2 || got.Files[0].Name != "<script>.txt" {
+			t.Errorf("files = %+v, want the two objects without the directory marker", got.Files)
+		}
+		if got.NextCursor != "tok" {
+			t.Errorf("next_cursor = %q, want tok", got.NextCursor)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/scheduler"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// Scheduled maintenance jobs, each a scheduler.Job
+
+const (
+	// multipartMaxAge is how long a multipart upload may stay incomplete
+	// before its parts are discarded
+	multipartMaxAge = 24 * time.Hour
+
+	// integrityBatch is the number of objects one integrity audit run
+	// reads back and checks
+	integrityBatch = 100
+
+	// inventoryPrefix holds the daily inventory reports
+	inventoryPrefix = "inventory/"
+)
+
+// WithScheduler enables the background job status endpoint
+func WithScheduler(s *scheduler.Scheduler) Option {
+	return func(h *MediaHandler) {
+		h.scheduler = s
+	}
+}
+
+// WithLifecycle sets the rules the lifecycle job expires objects by,
+// plus one expiring generated images after cfg.GeneratedDays
+func WithLifecycle(cfg config.LifecycleConfig) Option {
+	return func(h *MediaHandler) {
+		h.lifecycle = slices.Clip(cfg.Rules)
+		if cfg.GeneratedDays > 0 {
+			h.lifecycle = append(h.lifecycle, config.LifecycleRule{Prefix: generatedPrefix, Days: cfg.GeneratedDays})
+		}
+	}
+}
+
+// ExpireObjects deletes the objects under each lifecycle rule's prefix
+// last written more than its days ago. Assets are deleted as through the
+// API, into the trash where it is enabled; the service's own objects
+// under reserved prefixes, such as generated/qr/, are deleted outright.
+func (h *MediaHandler) ExpireObjects(ctx context.Context) error {
+	now := time.Now()
+	for _, rule := range h.lifecycle {
+		n, err := expireUnder(ctx, rule.Prefix, now.AddDate(0, 0, -rule.Days), h.r2Client.ListObjectsPage, h.expireObject)
+		if n > 0 {
+			log.Printf("Lifecycle: expired %d objects under %s", n, rule.Prefix)
+		}
+		if err != nil {
+			return fmt.Errorf("expiring %s: %w", rule.Prefix, err)
+		}
+	}
+	return nil
+}
+
+func (h *MediaHandler) expireObject(ctx context.Context, key string) error {
+	if errors.Is(ValidateKey(key), ErrReservedKey) {
+		return h.r2Client.DeleteObject(ctx, key)
+	}
+	return h.RemoveAsset(ctx, key)
+}
+
+// expireUnder removes with remove every object under prefix last
+// modified before cutoff, and returns how many it removed
+func expireUnder(ctx context.Context, prefix string, cutoff time.Time, list func(ctx context.Context, opts storage.ListOptions) (*storage.ListPage, error), remove func(ctx context.Context, key string) error) (int, error) {
+	removed := 0
+	opts := storage.ListOptions{Prefix: prefix, MaxKeys: 1000}
+	for {
+		page, err := list(ctx, opts)
+		if err != nil {
+			return removed, err
+		}
+		for _, o := range page.Objects {
+			if !o.LastModified.Before(cutoff) {
+				continue
+			}
+			if err := remove(ctx, o.Key); err != nil && !storage.IsNotFound(err) {
+				return removed, fmt.Errorf("%s: %w", o.Key, err)
+			}
+			removed++
+		}
+		if !page.IsTruncated {
+			return removed, nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// AbortStaleUploads aborts multipart uploads left incomplete for longer
+// than multipartMaxAge, whose parts R2 would otherwise keep (and bill)
+func (h *MediaHandler) AbortStaleUploads(ctx context.Context) error {
+	uploads, err := h.r2Client.ListMultipartUploads(ctx)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-multipartMaxAge)
+	aborted := 0
+	for _, u := range uploads {
+		if u.Initiated.After(cutoff) {
+			continue
+		}
+		if err := h.r2Client.AbortMultipartUpload(ctx, u.Key, u.UploadID); err != nil && !storage.IsNotFound(err) {
+			return fmt.Errorf("aborting upload of %s: %w", u.Key, err)
+		}
+		aborted++
+	}
+	if aborted > 0 {
+		log.Printf("Multipart GC: aborted %d stale uploads", aborted)
+	}
+	return nil
+}
+
+// AuditIntegrity reads back the next integrityBatch indexed objects, in
+// key order continuing from the last run, and checks their content
+// against the ETag recorded at upload. Multipart ETags aren't content
+// hashes, and objects under a client's SSE-C key can't be read, so both
+// are skipped. It fails listing the objects that don't match.
+func (h *MediaHandler) AuditIntegrity(ctx context.Context) error {
+	if h.index == nil {
+		return nil
+	}
+
+	var candidates []string
+	entries := h.index.List("")
+	for _, e := range entries {
+		if e.Key > h.integrityCursor && len(e.ETag) == md5.Size*2 && !e.CustomerKey {
+			candidates = append(candidates, e.Key)
+		}
+	}
+	if len(candidates) == 0 {
+		// Wrap around to the start of the index
+		h.integrityCursor = ""
+		return nil
+	}
+	if len(candidates) > integrityBatch {
+		candidates = candidates[:integrityBatch]
+	}
+
+	var corrupt []string
+	for _, key := range candidates {
+		e, ok := h.index.Get(key)
+		if !ok {
+			continue
+		}
+		ok, err := h.verifyObject(ctx, key, e.ETag)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			corrupt = append(corrupt, key)
+		}
+		h.integrityCursor = key
+	}
+
+	if len(corrupt) > 0 {
+		msg := fmt.Sprintf("integrity audit: %d objects don't match their ETag: %s", len(corrupt), strings.Join(corrupt, ", "))
+		h.reporter.CaptureMessage(nil, "error", msg)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// verifyObject reports whether the object at key hashes to etag. A
+// missing object counts as verified; reconciliation flags it.
+func (h *MediaHandler) verifyObject(ctx context.Context, key, etag string) (bool, error) {
+	obj, err := h.r2Client.GetObject(ctx, key)
+	if storage.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer obj.Body.Close()
+
+	sum := md5.New()
+	if _, err := io.Copy(sum, obj.Body); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(sum.Sum(nil)) == etag, nil
+}
+
+// WriteInventory stores a CSV of every indexed object in the bucket
+// under inventory/<date>.csv
+func (h *MediaHandler) WriteInventory(ctx context.Context) error {
+	if h.index == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeInventory(&buf, h.index.List("")); err != nil {
+		return err
+	}
+	key := inventoryPrefix + time.Now().UTC().Format("2006-01-02") + ".csv"
+	return h.r2Client.PutObject(ctx, key, &buf, "text/csv", nil)
+}
+
+func writeInventory(w io.Writer, entries []index.Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"key", "size", "content_type", "etag", "updated_at", "orphaned", "requests", "bytes_served"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Key,
+			strconv.FormatInt(e.Size, 10),
+			e.ContentType,
+			e.ETag,
+			e.UpdatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatBool(e.Orphaned),
+			strconv.FormatInt(e.Stats.Requests, 10),
+			strconv.FormatInt(e.Stats.BytesServed, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Jobs reports the scheduled background jobs and their last runs
+func (h *MediaHandler) Jobs(w http.ResponseWriter, r *http.Request) {
+	if h.scheduler == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Scheduler not enabled")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"jobs": h.scheduler.Status()})
+}
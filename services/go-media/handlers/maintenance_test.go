@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/smithy-go"
+)
+
+func TestWriteInventory(t *testing.T) {
+	entries := []index.Entry{
+		{Key: "assets/a,b.png", Size: 10, ContentType: "image/png", ETag: "abc", UpdatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Stats: index.Stats{Requests: 3, BytesServed: 30}},
+		{Key: "assets/gone.txt", Size: 1, Orphaned: true},
+	}
+	var buf bytes.Buffer
+	if err := writeInventory(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "key,size,content_type,etag,updated_at,orphaned,requests,bytes_served\n" +
+		"\"assets/a,b.png\",10,image/png,abc,2024-05-01T12:00:00Z,false,3,30\n" +
+		"assets/gone.txt,1,,,0001-01-01T00:00:00Z,true,0,0\n"
+	if buf.String() != want {
+		t.Errorf("inventory =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestExpireUnder(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	pages := []*storage.ListPage{
+		{Objects: []storage.Object{
+			{Key: "tmp/old.txt", LastModified: now.AddDate(0, 0, -10)},
+			{Key: "tmp/new.txt", LastModified: now.AddDate(0, 0, -1)},
+		}, IsTruncated: true, NextContinuationToken: "2"},
+		{Objects: []storage.Object{
+			{Key: "tmp/gone.txt", LastModified: now.AddDate(0, 0, -30)},
+			{Key: "tmp/older.txt", LastModified: now.AddDate(0, -1, 0)},
+		}},
+	}
+	list := func(ctx context.Context, opts storage.ListOptions) (*storage.ListPage, error) {
+		if opts.Prefix != "tmp/" {
+			t.Errorf("listed %q", opts.Prefix)
+		}
+		if opts.ContinuationToken == "2" {
+			return pages[1], nil
+		}
+		return pages[0], nil
+	}
+	var removed []string
+	remove := func(ctx context.Context, key string) error {
+		if key == "tmp/gone.txt" {
+			return &smithy.GenericAPIError{Code: "NoSuchKey"}
+		}
+		removed = append(removed, key)
+		return nil
+	}
+
+	n, err := expireUnder(context.Background(), "tmp/", now.AddDate(0, 0, -7), list, remove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || strings.Join(removed, ",") != "tmp/old.txt,tmp/older.txt" {
+		t.Errorf("expired %d: %v", n, removed)
+	}
+
+	failing := func(ctx context.Context, key string) error { return errors.New("access denied") }
+	if _, err := expireUnder(context.Background(), "tmp/", now.AddDate(0, 0, -7), list, failing); err == nil || !strings.Contains(err.Error(), "tmp/old.txt") {
+		t.Errorf("err = %v, want the key that failed", err)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+// maxManifestAssets bounds the assets one manifest lists
+const maxManifestAssets = 10000
+
+// Manifest maps the logical paths of the assets under a prefix to
+// fingerprinted URLs, for build tools to write into pages
+type Manifest struct {
+	Prefix string                   `json:"prefix"`
+	Assets map[string]ManifestEntry `json:"assets"`
+}
+
+// ManifestEntry is one asset of a Manifest
+type ManifestEntry struct {
+	Key string `json:"key"`
+	// URL changes whenever the content does, so it can be cached for
+	// good
+	URL string `json:"url"`
+	// Integrity is the asset's Subresource Integrity hash, for the
+	// integrity attribute of <script> and <link>. Assets stored without
+	// one, such as chunked uploads, have none.
+	Integrity   string `json:"integrity,omitempty"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// AssetManifest returns the Manifest of the public assets under
+// ?prefix=. It is built from the index on each request, so it changes as
+// soon as an upload does, and clients revalidate it by ETag.
+func (h *MediaHandler) AssetManifest(w http.ResponseWriter, r *http.Request) {
+	if h.index == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Manifests need the metadata index")
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	m, err := h.manifest(prefix, h.baseURL(r), time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	h.varyByRegion(w)
+	respondJSONConditional(w, r, m)
+}
+
+// manifest builds the Manifest of prefix at now, with URLs on the CDN at
+// base. Drafts, scheduled and
+// archived assets, and encrypted ones, have no public URL and are left
+// out.
+func (h *MediaHandler) manifest(prefix, base string, now time.Time) (Manifest, error) {
+	m := Manifest{Prefix: prefix, Assets: make(map[string]ManifestEntry)}
+	for _, e := range h.index.List(prefix) {
+		if internalKey(e.Key) || e.Orphaned || e.CustomerKey || e.Hidden(now) || h.sealed(e.Key) || strings.HasSuffix(e.Key, "/") {
+			continue
+		}
+		if len(m.Assets) == maxManifestAssets {
+			return m, fmt.Errorf("more than %d assets are under %q; use a longer prefix", maxManifestAssets, prefix)
+		}
+		m.Assets[strings.TrimPrefix(e.Key, prefix)] = ManifestEntry{
+			Key:         e.Key,
+			URL:         h.fingerprintedURL(base, e),
+			Integrity:   e.Integrity,
+			Size:        e.Size,
+			ContentType: e.ContentType,
+		}
+	}
+	return m, nil
+}
+
+// fingerprintedURL is the public URL of an indexed asset on the CDN at
+// base, with its version in the query so a new version gets a new URL
+func (h *MediaHandler) fingerprintedURL(base string, e index.Entry) string {
+	url := h.keyURL(e.Key, base)
+	if isHTML(e.ContentType) && h.html.Origin != "" {
+		url = h.htmlURL(context.Background(), e.Key)
+	}
+	version, _, _ := strings.Cut(e.ETag, "-")
+	if version == "" {
+		return url
+	}
+	return url + "?v=" + version[:min(len(version), 16)]
+}
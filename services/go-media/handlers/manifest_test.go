@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+func TestAssetManifest(t *testing.T) {
+	idx, _ := index.Open("")
+	h := NewMediaHandler(nil, "secret", WithIndex(idx))
+	later := time.Now().Add(time.Hour)
+	for key, e := range map[string]index.Entry{
+		"app/main.js":         {ETag: "5d41402abc4b2a76b9719d911017c592", Integrity: integrity(contentDigests([]byte("hello"))), ContentType: "text/javascript"},
+		"app/css/site.css":    {ETag: "0f343b0931126a20f133d67c2b018a3b-3", ContentType: "text/css"},
+		"app/draft.js":        {ETag: "aa", State: index.StateDraft},
+		"app/next.js":         {ETag: "bb", PublishAt: &later},
+		"app/secret.js":       {CustomerKey: true},
+		"other/main.js":       {ETag: "cc"},
+		"derived/app/main.js": {ETag: "dd"},
+	} {
+		e := e
+		idx.Update(key, func(entry *index.Entry) { *entry = e; entry.Key = key })
+	}
+
+	w := httptest.NewRecorder()
+	h.AssetManifest(w, httptest.NewRequest("GET", "/v1/media/manifest?prefix=app/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var m Manifest
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ManifestEntry{
+		"main.js": {
+			Key:         "app/main.js",
+			URL:         publicBaseURL + "/app/main.js?v=5d41402abc4b2a76",
+			Integrity:   "sha384-WeF0h3dEjGnea4ANejO7+5/xtGPkQ1TDVTvNucZm+pASWjx5+QOXvfX2oT3oKGhP",
+			ContentType: "text/javascript",
+		},
+		"css/site.css": {Key: "app/css/site.css", URL: publicBaseURL + "/app/css/site.css?v=0f343b0931126a20", ContentType: "text/css"},
+	}
+	if len(m.Assets) != len(want) {
+		t.Errorf("assets = %v", m.Assets)
+	}
+	for path, e := range want {
+		if m.Assets[path] != e {
+			t.Errorf("%s = %+v, want %+v", path, m.Assets[path], e)
+		}
+	}
+
+	// Unchanged, it revalidates; an upload changes it
+	r := httptest.NewRequest("GET", "/v1/media/manifest?prefix=app/", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	h.AssetManifest(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation status %d", w.Code)
+	}
+	idx.Update("app/main.js", func(e *index.Entry) { e.ETag = "ee" })
+	w = httptest.NewRecorder()
+	h.AssetManifest(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("status %d after an upload", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	NewMediaHandler(nil, "secret").AssetManifest(w, httptest.NewRequest("GET", "/v1/media/manifest", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("status %d without an index", w.Code)
+	}
+}
@@ -6,29 +6,210 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/WomB0ComB0/cdn/services/go-media/analytics"
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audio"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/collections"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/deploys"
+	"github.com/WomB0ComB0/cdn/services/go-media/documents"
+	"github.com/WomB0ComB0/cdn/services/go-media/domains"
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/offload"
+	"github.com/WomB0ComB0/cdn/services/go-media/reporting"
+	"github.com/WomB0ComB0/cdn/services/go-media/scheduler"
 	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
 	"github.com/gorilla/mux"
 )
 
 type MediaHandler struct {
 	r2Client      *storage.R2Client
 	signingSecret string
+	reporter      *reporting.Reporter
+	index         *index.Index
+	collections   *collections.Store
+	deploys       *deploys.Store
+	analytics     *analytics.Tracker
+	usage         *analytics.Usage
+	auditLog      *audit.Log
+	events        *events.Bus
+	webhooks      *events.WebhookDispatcher
+	cacheConfig   func() config.CacheConfig
+	listingConfig func() config.ListingConfig
+	downloads     config.DownloadConfig
+	html          config.HTMLConfig
+	regions       *regions
+	// publicBase is where the edge serves public assets; publicHosts are
+	// other hosts requests get URLs on, and domains the custom domains
+	// of prefixes
+	publicBase  string
+	publicHosts map[string]bool
+	domains     []config.URLDomain
+	// customDomains serve prefixes at their root once verified, with
+	// lookupTXT
+	customDomains *domains.Store
+	lookupTXT     func(ctx context.Context, name string) ([]string, error)
+	wellKnown     map[string]config.WellKnownFile
+	offload       *offload.Client
+	sealer        *envelope.Sealer
+	sealPrefix    string
+
+	requireUploadToken bool
+	usedUploadTokens   usedTokens
+	maxChunkedSize     int64
+	uploader           *storage.Uploader
+	keyStrategy        KeyStrategy
+	// trash keeps deleted objects under trashPrefix for restoring, for
+	// trashRetention days
+	trash          bool
+	trashRetention int
+	// lifecycle expires objects by prefix
+	lifecycle []config.LifecycleRule
+	// readOnly leaves the bucket to the primary instance: images rendered
+	// on request are served without being stored
+	readOnly bool
+
+	// transforms runs variant builds a bounded number at a time
+	transforms  *workpool.Pool
+	images      imaging.Engine
+	imageLimits imaging.Limits
+	// requireImageSignature refuses image variant URLs without ?s=
+	requireImageSignature bool
+	videos                *videos
+	audio                 *audio.Converter
+	documents             documents.Converter
+	// textLimit bounds the text indexed for each asset; 0 indexes none
+	textLimit int
+
+	scheduler *scheduler.Scheduler
+
+	reconcileMu   sync.Mutex // held while reconciling
+	reportMu      sync.Mutex
+	lastReconcile *ReconcileReport
+
+	// integrityCursor is the last key the integrity audit checked
+	integrityCursor string
+	cfZoneID        string
+	cfAPIToken      string
+	cfAPIBase       string
+	maxUploadSize   int64
+}
+
+// Option configures optional MediaHandler dependencies
+type Option func(*MediaHandler)
+
+// WithReporter forwards handler errors to an error reporter
+func WithReporter(reporter *reporting.Reporter) Option {
+	return func(h *MediaHandler) {
+		h.reporter = reporter
+	}
+}
+
+// WithIndex keeps the metadata index in sync with uploads and deletes
+func WithIndex(idx *index.Index) Option {
+	return func(h *MediaHandler) {
+		h.index = idx
+	}
+}
+
+// WithUsage enables monthly usage and cost reporting
+func WithUsage(usage *analytics.Usage) Option {
+	return func(h *MediaHandler) {
+		h.usage = usage
+	}
+}
+
+// WithAuditLog enables the audit log query endpoint
+func WithAuditLog(auditLog *audit.Log) Option {
+	return func(h *MediaHandler) {
+		h.auditLog = auditLog
+	}
+}
+
+// WithEvents publishes asset lifecycle events to bus
+func WithEvents(bus *events.Bus) Option {
+	return func(h *MediaHandler) {
+		h.events = bus
+	}
+}
+
+// WithWebhooks enables the webhook delivery log endpoint
+func WithWebhooks(d *events.WebhookDispatcher) Option {
+	return func(h *MediaHandler) {
+		h.webhooks = d
+	}
+}
+
+// WithCacheConfig sources Cache-Control headers from fn, which is called
+// per request so reloaded settings apply immediately
+func WithCacheConfig(fn func() config.CacheConfig) Option {
+	return func(h *MediaHandler) {
+		h.cacheConfig = fn
+	}
+}
+
+// WithListingConfig enables directory listings for the prefixes fn
+// returns, read per request like WithCacheConfig
+func WithListingConfig(fn func() config.ListingConfig) Option {
+	return func(h *MediaHandler) {
+		h.listingConfig = fn
+	}
+}
+
+// WithCloudflare sets the zone and API token used for cache purges
+func WithCloudflare(zoneID, apiToken string) Option {
+	return func(h *MediaHandler) {
+		h.cfZoneID = zoneID
+		h.cfAPIToken = apiToken
+	}
+}
+
+// WithCloudflareAPI sends cache purges to base in place of Cloudflare's
+// API, such as a stand-in in tests
+func WithCloudflareAPI(base string) Option {
+	return func(h *MediaHandler) {
+		h.cfAPIBase = base
+	}
+}
+
+// WithMaxUploadSize limits the size of a single upload request
+func WithMaxUploadSize(n int64) Option {
+	return func(h *MediaHandler) {
+		h.maxUploadSize = n
+	}
+}
+
+// WithAnalytics records per-asset request and byte counters
+func WithAnalytics(tracker *analytics.Tracker) Option {
+	return func(h *MediaHandler) {
+		h.analytics = tracker
+	}
 }
 
 type SignedURLRequest struct {
-	Path      string `json:"path"`
-	ExpiresIn int64  `json:"expires_in"` // seconds
+	Path      string `json:"path" validate:"required"`
+	ExpiresIn int64  `json:"expires_in" validate:"min=0"` // seconds
+	// Presigned returns an R2 presigned URL, which clients download from
+	// R2 directly, instead of one served by this service
+	Presigned bool `json:"presigned,omitempty"`
+	URLOptions
 }
 
 type SignedURLResponse struct {
@@ -40,17 +221,39 @@ type UploadResponse struct {
 	URL  string `json:"url"`
 	Key  string `json:"key"`
 	ETag string `json:"etag,omitempty"`
+	// Offload is the Cloudflare Images or Stream copy, whose URL is the
+	// one returned when set
+	Offload *offload.Asset `json:"offload,omitempty"`
+	// Deduplicated is set when the content was already stored, so
+	// nothing was written
+	Deduplicated bool `json:"deduplicated,omitempty"`
+	// Video is the HLS package a video is being transcoded to
+	Video *VideoTranscode `json:"video,omitempty"`
+	// State is draft for an upload kept off the public path until
+	// promoted
+	State string `json:"state,omitempty"`
+	// PublishAt and UnpublishAt bound when the asset is public
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty"`
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
+// ErrorResponse is the body of error responses
+type ErrorResponse = apierror.Response
 
-func NewMediaHandler(r2Client *storage.R2Client, signingSecret string) *MediaHandler {
-	return &MediaHandler{
-		r2Client:      r2Client,
-		signingSecret: signingSecret,
+func NewMediaHandler(r2Client *storage.R2Client, signingSecret string, opts ...Option) *MediaHandler {
+	h := &MediaHandler{
+		r2Client:       r2Client,
+		signingSecret:  signingSecret,
+		maxUploadSize:  100 << 20, // 100MB
+		maxChunkedSize: 5 << 30,   // 5GB
+		keyStrategy:    KeyHash,
+		publicBase:     publicBaseURL,
+		cfAPIBase:      "https://api.cloudflare.com/client/v4",
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // HealthCheck endpoint
@@ -67,15 +270,63 @@ func (h *MediaHandler) ServeAsset(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
+	// Encrypted objects are only served through signed URLs, and deleted
+	// ones not at all
+	if h.sealed(key) || trashed(key) {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	}
+
+	// Directories serve their index.html, or a listing where enabled
+	if strings.HasSuffix(key, "/") {
+		indexKey, ok := h.serveDirectory(w, r, key)
+		if !ok {
+			return
+		}
+		key = indexKey
+	}
+
+	// Drafts, archived assets and scheduled ones outside their window
+	// are only served through signed URLs
+	if h.hidden(key) {
+		notPublished(w)
+		return
+	}
+	if h.redirectHTML(w, r, key, "") {
+		return
+	}
+
+	v, err := h.selectVariant(ctx, r, key)
+	switch {
+	case errors.Is(err, workpool.ErrSaturated):
+		w.Header().Set("Retry-After", "1")
+		respondError(w, http.StatusTooManyRequests, apierror.TransformsBusy, "Too many transformations in progress, retry shortly")
+		return
+	case errors.Is(err, errImageSignature):
+		respondError(w, http.StatusForbidden, apierror.SignatureInvalid, err.Error())
+		return
+	case err != nil:
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
 	// HEAD request - only return headers
 	if r.Method == http.MethodHead {
-		head, err := h.r2Client.HeadObject(ctx, key)
+		head, err := h.r2Client.HeadObject(ctx, v.objectKey)
 		if err != nil {
-			http.Error(w, "Object not found", http.StatusNotFound)
+			respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
 			return
 		}
 
-		h.setObjectHeaders(w, head.ETag, head.ContentType, head.ContentLength, head.LastModified)
+		etag := v.etag(head.ETag, head.Metadata)
+		h.setVariantHeaders(w, v, etag)
+		if h.checkETag(w, r, etag) {
+			return
+		}
+		h.setObjectHeaders(w, nil, head.ContentType, head.ContentLength, head.LastModified)
+		setDigestHeaders(w, head.Metadata)
+		setEncodingHeaders(w, key, v.encoding)
+		h.sandboxHTML(w, key)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -83,29 +334,57 @@ func (h *MediaHandler) ServeAsset(w http.ResponseWriter, r *http.Request) {
 	// Handle Range requests
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader != "" {
-		h.serveRange(w, r, key, rangeHeader)
+		h.serveRange(w, r, v, rangeHeader)
 		return
 	}
 
 	// Regular GET request
-	obj, err := h.r2Client.GetObject(ctx, key)
+	obj, err := h.r2Client.GetObject(ctx, v.objectKey)
 	if err != nil {
-		http.Error(w, "Object not found", http.StatusNotFound)
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
 		return
 	}
 	defer obj.Body.Close()
 
-	// Check If-None-Match (ETag)
-	if h.checkETag(w, r, obj.ETag) {
+	// Check If-None-Match (ETag). A 304 carries the same validators and
+	// caching headers as the full response would.
+	etag := v.etag(obj.ETag, obj.Metadata)
+	h.setVariantHeaders(w, v, etag)
+	if h.checkETag(w, r, etag) {
 		return
 	}
 
-	h.setObjectHeaders(w, obj.ETag, obj.ContentType, obj.ContentLength, obj.LastModified)
-	
-	// Immutable cache for assets
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-	
-	io.Copy(w, obj.Body)
+	h.setObjectHeaders(w, nil, obj.ContentType, obj.ContentLength, obj.LastModified)
+	setDigestHeaders(w, obj.Metadata)
+	setEncodingHeaders(w, key, v.encoding)
+	h.sandboxHTML(w, key)
+
+	body := obj.Body
+	if obj.ContentLength != nil {
+		body = h.parallelBody(ctx, v.objectKey, obj.Body, *obj.ContentLength, obj.ETag)
+		defer body.Close()
+	}
+	// A failed part leaves the body short of its Content-Length, so the
+	// connection is closed and the client sees a failed download
+	n, _ := io.Copy(w, body)
+	h.analytics.Record(key, n)
+}
+
+// setVariantHeaders sets the headers shared by full, partial and 304
+// responses for a variant of a public asset
+func (h *MediaHandler) setVariantHeaders(w http.ResponseWriter, v assetVariant, etag *string) {
+	if etag != nil {
+		w.Header().Set("ETag", *etag)
+	}
+	if variesByEncoding(v.key) {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if imaging.Transcoded(v.key) {
+		// Converted to WebP or JPEG by what the client accepts
+		w.Header().Add("Vary", "Accept")
+	}
+	// Immutable cache for assets unless a cache rule says otherwise
+	w.Header().Set("Cache-Control", h.publicCacheControl(v.key))
 }
 
 // ServePrivateAsset serves private assets with signature validation
@@ -116,182 +395,335 @@ func (h *MediaHandler) ServePrivateAsset(w http.ResponseWriter, r *http.Request)
 	// Validate signature
 	signature := r.URL.Query().Get("sig")
 	expires := r.URL.Query().Get("exp")
+	opts := urlOptions(r.URL.Query())
 
-	if !h.validateSignature(key, expires, signature) {
-		http.Error(w, "Invalid or expired signature", http.StatusForbidden)
+	// URLs signed before their options were checked are refused too
+	if !h.validateSignature(key, expires, signature, opts) || len(opts.Check()) > 0 {
+		respondError(w, http.StatusForbidden, apierror.SignatureInvalid, "Invalid or expired signature")
+		return
+	}
+	if trashed(key) {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
 		return
 	}
 
 	// Check expiration
 	expTime, err := strconv.ParseInt(expires, 10, 64)
 	if err != nil || time.Now().Unix() > expTime {
-		http.Error(w, "Signature expired", http.StatusForbidden)
+		respondError(w, http.StatusForbidden, apierror.SignatureExpired, "Signature expired")
+		return
+	}
+	if !opts.active(time.Now()) {
+		w.Header().Set("Retry-After", time.Unix(opts.NotBefore, 0).UTC().Format(http.TimeFormat))
+		respondError(w, http.StatusForbidden, apierror.SignatureNotYetValid, "Signature not yet valid")
+		return
+	}
+	if h.redirectHTML(w, r, key, opts.ContentType) {
 		return
 	}
 
 	// Serve the asset (similar to ServeAsset)
-	ctx := r.Context()
+	ctx, err := customerKeyContext(r.Context(), r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
 	obj, err := h.r2Client.GetObject(ctx, key)
 	if err != nil {
-		http.Error(w, "Object not found", http.StatusNotFound)
+		if storage.HasCustomerKey(ctx) && !storage.IsNotFound(err) {
+			// R2 refuses a key that doesn't match the object's
+			respondError(w, http.StatusForbidden, apierror.EncryptionKeyMismatch, "Encryption key does not match")
+			return
+		}
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
 		return
 	}
 	defer obj.Body.Close()
+	// The HTML decisions go by the type the response is served with
+	contentType := opts.ContentType
+	if contentType == "" && obj.ContentType != nil {
+		contentType = *obj.ContentType
+	}
+	if h.redirectHTML(w, r, key, contentType) {
+		return
+	}
+	if err := h.openSealed(ctx, obj); err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to decrypt object")
+		return
+	}
 
-	h.setObjectHeaders(w, obj.ETag, obj.ContentType, obj.ContentLength, obj.LastModified)
-	w.Header().Set("Cache-Control", "private, max-age=3600")
-	
-	io.Copy(w, obj.Body)
+	body, etag, length, metadata := io.Reader(obj.Body), obj.ETag, obj.ContentLength, obj.Metadata
+	if obj.ContentType != nil && isPlaylist(*obj.ContentType) {
+		// Each viewer gets the playlist with their own segment
+		// signatures, so its stored ETag and digests don't apply
+		data, err := io.ReadAll(io.LimitReader(obj.Body, maxSignedPlaylist+1))
+		if err == nil && len(data) > maxSignedPlaylist {
+			err = fmt.Errorf("playlist %s is over %d bytes", key, maxSignedPlaylist)
+		}
+		if err != nil {
+			h.reporter.CaptureError(r, err)
+			respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read playlist")
+			return
+		}
+		data = h.signPlaylist(key, data, expires)
+		size := int64(len(data))
+		body, etag, length, metadata = bytes.NewReader(data), nil, &size, nil
+	}
+
+	h.setObjectHeaders(w, etag, obj.ContentType, length, obj.LastModified)
+	setDigestHeaders(w, metadata)
+	opts.apply(w)
+	h.sandboxHTML(w, key)
+	w.Header().Set("Cache-Control", h.privateCacheControl())
+	if storage.HasCustomerKey(ctx) {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	n, _ := io.Copy(w, body)
+	h.analytics.Record(key, n)
 }
 
 // Upload handles single file upload
 func (h *MediaHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respondError(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+	ctx, err := customerKeyContext(context.Background(), r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	// An upload token in the header is checked before the body is read,
+	// and bounds how much of it is
+	maxUploadSize, bodyLimit := h.maxUploadSize, h.maxUploadSize
+	var grant *UploadGrant
+	if token := r.Header.Get(uploadTokenHeader); token != "" {
+		if grant, err = h.parseUploadToken(token); err != nil {
+			respondError(w, http.StatusUnauthorized, apierror.UploadTokenInvalid, err.Error())
+			return
+		}
+		if grant.MaxSize < maxUploadSize {
+			maxUploadSize = grant.MaxSize
+			bodyLimit = grant.MaxSize + uploadFormOverhead
+		}
+	}
+
+	// Parse multipart form
+	r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Failed to parse form or file too large")
 		return
 	}
 
-	// Parse multipart form (100MB max)
-	maxUploadSize := int64(100 << 20) // 100MB
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-	
-	err := r.ParseMultipartForm(32 << 20)
+	if token := r.FormValue("token"); grant == nil && token != "" {
+		if grant, err = h.parseUploadToken(token); err != nil {
+			respondError(w, http.StatusUnauthorized, apierror.UploadTokenInvalid, err.Error())
+			return
+		}
+		if grant.MaxSize < maxUploadSize {
+			maxUploadSize = grant.MaxSize
+		}
+	}
+	if grant == nil && h.requireUploadToken {
+		respondError(w, http.StatusUnauthorized, apierror.UploadTokenRequired, "Upload token required")
+		return
+	}
+	if err := h.checkVideoPreset(r.FormValue("video_preset")); err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	ctx = withVideoPreset(ctx, r.FormValue("video_preset"))
+	schedule, err := parsePublishSchedule(r.FormValue("publish_at"), r.FormValue("unpublish_at"), time.Now())
+	if err == nil {
+		schedule.State, err = uploadState(r.FormValue("state"))
+	}
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Failed to parse form or file too large"})
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
 		return
 	}
+	ctx = withPublishSchedule(ctx, schedule)
+	ctx = withPublicBase(ctx, h.baseURL(r))
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "No file provided"})
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "No file provided")
 		return
 	}
 	defer file.Close()
 
 	// Validate file size
 	if header.Size > maxUploadSize {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "File too large (max 100MB)"})
+		respondError(w, http.StatusBadRequest, apierror.FileTooLarge, fmt.Sprintf("File too large (max %dMB)", maxUploadSize>>20))
 		return
 	}
 
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowedExts := map[string]bool{
-		".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
-		".pdf": true, ".svg": true, ".mp4": true, ".webm": true, ".mp3": true,
-		".zip": true, ".json": true, ".txt": true, ".csv": true,
-	}
-	if !allowedExts[ext] {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "File type not allowed"})
+	fileBytes, err := io.ReadAll(io.LimitReader(file, maxUploadSize))
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file")
 		return
 	}
 
-	// Sanitize filename to prevent path traversal
-	filename := filepath.Base(header.Filename)
-	if strings.Contains(filename, "..") || strings.Contains(filename, "/") {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid filename"})
+	// Validate the file type and derive the key
+	strategy, err := h.uploadKeyStrategy(grant, r.FormValue("key_strategy"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
 		return
 	}
-
-	// Generate content hash for filename
-	hash := sha256.New()
-	fileBytes, err := io.ReadAll(io.LimitReader(file, maxUploadSize))
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read file"})
+	key, err := h.uploadKey(strategy, header.Filename, fileBytes)
+	switch {
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		respondError(w, http.StatusBadRequest, apierror.FileTypeNotAllowed, "File type not allowed")
+		return
+	case errors.Is(err, ErrInvalidFilename):
+		respondError(w, http.StatusBadRequest, apierror.InvalidFilename, "Invalid filename")
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to upload")
 		return
 	}
-	hash.Write(fileBytes)
-	contentHash := hex.EncodeToString(hash.Sum(nil))[:16]
-
-	// Create key with content hash
-	ext := filepath.Ext(header.Filename)
-	key := fmt.Sprintf("assets/%s%s", contentHash, ext)
 
-	// Detect content type
 	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = http.DetectContentType(fileBytes)
+	switch {
+	case grant != nil:
+		// The token decides where the file goes
+		if !grant.allows(uploadContentType(key, contentType, fileBytes)) {
+			respondError(w, http.StatusForbidden, apierror.ContentTypeNotAllowed, "Content type not allowed by upload token")
+			return
+		}
+		key = grant.Prefix + strings.TrimPrefix(key, "assets/")
+	case r.FormValue("secure") == "true":
+		if h.sealer == nil {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Encryption not enabled")
+			return
+		}
+		key = h.sealPrefix + strings.TrimPrefix(key, "assets/")
+	}
+	if err := ValidateKey(key); err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+	details := map[string]string{"filename": filepath.Base(header.Filename)}
+	if grant != nil {
+		details["token_id"] = grant.ID
+		if !h.usedUploadTokens.claim(grant.ID, time.Unix(grant.ExpiresAt, 0)) {
+			respondError(w, http.StatusUnauthorized, apierror.UploadTokenUsed, errUploadTokenUsed.Error())
+			return
+		}
+	}
+	audit.Annotate(r, key, details)
+
+	// A repeat upload to a content-hash or file name key may need no
+	// write, unless it brings a state or schedule to record
+	if strategy.stable() && schedule.isZero() {
+		if resp := h.ExistingUpload(ctx, key, fileBytes); resp != nil {
+			respondJSON(w, http.StatusOK, resp)
+			return
+		}
 	}
 
 	// Upload to R2
-	ctx := context.Background()
-	err = h.r2Client.PutObject(ctx, key, bytes.NewReader(fileBytes), contentType, nil)
+	resp, err := h.StoreUpload(ctx, key, header.Filename, contentType, fileBytes)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to upload"})
+		if grant != nil {
+			h.usedUploadTokens.release(grant.ID)
+		}
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to upload")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, UploadResponse{
-		URL: fmt.Sprintf("https://cdn.mikeodnis.dev/%s", key),
-		Key: key,
-	})
+	respondJSON(w, http.StatusOK, resp)
 }
 
 // MultipartUpload handles large file uploads
 func (h *MediaHandler) MultipartUpload(w http.ResponseWriter, r *http.Request) {
 	// Implementation for multipart upload would go here
 	// This is a placeholder for the complete implementation
-	respondJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "Multipart upload not yet implemented"})
+	respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Multipart upload not yet implemented")
 }
 
 // GenerateSignedURL creates a signed URL for private access
 func (h *MediaHandler) GenerateSignedURL(w http.ResponseWriter, r *http.Request) {
 	var req SignedURLRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	if req.ExpiresIn == 0 {
 		req.ExpiresIn = 3600 // Default 1 hour
 	}
+	expiresIn := time.Duration(req.ExpiresIn) * time.Second
+	if req.NotBefore != 0 && !time.Unix(req.NotBefore, 0).Before(time.Now().Add(expiresIn)) {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, errNeverValid.Error())
+		return
+	}
 
-	expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
-	expires := strconv.FormatInt(expiresAt.Unix(), 10)
-
-	signature := h.generateSignature(req.Path, expires)
-
-	url := fmt.Sprintf("https://cdn.mikeodnis.dev/v1/media/private/%s?exp=%s&sig=%s",
-		req.Path, expires, signature)
-
-	respondJSON(w, http.StatusOK, SignedURLResponse{
-		URL:       url,
-		ExpiresAt: expiresAt,
+	resp := h.signURL(h.keyBaseURL(req.Path, h.baseURL(r)), req.Path, expiresIn, req.URLOptions)
+	if req.Presigned {
+		var err error
+		resp, err = h.PresignURL(r.Context(), req.Path, expiresIn, req.URLOptions)
+		switch {
+		case errors.Is(err, errPresignUnsupported), errors.Is(err, errPresignExpiry), errors.Is(err, errPresignNotBefore):
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+			return
+		case err != nil:
+			h.reporter.CaptureError(r, err)
+			respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to presign URL")
+			return
+		}
+	}
+	audit.Annotate(r, req.Path, map[string]string{
+		"expires_at": strconv.FormatInt(resp.ExpiresAt.Unix(), 10),
+		"presigned":  strconv.FormatBool(req.Presigned),
 	})
+
+	respondJSON(w, http.StatusOK, resp)
 }
 
 // PurgeCache triggers Cloudflare cache purge
 func (h *MediaHandler) PurgeCache(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Files []string `json:"files"`
+		Files []string `json:"files" validate:"required,dive,required"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
+	audit.Annotate(r, "", map[string]string{"files": strings.Join(req.Files, ",")})
+
 	// Purge Cloudflare cache
 	err := h.purgeCloudflareCache(req.Files)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to purge cache"})
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to purge cache")
 		return
 	}
 
+	h.events.Publish(events.New(events.AssetPurged, map[string]interface{}{"files": req.Files}))
+
 	respondJSON(w, http.StatusOK, map[string]string{"status": "purged"})
 }
 
 // ListAssets lists objects in R2
 func (h *MediaHandler) ListAssets(w http.ResponseWriter, r *http.Request) {
 	prefix := r.URL.Query().Get("prefix")
-	
-	ctx := r.Context()
-	objects, err := h.r2Client.ListObjects(ctx, prefix, 100)
+
+	objects, err := h.ListObjects(r.Context(), prefix, 100)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to list objects"})
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, objects)
+	// Revalidated on every poll, answered with a 304 while nothing changed
+	w.Header().Set("Cache-Control", "private, no-cache")
+	respondJSONConditional(w, r, objects)
 }
 
 // DeleteAsset deletes an object from R2
@@ -299,10 +731,16 @@ func (h *MediaHandler) DeleteAsset(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["path"]
 
-	ctx := r.Context()
-	err := h.r2Client.DeleteObject(ctx, key)
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete"})
+	if err := h.RemoveAsset(r.Context(), key); err != nil {
+		switch {
+		case errors.Is(err, ErrReservedKey):
+			respondError(w, http.StatusForbidden, apierror.ReservedKey, err.Error())
+		case errors.Is(err, ErrInvalidKey):
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		default:
+			h.reporter.CaptureError(r, err)
+			respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete")
+		}
 		return
 	}
 
@@ -311,36 +749,42 @@ func (h *MediaHandler) DeleteAsset(w http.ResponseWriter, r *http.Request) {
 
 // Helper functions
 
-func (h *MediaHandler) serveRange(w http.ResponseWriter, r *http.Request, key string, rangeHeader string) {
+// serveRange serves part of a variant of an asset
+func (h *MediaHandler) serveRange(w http.ResponseWriter, r *http.Request, v assetVariant, rangeHeader string) {
 	ctx := r.Context()
-	
+
 	// Get object metadata first
-	head, err := h.r2Client.HeadObject(ctx, key)
+	head, err := h.r2Client.HeadObject(ctx, v.objectKey)
 	if err != nil {
-		http.Error(w, "Object not found", http.StatusNotFound)
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
 		return
 	}
 
 	// Parse range header
 	ranges, err := parseRange(rangeHeader, *head.ContentLength)
 	if err != nil || len(ranges) == 0 {
-		http.Error(w, "Invalid range", http.StatusRequestedRangeNotSatisfiable)
+		respondError(w, http.StatusRequestedRangeNotSatisfiable, apierror.RangeNotSatisfiable, "Invalid range")
 		return
 	}
 
 	// Get object with range
-	obj, err := h.r2Client.GetObjectWithRange(ctx, key, rangeHeader)
+	obj, err := h.r2Client.GetObjectWithRange(ctx, v.objectKey, rangeHeader)
 	if err != nil {
-		http.Error(w, "Failed to get range", http.StatusInternalServerError)
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to get range")
 		return
 	}
 	defer obj.Body.Close()
 
-	h.setObjectHeaders(w, obj.ETag, obj.ContentType, obj.ContentLength, obj.LastModified)
+	h.setVariantHeaders(w, v, v.etag(obj.ETag, obj.Metadata))
+	h.setObjectHeaders(w, nil, obj.ContentType, obj.ContentLength, obj.LastModified)
+	setEncodingHeaders(w, v.key, v.encoding)
+	h.sandboxHTML(w, v.key)
 	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ranges[0].start, ranges[0].end, *head.ContentLength))
 	w.WriteHeader(http.StatusPartialContent)
-	
-	io.Copy(w, obj.Body)
+
+	n, _ := io.Copy(w, obj.Body)
+	h.analytics.Record(v.key, n)
 }
 
 func (h *MediaHandler) checkETag(w http.ResponseWriter, r *http.Request, etag *string) bool {
@@ -348,8 +792,7 @@ func (h *MediaHandler) checkETag(w http.ResponseWriter, r *http.Request, etag *s
 		return false
 	}
 
-	ifNoneMatch := r.Header.Get("If-None-Match")
-	if ifNoneMatch != "" && ifNoneMatch == *etag {
+	if etagMatches(r.Header.Get("If-None-Match"), *etag) {
 		w.WriteHeader(http.StatusNotModified)
 		return true
 	}
@@ -373,21 +816,37 @@ func (h *MediaHandler) setObjectHeaders(w http.ResponseWriter, etag *string, con
 	w.Header().Set("Accept-Ranges", "bytes")
 }
 
-func (h *MediaHandler) generateSignature(path string, expires string) string {
-	message := fmt.Sprintf("%s:%s", path, expires)
+func (h *MediaHandler) generateSignature(path string, expires string, opts URLOptions) string {
 	mac := hmac.New(sha256.New, []byte(h.signingSecret))
-	mac.Write([]byte(message))
+	mac.Write([]byte(opts.message(path, expires)))
 	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
 }
 
-func (h *MediaHandler) validateSignature(path string, expires string, signature string) bool {
-	expected := h.generateSignature(path, expires)
+func (h *MediaHandler) validateSignature(path string, expires string, signature string, opts URLOptions) bool {
+	expected := h.generateSignature(path, expires, opts)
 	return hmac.Equal([]byte(expected), []byte(signature))
 }
 
+func (h *MediaHandler) publicCacheControl(key string) string {
+	if h.cacheConfig != nil {
+		if cc := h.cacheConfig().CacheControlFor(key); cc != "" {
+			return h.cacheUntil(key, cc)
+		}
+	}
+	return h.cacheUntil(key, "public, max-age=31536000, immutable")
+}
+
+func (h *MediaHandler) privateCacheControl() string {
+	if h.cacheConfig != nil {
+		if cc := h.cacheConfig().PrivateCacheControl; cc != "" {
+			return cc
+		}
+	}
+	return "private, max-age=3600"
+}
+
 func (h *MediaHandler) purgeCloudflareCache(files []string) error {
-	zoneID := os.Getenv("CLOUDFLARE_ZONE_ID")
-	apiToken := os.Getenv("CLOUDFLARE_API_TOKEN")
+	zoneID, apiToken := h.cfZoneID, h.cfAPIToken
 
 	if zoneID == "" || apiToken == "" {
 		return fmt.Errorf("cloudflare credentials not configured")
@@ -402,7 +861,7 @@ func (h *MediaHandler) purgeCloudflareCache(files []string) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/purge_cache", zoneID)
+	url := fmt.Sprintf("%s/zones/%s/purge_cache", h.cfAPIBase, zoneID)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
@@ -434,22 +893,22 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 	if !strings.HasPrefix(s, "bytes=") {
 		return nil, fmt.Errorf("invalid range")
 	}
-	
+
 	ranges := []httpRange{}
 	for _, ra := range strings.Split(s[6:], ",") {
 		ra = strings.TrimSpace(ra)
 		if ra == "" {
 			continue
 		}
-		
+
 		i := strings.Index(ra, "-")
 		if i < 0 {
 			return nil, fmt.Errorf("invalid range")
 		}
-		
+
 		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
 		var r httpRange
-		
+
 		if start == "" {
 			// suffix range
 			i, err := strconv.ParseInt(end, 10, 64)
@@ -490,3 +949,56 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// respondError answers with an error with a stable code (see apierror)
+func respondError(w http.ResponseWriter, status int, code, message string) {
+	apierror.Write(w, status, code, message)
+}
+
+// decodeJSON decodes the request body into v and checks it against its
+// validate tags (see validation). On failure it answers 400, with every
+// field that failed in details, or 413 for a body over the limit, and
+// returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := validation.Decode(r.Body, v)
+	var fields validation.Errors
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &fields):
+		apierror.WriteDetails(w, http.StatusBadRequest, apierror.ValidationFailed, err.Error(), map[string]interface{}{"fields": fields})
+	case errors.As(err, &tooLarge):
+		respondError(w, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge, "Request body too large")
+	case errors.Is(err, io.EOF):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Request body is empty")
+	default:
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid request body: "+err.Error())
+	}
+	return false
+}
+
+// respondJSONConditional writes data with an ETag hashed from its
+// encoding, answering 304 when the request's If-None-Match already has
+// it. Clients polling a listing only download it again when it changed.
+func respondJSONConditional(w http.ResponseWriter, r *http.Request, data interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(data); err != nil {
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to encode response")
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
@@ -1,13 +1,14 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -41,8 +42,8 @@ func TestGenerateSignature(t *testing.T) {
 	path := "private/test.pdf"
 	expires := "1234567890"
 
-	sig1 := handler.generateSignature(path, expires)
-	sig2 := handler.generateSignature(path, expires)
+	sig1 := handler.generateSignature(path, expires, URLOptions{})
+	sig2 := handler.generateSignature(path, expires, URLOptions{})
 
 	if sig1 != sig2 {
 		t.Error("Signatures should be deterministic")
@@ -61,7 +62,7 @@ func TestValidateSignature(t *testing.T) {
 	path := "private/test.pdf"
 	expires := "1234567890"
 
-	validSig := handler.generateSignature(path, expires)
+	validSig := handler.generateSignature(path, expires, URLOptions{})
 
 	tests := []struct {
 		name      string
@@ -102,7 +103,7 @@ func TestValidateSignature(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := handler.validateSignature(tt.path, tt.expires, tt.signature)
+			got := handler.validateSignature(tt.path, tt.expires, tt.signature, URLOptions{})
 			if got != tt.want {
 				t.Errorf("validateSignature() = %v, want %v", got, tt.want)
 			}
@@ -110,6 +111,44 @@ func TestValidateSignature(t *testing.T) {
 	}
 }
 
+func TestGenerateSignedURLPresigned(t *testing.T) {
+	r2, err := storage.NewR2Client(storage.R2Config{
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		BucketName:      "cdn",
+		Endpoint:        "https://account.r2.cloudflarestorage.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewMediaHandler(r2, "test-secret")
+
+	sign := func(body string) (*httptest.ResponseRecorder, SignedURLResponse) {
+		w := httptest.NewRecorder()
+		h.GenerateSignedURL(w, httptest.NewRequest("POST", "/v1/media/sign", strings.NewReader(body)))
+		var resp SignedURLResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := sign(`{"path": "downloads/big.zip", "expires_in": 600, "presigned": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if !strings.HasPrefix(resp.URL, "https://account.r2.cloudflarestorage.com/cdn/downloads/big.zip?") ||
+		!strings.Contains(resp.URL, "X-Amz-Expires=600") || !strings.Contains(resp.URL, "X-Amz-Signature=") {
+		t.Errorf("presigned URL = %s", resp.URL)
+	}
+
+	if w, _ := sign(`{"path": "downloads/big.zip", "expires_in": 864000, "presigned": true}`); w.Code != http.StatusBadRequest {
+		t.Errorf("10-day presigned URL: status %d, want 400", w.Code)
+	}
+
+	if _, resp := sign(`{"path": "downloads/big.zip"}`); !strings.HasPrefix(resp.URL, publicBaseURL+"/v1/media/private/") {
+		t.Errorf("signed URL = %s", resp.URL)
+	}
+}
+
 func TestParseRange(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -209,3 +248,28 @@ func TestRespondJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestRespondJSONConditional(t *testing.T) {
+	objects := []map[string]string{{"key": "a.png"}, {"key": "b.png"}}
+
+	first := httptest.NewRecorder()
+	respondJSONConditional(first, httptest.NewRequest("GET", "/v1/media/list", nil), objects)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("first response = %d, ETag %q, %d bytes", first.Code, etag, first.Body.Len())
+	}
+
+	req := httptest.NewRequest("GET", "/v1/media/list", nil)
+	req.Header.Set("If-None-Match", etag)
+	poll := httptest.NewRecorder()
+	respondJSONConditional(poll, req, objects)
+	if poll.Code != http.StatusNotModified || poll.Body.Len() != 0 {
+		t.Errorf("unchanged poll = %d with %d bytes, want an empty 304", poll.Code, poll.Body.Len())
+	}
+
+	changed := httptest.NewRecorder()
+	respondJSONConditional(changed, req, append(objects, map[string]string{"key": "c.png"}))
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Errorf("changed listing = %d with ETag %q, want 200 with a new ETag", changed.Code, changed.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/minify"
+)
+
+// minifiedPrefix held minified variants before they moved under
+// derived/. It stays internal so leftover copies are never indexed or
+// overwritten.
+const minifiedPrefix = "_min/"
+
+// maxMinifySize bounds the sources minified on request; larger assets
+// are served as uploaded
+const maxMinifySize = 4 << 20
+
+// minified is the derivation of a minified JS or CSS asset
+func minified(fn minify.Func) derivation {
+	return derivation{transform: "min", maxSize: maxMinifySize, build: func(src []byte) ([]byte, string, error) {
+		out, err := fn(src)
+		return out, "", err
+	}}
+}
+
+// wantsMinified reports whether the request asks for the minified
+// variant of a JS or CSS asset with ?min=1
+func wantsMinified(r *http.Request, key string) (derivation, bool) {
+	if r.URL.Query().Get("min") != "1" {
+		return derivation{}, false
+	}
+	fn, ok := minify.For(key)
+	if !ok {
+		return derivation{}, false
+	}
+	return minified(fn), true
+}
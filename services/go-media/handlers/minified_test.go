@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsMinified(t *testing.T) {
+	tests := []struct {
+		target, key string
+		want        bool
+	}{
+		{"/v1/media/assets/app.js?min=1", "app.js", true},
+		{"/v1/media/assets/site.css?min=1", "site.css", true},
+		{"/v1/media/assets/app.js", "app.js", false},
+		{"/v1/media/assets/app.js?min=0", "app.js", false},
+		{"/v1/media/assets/photo.png?min=1", "photo.png", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if _, got := wantsMinified(r, tt.key); got != tt.want {
+			t.Errorf("wantsMinified(%s) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
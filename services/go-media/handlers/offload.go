@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/offload"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/gorilla/mux"
+)
+
+// Object metadata recording the Cloudflare copy of an upload
+const (
+	offloadServiceMeta = "offload-service"
+	offloadIDMeta      = "offload-id"
+)
+
+// WithOffload also uploads images and videos to Cloudflare Images and
+// Stream, whose URLs are returned for them instead of the R2 copy's
+func WithOffload(c *offload.Client) Option {
+	return func(h *MediaHandler) {
+		h.offload = c
+	}
+}
+
+type OffloadResponse struct {
+	Key string `json:"key"`
+	offload.Asset
+}
+
+// offloadedCopy returns the copy recorded in an object's metadata
+func offloadedCopy(metadata map[string]string) *offload.Asset {
+	service, id := metadata[offloadServiceMeta], metadata[offloadIDMeta]
+	if service == "" || id == "" {
+		return nil
+	}
+	return &offload.Asset{Service: service, ID: id}
+}
+
+// offloadUpload sends data, about to be stored at key with etag, to
+// Cloudflare if its type is offloaded. It returns the new copy, if any,
+// and the one recorded for the current object at key, if any. The
+// current copy is reused when the content is unchanged. Failures leave
+// the upload on R2 only.
+func (h *MediaHandler) offloadUpload(ctx context.Context, key, filename, contentType, etag string, data []byte) (asset, prev *offload.Asset) {
+	service := h.offload.Service(contentType, len(data))
+	if service == "" {
+		return nil, nil
+	}
+
+	if head, err := h.r2Client.HeadObject(ctx, key); err == nil {
+		prev = offloadedCopy(head.Metadata)
+		if prev != nil && head.ETag != nil && strings.Trim(*head.ETag, `"`) == etag {
+			if a, err := h.offload.Status(ctx, prev.Service, prev.ID); err == nil {
+				return a, prev
+			}
+		}
+	}
+
+	a, err := h.offload.Upload(ctx, service, filepath.Base(filename), data)
+	if err != nil {
+		h.reporter.CaptureError(nil, err)
+		return nil, prev
+	}
+	return a, prev
+}
+
+// dropOffloaded deletes a Cloudflare copy no object refers to any more
+func (h *MediaHandler) dropOffloaded(ctx context.Context, a *offload.Asset) {
+	if err := h.offload.Delete(ctx, a.Service, a.ID); err != nil {
+		log.Printf("Offload: failed to delete %s %s: %v", a.Service, a.ID, err)
+	}
+}
+
+// OffloadStatus reports the Cloudflare copy of an asset and its current
+// state, such as whether a video has finished transcoding
+func (h *MediaHandler) OffloadStatus(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["path"]
+	if h.offload == nil {
+		respondError(w, http.StatusNotFound, apierror.NotEnabled, "Offloading is not enabled")
+		return
+	}
+
+	head, err := h.r2Client.HeadObject(r.Context(), key)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Asset not found")
+			return
+		}
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to read asset")
+		return
+	}
+	a := offloadedCopy(head.Metadata)
+	if a == nil {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Asset is not offloaded")
+		return
+	}
+
+	status, err := h.offload.Status(r.Context(), a.Service, a.ID)
+	switch {
+	case errors.Is(err, offload.ErrNotFound):
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Offloaded copy not found")
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusBadGateway, apierror.Upstream, "Failed to fetch offload status")
+		return
+	}
+	respondJSON(w, http.StatusOK, OffloadResponse{Key: key, Asset: *status})
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/fonts"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+const (
+	// cardWidth and cardHeight are the size Open Graph and large
+	// Twitter/X cards are shown at
+	cardWidth  = 1200
+	cardHeight = 630
+
+	// cardPhotoWidth is the width of the image on the right of a card
+	cardPhotoWidth = 480
+
+	// cardPadding surrounds the text
+	cardPadding = 72
+
+	// cardTemplate changes the cache keys of every card when the layout
+	// does
+	cardTemplate = "og1"
+)
+
+// cardThemes are the colors of the card template
+var cardThemes = map[string]struct{ background, text, muted color.RGBA }{
+	"dark":  {color.RGBA{17, 24, 39, 255}, color.RGBA{249, 250, 251, 255}, color.RGBA{156, 163, 175, 255}},
+	"light": {color.RGBA{255, 255, 255, 255}, color.RGBA{17, 24, 39, 255}, color.RGBA{75, 85, 99, 255}},
+}
+
+// socialCard is what a social card shows
+type socialCard struct {
+	Title    string
+	Subtitle string
+	Site     string
+	// Image is the key of an asset shown on the right
+	Image string
+	Theme string
+}
+
+// parseSocialCard reads a card from title, subtitle, site, image and
+// theme
+func parseSocialCard(q url.Values) (socialCard, error) {
+	c := socialCard{
+		Title:    q.Get("title"),
+		Subtitle: q.Get("subtitle"),
+		Site:     q.Get("site"),
+		Image:    q.Get("image"),
+		Theme:    q.Get("theme"),
+	}
+	if c.Theme == "" {
+		c.Theme = "dark"
+	}
+	switch {
+	case c.Title == "":
+		return c, errors.New("title is required")
+	case utf8.RuneCountInString(c.Title) > 200 || utf8.RuneCountInString(c.Subtitle) > 300 || utf8.RuneCountInString(c.Site) > 100:
+		return c, errors.New("title, subtitle and site are limited to 200, 300 and 100 characters")
+	case cardThemes[c.Theme].background.A == 0:
+		return c, errors.New("theme must be dark or light")
+	}
+	if c.Image != "" {
+		if err := ValidateKey(c.Image); err != nil {
+			return c, fmt.Errorf("image: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// SocialCard renders a card for Open Graph and Twitter/X previews: the
+// title, with an optional subtitle and site name, beside an optional
+// asset image. Cards are cached by their parameters and the image's
+// version.
+func (h *MediaHandler) SocialCard(w http.ResponseWriter, r *http.Request) {
+	c, err := parseSocialCard(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	imageETag := ""
+	if c.Image != "" {
+		if h.images == nil || !h.images.Reads(c.Image) {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "image must be a JPEG, PNG or GIF asset, or another type the image engine reads")
+			return
+		}
+		// Only public, published assets can be shown
+		if h.sealed(c.Image) || h.hidden(c.Image) {
+			respondError(w, http.StatusNotFound, apierror.AssetNotFound, "image not found")
+			return
+		}
+		head, err := h.r2Client.HeadObject(r.Context(), c.Image)
+		if err != nil {
+			if !storage.IsNotFound(err) {
+				h.reporter.CaptureError(r, err)
+			}
+			respondError(w, http.StatusNotFound, apierror.AssetNotFound, "image not found")
+			return
+		}
+		if aws.ToInt64(head.ContentLength) > maxImageSize {
+			respondError(w, http.StatusBadRequest, apierror.FileTooLarge, fmt.Sprintf("image is over %d MB", maxImageSize>>20))
+			return
+		}
+		imageETag = aws.ToString(head.ETag)
+	}
+
+	key := generatedKey("og", ".png", cardTemplate, c.Title, c.Subtitle, c.Site, c.Theme, c.Image, imageETag)
+	h.serveGenerated(w, r, key, "image/png", func() ([]byte, error) {
+		var photo image.Image
+		if c.Image != "" {
+			var err error
+			if photo, err = h.cardPhoto(r.Context(), c.Image); err != nil {
+				return nil, err
+			}
+		}
+		return renderSocialCard(c, photo)
+	})
+}
+
+// cardPhoto reads the asset at key cropped to the right of a card
+func (h *MediaHandler) cardPhoto(ctx context.Context, key string) (image.Image, error) {
+	obj, err := h.r2Client.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	src, err := io.ReadAll(io.LimitReader(obj.Body, maxImageSize))
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := h.images.Transform(src, imaging.Options{Width: cardPhotoWidth, Height: cardHeight, Fit: imaging.FitCover, Format: "png"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: image: %v", errRenderInput, err)
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+// renderSocialCard draws c as a PNG. The title gets the largest size at
+// which it fits three lines, and is cut short beyond that; the subtitle
+// gets two.
+func renderSocialCard(c socialCard, photo image.Image) ([]byte, error) {
+	theme := cardThemes[c.Theme]
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.background), image.Point{}, draw.Src)
+
+	textWidth := float64(cardWidth - 2*cardPadding)
+	if photo != nil {
+		area := image.Rect(cardWidth-cardPhotoWidth, 0, cardWidth, cardHeight)
+		draw.Draw(img, area, photo, photo.Bounds().Min, draw.Src)
+		textWidth -= cardPhotoWidth
+	}
+
+	title := fonts.Face{Font: fonts.Bold, Size: 72}
+	lines := title.Wrap(c.Title, textWidth)
+	for title.Size > 44 && len(lines) > 3 {
+		title.Size -= 4
+		lines = title.Wrap(c.Title, textWidth)
+	}
+	lines = fitLines(title, lines, 3, textWidth)
+
+	subtitle := fonts.Face{Font: fonts.Regular, Size: 34}
+	var subLines []string
+	if c.Subtitle != "" {
+		subLines = fitLines(subtitle, subtitle.Wrap(c.Subtitle, textWidth), 2, textWidth)
+	}
+
+	// The title and subtitle are centered in the space above the site
+	site := fonts.Face{Font: fonts.Bold, Size: 28}
+	bottom := float64(cardHeight - cardPadding)
+	if c.Site != "" {
+		site.Draw(img, cardPadding, bottom, site.Truncate(c.Site, textWidth), theme.muted)
+		bottom -= site.LineHeight() + 24
+	}
+	height := float64(len(lines)) * title.LineHeight()
+	if len(subLines) > 0 {
+		height += 24 + float64(len(subLines))*subtitle.LineHeight()
+	}
+	y := cardPadding + max(0, (bottom-cardPadding-height)/2)
+	for _, l := range lines {
+		title.Draw(img, cardPadding, y+title.Ascent(), l, theme.text)
+		y += title.LineHeight()
+	}
+	y += 24
+	for _, l := range subLines {
+		subtitle.Draw(img, cardPadding, y+subtitle.Ascent(), l, theme.muted)
+		y += subtitle.LineHeight()
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitLines keeps the first n lines, ending the last with an ellipsis if
+// any were dropped
+func fitLines(face fonts.Face, lines []string, n int, width float64) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	last := face.Truncate(lines[n-1]+" "+lines[n], width)
+	return append(lines[:n-1], last)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseSocialCard(t *testing.T) {
+	c, err := parseSocialCard(url.Values{"title": {"Launch week"}, "image": {"img/hero.jpg"}})
+	if err != nil || c.Theme != "dark" || c.Image != "img/hero.jpg" {
+		t.Fatalf("parseSocialCard() = %+v, %v", c, err)
+	}
+
+	for name, q := range map[string]url.Values{
+		"no title":       {"subtitle": {"Only a subtitle"}},
+		"long title":     {"title": {strings.Repeat("a", 201)}},
+		"unknown theme":  {"title": {"a"}, "theme": {"neon"}},
+		"invalid image":  {"title": {"a"}, "image": {"/img/hero.jpg"}},
+		"reserved image": {"title": {"a"}, "image": {"derived/img/hero.jpg"}},
+	} {
+		if _, err := parseSocialCard(q); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestRenderSocialCard(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, cardPhotoWidth, cardHeight))
+	for i := range photo.Pix {
+		photo.Pix[i] = 0xff // white
+	}
+	data, err := renderSocialCard(socialCard{Title: strings.Repeat("A very long title ", 12), Subtitle: "Subtitle", Site: "mikeodnis.dev", Theme: "dark"}, photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != cardWidth || b.Dy() != cardHeight {
+		t.Errorf("size = %v", b)
+	}
+	if got := color.RGBAModel.Convert(img.At(2, 2)); got != cardThemes["dark"].background {
+		t.Errorf("background = %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(cardWidth-2, 2)); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("photo = %v", got)
+	}
+}
+
+func TestGeneratedKey(t *testing.T) {
+	a := generatedKey("og", ".png", "og1", "Title", "")
+	if !strings.HasPrefix(a, "generated/og/") || !strings.HasSuffix(a, ".png") || a != generatedKey("og", ".png", "og1", "Title", "") {
+		t.Errorf("generatedKey() = %q", a)
+	}
+	// Parameters are separated, so moving text between them changes the key
+	if a == generatedKey("og", ".png", "og1", "Titl", "e") {
+		t.Error("different parameters share a key")
+	}
+	if !internalKey(a) {
+		t.Error("generated images aren't internal")
+	}
+}
+
+func TestSocialCardBadRequest(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+	for _, target := range []string{
+		"/v1/media/og",
+		// No image engine reads it
+		"/v1/media/og?title=Launch&image=docs/brief.pdf",
+	} {
+		w := httptest.NewRecorder()
+		h.SocialCard(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, w.Code)
+		}
+	}
+}
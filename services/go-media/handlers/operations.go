@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/offload"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Asset operations shared by the HTTP handlers and the gRPC service
+
+// Upload validation errors, reported to clients as bad requests
+var (
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+	ErrInvalidFilename    = errors.New("invalid filename")
+)
+
+var allowedUploadExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".pdf": true, ".svg": true, ".mp4": true, ".webm": true, ".mp3": true,
+	".wav": true, ".flac": true, ".ogg": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true,
+	".zip": true, ".json": true, ".txt": true, ".csv": true,
+	".heic": true, ".heif": true,
+	".ttf": true, ".otf": true, ".woff2": true,
+}
+
+// extContentTypes are the types of uploads http.DetectContentType
+// doesn't recognize, and clients often send as application/octet-stream.
+// Office documents are ZIP archives as far as it can tell.
+var extContentTypes = map[string]string{
+	".heic":  "image/heic",
+	".heif":  "image/heif",
+	".flac":  "audio/flac",
+	".docx":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx":  "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":   "application/vnd.oasis.opendocument.text",
+	".ods":   "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":   "application/vnd.oasis.opendocument.presentation",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff2": "font/woff2",
+}
+
+// uploadContentType returns the type to store an upload to key with:
+// the declared one, else the detected one, unless that is the generic
+// application/octet-stream or application/zip and key's extension says
+// better
+func uploadContentType(key, declared string, data []byte) string {
+	if declared == "" {
+		declared = http.DetectContentType(data)
+	}
+	if t := extContentTypes[strings.ToLower(filepath.Ext(key))]; t != "" && (declared == "application/octet-stream" || declared == "application/zip") {
+		return t
+	}
+	return declared
+}
+
+// UploadKey validates filename and returns the content-hash key that
+// data is stored under
+func UploadKey(filename string, data []byte) (string, error) {
+	return StrategyKey(KeyHash, filename, data)
+}
+
+// StoreUpload writes data under key (from UploadKey), updates the index
+// and publishes an upload event. An empty contentType is detected from
+// the content.
+func (h *MediaHandler) StoreUpload(ctx context.Context, key, filename, contentType string, data []byte) (*UploadResponse, error) {
+	return h.StoreUploadIfMatch(ctx, key, filename, contentType, "", data)
+}
+
+// StoreUploadIfMatch is StoreUpload for a client that read the object at
+// ETag ifMatch: the write fails, with an error storage.IsPreconditionFailed
+// recognizes, if the object has changed since
+func (h *MediaHandler) StoreUploadIfMatch(ctx context.Context, key, filename, contentType, ifMatch string, data []byte) (*UploadResponse, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	contentType = uploadContentType(key, contentType, data)
+	size := len(data)
+	digests := contentDigests(data)
+
+	var metadata map[string]string
+	sealed := h.sealed(key)
+	// Encrypted content is kept out of the index, text and all
+	var text string
+	if !sealed && !storage.HasCustomerKey(ctx) {
+		text = h.searchText(contentType, data)
+	}
+	if sealed {
+		var err error
+		if data, metadata, err = h.sealer.Seal(ctx, data); err != nil {
+			return nil, fmt.Errorf("encrypting %s: %w", key, err)
+		}
+	}
+
+	// R2's ETag for a single PUT is the MD5 of the content
+	sum := md5.Sum(data)
+	etag := hex.EncodeToString(sum[:])
+
+	// Encrypted content never leaves the bucket, and drafts and embargoed
+	// content don't go where their URL would be public at once
+	customerKey := storage.HasCustomerKey(ctx)
+	schedule := publishScheduleFrom(ctx)
+	var asset, prev *offload.Asset
+	if !sealed && !customerKey && !schedule.withheld() {
+		asset, prev = h.offloadUpload(ctx, key, filename, contentType, etag, data)
+	}
+	if asset != nil {
+		metadata = map[string]string{offloadServiceMeta: asset.Service, offloadIDMeta: asset.ID}
+	}
+	if !schedule.isZero() {
+		if metadata == nil {
+			metadata = make(map[string]string, 2)
+		}
+		for k, v := range schedule.metadata() {
+			metadata[k] = v
+		}
+	}
+	// Digests of what clients download. A client-supplied key keeps even
+	// a hash of the content from being stored in the clear.
+	if !customerKey {
+		if metadata == nil {
+			metadata = make(map[string]string, len(digests))
+		}
+		for k, v := range digests {
+			metadata[k] = v
+		}
+	}
+
+	var err error
+	if h.uploader != nil && ifMatch == "" && int64(len(data)) > h.uploader.PartSize {
+		// Large files go up as parallel parts. R2's ETag for them isn't
+		// the content MD5, so the integrity audit passes over them.
+		var res *storage.UploadResult
+		if res, err = h.uploader.Upload(ctx, key, bytes.NewReader(data), contentType, metadata); err == nil {
+			etag = res.ETag
+		}
+	} else {
+		err = h.r2Client.PutObjectIfMatch(ctx, key, bytes.NewReader(data), contentType, metadata, ifMatch)
+	}
+	if err != nil {
+		if asset != nil && (prev == nil || asset.ID != prev.ID) {
+			h.dropOffloaded(ctx, asset)
+		}
+		return nil, err
+	}
+	if prev != nil && (asset == nil || asset.ID != prev.ID) {
+		h.dropOffloaded(ctx, prev)
+	}
+	if asset != nil && !asset.Final() {
+		h.offload.Watch(key, *asset)
+	}
+
+	if h.index != nil {
+		h.index.Update(key, func(e *index.Entry) {
+			e.Size = int64(len(data))
+			e.ContentType = contentType
+			e.ETag = etag
+			e.Integrity = integrity(digests)
+			if customerKey {
+				// R2's ETag for an SSE-C object isn't the content MD5
+				e.ETag, e.Integrity = "", ""
+			}
+			e.CustomerKey = customerKey
+			e.Text = text
+			e.State, e.PublishAt, e.UnpublishAt = schedule.State, schedule.PublishAt, schedule.UnpublishAt
+			e.UpdatedAt = time.Now().UTC()
+		})
+	}
+
+	url := h.assetURL(ctx, key, sealed || customerKey)
+	if !sealed && !customerKey && isHTML(contentType) {
+		url = h.htmlURL(ctx, key)
+	}
+	eventData := map[string]interface{}{
+		"key":          key,
+		"url":          url,
+		"size":         size,
+		"content_type": contentType,
+		"filename":     filepath.Base(filename),
+	}
+	resp := &UploadResponse{URL: url, Key: key, Offload: asset, State: schedule.State, PublishAt: schedule.PublishAt, UnpublishAt: schedule.UnpublishAt}
+	if !sealed && !customerKey {
+		resp.Video = h.queueTranscode(key, contentType, etag, videoPresetFrom(ctx))
+	}
+	if asset != nil {
+		eventData["offload"] = asset
+		if asset.URL != "" {
+			resp.URL = asset.URL
+		}
+	}
+	h.events.Publish(events.New(events.AssetUploaded, eventData))
+
+	return resp, nil
+}
+
+// ExistingUpload returns the response for an upload whose content is
+// already stored at key, its content-hash key, so that repeating it can
+// skip the write. It returns nil when the object is missing, holds other
+// content, or can't be checked.
+func (h *MediaHandler) ExistingUpload(ctx context.Context, key string, data []byte) *UploadResponse {
+	if storage.HasCustomerKey(ctx) {
+		// The stored copy may be encrypted with someone else's key
+		return nil
+	}
+	head, err := h.r2Client.HeadObject(ctx, key)
+	if err != nil || !storedContent(head, data) {
+		return nil
+	}
+
+	resp := &UploadResponse{
+		URL:          h.assetURL(ctx, key, envelope.Sealed(head.Metadata)),
+		Key:          key,
+		ETag:         strings.Trim(aws.ToString(head.ETag), `"`),
+		Deduplicated: true,
+	}
+	if !envelope.Sealed(head.Metadata) && isHTML(aws.ToString(head.ContentType)) {
+		resp.URL = h.htmlURL(ctx, key)
+	}
+	if prev := offloadedCopy(head.Metadata); prev != nil && h.offload != nil {
+		if a, err := h.offload.Status(ctx, prev.Service, prev.ID); err == nil {
+			resp.Offload = a
+			if a.URL != "" {
+				resp.URL = a.URL
+			}
+		}
+	}
+	return resp
+}
+
+// storedContent reports whether the object described by head holds data,
+// by the SHA-256 recorded at upload or, for objects stored before that,
+// an ETag that is the content MD5
+func storedContent(head *s3.HeadObjectOutput, data []byte) bool {
+	digests := contentDigests(data)
+	if sum := head.Metadata[sha256Meta]; sum != "" {
+		return sum == digests[sha256Meta]
+	}
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	return !envelope.Sealed(head.Metadata) && etag == digests[md5Meta]
+}
+
+// SignURL creates a URL granting access to the private object at path
+// for expiresIn, served with opts' header overrides
+func (h *MediaHandler) SignURL(path string, expiresIn time.Duration, opts URLOptions) SignedURLResponse {
+	return h.signURL(h.keyBaseURL(path, h.publicBase), path, expiresIn, opts)
+}
+
+// signURL is SignURL on the host at base. The signature doesn't cover
+// the host, so every region accepts it.
+func (h *MediaHandler) signURL(base, path string, expiresIn time.Duration, opts URLOptions) SignedURLResponse {
+	expiresAt := time.Now().Add(expiresIn)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	signature := h.generateSignature(path, expires, opts)
+
+	return SignedURLResponse{
+		URL: fmt.Sprintf("%s/v1/media/private/%s?exp=%s&sig=%s%s",
+			base, path, expires, signature, opts.query()),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Errors for presigned URLs the request can't have
+var (
+	errPresignUnsupported = errors.New("encrypted objects can't be presigned; use a signed URL")
+	errPresignExpiry      = errors.New("presigned URLs may be valid for at most 7 days")
+	errPresignNotBefore   = errors.New("presigned URLs can't have a not-before time")
+)
+
+// PresignURL creates an R2 presigned URL for path, which clients
+// download from R2 directly so the bytes bypass the service. R2 applies
+// the header overrides. Objects the service decrypts on the way out
+// can't be presigned.
+func (h *MediaHandler) PresignURL(ctx context.Context, path string, expiresIn time.Duration, opts URLOptions) (SignedURLResponse, error) {
+	if expiresIn <= 0 || expiresIn > storage.MaxPresignExpiry {
+		return SignedURLResponse{}, errPresignExpiry
+	}
+	if h.sealed(path) {
+		return SignedURLResponse{}, errPresignUnsupported
+	}
+	if opts.NotBefore != 0 {
+		return SignedURLResponse{}, errPresignNotBefore
+	}
+	expiresAt := time.Now().Add(expiresIn)
+	url, err := h.r2Client.PresignGetObject(ctx, path, expiresIn, opts.disposition(), opts.ContentType)
+	if err != nil {
+		return SignedURLResponse{}, err
+	}
+	return SignedURLResponse{URL: url, ExpiresAt: expiresAt}, nil
+}
+
+// RemoveAsset deletes key from storage and the index and publishes a
+// delete event. With the trash enabled the object is kept there first,
+// and isn't deleted if it can't be.
+func (h *MediaHandler) RemoveAsset(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	var offloaded *offload.Asset
+	if h.offload != nil {
+		if head, err := h.r2Client.HeadObject(ctx, key); err == nil {
+			offloaded = offloadedCopy(head.Metadata)
+		}
+	}
+	if err := h.trashObject(ctx, key); err != nil {
+		return fmt.Errorf("moving to trash: %w", err)
+	}
+
+	if err := h.r2Client.DeleteObject(ctx, key); err != nil {
+		return err
+	}
+	if offloaded != nil {
+		h.dropOffloaded(ctx, offloaded)
+	}
+	if derivable(key) {
+		// Best effort: a stale variant is never served once its
+		// original is gone
+		h.removeDerived(ctx, key)
+	}
+
+	if h.index != nil {
+		h.index.Delete(key)
+	}
+	if h.collections != nil {
+		if err := h.collections.RemoveKey(key); err != nil {
+			log.Printf("Collections: failed to drop deleted %s: %v", key, err)
+		}
+	}
+	h.events.Publish(events.New(events.AssetDeleted, map[string]interface{}{"key": key}))
+	return nil
+}
+
+// ListObjects lists up to limit objects under prefix
+func (h *MediaHandler) ListObjects(ctx context.Context, prefix string, limit int32) ([]storage.Object, error) {
+	return h.r2Client.ListObjects(ctx, prefix, limit)
+}
+
+// OpenAsset fetches an object for streaming, decrypted if it is sealed.
+// Callers report the bytes they served with RecordServed.
+func (h *MediaHandler) OpenAsset(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	return h.OpenAssetRange(ctx, key, "")
+}
+
+// ListPage lists one page of objects with pagination and delimiters
+func (h *MediaHandler) ListPage(ctx context.Context, opts storage.ListOptions) (*storage.ListPage, error) {
+	return h.r2Client.ListObjectsPage(ctx, opts)
+}
+
+// HeadAsset returns an object's metadata, with a sealed object's
+// plaintext length
+func (h *MediaHandler) HeadAsset(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	head, err := h.r2Client.HeadObject(ctx, key)
+	if err == nil && envelope.Sealed(head.Metadata) && head.ContentLength != nil {
+		head.ContentLength = aws.Int64(*head.ContentLength - envelope.Overhead)
+	}
+	return head, err
+}
+
+// OpenAssetRange fetches a byte range (an HTTP Range header value) of an
+// object; an empty range fetches the whole object
+func (h *MediaHandler) OpenAssetRange(ctx context.Context, key, byteRange string) (*s3.GetObjectOutput, error) {
+	if h.sealed(key) {
+		return h.openSealedRange(ctx, key, byteRange)
+	}
+	return h.r2Client.GetObjectWithRange(ctx, key, byteRange)
+}
+
+// RecordServed adds n served bytes to the analytics for key
+func (h *MediaHandler) RecordServed(key string, n int64) {
+	h.analytics.Record(key, n)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// WithParallelUploads writes uploads larger than partSize to R2 as a
+// multipart upload of that part size, concurrency parts at a time
+func WithParallelUploads(partSize int64, concurrency int) Option {
+	return func(h *MediaHandler) {
+		h.uploader = storage.NewUploader(h.r2Client, partSize, concurrency)
+	}
+}
+
+// WithParallelDownloads fetches large objects from R2 as parallel byte
+// ranges, as configured by cfg
+func WithParallelDownloads(cfg config.DownloadConfig) Option {
+	return func(h *MediaHandler) {
+		h.downloads = cfg
+	}
+}
+
+// parallelBody returns the body to send for a full GET of objectKey,
+// whose plain GetObject response is body. Objects over the configured
+// threshold are sent as the first part of body followed by ranges fetched
+// in parallel; anything else is body itself.
+func (h *MediaHandler) parallelBody(ctx context.Context, objectKey string, body io.ReadCloser, size int64, etag *string) io.ReadCloser {
+	d := h.downloads
+	if d.ParallelThresholdBytes <= 0 || size < int64(d.ParallelThresholdBytes) || etag == nil {
+		return body
+	}
+
+	fetch := func(ctx context.Context, start, end int64) ([]byte, error) {
+		obj, err := h.r2Client.GetObjectPart(ctx, objectKey, fmt.Sprintf("bytes=%d-%d", start, end), *etag)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		return io.ReadAll(obj.Body)
+	}
+	return newParallelReader(ctx, body, size, int64(d.PartBytes), d.Concurrency, fetch)
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// parallelReader reads an object of a known size in order while
+// fetching up to concurrency parts ahead. The first part comes from an
+// already open stream of the whole object, which is then closed. At
+// most concurrency parts are buffered in memory.
+type parallelReader struct {
+	cancel      context.CancelFunc
+	ctx         context.Context
+	fetch       func(ctx context.Context, start, end int64) ([]byte, error)
+	size        int64
+	partSize    int64
+	concurrency int
+
+	first     io.ReadCloser
+	firstLeft int64
+
+	next    int64 // offset of the next part to fetch
+	pending []chan partResult
+	buf     []byte
+}
+
+func newParallelReader(ctx context.Context, first io.ReadCloser, size, partSize int64, concurrency int, fetch func(ctx context.Context, start, end int64) ([]byte, error)) *parallelReader {
+	ctx, cancel := context.WithCancel(ctx)
+	if partSize > size {
+		partSize = size
+	}
+	r := &parallelReader{
+		cancel:      cancel,
+		ctx:         ctx,
+		fetch:       fetch,
+		size:        size,
+		partSize:    partSize,
+		concurrency: concurrency,
+		first:       first,
+		firstLeft:   partSize,
+		next:        partSize,
+	}
+	r.fill()
+	return r
+}
+
+// fill starts fetching parts until concurrency are in flight or
+// buffered
+func (r *parallelReader) fill() {
+	for len(r.pending) < r.concurrency && r.next < r.size {
+		start, end := r.next, r.next+r.partSize-1
+		if end >= r.size {
+			end = r.size - 1
+		}
+		r.next = end + 1
+
+		ch := make(chan partResult, 1)
+		r.pending = append(r.pending, ch)
+		go func() {
+			data, err := r.fetch(r.ctx, start, end)
+			if err == nil && int64(len(data)) != end-start+1 {
+				err = fmt.Errorf("range %d-%d: got %d bytes: %w", start, end, len(data), io.ErrUnexpectedEOF)
+			}
+			ch <- partResult{data, err}
+		}()
+	}
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	if r.first != nil {
+		if r.firstLeft > 0 {
+			if int64(len(p)) > r.firstLeft {
+				p = p[:r.firstLeft]
+			}
+			n, err := r.first.Read(p)
+			r.firstLeft -= int64(n)
+			if err == io.EOF && r.firstLeft > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil && err != io.EOF {
+				return n, err
+			}
+			return n, nil
+		}
+		// The rest of the stream is fetched in parts instead
+		r.first.Close()
+		r.first = nil
+	}
+
+	for len(r.buf) == 0 {
+		if len(r.pending) == 0 {
+			return 0, io.EOF
+		}
+		res := <-r.pending[0]
+		r.pending = r.pending[1:]
+		if res.err != nil {
+			return 0, res.err
+		}
+		r.buf = res.data
+		r.fill()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close stops outstanding fetches and closes the first stream
+func (r *parallelReader) Close() error {
+	r.cancel()
+	if r.first != nil {
+		r.first.Close()
+		r.first = nil
+	}
+	return nil
+}
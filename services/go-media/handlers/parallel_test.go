@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelReader(t *testing.T) {
+	object := make([]byte, 10000)
+	for i := range object {
+		object[i] = byte(i * 7)
+	}
+
+	var fetches, inFlight, maxInFlight int32
+	fetch := func(ctx context.Context, start, end int64) ([]byte, error) {
+		atomic.AddInt32(&fetches, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		return append([]byte(nil), object[start:end+1]...), nil
+	}
+
+	first := io.NopCloser(bytes.NewReader(object))
+	r := newParallelReader(context.Background(), first, int64(len(object)), 1024, 3, fetch)
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, object) {
+		t.Fatal("stitched bytes differ from the object")
+	}
+	// 10 parts; the first comes from the open stream
+	if fetches != 9 {
+		t.Errorf("fetched %d ranges, want 9", fetches)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("%d fetches in flight, want at most 3", maxInFlight)
+	}
+}
+
+func TestParallelReaderErrors(t *testing.T) {
+	object := make([]byte, 4096)
+	failing := errors.New("precondition failed")
+
+	tests := []struct {
+		name  string
+		fetch func(ctx context.Context, start, end int64) ([]byte, error)
+		want  error
+	}{
+		{"part error", func(ctx context.Context, start, end int64) ([]byte, error) {
+			return nil, failing
+		}, failing},
+		{"short part", func(ctx context.Context, start, end int64) ([]byte, error) {
+			return object[start:end], nil
+		}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		r := newParallelReader(context.Background(), io.NopCloser(bytes.NewReader(object)), int64(len(object)), 1024, 2, tt.fetch)
+		_, err := io.ReadAll(r)
+		r.Close()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// A first stream that ends early is an error too
+	short := io.NopCloser(bytes.NewReader(object[:100]))
+	r := newParallelReader(context.Background(), short, int64(len(object)), 1024, 2, tests[0].fetch)
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short first stream: err = %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/fonts"
+)
+
+const (
+	// maxPlaceholderSide bounds each side of a placeholder, and so the
+	// memory rendering one takes
+	maxPlaceholderSide = 4096
+
+	defaultPlaceholderBackground = "e5e7eb"
+)
+
+// placeholder is a solid image with centered text
+type placeholder struct {
+	Width, Height int
+	// Background is six hex digits
+	Background string
+	Text       string
+}
+
+// parsePlaceholder reads a placeholder from a WIDTHxHEIGHT size and the
+// bg and text parameters. The text defaults to the size.
+func parsePlaceholder(size string, q url.Values) (placeholder, error) {
+	p := placeholder{Text: q.Get("text")}
+	w, h, ok := strings.Cut(size, "x")
+	var err error
+	if ok {
+		if p.Width, err = strconv.Atoi(w); err == nil {
+			p.Height, err = strconv.Atoi(h)
+		}
+	}
+	if !ok || err != nil || p.Width < 1 || p.Height < 1 || p.Width > maxPlaceholderSide || p.Height > maxPlaceholderSide {
+		return p, fmt.Errorf("size must be WIDTHxHEIGHT, each from 1 to %d pixels", maxPlaceholderSide)
+	}
+
+	if p.Background, ok = hexColor(q.Get("bg"), defaultPlaceholderBackground); !ok {
+		return p, errors.New("bg must be a hex color such as ccc or 1f2937, without the #")
+	}
+
+	if p.Text == "" {
+		p.Text = fmt.Sprintf("%d×%d", p.Width, p.Height)
+	}
+	if utf8.RuneCountInString(p.Text) > 100 {
+		return p, errors.New("text is limited to 100 characters")
+	}
+	return p, nil
+}
+
+// hexColor normalizes a color given as three or six hex digits, without
+// the #, to six lowercase digits. It returns def for "".
+func hexColor(s, def string) (string, bool) {
+	switch len(s) {
+	case 0:
+		return def, true
+	case 3:
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	s = strings.ToLower(s)
+	_, err := hex.DecodeString(s)
+	return s, err == nil && len(s) == 6
+}
+
+// rgba returns the opaque color of six hex digits from hexColor
+func rgba(hexColor string) color.RGBA {
+	b, _ := hex.DecodeString(hexColor)
+	return color.RGBA{b[0], b[1], b[2], 255}
+}
+
+// colors returns the background and a text color that stands out on it
+func (p placeholder) colors() (bg, fg color.RGBA) {
+	bg = rgba(p.Background)
+	// Rec. 601 luma
+	if 299*int(bg.R)+587*int(bg.G)+114*int(bg.B) > 128*1000 {
+		return bg, color.RGBA{75, 85, 99, 255}
+	}
+	return bg, color.RGBA{229, 231, 235, 255}
+}
+
+// face returns the largest face, up to a fifth of the shorter side, at
+// which the text takes at most 80% of the width
+func (p placeholder) face() fonts.Face {
+	f := fonts.Face{Font: fonts.Bold, Size: float64(min(p.Width, p.Height)) / 5}
+	if w := f.Measure(p.Text); w > 0.8*float64(p.Width) {
+		f.Size *= 0.8 * float64(p.Width) / w
+	}
+	f.Size = max(f.Size, 6)
+	return f
+}
+
+// Placeholder renders a solid image of the size in the path, with text
+// centered on it, for stubbing layouts before the real images exist. It
+// is a PNG or, with format=svg, an SVG, cached by its parameters.
+func (h *MediaHandler) Placeholder(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePlaceholder(mux.Vars(r)["size"], r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	format, contentType := "png", "image/png"
+	switch r.URL.Query().Get("format") {
+	case "", "png":
+	case "svg":
+		format, contentType = "svg", "image/svg+xml"
+	default:
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "format must be png or svg")
+		return
+	}
+
+	key := generatedKey("placeholder", "."+format, strconv.Itoa(p.Width), strconv.Itoa(p.Height), p.Background, p.Text)
+	h.serveGenerated(w, r, key, contentType, func() ([]byte, error) {
+		if format == "svg" {
+			return placeholderSVG(p), nil
+		}
+		return renderPlaceholder(p)
+	})
+}
+
+// renderPlaceholder draws p as a PNG
+func renderPlaceholder(p placeholder) ([]byte, error) {
+	bg, fg := p.colors()
+	img := image.NewRGBA(image.Rect(0, 0, p.Width, p.Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	face := p.face()
+	text := face.Truncate(p.Text, float64(p.Width))
+	x := (float64(p.Width) - face.Measure(text)) / 2
+	// Centered between the ascent and descent
+	descent := face.LineHeight() - face.Ascent()
+	face.Draw(img, x, (float64(p.Height)+face.Ascent()-descent)/2, text, fg)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// placeholderSVG draws p as an SVG, its text in the reader's sans-serif
+// font
+func placeholderSVG(p placeholder) []byte {
+	bg, fg := p.colors()
+	var text bytes.Buffer
+	xml.EscapeText(&text, []byte(p.Text))
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<rect width="100%%" height="100%%" fill="#%02x%02x%02x"/>`+
+		`<text x="50%%" y="50%%" fill="#%02x%02x%02x" font-family="DejaVu Sans, Verdana, sans-serif" font-weight="bold" font-size="%.1f" text-anchor="middle" dominant-baseline="central">%s</text></svg>`+"\n",
+		p.Width, p.Height, p.Width, p.Height, bg.R, bg.G, bg.B, fg.R, fg.G, fg.B, p.face().Size, text.String()))
+}
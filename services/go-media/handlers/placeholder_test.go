@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParsePlaceholder(t *testing.T) {
+	p, err := parsePlaceholder("600x400", url.Values{"bg": {"ABC"}})
+	if err != nil || p.Width != 600 || p.Height != 400 || p.Background != "aabbcc" || p.Text != "600×400" {
+		t.Fatalf("parsePlaceholder() = %+v, %v", p, err)
+	}
+	if p, _ := parsePlaceholder("1x1", nil); p.Background != defaultPlaceholderBackground {
+		t.Errorf("default background = %q", p.Background)
+	}
+
+	for _, tt := range []struct {
+		size string
+		q    url.Values
+	}{
+		{"600", nil},
+		{"600x", nil},
+		{"0x400", nil},
+		{"5000x400", nil},
+		{"600x-1", nil},
+		{"600x400", url.Values{"bg": {"#ccc"}}},
+		{"600x400", url.Values{"bg": {"red"}}},
+		{"600x400", url.Values{"text": {strings.Repeat("a", 101)}}},
+	} {
+		if _, err := parsePlaceholder(tt.size, tt.q); err == nil {
+			t.Errorf("%s %v: accepted", tt.size, tt.q)
+		}
+	}
+}
+
+func TestRenderPlaceholder(t *testing.T) {
+	p := placeholder{Width: 300, Height: 120, Background: "1f2937", Text: "Hero image"}
+	data, err := renderPlaceholder(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 120 {
+		t.Errorf("size = %v", b)
+	}
+	bg, fg := p.colors()
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != bg {
+		t.Errorf("background = %v", got)
+	}
+	// Light text on a dark background, somewhere in the middle
+	drawn := false
+	for x := 0; x < 300 && !drawn; x++ {
+		drawn = color.RGBAModel.Convert(img.At(x, 60)) == fg
+	}
+	if !drawn {
+		t.Error("no text across the middle")
+	}
+
+	svg := string(placeholderSVG(placeholder{Width: 10, Height: 10, Background: "ffffff", Text: "<b>"}))
+	if !strings.Contains(svg, "&lt;b&gt;") || !strings.Contains(svg, `fill="#ffffff"`) {
+		t.Errorf("placeholderSVG() = %s", svg)
+	}
+}
+
+func TestPlaceholderBadRequest(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+	for _, target := range []string{
+		"/v1/media/placeholder/600by400",
+		"/v1/media/placeholder/600x400?format=gif",
+	} {
+		r := httptest.NewRequest("GET", target, nil)
+		r = mux.SetURLVars(r, map[string]string{"size": strings.TrimPrefix(r.URL.Path, "/v1/media/placeholder/")})
+		w := httptest.NewRecorder()
+		h.Placeholder(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, w.Code)
+		}
+	}
+}
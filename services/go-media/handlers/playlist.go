@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxSignedPlaylist bounds the playlists the private path rewrites; a VOD
+// media playlist of several hours is well under it
+const maxSignedPlaylist = 4 << 20
+
+// playlistURIAttr matches the URIs tags such as EXT-X-MAP and EXT-X-MEDIA
+// carry as attributes
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// isPlaylist reports whether contentType is an HLS playlist's
+func isPlaylist(contentType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl":
+		return true
+	}
+	return false
+}
+
+// signPlaylist rewrites the HLS playlist stored at key so each relative
+// URI in it, whether a media playlist, init segment or segment, carries
+// its own signature expiring with the playlist's. Copying a segment URL
+// out of a protected stream then shares that segment only, and only
+// until expires. Absolute URIs point elsewhere and are left alone.
+func (h *MediaHandler) signPlaylist(key string, data []byte, expires string) []byte {
+	sign := func(uri string) string {
+		if uri == "" || strings.HasPrefix(uri, "/") || strings.Contains(uri, "://") || strings.ContainsAny(uri, "?#") {
+			return uri
+		}
+		target := path.Join(path.Dir(key), uri)
+		return uri + "?exp=" + expires + "&sig=" + h.generateSignature(target, expires, URLOptions{})
+	}
+
+	var out bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), maxSignedPlaylist)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+			line = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + sign(playlistURIAttr.FindStringSubmatch(attr)[1]) + `"`
+			})
+		case strings.TrimSpace(line) != "":
+			line = sign(strings.TrimSpace(line))
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignPlaylist(t *testing.T) {
+	h := &MediaHandler{signingSecret: "test-secret"}
+	master := `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_a0",NAME="audio-aac-128k",URI="hls-standard_audio-aac-128k.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=3080000,RESOLUTION=1280x720,AUDIO="group_a0"
+hls-standard_720p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=880000,RESOLUTION=640x360
+https://other.example/360p.m3u8
+`
+	got := string(h.signPlaylist("derived/clips/a.mp4/9b2c/hls-standard", []byte(master), "1900000000"))
+
+	sig := func(file string) string {
+		return "?exp=1900000000&sig=" + h.generateSignature("derived/clips/a.mp4/9b2c/"+file, "1900000000", URLOptions{})
+	}
+	for _, want := range []string{
+		`URI="hls-standard_audio-aac-128k.m3u8` + sig("hls-standard_audio-aac-128k.m3u8") + `"`,
+		"\nhls-standard_720p.m3u8" + sig("hls-standard_720p.m3u8") + "\n",
+		// Absolute URIs aren't ours to sign
+		"\nhttps://other.example/360p.m3u8\n",
+		"#EXT-X-STREAM-INF:BANDWIDTH=880000,RESOLUTION=640x360\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("signed playlist missing %q:\n%s", want, got)
+		}
+	}
+
+	media := "#EXTM3U\n#EXT-X-MAP:URI=\"hls-standard_720p_init.mp4\"\n#EXTINF:6.000000,\nhls-standard_720p_0000.m4s\n#EXT-X-ENDLIST\n"
+	got = string(h.signPlaylist("derived/clips/a.mp4/9b2c/hls-standard_720p.m3u8", []byte(media), "1900000000"))
+	for _, want := range []string{
+		`#EXT-X-MAP:URI="hls-standard_720p_init.mp4` + sig("hls-standard_720p_init.mp4") + `"`,
+		"\nhls-standard_720p_0000.m4s" + sig("hls-standard_720p_0000.m4s") + "\n",
+		"#EXTINF:6.000000,\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("signed media playlist missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestIsPlaylist(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/vnd.apple.mpegurl":        true,
+		"application/x-mpegURL; charset=utf-8": true,
+		"video/iso.segment":                    false,
+		"application/dash+xml":                 false,
+		"text/plain":                           false,
+	} {
+		if got := isPlaylist(contentType); got != want {
+			t.Errorf("isPlaylist(%q) = %v", contentType, got)
+		}
+	}
+}
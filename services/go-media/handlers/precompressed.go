@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// precompressedEncodings are the sidecar files looked for next to an
+// asset, in order of preference
+var precompressedEncodings = []struct {
+	encoding, suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// precompressibleTypes are the asset types build pipelines ship
+// precompressed, with the Content-Type to serve their sidecars as
+var precompressibleTypes = map[string]string{
+	".js":   "text/javascript; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".svg":  "image/svg+xml",
+	".json": "application/json",
+	".map":  "application/json",
+	".xml":  "application/xml",
+	".txt":  "text/plain; charset=utf-8",
+	".wasm": "application/wasm",
+}
+
+// precompressedVariant returns the object to serve for key: a sidecar
+// such as "app.js.br" with its encoding when the client accepts it and
+// one exists, or key itself with an empty encoding
+func (h *MediaHandler) precompressedVariant(ctx context.Context, r *http.Request, key string) (string, string) {
+	if _, ok := precompressibleTypes[strings.ToLower(path.Ext(key))]; !ok {
+		return key, ""
+	}
+	accept := r.Header.Get("Accept-Encoding")
+	if accept == "" {
+		return key, ""
+	}
+
+	for _, pe := range precompressedEncodings {
+		if !acceptsEncoding(accept, pe.encoding) {
+			continue
+		}
+		_, err := h.r2Client.HeadObject(ctx, key+pe.suffix)
+		if err == nil {
+			return key + pe.suffix, pe.encoding
+		}
+		if !storage.IsNotFound(err) {
+			h.reporter.CaptureError(r, err)
+		}
+	}
+	return key, ""
+}
+
+// setEncodingHeaders describes a sidecar served for key: its encoding,
+// and the original asset's Content-Type
+func setEncodingHeaders(w http.ResponseWriter, key, encoding string) {
+	contentType, ok := precompressibleTypes[strings.ToLower(path.Ext(key))]
+	if !ok || encoding == "" {
+		return
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Type", contentType)
+}
+
+// variesByEncoding reports whether responses for key depend on
+// Accept-Encoding, because its type may have sidecars
+func variesByEncoding(key string) bool {
+	_, ok := precompressibleTypes[strings.ToLower(path.Ext(key))]
+	return ok
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows
+// encoding. An explicit entry takes precedence over "*", and q=0
+// refuses an encoding.
+func acceptsEncoding(header, encoding string) bool {
+	explicit, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		switch {
+		case strings.EqualFold(name, encoding):
+			explicit = q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	if explicit >= 0 {
+		return explicit > 0
+	}
+	return wildcard > 0
+}
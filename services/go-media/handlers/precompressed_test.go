@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, encoding string
+		want             bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate, br", "gzip", true},
+		{"gzip", "br", false},
+		{"br;q=0, gzip", "br", false},
+		{"BR;q=0.5", "br", true},
+		{"*", "br", true},
+		{"*;q=0, gzip", "gzip", true},
+		{"*;q=0, gzip", "br", false},
+		{"identity", "gzip", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.encoding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestSetEncodingHeaders(t *testing.T) {
+	tests := []struct {
+		key, encoding          string
+		wantEncoding, wantType string
+	}{
+		{"static/app.js", "br", "br", "text/javascript; charset=utf-8"},
+		{"static/app.css", "", "", "application/octet-stream"},
+		{"assets/photo.png", "br", "", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		setEncodingHeaders(w, tt.key, tt.encoding)
+
+		if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.key, got, tt.wantEncoding)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.wantType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.key, got, tt.wantType)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxPrefixDeleteObjects bounds the objects one request deletes
+	maxPrefixDeleteObjects = 1000
+
+	// prefixDeleteConcurrency is the number of objects deleted at once
+	prefixDeleteConcurrency = 8
+
+	// prefixDeleteTTL is how long a dry run's confirmation token lasts
+	prefixDeleteTTL = 10 * time.Minute
+)
+
+// Confirmation token errors
+var (
+	errInvalidConfirmation = errors.New("invalid or expired confirmation token")
+	errPrefixChanged       = errors.New("objects under the prefix changed since the dry run")
+)
+
+// PrefixDeletePlan is what deleting a prefix would remove, with the
+// token that confirms deleting exactly that
+type PrefixDeletePlan struct {
+	Prefix    string    `json:"prefix"`
+	Count     int       `json:"count"`
+	Bytes     int64     `json:"bytes"`
+	Keys      []string  `json:"keys"`
+	Confirm   string    `json:"confirm"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type PrefixDeleteResponse struct {
+	Prefix  string                `json:"prefix"`
+	Deleted int                   `json:"deleted"`
+	Failed  []workpool.KeyFailure `json:"failed,omitempty"`
+}
+
+// deleteConfirmation is the content of a confirmation token: the prefix
+// and a digest of the objects its dry run listed
+type deleteConfirmation struct {
+	Prefix    string `json:"prefix"`
+	Digest    string `json:"digest"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// DeletePrefix deletes every object under a prefix, in two steps. With
+// dry_run=true it deletes nothing and lists what would go, with a
+// confirmation token; the deletion itself takes that token as confirm,
+// and is refused if the objects under the prefix changed in between, so
+// nothing is removed that the dry run didn't show.
+func (h *MediaHandler) DeletePrefix(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["path"]
+	if !strings.HasSuffix(prefix, "/") {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Prefix must end in /")
+		return
+	}
+	if err := ValidateKey(prefix); err != nil {
+		if errors.Is(err, ErrReservedKey) {
+			respondError(w, http.StatusForbidden, apierror.ReservedKey, err.Error())
+		} else {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		}
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	confirm := r.URL.Query().Get("confirm")
+	if !dryRun && confirm == "" {
+		respondError(w, http.StatusPreconditionRequired, apierror.ConfirmationRequired, "Run with dry_run=true first, then pass its token as confirm")
+		return
+	}
+
+	objects, err := h.prefixObjects(r.Context(), prefix, maxPrefixDeleteObjects)
+	switch {
+	case errors.Is(err, errTooManyObjects):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("At most %d objects can be deleted at once", maxPrefixDeleteObjects))
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return
+	case len(objects) == 0:
+		respondError(w, http.StatusNotFound, apierror.NotFound, "No objects under prefix")
+		return
+	}
+	digest := listingDigest(objects)
+
+	if dryRun {
+		expiresAt := time.Now().Add(prefixDeleteTTL)
+		plan := PrefixDeletePlan{
+			Prefix:    prefix,
+			Count:     len(objects),
+			Keys:      make([]string, len(objects)),
+			Confirm:   h.signDeleteConfirmation(deleteConfirmation{Prefix: prefix, Digest: digest, ExpiresAt: expiresAt.Unix()}),
+			ExpiresAt: expiresAt,
+		}
+		for i, obj := range objects {
+			plan.Keys[i] = obj.Key
+			plan.Bytes += obj.Size
+		}
+		audit.Annotate(r, prefix, map[string]string{"dry_run": "true", "objects": strconv.Itoa(len(objects))})
+		respondJSON(w, http.StatusOK, plan)
+		return
+	}
+
+	switch err := h.checkDeleteConfirmation(confirm, prefix, digest); {
+	case errors.Is(err, errPrefixChanged):
+		respondError(w, http.StatusConflict, apierror.PrefixChanged, "Objects under the prefix changed since the dry run; run it again")
+		return
+	case err != nil:
+		respondError(w, http.StatusForbidden, apierror.ConfirmationInvalid, "Invalid or expired confirmation token")
+		return
+	}
+
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	resp := deleteAll(r.Context(), keys, h.RemoveAsset)
+	resp.Prefix = prefix
+	audit.Annotate(r, prefix, map[string]string{"objects": strconv.Itoa(resp.Deleted), "failed": strconv.Itoa(len(resp.Failed))})
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// prefixObjects lists every object under prefix, directory markers and
+// encrypted objects included, up to limit
+func (h *MediaHandler) prefixObjects(ctx context.Context, prefix string, limit int) ([]storage.Object, error) {
+	var objects []storage.Object
+	opts := storage.ListOptions{Prefix: prefix, MaxKeys: 1000}
+	for {
+		page, err := h.ListPage(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(objects)+len(page.Objects) > limit {
+			return nil, errTooManyObjects
+		}
+		objects = append(objects, page.Objects...)
+		if !page.IsTruncated {
+			return objects, nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// listingDigest identifies a listing by the keys and ETags in it, so an
+// object added, removed or replaced since changes it
+func listingDigest(objects []storage.Object) string {
+	sum := sha256.New()
+	for _, obj := range objects {
+		fmt.Fprintf(sum, "%s\n%s\n", obj.Key, obj.ETag)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// signDeleteConfirmation encodes c with an HMAC of it
+func (h *MediaHandler) signDeleteConfirmation(c deleteConfirmation) string {
+	payload, _ := json.Marshal(c)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + h.tokenMAC("prefix-delete", encoded)
+}
+
+// checkDeleteConfirmation verifies that token is unexpired and confirms
+// deleting prefix as listed with digest
+func (h *MediaHandler) checkDeleteConfirmation(token, prefix, digest string) error {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(h.tokenMAC("prefix-delete", encoded))) {
+		return errInvalidConfirmation
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errInvalidConfirmation
+	}
+	var c deleteConfirmation
+	if err := json.Unmarshal(payload, &c); err != nil || time.Now().Unix() > c.ExpiresAt || c.Prefix != prefix {
+		return errInvalidConfirmation
+	}
+	if c.Digest != digest {
+		return errPrefixChanged
+	}
+	return nil
+}
+
+// deleteAll deletes every key with remove, a few at a time
+func deleteAll(ctx context.Context, keys []string, remove func(ctx context.Context, key string) error) PrefixDeleteResponse {
+	var resp PrefixDeleteResponse
+	workpool.Each(keys, prefixDeleteConcurrency, func(key string) func() {
+		err := remove(ctx, key)
+		return func() {
+			if err != nil {
+				resp.Failed = append(resp.Failed, workpool.KeyFailure{Key: key, Error: err.Error()})
+			} else {
+				resp.Deleted++
+			}
+		}
+	})
+	return resp
+}
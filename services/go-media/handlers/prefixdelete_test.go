@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+func TestDeleteConfirmation(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+	objects := []storage.Object{{Key: "old/a.png", ETag: `"1"`}, {Key: "old/b.png", ETag: `"2"`}}
+	digest := listingDigest(objects)
+	token := h.signDeleteConfirmation(deleteConfirmation{Prefix: "old/", Digest: digest, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	if err := h.checkDeleteConfirmation(token, "old/", digest); err != nil {
+		t.Fatalf("checkDeleteConfirmation() = %v", err)
+	}
+
+	// A replaced object changes the listing
+	objects[1].ETag = `"3"`
+	if err := h.checkDeleteConfirmation(token, "old/", listingDigest(objects)); !errors.Is(err, errPrefixChanged) {
+		t.Errorf("after a change: %v, want errPrefixChanged", err)
+	}
+
+	expired := h.signDeleteConfirmation(deleteConfirmation{Prefix: "old/", Digest: digest, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	for name, tc := range map[string]struct{ token, prefix string }{
+		"other prefix":  {token, "old/b"},
+		"expired":       {expired, "old/"},
+		"other secret":  {NewMediaHandler(nil, "other").signDeleteConfirmation(deleteConfirmation{Prefix: "old/", Digest: digest, ExpiresAt: time.Now().Add(time.Minute).Unix()}), "old/"},
+		"upload token":  {h.signUploadToken(UploadGrant{Prefix: "old/", ExpiresAt: time.Now().Add(time.Minute).Unix()}), "old/"},
+		"not a token":   {"nonsense", "old/"},
+		"empty payload": {"." + h.tokenMAC("prefix-delete", ""), "old/"},
+	} {
+		if err := h.checkDeleteConfirmation(tc.token, tc.prefix, digest); !errors.Is(err, errInvalidConfirmation) {
+			t.Errorf("%s: %v, want errInvalidConfirmation", name, err)
+		}
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		removed []string
+	)
+	resp := deleteAll(context.Background(), []string{"old/a.png", "old/locked.png", "old/b.png"}, func(ctx context.Context, key string) error {
+		if key == "old/locked.png" {
+			return errors.New("access denied")
+		}
+		mu.Lock()
+		removed = append(removed, key)
+		mu.Unlock()
+		return nil
+	})
+	if resp.Deleted != 2 || len(resp.Failed) != 1 || resp.Failed[0].Key != "old/locked.png" || resp.Failed[0].Error != "access denied" {
+		t.Errorf("deleteAll() = %+v", resp)
+	}
+	sort.Strings(removed)
+	if len(removed) != 2 || removed[0] != "old/a.png" || removed[1] != "old/b.png" {
+		t.Errorf("removed %v", removed)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+)
+
+const (
+	// maxPrewarmObjects bounds the objects one request warms
+	maxPrewarmObjects = 1000
+
+	// prewarmConcurrency is the number of edge fetches in flight
+	prewarmConcurrency = 8
+)
+
+// PrewarmRequest selects the objects to warm: explicit keys or every
+// object under a prefix
+type PrewarmRequest struct {
+	Keys   []string `json:"keys,omitempty" validate:"dive,required"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+func (req PrewarmRequest) Check() validation.Errors {
+	return keysOrPrefix(req.Keys, req.Prefix)
+}
+
+type PrewarmResponse struct {
+	Warmed int                   `json:"warmed"`
+	Failed []workpool.KeyFailure `json:"failed,omitempty"`
+}
+
+// Prewarm fetches objects through the public CDN so the edge cache
+// holds them before a launch sends traffic their way. Each object is
+// requested once, as a browser would, and its body discarded.
+func (h *MediaHandler) Prewarm(w http.ResponseWriter, r *http.Request) {
+	var req PrewarmRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	keys := req.Keys
+	var err error
+	if req.Prefix != "" {
+		var entries []bundleEntry
+		entries, err = h.prefixEntries(r.Context(), req.Prefix, maxPrewarmObjects)
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+	} else if len(keys) > maxPrewarmObjects {
+		err = errTooManyObjects
+	}
+	switch {
+	case errors.Is(err, errTooManyObjects):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("At most %d objects can be warmed at once", maxPrewarmObjects))
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return
+	case len(keys) == 0:
+		respondError(w, http.StatusNotFound, apierror.NotFound, "No objects under prefix")
+		return
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	respondJSON(w, http.StatusOK, warmEdge(r.Context(), client, h.publicBase, keys))
+}
+
+// warmEdge requests every key from baseURL, a few at a time
+func warmEdge(ctx context.Context, client *http.Client, baseURL string, keys []string) PrewarmResponse {
+	var resp PrewarmResponse
+	workpool.Each(keys, prewarmConcurrency, func(key string) func() {
+		err := warmKey(ctx, client, baseURL, key)
+		return func() {
+			if err != nil {
+				resp.Failed = append(resp.Failed, workpool.KeyFailure{Key: key, Error: err.Error()})
+			} else {
+				resp.Warmed++
+			}
+		}
+	})
+	return resp
+}
+
+func warmKey(ctx context.Context, client *http.Client, baseURL, key string) error {
+	target := baseURL + (&url.URL{Path: "/" + key}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	// Warm the representation browsers ask for
+	req.Header.Set("Accept-Encoding", "br, gzip")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestWarmEdge(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	edge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.EscapedPath())
+		mu.Unlock()
+
+		if r.Header.Get("Accept-Encoding") == "" {
+			t.Error("warm request without Accept-Encoding")
+		}
+		if r.URL.Path == "/static/missing.js" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer edge.Close()
+
+	keys := []string{"static/app.js", "static/missing.js", "static/hero image.png"}
+	resp := warmEdge(context.Background(), edge.Client(), edge.URL, keys)
+
+	if resp.Warmed != 2 {
+		t.Errorf("Warmed = %d, want 2", resp.Warmed)
+	}
+	if len(resp.Failed) != 1 || resp.Failed[0].Key != "static/missing.js" || resp.Failed[0].Error != "status 404" {
+		t.Errorf("Failed = %+v", resp.Failed)
+	}
+
+	sort.Strings(paths)
+	want := []string{"/static/app.js", "/static/hero%20image.png", "/static/missing.js"}
+	for i := range want {
+		if i >= len(paths) || paths[i] != want[i] {
+			t.Fatalf("edge requests = %v, want %v", paths, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/qrcode"
+)
+
+const (
+	defaultQRSize = 512
+	maxQRSize     = 2048
+)
+
+// qrLevels are the error correction levels ec accepts
+var qrLevels = map[string]qrcode.Level{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.Quartile,
+	"H": qrcode.High,
+}
+
+// QRCode renders data, such as a signed URL or a ticket number, as a QR
+// code size pixels wide, as a PNG or, with format=svg, an SVG. Codes are
+// cached by their parameters.
+func (h *MediaHandler) QRCode(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := q.Get("data")
+	if data == "" {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "data is required")
+		return
+	}
+
+	size := defaultQRSize
+	if s := q.Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxQRSize {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("size must be a number of pixels up to %d", maxQRSize))
+			return
+		}
+		size = n
+	}
+
+	format := q.Get("format")
+	contentType := "image/png"
+	switch format {
+	case "", "png":
+		format = "png"
+	case "svg":
+		contentType = "image/svg+xml"
+	default:
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "format must be png or svg")
+		return
+	}
+
+	ec := q.Get("ec")
+	if ec == "" {
+		ec = "M"
+	}
+	level, ok := qrLevels[ec]
+	if !ok {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "ec must be L, M, Q or H")
+		return
+	}
+
+	key := generatedKey("qr", "."+format, data, ec, strconv.Itoa(size))
+	h.serveGenerated(w, r, key, contentType, func() ([]byte, error) {
+		code, err := qrcode.Encode([]byte(data), level)
+		if errors.Is(err, qrcode.ErrTooLong) {
+			return nil, fmt.Errorf("%w: data is too long for a QR code at ec=%s", errRenderInput, ec)
+		} else if err != nil {
+			return nil, err
+		}
+		if size < code.MinSize() {
+			return nil, fmt.Errorf("%w: size must be at least %d pixels for this data", errRenderInput, code.MinSize())
+		}
+
+		if format == "svg" {
+			return code.SVG(size), nil
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, code.Image(size)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQRCodeBadRequest(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+	for _, target := range []string{
+		"/v1/media/qr",
+		"/v1/media/qr?data=hello&size=0",
+		"/v1/media/qr?data=hello&size=4096",
+		"/v1/media/qr?data=hello&format=gif",
+		"/v1/media/qr?data=hello&ec=X",
+	} {
+		w := httptest.NewRecorder()
+		h.QRCode(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, w.Code)
+		}
+	}
+}
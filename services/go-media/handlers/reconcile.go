@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxReportedOrphans bounds the orphaned keys listed in a report; the
+// count covers all of them
+const maxReportedOrphans = 1000
+
+// internalPrefixes hold objects the service writes for itself, which
+// are not assets and never indexed
+var internalPrefixes = []string{trashPrefix, derivedPrefix, minifiedPrefix, inventoryPrefix, deployPrefix, generatedPrefix, "logs/"}
+
+func internalKey(key string) bool {
+	for _, p := range internalPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var errReconcileRunning = errors.New("reconciliation already running")
+
+// ReconcileReport describes one walk of the bucket and the drift it
+// found between the bucket and the index
+type ReconcileReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Objects were in the bucket, Indexed in the index beforehand
+	Objects int `json:"objects"`
+	Indexed int `json:"indexed"`
+	// Added objects were missing from the index; Updated ones had a
+	// different size or ETag there
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	// Orphans are indexed keys no longer in the bucket. They are flagged
+	// in the index, not removed.
+	OrphanCount int      `json:"orphan_count"`
+	Orphans     []string `json:"orphans,omitempty"`
+}
+
+// Reconcile walks the bucket and brings the index in line with it:
+// objects missing from the index are added, changed ones updated, and
+// entries for vanished objects flagged as orphans
+func (h *MediaHandler) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	if h.index == nil {
+		return nil, errors.New("no index to reconcile")
+	}
+	if !h.reconcileMu.TryLock() {
+		return nil, errReconcileRunning
+	}
+	defer h.reconcileMu.Unlock()
+
+	rec := newReconciliation(h.index, time.Now().UTC())
+	contentType := func(key string) (string, bool) {
+		head, err := h.r2Client.HeadObject(ctx, key)
+		if err != nil {
+			// Deleted since it was listed, or unreadable until the next run
+			return "", false
+		}
+		return aws.ToString(head.ContentType), true
+	}
+
+	opts := storage.ListOptions{MaxKeys: 1000}
+	for {
+		page, err := h.r2Client.ListObjectsPage(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Objects {
+			rec.object(obj, contentType)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+
+	report := rec.finish()
+	h.reportMu.Lock()
+	h.lastReconcile = report
+	h.reportMu.Unlock()
+	return report, nil
+}
+
+// ReconcileJob reconciles the index as a scheduled job, logging the
+// drift found
+func (h *MediaHandler) ReconcileJob(ctx context.Context) error {
+	report, err := h.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Reconcile: %d objects, %d indexed; %d added, %d updated, %d orphaned",
+		report.Objects, report.Indexed, report.Added, report.Updated, report.OrphanCount)
+	return nil
+}
+
+// reconciliation compares one bucket walk against the index
+type reconciliation struct {
+	idx    *index.Index
+	seen   map[string]bool
+	report ReconcileReport
+}
+
+func newReconciliation(idx *index.Index, now time.Time) *reconciliation {
+	count, _ := idx.Totals()
+	return &reconciliation{
+		idx:    idx,
+		seen:   make(map[string]bool, count),
+		report: ReconcileReport{StartedAt: now, Indexed: count},
+	}
+}
+
+// object reconciles a listed object. contentType looks up the type of
+// an object about to be indexed, reporting false if it is gone.
+func (r *reconciliation) object(obj storage.Object, contentType func(key string) (string, bool)) {
+	// Directory markers have no content
+	if internalKey(obj.Key) || strings.HasSuffix(obj.Key, "/") {
+		return
+	}
+	r.report.Objects++
+	r.seen[obj.Key] = true
+	etag := strings.Trim(obj.ETag, `"`)
+
+	e, ok := r.idx.Get(obj.Key)
+	switch {
+	case ok && !e.UpdatedAt.Before(r.report.StartedAt):
+		// Written during the walk, so newer than the listing
+	case !ok:
+		ct, exists := contentType(obj.Key)
+		if !exists {
+			return
+		}
+		r.report.Added++
+		r.idx.Update(obj.Key, func(e *index.Entry) {
+			e.Size = obj.Size
+			e.ContentType = ct
+			e.ETag = etag
+			e.UpdatedAt = obj.LastModified.UTC()
+		})
+	case e.Size != obj.Size || e.ETag != "" && e.ETag != etag:
+		r.report.Updated++
+		r.idx.Update(obj.Key, func(e *index.Entry) {
+			e.Size = obj.Size
+			e.ETag = etag
+			e.Integrity = ""
+			e.UpdatedAt = obj.LastModified.UTC()
+			e.Orphaned = false
+		})
+	case e.ETag == "" || e.Orphaned:
+		// Entries from before ETags were indexed, or back after going
+		// missing, are filled in without counting as drift
+		r.idx.Update(obj.Key, func(e *index.Entry) {
+			e.ETag = etag
+			e.Orphaned = false
+		})
+	}
+}
+
+// finish flags indexed entries the walk didn't see. Entries written
+// after the walk started may simply have been listed before they
+// existed, so they are left alone.
+func (r *reconciliation) finish() *ReconcileReport {
+	for _, e := range r.idx.List("") {
+		if r.seen[e.Key] || !e.UpdatedAt.Before(r.report.StartedAt) {
+			continue
+		}
+		r.report.OrphanCount++
+		if len(r.report.Orphans) < maxReportedOrphans {
+			r.report.Orphans = append(r.report.Orphans, e.Key)
+		}
+		if !e.Orphaned {
+			r.idx.Update(e.Key, func(e *index.Entry) { e.Orphaned = true })
+		}
+	}
+	r.report.FinishedAt = time.Now().UTC()
+	return &r.report
+}
+
+// ReconcileStatus reports the last reconciliation of the index with the
+// bucket (GET), or runs one now and reports it (POST)
+func (h *MediaHandler) ReconcileStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.reportMu.Lock()
+		report := h.lastReconcile
+		h.reportMu.Unlock()
+		if report == nil {
+			respondError(w, http.StatusNotFound, apierror.NotFound, "No reconciliation has run yet")
+			return
+		}
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	report, err := h.Reconcile(r.Context())
+	switch {
+	case errors.Is(err, errReconcileRunning):
+		respondError(w, http.StatusConflict, apierror.Conflict, "Reconciliation already running")
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to reconcile index")
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+func TestReconciliation(t *testing.T) {
+	idx, _ := index.Open("")
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := start.Add(-time.Hour)
+
+	set := func(key string, size int64, etag string, at time.Time) {
+		idx.Update(key, func(e *index.Entry) {
+			e.Size, e.ETag, e.UpdatedAt = size, etag, at
+		})
+	}
+	set("assets/same.png", 10, "aaa", before)
+	set("assets/changed.png", 10, "bbb", before)
+	set("assets/gone.png", 10, "ccc", before)
+	set("assets/legacy.png", 10, "", before)
+	set("assets/new-upload.png", 10, "ddd", start.Add(time.Minute))
+
+	listed := []storage.Object{
+		{Key: "assets/same.png", Size: 10, ETag: `"aaa"`},
+		{Key: "assets/changed.png", Size: 20, ETag: `"eee"`},
+		{Key: "assets/legacy.png", Size: 10, ETag: `"fff"`},
+		{Key: "assets/external.png", Size: 5, ETag: `"ggg"`, LastModified: before},
+		{Key: "assets/deleted-since.png", Size: 5, ETag: `"hhh"`},
+		{Key: "assets/dir/", Size: 0},
+		{Key: derivedPrefix + "app.js/abc/min", Size: 3},
+	}
+	contentType := func(key string) (string, bool) {
+		return "image/png", key != "assets/deleted-since.png"
+	}
+
+	rec := newReconciliation(idx, start)
+	for _, obj := range listed {
+		rec.object(obj, contentType)
+	}
+	report := rec.finish()
+
+	if report.Indexed != 5 || report.Objects != 5 || report.Added != 1 || report.Updated != 1 || report.OrphanCount != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != "assets/gone.png" {
+		t.Errorf("orphans = %v, want assets/gone.png", report.Orphans)
+	}
+
+	if e, _ := idx.Get("assets/external.png"); e.ContentType != "image/png" || e.ETag != "ggg" || !e.UpdatedAt.Equal(before) {
+		t.Errorf("added entry = %+v", e)
+	}
+	if e, _ := idx.Get("assets/changed.png"); e.Size != 20 || e.ETag != "eee" {
+		t.Errorf("updated entry = %+v", e)
+	}
+	if e, _ := idx.Get("assets/legacy.png"); e.ETag != "fff" {
+		t.Errorf("legacy entry not filled in: %+v", e)
+	}
+	if e, ok := idx.Get("assets/gone.png"); !ok || !e.Orphaned {
+		t.Errorf("orphan = %+v, want flagged but kept", e)
+	}
+	if e, _ := idx.Get("assets/new-upload.png"); e.Orphaned {
+		t.Error("entry written during the walk flagged as an orphan")
+	}
+	if _, ok := idx.Get("assets/deleted-since.png"); ok {
+		t.Error("object deleted since listing was indexed")
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+// regions picks the public base URL of the region nearest a client
+type regions struct {
+	// header carries the client's country, as the fronting proxy
+	// geolocated it
+	header string
+	// byCountry maps upper-case country codes to base URLs
+	byCountry map[string]string
+	// home is this instance's region's base URL, for everyone else
+	home string
+}
+
+// WithRegions points signed URLs and manifests at the region of the
+// client's country when cfg lists regional hosts
+func WithRegions(cfg config.RegionsConfig) Option {
+	return func(h *MediaHandler) {
+		if len(cfg.Hosts) == 0 {
+			return
+		}
+		h.regions = &regions{header: cfg.CountryHeader, byCountry: make(map[string]string)}
+		for _, host := range cfg.Hosts {
+			base := strings.TrimSuffix(host.BaseURL, "/")
+			for _, cc := range host.Countries {
+				h.regions.byCountry[strings.ToUpper(cc)] = base
+			}
+			if host.Name == cfg.Region {
+				h.regions.home = base
+			}
+		}
+	}
+}
+
+// varyByRegion marks a response built with baseURL as differing by the
+// client's country, so caches keep one per country
+func (h *MediaHandler) varyByRegion(w http.ResponseWriter) {
+	if h.regions != nil {
+		w.Header().Add("Vary", h.regions.header)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+func TestRegionSteering(t *testing.T) {
+	idx, _ := index.Open("")
+	idx.Update("app/main.js", func(e *index.Entry) { e.Key = "app/main.js"; e.ETag = "5d41402abc4b2a76b9719d911017c592" })
+	h := NewMediaHandler(nil, "secret", WithIndex(idx), WithRegions(config.RegionsConfig{
+		Region:        "us",
+		CountryHeader: "CF-IPCountry",
+		Hosts: []config.RegionHost{
+			{Name: "us", BaseURL: "https://us.cdn.example.com", Countries: []string{"US", "CA"}},
+			{Name: "eu", BaseURL: "https://eu.cdn.example.com/", Countries: []string{"de", "FR"}},
+		},
+	}))
+
+	tests := []struct {
+		country, want string
+	}{
+		{"DE", "https://eu.cdn.example.com"},
+		{"fr", "https://eu.cdn.example.com"},
+		{"CA", "https://us.cdn.example.com"},
+		// Unlisted and unknown countries get this instance's region
+		{"JP", "https://us.cdn.example.com"},
+		{"", "https://us.cdn.example.com"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/v1/media/sign", strings.NewReader(`{"path": "reports/q3.pdf"}`))
+		r.Header.Set("CF-IPCountry", tt.country)
+		w := httptest.NewRecorder()
+		h.GenerateSignedURL(w, r)
+		var signed SignedURLResponse
+		json.NewDecoder(w.Body).Decode(&signed)
+		if !strings.HasPrefix(signed.URL, tt.want+"/v1/media/private/reports/q3.pdf?") {
+			t.Errorf("%q: signed URL = %s, want on %s", tt.country, signed.URL, tt.want)
+		}
+
+		r = httptest.NewRequest("GET", "/v1/media/manifest?prefix=app/", nil)
+		r.Header.Set("CF-IPCountry", tt.country)
+		w = httptest.NewRecorder()
+		h.AssetManifest(w, r)
+		var m Manifest
+		json.NewDecoder(w.Body).Decode(&m)
+		if got := m.Assets["main.js"].URL; got != tt.want+"/app/main.js?v=5d41402abc4b2a76" {
+			t.Errorf("%q: manifest URL = %s, want on %s", tt.country, got, tt.want)
+		}
+		if v := w.Header().Get("Vary"); !strings.Contains(v, "CF-IPCountry") {
+			t.Errorf("%q: Vary = %q", tt.country, v)
+		}
+	}
+
+	// A signature made for one region is accepted by all of them
+	u, _ := url.Parse(h.signURL("https://eu.cdn.example.com", "reports/q3.pdf", time.Hour, URLOptions{}).URL)
+	if q := u.Query(); !NewMediaHandler(nil, "secret").validateSignature("reports/q3.pdf", q.Get("exp"), q.Get("sig"), URLOptions{}) {
+		t.Errorf("regional URL %s isn't valid elsewhere", u)
+	}
+
+	if got := NewMediaHandler(nil, "secret").baseURL(httptest.NewRequest("GET", "/", nil)); got != publicBaseURL {
+		t.Errorf("baseURL() = %s without regions", got)
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+// Object metadata holding an upload's publishing window, which the edge
+// Worker reads too
+const (
+	publishAtMeta   = "publish-at"
+	unpublishAtMeta = "unpublish-at"
+)
+
+// publishSchedule is when an upload becomes public and stops being so,
+// either of which may be nil, and its state when not published
+type publishSchedule struct {
+	State       string
+	PublishAt   *time.Time
+	UnpublishAt *time.Time
+}
+
+func (s publishSchedule) isZero() bool {
+	return s.State == "" && s.PublishAt == nil && s.UnpublishAt == nil
+}
+
+// withheld reports whether an upload under s isn't public at once
+func (s publishSchedule) withheld() bool {
+	return s.State != "" || s.PublishAt != nil
+}
+
+// parsePublishSchedule reads a schedule from RFC 3339 times. A publish
+// time already past is dropped, since the upload is public at once.
+func parsePublishSchedule(publishAt, unpublishAt string, now time.Time) (publishSchedule, error) {
+	var s publishSchedule
+	for _, f := range []struct {
+		name, value string
+		t           **time.Time
+	}{{"publish_at", publishAt, &s.PublishAt}, {"unpublish_at", unpublishAt, &s.UnpublishAt}} {
+		if f.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.value)
+		if err != nil {
+			return publishSchedule{}, fmt.Errorf("%s must be an RFC 3339 time, such as 2026-01-02T15:04:05Z", f.name)
+		}
+		t = t.UTC()
+		*f.t = &t
+	}
+	if s.PublishAt != nil && !now.Before(*s.PublishAt) {
+		s.PublishAt = nil
+	}
+	switch {
+	case s.UnpublishAt != nil && !now.Before(*s.UnpublishAt):
+		return publishSchedule{}, errors.New("unpublish_at is in the past")
+	case s.PublishAt != nil && s.UnpublishAt != nil && !s.UnpublishAt.After(*s.PublishAt):
+		return publishSchedule{}, errors.New("unpublish_at must be after publish_at")
+	}
+	return s, nil
+}
+
+// hidden reports whether s keeps an asset off the public path at now
+func (s publishSchedule) hidden(now time.Time) bool {
+	return index.Entry{State: s.State, PublishAt: s.PublishAt, UnpublishAt: s.UnpublishAt}.Hidden(now)
+}
+
+// metadata returns the object metadata recording s
+func (s publishSchedule) metadata() map[string]string {
+	m := make(map[string]string)
+	if s.State != "" {
+		m[stateMeta] = s.State
+	}
+	if s.PublishAt != nil {
+		m[publishAtMeta] = s.PublishAt.Format(time.RFC3339)
+	}
+	if s.UnpublishAt != nil {
+		m[unpublishAtMeta] = s.UnpublishAt.Format(time.RFC3339)
+	}
+	return m
+}
+
+// scheduleFromMetadata reads the schedule recorded in object metadata,
+// as for objects written to the bucket directly
+func scheduleFromMetadata(meta map[string]string) publishSchedule {
+	var s publishSchedule
+	if st := meta[stateMeta]; st == index.StateDraft || st == index.StateArchived {
+		s.State = st
+	}
+	if t, err := time.Parse(time.RFC3339, meta[publishAtMeta]); err == nil {
+		s.PublishAt = &t
+	}
+	if t, err := time.Parse(time.RFC3339, meta[unpublishAtMeta]); err == nil {
+		s.UnpublishAt = &t
+	}
+	return s
+}
+
+type publishScheduleKey struct{}
+
+// withPublishSchedule makes the uploads stored with ctx public only
+// within s
+func withPublishSchedule(ctx context.Context, s publishSchedule) context.Context {
+	if s.isZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, publishScheduleKey{}, s)
+}
+
+func publishScheduleFrom(ctx context.Context) publishSchedule {
+	s, _ := ctx.Value(publishScheduleKey{}).(publishSchedule)
+	return s
+}
+
+// hidden reports whether key is kept off the public path by its state or
+// publishing schedule
+func (h *MediaHandler) hidden(key string) bool {
+	if h.index == nil {
+		return false
+	}
+	e, ok := h.index.Get(key)
+	return ok && e.Hidden(time.Now())
+}
+
+// cacheUntil caps cache for the public copy of key at its unpublish
+// time, so caches don't serve it after. It returns cc when key has none.
+func (h *MediaHandler) cacheUntil(key, cc string) string {
+	if h.index == nil {
+		return cc
+	}
+	e, ok := h.index.Get(key)
+	if !ok || e.UnpublishAt == nil {
+		return cc
+	}
+	ttl := int(time.Until(*e.UnpublishAt).Seconds())
+	return "public, max-age=" + strconv.Itoa(max(ttl, 0))
+}
+
+// notPublished answers a request for an asset that isn't public yet, or
+// any more, as if it didn't exist. The answer isn't cached, since the
+// asset may appear at any moment.
+func notPublished(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+}
+
+// PublishScheduled acts on the publishing schedules that have come due:
+// each asset whose publish or unpublish time has passed is purged from
+// the edge cache, so its 404 or its content doesn't linger there, and
+// announced. Visibility itself follows the clock, not this job.
+func (h *MediaHandler) PublishScheduled(ctx context.Context) error {
+	if h.index == nil {
+		return nil
+	}
+	now := time.Now()
+	var failed []string
+	var lastErr error
+	for _, e := range h.index.List("") {
+		// A draft stays one past its publish time, until promoted
+		published := e.State == "" && e.PublishAt != nil && !now.Before(*e.PublishAt)
+		unpublished := e.UnpublishAt != nil && !now.Before(*e.UnpublishAt)
+		if !published && !unpublished {
+			continue
+		}
+		if err := h.purgeKey(e.Key); err != nil {
+			// Left due, so the next run retries
+			failed, lastErr = append(failed, e.Key), err
+			continue
+		}
+		h.index.Update(e.Key, func(e *index.Entry) {
+			if published {
+				e.PublishAt = nil
+			}
+			if unpublished {
+				e.UnpublishAt = nil
+				e.State = index.StateArchived
+			}
+		})
+		data := map[string]interface{}{"key": e.Key, "url": h.publicURL(e.Key)}
+		if published && !unpublished {
+			log.Printf("Publishing: %s is public", e.Key)
+			h.events.Publish(events.New(events.AssetPublished, data))
+		}
+		if unpublished {
+			log.Printf("Publishing: %s is withdrawn", e.Key)
+			h.events.Publish(events.New(events.AssetUnpublished, data))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("publishing: failed to purge %s: %w", strings.Join(failed, ", "), lastErr)
+	}
+	return nil
+}
+
+// purgeKey purges the public URLs of key, on every public host, from the
+// edge cache, where Cloudflare is configured
+func (h *MediaHandler) purgeKey(key string) error {
+	if h.cfZoneID == "" || h.cfAPIToken == "" {
+		return nil
+	}
+	urls := []string{h.keyBaseURL(key, h.publicBase) + "/" + key}
+	if u := h.publicURL(key); u != urls[0] {
+		urls = append(urls, u)
+	}
+	for host := range h.publicHosts {
+		urls = append(urls, h.hostURL(host)+"/"+key)
+	}
+	return h.purgeCloudflareCache(urls)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+func TestParsePublishSchedule(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s, err := parsePublishSchedule("2026-03-02T09:00:00+02:00", "2026-04-01T00:00:00Z", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.PublishAt.Equal(time.Date(2026, 3, 2, 7, 0, 0, 0, time.UTC)) || s.PublishAt.Location() != time.UTC {
+		t.Errorf("publish_at = %v", s.PublishAt)
+	}
+	if meta := s.metadata(); meta[publishAtMeta] != "2026-03-02T07:00:00Z" || meta[unpublishAtMeta] != "2026-04-01T00:00:00Z" {
+		t.Errorf("metadata = %v", meta)
+	}
+	if back := scheduleFromMetadata(s.metadata()); !back.PublishAt.Equal(*s.PublishAt) || !back.UnpublishAt.Equal(*s.UnpublishAt) {
+		t.Errorf("metadata round trip = %+v", back)
+	}
+
+	// A publish time already past means public now
+	if s, err := parsePublishSchedule("2026-02-01T00:00:00Z", "", now); err != nil || !s.isZero() {
+		t.Errorf("past publish_at = %+v, %v", s, err)
+	}
+
+	for _, c := range [][2]string{
+		{"tomorrow", ""},
+		{"", "2026-02-01T00:00:00Z"},
+		{"2026-03-05T00:00:00Z", "2026-03-04T00:00:00Z"},
+		{"2026-03-05T00:00:00Z", "2026-03-05T00:00:00Z"},
+	} {
+		if _, err := parsePublishSchedule(c[0], c[1], now); err == nil {
+			t.Errorf("parsePublishSchedule(%q, %q) accepted", c[0], c[1])
+		}
+	}
+}
+
+func TestScheduledAssetHidden(t *testing.T) {
+	idx, _ := index.Open("")
+	h := NewMediaHandler(nil, "secret", WithIndex(idx))
+
+	future := time.Now().Add(time.Hour)
+	idx.Update("press/launch.pdf", func(e *index.Entry) { e.PublishAt = &future })
+	idx.Update("press/old.pdf", func(e *index.Entry) { e.State = index.StateArchived })
+
+	for _, key := range []string{"press/launch.pdf", "press/old.pdf"} {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("GET", "/v1/media/assets/"+key, nil), map[string]string{"path": key})
+		h.ServeAsset(w, r)
+		if w.Code != 404 || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: status %d, Cache-Control %q", key, w.Code, w.Header().Get("Cache-Control"))
+		}
+	}
+
+	idx.Update("press/launch.pdf", func(e *index.Entry) {
+		e.PublishAt = nil
+		e.UnpublishAt = &future
+	})
+	if h.hidden("press/launch.pdf") {
+		t.Error("published asset hidden")
+	}
+	if cc := h.cacheUntil("press/launch.pdf", "public, max-age=31536000"); cc != "public, max-age=3599" && cc != "public, max-age=3600" {
+		t.Errorf("cacheUntil = %q", cc)
+	}
+	if cc := h.cacheUntil("press/other.pdf", "public, max-age=60"); cc != "public, max-age=60" {
+		t.Errorf("cacheUntil without a schedule = %q", cc)
+	}
+}
+
+func TestPublishScheduled(t *testing.T) {
+	idx, _ := index.Open("")
+	sink := &recordingSink{}
+	h := NewMediaHandler(nil, "secret", WithIndex(idx), WithEvents(events.NewBus(sink)))
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	idx.Update("press/due.pdf", func(e *index.Entry) {
+		e.PublishAt = &past
+		e.UnpublishAt = &future
+	})
+	idx.Update("press/expired.pdf", func(e *index.Entry) { e.UnpublishAt = &past })
+	idx.Update("press/later.pdf", func(e *index.Entry) { e.PublishAt = &future })
+	idx.Update("press/draft.pdf", func(e *index.Entry) {
+		e.State = index.StateDraft
+		e.PublishAt = &past
+	})
+
+	if err := h.PublishScheduled(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, _ := idx.Get("press/due.pdf"); e.PublishAt != nil || e.UnpublishAt == nil || e.Hidden(time.Now()) {
+		t.Errorf("due entry = %+v", e)
+	}
+	if e, _ := idx.Get("press/expired.pdf"); e.State != index.StateArchived || e.UnpublishAt != nil {
+		t.Errorf("expired entry = %+v", e)
+	}
+	if e, _ := idx.Get("press/later.pdf"); e.PublishAt == nil {
+		t.Errorf("entry not yet due was changed: %+v", e)
+	}
+	if e, _ := idx.Get("press/draft.pdf"); e.PublishAt == nil || !e.Hidden(time.Now()) {
+		t.Errorf("draft was published: %+v", e)
+	}
+
+	types := map[string]string{}
+	for _, e := range sink.events {
+		types[e.Data["key"].(string)] = e.Type
+	}
+	if len(sink.events) != 2 || types["press/due.pdf"] != events.AssetPublished || types["press/expired.pdf"] != events.AssetUnpublished {
+		t.Errorf("events = %v", sink.events)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/textextract"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// maxExtractSize bounds the objects text is extracted from; larger ones
+// are found by key only
+const maxExtractSize = 32 << 20
+
+// extractTimeout bounds reading back an object to extract its text
+const extractTimeout = 2 * time.Minute
+
+// WithTextExtraction indexes up to limit bytes of the text of each PDF,
+// plain text, CSV and JSON asset for Search; 0 indexes none
+func WithTextExtraction(limit int) Option {
+	return func(h *MediaHandler) {
+		h.textLimit = limit
+	}
+}
+
+// searchText returns the text to index for an upload of data, which is
+// "" unless extraction is on and data's type has text to extract
+func (h *MediaHandler) searchText(contentType string, data []byte) string {
+	if h.textLimit == 0 || len(data) > maxExtractSize {
+		return ""
+	}
+	return textextract.Extract(contentType, data, h.textLimit)
+}
+
+// indexTextLater extracts the text of an object written without passing
+// through the service whole, such as a chunked upload, in the background,
+// and adds it to the index entry if the object hasn't changed since
+func (h *MediaHandler) indexTextLater(key, contentType, etag string, size int64) {
+	if h.index == nil || h.textLimit == 0 || size > maxExtractSize || h.sealed(key) || !textextract.Supported(contentType) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), extractTimeout)
+		defer cancel()
+		obj, err := h.r2Client.GetObject(ctx, key)
+		if err != nil {
+			log.Printf("Search: failed to read %s for its text: %v", key, err)
+			return
+		}
+		defer obj.Body.Close()
+		if strings.Trim(aws.ToString(obj.ETag), `"`) != strings.Trim(etag, `"`) {
+			// Replaced since, by a write that indexes its own text
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(obj.Body, maxExtractSize))
+		if err != nil {
+			log.Printf("Search: failed to read %s for its text: %v", key, err)
+			return
+		}
+		text := textextract.Extract(contentType, data, h.textLimit)
+		if e, ok := h.index.Get(key); !ok || e.ETag != etag {
+			return
+		}
+		h.index.Update(key, func(e *index.Entry) {
+			e.Text = text
+		})
+	}()
+}
+
+// Search finds indexed assets under ?prefix= whose key or extracted text
+// holds every word of ?q=, best matches first. Like the manifest, it
+// leaves out drafts, scheduled and archived assets, and encrypted ones.
+func (h *MediaHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if h.index == nil {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Search needs the metadata index")
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "q is required")
+		return
+	}
+
+	limit := 20
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	now := time.Now()
+	public := func(e *index.Entry) bool {
+		return !internalKey(e.Key) && !e.Orphaned && !e.CustomerKey && !e.Hidden(now) && !h.sealed(e.Key)
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   q,
+		"results": h.index.Search(query.Get("prefix"), q, limit, public),
+	})
+}
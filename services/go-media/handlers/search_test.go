@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+func TestSearch(t *testing.T) {
+	idx, _ := index.Open("")
+	h := NewMediaHandler(nil, "secret", WithIndex(idx), WithTextExtraction(1000))
+	for key, data := range map[string]string{
+		"handbook/expense-policy.txt": "Receipts over $25 need approval.",
+		"handbook/onboarding.txt":     "Submit your first expense report in week two.",
+		"archive/expense.txt":         "Old expense rules.",
+	} {
+		text := h.searchText("text/plain; charset=utf-8", []byte(data))
+		idx.Update(key, func(e *index.Entry) { e.Text = text })
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		keys  []string
+	}{
+		{query: "q=expense&prefix=handbook/", code: http.StatusOK, keys: []string{"handbook/expense-policy.txt", "handbook/onboarding.txt"}},
+		{query: "q=Expense+REPORT", code: http.StatusOK, keys: []string{"handbook/onboarding.txt"}},
+		{query: "q=expense&limit=1", code: http.StatusOK, keys: []string{"archive/expense.txt"}},
+		{query: "q=payroll", code: http.StatusOK, keys: []string{}},
+		{query: "q=+", code: http.StatusBadRequest},
+		{query: "q=expense&limit=500", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.Search(w, httptest.NewRequest("GET", "/v1/media/search?"+tt.query, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.query, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Results []index.Result `json:"results"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var keys []string
+		for _, r := range resp.Results {
+			keys = append(keys, r.Key)
+		}
+		if len(keys) != len(tt.keys) {
+			t.Errorf("%s = %v, want %v", tt.query, keys, tt.keys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != tt.keys[i] {
+				t.Errorf("%s = %v, want %v", tt.query, keys, tt.keys)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+	if text := h.searchText("text/plain", []byte("hello")); text != "" {
+		t.Errorf("text extracted while extraction is off: %q", text)
+	}
+	h = NewMediaHandler(nil, "secret", WithTextExtraction(4))
+	if text := h.searchText("text/plain", []byte("hello")); text != "hell" {
+		t.Errorf("searchText = %q, want the first 4 bytes", text)
+	}
+	if text := h.searchText("image/png", []byte("hello")); text != "" {
+		t.Errorf("text extracted from an image: %q", text)
+	}
+
+	w := httptest.NewRecorder()
+	h.Search(w, httptest.NewRequest("GET", "/v1/media/search?q=hello", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("search without an index: status %d", w.Code)
+	}
+}
+
+func TestSearchSkipsUnpublished(t *testing.T) {
+	idx, _ := index.Open("")
+	h := NewMediaHandler(nil, "secret", WithIndex(idx))
+	tomorrow := time.Now().Add(24 * time.Hour)
+	for key, update := range map[string]func(e *index.Entry){
+		"press/live.txt":      func(e *index.Entry) {},
+		"press/draft.txt":     func(e *index.Entry) { e.State = index.StateDraft },
+		"press/archived.txt":  func(e *index.Entry) { e.State = index.StateArchived },
+		"press/embargoed.txt": func(e *index.Entry) { e.PublishAt = &tomorrow },
+		"press/orphaned.txt":  func(e *index.Entry) { e.Orphaned = true },
+		"press/customer.txt":  func(e *index.Entry) { e.CustomerKey = true },
+	} {
+		idx.Update(key, func(e *index.Entry) {
+			e.Text = "merger announcement"
+			update(e)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	h.Search(w, httptest.NewRequest("GET", "/v1/media/search?q=merger", nil))
+	var resp struct {
+		Results []index.Result `json:"results"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Results) != 1 || resp.Results[0].Key != "press/live.txt" {
+		t.Errorf("results = %+v, want only press/live.txt", resp.Results)
+	}
+}
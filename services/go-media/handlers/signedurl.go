@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+)
+
+// errNeverValid is a signed URL that expires before it becomes valid
+var errNeverValid = errors.New("nbf must be before the URL expires")
+
+// URLOptions are the optional parts of a signed URL. The signature
+// covers them, so a recipient can't change them.
+type URLOptions struct {
+	// Filename downloads the object as an attachment with this name
+	Filename string `json:"filename,omitempty"`
+	// ContentType replaces the object's stored content type
+	ContentType string `json:"content_type,omitempty"`
+	// NotBefore is the Unix time the URL becomes valid, for links issued
+	// ahead of an embargo
+	NotBefore int64 `json:"nbf,omitempty" validate:"min=0"`
+}
+
+// urlOptions reads the options from a signed URL's query
+func urlOptions(q url.Values) URLOptions {
+	nbf, _ := strconv.ParseInt(q.Get("nbf"), 10, 64)
+	return URLOptions{Filename: q.Get("filename"), ContentType: q.Get("content_type"), NotBefore: nbf}
+}
+
+// active reports whether a URL with these options may be used at now
+func (o URLOptions) active(now time.Time) bool {
+	return now.Unix() >= o.NotBefore
+}
+
+// Check checks the overrides, which end up in response headers
+func (o URLOptions) Check() validation.Errors {
+	var errs validation.Errors
+	if strings.IndexFunc(o.Filename, unicode.IsControl) >= 0 || strings.ContainsAny(o.Filename, `/\`) {
+		errs = append(errs, validation.FieldError{Field: "filename", Rule: "filename", Message: "must not contain slashes or control characters"})
+	}
+	if o.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(o.ContentType)
+		switch {
+		case err != nil:
+			errs = append(errs, validation.FieldError{Field: "content_type", Rule: "media_type", Message: "must be a media type"})
+		case scriptable(mediaType):
+			errs = append(errs, validation.FieldError{Field: "content_type", Rule: "content_type", Message: "must not be HTML, SVG or XML"})
+		}
+	}
+	return errs
+}
+
+// scriptable reports whether browsers run scripts in documents of
+// mediaType. Overriding an object's type with one would let anyone able
+// to sign a URL serve their upload as a page on the API origin.
+func scriptable(mediaType string) bool {
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// message is what a signed URL's signature covers. Options are appended
+// only when set, so URLs signed without them keep their signatures.
+func (o URLOptions) message(path, expires string) string {
+	msg := path + ":" + expires
+	if o.Filename != "" {
+		msg += "\nfilename=" + o.Filename
+	}
+	if o.ContentType != "" {
+		msg += "\ncontent_type=" + o.ContentType
+	}
+	if o.NotBefore != 0 {
+		msg += "\nnbf=" + strconv.FormatInt(o.NotBefore, 10)
+	}
+	return msg
+}
+
+// query encodes the options as signed URL parameters
+func (o URLOptions) query() string {
+	q := url.Values{}
+	if o.Filename != "" {
+		q.Set("filename", o.Filename)
+	}
+	if o.ContentType != "" {
+		q.Set("content_type", o.ContentType)
+	}
+	if o.NotBefore != 0 {
+		q.Set("nbf", strconv.FormatInt(o.NotBefore, 10))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "&" + q.Encode()
+}
+
+// disposition is the Content-Disposition header for the filename
+// override, or "" without one
+func (o URLOptions) disposition() string {
+	if o.Filename == "" {
+		return ""
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": o.Filename})
+}
+
+// apply sets the overridden response headers
+func (o URLOptions) apply(w http.ResponseWriter) {
+	if d := o.disposition(); d != "" {
+		w.Header().Set("Content-Disposition", d)
+	}
+	if o.ContentType != "" {
+		w.Header().Set("Content-Type", o.ContentType)
+	}
+}
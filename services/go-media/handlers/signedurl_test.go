@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+func TestSignURLOptions(t *testing.T) {
+	h := &MediaHandler{signingSecret: "test-secret"}
+	opts := URLOptions{Filename: "Q3 report (final).pdf", ContentType: "application/pdf"}
+	signed := h.SignURL("reports/q3.pdf", time.Hour, opts)
+
+	u, err := url.Parse(signed.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if got := urlOptions(q); got != opts {
+		t.Fatalf("options in URL = %+v, want %+v", got, opts)
+	}
+	if !h.validateSignature("reports/q3.pdf", q.Get("exp"), q.Get("sig"), urlOptions(q)) {
+		t.Error("signed URL doesn't validate")
+	}
+
+	// A recipient can't rename the download or drop the override
+	q.Set("filename", "other.pdf")
+	if h.validateSignature("reports/q3.pdf", q.Get("exp"), q.Get("sig"), urlOptions(q)) {
+		t.Error("URL with a changed filename validates")
+	}
+	q.Del("filename")
+	if h.validateSignature("reports/q3.pdf", q.Get("exp"), q.Get("sig"), urlOptions(q)) {
+		t.Error("URL without its filename validates")
+	}
+
+	// URLs signed without options are unchanged
+	plain, _ := url.Parse(h.SignURL("reports/q3.pdf", time.Hour, URLOptions{}).URL)
+	pq := plain.Query()
+	if len(pq) != 2 || pq.Get("sig") != h.generateSignature("reports/q3.pdf", pq.Get("exp"), URLOptions{}) {
+		t.Errorf("plain signed URL = %s", plain)
+	}
+}
+
+func TestURLOptionsApply(t *testing.T) {
+	w := httptest.NewRecorder()
+	URLOptions{Filename: "résumé.pdf", ContentType: "application/octet-stream"}.apply(w)
+	if got := w.Header().Get("Content-Disposition"); got != "attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	for _, bad := range []URLOptions{
+		{Filename: "a\nb.pdf"},
+		{Filename: "../etc/passwd"},
+		{ContentType: "not a type"},
+		{ContentType: "text/html; charset=utf-8"},
+		{ContentType: "application/xhtml+xml"},
+		{ContentType: "image/svg+xml"},
+		{ContentType: "application/rss+xml"},
+	} {
+		if len(bad.Check()) == 0 {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+func TestSignURLNotBefore(t *testing.T) {
+	h := &MediaHandler{signingSecret: "test-secret"}
+	release := time.Now().Add(time.Hour).Truncate(time.Second)
+	signed := h.SignURL("press/launch.mp4", 2*time.Hour, URLOptions{NotBefore: release.Unix()})
+
+	u, _ := url.Parse(signed.URL)
+	q := u.Query()
+	opts := urlOptions(q)
+	if !h.validateSignature("press/launch.mp4", q.Get("exp"), q.Get("sig"), opts) {
+		t.Fatal("signed URL doesn't validate")
+	}
+	if opts.active(release.Add(-time.Second)) || !opts.active(release) {
+		t.Error("URL should become valid exactly at nbf")
+	}
+
+	q.Set("nbf", strconv.FormatInt(time.Now().Unix(), 10))
+	if h.validateSignature("press/launch.mp4", q.Get("exp"), q.Get("sig"), urlOptions(q)) {
+		t.Error("URL with an earlier nbf validates")
+	}
+
+	// Served before nbf: refused without touching storage
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("GET", u.RequestURI(), nil), map[string]string{"path": "press/launch.mp4"})
+	h.ServePrivateAsset(w, r)
+	if w.Code != http.StatusForbidden || w.Header().Get("Retry-After") == "" {
+		t.Errorf("before nbf: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	var resp ErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Code != apierror.SignatureNotYetValid {
+		t.Errorf("before nbf: code %q", resp.Code)
+	}
+
+	// Served after exp
+	u, _ = url.Parse(h.SignURL("press/launch.mp4", -time.Minute, URLOptions{}).URL)
+	w = httptest.NewRecorder()
+	h.ServePrivateAsset(w, mux.SetURLVars(httptest.NewRequest("GET", u.RequestURI(), nil), map[string]string{"path": "press/launch.mp4"}))
+	resp = ErrorResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusForbidden || resp.Code != apierror.SignatureExpired {
+		t.Errorf("after exp: status %d, code %q", w.Code, resp.Code)
+	}
+}
+
+func TestSignedContentTypeNotScriptable(t *testing.T) {
+	h := &MediaHandler{signingSecret: "test-secret"}
+
+	// Refused when signing, and when served if signed before the check
+	if len((URLOptions{ContentType: "text/html"}).Check()) == 0 {
+		t.Error("HTML override accepted")
+	}
+	u, _ := url.Parse(h.SignURL("notes/a.txt", time.Hour, URLOptions{ContentType: "text/html"}).URL)
+	w := httptest.NewRecorder()
+	h.ServePrivateAsset(w, mux.SetURLVars(httptest.NewRequest("GET", u.RequestURI(), nil), map[string]string{"path": "notes/a.txt"}))
+	var resp ErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusForbidden || resp.Code != apierror.SignatureInvalid {
+		t.Errorf("HTML override served: status %d, code %q", w.Code, resp.Code)
+	}
+}
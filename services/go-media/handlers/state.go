@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// stateMeta is the object metadata holding an asset's state when it
+// isn't published, which the edge Worker reads too
+const stateMeta = "state"
+
+var errSameState = errors.New("asset is already in that state")
+
+// uploadState reads the state an upload asks for, empty for published
+func uploadState(s string) (string, error) {
+	switch s {
+	case "", index.StatePublished:
+		return "", nil
+	case index.StateDraft:
+		return s, nil
+	}
+	return "", errors.New("state must be draft or published")
+}
+
+// AssetStateResponse is an asset's state after a change
+type AssetStateResponse struct {
+	Key   string `json:"key"`
+	State string `json:"state"`
+	URL   string `json:"url"`
+}
+
+// SetState moves key to state. Publishing makes it public now, dropping
+// any publish time and an unpublish time already past; draft and archived
+// take it off the public path. The change is made to the object's
+// metadata only if the object is unchanged since it was read, so it
+// can't race another. It fails with errSameState when key is in state
+// already.
+func (h *MediaHandler) SetState(ctx context.Context, key, state string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	head, err := h.r2Client.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	current := scheduleFromMetadata(head.Metadata)
+	if state == index.StatePublished {
+		state = ""
+	}
+	if current.State == state && (state != "" || !current.hidden(now)) {
+		return errSameState
+	}
+
+	metadata := make(map[string]string, len(head.Metadata)+1)
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	delete(metadata, stateMeta)
+	if state != "" {
+		metadata[stateMeta] = state
+	} else {
+		delete(metadata, publishAtMeta)
+		if current.UnpublishAt != nil && !now.Before(*current.UnpublishAt) {
+			delete(metadata, unpublishAtMeta)
+		}
+	}
+	if err := h.r2Client.ReplaceMetadata(ctx, key, aws.ToString(head.ContentType), metadata, aws.ToString(head.ETag)); err != nil {
+		return err
+	}
+
+	schedule := scheduleFromMetadata(metadata)
+	if h.index != nil {
+		h.index.Update(key, func(e *index.Entry) {
+			e.State, e.PublishAt, e.UnpublishAt = schedule.State, schedule.PublishAt, schedule.UnpublishAt
+		})
+	}
+
+	data := map[string]interface{}{"key": key, "url": h.publicURL(key), "state": index.StatePublished}
+	if state != "" {
+		data["state"] = state
+		log.Printf("Publishing: %s is now %s", key, state)
+		h.events.Publish(events.New(events.AssetUnpublished, data))
+	} else {
+		log.Printf("Publishing: %s is promoted", key)
+		h.events.Publish(events.New(events.AssetPublished, data))
+	}
+	return nil
+}
+
+// PromoteAsset publishes a draft or archived asset and purges its public
+// URL from the edge cache
+func (h *MediaHandler) PromoteAsset(w http.ResponseWriter, r *http.Request) {
+	h.changeState(w, r, index.StatePublished)
+}
+
+// ArchiveAsset takes an asset off the public path, keeping it readable
+// through signed URLs, and purges its public URL from the edge cache
+func (h *MediaHandler) ArchiveAsset(w http.ResponseWriter, r *http.Request) {
+	h.changeState(w, r, index.StateArchived)
+}
+
+func (h *MediaHandler) changeState(w http.ResponseWriter, r *http.Request, state string) {
+	key := mux.Vars(r)["path"]
+	audit.Annotate(r, key, map[string]string{"state": state})
+
+	switch err := h.SetState(r.Context(), key, state); {
+	case err == nil:
+	case errors.Is(err, ErrReservedKey):
+		respondError(w, http.StatusForbidden, apierror.ReservedKey, err.Error())
+		return
+	case errors.Is(err, ErrInvalidKey):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	case errors.Is(err, errSameState):
+		respondError(w, http.StatusConflict, apierror.Conflict, fmt.Sprintf("Asset is already %s", state))
+		return
+	case storage.IsNotFound(err):
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	case storage.IsPreconditionFailed(err):
+		respondError(w, http.StatusConflict, apierror.AssetChanged, "The asset changed while its state was set; retry")
+		return
+	default:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to set state")
+		return
+	}
+
+	if err := h.purgeKey(key); err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusBadGateway, apierror.Upstream, fmt.Sprintf("Asset is %s, but purging it from the CDN cache failed; purge it again", state))
+		return
+	}
+	respondJSON(w, http.StatusOK, AssetStateResponse{Key: key, State: state, URL: h.assetURL(withPublicBase(r.Context(), h.baseURL(r)), key, false)})
+}
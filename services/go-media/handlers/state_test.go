@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+)
+
+func TestUploadState(t *testing.T) {
+	for in, want := range map[string]string{"": "", "published": "", "draft": "draft"} {
+		if got, err := uploadState(in); err != nil || got != want {
+			t.Errorf("uploadState(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"archived", "Draft", "public"} {
+		if _, err := uploadState(in); err == nil {
+			t.Errorf("uploadState(%q) accepted", in)
+		}
+	}
+}
+
+func TestStateMetadata(t *testing.T) {
+	now := time.Now()
+	s := publishSchedule{State: index.StateDraft}
+	if s.metadata()[stateMeta] != "draft" || !s.withheld() || !s.hidden(now) {
+		t.Errorf("draft schedule = %v", s.metadata())
+	}
+	if back := scheduleFromMetadata(s.metadata()); back.State != index.StateDraft {
+		t.Errorf("metadata round trip = %+v", back)
+	}
+	if s := scheduleFromMetadata(map[string]string{stateMeta: "bogus"}); !s.isZero() {
+		t.Errorf("unknown state read as %+v", s)
+	}
+	past := now.Add(-time.Minute)
+	if s := (publishSchedule{UnpublishAt: &past}); s.withheld() || !s.hidden(now) {
+		t.Error("withdrawn schedule should be hidden but not withheld")
+	}
+}
+
+func TestChangeStateRejectsKeys(t *testing.T) {
+	h := NewMediaHandler(nil, "secret")
+	for key, want := range map[string]int{"../etc/passwd": 400, ".trash/a.png": 403} {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/v1/media/promote/"+key, nil), map[string]string{"path": key})
+		h.PromoteAsset(w, r)
+		if w.Code != want {
+			t.Errorf("promote %s: status %d, want %d", key, w.Code, want)
+		}
+	}
+}
+
+func TestDraftHiddenFromServing(t *testing.T) {
+	idx, _ := index.Open("")
+	h := NewMediaHandler(nil, "secret", WithIndex(idx))
+	idx.Update("press/hero.jpg", func(e *index.Entry) { e.State = index.StateDraft })
+
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("GET", "/v1/media/assets/press/hero.jpg", nil), map[string]string{"path": "press/hero.jpg"})
+	h.ServeAsset(w, r)
+	if w.Code != 404 {
+		t.Errorf("draft served with status %d", w.Code)
+	}
+}
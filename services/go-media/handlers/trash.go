@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/WomB0ComB0/cdn/services/go-media/validation"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	// trashPrefix holds deleted objects at their original keys until the
+	// trash_purge job deletes them
+	trashPrefix = ".trash/"
+
+	// maxRestoreObjects bounds the objects one request restores
+	maxRestoreObjects = 1000
+
+	// restoreConcurrency is the number of objects restored at once
+	restoreConcurrency = 8
+)
+
+// Restore errors, reported per object
+var (
+	errNotInTrash    = errors.New("not in the trash")
+	errRestoreExists = errors.New("an object exists at the key")
+)
+
+// WithTrash moves deleted objects to the trash rather than deleting
+// them, when enabled
+func WithTrash(enabled bool) Option {
+	return func(h *MediaHandler) {
+		h.trash = enabled
+	}
+}
+
+// WithTrashRetention keeps deleted objects in the trash for days before
+// PurgeTrash deletes them; 0 keeps them forever
+func WithTrashRetention(days int) Option {
+	return func(h *MediaHandler) {
+		h.trashRetention = days
+	}
+}
+
+// PurgeTrash deletes the objects that have been in the trash for longer
+// than its retention
+func (h *MediaHandler) PurgeTrash(ctx context.Context) error {
+	if h.trashRetention <= 0 {
+		return nil
+	}
+	n, err := expireUnder(ctx, trashPrefix, time.Now().AddDate(0, 0, -h.trashRetention), h.r2Client.ListObjectsPage, h.r2Client.DeleteObject)
+	if n > 0 {
+		log.Printf("Trash: purged %d objects", n)
+	}
+	return err
+}
+
+// trashed reports whether key is a deleted object in the trash
+func trashed(key string) bool {
+	return strings.HasPrefix(key, trashPrefix)
+}
+
+// trashObject copies key to the trash ahead of its deletion. Objects
+// under a customer-provided key, which can't be restored without it, and
+// keys too long to move are deleted outright.
+func (h *MediaHandler) trashObject(ctx context.Context, key string) error {
+	if !h.trash || storage.HasCustomerKey(ctx) || len(trashPrefix)+len(key) > maxKeyLength {
+		return nil
+	}
+	if h.index != nil {
+		if e, ok := h.index.Get(key); ok && e.CustomerKey {
+			return nil
+		}
+	}
+	head, err := h.r2Client.HeadObject(ctx, key)
+	if storage.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.r2Client.CopyObject(ctx, key, trashPrefix+key, aws.ToString(head.ContentType), head.Metadata, aws.ToString(head.ETag))
+}
+
+// TrashItem is a deleted object, by the key it had
+type TrashItem struct {
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type TrashListing struct {
+	Items      []TrashItem `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type TrashUsage struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// TrashRestoreRequest selects the deleted objects to restore: explicit
+// keys or every one deleted from under a prefix. Objects are restored at
+// the keys they had, over any object there since only with Overwrite.
+type TrashRestoreRequest struct {
+	Keys      []string `json:"keys,omitempty" validate:"dive,required"`
+	Prefix    string   `json:"prefix,omitempty"`
+	Overwrite bool     `json:"overwrite,omitempty"`
+}
+
+func (req TrashRestoreRequest) Check() validation.Errors {
+	return keysOrPrefix(req.Keys, req.Prefix)
+}
+
+type TrashRestoreResponse struct {
+	Restored int                   `json:"restored"`
+	Skipped  []string              `json:"skipped,omitempty"`
+	Failed   []workpool.KeyFailure `json:"failed,omitempty"`
+}
+
+func (h *MediaHandler) trashEnabled(w http.ResponseWriter) bool {
+	if !h.trash {
+		respondError(w, http.StatusNotImplemented, apierror.NotEnabled, "Trash not enabled")
+		return false
+	}
+	return true
+}
+
+// ListTrash lists deleted objects, a page at a time.
+// Query parameters: prefix (of the original keys), cursor, limit
+// (default 100).
+func (h *MediaHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	if !h.trashEnabled(w) {
+		return
+	}
+	query := r.URL.Query()
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.ListPage(r.Context(), storage.ListOptions{
+		Prefix:            trashPrefix + query.Get("prefix"),
+		ContinuationToken: query.Get("cursor"),
+		MaxKeys:           int32(limit),
+	})
+	if err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return
+	}
+	listing := TrashListing{Items: make([]TrashItem, len(page.Objects))}
+	for i, obj := range page.Objects {
+		listing.Items[i] = TrashItem{Key: strings.TrimPrefix(obj.Key, trashPrefix), Size: obj.Size, DeletedAt: obj.LastModified}
+	}
+	if page.IsTruncated {
+		listing.NextCursor = page.NextContinuationToken
+	}
+	respondJSON(w, http.StatusOK, listing)
+}
+
+// TrashUsage reports the objects in the trash and the space they hold
+func (h *MediaHandler) TrashUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.trashEnabled(w) {
+		return
+	}
+	var usage TrashUsage
+	opts := storage.ListOptions{Prefix: trashPrefix, MaxKeys: 1000}
+	for {
+		page, err := h.ListPage(r.Context(), opts)
+		if err != nil {
+			h.reporter.CaptureError(r, err)
+			respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+			return
+		}
+		for _, obj := range page.Objects {
+			usage.Objects++
+			usage.Bytes += obj.Size
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+	respondJSON(w, http.StatusOK, usage)
+}
+
+// RestoreTrash puts up to maxRestoreObjects deleted objects back at the
+// keys they had
+func (h *MediaHandler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	if !h.trashEnabled(w) {
+		return
+	}
+	var req TrashRestoreRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	keys := req.Keys
+	var err error
+	if req.Prefix != "" {
+		var objects []storage.Object
+		objects, err = h.prefixObjects(r.Context(), trashPrefix+req.Prefix, maxRestoreObjects)
+		for _, obj := range objects {
+			keys = append(keys, strings.TrimPrefix(obj.Key, trashPrefix))
+		}
+	} else if len(keys) > maxRestoreObjects {
+		err = errTooManyObjects
+	}
+	switch {
+	case errors.Is(err, errTooManyObjects):
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("At most %d objects can be restored at once", maxRestoreObjects))
+		return
+	case err != nil:
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to list objects")
+		return
+	case len(keys) == 0:
+		respondError(w, http.StatusNotFound, apierror.NotFound, "No deleted objects under prefix")
+		return
+	}
+
+	resp := restoreAll(r.Context(), keys, func(ctx context.Context, key string) error {
+		return h.restoreObject(ctx, key, req.Overwrite)
+	})
+	audit.Annotate(r, req.Prefix, map[string]string{"objects": strconv.Itoa(resp.Restored), "failed": strconv.Itoa(len(resp.Failed))})
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// restoreObject moves key back from the trash and indexes it again. Its
+// offloaded copy was dropped with it, so it is served from R2 until
+// uploaded again.
+func (h *MediaHandler) restoreObject(ctx context.Context, key string, overwrite bool) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	head, err := h.r2Client.HeadObject(ctx, trashPrefix+key)
+	if storage.IsNotFound(err) {
+		return errNotInTrash
+	} else if err != nil {
+		return err
+	}
+	if !overwrite {
+		if _, err := h.r2Client.HeadObject(ctx, key); err == nil {
+			return errRestoreExists
+		} else if !storage.IsNotFound(err) {
+			return err
+		}
+	}
+
+	metadata := make(map[string]string, len(head.Metadata))
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	delete(metadata, offloadServiceMeta)
+	delete(metadata, offloadIDMeta)
+	contentType := aws.ToString(head.ContentType)
+	if err := h.r2Client.CopyObject(ctx, trashPrefix+key, key, contentType, metadata, aws.ToString(head.ETag)); err != nil {
+		return err
+	}
+	if err := h.r2Client.DeleteObject(ctx, trashPrefix+key); err != nil {
+		// Restored all the same; the copy left behind is purged in time
+		log.Printf("Trash: failed to remove restored %s: %v", key, err)
+	}
+
+	restored, err := h.HeadAsset(ctx, key)
+	if err != nil {
+		return err
+	}
+	size, etag := aws.ToInt64(restored.ContentLength), aws.ToString(restored.ETag)
+	if h.index != nil {
+		schedule := scheduleFromMetadata(metadata)
+		h.index.Update(key, func(e *index.Entry) {
+			e.State, e.PublishAt, e.UnpublishAt = schedule.State, schedule.PublishAt, schedule.UnpublishAt
+			e.Size = size
+			e.ContentType = contentType
+			e.ETag = etag
+			e.Integrity = ""
+			e.Text = ""
+			e.UpdatedAt = time.Now().UTC()
+		})
+	}
+	h.indexTextLater(key, contentType, etag, size)
+	h.events.Publish(events.New(events.AssetRestored, map[string]interface{}{
+		"key":          key,
+		"url":          h.assetURL(ctx, key, h.sealed(key)),
+		"size":         size,
+		"content_type": contentType,
+	}))
+	return nil
+}
+
+// restoreAll restores every key with restore, a few at a time
+func restoreAll(ctx context.Context, keys []string, restore func(ctx context.Context, key string) error) TrashRestoreResponse {
+	var resp TrashRestoreResponse
+	workpool.Each(keys, restoreConcurrency, func(key string) func() {
+		err := restore(ctx, key)
+		return func() {
+			switch {
+			case errors.Is(err, errRestoreExists):
+				resp.Skipped = append(resp.Skipped, key)
+			case err != nil:
+				resp.Failed = append(resp.Failed, workpool.KeyFailure{Key: key, Error: err.Error()})
+			default:
+				resp.Restored++
+			}
+		}
+	})
+	return resp
+}
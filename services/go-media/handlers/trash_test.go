@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRestoreAll(t *testing.T) {
+	resp := restoreAll(context.Background(), []string{"a.png", "taken.png", "gone.png"}, func(ctx context.Context, key string) error {
+		switch key {
+		case "taken.png":
+			return errRestoreExists
+		case "gone.png":
+			return errNotInTrash
+		}
+		return nil
+	})
+	if resp.Restored != 1 || len(resp.Skipped) != 1 || resp.Skipped[0] != "taken.png" {
+		t.Errorf("restoreAll() = %+v", resp)
+	}
+	if len(resp.Failed) != 1 || resp.Failed[0].Key != "gone.png" || resp.Failed[0].Error != errNotInTrash.Error() {
+		t.Errorf("failed = %+v", resp.Failed)
+	}
+}
+
+// The trash is reserved, so deleted objects can't be written or deleted
+// through the API, and isn't indexed
+func TestTrashReserved(t *testing.T) {
+	if err := ValidateKey(trashPrefix + "a.png"); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("ValidateKey() = %v, want ErrReservedKey", err)
+	}
+	if !internalKey(trashPrefix + "a.png") {
+		t.Error("trash not internal")
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+)
+
+// uploadTokenHeader carries an upload token; browsers may send it as
+// the "token" form field instead
+const uploadTokenHeader = "X-Upload-Token"
+
+// uploadFormOverhead allows for the multipart framing and other fields
+// around a file when an upload token limits the request body
+const uploadFormOverhead = 64 << 10
+
+// Upload token errors, reported to clients as unauthorized
+var (
+	errInvalidUploadToken = errors.New("invalid or expired upload token")
+	errUploadTokenUsed    = errors.New("upload token already used")
+)
+
+// UploadGrant is what an upload token authorizes: one upload of at most
+// MaxSize bytes, of one of ContentTypes ("image/*" matches any image;
+// empty allows any), stored under Prefix
+type UploadGrant struct {
+	ID           string   `json:"id"`
+	MaxSize      int64    `json:"max_size"`
+	ContentTypes []string `json:"content_types,omitempty"`
+	Prefix       string   `json:"prefix"`
+	// KeyStrategy, when set, names the upload regardless of the request
+	KeyStrategy KeyStrategy `json:"key_strategy,omitempty"`
+	ExpiresAt   int64       `json:"exp"`
+}
+
+// UploadTokenRequest sets the constraints of a new upload token
+type UploadTokenRequest struct {
+	MaxSize      int64    `json:"max_size"`
+	ContentTypes []string `json:"content_types" validate:"dive,required"`
+	Prefix       string   `json:"prefix"`
+	KeyStrategy  string   `json:"key_strategy"`
+	ExpiresIn    int64    `json:"expires_in" validate:"min=0,max=86400"` // seconds, at most a day
+}
+
+type UploadTokenResponse struct {
+	Token     string      `json:"token"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	Grant     UploadGrant `json:"grant"`
+}
+
+// WithUploadTokens makes uploads without an upload token fail when
+// required is set
+func WithUploadTokens(required bool) Option {
+	return func(h *MediaHandler) {
+		h.requireUploadToken = required
+	}
+}
+
+// usedTokens remembers spent upload tokens until they expire
+type usedTokens struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// claim marks id used, failing if it already was
+func (u *usedTokens) claim(id string, expires time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	if u.ids == nil {
+		u.ids = make(map[string]time.Time)
+	}
+	for used, exp := range u.ids {
+		if now.After(exp) {
+			delete(u.ids, used)
+		}
+	}
+	if _, ok := u.ids[id]; ok {
+		return false
+	}
+	u.ids[id] = expires
+	return true
+}
+
+// release makes id usable again after an upload that failed
+func (u *usedTokens) release(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.ids, id)
+}
+
+// IssueUploadToken returns a token authorizing a single browser upload
+// within the requested constraints, so frontends can upload without
+// holding credentials
+func (h *MediaHandler) IssueUploadToken(w http.ResponseWriter, r *http.Request) {
+	var req UploadTokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.ExpiresIn == 0 {
+		req.ExpiresIn = 600
+	}
+	ttl := time.Duration(req.ExpiresIn) * time.Second
+	if req.MaxSize <= 0 || req.MaxSize > h.maxUploadSize {
+		req.MaxSize = h.maxUploadSize
+	}
+	if req.Prefix == "" {
+		req.Prefix = "assets/"
+	}
+	if !validUploadPrefix(req.Prefix) {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid prefix")
+		return
+	}
+	strategy, err := ParseKeyStrategy(req.KeyStrategy)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		h.reporter.CaptureError(r, err)
+		respondError(w, http.StatusInternalServerError, apierror.Internal, "Failed to issue token")
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	grant := UploadGrant{
+		ID:           hex.EncodeToString(id),
+		MaxSize:      req.MaxSize,
+		ContentTypes: req.ContentTypes,
+		Prefix:       req.Prefix,
+		KeyStrategy:  strategy,
+		ExpiresAt:    expiresAt.Unix(),
+	}
+	audit.Annotate(r, req.Prefix, map[string]string{"token_id": grant.ID})
+
+	respondJSON(w, http.StatusOK, UploadTokenResponse{
+		Token:     h.signUploadToken(grant),
+		ExpiresAt: expiresAt,
+		Grant:     grant,
+	})
+}
+
+// validUploadPrefix accepts a directory prefix uploads may target
+func validUploadPrefix(prefix string) bool {
+	return strings.HasSuffix(prefix, "/") && ValidateKey(prefix) == nil
+}
+
+// signUploadToken encodes grant with an HMAC of it
+func (h *MediaHandler) signUploadToken(grant UploadGrant) string {
+	payload, _ := json.Marshal(grant)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + h.tokenMAC("upload-token", encoded)
+}
+
+// tokenMAC signs an encoded token payload; purpose keeps one kind of
+// token from being accepted as another
+func (h *MediaHandler) tokenMAC(purpose, encoded string) string {
+	mac := hmac.New(sha256.New, []byte(h.signingSecret))
+	mac.Write([]byte(purpose + ":" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseUploadToken verifies a token and returns its unexpired grant
+func (h *MediaHandler) parseUploadToken(token string) (*UploadGrant, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(h.tokenMAC("upload-token", encoded))) {
+		return nil, errInvalidUploadToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidUploadToken
+	}
+	var grant UploadGrant
+	if err := json.Unmarshal(payload, &grant); err != nil || time.Now().Unix() > grant.ExpiresAt {
+		return nil, errInvalidUploadToken
+	}
+	return &grant, nil
+}
+
+// allows reports whether the grant accepts a file of contentType
+func (g *UploadGrant) allows(contentType string) bool {
+	if len(g.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	for _, allowed := range g.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
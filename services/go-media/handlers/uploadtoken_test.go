@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func issueUploadToken(t *testing.T, h *MediaHandler, body string) (*httptest.ResponseRecorder, UploadTokenResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.IssueUploadToken(w, httptest.NewRequest("POST", "/v1/media/upload-token", strings.NewReader(body)))
+	var resp UploadTokenResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func uploadRequest(t *testing.T, token, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", filename)
+	fw.Write(content)
+	mw.Close()
+	r := httptest.NewRequest("POST", "/v1/media/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if token != "" {
+		r.Header.Set(uploadTokenHeader, token)
+	}
+	return r
+}
+
+func TestIssueUploadToken(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret")
+
+	w, resp := issueUploadToken(t, h, `{"max_size": 1024, "content_types": ["image/*"], "prefix": "avatars/", "expires_in": 300}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	grant, err := h.parseUploadToken(resp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.MaxSize != 1024 || grant.Prefix != "avatars/" || grant.ID == "" || grant.ExpiresAt != resp.ExpiresAt.Unix() {
+		t.Errorf("grant = %+v", grant)
+	}
+
+	// Tampered or foreign tokens are rejected
+	if _, err := h.parseUploadToken(strings.Replace(resp.Token, ".", "x.", 1)); err == nil {
+		t.Error("tampered token accepted")
+	}
+	other := NewMediaHandler(nil, "other-secret")
+	if _, err := other.parseUploadToken(resp.Token); err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+	expired := h.signUploadToken(UploadGrant{ID: "x", Prefix: "assets/", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if _, err := h.parseUploadToken(expired); err == nil {
+		t.Error("expired token accepted")
+	}
+
+	for _, body := range []string{
+		`{"prefix": "avatars"}`,
+		`{"prefix": "../avatars/"}`,
+		`{"prefix": "_min/"}`,
+		`{"expires_in": 172800}`,
+		`{"key_strategy": "sha1"}`,
+	} {
+		if w, _ := issueUploadToken(t, h, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestUploadWithToken(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret", WithUploadTokens(true))
+	_, resp := issueUploadToken(t, h, `{"max_size": 16, "content_types": ["image/png"]}`)
+
+	tests := []struct {
+		name     string
+		token    string
+		filename string
+		content  []byte
+		want     int
+	}{
+		{"no token", "", "a.png", []byte("\x89PNG\r\n\x1a\n"), http.StatusUnauthorized},
+		{"bad token", "nope", "a.png", []byte("\x89PNG\r\n\x1a\n"), http.StatusUnauthorized},
+		{"too large", resp.Token, "a.png", bytes.Repeat([]byte("x"), 1024), http.StatusBadRequest},
+		{"wrong type", resp.Token, "a.txt", []byte("hello"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.Upload(w, uploadRequest(t, tt.token, tt.filename, tt.content))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+func TestUsedTokens(t *testing.T) {
+	var used usedTokens
+	exp := time.Now().Add(time.Minute)
+	if !used.claim("a", exp) || used.claim("a", exp) {
+		t.Error("a token must be claimable exactly once")
+	}
+	used.release("a")
+	if !used.claim("a", exp) {
+		t.Error("released token can't be claimed")
+	}
+
+	// Expired entries are forgotten
+	used.claim("old", time.Now().Add(-time.Second))
+	used.claim("b", exp)
+	if _, ok := used.ids["old"]; ok {
+		t.Error("expired token kept")
+	}
+}
+
+func TestUploadGrantAllows(t *testing.T) {
+	g := UploadGrant{ContentTypes: []string{"image/*", "application/pdf"}}
+	for ct, want := range map[string]bool{
+		"image/png":                 true,
+		"IMAGE/JPEG":                true,
+		"application/pdf":           true,
+		"text/plain; charset=utf-8": false,
+		"imagex/png":                false,
+	} {
+		if got := g.allows(ct); got != want {
+			t.Errorf("allows(%q) = %v, want %v", ct, got, want)
+		}
+	}
+	if !(&UploadGrant{}).allows("anything/else") {
+		t.Error("a grant without types should allow any")
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+// publicBaseURL is where the edge serves public assets by key, unless
+// configured otherwise
+const publicBaseURL = "https://cdn.mikeodnis.dev"
+
+// WithPublicURLs sets the base URLs handed out for assets: cfg's public
+// base URL, the host a request arrived on when it is one of cfg's hosts,
+// and the custom domains of cfg's prefixes
+func WithPublicURLs(cfg config.URLsConfig) Option {
+	return func(h *MediaHandler) {
+		if cfg.PublicBaseURL != "" {
+			h.publicBase = strings.TrimSuffix(cfg.PublicBaseURL, "/")
+		}
+		h.publicHosts = make(map[string]bool)
+		for _, host := range cfg.Hosts {
+			h.publicHosts[strings.ToLower(host)] = true
+		}
+		h.domains = append([]config.URLDomain(nil), cfg.Domains...)
+		// The longest prefix wins
+		sort.SliceStable(h.domains, func(i, j int) bool {
+			return len(h.domains[i].Prefix) > len(h.domains[j].Prefix)
+		})
+	}
+}
+
+// baseURL is the public base URL for the client making r: the host it
+// reached the service on, when that is one of the public hosts, its
+// region's, when regions are configured, and otherwise the configured one
+func (h *MediaHandler) baseURL(r *http.Request) string {
+	if h.publicHosts[strings.ToLower(r.Host)] {
+		return h.hostURL(r.Host)
+	}
+	if h.regions != nil {
+		if base, ok := h.regions.byCountry[strings.ToUpper(r.Header.Get(h.regions.header))]; ok {
+			return base
+		}
+		return h.regions.home
+	}
+	return h.publicBase
+}
+
+// hostURL is the base URL of host, one of the public hosts, with the
+// configured base URL's scheme
+func (h *MediaHandler) hostURL(host string) string {
+	scheme := "https"
+	if u, err := url.Parse(h.publicBase); err == nil {
+		scheme = u.Scheme
+	}
+	return scheme + "://" + host
+}
+
+// keyBaseURL is the base URL of the public URL of path, a key or one of
+// its variants: the custom domain of its prefix, if it has one, or base
+func (h *MediaHandler) keyBaseURL(path, base string) string {
+	key := strings.TrimPrefix(path, derivedPrefix)
+	for _, d := range h.domains {
+		if strings.HasPrefix(key, d.Prefix) {
+			return strings.TrimSuffix(d.BaseURL, "/")
+		}
+	}
+	return base
+}
+
+// keyURL is the public URL of path, a key or one of its variants: at the
+// root of the verified custom domain serving it, if there is one, and
+// otherwise on keyBaseURL
+func (h *MediaHandler) keyURL(path, base string) string {
+	if h.customDomains != nil {
+		if d, ok := h.customDomains.ServingKey(path); ok {
+			return "https://" + d.Host + "/" + strings.TrimPrefix(path, d.Prefix)
+		}
+	}
+	return h.keyBaseURL(path, base) + "/" + path
+}
+
+// publicURL is the URL the edge serves path, a key or one of its
+// variants, from. It doesn't depend on a request, so it is what URLs
+// stored or sent in events use.
+func (h *MediaHandler) publicURL(path string) string {
+	return h.keyURL(path, h.publicBase)
+}
+
+type publicBaseKey struct{}
+
+// withPublicBase makes the asset URLs built for ctx's request use base,
+// from baseURL
+func withPublicBase(ctx context.Context, base string) context.Context {
+	return context.WithValue(ctx, publicBaseKey{}, base)
+}
+
+// assetURL is where clients fetch the object at key: on the base URL of
+// ctx's request, or the configured one. Private objects are readable only
+// once signed (see SignURL).
+func (h *MediaHandler) assetURL(ctx context.Context, key string, private bool) string {
+	base, ok := ctx.Value(publicBaseKey{}).(string)
+	if !ok {
+		base = h.publicBase
+	}
+	if private {
+		return h.keyBaseURL(key, base) + "/v1/media/private/" + key
+	}
+	return h.keyURL(key, base)
+}
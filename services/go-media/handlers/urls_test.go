@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+func TestPublicURLs(t *testing.T) {
+	h := NewMediaHandler(nil, "secret", WithPublicURLs(config.URLsConfig{
+		PublicBaseURL: "https://cdn.example.com/",
+		Hosts:         []string{"static.example.org", "Media.Example.net"},
+		Domains: []config.URLDomain{
+			{Prefix: "tenants/", BaseURL: "https://tenants.example.com"},
+			{Prefix: "tenants/acme/", BaseURL: "https://assets.acme.test/"},
+		},
+	}))
+
+	tests := []struct {
+		host, key, want string
+	}{
+		{"api.example.com", "photos/team.jpg", "https://cdn.example.com/photos/team.jpg"},
+		{"static.example.org", "photos/team.jpg", "https://static.example.org/photos/team.jpg"},
+		{"media.example.net", "photos/team.jpg", "https://media.example.net/photos/team.jpg"},
+		// Custom domains win over the host, and the longest prefix wins
+		{"static.example.org", "tenants/acme/logo.png", "https://assets.acme.test/tenants/acme/logo.png"},
+		{"api.example.com", "tenants/globex/logo.png", "https://tenants.example.com/tenants/globex/logo.png"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = tt.host
+		if got := h.assetURL(withPublicBase(context.Background(), h.baseURL(r)), tt.key, false); got != tt.want {
+			t.Errorf("%s on %s: assetURL() = %s, want %s", tt.key, tt.host, got, tt.want)
+		}
+	}
+
+	// URLs that outlive the request don't depend on its host
+	if got := h.publicURL(derivedKey("tenants/acme/intro.mp4", "abc", "sprites")); got != "https://assets.acme.test/derived/tenants/acme/intro.mp4/abc/sprites" {
+		t.Errorf("publicURL() = %s", got)
+	}
+	if got := h.assetURL(context.Background(), "reports/q3.pdf", true); got != "https://cdn.example.com/v1/media/private/reports/q3.pdf" {
+		t.Errorf("assetURL() = %s without a request", got)
+	}
+
+	r := httptest.NewRequest("POST", "/v1/media/sign", strings.NewReader(`{"path": "reports/q3.pdf"}`))
+	r.Host = "static.example.org"
+	w := httptest.NewRecorder()
+	h.GenerateSignedURL(w, r)
+	var signed SignedURLResponse
+	json.NewDecoder(w.Body).Decode(&signed)
+	if !strings.HasPrefix(signed.URL, "https://static.example.org/v1/media/private/reports/q3.pdf?") {
+		t.Errorf("signed URL = %s", signed.URL)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// assetVariant is the stored object that answers a request for an asset:
+// the asset itself, a precompressed sidecar or a derived variant
+type assetVariant struct {
+	key       string // the requested asset
+	objectKey string // the object served
+	encoding  string // Content-Encoding of a precompressed sidecar
+	transform string // fingerprint of a derived variant, such as "min"
+}
+
+// selectVariant picks the object to serve for key. ?min=1 serves a
+// minified variant of JS and CSS, ?w=, ?h=, ?fit=, ?format= and ?q= a
+// transformed image, ?format=, ?bitrate= and ?normalize= converted audio
+// and ?format=pdf an office document as PDF, and ?subset= and ?text= a
+// font subset as WOFF2; otherwise precompressed sidecars (app.js.br,
+// app.js.gz) stand in for the asset when the client accepts their
+// encoding. It fails with an imaging error for bad image options,
+// an audio error for bad audio options, an error for bad font subset
+// options, errImageSignature for an unsigned
+// image or font subset URL that needs a signature, and
+// with workpool.ErrSaturated when a variant needs building and the
+// transformation pool is full.
+func (h *MediaHandler) selectVariant(ctx context.Context, r *http.Request, key string) (assetVariant, error) {
+	v := assetVariant{key: key}
+	d, ok := wantsMinified(r, key)
+	if !ok {
+		var err error
+		if d, ok, err = h.wantsImage(r, key); err != nil {
+			return v, err
+		}
+	}
+	if !ok {
+		var err error
+		if d, ok, err = h.wantsAudio(r, key); err != nil {
+			return v, err
+		}
+	}
+	if !ok {
+		d, ok = h.wantsDocument(r, key)
+	}
+	if !ok {
+		var err error
+		if d, ok, err = h.wantsFontSubset(r, key); err != nil {
+			return v, err
+		}
+	}
+	if ok {
+		var err error
+		v.objectKey, v.transform, err = h.derivedVariant(ctx, r, key, d)
+		return v, err
+	}
+	v.objectKey, v.encoding = h.precompressedVariant(ctx, r, key)
+	return v, nil
+}
+
+// etag returns the ETag of the representation. Variants get the ETag of
+// what they were built from plus a fingerprint ("abc-br", "abc-min"), so
+// caches never confuse them with the original or with each other, and a
+// derived variant's ETag changes exactly when its source does.
+func (v assetVariant) etag(objectETag *string, metadata map[string]string) *string {
+	if objectETag == nil {
+		return nil
+	}
+	base, fingerprint := *objectETag, v.encoding
+	if v.transform != "" {
+		fingerprint = v.transform
+		if src := metadata[sourceETagMeta]; src != "" {
+			base = src
+		}
+	}
+	if fingerprint == "" {
+		return objectETag
+	}
+	etag := variantETag(base, fingerprint)
+	return &etag
+}
+
+// variantETag appends fingerprint to an entity tag, keeping it weak if
+// it was weak
+func variantETag(etag, fingerprint string) string {
+	weak := strings.HasPrefix(etag, "W/")
+	opaque := strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	tagged := `"` + opaque + "-" + fingerprint + `"`
+	if weak {
+		return "W/" + tagged
+	}
+	return tagged
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// comparing weakly as RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
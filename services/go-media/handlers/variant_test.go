@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVariantETag(t *testing.T) {
+	etag := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		v        assetVariant
+		object   string
+		metadata map[string]string
+		want     string
+	}{
+		{"original", assetVariant{key: "app.js", objectKey: "app.js"}, `"abc"`, nil, `"abc"`},
+		{"sidecar", assetVariant{key: "app.js", objectKey: "app.js.br", encoding: "br"}, `"def"`, nil, `"def-br"`},
+		{"weak sidecar", assetVariant{key: "app.js", objectKey: "app.js.gz", encoding: "gzip"}, `W/"def"`, nil, `W/"def-gzip"`},
+		{"minified", assetVariant{key: "app.js", objectKey: "derived/app.js/abc/min", transform: "min"}, `"xyz"`, map[string]string{sourceETagMeta: `"abc"`}, `"abc-min"`},
+		{"minified without source", assetVariant{key: "app.js", objectKey: "derived/app.js/abc/min", transform: "min"}, `"xyz"`, nil, `"xyz-min"`},
+	}
+	for _, tt := range tests {
+		if got := tt.v.etag(etag(tt.object), tt.metadata); *got != tt.want {
+			t.Errorf("%s: etag = %s, want %s", tt.name, *got, tt.want)
+		}
+	}
+	if got := (assetVariant{encoding: "br"}).etag(nil, nil); got != nil {
+		t.Errorf("etag(nil) = %s, want nil", *got)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		want         bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`"abc"`, `"abc-br"`, false},
+		{`"x", "abc-br"`, `"abc-br"`, true},
+		{`W/"abc"`, `"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{"*", `"abc"`, true},
+		{"", `"abc"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%s, %s) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestSetVariantHeaders(t *testing.T) {
+	h := &MediaHandler{}
+	tag := `"abc-br"`
+
+	w := httptest.NewRecorder()
+	h.setVariantHeaders(w, assetVariant{key: "static/app.js", encoding: "br"}, &tag)
+	if got := w.Header().Get("ETag"); got != tag {
+		t.Errorf("ETag = %s, want %s", got, tag)
+	}
+	if got := w.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+		t.Errorf("Vary = %v, want [Accept-Encoding]", got)
+	}
+	if w.Header().Get("Cache-Control") == "" {
+		t.Error("Cache-Control not set")
+	}
+
+	w = httptest.NewRecorder()
+	h.setVariantHeaders(w, assetVariant{key: "assets/photo.png"}, nil)
+	if got := w.Header().Get("Vary"); got != "" {
+		t.Errorf("photo Vary = %q, want none", got)
+	}
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/video"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// videoTransformPrefix starts the transform of an HLS package, followed
+// by the preset's name. The package's other files are stored beside its
+// master playlist as <transform>_<file>.
+const videoTransformPrefix = "hls-"
+
+// transcodeTimeout bounds one video's transcode, upload included
+const transcodeTimeout = 2 * time.Hour
+
+// videos transcodes uploaded videos with the configured presets
+type videos struct {
+	transcoder    video.Transcoder
+	presets       map[string]video.Preset
+	defaultPreset string
+	tracks        bool
+	// spriteInterval separates seek-preview thumbnails; 0 makes none
+	spriteInterval time.Duration
+	// slots holds a token per transcode running
+	slots chan struct{}
+}
+
+// WithVideo transcodes uploaded videos to HLS, and extracts their tracks
+// and seek-preview sprites, as cfg describes, when cfg.Transcode is set
+func WithVideo(cfg config.VideoConfig) Option {
+	return func(h *MediaHandler) {
+		if !cfg.Transcode {
+			return
+		}
+		v := &videos{
+			transcoder:     video.Transcoder{FFmpeg: cfg.FFmpegPath, FFprobe: cfg.FFprobePath},
+			presets:        make(map[string]video.Preset, len(cfg.Presets)),
+			defaultPreset:  cfg.DefaultPreset,
+			tracks:         cfg.ExtractTracks,
+			spriteInterval: time.Duration(cfg.SpriteSeconds) * time.Second,
+			slots:          make(chan struct{}, cfg.Workers),
+		}
+		for _, p := range cfg.Presets {
+			v.presets[p.Name] = p
+		}
+		h.videos = v
+	}
+}
+
+// preset returns the preset called name, or the default one for ""
+func (v *videos) preset(name string) (video.Preset, bool) {
+	if v == nil {
+		return video.Preset{}, false
+	}
+	if name == "" {
+		name = v.defaultPreset
+	}
+	p, ok := v.presets[name]
+	return p, ok
+}
+
+// checkVideoPreset reports whether uploads may ask for the preset called
+// name; "" asks for none
+func (h *MediaHandler) checkVideoPreset(name string) error {
+	if name == "" {
+		return nil
+	}
+	if h.videos == nil {
+		return errors.New("video transcoding is not enabled")
+	}
+	if _, ok := h.videos.preset(name); !ok {
+		return fmt.Errorf("unknown video_preset %q", name)
+	}
+	return nil
+}
+
+type videoPresetKey struct{}
+
+// withVideoPreset makes the uploads stored with ctx transcode with the
+// preset called name instead of the default one
+func withVideoPreset(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, videoPresetKey{}, name)
+}
+
+func videoPresetFrom(ctx context.Context) string {
+	name, _ := ctx.Value(videoPresetKey{}).(string)
+	return name
+}
+
+// VideoTranscode is the HLS and DASH package a video upload is being
+// transcoded to
+type VideoTranscode struct {
+	Preset string `json:"preset"`
+	// Playlist is the URL of the package's master playlist, which exists
+	// once the asset.transcoded event is published
+	Playlist string `json:"playlist"`
+	// DASH is the URL of the package's DASH manifest, which lists the
+	// same segments
+	DASH string `json:"dash"`
+}
+
+// queueTranscode starts transcoding the video stored at key with etag to
+// HLS with the named preset, the default one for "", and extracting its
+// tracks and sprites. It returns nil for uploads that aren't videos or when
+// transcoding is off.
+func (h *MediaHandler) queueTranscode(key, contentType, etag, preset string) *VideoTranscode {
+	if !strings.HasPrefix(contentType, "video/") {
+		return nil
+	}
+	p, ok := h.videos.preset(preset)
+	if !ok {
+		return nil
+	}
+	h.queueVideo(key, etag, videoWork{
+		presets: []video.Preset{p},
+		tracks:  h.videos.tracks,
+		sprites: h.videos.spriteInterval > 0,
+	})
+	transform := videoTransformPrefix + p.Name
+	return &VideoTranscode{
+		Preset:   p.Name,
+		Playlist: h.publicURL(derivedKey(key, etag, transform)),
+		DASH:     h.publicURL(derivedKey(key, etag, video.DASHManifest(transform))),
+	}
+}
+
+// videoWork is what a video job makes from its source
+type videoWork struct {
+	presets []video.Preset // an HLS package for each
+	tracks  bool           // subtitle and alternate audio tracks
+	sprites bool           // seek-preview sprite sheets
+}
+
+// videoWorkFor returns the work rebuilding transform, and whether
+// transform is made by video jobs at all
+func (h *MediaHandler) videoWorkFor(transform string) (work videoWork, ok bool) {
+	if name, ok := strings.CutPrefix(transform, videoTransformPrefix); ok {
+		if p, ok := h.videos.preset(name); ok {
+			work.presets = []video.Preset{p}
+		}
+		return work, true
+	}
+	switch transform {
+	case tracksTransform:
+		work.tracks = h.videos != nil
+		return work, true
+	case spritesTransform:
+		work.sprites = h.videos != nil && h.videos.spriteInterval > 0
+		return work, true
+	}
+	return work, false
+}
+
+func (w videoWork) empty() bool {
+	return len(w.presets) == 0 && !w.tracks && !w.sprites
+}
+
+// queueVideo runs a video job in the background, once a slot is free.
+// Failures are logged, and leave the video served as uploaded.
+func (h *MediaHandler) queueVideo(key, etag string, work videoWork) {
+	go func() {
+		h.videos.slots <- struct{}{}
+		defer func() { <-h.videos.slots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+		defer cancel()
+		if err := h.processVideo(ctx, key, etag, work); err != nil {
+			log.Printf("Video: failed to process %s: %v", key, err)
+			h.reporter.CaptureError(nil, fmt.Errorf("processing video %s: %w", key, err))
+		}
+	}()
+}
+
+// processVideo makes work from the version of key with etag, storing it
+// under derived/, and announces the result
+func (h *MediaHandler) processVideo(ctx context.Context, key, etag string, work videoWork) error {
+	dir, err := os.MkdirTemp("", "video-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	obj, err := h.r2Client.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	if strings.Trim(aws.ToString(obj.ETag), `"`) != strings.Trim(etag, `"`) {
+		// Replaced since; the new version has its own job
+		obj.Body.Close()
+		return nil
+	}
+	input := filepath.Join(dir, "source"+path.Ext(key))
+	f, err := os.Create(input)
+	if err == nil {
+		_, err = io.Copy(f, obj.Body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	obj.Body.Close()
+	if err != nil {
+		return fmt.Errorf("downloading: %w", err)
+	}
+
+	info, err := h.videos.transcoder.Probe(ctx, input)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"key":      key,
+		"duration": info.Duration.Seconds(),
+	}
+	for _, p := range work.presets {
+		transform := videoTransformPrefix + p.Name
+		out, err := os.MkdirTemp(dir, transform)
+		if err != nil {
+			return err
+		}
+		if err := h.videos.transcoder.Transcode(ctx, p, info, input, out, transform); err != nil {
+			return err
+		}
+		if err := h.storeVideoFiles(ctx, key, etag, out, transform); err != nil {
+			return err
+		}
+		var renditions []string
+		for _, r := range p.Ladder(info) {
+			renditions = append(renditions, r.Name)
+		}
+		data["preset"] = p.Name
+		data["playlist"] = h.publicURL(derivedKey(key, etag, transform))
+		data["dash"] = h.publicURL(derivedKey(key, etag, video.DASHManifest(transform)))
+		data["renditions"] = renditions
+	}
+	if work.tracks && len(info.Tracks) > 0 {
+		tracks, err := h.extractTracks(ctx, key, etag, info, input, dir)
+		if err != nil {
+			return err
+		}
+		data["tracks"] = tracks
+	}
+	if work.sprites && info.Duration > 0 {
+		sprites, err := h.makeSprites(ctx, key, etag, info, input, dir)
+		if err != nil {
+			return err
+		}
+		data["sprites"] = sprites
+	}
+	h.events.Publish(events.New(events.AssetTranscoded, data))
+	return nil
+}
+
+// storeVideoFiles uploads the files in dir as variants of key. Playlists
+// and manifests go up after the segments they list, and the one called
+// last after everything, so players never find one before what it
+// refers to.
+func (h *MediaHandler) storeVideoFiles(ctx context.Context, key, etag, dir, last string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	rank := func(name string) int {
+		switch {
+		case name == last:
+			return 2
+		case strings.HasSuffix(name, ".m3u8"), strings.HasSuffix(name, ".mpd"):
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return rank(files[i].Name()) < rank(files[j].Name())
+	})
+	for _, file := range files {
+		if err := h.storeVideoFile(ctx, filepath.Join(dir, file.Name()), derivedKey(key, etag, file.Name()), etag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeVideoFile uploads one file a video job made
+func (h *MediaHandler) storeVideoFile(ctx context.Context, file, objectKey, etag string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return h.r2Client.PutObject(ctx, objectKey, f, video.ContentType(file), map[string]string{sourceETagMeta: strings.Trim(etag, `"`)})
+}
+
+// tracksTransform names the tracks extracted from a video. Its main
+// object lists them, as JSON, and the tracks are stored beside it.
+const tracksTransform = "tracks"
+
+// VideoTrack is a subtitle track, as WebVTT, or an alternate audio
+// track, as AAC, extracted from a video
+type VideoTrack struct {
+	Kind     string `json:"kind"` // subtitles or audio
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	URL      string `json:"url"`
+}
+
+// extractTracks stores the tracks of the video at input as variants of
+// key, then the list of them
+func (h *MediaHandler) extractTracks(ctx context.Context, key, etag string, info video.Info, input, dir string) ([]VideoTrack, error) {
+	out, err := os.MkdirTemp(dir, tracksTransform)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.videos.transcoder.Extract(ctx, info, input, out, tracksTransform); err != nil {
+		return nil, err
+	}
+	if err := h.storeVideoFiles(ctx, key, etag, out, ""); err != nil {
+		return nil, err
+	}
+
+	tracks := make([]VideoTrack, len(info.Tracks))
+	for i, t := range info.Tracks {
+		tracks[i] = VideoTrack{
+			Kind:     t.Kind,
+			Language: t.Language,
+			Title:    t.Title,
+			Default:  t.Default,
+			URL:      h.publicURL(derivedKey(key, etag, t.File(tracksTransform))),
+		}
+	}
+	list, err := json.Marshal(tracks)
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]string{sourceETagMeta: strings.Trim(etag, `"`)}
+	return tracks, h.r2Client.PutObject(ctx, derivedKey(key, etag, tracksTransform), bytes.NewReader(list), "application/json", meta)
+}
+
+// VideoInfo describes what has been extracted from a video asset
+type VideoInfo struct {
+	Tracks []VideoTrack `json:"tracks,omitempty"`
+	// Sprites is the WebVTT file of its seek-preview thumbnails
+	Sprites string `json:"sprites,omitempty"`
+}
+
+// videoInfo returns what has been extracted from the version of key with
+// etag, or nil if nothing has
+func (h *MediaHandler) videoInfo(ctx context.Context, key, etag string) *VideoInfo {
+	var info VideoInfo
+	if obj, err := h.r2Client.GetObject(ctx, derivedKey(key, etag, tracksTransform)); err == nil {
+		if err := json.NewDecoder(obj.Body).Decode(&info.Tracks); err != nil {
+			info.Tracks = nil
+		}
+		obj.Body.Close()
+	}
+	if _, err := h.r2Client.HeadObject(ctx, derivedKey(key, etag, spritesTransform)); err == nil {
+		info.Sprites = h.publicURL(derivedKey(key, etag, spritesTransform))
+	}
+	if info.Tracks == nil && info.Sprites == "" {
+		return nil
+	}
+	return &info
+}
+
+// spritesTransform names the seek-preview sprite sheets of a video. Its
+// main object is the WebVTT file players read them through.
+const spritesTransform = "sprites"
+
+// makeSprites stores the sprite sheets of the video at input as variants
+// of key, then the WebVTT file pointing at them, whose URL it returns
+func (h *MediaHandler) makeSprites(ctx context.Context, key, etag string, info video.Info, input, dir string) (string, error) {
+	out, err := os.MkdirTemp(dir, spritesTransform)
+	if err != nil {
+		return "", err
+	}
+	interval := h.videos.spriteInterval
+	if err := h.videos.transcoder.Sprites(ctx, info, interval, input, out, spritesTransform); err != nil {
+		return "", err
+	}
+	if err := h.storeVideoFiles(ctx, key, etag, out, ""); err != nil {
+		return "", err
+	}
+	vttKey := derivedKey(key, etag, spritesTransform)
+	vtt := video.SpriteVTT(info, interval, spritesTransform)
+	meta := map[string]string{sourceETagMeta: strings.Trim(etag, `"`)}
+	if err := h.r2Client.PutObject(ctx, vttKey, bytes.NewReader(vtt), "text/vtt; charset=utf-8", meta); err != nil {
+		return "", err
+	}
+	return h.publicURL(vttKey), nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/video"
+)
+
+func TestVideoPresets(t *testing.T) {
+	h := NewMediaHandler(nil, "test-secret", WithVideo(config.VideoConfig{
+		Transcode:     true,
+		DefaultPreset: "standard",
+		Workers:       1,
+		Presets:       video.DefaultPresets(),
+	}))
+
+	for name, ok := range map[string]bool{"": true, "mobile": true, "4k": false, "Mobile": false} {
+		if err := h.checkVideoPreset(name); (err == nil) != ok {
+			t.Errorf("checkVideoPreset(%q) = %v", name, err)
+		}
+	}
+	if err := NewMediaHandler(nil, "test-secret").checkVideoPreset("mobile"); err == nil {
+		t.Error("preset accepted with transcoding off")
+	}
+
+	ctx := withVideoPreset(context.Background(), "mobile")
+	if got := videoPresetFrom(ctx); got != "mobile" {
+		t.Errorf("videoPresetFrom = %q, want mobile", got)
+	}
+	if p, ok := h.videos.preset(videoPresetFrom(context.Background())); !ok || p.Name != "standard" {
+		t.Errorf("preset without a choice = %s, %v; want standard", p.Name, ok)
+	}
+	// Only videos are transcoded
+	if job := h.queueTranscode("assets/a.png", "image/png", "9b2c", ""); job != nil {
+		t.Errorf("image queued for transcoding: %+v", job)
+	}
+}
+
+func TestHLSFilesAreVariants(t *testing.T) {
+	master := derivedKey("clips/a.mp4", `"9b2c"`, videoTransformPrefix+"standard")
+	for _, file := range []string{"hls-standard", "hls-standard_720p.m3u8", "hls-standard_720p_init.mp4", "hls-standard_720p_0003.m4s", "hls-standard_manifest.mpd"} {
+		object := "derived/clips/a.mp4/9b2c/" + file
+		if !derivedOf("clips/a.mp4", object) {
+			t.Errorf("%s isn't a variant of clips/a.mp4", object)
+		}
+		if got := derivedTransform(object); got != "hls-standard" {
+			t.Errorf("derivedTransform(%s) = %s, want hls-standard", object, got)
+		}
+	}
+	if want := "derived/clips/a.mp4/9b2c/hls-standard"; master != want {
+		t.Errorf("master playlist at %s, want %s", master, want)
+	}
+	if got := derivedTransform("derived/clips/a.mp4/9b2c/tracks_3.vtt"); got != tracksTransform {
+		t.Errorf("subtitle track transform = %s", got)
+	}
+	if got := derivedTransform("derived/clips/a.mp4/9b2c/sprites_001.jpg"); got != spritesTransform {
+		t.Errorf("sprite sheet transform = %s", got)
+	}
+	if got := derivedTransform("derived/photo.png/9b2c/w300-h200-cover"); got != "w300-h200-cover" {
+		t.Errorf("image variant transform = %s", got)
+	}
+}
+
+func TestVideoWorkFor(t *testing.T) {
+	on := NewMediaHandler(nil, "test-secret", WithVideo(config.VideoConfig{
+		Transcode:     true,
+		DefaultPreset: "standard",
+		Workers:       1,
+		ExtractTracks: true,
+		SpriteSeconds: 10,
+		Presets:       video.DefaultPresets(),
+	}))
+	off := NewMediaHandler(nil, "test-secret")
+
+	tests := []struct {
+		h         *MediaHandler
+		transform string
+		video     bool
+		preset    string // "" for none
+		tracks    bool
+		sprites   bool
+	}{
+		{on, "hls-mobile", true, "mobile", false, false},
+		{on, "hls-removed", true, "", false, false},
+		{on, "tracks", true, "", true, false},
+		{on, "sprites", true, "", false, true},
+		{on, "min", false, "", false, false},
+		{on, "w300-h200-cover", false, "", false, false},
+		{off, "hls-standard", true, "", false, false},
+		{off, "tracks", true, "", false, false},
+		{off, "sprites", true, "", false, false},
+	}
+	for _, tt := range tests {
+		work, ok := tt.h.videoWorkFor(tt.transform)
+		preset := ""
+		if len(work.presets) == 1 {
+			preset = work.presets[0].Name
+		}
+		if ok != tt.video || preset != tt.preset || work.tracks != tt.tracks || work.sprites != tt.sprites || len(work.presets) > 1 {
+			t.Errorf("videoWorkFor(%s) = %+v, %v", tt.transform, work, ok)
+		}
+	}
+}
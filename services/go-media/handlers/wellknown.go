@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+// wellKnownCacheControl keeps inline well-known files for an hour, so
+// config changes reach crawlers soon after a restart
+const wellKnownCacheControl = "public, max-age=3600"
+
+// WithWellKnown serves files at well-known paths, by path
+func WithWellKnown(files []config.WellKnownFile) Option {
+	return func(h *MediaHandler) {
+		h.wellKnown = make(map[string]config.WellKnownFile, len(files))
+		for _, f := range files {
+			h.wellKnown[f.Path] = f
+		}
+	}
+}
+
+// OnWellKnown matches requests for a configured well-known file
+func (h *MediaHandler) OnWellKnown(r *http.Request, _ *mux.RouteMatch) bool {
+	_, ok := h.wellKnown[r.URL.Path]
+	return ok
+}
+
+// ServeWellKnown serves the well-known file at the request's path: its
+// body, or the object it names, as a public asset
+func (h *MediaHandler) ServeWellKnown(w http.ResponseWriter, r *http.Request) {
+	f, ok := h.wellKnown[r.URL.Path]
+	if !ok {
+		respondError(w, http.StatusNotFound, apierror.AssetNotFound, "Object not found")
+		return
+	}
+	if f.Key != "" {
+		h.ServeAsset(w, mux.SetURLVars(r, map[string]string{"path": f.Key}))
+		return
+	}
+
+	contentType := f.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(f.Path))
+	}
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	sum := sha256.Sum256([]byte(f.Body))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", wellKnownCacheControl)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(f.Body))
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+)
+
+func TestServeWellKnown(t *testing.T) {
+	h := NewMediaHandler(nil, "secret", WithWellKnown([]config.WellKnownFile{
+		{Path: "/robots.txt", Body: "User-agent: *\nDisallow: /v1/\n"},
+		{Path: "/.well-known/apple-app-site-association", Body: `{"applinks": {}}`, ContentType: "application/json"},
+		{Path: "/.well-known/security.txt", Key: "site/security.txt"},
+	}))
+
+	if h.OnWellKnown(httptest.NewRequest("GET", "/humans.txt", nil), nil) {
+		t.Error("unconfigured path matched")
+	}
+	if !h.OnWellKnown(httptest.NewRequest("GET", "/.well-known/security.txt", nil), nil) {
+		t.Error("configured path didn't match")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeWellKnown(w, httptest.NewRequest("GET", "/robots.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "User-agent: *\nDisallow: /v1/\n" || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("robots.txt = %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest("GET", "/robots.txt", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeWellKnown(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidated robots.txt = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeWellKnown(w, httptest.NewRequest("GET", "/.well-known/apple-app-site-association", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
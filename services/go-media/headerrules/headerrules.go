@@ -0,0 +1,246 @@
+// Package headerrules adds configured headers to asset responses, by
+// path or extension, such as CORS for fonts served to other origins or a
+// sandboxing Content-Security-Policy for HTML. Rules use the Netlify
+// _headers syntax: a path pattern, then its headers indented below it.
+//
+//	/fonts/*
+//	  Access-Control-Allow-Origin: *
+//	*.html
+//	  Content-Security-Policy: sandbox
+//	  X-Frame-Options: DENY
+//
+// A * in a pattern matches anything, slashes included, so /fonts/*
+// covers a prefix and *.html an extension anywhere. Every matching rule
+// applies, in order, a later one replacing a header an earlier one set.
+package headerrules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/gorilla/mux"
+)
+
+// Rule sets Headers on responses for paths matching For
+type Rule struct {
+	For     string
+	Headers http.Header
+}
+
+// reserved headers frame or route the response, so rules can't set them
+var reserved = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Upgrade":           true,
+}
+
+// Parse reads rules in _headers format, with blank lines and # comments
+// ignored. Repeating a header within a rule sends it once per value.
+func Parse(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if text[0] != ' ' && text[0] != '\t' {
+			rules = append(rules, Rule{For: trimmed, Headers: make(http.Header)})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: header %q before any path", line, trimmed)
+		}
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want \"Name: value\", got %q", line, trimmed)
+		}
+		rules[len(rules)-1].Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Table is a checked, immutable set of rules
+type Table struct {
+	rules []Rule
+}
+
+// NewTable checks rules, which apply in order
+func NewTable(rules []Rule) (*Table, error) {
+	t := &Table{}
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.For, "/") && !strings.HasPrefix(rule.For, "*") {
+			return nil, fmt.Errorf("path %q must start with / or *", rule.For)
+		}
+		headers := make(http.Header, len(rule.Headers))
+		for name, values := range rule.Headers {
+			canonical := textproto.CanonicalMIMEHeaderKey(name)
+			switch {
+			case !validName(name):
+				return nil, fmt.Errorf("%s: invalid header name %q", rule.For, name)
+			case reserved[canonical]:
+				return nil, fmt.Errorf("%s: %s can't be set by a rule", rule.For, canonical)
+			}
+			for _, v := range values {
+				if strings.ContainsAny(v, "\r\n\x00") {
+					return nil, fmt.Errorf("%s: invalid value for %s", rule.For, canonical)
+				}
+				headers.Add(canonical, v)
+			}
+		}
+		t.rules = append(t.rules, Rule{For: rule.For, Headers: headers})
+	}
+	return t, nil
+}
+
+// Load builds a table from cfg: the rules in cfg.File (if set) come
+// first, followed by the rules in the config file itself
+func Load(cfg config.HeadersConfig) (*Table, error) {
+	var rules []Rule
+	if cfg.File != "" {
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open headers file: %w", err)
+		}
+		defer f.Close()
+
+		rules, err = Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.File, err)
+		}
+	}
+	for _, r := range cfg.Rules {
+		headers := make(http.Header, len(r.Headers))
+		for name, value := range r.Headers {
+			headers.Add(name, value)
+		}
+		rules = append(rules, Rule{For: r.For, Headers: headers})
+	}
+	return NewTable(rules)
+}
+
+// Apply sets the headers of every rule matching p, a path beginning with
+// "/", on h
+func (t *Table) Apply(h http.Header, p string) {
+	for _, rule := range t.rules {
+		if !match(rule.For, p) {
+			continue
+		}
+		for name, values := range rule.Headers {
+			h[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// match reports whether p matches pattern, in which * matches any run of
+// characters
+func match(pattern, p string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == p
+	}
+	if !strings.HasPrefix(p, parts[0]) {
+		return false
+	}
+	p = p[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(p, part)
+		if i < 0 {
+			return false
+		}
+		p = p[i+len(part):]
+	}
+	return len(p) >= len(last) && strings.HasSuffix(p, last)
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Injector applies the current table to responses. The table can be
+// swapped on config reload.
+type Injector struct {
+	table atomic.Pointer[Table]
+}
+
+// NewInjector returns an injector applying table
+func NewInjector(table *Table) *Injector {
+	in := &Injector{}
+	in.SetTable(table)
+	return in
+}
+
+// SetTable replaces the rules for subsequent requests
+func (in *Injector) SetTable(table *Table) {
+	in.table.Store(table)
+}
+
+// Middleware applies the rules to routes whose key is the "path" route
+// variable, matched as "/" + key. The headers are set as the response
+// is written, so they replace any the handler set. A nil Injector
+// passes requests through.
+func (in *Injector) Middleware(next http.Handler) http.Handler {
+	if in == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		table := in.table.Load()
+		if table == nil || len(table.rules) == 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&writer{ResponseWriter: w, apply: func(h http.Header) {
+			table.Apply(h, "/"+mux.Vars(r)["path"])
+		}}, r)
+	})
+}
+
+// writer applies the rules just before the header is written
+type writer struct {
+	http.ResponseWriter
+	apply       func(http.Header)
+	wroteHeader bool
+}
+
+func (w *writer) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.apply(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
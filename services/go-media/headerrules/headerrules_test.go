@@ -0,0 +1,142 @@
+package headerrules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/gorilla/mux"
+)
+
+const headersFile = `
+# Fonts are loaded cross-origin
+/fonts/*
+  Access-Control-Allow-Origin: *
+
+*.html
+  Content-Security-Policy: sandbox
+  X-Frame-Options: DENY
+/docs/*.html
+  Content-Security-Policy: sandbox allow-scripts
+`
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(strings.NewReader(headersFile))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("len(rules) = %d, want 3", len(rules))
+	}
+	if got := rules[1]; got.For != "*.html" || got.Headers.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("rules[1] = %+v", got)
+	}
+
+	for _, bad := range []string{"  X-A: b\n", "/a\n  no colon\n"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Parse(%q) accepted", bad)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	rules, _ := Parse(strings.NewReader(headersFile))
+	table, err := NewTable(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, header, want string
+	}{
+		{"/fonts/inter/regular.woff2", "Access-Control-Allow-Origin", "*"},
+		{"/fontsx/a.woff2", "Access-Control-Allow-Origin", ""},
+		{"/site/index.html", "Content-Security-Policy", "sandbox"},
+		{"/site/index.html", "X-Frame-Options", "DENY"},
+		// A later rule replaces a header an earlier one set
+		{"/docs/guide/intro.html", "Content-Security-Policy", "sandbox allow-scripts"},
+		{"/docs/guide/intro.html", "X-Frame-Options", "DENY"},
+		{"/site/index.htm", "Content-Security-Policy", ""},
+	}
+	for _, tt := range tests {
+		h := make(http.Header)
+		table.Apply(h, tt.path)
+		if got := h.Get(tt.header); got != tt.want {
+			t.Errorf("%s: %s = %q, want %q", tt.path, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestNewTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"relative path", Rule{For: "fonts/*", Headers: http.Header{"X-A": {"b"}}}},
+		{"reserved header", Rule{For: "/*", Headers: http.Header{"Content-Length": {"0"}}}},
+		{"invalid name", Rule{For: "/*", Headers: http.Header{"X A": {"b"}}}},
+		{"newline in value", Rule{For: "/*", Headers: http.Header{"X-A": {"b\r\nSet-Cookie: c"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewTable([]Rule{tt.rule}); err == nil {
+			t.Errorf("%s: NewTable() error = nil", tt.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "_headers")
+	if err := os.WriteFile(path, []byte(headersFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := Load(config.HeadersConfig{
+		File:  path,
+		Rules: []config.HeaderRule{{For: "/fonts/*", Headers: map[string]string{"access-control-allow-origin": "https://mikeodnis.dev"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Inline rules follow the file's, so they win
+	h := make(http.Header)
+	table.Apply(h, "/fonts/a.woff2")
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://mikeodnis.dev" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+
+	if _, err := Load(config.HeadersConfig{File: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("Load() accepted a missing file")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rules, _ := Parse(strings.NewReader(headersFile))
+	table, _ := NewTable(rules)
+	in := NewInjector(table)
+
+	handler := in.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Write([]byte("<p>hi</p>"))
+	}))
+
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("GET", "/v1/media/assets/site/index.html", nil), map[string]string{"path": "site/index.html"})
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Content-Security-Policy") != "sandbox" || w.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("headers = %v", w.Header())
+	}
+	if w.Body.String() != "<p>hi</p>" {
+		t.Errorf("body = %q", w.Body.String())
+	}
+
+	// A nil injector passes requests through
+	var none *Injector
+	w = httptest.NewRecorder()
+	none.Middleware(handler).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("nil injector status = %d", w.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// focusSize is the longest side of the thumbnail DetectFocus looks at
+const focusSize = 64
+
+// DetectFocus guesses the focal point of img: the centre of its most
+// salient area, where saliency is local contrast plus color saturation.
+// Faces, products and text have both; skies, walls and studio backdrops
+// have neither. It is cheap rather than clever, and falls back to the
+// centre for images with no detail.
+func DetectFocus(img image.Image) Focus {
+	b := img.Bounds()
+	if b.Dx() < 3 || b.Dy() < 3 {
+		return Focus{X: 500, Y: 500, Set: true}
+	}
+	k := math.Min(1, focusSize/float64(max(b.Dx(), b.Dy())))
+	w, h := max(3, int(math.Round(float64(b.Dx())*k))), max(3, int(math.Round(float64(b.Dy())*k)))
+	small := resample(img, b, w, h)
+
+	luma := make([]float64, w*h)
+	sat := make([]float64, w*h)
+	for i := range luma {
+		p := small.Pix[i*4:]
+		r, g, bl := float64(p[0]), float64(p[1]), float64(p[2])
+		luma[i] = 0.299*r + 0.587*g + 0.114*bl
+		sat[i] = math.Max(r, math.Max(g, bl)) - math.Min(r, math.Min(g, bl))
+	}
+
+	// Weight each pixel by its saliency squared, so the most salient area
+	// outweighs scattered texture, and take the weighted centre
+	var sum, sx, sy float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			gx := luma[i+1] - luma[i-1]
+			gy := luma[i+w] - luma[i-w]
+			s := math.Hypot(gx, gy) + 0.5*sat[i]
+			s *= s
+			sum += s
+			sx += s * (float64(x) + 0.5)
+			sy += s * (float64(y) + 0.5)
+		}
+	}
+	if sum == 0 {
+		return Focus{X: 500, Y: 500, Set: true}
+	}
+	return Focus{
+		X:   int(math.Round(1000 * sx / sum / float64(w))),
+		Y:   int(math.Round(1000 * sy / sum / float64(h))),
+		Set: true,
+	}
+}
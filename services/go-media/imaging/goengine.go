@@ -0,0 +1,176 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"path"
+	"strings"
+)
+
+func init() {
+	register("go", func(s Settings) (Engine, error) { return goEngine{autoFocus: s.AutoFocus}, nil })
+}
+
+// goEngine transforms images with the standard library alone. It reads
+// JPEG, PNG and GIF (the first frame) and writes the same; it ignores
+// EXIF orientation. Having no color management, it can't convert to
+// sRGB, so it carries the source's color profile over to JPEG and PNG
+// output whatever the Color setting, rather than washing out wide-gamut
+// images.
+type goEngine struct {
+	autoFocus bool
+}
+
+func (goEngine) Name() string { return "go" }
+
+func (goEngine) Reads(key string) bool {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+func (goEngine) Writes(format string) bool {
+	return format == "" || format == "jpeg" || format == "png" || format == "gif"
+}
+
+func (e goEngine) Transform(src []byte, o Options) ([]byte, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if o.Format != "" {
+		format = o.Format
+	}
+	if !e.Writes(format) {
+		return nil, "", fmt.Errorf("%w: the go engine can't write %s", ErrUnsupportedFormat, format)
+	}
+
+	if o.Fit == FitCover && !o.Focus.Set && e.autoFocus {
+		o.Focus = DetectFocus(img)
+	}
+	b := img.Bounds()
+	crop, w, h := Plan(b.Dx(), b.Dy(), o)
+	out := resample(img, crop.Add(b.Min), w, h)
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		quality := o.Quality
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, out)
+	case "gif":
+		err = gif.Encode(&buf, out, nil)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return embedICC(buf.Bytes(), extractICC(src)), contentTypes[format], nil
+}
+
+// resample scales the area r of src to w×h with a triangle filter,
+// widened when shrinking so that every source pixel contributes
+func resample(src image.Image, r image.Rectangle, w, h int) *image.RGBA {
+	// Work on premultiplied RGBA so transparent pixels don't bleed color
+	in := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(in, in.Bounds(), src, r.Min, draw.Src)
+	if r.Dx() == w && r.Dy() == h {
+		return in
+	}
+
+	// Horizontal pass into a float buffer, then vertical into the output
+	cols := filterWeights(w, r.Dx())
+	tmp := make([]float32, r.Dy()*w*4)
+	for y := 0; y < r.Dy(); y++ {
+		row := in.Pix[y*in.Stride:]
+		for x, c := range cols {
+			var px [4]float32
+			for i, wt := range c.weights {
+				p := row[(c.start+i)*4:]
+				px[0] += wt * float32(p[0])
+				px[1] += wt * float32(p[1])
+				px[2] += wt * float32(p[2])
+				px[3] += wt * float32(p[3])
+			}
+			copy(tmp[(y*w+x)*4:], px[:])
+		}
+	}
+
+	rows := filterWeights(h, r.Dy())
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, c := range rows {
+		for x := 0; x < w; x++ {
+			var px [4]float32
+			for i, wt := range c.weights {
+				p := tmp[((c.start+i)*w+x)*4:]
+				px[0] += wt * p[0]
+				px[1] += wt * p[1]
+				px[2] += wt * p[2]
+				px[3] += wt * p[3]
+			}
+			o := out.Pix[y*out.Stride+x*4:]
+			for i := range px {
+				o[i] = clamp8(px[i])
+			}
+		}
+	}
+	return out
+}
+
+// contribution is the source pixels, from start, that make up one
+// output pixel, and their weights
+type contribution struct {
+	start   int
+	weights []float32
+}
+
+// filterWeights returns the contributions for scaling n source pixels to
+// size output pixels
+func filterWeights(size, n int) []contribution {
+	scale := float64(n) / float64(size)
+	support := math.Max(1, scale)
+	out := make([]contribution, size)
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := max(0, int(math.Ceil(center-support)))
+		hi := min(n-1, int(math.Floor(center+support)))
+		c := contribution{start: lo, weights: make([]float32, 0, hi-lo+1)}
+		var sum float64
+		for j := lo; j <= hi; j++ {
+			wt := math.Max(0, 1-math.Abs(float64(j)-center)/support)
+			c.weights = append(c.weights, float32(wt))
+			sum += wt
+		}
+		if sum == 0 {
+			// The filter fell between pixels; take the nearest
+			c.start, c.weights = min(n-1, max(0, int(math.Round(center)))), []float32{1}
+		} else {
+			for j := range c.weights {
+				c.weights[j] /= float32(sum)
+			}
+		}
+		out[i] = c
+	}
+	return out
+}
+
+func clamp8(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
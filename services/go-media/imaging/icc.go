@@ -0,0 +1,173 @@
+package imaging
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// Color is what happens to an image's color profile when it is
+// transformed
+type Color string
+
+const (
+	// ColorSRGB converts images with an embedded profile to sRGB, the
+	// profile browsers assume, so wide-gamut photos look the same
+	// everywhere
+	ColorSRGB Color = "srgb"
+	// ColorPreserve keeps the embedded profile, for wide-gamut displays
+	ColorPreserve Color = "preserve"
+)
+
+// Settings configure an engine
+type Settings struct {
+	// Color is ColorSRGB when empty
+	Color Color
+	// AutoFocus centres FitCover crops without a focal point on the one
+	// DetectFocus finds, instead of the image's centre
+	AutoFocus bool
+}
+
+// iccMarker starts the JPEG APP2 segments holding a color profile
+const iccMarker = "ICC_PROFILE\x00"
+
+// maxICCChunk is the profile data one APP2 segment holds: the segment
+// length minus itself, the marker and the chunk's sequence and count
+const maxICCChunk = 0xffff - 2 - len(iccMarker) - 2
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// extractICC returns the color profile embedded in a JPEG or PNG, or nil
+func extractICC(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return jpegICC(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return pngICC(data)
+	}
+	return nil
+}
+
+// jpegICC joins the profile chunks in a JPEG's APP2 segments
+func jpegICC(data []byte) []byte {
+	var chunks [][]byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			// Image data follows; metadata segments come before it
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return nil
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xe2 && bytes.HasPrefix(seg, []byte(iccMarker)) && len(seg) >= len(iccMarker)+2 {
+			seq, count := int(seg[len(iccMarker)]), int(seg[len(iccMarker)+1])
+			if seq < 1 || seq > count {
+				return nil
+			}
+			if chunks == nil {
+				chunks = make([][]byte, count)
+			}
+			if count != len(chunks) {
+				return nil
+			}
+			chunks[seq-1] = seg[len(iccMarker)+2:]
+		}
+		i += 2 + n
+	}
+	var profile []byte
+	for _, c := range chunks {
+		if c == nil {
+			return nil
+		}
+		profile = append(profile, c...)
+	}
+	return profile
+}
+
+// pngICC decompresses a PNG's iCCP chunk
+func pngICC(data []byte) []byte {
+	for i := len(pngSignature); i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		if n < 0 || i+12+n > len(data) || typ == "IDAT" {
+			return nil
+		}
+		if typ == "iCCP" {
+			chunk := data[i+8 : i+8+n]
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) || chunk[name+1] != 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				return nil
+			}
+			profile, err := io.ReadAll(zr)
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		i += 12 + n
+	}
+	return nil
+}
+
+// embedICC adds profile to an image encoded by the standard library,
+// which writes none. GIFs can't carry one and are returned unchanged.
+func embedICC(data, profile []byte) []byte {
+	switch {
+	case len(profile) == 0:
+		return data
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return embedJPEG(data, profile)
+	case bytes.HasPrefix(data, pngSignature):
+		return embedPNG(data, profile)
+	}
+	return data
+}
+
+// embedJPEG inserts profile as APP2 segments right after the SOI marker
+func embedJPEG(data, profile []byte) []byte {
+	count := (len(profile) + maxICCChunk - 1) / maxICCChunk
+	if count > 255 {
+		return data
+	}
+	out := append([]byte{}, data[:2]...)
+	for seq := 1; len(profile) > 0; seq++ {
+		chunk := profile[:min(len(profile), maxICCChunk)]
+		profile = profile[len(chunk):]
+		out = append(out, 0xff, 0xe2)
+		out = binary.BigEndian.AppendUint16(out, uint16(2+len(iccMarker)+2+len(chunk)))
+		out = append(out, iccMarker...)
+		out = append(out, byte(seq), byte(count))
+		out = append(out, chunk...)
+	}
+	return append(out, data[2:]...)
+}
+
+// embedPNG inserts profile as an iCCP chunk after IHDR, which PNG
+// requires to be first
+func embedPNG(data, profile []byte) []byte {
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	if len(data) < ihdrEnd {
+		return data
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(profile)
+	zw.Close()
+
+	chunk := append([]byte("iCCP"), "icc\x00\x00"...)
+	chunk = append(chunk, z.Bytes()...)
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(chunk)-4))
+	out = append(out, chunk...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk))
+	return append(out, data[ihdrEnd:]...)
+}
@@ -0,0 +1,69 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestICCRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	var jpg, pngBuf bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	// A profile larger than one APP2 segment holds
+	profile := bytes.Repeat([]byte("display-p3 "), 7000)
+	for name, data := range map[string][]byte{"jpeg": jpg.Bytes(), "png": pngBuf.Bytes()} {
+		if extractICC(data) != nil {
+			t.Errorf("%s: profile found before embedding", name)
+		}
+		tagged := embedICC(data, profile)
+		if got := extractICC(tagged); !bytes.Equal(got, profile) {
+			t.Errorf("%s: extracted %d bytes, want the %d embedded", name, len(got), len(profile))
+		}
+		if _, _, err := image.Decode(bytes.NewReader(tagged)); err != nil {
+			t.Errorf("%s with a profile doesn't decode: %v", name, err)
+		}
+	}
+}
+
+func TestGoEngineKeepsProfile(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			img.Set(x, y, color.RGBA{200, 30, 30, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	profile := []byte("wide-gamut profile")
+	src := embedICC(buf.Bytes(), profile)
+
+	e, err := New("go", Settings{Color: ColorSRGB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"", "png", "gif"} {
+		out, _, err := e.Transform(src, Options{Width: 10, Format: format})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		want := profile
+		if format == "gif" {
+			want = nil
+		}
+		if got := extractICC(out); !bytes.Equal(got, want) {
+			t.Errorf("format %q: profile %q, want %q", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// Package imaging resizes, crops and re-encodes images for the asset
+// variants requested with ?w=, ?h=, ?fit=, ?fp-x=, ?fp-y=, ?format= and
+// ?q=. The work is
+// done by an Engine: libvips when the binary is built with the vips tag,
+// otherwise a pure-Go one that needs no C libraries.
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fit is how an image is made to fit the requested box
+type Fit string
+
+const (
+	// FitContain scales the image to fit inside the box, keeping its
+	// aspect ratio
+	FitContain Fit = "contain"
+	// FitCover scales the image to cover the box and crops the overflow
+	FitCover Fit = "cover"
+	// FitFill stretches the image to the box
+	FitFill Fit = "fill"
+)
+
+// DefaultQuality is the quality of lossy output when none is asked for
+const DefaultQuality = 82
+
+// contentTypes are the output formats and their media types
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// sourceExts are the file types an engine may be able to read
+var sourceExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+	".webp": true, ".avif": true, ".tif": true, ".tiff": true,
+	".heic": true, ".heif": true,
+}
+
+// transcodedExts are file types most browsers can't display, such as
+// the HEIC photos iPhones take
+var transcodedExts = map[string]bool{".heic": true, ".heif": true}
+
+var (
+	ErrInvalidOptions    = errors.New("invalid image options")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrOutsideLimits     = errors.New("image options outside the allowed limits")
+)
+
+// Options is one transformation. The zero value changes nothing.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     Fit    // FitContain when empty
+	Focus   Focus  // what FitCover crops keep; the centre when unset
+	Format  string // output format; empty keeps the source's
+	Quality int    // 1-100 for lossy formats; 0 is DefaultQuality
+}
+
+// Focus is a focal point: the point of an image that FitCover crops keep
+// in view, in thousandths of its width and height from the top left
+type Focus struct {
+	X, Y int
+	Set  bool
+}
+
+// ParseFocus reads a focal point written as "x,y", fractions of the
+// width and height from 0 to 1, such as "0.3,0.6"
+func ParseFocus(s string) (Focus, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	x, errX := strconv.ParseFloat(strings.TrimSpace(xs), 64)
+	y, errY := strconv.ParseFloat(strings.TrimSpace(ys), 64)
+	if !ok || errX != nil || errY != nil || x < 0 || x > 1 || y < 0 || y > 1 {
+		return Focus{}, fmt.Errorf("%w: a focal point is two numbers from 0 to 1", ErrInvalidOptions)
+	}
+	return Focus{X: int(math.Round(x * 1000)), Y: int(math.Round(y * 1000)), Set: true}, nil
+}
+
+// String writes f as ParseFocus reads it
+func (f Focus) String() string {
+	return thousandths(f.X) + "," + thousandths(f.Y)
+}
+
+func thousandths(n int) string {
+	return strconv.FormatFloat(float64(n)/1000, 'f', -1, 64)
+}
+
+// IsZero reports whether o asks for no transformation
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// ContentType returns the media type of the output, or "" when it keeps
+// the source's format
+func (o Options) ContentType() string {
+	return contentTypes[o.Format]
+}
+
+// Source reports whether key's file type is one images are read from
+func Source(key string) bool {
+	return sourceExts[strings.ToLower(path.Ext(key))]
+}
+
+// Transcoded reports whether key's file type is one browsers can't
+// display, which is served converted to a format they can
+func Transcoded(key string) bool {
+	return transcodedExts[strings.ToLower(path.Ext(key))]
+}
+
+// ParseQuery reads Options from w, h, fit, fp-x and fp-y, format and q.
+// It returns the zero Options when none of them is set.
+func ParseQuery(q url.Values) (Options, error) {
+	var o Options
+	ints := []struct {
+		name string
+		dst  *int
+		max  int
+	}{{"w", &o.Width, math.MaxInt32}, {"h", &o.Height, math.MaxInt32}, {"q", &o.Quality, 100}}
+	for _, p := range ints {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > p.max {
+			return Options{}, fmt.Errorf("%w: %s must be between 1 and %d", ErrInvalidOptions, p.name, p.max)
+		}
+		*p.dst = n
+	}
+
+	switch fit := Fit(q.Get("fit")); fit {
+	case "", FitContain, FitCover, FitFill:
+		o.Fit = fit
+	default:
+		return Options{}, fmt.Errorf("%w: fit must be contain, cover or fill", ErrInvalidOptions)
+	}
+	if x, y := q.Get("fp-x"), q.Get("fp-y"); x != "" || y != "" {
+		focus, err := ParseFocus(x + "," + y)
+		if err != nil {
+			return Options{}, fmt.Errorf("%w: fp-x and fp-y must both be from 0 to 1", ErrInvalidOptions)
+		}
+		o.Focus = focus
+	}
+	if format := strings.ToLower(q.Get("format")); format != "" {
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if contentTypes[format] == "" {
+			return Options{}, fmt.Errorf("%w: format must be jpeg, png, gif, webp or avif", ErrInvalidOptions)
+		}
+		o.Format = format
+	}
+	return o.normalize(), nil
+}
+
+// normalize drops settings that are the default, so equal
+// transformations have equal fingerprints
+func (o Options) normalize() Options {
+	if o.Fit == FitContain || o.Width == 0 || o.Height == 0 {
+		// With one side given, every fit scales to it
+		o.Fit = ""
+	}
+	if o.Fit != FitCover {
+		// Only cover crops
+		o.Focus = Focus{}
+	}
+	if o.Quality == DefaultQuality {
+		o.Quality = 0
+	}
+	return o
+}
+
+// Fingerprint names o in keys and ETags, such as "w300-h200-cover-webp"
+// or "w300-h200-cover-fp300x600". ParseFingerprint reverses it.
+func (o Options) Fingerprint() string {
+	var parts []string
+	if o.Width > 0 {
+		parts = append(parts, "w"+strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		parts = append(parts, "h"+strconv.Itoa(o.Height))
+	}
+	if o.Fit != "" {
+		parts = append(parts, string(o.Fit))
+	}
+	if o.Focus.Set {
+		parts = append(parts, fmt.Sprintf("fp%dx%d", o.Focus.X, o.Focus.Y))
+	}
+	if o.Quality > 0 {
+		parts = append(parts, "q"+strconv.Itoa(o.Quality))
+	}
+	if o.Format != "" {
+		parts = append(parts, o.Format)
+	}
+	return strings.Join(parts, "-")
+}
+
+// Query returns the query parameters ParseQuery reads o from
+func (o Options) Query() url.Values {
+	q := url.Values{}
+	if o.Width > 0 {
+		q.Set("w", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		q.Set("h", strconv.Itoa(o.Height))
+	}
+	if o.Fit != "" {
+		q.Set("fit", string(o.Fit))
+	}
+	if o.Focus.Set {
+		q.Set("fp-x", thousandths(o.Focus.X))
+		q.Set("fp-y", thousandths(o.Focus.Y))
+	}
+	if o.Quality > 0 {
+		q.Set("q", strconv.Itoa(o.Quality))
+	}
+	if o.Format != "" {
+		q.Set("format", o.Format)
+	}
+	return q
+}
+
+// ParseFingerprint returns the Options named by fingerprint
+func ParseFingerprint(fingerprint string) (Options, bool) {
+	q := url.Values{}
+	for _, part := range strings.Split(fingerprint, "-") {
+		switch {
+		case part == "":
+			return Options{}, false
+		case contentTypes[part] != "":
+			q.Set("format", part)
+		case part == string(FitCover) || part == string(FitFill):
+			q.Set("fit", part)
+		case strings.HasPrefix(part, "fp"):
+			x, y, _ := strings.Cut(part[2:], "x")
+			xn, errX := strconv.Atoi(x)
+			yn, errY := strconv.Atoi(y)
+			if errX != nil || errY != nil {
+				return Options{}, false
+			}
+			q.Set("fp-x", thousandths(xn))
+			q.Set("fp-y", thousandths(yn))
+		case strings.ContainsAny(part[:1], "whq"):
+			q.Set(part[:1], part[1:])
+		default:
+			return Options{}, false
+		}
+	}
+	o, err := ParseQuery(q)
+	if err != nil || o.IsZero() || o.Fingerprint() != fingerprint {
+		return Options{}, false
+	}
+	return o, true
+}
+
+// Limits bounds the transformations requests may ask for, so a request
+// like ?w=20000 can't make the service build huge images. Zero and
+// empty fields don't limit.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels bounds the area of a box given by both width and height
+	MaxPixels int
+	// Formats are the output formats allowed
+	Formats []string
+	// Qualities are the q values allowed besides the default
+	Qualities []int
+}
+
+// Allow checks o against l
+func (l Limits) Allow(o Options) error {
+	switch {
+	case l.MaxWidth > 0 && o.Width > l.MaxWidth:
+		return fmt.Errorf("%w: w may be at most %d", ErrOutsideLimits, l.MaxWidth)
+	case l.MaxHeight > 0 && o.Height > l.MaxHeight:
+		return fmt.Errorf("%w: h may be at most %d", ErrOutsideLimits, l.MaxHeight)
+	case l.MaxPixels > 0 && o.Width*o.Height > l.MaxPixels:
+		return fmt.Errorf("%w: w × h may be at most %d pixels", ErrOutsideLimits, l.MaxPixels)
+	}
+	if o.Format != "" && len(l.Formats) > 0 && !contains(l.Formats, o.Format) {
+		return fmt.Errorf("%w: format must be one of %s", ErrOutsideLimits, strings.Join(l.Formats, ", "))
+	}
+	if o.Quality != 0 && len(l.Qualities) > 0 && !contains(l.Qualities, o.Quality) {
+		return fmt.Errorf("%w: q must be one of %s", ErrOutsideLimits, strings.Trim(fmt.Sprint(l.Qualities), "[]"))
+	}
+	return nil
+}
+
+func contains[T comparable](list []T, v T) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan works out a transformation of a srcW×srcH image: the part of the
+// source to keep, and the size to scale it to. Images are never
+// enlarged; a box larger than the source shrinks to fit it, keeping the
+// box's shape for FitCover. FitCover crops are centred on the focal
+// point, as far as the image's edges allow.
+func Plan(srcW, srcH int, o Options) (crop image.Rectangle, width, height int) {
+	crop = image.Rect(0, 0, srcW, srcH)
+	w, h := o.Width, o.Height
+	switch {
+	case w == 0 && h == 0:
+		return crop, srcW, srcH
+	case w == 0:
+		w = scaled(srcW, h, srcH)
+	case h == 0:
+		h = scaled(srcH, w, srcW)
+	}
+
+	switch o.Fit {
+	case FitCover:
+		// Shrink the box until it fits in the source, then crop the
+		// source to the box's shape around the focal point
+		k := math.Min(1, math.Min(float64(srcW)/float64(w), float64(srcH)/float64(h)))
+		w, h = max(1, int(math.Round(float64(w)*k))), max(1, int(math.Round(float64(h)*k)))
+		cropW, cropH := srcW, scaled(srcW, h, w)
+		if cropH > srcH {
+			cropW, cropH = scaled(srcH, w, h), srcH
+		}
+		x, y := (srcW-cropW)/2, (srcH-cropH)/2
+		if o.Focus.Set {
+			x = clampInt(int(math.Round(float64(o.Focus.X)*float64(srcW)/1000-float64(cropW)/2)), 0, srcW-cropW)
+			y = clampInt(int(math.Round(float64(o.Focus.Y)*float64(srcH)/1000-float64(cropH)/2)), 0, srcH-cropH)
+		}
+		return image.Rect(x, y, x+cropW, y+cropH), w, h
+	case FitFill:
+		return crop, min(w, srcW), min(h, srcH)
+	}
+	k := math.Min(1, math.Min(float64(w)/float64(srcW), float64(h)/float64(srcH)))
+	return crop, max(1, int(math.Round(float64(srcW)*k))), max(1, int(math.Round(float64(srcH)*k)))
+}
+
+func clampInt(n, lo, hi int) int {
+	return max(lo, min(n, hi))
+}
+
+// scaled returns n scaled by num/den, at least 1
+func scaled(n, num, den int) int {
+	return max(1, int(math.Round(float64(n)*float64(num)/float64(den))))
+}
+
+// Engine decodes, transforms and encodes images
+type Engine interface {
+	// Name is the engine's name, as selected by New
+	Name() string
+	// Reads reports whether the engine can decode key's file type
+	Reads(key string) bool
+	// Writes reports whether the engine can encode format
+	Writes(format string) bool
+	// Transform applies o to the encoded image src and returns the
+	// encoded result and its content type
+	Transform(src []byte, o Options) ([]byte, string, error)
+}
+
+var (
+	enginesMu sync.Mutex
+	engines   = map[string]func(Settings) (Engine, error){}
+)
+
+// register makes an engine available to New. Engines built in with
+// build tags register themselves from init.
+func register(name string, open func(Settings) (Engine, error)) {
+	enginesMu.Lock()
+	engines[name] = open
+	enginesMu.Unlock()
+}
+
+// Engines returns the names of the engines built in
+func Engines() []string {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	var names []string
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New opens the engine called name with s. "auto" or "" picks libvips
+// when it is built in and the pure-Go engine otherwise.
+func New(name string, s Settings) (Engine, error) {
+	enginesMu.Lock()
+	if name == "" || name == "auto" {
+		name = "go"
+		if engines["vips"] != nil {
+			name = "vips"
+		}
+	}
+	open := engines[name]
+	enginesMu.Unlock()
+	if open == nil {
+		return nil, fmt.Errorf("image engine %q is not built in (have %s)", name, strings.Join(Engines(), ", "))
+	}
+	switch s.Color {
+	case "":
+		s.Color = ColorSRGB
+	case ColorSRGB, ColorPreserve:
+	default:
+		return nil, fmt.Errorf("image color must be %s or %s, not %q", ColorSRGB, ColorPreserve, s.Color)
+	}
+	return open(s)
+}
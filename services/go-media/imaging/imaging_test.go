@@ -0,0 +1,244 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query       string
+		fingerprint string
+		err         bool
+	}{
+		{"", "", false},
+		{"min=1", "", false},
+		{"w=300", "w300", false},
+		{"w=300&h=200&fit=cover&format=webp", "w300-h200-cover-webp", false},
+		{"w=300&h=200&fit=contain", "w300-h200", false},
+		{"w=300&fit=cover", "w300", false},
+		{"h=50&q=82&format=jpg", "h50-jpeg", false},
+		{"h=50&q=60", "h50-q60", false},
+		{"w=300&h=200&fit=cover&fp-x=0.3&fp-y=0.6", "w300-h200-cover-fp300x600", false},
+		{"w=300&h=200&fit=cover&fp-x=0&fp-y=1", "w300-h200-cover-fp0x1000", false},
+		{"w=300&h=200&fp-x=0.3&fp-y=0.6", "w300-h200", false},
+		{"fp-x=0.3", "", true},
+		{"fp-x=1.5&fp-y=0", "", true},
+		{"w=0", "", true},
+		{"w=abc", "", true},
+		{"q=101", "", true},
+		{"fit=stretch", "", true},
+		{"format=bmp", "", true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		o, err := ParseQuery(q)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("ParseQuery(%q) error = %v, want ErrInvalidOptions", tt.query, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if got := o.Fingerprint(); got != tt.fingerprint {
+			t.Errorf("ParseQuery(%q) fingerprint = %q, want %q", tt.query, got, tt.fingerprint)
+		}
+		if tt.fingerprint == "" {
+			continue
+		}
+		if back, ok := ParseFingerprint(tt.fingerprint); !ok || back != o {
+			t.Errorf("ParseFingerprint(%q) = %+v, %v, want %+v", tt.fingerprint, back, ok, o)
+		}
+		if back, err := ParseQuery(o.Query()); err != nil || back != o {
+			t.Errorf("ParseQuery(%q) = %+v, %v, want %+v", o.Query().Encode(), back, err, o)
+		}
+	}
+
+	for _, bad := range []string{"min", "", "w300-", "w300-contain", "q82", "webp-w300", "w300-h200-cover-fp300", "w300-fp300x600", "w300-h200-cover-fp0300x600"} {
+		if _, ok := ParseFingerprint(bad); ok {
+			t.Errorf("ParseFingerprint(%q) accepted", bad)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name string
+		o    Options
+		crop image.Rectangle
+		w, h int
+	}{
+		{"width only", Options{Width: 400}, image.Rect(0, 0, 1600, 900), 400, 225},
+		{"height only", Options{Height: 90}, image.Rect(0, 0, 1600, 900), 160, 90},
+		{"contain", Options{Width: 400, Height: 400}, image.Rect(0, 0, 1600, 900), 400, 225},
+		{"cover", Options{Width: 400, Height: 400, Fit: FitCover}, image.Rect(350, 0, 1250, 900), 400, 400},
+		{"cover wider", Options{Width: 800, Height: 200, Fit: FitCover}, image.Rect(0, 250, 1600, 650), 800, 200},
+		{"fill", Options{Width: 400, Height: 400, Fit: FitFill}, image.Rect(0, 0, 1600, 900), 400, 400},
+		{"never enlarged", Options{Width: 3200}, image.Rect(0, 0, 1600, 900), 1600, 900},
+		{"cover focus left", Options{Width: 400, Height: 400, Fit: FitCover, Focus: Focus{X: 100, Y: 500, Set: true}}, image.Rect(0, 0, 900, 900), 400, 400},
+		{"cover focus", Options{Width: 400, Height: 400, Fit: FitCover, Focus: Focus{X: 600, Y: 0, Set: true}}, image.Rect(510, 0, 1410, 900), 400, 400},
+		{"cover focus right", Options{Width: 400, Height: 400, Fit: FitCover, Focus: Focus{X: 900, Y: 500, Set: true}}, image.Rect(700, 0, 1600, 900), 400, 400},
+		{"cover box shrunk", Options{Width: 2000, Height: 2000, Fit: FitCover}, image.Rect(350, 0, 1250, 900), 900, 900},
+	}
+	for _, tt := range tests {
+		crop, w, h := Plan(1600, 900, tt.o)
+		if crop != tt.crop || w != tt.w || h != tt.h {
+			t.Errorf("%s: Plan = %v %dx%d, want %v %dx%d", tt.name, crop, w, h, tt.crop, tt.w, tt.h)
+		}
+	}
+}
+
+func TestGoEngine(t *testing.T) {
+	// Left half red, right half blue
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 100 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := New("go", Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, contentType, err := e.Transform(buf.Bytes(), Options{Width: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" {
+		t.Errorf("content type = %q, want image/png", contentType)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 50 || b.Dy() != 25 {
+		t.Errorf("size = %dx%d, want 50x25", b.Dx(), b.Dy())
+	}
+	if r, _, b, _ := img.At(5, 10).RGBA(); r>>8 != 255 || b != 0 {
+		t.Errorf("left pixel = %v, want red", img.At(5, 10))
+	}
+
+	out, contentType, err = e.Transform(buf.Bytes(), Options{Width: 20, Height: 20, Fit: FitCover, Format: "jpeg"})
+	if err != nil || contentType != "image/jpeg" {
+		t.Fatalf("to jpeg: %q, %v", contentType, err)
+	}
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(out)); err != nil || cfg.Width != 20 || cfg.Height != 20 {
+		t.Errorf("jpeg = %+v, %v, want 20x20", cfg, err)
+	}
+
+	if _, _, err := e.Transform(buf.Bytes(), Options{Format: "webp"}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("webp output error = %v, want ErrUnsupportedFormat", err)
+	}
+	if _, _, err := e.Transform([]byte("not an image"), Options{Width: 10}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("bad input error = %v, want ErrUnsupportedFormat", err)
+	}
+	if !e.Reads("a/photo.JPG") || e.Reads("a/photo.heic") || e.Reads("a/photo.webp") {
+		t.Error("Reads disagrees with the go engine's decoders")
+	}
+}
+
+func TestTranscoded(t *testing.T) {
+	for key, want := range map[string]bool{"IMG_0001.HEIC": true, "a/b.heif": true, "a/b.jpg": false, "heic": false} {
+		if got := Transcoded(key); got != want {
+			t.Errorf("Transcoded(%q) = %v, want %v", key, got, want)
+		}
+		if want && !Source(key) {
+			t.Errorf("Source(%q) = false for a transcoded type", key)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	e, err := New("auto", Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Engines()[len(Engines())-1]; e.Name() != want {
+		t.Errorf("auto picked %s, want %s", e.Name(), want)
+	}
+	if _, err := New("magick", Settings{}); err == nil {
+		t.Error("New(magick) succeeded")
+	}
+	if _, err := New("go", Settings{Color: "p3"}); err == nil {
+		t.Error("New with color p3 succeeded")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	l := Limits{MaxWidth: 2048, MaxHeight: 2048, MaxPixels: 2048 * 1024, Formats: []string{"jpeg", "webp"}, Qualities: []int{60, 90}}
+	tests := []struct {
+		o  Options
+		ok bool
+	}{
+		{Options{Width: 2048}, true},
+		{Options{Width: 20000}, false},
+		{Options{Height: 2049}, false},
+		{Options{Width: 2048, Height: 1024}, true},
+		{Options{Width: 2048, Height: 2048}, false},
+		{Options{Width: 300, Format: "webp"}, true},
+		{Options{Width: 300, Format: "png"}, false},
+		{Options{Width: 300, Quality: 60}, true},
+		{Options{Width: 300, Quality: 61}, false},
+		{Options{Width: 300}, true}, // the default quality is always allowed
+	}
+	for _, tt := range tests {
+		err := l.Allow(tt.o)
+		if tt.ok && err != nil || !tt.ok && !errors.Is(err, ErrOutsideLimits) {
+			t.Errorf("Allow(%+v) = %v, want ok %v", tt.o, err, tt.ok)
+		}
+	}
+	if err := (Limits{}).Allow(Options{Width: 20000, Format: "avif", Quality: 1}); err != nil {
+		t.Errorf("zero Limits refused %v", err)
+	}
+}
+
+func TestDetectFocus(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{120, 120, 120, 255}
+			// Detail in the right half, upper third
+			if x >= 260 && x < 340 && y >= 30 && y < 70 && (x/4+y/4)%2 == 0 {
+				c = color.RGBA{230, 40, 40, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	f := DetectFocus(img)
+	if !f.Set || f.X < 650 || f.X > 850 || f.Y < 150 || f.Y > 350 {
+		t.Errorf("DetectFocus = %+v, want about 750,250", f)
+	}
+	if f := DetectFocus(image.NewRGBA(image.Rect(0, 0, 50, 50))); f != (Focus{X: 500, Y: 500, Set: true}) {
+		t.Errorf("DetectFocus of a blank image = %+v, want the centre", f)
+	}
+}
+
+func TestParseFocus(t *testing.T) {
+	f, err := ParseFocus("0.3, 0.65")
+	if err != nil || f != (Focus{X: 300, Y: 650, Set: true}) || f.String() != "0.3,0.65" {
+		t.Errorf("ParseFocus = %+v (%s), %v", f, f, err)
+	}
+	for _, bad := range []string{"", "0.3", "0.3,", "a,b", "-0.1,0.5", "0.5,1.01"} {
+		if _, err := ParseFocus(bad); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("ParseFocus(%q) error = %v, want ErrInvalidOptions", bad, err)
+		}
+	}
+}
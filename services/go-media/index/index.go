@@ -0,0 +1,216 @@
+package index
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/fsutil"
+)
+
+// Asset states. An entry without one is published.
+const (
+	StateDraft     = "draft"
+	StatePublished = "published"
+	StateArchived  = "archived"
+)
+
+// Entry holds the metadata tracked for a single object key
+type Entry struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	ETag        string    `json:"etag,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Integrity is the Subresource Integrity hash of the content,
+	// recorded by uploads that read all of it
+	Integrity string `json:"integrity,omitempty"`
+	// Orphaned entries were missing from the bucket when last reconciled
+	Orphaned bool `json:"orphaned,omitempty"`
+	// CustomerKey objects are encrypted with a key only the client holds
+	CustomerKey bool `json:"customer_key,omitempty"`
+	// Text is the object's searchable text, for the types it is
+	// extracted from
+	Text string `json:"text,omitempty"`
+	// State is draft or archived for objects kept off the public path,
+	// and empty for published ones
+	State string `json:"state,omitempty"`
+	// PublishAt and UnpublishAt bound when the object is public. Each
+	// is cleared once the publishing job has acted on it, and State
+	// archived after UnpublishAt.
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty"`
+	Stats       Stats      `json:"stats"`
+}
+
+// Hidden reports whether the object is kept off the public path at now,
+// being a draft, archived, or scheduled for later
+func (e Entry) Hidden(now time.Time) bool {
+	return e.State == StateDraft || e.State == StateArchived ||
+		e.PublishAt != nil && now.Before(*e.PublishAt) ||
+		e.UnpublishAt != nil && !now.Before(*e.UnpublishAt)
+}
+
+// Stats are usage counters accumulated for an object
+type Stats struct {
+	Requests    int64     `json:"requests"`
+	BytesServed int64     `json:"bytes_served"`
+	LastAccess  time.Time `json:"last_access,omitempty"`
+}
+
+// Index is an in-memory metadata index that is persisted as a JSON
+// snapshot. An empty path keeps the index in memory only.
+type Index struct {
+	mu      sync.RWMutex
+	saveMu  sync.Mutex
+	path    string
+	entries map[string]*Entry
+	dirty   bool
+}
+
+type snapshot struct {
+	SavedAt time.Time `json:"saved_at"`
+	Entries []*Entry  `json:"entries"`
+}
+
+// Open loads the index snapshot at path, starting empty if it does not exist
+func Open(path string) (*Index, error) {
+	idx := &Index{
+		path:    path,
+		entries: make(map[string]*Entry),
+	}
+
+	if path == "" {
+		return idx, nil
+	}
+
+	var snap snapshot
+	if _, err := fsutil.ReadJSON(path, &snap); err != nil {
+		return nil, err
+	}
+	for _, e := range snap.Entries {
+		idx.entries[e.Key] = e
+	}
+
+	return idx, nil
+}
+
+// Get returns a copy of the entry for key
+func (i *Index) Get(key string) (Entry, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	e, ok := i.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Update applies fn to the entry for key, creating it if necessary
+func (i *Index) Update(key string, fn func(e *Entry)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.entries[key]
+	if !ok {
+		e = &Entry{Key: key}
+		i.entries[key] = e
+	}
+	fn(e)
+	i.dirty = true
+}
+
+// UpdateIfPresent applies fn to the entry for key if there is one, and
+// reports whether there was
+func (i *Index) UpdateIfPresent(key string, fn func(e *Entry)) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.entries[key]
+	if !ok {
+		return false
+	}
+	fn(e)
+	i.dirty = true
+	return true
+}
+
+// Delete removes the entry for key
+func (i *Index) Delete(key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.entries[key]; ok {
+		delete(i.entries, key)
+		i.dirty = true
+	}
+}
+
+// List returns copies of all entries under prefix, sorted by key
+func (i *Index) List(prefix string) []Entry {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	entries := make([]Entry, 0)
+	for key, e := range i.entries {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, *e)
+		}
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Key < entries[b].Key
+	})
+
+	return entries
+}
+
+// Totals returns the number of indexed objects and their combined size
+func (i *Index) Totals() (count int, bytes int64) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for _, e := range i.entries {
+		bytes += e.Size
+	}
+	return len(i.entries), bytes
+}
+
+// Save writes the index snapshot to disk if anything changed since the
+// last save. The file is replaced atomically.
+func (i *Index) Save() error {
+	if i.path == "" {
+		return nil
+	}
+
+	i.saveMu.Lock()
+	defer i.saveMu.Unlock()
+
+	i.mu.Lock()
+	if !i.dirty {
+		i.mu.Unlock()
+		return nil
+	}
+	snap := snapshot{
+		SavedAt: time.Now().UTC(),
+		Entries: make([]*Entry, 0, len(i.entries)),
+	}
+	for _, e := range i.entries {
+		copied := *e
+		snap.Entries = append(snap.Entries, &copied)
+	}
+	i.dirty = false
+	i.mu.Unlock()
+
+	if err := fsutil.WriteJSON(i.path, snap); err != nil {
+		// Keep the changes pending so the next save retries them
+		i.mu.Lock()
+		i.dirty = true
+		i.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
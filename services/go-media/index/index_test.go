@@ -0,0 +1,59 @@
+package index
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexSaveAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	idx.Update("assets/a.png", func(e *Entry) {
+		e.Size = 42
+		e.ContentType = "image/png"
+	})
+	idx.Update("docs/b.pdf", func(e *Entry) {
+		e.Size = 7
+	})
+	idx.Delete("docs/b.pdf")
+
+	if err := idx.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	entry, ok := reloaded.Get("assets/a.png")
+	if !ok {
+		t.Fatal("expected entry to survive reload")
+	}
+	if entry.Size != 42 || entry.ContentType != "image/png" {
+		t.Errorf("unexpected entry after reload: %+v", entry)
+	}
+	if _, ok := reloaded.Get("docs/b.pdf"); ok {
+		t.Error("deleted entry should not be persisted")
+	}
+}
+
+func TestIndexListPrefix(t *testing.T) {
+	idx, _ := Open("")
+	for _, key := range []string{"assets/b", "assets/a", "docs/c"} {
+		idx.Update(key, func(e *Entry) {})
+	}
+
+	entries := idx.List("assets/")
+	if len(entries) != 2 {
+		t.Fatalf("List() returned %d entries, want 2", len(entries))
+	}
+	if entries[0].Key != "assets/a" || entries[1].Key != "assets/b" {
+		t.Errorf("List() not sorted: %s, %s", entries[0].Key, entries[1].Key)
+	}
+}
@@ -0,0 +1,117 @@
+package index
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// snippetRadius is how much text either side of a match a snippet shows
+const snippetRadius = 80
+
+// keyWeight scores a term found in an entry's key above one found in
+// its text only, however often
+const keyWeight = 10
+
+// Result is an entry matching a search
+type Result struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Score       int       `json:"score"`
+	// Snippet is the text around the first match in it
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Search returns up to limit entries under prefix whose key or text holds
+// every word of query, ignoring case, skipping those keep rejects if it is
+// set. The best matches come first: those with the words in their keys,
+// then those mentioning them most.
+func (i *Index) Search(prefix, query string, limit int, keep func(e *Entry) bool) []Result {
+	terms := strings.Fields(strings.ToLower(query))
+	results := make([]Result, 0)
+	if len(terms) == 0 {
+		return results
+	}
+
+	i.mu.RLock()
+	for key, e := range i.entries {
+		if !strings.HasPrefix(key, prefix) || keep != nil && !keep(e) {
+			continue
+		}
+		if r, ok := match(e, terms); ok {
+			results = append(results, r)
+		}
+	}
+	i.mu.RUnlock()
+
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return results[a].Key < results[b].Key
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// match scores e against terms, all of which must be found
+func match(e *Entry, terms []string) (Result, bool) {
+	key := strings.ToLower(e.Key)
+	text := strings.ToLower(e.Text)
+	score, first := 0, -1
+	for _, term := range terms {
+		inKey := strings.Contains(key, term)
+		n := strings.Count(text, term)
+		if !inKey && n == 0 {
+			return Result{}, false
+		}
+		if inKey {
+			score += keyWeight
+		}
+		score += min(n, keyWeight-1)
+		if at := strings.Index(text, term); at >= 0 && (first < 0 || at < first) {
+			first = at
+		}
+	}
+	r := Result{Key: e.Key, Size: e.Size, ContentType: e.ContentType, UpdatedAt: e.UpdatedAt, Score: score}
+	if first >= 0 {
+		r.Snippet = snippet(e.Text, text, first)
+	}
+	return r, true
+}
+
+// snippet returns the text around offset at in lower, the lowercased
+// text. Lowercasing seldom changes a string's length, but when it has the
+// offsets don't line up, and the snippet is the start of the text.
+func snippet(text, lower string, at int) string {
+	if len(text) != len(lower) {
+		at = 0
+	}
+	start, end := max(at-snippetRadius, 0), min(at+snippetRadius, len(text))
+	// Widen to whole words, and whole runes
+	if i := strings.LastIndexAny(text[:start], " \n"); start > 0 && i >= 0 && start-i < 20 {
+		start = i + 1
+	}
+	if i := strings.IndexAny(text[end:], " \n"); i >= 0 && i < 20 {
+		end += i
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	s := strings.ReplaceAll(text[start:end], "\n", " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package index
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	idx, _ := Open("")
+	idx.Update("docs/handbook.pdf", func(e *Entry) {
+		e.Text = "Welcome to the team. " + strings.Repeat("Filler text here. ", 20) + "Expense reports are due monthly; see the Expense policy."
+	})
+	idx.Update("docs/expense-policy.txt", func(e *Entry) { e.Text = "Receipts over $25 need approval." })
+	idx.Update("docs/menu.csv", func(e *Entry) { e.Text = "soup,bread" })
+	idx.Update("public/expense.json", func(e *Entry) { e.Text = "expense" })
+
+	got := idx.Search("docs/", "EXPENSE", 10, nil)
+	if len(got) != 2 || got[0].Key != "docs/expense-policy.txt" || got[1].Key != "docs/handbook.pdf" {
+		t.Fatalf("Search(expense) = %+v", got)
+	}
+	if got[0].Score <= got[1].Score {
+		t.Errorf("key match scored %d, text match %d", got[0].Score, got[1].Score)
+	}
+	if s := got[1].Snippet; !strings.HasPrefix(s, "…") || !strings.Contains(s, "Expense reports are due monthly") {
+		t.Errorf("snippet = %q", s)
+	}
+
+	// Every word must match, in the key or the text
+	if got := idx.Search("", "expense approval", 10, nil); len(got) != 1 || got[0].Key != "docs/expense-policy.txt" {
+		t.Errorf("Search(expense approval) = %+v", got)
+	}
+	if got := idx.Search("", "expense", 1, nil); len(got) != 1 {
+		t.Errorf("limit 1 returned %d results", len(got))
+	}
+	if got := idx.Search("", "  ", 10, nil); len(got) != 0 {
+		t.Errorf("empty query matched %d entries", len(got))
+	}
+}
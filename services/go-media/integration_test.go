@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/s3fake"
+	"github.com/WomB0ComB0/cdn/services/go-media/middleware"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+// stack is the service's full router in front of an in-memory bucket
+type stack struct {
+	t      *testing.T
+	server *httptest.Server
+	bucket *s3fake.Server
+	media  *handlers.MediaHandler
+
+	mu     sync.Mutex
+	purged []string
+}
+
+// testAdminToken is the stack's admin token
+const testAdminToken = "test-admin-token"
+
+func newStack(t *testing.T, opts ...handlers.Option) *stack {
+	t.Helper()
+	s := &stack{t: t, bucket: s3fake.New("assets")}
+	bucket := httptest.NewServer(s.bucket)
+	t.Cleanup(bucket.Close)
+
+	// Stands in for Cloudflare's purge API
+	cloudflare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Files []string `json:"files"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		s.mu.Lock()
+		s.purged = append(s.purged, req.Files...)
+		s.mu.Unlock()
+		w.Write([]byte(`{"success": true}`))
+	}))
+	t.Cleanup(cloudflare.Close)
+
+	r2, err := storage.NewR2Client(storage.R2Config{
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		BucketName:      "assets",
+		Endpoint:        bucket.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, _ := index.Open("")
+	probes := handlers.NewProbes("test", r2.HeadBucket)
+	probes.MarkWarm()
+	s.media = handlers.NewMediaHandler(r2, "secret", append([]handlers.Option{
+		handlers.WithIndex(idx),
+		handlers.WithCloudflare("zone", "token"),
+		handlers.WithCloudflareAPI(cloudflare.URL),
+	}, opts...)...)
+	cfg := config.Default()
+	cfg.AdminToken = testAdminToken
+	s.server = httptest.NewServer(newHandler(routeDeps{
+		cfg:         cfg,
+		media:       s.media,
+		probes:      probes,
+		drainer:     middleware.NewDrainer(),
+		uploadLimit: func(next http.Handler) http.Handler { return next },
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// upload uploads a file through the API and returns its key
+func (s *stack) upload(name, content string) string {
+	s.t.Helper()
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", name)
+	io.WriteString(part, content)
+	mw.Close()
+	resp := s.do("POST", "/v1/media/upload", http.Header{"Content-Type": {mw.FormDataContentType()}}, &form, http.StatusOK)
+	var uploaded handlers.UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		s.t.Fatal(err)
+	}
+	return uploaded.Key
+}
+
+// do sends a request to the service, failing the test unless it answers
+// with status
+func (s *stack) do(method, path string, header http.Header, body io.Reader, status int) *http.Response {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.server.URL+path, body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != status {
+		b, _ := io.ReadAll(resp.Body)
+		s.t.Fatalf("%s %s = %d, want %d: %s", method, path, resp.StatusCode, status, b)
+	}
+	return resp
+}
+
+func readAll(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestAssetLifecycle drives one asset through the HTTP API end to end:
+// upload, serve, range, sign, purge and delete
+func TestAssetLifecycle(t *testing.T) {
+	s := newStack(t)
+	content := strings.Repeat("0123456789", 100)
+
+	s.do("GET", "/readyz", nil, nil, http.StatusOK)
+
+	// Upload
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "notes.txt")
+	io.WriteString(part, content)
+	mw.Close()
+	resp := s.do("POST", "/v1/media/upload", http.Header{"Content-Type": {mw.FormDataContentType()}}, &form, http.StatusOK)
+	var uploaded handlers.UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if stored, ok := s.bucket.Object(uploaded.Key); !ok || string(stored) != content {
+		t.Fatalf("bucket holds %q at %s", stored, uploaded.Key)
+	}
+	if !strings.HasSuffix(uploaded.URL, "/"+uploaded.Key) {
+		t.Errorf("upload URL = %s for %s", uploaded.URL, uploaded.Key)
+	}
+
+	// Serve, then revalidate
+	assetPath := "/v1/media/assets/" + uploaded.Key
+	resp = s.do("GET", assetPath, nil, nil, http.StatusOK)
+	if got := readAll(t, resp); got != content {
+		t.Errorf("served %d bytes, want %d", len(got), len(content))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	s.do("GET", assetPath, http.Header{"If-None-Match": {etag}}, nil, http.StatusNotModified)
+	s.do("HEAD", assetPath, nil, nil, http.StatusOK)
+
+	// Range
+	resp = s.do("GET", assetPath, http.Header{"Range": {"bytes=10-19"}}, nil, http.StatusPartialContent)
+	if got := readAll(t, resp); got != "0123456789" {
+		t.Errorf("range body = %q", got)
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "bytes 10-19/1000" {
+		t.Errorf("Content-Range = %q", cr)
+	}
+
+	// Sign, then fetch through the signed URL
+	resp = s.do("POST", "/v1/media/sign", http.Header{"Content-Type": {"application/json"}},
+		strings.NewReader(`{"path": "`+uploaded.Key+`", "expires_in": 300}`), http.StatusOK)
+	var signed handlers.SignedURLResponse
+	json.NewDecoder(resp.Body).Decode(&signed)
+	u, err := url.Parse(signed.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, s.do("GET", u.RequestURI(), nil, nil, http.StatusOK)); got != content {
+		t.Errorf("signed URL served %d bytes", len(got))
+	}
+	q := u.Query()
+	q.Set("sig", "forged")
+	u.RawQuery = q.Encode()
+	s.do("GET", u.RequestURI(), nil, nil, http.StatusForbidden)
+
+	// Purge
+	s.do("POST", "/v1/media/purge", http.Header{"Content-Type": {"application/json"}, "Authorization": {"Bearer " + testAdminToken}},
+		strings.NewReader(`{"files": ["`+uploaded.URL+`"]}`), http.StatusOK)
+	s.mu.Lock()
+	purged := s.purged
+	s.mu.Unlock()
+	if len(purged) != 1 || purged[0] != uploaded.URL {
+		t.Errorf("purged %v", purged)
+	}
+
+	// Delete, which takes the admin token
+	s.do("DELETE", "/v1/media/delete/"+uploaded.Key, nil, nil, http.StatusUnauthorized)
+	s.do("DELETE", "/v1/media/delete/"+uploaded.Key, http.Header{"Authorization": {"Bearer " + testAdminToken}}, nil, http.StatusOK)
+	if _, ok := s.bucket.Object(uploaded.Key); ok {
+		t.Error("object still in the bucket after delete")
+	}
+	s.do("GET", assetPath, nil, nil, http.StatusNotFound)
+
+	// /v2 serves the same routes with problem details for errors
+	resp = s.do("GET", "/v2/media/info/"+uploaded.Key, nil, nil, http.StatusNotFound)
+	if ct := resp.Header.Get("Content-Type"); ct != apierror.ProblemContentType {
+		t.Errorf("/v2 error as %s", ct)
+	}
+}
+
+// TestAdminOnlyRoutes refuses state-changing requests without the admin
+// token before they touch the bucket
+func TestAdminOnlyRoutes(t *testing.T) {
+	s := newStack(t)
+	key := s.upload("a.txt", "alpha")
+	headers := http.Header{"Content-Type": {"application/json"}}
+
+	for _, r := range []struct{ method, path, body string }{
+		{"DELETE", "/v1/media/delete/" + key, ""},
+		{"POST", "/v1/media/promote/" + key, "{}"},
+		{"POST", "/v1/media/archive/" + key, "{}"},
+		{"POST", "/v1/media/favicons/" + key, "{}"},
+		{"POST", "/v1/media/purge", `{"files": ["` + key + `"]}`},
+		{"POST", "/v1/media/collections", `{"name": "a", "keys": ["` + key + `"]}`},
+		{"PUT", "/v1/media/collections/x", `{"name": "a", "keys": ["` + key + `"]}`},
+		{"DELETE", "/v1/media/collections/x", ""},
+	} {
+		s.do(r.method, r.path, headers, strings.NewReader(r.body), http.StatusUnauthorized)
+	}
+	if _, ok := s.bucket.Object(key); !ok {
+		t.Error("object deleted without the admin token")
+	}
+}
+
+// TestPrefixDelete deletes a prefix only as confirmed by a dry run
+func TestPrefixDelete(t *testing.T) {
+	s := newStack(t)
+	first := s.upload("a.txt", "alpha")
+	prefix := first[:strings.LastIndex(first, "/")+1]
+	path := "/v1/media/prefix/" + prefix
+	admin := http.Header{"Authorization": {"Bearer " + testAdminToken}}
+
+	s.do("DELETE", path, nil, nil, http.StatusUnauthorized)
+	s.do("DELETE", path, admin, nil, http.StatusPreconditionRequired)
+
+	var plan handlers.PrefixDeletePlan
+	json.NewDecoder(s.do("DELETE", path+"?dry_run=true", admin, nil, http.StatusOK).Body).Decode(&plan)
+	if plan.Count != 1 || plan.Bytes != 5 || plan.Keys[0] != first || plan.Confirm == "" {
+		t.Fatalf("dry run = %+v", plan)
+	}
+	if _, ok := s.bucket.Object(first); !ok {
+		t.Fatal("dry run deleted the object")
+	}
+
+	// An upload since the dry run voids its token
+	second := s.upload("b.txt", "bravo")
+	if !strings.HasPrefix(second, prefix) {
+		t.Fatalf("second upload at %s, outside %s", second, prefix)
+	}
+	s.do("DELETE", path+"?confirm="+url.QueryEscape(plan.Confirm), admin, nil, http.StatusConflict)
+	s.do("DELETE", path+"?confirm=forged", admin, nil, http.StatusForbidden)
+
+	json.NewDecoder(s.do("DELETE", path+"?dry_run=true", admin, nil, http.StatusOK).Body).Decode(&plan)
+	var deleted handlers.PrefixDeleteResponse
+	json.NewDecoder(s.do("DELETE", path+"?confirm="+url.QueryEscape(plan.Confirm), admin, nil, http.StatusOK).Body).Decode(&deleted)
+	if deleted.Deleted != 2 || len(deleted.Failed) != 0 {
+		t.Errorf("deleted = %+v", deleted)
+	}
+	if keys := s.bucket.Keys(); len(keys) != 0 {
+		t.Errorf("bucket still holds %v", keys)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	s := newStack(t, handlers.WithTrash(true))
+	key := s.upload("a.txt", "alpha")
+	admin := http.Header{"Authorization": {"Bearer " + testAdminToken}}
+	asset := "/v1/media/assets/" + key
+
+	s.do("DELETE", "/v1/media/delete/"+key, admin, nil, http.StatusOK)
+	s.do("GET", asset, nil, nil, http.StatusNotFound)
+	s.do("GET", "/v1/media/assets/.trash/"+key, nil, nil, http.StatusNotFound)
+	if _, ok := s.bucket.Object(key); ok {
+		t.Fatal("deleted object still in place")
+	}
+
+	var listing handlers.TrashListing
+	json.NewDecoder(s.do("GET", "/v1/admin/trash?prefix="+url.QueryEscape(key), admin, nil, http.StatusOK).Body).Decode(&listing)
+	if len(listing.Items) != 1 || listing.Items[0].Key != key || listing.Items[0].Size != 5 || listing.Items[0].DeletedAt.IsZero() {
+		t.Fatalf("trash = %+v", listing)
+	}
+	var usage handlers.TrashUsage
+	json.NewDecoder(s.do("GET", "/v1/admin/trash/usage", admin, nil, http.StatusOK).Body).Decode(&usage)
+	if usage.Objects != 1 || usage.Bytes != 5 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	// The key is taken again before the restore
+	s.bucket.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/assets/"+key, strings.NewReader("bravo")))
+	restore := func(body string) handlers.TrashRestoreResponse {
+		var resp handlers.TrashRestoreResponse
+		json.NewDecoder(s.do("POST", "/v1/admin/trash/restore", admin, strings.NewReader(body), http.StatusOK).Body).Decode(&resp)
+		return resp
+	}
+	prefix := key[:strings.LastIndex(key, "/")+1]
+	if resp := restore(`{"prefix":"` + prefix + `"}`); resp.Restored != 0 || len(resp.Skipped) != 1 || resp.Skipped[0] != key {
+		t.Errorf("restore over a newer object = %+v", resp)
+	}
+	if resp := restore(`{"keys":["` + key + `"],"overwrite":true}`); resp.Restored != 1 || len(resp.Failed) != 0 {
+		t.Errorf("restore = %+v", resp)
+	}
+	if body := readAll(t, s.do("GET", asset, nil, nil, http.StatusOK)); body != "alpha" {
+		t.Errorf("restored %q", body)
+	}
+	if keys := s.bucket.Keys(); len(keys) != 1 || keys[0] != key {
+		t.Errorf("bucket holds %v", keys)
+	}
+	if resp := restore(`{"keys":["` + key + `"]}`); len(resp.Failed) != 1 {
+		t.Errorf("restore of a key not in the trash = %+v", resp)
+	}
+}
+
+// TestTrashPurge empties the trash of objects kept past its retention
+func TestTrashPurge(t *testing.T) {
+	s := newStack(t, handlers.WithTrash(true), handlers.WithTrashRetention(30))
+	old, recent := s.upload("a.txt", "alpha"), s.upload("b.txt", "bravo")
+	admin := http.Header{"Authorization": {"Bearer " + testAdminToken}}
+
+	s.bucket.SetClock(func() time.Time { return time.Now().AddDate(0, 0, -31) })
+	s.do("DELETE", "/v1/media/delete/"+old, admin, nil, http.StatusOK)
+	s.bucket.SetClock(time.Now)
+	s.do("DELETE", "/v1/media/delete/"+recent, admin, nil, http.StatusOK)
+
+	if err := s.media.PurgeTrash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.bucket.Keys(); len(keys) != 1 || keys[0] != ".trash/"+recent {
+		t.Errorf("bucket holds %v, want only the recently deleted object", keys)
+	}
+}
+
+func TestIndexExportImport(t *testing.T) {
+	s := newStack(t)
+	key := s.upload("a.txt", "alpha")
+	admin := http.Header{"Authorization": {"Bearer " + testAdminToken}}
+	export := func() handlers.IndexSnapshot {
+		var snap handlers.IndexSnapshot
+		json.NewDecoder(s.do("GET", "/v1/admin/index?prefix="+url.QueryEscape(key), admin, nil, http.StatusOK).Body).Decode(&snap)
+		return snap
+	}
+
+	snap := export()
+	if len(snap.Entries) != 1 || snap.Entries[0].Key != key || snap.Entries[0].Size != 5 {
+		t.Fatalf("export = %+v", snap)
+	}
+
+	// Entries are taken as they are, replacing those at the same keys
+	snap.Entries[0].Size = 7
+	snap.Entries = append(snap.Entries, index.Entry{Key: "restored/b.txt", Size: 3})
+	body, _ := json.Marshal(snap)
+	var resp handlers.IndexImportResponse
+	json.NewDecoder(s.do("POST", "/v1/admin/index", admin, bytes.NewReader(body), http.StatusOK).Body).Decode(&resp)
+	if resp.Imported != 2 {
+		t.Errorf("imported %d, want 2", resp.Imported)
+	}
+	if snap := export(); len(snap.Entries) != 1 || snap.Entries[0].Size != 7 {
+		t.Errorf("export after import = %+v", snap)
+	}
+
+	s.do("POST", "/v1/admin/index", admin, strings.NewReader(`{"entries":[{"key":"../x"}]}`), http.StatusBadRequest)
+}
+
+// TestPrivateHTMLByType serves an object stored as HTML under another
+// extension through a signed URL
+func TestPrivateHTMLByType(t *testing.T) {
+	for _, origin := range []string{"", "https://usercontent.example.com"} {
+		s := newStack(t, handlers.WithHTML(config.HTMLConfig{Origin: origin}))
+		put := httptest.NewRequest("PUT", "/assets/notes/b.txt", strings.NewReader("<script>alert(1)</script>"))
+		put.Header.Set("Content-Type", "text/html")
+		s.bucket.ServeHTTP(httptest.NewRecorder(), put)
+
+		// Overrides browsers would run scripts in can't be signed
+		s.do("POST", "/v1/media/sign", nil, strings.NewReader(`{"path":"notes/a.txt","content_type":"text/html"}`), http.StatusBadRequest)
+
+		var signed handlers.SignedURLResponse
+		json.NewDecoder(s.do("POST", "/v1/media/sign", nil, strings.NewReader(`{"path":"notes/b.txt"}`), http.StatusOK).Body).Decode(&signed)
+		u, _ := url.Parse(signed.URL)
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(s.server.URL + u.RequestURI())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if origin != "" {
+			if resp.StatusCode != http.StatusTemporaryRedirect || !strings.HasPrefix(resp.Header.Get("Location"), origin+"/") {
+				t.Errorf("with an HTML origin: status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("status %d, Content-Security-Policy %q", resp.StatusCode, resp.Header.Get("Content-Security-Policy"))
+		}
+	}
+}
+
+func TestGeneratedImagesExpire(t *testing.T) {
+	s := newStack(t, handlers.WithLifecycle(config.LifecycleConfig{GeneratedDays: 30}))
+	s.bucket.SetClock(func() time.Time { return time.Now().AddDate(0, 0, -31) })
+	s.do("GET", "/v1/media/placeholder/20x10", nil, nil, http.StatusOK)
+	s.bucket.SetClock(time.Now)
+	s.do("GET", "/v1/media/placeholder/30x10", nil, nil, http.StatusOK)
+	if keys := s.bucket.Keys(); len(keys) != 2 {
+		t.Fatalf("bucket holds %v, want both placeholders", keys)
+	}
+
+	if err := s.media.ExpireObjects(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.bucket.Keys(); len(keys) != 1 || !strings.HasPrefix(keys[0], "generated/placeholder/") {
+		t.Errorf("bucket holds %v, want only the recent placeholder", keys)
+	}
+}
+
+func TestReadOnlyReplicaDoesNotStoreGeneratedImages(t *testing.T) {
+	s := newStack(t, handlers.WithReadOnly(true))
+	resp := s.do("GET", "/v1/media/placeholder/20x10", nil, nil, http.StatusOK)
+	if resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	if keys := s.bucket.Keys(); len(keys) != 0 {
+		t.Errorf("replica stored %v", keys)
+	}
+}
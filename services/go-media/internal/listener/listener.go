@@ -0,0 +1,108 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sdListenFDsStart is the first file descriptor passed by systemd
+const sdListenFDsStart = 3
+
+// Listen opens the listener described by addr:
+//
+//	host:port          TCP
+//	unix:/path/to.sock Unix domain socket, replacing a stale socket file
+//	systemd            the first socket passed by systemd socket activation
+//	systemd:name       the socket with FileDescriptorName=name
+//
+// socketMode sets the permissions of a Unix socket so a co-located proxy
+// (e.g. nginx) can connect.
+func Listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(addr, "unix:"), socketMode)
+	case addr == "systemd":
+		return listenSystemd("")
+	case strings.HasPrefix(addr, "systemd:"):
+		return listenSystemd(strings.TrimPrefix(addr, "systemd:"))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("unix socket path is empty")
+	}
+
+	// Remove a socket left behind by an unclean exit, but never a regular file
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// listenSystemd returns an inherited socket following the sd_listen_fds
+// protocol (LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES)
+func listenSystemd(name string) (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID not set for this process)")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	index := 0
+	if name != "" {
+		index = -1
+		for i, n := range names {
+			if n == name && i < count {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("systemd passed no socket named %q", name)
+		}
+	}
+
+	f := os.NewFile(uintptr(sdListenFDsStart+index), "systemd-socket")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited fd %d is not a listening socket: %w", sdListenFDsStart+index, err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,67 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "cdn.sock")
+
+	ln, err := Listen("unix:"+path, 0o660)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode = %v, want 0660", info.Mode().Perm())
+	}
+
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close()
+
+	// A stale socket from an unclean exit is replaced; net.UnixListener
+	// removes the file on Close, so recreate one without cleanup
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = Listen("unix:"+path, 0o660)
+	if err != nil {
+		t.Fatalf("Listen() over stale socket error = %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenUnixRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cdn.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Listen("unix:"+path, 0o660); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("Listen() error = %v, want not a socket", err)
+	}
+}
+
+func TestListenSystemdWithoutSockets(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	if _, err := Listen("systemd", 0); err == nil || !strings.Contains(err.Error(), "LISTEN_PID") {
+		t.Errorf("Listen() error = %v, want LISTEN_PID error", err)
+	}
+}
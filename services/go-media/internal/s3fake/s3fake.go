@@ -0,0 +1,519 @@
+// Package s3fake is an in-memory S3 server speaking the path-style
+// requests storage.R2Client makes, for tests that run the service end to
+// end without R2. It keeps one bucket, checks no signatures and ignores
+// SSE-C keys.
+package s3fake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+type object struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	etag        string // quoted
+	modified    time.Time
+}
+
+type upload struct {
+	key         string
+	contentType string
+	metadata    map[string]string
+	initiated   time.Time
+	parts       map[int][]byte
+}
+
+// Server is an S3 bucket held in memory
+type Server struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]*object
+	uploads map[string]*upload
+	nextID  int
+	now     func() time.Time
+}
+
+// New returns an empty bucket named bucket
+func New(bucket string) *Server {
+	return &Server{
+		bucket:  bucket,
+		objects: make(map[string]*object),
+		uploads: make(map[string]*upload),
+		now:     func() time.Time { return time.Now().UTC().Truncate(time.Millisecond) },
+	}
+}
+
+// Object returns the content of key, for assertions
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(o.data), true
+}
+
+// SetClock sets the modification time given to objects written from now
+// on, for tests of what happens as they age
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Keys returns every key in the bucket, in order
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodGet && q.Has("uploads"):
+		s.listUploads(w)
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case key == "":
+		s.error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.createUpload(w, r, key)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, r)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeUpload(w, r, key)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		if _, ok := s.uploads[q.Get("uploadId")]; !ok {
+			s.error(w, r, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && q.Has("uploadId"):
+		s.listParts(w, r, key)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		s.put(w, r, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	// Responses to HEAD have no body, so the SDK maps the status alone
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// readBody reads a request body, decoding the aws-chunked framing the SDK
+// may stream it in
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil || !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data, err
+	}
+	var out []byte
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte("\r\n"))
+		if !ok {
+			return nil, fmt.Errorf("bad chunk framing")
+		}
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil || int64(len(rest)) < size {
+			return nil, fmt.Errorf("bad chunk size")
+		}
+		if size == 0 {
+			break
+		}
+		out = append(out, rest[:size]...)
+		data = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return out, nil
+}
+
+func metadata(h http.Header) map[string]string {
+	m := make(map[string]string)
+	for name, values := range h {
+		if k, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			m[k] = values[0]
+		}
+	}
+	return m
+}
+
+// precondition checks If-Match and If-None-Match against o, which is
+// nil when the object doesn't exist
+func precondition(r *http.Request, o *object, ifMatch, ifNoneMatch string) bool {
+	if m := r.Header.Get(ifMatch); m != "" && (o == nil || (m != "*" && strings.Trim(m, `"`) != strings.Trim(o.etag, `"`))) {
+		return false
+	}
+	if m := r.Header.Get(ifNoneMatch); m == "*" && o != nil {
+		return false
+	}
+	return true
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if !precondition(r, s.objects[key], "If-Match", "If-None-Match") {
+		s.error(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	sum := md5.Sum(data)
+	o := &object{
+		data:        data,
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    metadata(r.Header),
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified:    s.now(),
+	}
+	s.objects[key] = o
+	w.Header().Set("ETag", o.etag)
+}
+
+func (s *Server) copy(w http.ResponseWriter, r *http.Request, key string) {
+	source := strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")
+	srcBucket, srcKey, _ := strings.Cut(source, "/")
+	if i := strings.Index(srcKey, "?"); i >= 0 {
+		srcKey = srcKey[:i]
+	}
+	srcKey, err := url.PathUnescape(srcKey)
+	src, ok := s.objects[srcKey]
+	if err != nil || srcBucket != s.bucket || !ok {
+		s.error(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if !precondition(r, src, "X-Amz-Copy-Source-If-Match", "") {
+		s.error(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	o := &object{data: src.data, contentType: src.contentType, metadata: src.metadata, etag: src.etag, modified: s.now()}
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		o.contentType, o.metadata = r.Header.Get("Content-Type"), metadata(r.Header)
+	}
+	s.objects[key] = o
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: o.etag, LastModified: o.modified.Format(timeFormat)})
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	o, ok := s.objects[key]
+	if !ok {
+		s.error(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if !precondition(r, o, "If-Match", "") {
+		s.error(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", o.etag)
+	h.Set("Last-Modified", o.modified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if o.contentType != "" {
+		h.Set("Content-Type", o.contentType)
+	}
+	for k, v := range o.metadata {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" && strings.Trim(m, `"`) == strings.Trim(o.etag, `"`) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, status := o.data, http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" {
+		start, end, ok := parseRange(spec, int64(len(o.data)))
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(o.data)))
+			s.error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		data, status = o.data[start:end+1], http.StatusPartialContent
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(o.data)))
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// parseRange parses a single bytes=start-end, start- or -suffix range of
+// an object of size bytes
+func parseRange(spec string, size int64) (start, end int64, ok bool) {
+	spec, ok = strings.CutPrefix(spec, "bytes=")
+	from, to, found := strings.Cut(spec, "-")
+	if !ok || !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	if from == "" {
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if to != "" {
+		if end, err = strconv.ParseInt(to, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+type listedObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	after := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		after = token
+	}
+	maxKeys := 1000
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n > 0 && n < maxKeys {
+		maxKeys = n
+	}
+
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []listedObject
+		CommonPrefixes        []commonPrefix
+	}{Name: s.bucket, Prefix: prefix, MaxKeys: maxKeys}
+	seen := make(map[string]bool)
+	last := ""
+	for _, k := range keys {
+		if result.KeyCount == maxKeys {
+			result.IsTruncated, result.NextContinuationToken = true, last
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+					result.KeyCount++
+				}
+				last = k
+				continue
+			}
+		}
+		o := s.objects[k]
+		result.Contents = append(result.Contents, listedObject{Key: k, LastModified: o.modified.Format(timeFormat), ETag: o.etag, Size: int64(len(o.data)), StorageClass: "STANDARD"})
+		result.KeyCount++
+		last = k
+	}
+	writeXML(w, result)
+}
+
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request, key string) {
+	s.nextID++
+	id := fmt.Sprintf("upload-%d", s.nextID)
+	s.uploads[id] = &upload{key: key, contentType: r.Header.Get("Content-Type"), metadata: metadata(r.Header), initiated: s.now(), parts: make(map[int][]byte)}
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: s.bucket, Key: key, UploadId: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if !ok || err != nil {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	data, err := readBody(r)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	u.parts[n] = data
+	sum := md5.Sum(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, key string) {
+	id := r.URL.Query().Get("uploadId")
+	u, ok := s.uploads[id]
+	if !ok || u.key != key {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		s.error(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	sums := md5.New()
+	for _, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok {
+			s.error(w, r, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, part...)
+		sum := md5.Sum(part)
+		sums.Write(sum[:])
+	}
+	o := &object{
+		data:        data,
+		contentType: u.contentType,
+		metadata:    u.metadata,
+		etag:        fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sums.Sum(nil)), len(req.Parts)),
+		modified:    s.now(),
+	}
+	s.objects[key] = o
+	delete(s.uploads, id)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: s.bucket, Key: key, ETag: o.etag})
+}
+
+func (s *Server) listUploads(w http.ResponseWriter) {
+	type listedUpload struct {
+		Key       string
+		UploadId  string
+		Initiated string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket      string
+		IsTruncated bool
+		Upload      []listedUpload
+	}{Bucket: s.bucket}
+	for id, u := range s.uploads {
+		result.Upload = append(result.Upload, listedUpload{Key: u.key, UploadId: id, Initiated: u.initiated.Format(timeFormat)})
+	}
+	sort.Slice(result.Upload, func(i, j int) bool { return result.Upload[i].UploadId < result.Upload[j].UploadId })
+	writeXML(w, result)
+}
+
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, key string) {
+	u, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	if !ok || u.key != key {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	type listedPart struct {
+		PartNumber int
+		ETag       string
+		Size       int64
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		Bucket      string
+		Key         string
+		UploadId    string
+		IsTruncated bool
+		Part        []listedPart
+	}{Bucket: s.bucket, Key: key, UploadId: r.URL.Query().Get("uploadId")}
+	for n, data := range u.parts {
+		sum := md5.Sum(data)
+		result.Part = append(result.Part, listedPart{PartNumber: n, ETag: `"` + hex.EncodeToString(sum[:]) + `"`, Size: int64(len(data))})
+	}
+	sort.Slice(result.Part, func(i, j int) bool { return result.Part[i].PartNumber < result.Part[j].PartNumber })
+	writeXML(w, result)
+}
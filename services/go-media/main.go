@@ -2,93 +2,498 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/WomB0ComB0/cdn/services/go-media/analytics"
+	"github.com/WomB0ComB0/cdn/services/go-media/audio"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/bucketevents"
+	"github.com/WomB0ComB0/cdn/services/go-media/collections"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/deploys"
+	"github.com/WomB0ComB0/cdn/services/go-media/documents"
+	"github.com/WomB0ComB0/cdn/services/go-media/domains"
+	"github.com/WomB0ComB0/cdn/services/go-media/envelope"
+	"github.com/WomB0ComB0/cdn/services/go-media/events"
+	"github.com/WomB0ComB0/cdn/services/go-media/grpcapi"
 	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/headerrules"
+	"github.com/WomB0ComB0/cdn/services/go-media/imaging"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
 	"github.com/WomB0ComB0/cdn/services/go-media/middleware"
+	"github.com/WomB0ComB0/cdn/services/go-media/offload"
+	"github.com/WomB0ComB0/cdn/services/go-media/redirects"
+	"github.com/WomB0ComB0/cdn/services/go-media/reporting"
+	"github.com/WomB0ComB0/cdn/services/go-media/s3api"
+	"github.com/WomB0ComB0/cdn/services/go-media/scheduler"
+	"github.com/WomB0ComB0/cdn/services/go-media/selfcheck"
 	"github.com/WomB0ComB0/cdn/services/go-media/storage"
-	"github.com/gorilla/mux"
+	"github.com/WomB0ComB0/cdn/services/go-media/workpool"
 )
 
 func main() {
-	port := getEnv("PORT", "8080")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML, TOML or JSON config file")
+	flag.Parse()
+
+	// Load and validate configuration (file + environment overrides)
+	cfgStore, err := config.NewStore(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	cfg := cfgStore.Current()
+
+	// Initialize error reporting (disabled when no DSN is configured)
+	reporter, err := reporting.New(reporting.Config{
+		DSN:         cfg.Sentry.DSN,
+		Environment: cfg.Sentry.Environment,
+		Release:     cfg.AppVersion,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize error reporter: %v", err)
+	}
 
 	// Initialize R2 storage client
 	r2Client, err := storage.NewR2Client(storage.R2Config{
-		AccountID:       os.Getenv("R2_ACCOUNT_ID"),
-		AccessKeyID:     os.Getenv("R2_ACCESS_KEY_ID"),
-		SecretAccessKey: os.Getenv("R2_SECRET_ACCESS_KEY"),
-		BucketName:      os.Getenv("R2_BUCKET_NAME"),
-		Endpoint:        os.Getenv("R2_ENDPOINT"),
+		AccountID:       cfg.R2.AccountID,
+		AccessKeyID:     cfg.R2.AccessKeyID,
+		SecretAccessKey: cfg.R2.SecretAccessKey,
+		BucketName:      cfg.R2.BucketName,
+		Endpoint:        cfg.R2.Endpoint,
 	})
 	if err != nil {
 		log.Fatalf("Failed to initialize R2 client: %v", err)
 	}
 
-	// Initialize handlers
-	mediaHandler := handlers.NewMediaHandler(r2Client, os.Getenv("SIGNING_SECRET"))
+	// Metrics served at /v1/admin/metrics, from the first request to R2
+	metricsRegistry := metrics.NewRegistry()
+	r2Client.SetMetrics(metricsRegistry)
 
-	// Setup router
-	router := mux.NewRouter()
+	// Verify storage access and credentials before accepting traffic
+	if cfg.StartupCheck != "off" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := selfcheck.Run(ctx,
+			selfcheck.BucketAccess(r2Client.HeadBucket),
+			selfcheck.SigningSecret(cfg.SigningSecret),
+			selfcheck.CloudflareCredentials(cfg.Cloudflare.ZoneID, cfg.Cloudflare.APIToken),
+		)
+		cancel()
+		switch {
+		case err != nil && cfg.StartupCheck == "strict":
+			log.Fatal(err)
+		case err != nil:
+			log.Printf("Continuing despite failed checks (STARTUP_CHECK=warn): %v", err)
+		default:
+			log.Println("Startup self-check passed")
+		}
+	}
 
-	// Apply middleware
-	router.Use(middleware.Logger)
-	router.Use(middleware.Recovery)
-	router.Use(middleware.SecurityHeaders)
+	// Load the metadata index
+	idx, err := index.Open(cfg.Data.IndexPath)
+	if err != nil {
+		log.Fatalf("Failed to open metadata index: %v", err)
+	}
 
-	// Rate limiting for uploads (10 requests per minute)
-	uploadRateLimiter := middleware.NewRateLimiter(10, 20)
+	// Named sets of assets, such as galleries
+	collectionStore, err := collections.Open(cfg.Data.CollectionsPath)
+	if err != nil {
+		log.Fatalf("Failed to open collections: %v", err)
+	}
 
-	// Health checks
-	router.HandleFunc("/health", handlers.HealthCheck).Methods("GET")
-	router.HandleFunc("/health/detailed", handlers.HealthCheckDetailed(r2Client)).Methods("GET")
+	// Static site deploys and which one is live
+	deployStore, err := deploys.Open(cfg.Data.DeploysPath)
+	if err != nil {
+		log.Fatalf("Failed to open deploys: %v", err)
+	}
 
-	// Media routes (under /v1/media)
-	api := router.PathPrefix("/v1/media").Subrouter()
+	// Custom domains serving a prefix of the bucket
+	domainStore, err := domains.Open(cfg.Data.DomainsPath)
+	if err != nil {
+		log.Fatalf("Failed to open domains: %v", err)
+	}
 
-	// Upload endpoints (with rate limiting)
-	uploadRouter := api.PathPrefix("/upload").Subrouter()
-	uploadRouter.Use(uploadRateLimiter.Middleware)
-	uploadRouter.HandleFunc("", mediaHandler.Upload).Methods("POST")
-	uploadRouter.HandleFunc("/multipart", mediaHandler.MultipartUpload).Methods("POST")
+	// Background workers are stopped when the server shuts down
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	var bgWorkers sync.WaitGroup
 
-	// Asset serving with ETag and Range support
-	api.HandleFunc("/assets/{path:.+}", mediaHandler.ServeAsset).Methods("GET", "HEAD")
+	// Monthly storage, egress and R2 operation accounting
+	usage, err := analytics.OpenUsage(cfg.Data.UsagePath)
+	if err != nil {
+		log.Fatalf("Failed to open usage history: %v", err)
+	}
+	r2Client.SetOperationHook(usage.RecordOperation)
 
-	// Signed URL generation
-	api.HandleFunc("/sign", mediaHandler.GenerateSignedURL).Methods("POST")
+	// Per-asset analytics, flushed to the index every 30 seconds
+	tracker := analytics.NewTracker(idx, usage)
+	bgWorkers.Add(1)
+	go func() {
+		defer bgWorkers.Done()
+		tracker.Run(bgCtx, 30*time.Second)
+	}()
 
-	// Private asset serving (requires signature validation)
-	api.HandleFunc("/private/{path:.+}", mediaHandler.ServePrivateAsset).Methods("GET", "HEAD")
+	// Append-only audit log of mutating operations
+	auditLog, err := audit.Open(cfg.Data.AuditLogPath)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer auditLog.Close()
+	if cfg.Data.AuditShipToBucket {
+		auditLog.ShipTo(r2Client, "logs/audit")
+	}
+	bgWorkers.Add(1)
+	go func() {
+		defer bgWorkers.Done()
+		auditLog.Run(bgCtx, time.Minute)
+	}()
+
+	// Asset lifecycle events, delivered to webhooks when configured
+	bus := events.NewBus()
+	var webhooks *events.WebhookDispatcher
+	if endpoints := webhookEndpoints(cfg.Webhooks); len(endpoints) > 0 {
+		webhooks = events.NewWebhookDispatcher(endpoints, 4)
+		bus.Add(webhooks)
+	}
+
+	// Optionally also publish events to NATS or Kafka
+	var broker *events.BrokerSink
+	if cfg.Events.Broker != "" {
+		broker, err = events.NewBrokerSink(events.BrokerConfig{
+			Kind:     cfg.Events.Broker,
+			URL:      cfg.Events.URL,
+			Topic:    cfg.Events.Topic,
+			Username: cfg.Events.Username,
+			Password: cfg.Events.Password,
+			SASL:     cfg.Events.SASL,
+			TLS:      cfg.Events.TLS,
+			CAFile:   cfg.Events.CAFile,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure event broker: %v", err)
+		}
+		bus.Add(broker)
+	}
+
+	// Envelope encryption for the secure prefix, with the master keys
+	// held in memory
+	keyring, err := envelope.NewKeyring(cfg.Encryption.MasterKeys)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	var sealer *envelope.Sealer
+	if keyring != nil {
+		sealer = envelope.New(keyring)
+	}
+
+	// Images and videos offloaded to Cloudflare Images and Stream. Videos
+	// are polled until transcoded, then announced with an event.
+	offloader := offload.New(offload.Config{
+		AccountID:     cfg.R2.AccountID,
+		APIToken:      cfg.Cloudflare.APIToken,
+		Images:        cfg.Cloudflare.OffloadImages,
+		ImagesVariant: cfg.Cloudflare.ImagesVariant,
+		Stream:        cfg.Cloudflare.OffloadVideos,
+	})
+	if offloader != nil {
+		bgWorkers.Add(1)
+		go func() {
+			defer bgWorkers.Done()
+			offloader.Run(bgCtx, 15*time.Second, func(key string, a offload.Asset) {
+				bus.Publish(events.New(events.AssetOffloaded, map[string]interface{}{"key": key, "offload": a}))
+			})
+		}()
+	}
+
+	// Background jobs, scheduled once the handlers exist
+	jobScheduler := scheduler.New()
 
-	// Cache purge endpoint
-	api.HandleFunc("/purge", mediaHandler.PurgeCache).Methods("POST")
+	// Variant builds run a bounded number at a time
+	transformPool := workpool.New(cfg.Transforms.Workers, cfg.Transforms.Queue,
+		time.Duration(cfg.Transforms.QueueWaitSeconds)*time.Second, metricsRegistry)
 
-	// List assets
-	api.HandleFunc("/list", mediaHandler.ListAssets).Methods("GET")
+	// Image variants, unless left to imgproxy
+	var imageEngine imaging.Engine
+	if cfg.Images.Engine != "off" {
+		if imageEngine, err = imaging.New(cfg.Images.Engine, imaging.Settings{
+			Color:     imaging.Color(cfg.Images.Color),
+			AutoFocus: cfg.Images.AutoFocus,
+		}); err != nil {
+			log.Fatalf("Failed to start image engine: %v", err)
+		}
+		log.Printf("Image engine: %s", imageEngine.Name())
+	}
 
-	// Delete asset
-	api.HandleFunc("/delete/{path:.+}", mediaHandler.DeleteAsset).Methods("DELETE")
+	// HLS transcoding of video uploads, which needs ffmpeg installed
+	if cfg.Video.Transcode {
+		for _, bin := range []string{cfg.Video.FFmpegPath, cfg.Video.FFprobePath} {
+			if _, err := exec.LookPath(bin); err != nil {
+				log.Fatalf("Video transcoding needs %s: %v", bin, err)
+			}
+		}
+		log.Printf("Video transcoding: default preset %s, %d at a time", cfg.Video.DefaultPreset, cfg.Video.Workers)
+	}
+	var audioConverter *audio.Converter
+	if cfg.Audio.Convert {
+		if _, err := exec.LookPath(cfg.Video.FFmpegPath); err != nil {
+			log.Fatalf("Audio conversion needs %s: %v", cfg.Video.FFmpegPath, err)
+		}
+		audioConverter = &audio.Converter{FFmpeg: cfg.Video.FFmpegPath, Loudness: cfg.Audio.LoudnessLUFS}
+		log.Printf("Audio conversion: normalizing to %d LUFS", cfg.Audio.LoudnessLUFS)
+	}
+	var documentConverter documents.Converter
+	if cfg.Documents.Converter != "" {
+		documentConverter, err = documents.New(cfg.Documents.Converter, documents.Settings{
+			LibreOffice: cfg.Documents.LibreOfficePath,
+			URL:         cfg.Documents.ConverterURL,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open document converter: %v", err)
+		}
+		log.Printf("Document previews: converting to PDF with %s", documentConverter.Name())
+	}
 
-	// Create server
+	textLimit := 0
+	if cfg.Search.ExtractText {
+		textLimit = cfg.Search.MaxTextBytes
+		log.Printf("Search: indexing up to %d bytes of text per asset", textLimit)
+	}
+
+	// Initialize handlers
+	mediaHandler := handlers.NewMediaHandler(r2Client, cfg.SigningSecret,
+		handlers.WithCloudflare(cfg.Cloudflare.ZoneID, cfg.Cloudflare.APIToken),
+		handlers.WithMaxUploadSize(int64(cfg.Server.Limits.MaxUploadBytes)),
+		handlers.WithParallelDownloads(cfg.Downloads),
+		handlers.WithOffload(offloader),
+		handlers.WithEncryption(sealer, cfg.Encryption.Prefix),
+		handlers.WithUploadTokens(cfg.Uploads.RequireToken),
+		handlers.WithTrash(cfg.Trash.Enabled),
+		handlers.WithTrashRetention(cfg.Trash.RetentionDays),
+		handlers.WithLifecycle(cfg.Lifecycle),
+		handlers.WithReadOnly(cfg.Server.ReadOnly),
+		handlers.WithKeyStrategy(handlers.KeyStrategy(cfg.Uploads.KeyStrategy)),
+		handlers.WithMaxChunkedSize(int64(cfg.Uploads.MaxChunkedBytes)),
+		handlers.WithParallelUploads(int64(cfg.Uploads.PartBytes), cfg.Uploads.Concurrency),
+		handlers.WithHTML(cfg.HTML),
+		handlers.WithPublicURLs(cfg.URLs),
+		handlers.WithRegions(cfg.Regions),
+		handlers.WithWellKnown(cfg.WellKnown.Files),
+		handlers.WithCacheConfig(func() config.CacheConfig { return cfgStore.Current().Cache }),
+		handlers.WithListingConfig(func() config.ListingConfig { return cfgStore.Current().Listing }),
+		handlers.WithReporter(reporter),
+		handlers.WithIndex(idx),
+		handlers.WithCollections(collectionStore),
+		handlers.WithDeploys(deployStore),
+		handlers.WithDomains(domainStore),
+		handlers.WithAnalytics(tracker),
+		handlers.WithUsage(usage),
+		handlers.WithAuditLog(auditLog),
+		handlers.WithEvents(bus),
+		handlers.WithWebhooks(webhooks),
+		handlers.WithScheduler(jobScheduler),
+		handlers.WithTransformPool(transformPool),
+		handlers.WithImageEngine(imageEngine),
+		handlers.WithImageLimits(imaging.Limits{
+			MaxWidth:  cfg.Images.MaxWidth,
+			MaxHeight: cfg.Images.MaxHeight,
+			MaxPixels: cfg.Images.MaxPixels,
+			Formats:   cfg.Images.Formats,
+			Qualities: cfg.Images.Qualities,
+		}),
+		handlers.WithImageSignatures(cfg.Images.RequireSignature),
+		handlers.WithVideo(cfg.Video),
+		handlers.WithAudio(audioConverter),
+		handlers.WithDocumentConverter(documentConverter),
+		handlers.WithTextExtraction(textLimit),
+	)
+
+	// Objects written to the bucket directly, by other systems, are indexed
+	// and announced from R2's event notifications
+	if consumer := bucketevents.NewConsumer(bucketevents.Config{
+		AccountID: cfg.R2.AccountID,
+		APIToken:  cfg.Cloudflare.APIToken,
+		QueueID:   cfg.BucketEvents.QueueID,
+		Bucket:    cfg.R2.BucketName,
+	}, mediaHandler.ApplyBucketNotification); consumer != nil {
+		bgWorkers.Add(1)
+		go func() {
+			defer bgWorkers.Done()
+			consumer.Run(bgCtx)
+		}()
+	}
+
+	// Uploads are refused once shutdown starts; in-flight ones may finish
+	uploadDrainer := middleware.NewDrainer()
+
+	// Rate limiting for uploads (reloadable)
+	uploadRateLimiter := middleware.NewRateLimiter(cfg.RateLimit.UploadPerMinute, cfg.RateLimit.UploadBurst)
+	cfgStore.OnReload(func(c *config.Config) {
+		uploadRateLimiter.SetLimits(c.RateLimit.UploadPerMinute, c.RateLimit.UploadBurst)
+	})
+
+	// Maintenance jobs on cron schedules
+	jobs := cfg.Jobs.Schedules()
+	if cfg.Server.ReadOnly {
+		// Leave bucket housekeeping to the writer instance
+		delete(jobs, "multipart_gc")
+		delete(jobs, "inventory")
+		delete(jobs, "publish")
+		delete(jobs, "lifecycle")
+		delete(jobs, "trash_purge")
+		log.Println("Read-only replica: uploads, deletes and purges are disabled")
+	}
+	for name, fn := range map[string]scheduler.Job{
+		"rate_limit_cleanup": func(context.Context) error {
+			uploadRateLimiter.Cleanup()
+			return nil
+		},
+		"reconcile":       mediaHandler.ReconcileJob,
+		"multipart_gc":    mediaHandler.AbortStaleUploads,
+		"integrity_audit": mediaHandler.AuditIntegrity,
+		"inventory":       mediaHandler.WriteInventory,
+		"publish":         mediaHandler.PublishScheduled,
+		"lifecycle":       mediaHandler.ExpireObjects,
+		"trash_purge":     mediaHandler.PurgeTrash,
+	} {
+		if err := jobScheduler.Add(name, jobs[name], fn); err != nil {
+			log.Fatalf("Failed to schedule %s: %v", name, err)
+		}
+	}
+	bgWorkers.Add(1)
+	go func() {
+		defer bgWorkers.Done()
+		jobScheduler.Run(bgCtx)
+	}()
+
+	// Redirect and rewrite rules for public assets (reloadable)
+	redirectTable, err := redirects.Load(cfg.Redirects)
+	if err != nil {
+		log.Fatalf("Failed to load redirect rules: %v", err)
+	}
+	rewriter := redirects.NewRewriter(redirectTable)
+	cfgStore.OnReload(func(c *config.Config) {
+		table, err := redirects.Load(c.Redirects)
+		if err != nil {
+			log.Printf("Redirect rules not reloaded: %v", err)
+			return
+		}
+		rewriter.SetTable(table)
+	})
+
+	// Extra response headers for assets (reloadable)
+	headerTable, err := headerrules.Load(cfg.Headers)
+	if err != nil {
+		log.Fatalf("Failed to load header rules: %v", err)
+	}
+	headerInjector := headerrules.NewInjector(headerTable)
+	cfgStore.OnReload(func(c *config.Config) {
+		table, err := headerrules.Load(c.Headers)
+		if err != nil {
+			log.Printf("Header rules not reloaded: %v", err)
+			return
+		}
+		headerInjector.SetTable(table)
+	})
+
+	// Readiness fails while R2 is unreachable or the server is draining
+	probes := handlers.NewProbes(cfg.AppVersion, r2Client.HeadBucket)
+
+	// Load shedding by priority class, shared by the HTTP and S3 APIs
+	qos := middleware.NewLimiter(cfg.QoS.MaxConcurrent, cfg.QoS.StandardPercent, cfg.QoS.BulkPercent)
+	delivery := middleware.NewDelivery(metricsRegistry, time.Duration(cfg.Metrics.StallSeconds)*time.Second)
+	var chaos *middleware.Chaos
+	if cfg.Chaos.Enabled {
+		rules := make([]middleware.ChaosRule, len(cfg.Chaos.Rules))
+		for i, rule := range cfg.Chaos.Rules {
+			rules[i] = middleware.ChaosRule{
+				Prefix:       rule.Prefix,
+				Methods:      rule.Methods,
+				Latency:      time.Duration(rule.LatencyMS) * time.Millisecond,
+				Jitter:       time.Duration(rule.JitterMS) * time.Millisecond,
+				ErrorPercent: rule.ErrorPercent,
+				ErrorStatus:  rule.ErrorStatus,
+			}
+		}
+		chaos = middleware.NewChaos(metricsRegistry, rules)
+		log.Printf("Fault injection enabled (CHAOS_ENABLED) with %d rules; don't run this in production", len(rules))
+	}
+	mirror := middleware.NewMirror(metricsRegistry, cfg.Mirror.URL, cfg.Mirror.Percent, cfg.Mirror.Concurrency, time.Duration(cfg.Mirror.TimeoutSeconds)*time.Second)
+
+	handler := newHandler(routeDeps{
+		cfg:         cfg,
+		media:       mediaHandler,
+		probes:      probes,
+		auditLog:    auditLog,
+		reporter:    reporter,
+		drainer:     uploadDrainer,
+		uploadLimit: uploadRateLimiter.Middleware,
+		redirects:   rewriter,
+		headers:     headerInjector,
+		qos:         qos,
+		metrics:     metricsRegistry,
+		delivery:    delivery,
+		mirror:      mirror,
+		chaos:       chaos,
+	})
+
+	// Create server. Server-wide timeouts apply to routes without their own;
+	// the write timeout leaves room for the JSON API timeout response.
+	timeouts := cfg.Server.Timeouts
+	apiTimeout := time.Duration(timeouts.APISeconds) * time.Second
 	srv := &http.Server{
-		Addr:         ":" + port,
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(timeouts.ReadHeaderSeconds) * time.Second,
+		ReadTimeout:       apiTimeout,
+		WriteTimeout:      apiTimeout + 5*time.Second,
+		IdleTimeout:       time.Duration(timeouts.IdleSeconds) * time.Second,
+		MaxHeaderBytes:    cfg.Server.Limits.MaxHeaderBytes,
 	}
 
-	// Start server in goroutine
+	// Startup work is done once the server is constructed
+	probes.MarkWarm()
+
+	// Start serving (with TLS when configured)
+	servers, h3 := startServers(srv, cfg.Server)
+
+	// gRPC API for internal services (optional, separate port)
+	grpcSrv := startGRPC(cfg, grpcapi.NewServer(mediaHandler,
+		grpcapi.WithAuditLog(auditLog),
+		grpcapi.WithReporter(reporter),
+		grpcapi.WithMaxUploadSize(int64(cfg.Server.Limits.MaxUploadBytes)),
+		grpcapi.WithReadOnly(cfg.Server.ReadOnly),
+	))
+
+	// S3-compatible API for rclone and the AWS CLI (optional, separate
+	// port). Its requests are drained with uploads on shutdown.
+	s3Srv := startS3(cfg, uploadDrainer.Middleware(s3api.NewServer(mediaHandler, cfg.S3.Bucket, s3Keys(cfg.S3),
+		s3api.WithIndex(idx),
+		s3api.WithAuditLog(auditLog),
+		s3api.WithReporter(reporter),
+		s3api.WithLimiter(qos),
+		s3api.WithMaxUploadSize(int64(cfg.Server.Limits.MaxUploadBytes)),
+		s3api.WithReadOnly(cfg.Server.ReadOnly),
+	)))
+	if s3Srv != nil {
+		servers = append(servers, s3Srv)
+	}
+
+	// Reload non-structural settings on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		log.Printf("Starting server on port %s", port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+		for range reload {
+			if err := cfgStore.Reload(); err != nil {
+				log.Printf("Config reload failed, keeping current settings: %v", err)
+				continue
+			}
+			log.Println("Configuration reloaded")
 		}
 	}()
 
@@ -98,19 +503,63 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+
+	// Fail readiness and let in-flight uploads finish before closing
+	// connections
+	probes.SetDraining(true)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(cfg.Server.DrainTimeoutSeconds)*time.Second)
+	if err := uploadDrainer.Drain(drainCtx); err != nil {
+		log.Printf("Upload drain incomplete: %v", err)
+	}
+	cancelDrain()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	// Connections still open when ctx expires, such as long asset
+	// streams, are cut; the rest of the shutdown still runs
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Server forced to shutdown: %v", err)
+			s.Close()
+		}
+	}
+	if h3 != nil {
+		h3.Close()
+	}
+	if grpcSrv != nil {
+		stopGRPC(ctx, grpcSrv)
+	}
+
+	// Flush analytics, persist the index and ship pending audit records
+	stopBackground()
+	bgWorkers.Wait()
+
+	if webhooks != nil {
+		webhooks.Close()
+	}
+	if broker != nil {
+		broker.Close()
 	}
 
+	reporter.Close(5 * time.Second)
+
 	log.Println("Server exited")
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// webhookEndpoints combines the shared-secret URL list with individually
+// configured endpoints
+func webhookEndpoints(cfg config.WebhookConfig) []events.Endpoint {
+	var endpoints []events.Endpoint
+	for _, url := range cfg.URLs {
+		endpoints = append(endpoints, events.Endpoint{URL: url, Secret: cfg.Secret})
+	}
+	for _, ep := range cfg.Endpoints {
+		secret := ep.Secret
+		if secret == "" {
+			secret = cfg.Secret
+		}
+		endpoints = append(endpoints, events.Endpoint{URL: ep.URL, Secret: secret, Events: ep.Events})
 	}
-	return defaultValue
+	return endpoints
 }
@@ -0,0 +1,299 @@
+// Package metrics keeps counters and histograms, alone or in families
+// told apart by labels, and serves them in the Prometheus text exposition
+// format. A nil *Registry, and the metrics
+// it returns, record nothing.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds the metrics served by ServeHTTP, in the order they were
+// created
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// Counter returns a new counter named name
+func (r *Registry) Counter(name, help string) *Counter {
+	if r == nil {
+		return nil
+	}
+	c := &Counter{name: name, help: help}
+	r.add(c)
+	return c
+}
+
+// Histogram returns a new histogram named name counting observations in
+// buckets with the given ascending upper bounds
+func (r *Registry) Histogram(name, help string, bounds []float64) *Histogram {
+	if r == nil {
+		return nil
+	}
+	h := &Histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	r.add(h)
+	return h
+}
+
+// CounterVec returns a new family of counters named name, told apart by
+// the values of labels
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	if r == nil {
+		return nil
+	}
+	v := &CounterVec{vec: vec{name: name, help: help, kind: "counter", labels: labels}}
+	v.newChild = func(labels string) child { return &Counter{name: name, labels: labels} }
+	r.add(v)
+	return v
+}
+
+// HistogramVec returns a new family of histograms named name, told apart
+// by the values of labels, each with the given bucket bounds
+func (r *Registry) HistogramVec(name, help string, bounds []float64, labels ...string) *HistogramVec {
+	if r == nil {
+		return nil
+	}
+	v := &HistogramVec{vec: vec{name: name, help: help, kind: "histogram", labels: labels}}
+	v.newChild = func(labels string) child {
+		return &Histogram{name: name, labels: labels, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	}
+	r.add(v)
+	return v
+}
+
+// ServeHTTP writes every metric in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	name, help string
+	labels     string        // formatted, for a member of a CounterVec
+	bits       atomic.Uint64 // float64 bits
+}
+
+// Add adds v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if c == nil {
+		return
+	}
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Inc adds 1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the count so far
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.writeSamples(w)
+}
+
+func (c *Counter) writeSamples(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %s\n", c.name, braced(c.labels), formatFloat(c.Value()))
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	name, help string
+	labels     string // formatted, for a member of a HistogramVec
+	bounds     []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, the last for values above every bound
+	sum    float64
+}
+
+// Observe records v
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.writeSamples(w)
+}
+
+func (h *Histogram) writeSamples(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum := h.sum
+	h.mu.Unlock()
+
+	le := ""
+	if h.labels != "" {
+		le = h.labels + ","
+	}
+	var total uint64
+	for i, bound := range h.bounds {
+		total += counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, le, formatFloat(bound), total)
+	}
+	total += counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n%s_sum%s %s\n%s_count%s %d\n",
+		h.name, le, total, h.name, braced(h.labels), formatFloat(sum), h.name, braced(h.labels), total)
+}
+
+// child is a member of a vec
+type child interface {
+	writeSamples(w io.Writer)
+}
+
+// vec is a family of metrics of one kind, created on first use of each
+// combination of label values
+type vec struct {
+	name, help, kind string
+	labels           []string
+	newChild         func(labels string) child
+
+	mu       sync.Mutex
+	children map[string]child // by formatted labels
+}
+
+func (v *vec) with(values []string) child {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + `="` + labelEscaper.Replace(value) + `"`
+	}
+	labels := strings.Join(pairs, ",")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[labels]
+	if !ok {
+		if v.children == nil {
+			v.children = map[string]child{}
+		}
+		c = v.newChild(labels)
+		v.children[labels] = c
+	}
+	return c
+}
+
+// write writes the members in order of their labels, so the exposition
+// is stable
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	children := make([]child, len(keys))
+	for i, k := range keys {
+		children[i] = v.children[k]
+	}
+	v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	for _, c := range children {
+		c.writeSamples(w)
+	}
+}
+
+// CounterVec is a family of counters with the same labels
+type CounterVec struct {
+	vec
+}
+
+// With returns the counter for values, one for each label in order
+func (v *CounterVec) With(values ...string) *Counter {
+	if v == nil {
+		return nil
+	}
+	return v.with(values).(*Counter)
+}
+
+// HistogramVec is a family of histograms with the same labels and buckets
+type HistogramVec struct {
+	vec
+}
+
+// With returns the histogram for values, one for each label in order
+func (v *HistogramVec) With(values ...string) *Histogram {
+	if v == nil {
+		return nil
+	}
+	return v.with(values).(*Histogram)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// braced returns labels in braces, or nothing when there are none
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
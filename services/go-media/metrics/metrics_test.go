@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	latency := r.Histogram("request_seconds", "Request latency", []float64{0.1, 1})
+	errors := r.Counter("errors_total", "Failed requests")
+
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.Observe(v)
+	}
+	errors.Inc()
+	errors.Add(2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP request_seconds Request latency
+# TYPE request_seconds histogram
+request_seconds_bucket{le="0.1"} 2
+request_seconds_bucket{le="1"} 3
+request_seconds_bucket{le="+Inf"} 4
+request_seconds_sum 3.65
+request_seconds_count 4
+# HELP errors_total Failed requests
+# TYPE errors_total counter
+errors_total 3
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	r.Counter("c", "").Inc()
+	r.Histogram("h", "", []float64{1}).Observe(2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Body.Len() != 0 {
+		t.Errorf("nil registry served %q", w.Body)
+	}
+}
+
+func TestVecExposition(t *testing.T) {
+	r := NewRegistry()
+	ops := r.CounterVec("ops_total", "Operations", "op", "result")
+	latency := r.HistogramVec("op_seconds", "Operation latency", []float64{1}, "op")
+
+	ops.With("Put", "ok").Inc()
+	ops.With("Get", "ok").Add(2)
+	ops.With("Get", `say "hi"`).Inc()
+	ops.With("Get", "ok").Inc()
+	latency.With("Get").Observe(0.5)
+	latency.With("Get").Observe(2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP ops_total Operations
+# TYPE ops_total counter
+ops_total{op="Get",result="ok"} 3
+ops_total{op="Get",result="say \"hi\""} 1
+ops_total{op="Put",result="ok"} 1
+# HELP op_seconds Operation latency
+# TYPE op_seconds histogram
+op_seconds_bucket{op="Get",le="1"} 1
+op_seconds_bucket{op="Get",le="+Inf"} 2
+op_seconds_sum{op="Get"} 2.5
+op_seconds_count{op="Get"} 2
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+
+	var nilReg *Registry
+	nilReg.CounterVec("c", "", "l").With("v").Inc()
+	nilReg.HistogramVec("h", "", []float64{1}, "l").With("v").Observe(1)
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// AdminAuth requires an "Authorization: Bearer <token>" header matching
+// token. An empty token disables the protected routes entirely rather
+// than leaving them open.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				apierror.Write(w, http.StatusForbidden, apierror.AdminDisabled, "Admin API disabled")
+				return
+			}
+
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Unauthorized")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BasicAuth requires HTTP Basic credentials matching username and
+// password, for clients such as WebDAV mounts that can't send bearer
+// tokens. An empty password disables the protected routes.
+func BasicAuth(realm, username, password string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if password == "" {
+				apierror.Write(w, http.StatusForbidden, apierror.NotEnabled, "Disabled")
+				return
+			}
+
+			user, pass, _ := r.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+			if !userOK || !passOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Unauthorized")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
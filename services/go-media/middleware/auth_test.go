@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "valid token", token: "secret", header: "Bearer secret", want: http.StatusOK},
+		{name: "wrong token", token: "secret", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "missing header", token: "secret", header: "", want: http.StatusUnauthorized},
+		{name: "admin disabled", token: "", header: "Bearer ", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/admin/usage", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			AdminAuth(tt.token)(handler).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		password   string
+		user, pass string
+		want       int
+	}{
+		{name: "valid credentials", password: "secret", user: "designer", pass: "secret", want: http.StatusOK},
+		{name: "wrong password", password: "secret", user: "designer", pass: "nope", want: http.StatusUnauthorized},
+		{name: "wrong user", password: "secret", user: "admin", pass: "secret", want: http.StatusUnauthorized},
+		{name: "missing header", password: "secret", want: http.StatusUnauthorized},
+		{name: "disabled", password: "", user: "designer", pass: "", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			w := httptest.NewRecorder()
+
+			BasicAuth("cdn", "designer", tt.password)(handler).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+// ChaosHeader marks responses a fault was injected into, so tests can
+// tell injected failures from real ones
+const ChaosHeader = "X-CDN-Chaos"
+
+// ChaosRule delays the requests whose path starts with Prefix, with one
+// of Methods when given, by Latency plus up to Jitter, and fails
+// ErrorPercent of them with ErrorStatus
+type ChaosRule struct {
+	Prefix       string
+	Methods      []string
+	Latency      time.Duration
+	Jitter       time.Duration
+	ErrorPercent int
+	ErrorStatus  int
+}
+
+// Chaos injects faults into requests by rule, for exercising clients'
+// retries and circuit breakers against realistic failures in staging
+type Chaos struct {
+	rules  []ChaosRule
+	random func(n int) int // in [0, n)
+
+	delays *metrics.Counter
+	errors *metrics.Counter
+}
+
+// NewChaos injects faults by the first of rules matching each request,
+// and registers its metrics in reg. It returns nil, which injects
+// nothing, when there are no rules.
+func NewChaos(reg *metrics.Registry, rules []ChaosRule) *Chaos {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Chaos{
+		rules:  rules,
+		random: rand.Intn,
+		delays: reg.Counter("cdn_chaos_delays_total", "Requests delayed by fault injection"),
+		errors: reg.Counter("cdn_chaos_errors_total", "Requests failed by fault injection"),
+	}
+}
+
+// match returns the rule for r. Preflights are left alone, so browsers
+// see the faults themselves rather than CORS failures.
+func (c *Chaos) match(r *http.Request) (ChaosRule, bool) {
+	if r.Method == http.MethodOptions {
+		return ChaosRule{}, false
+	}
+	for _, rule := range c.rules {
+		if strings.HasPrefix(r.URL.Path, rule.Prefix) && (len(rule.Methods) == 0 || slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) })) {
+			return rule, true
+		}
+	}
+	return ChaosRule{}, false
+}
+
+// Middleware injects faults into the requests to next. Delays end early
+// when the client goes away. A failed request gets Retry-After when its
+// status invites a retry, as real overload would.
+func (c *Chaos) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := c.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay := rule.Latency
+		if rule.Jitter > 0 {
+			delay += time.Duration(c.random(int(rule.Jitter) + 1))
+		}
+		if delay > 0 {
+			c.delays.Inc()
+			w.Header().Set(ChaosHeader, "delay="+delay.Round(time.Millisecond).String())
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if rule.ErrorPercent > 0 && c.random(100) < rule.ErrorPercent {
+			c.errors.Inc()
+			status := rule.ErrorStatus
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			w.Header().Add(ChaosHeader, "error")
+			if status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			apierror.Write(w, status, apierror.InjectedFault, "Injected fault")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+func TestChaosInjectsFaults(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := NewChaos(reg, []ChaosRule{
+		{Prefix: "/v1/media/upload", Methods: []string{"post"}, ErrorPercent: 50, ErrorStatus: http.StatusBadGateway},
+		{Prefix: "/v1/media/", Latency: 20 * time.Millisecond, ErrorPercent: 10},
+	})
+	var roll int
+	c.random = func(n int) int { return roll % n }
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		method, path string
+		roll         int
+		status       int
+		delayed      bool
+	}{
+		{"POST", "/v1/media/upload", 49, http.StatusBadGateway, false},
+		{"POST", "/v1/media/upload", 50, http.StatusOK, false},
+		// The first rule is for POSTs only
+		{"GET", "/v1/media/upload", 9, http.StatusServiceUnavailable, true},
+		{"GET", "/v1/media/assets/a.png", 10, http.StatusOK, true},
+		{"OPTIONS", "/v1/media/assets/a.png", 0, http.StatusOK, false},
+		{"GET", "/healthz", 0, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		roll = tt.roll
+		w := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s at %d: status = %d, want %d", tt.method, tt.path, tt.roll, w.Code, tt.status)
+		}
+		if delayed := time.Since(start) >= 20*time.Millisecond; delayed != tt.delayed {
+			t.Errorf("%s %s: delayed = %v", tt.method, tt.path, delayed)
+		}
+		if injected := w.Header().Get(ChaosHeader) != ""; injected != (tt.delayed || w.Code != http.StatusOK) {
+			t.Errorf("%s %s: %s = %q", tt.method, tt.path, ChaosHeader, w.Header().Get(ChaosHeader))
+		}
+		if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %s: no Retry-After", tt.method, tt.path)
+		}
+	}
+
+	out := scrape(t, reg)
+	for _, want := range []string{"cdn_chaos_delays_total 2", "cdn_chaos_errors_total 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestChaosDelayEndsWithClient(t *testing.T) {
+	c := NewChaos(nil, []ChaosRule{{Prefix: "/", Latency: time.Minute}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	called := false
+	c.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a.png", nil).WithContext(ctx))
+	if called {
+		t.Error("handler ran after the client went away")
+	}
+}
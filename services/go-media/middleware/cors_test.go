@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	uploads := CORS(CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedMethods:   []string{"POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"ETag"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	})(next)
+	assets := CORS(CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD"},
+	})(next)
+
+	tests := []struct {
+		name        string
+		handler     http.Handler
+		method      string
+		origin      string
+		reqMethod   string
+		wantCode    int
+		wantOrigin  string
+		wantMethods string
+		wantMaxAge  string
+	}{
+		{name: "preflight allowed", handler: uploads, method: "OPTIONS", origin: "https://app.example.com", reqMethod: "POST",
+			wantCode: http.StatusNoContent, wantOrigin: "https://app.example.com", wantMethods: "POST", wantMaxAge: "600"},
+		{name: "preflight wildcard subdomain", handler: uploads, method: "OPTIONS", origin: "https://pr-1.preview.example.com", reqMethod: "POST",
+			wantCode: http.StatusNoContent, wantOrigin: "https://pr-1.preview.example.com", wantMethods: "POST", wantMaxAge: "600"},
+		{name: "preflight unknown origin", handler: uploads, method: "OPTIONS", origin: "https://evil.example", reqMethod: "POST",
+			wantCode: http.StatusNoContent},
+		{name: "preflight disallowed method", handler: uploads, method: "OPTIONS", origin: "https://app.example.com", reqMethod: "DELETE",
+			wantCode: http.StatusNoContent},
+		{name: "actual request", handler: uploads, method: "POST", origin: "https://app.example.com",
+			wantCode: http.StatusOK, wantOrigin: "https://app.example.com"},
+		{name: "same origin request", handler: uploads, method: "POST", wantCode: http.StatusOK},
+		{name: "plain options", handler: assets, method: "OPTIONS", wantCode: http.StatusNoContent},
+		{name: "public asset", handler: assets, method: "GET", origin: "https://anywhere.example",
+			wantCode: http.StatusOK, wantOrigin: "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/media/upload", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+			}
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+// rateMinBytes is the smallest response whose transfer rate is
+// recorded; smaller ones finish before the rate means anything
+const rateMinBytes = 1 << 20
+
+// Delivery measures how responses are delivered: time to first byte,
+// transfer rate, and where the time went once bytes were flowing. Time
+// blocked writing to the client is the client's network; time between
+// writes is the handler waiting on R2. A wait of either kind longer than
+// the stall threshold is logged and counted under its cause, so slow
+// clients aren't mistaken for R2 trouble or the other way round.
+type Delivery struct {
+	stall time.Duration
+
+	ttfb          *metrics.Histogram
+	rate          *metrics.Histogram
+	clientSeconds *metrics.Counter
+	originSeconds *metrics.Counter
+	clientStalls  *metrics.Counter
+	originStalls  *metrics.Counter
+}
+
+// NewDelivery registers the delivery metrics in reg. A wait longer than
+// stall counts as a stall.
+func NewDelivery(reg *metrics.Registry, stall time.Duration) *Delivery {
+	return &Delivery{
+		stall: stall,
+		ttfb: reg.Histogram("cdn_delivery_ttfb_seconds", "Time from request to the first body byte written",
+			[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}),
+		rate: reg.Histogram("cdn_delivery_rate_bytes_per_second", "Transfer rate of response bodies of 1 MiB or more, from first to last byte",
+			[]float64{64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20}),
+		clientSeconds: reg.Counter("cdn_delivery_client_write_seconds_total", "Time spent blocked writing response bodies to clients"),
+		originSeconds: reg.Counter("cdn_delivery_origin_wait_seconds_total", "Time spent between body writes, waiting on R2"),
+		clientStalls:  reg.Counter("cdn_delivery_client_stalls_total", "Responses with a write to the client blocked longer than the stall threshold"),
+		originStalls:  reg.Counter("cdn_delivery_origin_stalls_total", "Responses that waited on R2 longer than the stall threshold between writes"),
+	}
+}
+
+// Middleware measures the responses of next
+func (d *Delivery) Middleware(next http.Handler) http.Handler {
+	if d == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dw := &deliveryWriter{ResponseWriter: w, stall: d.stall, start: time.Now()}
+		next.ServeHTTP(dw, r)
+		d.record(r, dw)
+	})
+}
+
+func (d *Delivery) record(r *http.Request, dw *deliveryWriter) {
+	if dw.firstByte.IsZero() {
+		return
+	}
+	d.ttfb.Observe(dw.firstByte.Sub(dw.start).Seconds())
+
+	flowing := dw.lastWrite.Sub(dw.firstByte)
+	if dw.bytes >= rateMinBytes && flowing > 0 {
+		d.rate.Observe(float64(dw.bytes) / flowing.Seconds())
+	}
+	d.clientSeconds.Add(dw.writing.Seconds())
+	if waiting := flowing - dw.writing; waiting > 0 {
+		d.originSeconds.Add(waiting.Seconds())
+	}
+
+	if dw.clientStall > 0 {
+		d.clientStalls.Inc()
+		log.Printf("Slow client: %s %s blocked %s on one write (%dB sent in %s)",
+			r.Method, r.URL.Path, dw.clientStall.Round(time.Millisecond), dw.bytes, flowing.Round(time.Millisecond))
+	}
+	if dw.originStall > 0 {
+		d.originStalls.Inc()
+		log.Printf("Origin stall: %s %s waited %s for R2 between writes (%dB sent in %s)",
+			r.Method, r.URL.Path, dw.originStall.Round(time.Millisecond), dw.bytes, flowing.Round(time.Millisecond))
+	}
+}
+
+// deliveryWriter times the writes of a response
+type deliveryWriter struct {
+	http.ResponseWriter
+	stall time.Duration
+
+	start     time.Time
+	firstByte time.Time
+	lastWrite time.Time
+	bytes     int64
+	writing   time.Duration // blocked in Write
+
+	// The longest waits over the stall threshold
+	clientStall time.Duration
+	originStall time.Duration
+}
+
+func (dw *deliveryWriter) Write(b []byte) (int, error) {
+	before := time.Now()
+	if dw.firstByte.IsZero() {
+		dw.firstByte = before
+	} else if gap := before.Sub(dw.lastWrite); gap > dw.stall && gap > dw.originStall {
+		dw.originStall = gap
+	}
+
+	n, err := dw.ResponseWriter.Write(b)
+
+	dw.lastWrite = time.Now()
+	blocked := dw.lastWrite.Sub(before)
+	dw.writing += blocked
+	dw.bytes += int64(n)
+	if blocked > dw.stall && blocked > dw.clientStall {
+		dw.clientStall = blocked
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (dw *deliveryWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+// slowWriter is a client that takes delay to accept each write
+type slowWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseRecorder.Write(b)
+}
+
+func scrape(t *testing.T, reg *metrics.Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestDeliverySeparatesStalls(t *testing.T) {
+	const stall = 20 * time.Millisecond
+	body := bytes.Repeat([]byte("x"), 2<<20)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		client  time.Duration
+		want    []string
+	}{
+		{
+			name: "slow client",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body[:1<<20])
+				w.Write(body[1<<20:])
+			},
+			client: 2 * stall,
+			want:   []string{"cdn_delivery_client_stalls_total 1", "cdn_delivery_origin_stalls_total 0", "cdn_delivery_ttfb_seconds_count 1", "cdn_delivery_rate_bytes_per_second_count 1"},
+		},
+		{
+			name: "slow origin",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body[:1<<20])
+				time.Sleep(2 * stall)
+				w.Write(body[1<<20:])
+			},
+			want: []string{"cdn_delivery_client_stalls_total 0", "cdn_delivery_origin_stalls_total 1"},
+		},
+		{
+			name: "no body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+			},
+			want: []string{"cdn_delivery_ttfb_seconds_count 0", "cdn_delivery_client_stalls_total 0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := metrics.NewRegistry()
+			handler := NewDelivery(reg, stall).Middleware(tt.handler)
+			w := &slowWriter{ResponseRecorder: httptest.NewRecorder(), delay: tt.client}
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/media/assets/video.mp4", nil))
+
+			got := scrape(t, reg)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want+"\n") {
+					t.Errorf("metrics missing %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNilDelivery(t *testing.T) {
+	var d *Delivery
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if h := d.Middleware(next); h == nil {
+		t.Fatal("nil Delivery returned no handler")
+	}
+}
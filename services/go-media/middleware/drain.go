@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// Drainer tracks in-flight requests so shutdown can stop accepting new
+// ones and wait for the rest to finish
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inflight int
+	idle     chan struct{}
+}
+
+// NewDrainer creates a drainer that accepts requests until Drain is called
+func NewDrainer() *Drainer {
+	return &Drainer{}
+}
+
+// Middleware rejects requests with 503 once draining has started and
+// counts the ones it lets through
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			w.Header().Set("Retry-After", "30")
+			w.Header().Set("Connection", "close")
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.ShuttingDown, "Server is shutting down")
+			return
+		}
+		d.inflight++
+		d.mu.Unlock()
+
+		defer d.done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Drainer) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inflight--
+	if d.inflight == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// InFlight returns the number of requests currently being served
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inflight
+}
+
+// Drain stops accepting requests and waits until in-flight ones finish or
+// ctx is done
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	if d.inflight == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d requests still in flight: %w", d.InFlight(), ctx.Err())
+	}
+}
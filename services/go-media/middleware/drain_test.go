@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainerWaitsForInFlight(t *testing.T) {
+	d := NewDrainer()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	first := httptest.NewRecorder()
+	go handler.ServeHTTP(first, httptest.NewRequest("POST", "/v1/media/upload", nil))
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- d.Drain(context.Background()) }()
+
+	// Wait for draining to begin, then new requests are refused
+	for {
+		w := httptest.NewRecorder()
+		d.Middleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("POST", "/v1/media/upload", nil))
+		if w.Code == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain() returned %v before the upload finished", err)
+	default:
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Errorf("Drain() error = %v", err)
+	}
+	if d.InFlight() != 0 {
+		t.Errorf("InFlight() = %d, want 0", d.InFlight())
+	}
+}
+
+func TestDrainerDeadline(t *testing.T) {
+	d := NewDrainer()
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain() error = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// Deadlines replaces the server-wide read and write timeouts for a route,
+// so long uploads and media streams aren't cut off. A zero duration
+// removes the deadline.
+func Deadlines(read, write time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline(read))
+			rc.SetWriteDeadline(deadline(write))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// Timeout bounds the total time of buffered (JSON) handlers, replying
+// 503 and cancelling the request context when exceeded
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The handler gets a writer of its own, without the headers
+			// and error format chosen in front of it
+			if id := r.Header.Get(apierror.RequestIDHeader); id != "" {
+				w.Header().Set(apierror.RequestIDHeader, id)
+			}
+			apierror.Negotiate(next).ServeHTTP(w, r)
+		}), d, "Request timed out")
+	}
+}
+
+// MaxBodySize rejects request bodies larger than n bytes
+func MaxBodySize(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge, "Request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	handler := MaxBodySize(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{name: "within limit", body: "12345678", want: http.StatusOK},
+		{name: "declared too large", body: "123456789", want: http.StatusRequestEntityTooLarge},
+		{name: "streamed too large", body: "123456789", chunked: true, want: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/media/sign", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutKeepsErrorFormat(t *testing.T) {
+	handler := RequestID(apierror.Negotiate(Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, http.StatusNotFound, apierror.AssetNotFound, "Asset not found")
+	}))))
+	req := httptest.NewRequest("GET", "/v1/media/list", nil)
+	req.Header.Set(apierror.RequestIDHeader, "req-1")
+	req.Header.Set("Accept", "application/problem+json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var got apierror.Problem
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != apierror.ProblemContentType || got.RequestID != "req-1" || got.Code != apierror.AssetNotFound {
+		t.Errorf("headers %v, body %+v", w.Header(), got)
+	}
+}
+
+func TestDeadlinesExtendServerTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/short", slow)
+	mux.Handle("/long", Logger(Deadlines(time.Second, time.Second)(slow)))
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	if _, err := http.Get(srv.URL + "/short"); err == nil {
+		t.Error("expected the server write timeout to cut off /short")
+	}
+
+	resp, err := http.Get(srv.URL + "/long")
+	if err != nil {
+		t.Fatalf("GET /long error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("body = %q, want done", body)
+	}
+}
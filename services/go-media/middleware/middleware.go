@@ -1,9 +1,15 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/reporting"
 )
 
 type responseWriter struct {
@@ -23,6 +29,43 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// RequestID gives every request an ID, the one the client or a proxy in
+// front sent in X-Request-ID if it looks like one, else a random one. It
+// is set on the request for the handlers and on the response, where
+// error bodies repeat it, so a failure a client reports can be found in
+// the logs.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(apierror.RequestIDHeader)
+		if !validRequestID(id) {
+			var b [12]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+			r.Header.Set(apierror.RequestIDHeader, id)
+		}
+		w.Header().Set(apierror.RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether id is short and safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
 // Logger middleware
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,28 +79,38 @@ func Logger(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 
 		log.Printf(
-			"%s %s %d %s %dB %s",
+			"%s %s %d %s %dB %s %s",
 			r.Method,
 			r.RequestURI,
 			rw.status,
 			http.StatusText(rw.status),
 			rw.size,
 			time.Since(start),
+			r.Header.Get(apierror.RequestIDHeader),
 		)
 	})
 }
 
-// Recovery middleware
-func Recovery(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("Panic: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			}
-		}()
-		next.ServeHTTP(w, r)
-	})
+// Recovery middleware. Panics are logged and forwarded to the error
+// reporter (if configured) with the request context attached.
+func Recovery(reporter *reporting.Reporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					// Handlers abort streamed responses on purpose; let
+					// net/http drop the connection without a report
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					log.Printf("Panic: %v", err)
+					reporter.CapturePanic(r, err)
+					apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal Server Error")
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // SecurityHeaders middleware
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(apierror.RequestIDHeader)
+	}))
+
+	for _, tt := range []struct {
+		sent string
+		kept bool
+	}{
+		{"", false},
+		{"8a1b2c3d4e5f6a7b-SJC", true},
+		{"has space", false},
+		{strings.Repeat("a", 129), false},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.sent != "" {
+			r.Header.Set(apierror.RequestIDHeader, tt.sent)
+		}
+		h.ServeHTTP(w, r)
+		got := w.Header().Get(apierror.RequestIDHeader)
+		if got == "" || got != seen || (got == tt.sent) != tt.kept {
+			t.Errorf("sent %q: response ID %q, request ID %q", tt.sent, got, seen)
+		}
+	}
+}
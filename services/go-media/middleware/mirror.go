@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+// ShadowHeader marks requests sent by a Mirror, which a mirroring shadow
+// doesn't mirror again
+const ShadowHeader = "X-CDN-Shadow"
+
+// Mirror sends a sample of read requests also to a shadow deployment,
+// such as one on a new storage backend or version, and compares its
+// responses with the ones clients get: their status, body size and
+// latency. Only the primary response reaches the client; the shadow's is
+// read, measured and discarded. Differences are logged and counted, so a
+// migration can be judged on real traffic before it takes any.
+type Mirror struct {
+	target  string
+	percent int
+	client  *http.Client
+	timeout time.Duration
+	// slots bounds the shadow requests in flight
+	slots  chan struct{}
+	sample func() int // in [0, 100)
+
+	requests       *metrics.Counter
+	dropped        *metrics.Counter
+	failures       *metrics.Counter
+	statusDiffs    *metrics.Counter
+	sizeDiffs      *metrics.Counter
+	primarySeconds *metrics.Histogram
+	shadowSeconds  *metrics.Histogram
+}
+
+// NewMirror mirrors percent of reads to target, a base URL, with at most
+// concurrency shadow requests in flight, each given timeout, and
+// registers its metrics in reg. It returns nil, which mirrors nothing,
+// when target is empty.
+func NewMirror(reg *metrics.Registry, target string, percent, concurrency int, timeout time.Duration) *Mirror {
+	if target == "" {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Bodies are compared as sent
+	transport.DisableCompression = true
+	latency := []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	return &Mirror{
+		target:  strings.TrimSuffix(target, "/"),
+		percent: percent,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		timeout: timeout,
+		slots:   make(chan struct{}, concurrency),
+		sample:  func() int { return rand.Intn(100) },
+
+		requests:       reg.Counter("cdn_mirror_requests_total", "Reads mirrored to the shadow deployment"),
+		dropped:        reg.Counter("cdn_mirror_dropped_total", "Sampled reads not mirrored because the shadow had too many requests in flight"),
+		failures:       reg.Counter("cdn_mirror_failures_total", "Mirrored reads the shadow failed to answer in time"),
+		statusDiffs:    reg.Counter("cdn_mirror_status_mismatches_total", "Mirrored reads the shadow answered with another status"),
+		sizeDiffs:      reg.Counter("cdn_mirror_size_mismatches_total", "Mirrored reads the shadow answered with the same status and a body of another size"),
+		primarySeconds: reg.Histogram("cdn_mirror_primary_seconds", "Time to serve mirrored reads here", latency),
+		shadowSeconds:  reg.Histogram("cdn_mirror_shadow_seconds", "Time the shadow took to serve mirrored reads", latency),
+	}
+}
+
+// mirrored is one side of a mirrored read
+type mirrored struct {
+	status  int
+	bytes   int64
+	latency time.Duration
+	err     error
+}
+
+// Middleware mirrors a sample of the GET and HEAD requests to next
+func (m *Mirror) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get(ShadowHeader) != "" || m.sample() >= m.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			m.dropped.Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The shadow request starts with the primary one, so their
+		// latencies compare, and outlives it when the shadow is slower
+		shadow := make(chan mirrored, 1)
+		req := m.shadowRequest(r)
+		go func() {
+			defer func() { <-m.slots }()
+			shadow <- m.send(req)
+		}()
+
+		mw := &mirrorWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(mw, r)
+		primary := mirrored{status: mw.status, bytes: mw.bytes, latency: time.Since(start)}
+		go m.compare(r, primary, shadow)
+	})
+}
+
+// shadowRequest copies r for the shadow, on the same Host so host-based
+// routing matches
+func (m *Mirror) shadowRequest(r *http.Request) *http.Request {
+	req, _ := http.NewRequestWithContext(context.WithoutCancel(r.Context()), r.Method, m.target+r.URL.RequestURI(), nil)
+	req.Header = r.Header.Clone()
+	for _, h := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"} {
+		req.Header.Del(h)
+	}
+	req.Header.Set(ShadowHeader, "1")
+	req.Host = r.Host
+	return req
+}
+
+// send makes a shadow request and reads its response to the end
+func (m *Mirror) send(req *http.Request) mirrored {
+	ctx, cancel := context.WithTimeout(req.Context(), m.timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return mirrored{err: err}
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	return mirrored{status: resp.StatusCode, bytes: n, latency: time.Since(start), err: err}
+}
+
+// compare records how the shadow's answer to r differed from primary's
+func (m *Mirror) compare(r *http.Request, primary mirrored, result <-chan mirrored) {
+	shadow := <-result
+	m.requests.Inc()
+	m.primarySeconds.Observe(primary.latency.Seconds())
+	if shadow.err != nil {
+		m.failures.Inc()
+		log.Printf("Shadow failed: %s %s: %v", r.Method, r.URL.RequestURI(), shadow.err)
+		return
+	}
+	m.shadowSeconds.Observe(shadow.latency.Seconds())
+
+	switch {
+	case shadow.status != primary.status:
+		m.statusDiffs.Inc()
+		log.Printf("Shadow mismatch: %s %s: status %d, shadow %d (%s, shadow %s)",
+			r.Method, r.URL.RequestURI(), primary.status, shadow.status, primary.latency.Round(time.Millisecond), shadow.latency.Round(time.Millisecond))
+	case shadow.bytes != primary.bytes:
+		m.sizeDiffs.Inc()
+		log.Printf("Shadow mismatch: %s %s: %dB, shadow %dB (%s, shadow %s)",
+			r.Method, r.URL.RequestURI(), primary.bytes, shadow.bytes, primary.latency.Round(time.Millisecond), shadow.latency.Round(time.Millisecond))
+	}
+}
+
+// mirrorWriter records the status and size of the primary response
+type mirrorWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (mw *mirrorWriter) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints come first
+	if !mw.wroteHeader && status >= 200 {
+		mw.status, mw.wroteHeader = status, true
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *mirrorWriter) Write(b []byte) (int, error) {
+	mw.wroteHeader = true
+	n, err := mw.ResponseWriter.Write(b)
+	mw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (mw *mirrorWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+func TestMirrorComparesShadow(t *testing.T) {
+	seen := make(chan *http.Request, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r
+		switch r.URL.Path {
+		case "/gone.png":
+			http.Error(w, "Object not found", http.StatusNotFound)
+		case "/resized.png":
+			w.Write([]byte("smaller"))
+		default:
+			w.Write([]byte("same body"))
+		}
+	}))
+	defer shadow.Close()
+
+	reg := metrics.NewRegistry()
+	m := NewMirror(reg, shadow.URL+"/", 100, 4, time.Second)
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("same body"))
+	}))
+
+	for _, path := range []string{"/logo.png", "/gone.png", "/resized.png"} {
+		r := httptest.NewRequest("GET", path+"?w=100", nil)
+		r.Host = "cdn.example.com"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Body.String() != "same body" {
+			t.Errorf("%s: client got %q", path, w.Body.String())
+		}
+		got := <-seen
+		if got.Host != "cdn.example.com" || got.URL.RawQuery != "w=100" || got.Header.Get(ShadowHeader) == "" {
+			t.Errorf("%s: shadow got %s %s %v", path, got.Host, got.URL, got.Header)
+		}
+	}
+	// Writes and mirrored requests aren't mirrored
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", nil))
+	again := httptest.NewRequest("GET", "/logo.png", nil)
+	again.Header.Set(ShadowHeader, "1")
+	handler.ServeHTTP(httptest.NewRecorder(), again)
+
+	want := []string{"cdn_mirror_requests_total 3", "cdn_mirror_status_mismatches_total 1", "cdn_mirror_size_mismatches_total 1", "cdn_mirror_failures_total 0"}
+	var out string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if out = scrape(t, reg); strings.Contains(out, want[0]) {
+			break
+		}
+	}
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("metrics missing %q:\n%s", w, out)
+		}
+	}
+	if len(seen) != 0 {
+		t.Errorf("%d unexpected shadow requests", len(seen))
+	}
+}
+
+func TestMirrorSamples(t *testing.T) {
+	shadow := httptest.NewServer(http.NotFoundHandler())
+	defer shadow.Close()
+
+	reg := metrics.NewRegistry()
+	m := NewMirror(reg, shadow.URL, 25, 4, time.Second)
+	handler := m.Middleware(http.NotFoundHandler())
+	for _, sample := range []int{0, 24, 25, 99} {
+		m.sample = func() int { return sample }
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a.png", nil))
+	}
+
+	var out string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if out = scrape(t, reg); strings.Contains(out, "cdn_mirror_requests_total 2") {
+			return
+		}
+	}
+	t.Errorf("want 2 of 4 reads mirrored at 25%%:\n%s", out)
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// Priority is a request class for load shedding
+type Priority int
+
+// Priorities, most important first
+const (
+	// PriorityInteractive is for requests a person is waiting on, such as
+	// asset GETs rendering a page
+	PriorityInteractive Priority = iota
+	// PriorityStandard is for uploads and API calls
+	PriorityStandard
+	// PriorityBulk is for listings, archives and other batch work that
+	// can be retried later
+	PriorityBulk
+)
+
+var priorityNames = []string{"interactive", "standard", "bulk"}
+
+func (p Priority) String() string {
+	return priorityNames[p]
+}
+
+// ParsePriority parses a priority class name; "" is PriorityStandard
+func ParsePriority(s string) (Priority, error) {
+	if s == "" {
+		return PriorityStandard, nil
+	}
+	for i, name := range priorityNames {
+		if s == name {
+			return Priority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q (want interactive, standard or bulk)", s)
+}
+
+// Limiter caps the requests served at once and sheds lower priorities
+// first: each class is refused once the requests in flight reach its
+// share of the capacity, so bulk work gives way before standard, and
+// the last slots are kept for interactive requests.
+type Limiter struct {
+	mu       sync.Mutex
+	inflight int
+	limits   [3]int
+}
+
+// NewLimiter allows up to maxConcurrent requests in flight, of which
+// standard requests may take standardPercent and bulk requests
+// bulkPercent. It returns nil, which limits nothing, when maxConcurrent
+// is not positive.
+func NewLimiter(maxConcurrent, standardPercent, bulkPercent int) *Limiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	share := func(percent int) int {
+		if n := maxConcurrent * percent / 100; n > 0 {
+			return n
+		}
+		return 1
+	}
+	return &Limiter{limits: [3]int{maxConcurrent, share(standardPercent), share(bulkPercent)}}
+}
+
+// Acquire takes a slot for a request of priority p, reporting false if
+// the request should be shed. Each successful Acquire must be followed
+// by Release.
+func (l *Limiter) Acquire(p Priority) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= l.limits[p] {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// Release frees a slot taken by Acquire
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.inflight--
+	l.mu.Unlock()
+}
+
+// Middleware serves requests at priority p, answering 503 with
+// Retry-After when they are shed
+func (l *Limiter) Middleware(p Priority) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.Acquire(p) {
+				w.Header().Set("Retry-After", "1")
+				apierror.Write(w, http.StatusServiceUnavailable, apierror.ServerBusy, "Server is busy, retry shortly")
+				return
+			}
+			defer l.Release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
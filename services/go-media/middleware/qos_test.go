@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLimiterShedsByPriority(t *testing.T) {
+	// 10 slots: bulk may use 5, standard 8, interactive all 10
+	l := NewLimiter(10, 80, 50)
+
+	take := func(p Priority, n int) int {
+		got := 0
+		for i := 0; i < n; i++ {
+			if l.Acquire(p) {
+				got++
+			}
+		}
+		return got
+	}
+	if got := take(PriorityBulk, 7); got != 5 {
+		t.Errorf("bulk acquired %d slots, want 5", got)
+	}
+	if got := take(PriorityStandard, 5); got != 3 {
+		t.Errorf("standard acquired %d slots, want 3", got)
+	}
+	if got := take(PriorityInteractive, 5); got != 2 {
+		t.Errorf("interactive acquired %d slots, want 2", got)
+	}
+
+	// Freed slots go to whichever class is still under its share
+	l.Release()
+	if l.Acquire(PriorityBulk) {
+		t.Error("bulk acquired a slot with 9 in flight")
+	}
+	if !l.Acquire(PriorityInteractive) {
+		t.Error("interactive refused a free slot")
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := NewLimiter(1, 100, 100)
+	hold := make(chan struct{})
+	started := make(chan struct{})
+	h := l.Middleware(PriorityBulk)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-hold
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("shed request = %d (Retry-After %q), want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	close(hold)
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter = NewLimiter(0, 90, 60)
+	if l != nil {
+		t.Fatal("NewLimiter(0) should disable limiting")
+	}
+	if !l.Acquire(PriorityBulk) {
+		t.Error("nil limiter shed a request")
+	}
+	l.Release()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if h := l.Middleware(PriorityBulk)(next); h == nil {
+		t.Error("nil limiter middleware returned nil")
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for s, want := range map[string]Priority{"": PriorityStandard, "interactive": PriorityInteractive, "bulk": PriorityBulk} {
+		if got, err := ParsePriority(s); err != nil || got != want {
+			t.Errorf("ParsePriority(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Error("ParsePriority(urgent) should fail")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
 )
 
 type rateLimiter struct {
@@ -26,6 +28,8 @@ type tokenBucket struct {
 	mu         sync.Mutex
 }
 
+// NewRateLimiter limits each client to requestsPerMinute with bursts of
+// burst. Call Cleanup periodically to forget idle clients.
 func NewRateLimiter(requestsPerMinute, burst int) *rateLimiter {
 	rl := &rateLimiter{
 		visitors: make(map[string]*visitor),
@@ -33,17 +37,27 @@ func NewRateLimiter(requestsPerMinute, burst int) *rateLimiter {
 		burst:    burst,
 	}
 
-	// Cleanup old visitors every 5 minutes
-	go func() {
-		for {
-			time.Sleep(5 * time.Minute)
-			rl.cleanup()
-		}
-	}()
-
 	return rl
 }
 
+// SetLimits changes the rate and burst for new and existing visitors
+func (rl *rateLimiter) SetLimits(requestsPerMinute, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rate = requestsPerMinute
+	rl.burst = burst
+	for _, v := range rl.visitors {
+		v.limiter.mu.Lock()
+		v.limiter.refillRate = requestsPerMinute
+		v.limiter.maxTokens = burst
+		if v.limiter.tokens > burst {
+			v.limiter.tokens = burst
+		}
+		v.limiter.mu.Unlock()
+	}
+}
+
 func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip := r.RemoteAddr
@@ -69,7 +83,7 @@ func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
 		rl.mu.Unlock()
 
 		if !v.limiter.allow() {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			apierror.Write(w, http.StatusTooManyRequests, apierror.RateLimited, "Rate limit exceeded")
 			return
 		}
 
@@ -101,7 +115,8 @@ func (tb *tokenBucket) allow() bool {
 	return false
 }
 
-func (rl *rateLimiter) cleanup() {
+// Cleanup forgets clients not seen for five minutes
+func (rl *rateLimiter) Cleanup() {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
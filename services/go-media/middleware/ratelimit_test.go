@@ -138,7 +138,7 @@ func TestRateLimiterCleanup(t *testing.T) {
 		lastSeen: time.Now(),
 	}
 
-	rl.cleanup()
+	rl.Cleanup()
 
 	if _, exists := rl.visitors["old-ip"]; exists {
 		t.Error("Old visitor should have been cleaned up")
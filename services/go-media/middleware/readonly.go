@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// ReadOnly rejects every request but GET, HEAD and OPTIONS with 405 when
+// enabled, for replicas that serve reads while a single writer instance
+// takes uploads and deletes. Preflights still pass so browsers see the
+// rejection rather than a CORS failure.
+func ReadOnly(enabled bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+				apierror.Write(w, http.StatusMethodNotAllowed, apierror.ReadOnly, "Read-only replica; send writes to the primary instance")
+			}
+		})
+	}
+}
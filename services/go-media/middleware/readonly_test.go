@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		enabled bool
+		method  string
+		want    int
+	}{
+		{false, "POST", http.StatusOK},
+		{false, "DELETE", http.StatusOK},
+		{true, "GET", http.StatusOK},
+		{true, "HEAD", http.StatusOK},
+		{true, "OPTIONS", http.StatusOK},
+		{true, "POST", http.StatusMethodNotAllowed},
+		{true, "DELETE", http.StatusMethodNotAllowed},
+		{true, "PUT", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ReadOnly(tt.enabled)(ok).ServeHTTP(w, httptest.NewRequest(tt.method, "/v1/media/upload", nil))
+		if w.Code != tt.want {
+			t.Errorf("enabled=%v %s: status %d, want %d", tt.enabled, tt.method, w.Code, tt.want)
+		}
+		if w.Code == http.StatusMethodNotAllowed && w.Header().Get("Allow") == "" {
+			t.Errorf("%s: 405 without an Allow header", tt.method)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+// Versions serves each version of the API from the one set of /v1
+// routes. /v1 is frozen: it answers as it always has, and once a
+// retirement is announced its responses say so in Deprecation (RFC 9745)
+// and Sunset (RFC 8594) headers. /v2 is the same routes with the
+// envelopes that would break /v1 clients, which for now means errors as
+// RFC 9457 problem details whatever the Accept header.
+type Versions struct {
+	// Deprecated is when /v1 was or will be deprecated; zero for not yet
+	Deprecated time.Time
+	// Sunset is when /v1 is expected to stop answering; zero if unknown
+	Sunset time.Time
+	// Link is a page on moving off /v1, sent with the Deprecation header
+	Link string
+	// Stable are /v1 path prefixes of URLs rather than API calls, such as
+	// asset URLs, which stay under /v1 and aren't deprecated with it
+	Stable []string
+	// Skip reports requests the API doesn't serve, such as those to custom
+	// domains, whose paths are passed on as they are
+	Skip func(*http.Request) bool
+}
+
+// Middleware rewrites /v2 requests to the /v1 routes of next and marks
+// /v1 responses deprecated
+func (v Versions) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v.Skip != nil && v.Skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/v2/"); ok {
+			next.ServeHTTP(w, toV1(r, rest))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/v1/") && !v.Deprecated.IsZero() && !v.stable(r.URL.Path) {
+			h := w.Header()
+			h.Set("Deprecation", "@"+strconv.FormatInt(v.Deprecated.Unix(), 10))
+			if !v.Sunset.IsZero() {
+				h.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if v.Link != "" {
+				h.Add("Link", "<"+v.Link+`>; rel="deprecation"; type="text/html"`)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v Versions) stable(path string) bool {
+	for _, prefix := range v.Stable {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// toV1 returns r for the /v1 route of its /v2 path, the part after /v2/
+// being rest. Errors still name the /v2 path.
+func toV1(r *http.Request, rest string) *http.Request {
+	r2 := r.WithContext(apierror.WithProblems(r.Context(), r.URL.Path))
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/v1/" + rest
+	if raw, ok := strings.CutPrefix(r.URL.RawPath, "/v2/"); ok {
+		r2.URL.RawPath = "/v1/" + raw
+	}
+	return r2
+}
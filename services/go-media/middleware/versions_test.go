@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+)
+
+func TestVersions(t *testing.T) {
+	var served string
+	handler := Versions{
+		Deprecated: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:     time.Date(2027, 7, 1, 0, 0, 0, 0, time.UTC),
+		Link:       "https://docs.example.com/v2",
+		Stable:     []string{"/v1/media/assets/"},
+		Skip:       func(r *http.Request) bool { return r.Host == "assets.acme.test" },
+	}.Middleware(apierror.Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.Path
+		apierror.Write(w, http.StatusNotFound, apierror.AssetNotFound, "Asset not found")
+	})))
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// /v2 is the /v1 route, with problem details unasked
+	w := serve("cdn.example.com", "/v2/media/info/a.png")
+	var problem apierror.Problem
+	json.NewDecoder(w.Body).Decode(&problem)
+	if served != "/v1/media/info/a.png" || w.Header().Get("Content-Type") != apierror.ProblemContentType {
+		t.Fatalf("/v2 served %s as %s", served, w.Header().Get("Content-Type"))
+	}
+	if problem.Instance != "/v2/media/info/a.png" || problem.Code != apierror.AssetNotFound || w.Header().Get("Deprecation") != "" {
+		t.Errorf("/v2 problem = %+v, Deprecation %q", problem, w.Header().Get("Deprecation"))
+	}
+
+	// /v1 keeps its errors and announces its retirement
+	w = serve("cdn.example.com", "/v1/media/info/a.png")
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("/v1 error as %s", w.Header().Get("Content-Type"))
+	}
+	if got := w.Header().Get("Deprecation"); got != "@1798761600" {
+		t.Errorf("Deprecation = %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Thu, 01 Jul 2027 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", got)
+	}
+	if got := w.Header().Get("Link"); got != `<https://docs.example.com/v2>; rel="deprecation"; type="text/html"` {
+		t.Errorf("Link = %q", got)
+	}
+
+	// Asset URLs and custom domains are left alone
+	if w := serve("cdn.example.com", "/v1/media/assets/a.png"); w.Header().Get("Deprecation") != "" {
+		t.Error("stable asset URL marked deprecated")
+	}
+	if w := serve("assets.acme.test", "/v2/a.png"); served != "/v2/a.png" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("custom domain served %s as %s", served, w.Header().Get("Content-Type"))
+	}
+}
+
+// Without a date, /v1 isn't announced deprecated
+func TestVersionsNotDeprecated(t *testing.T) {
+	handler := Versions{}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/media/list", nil))
+	if w.Header().Get("Deprecation") != "" || w.Header().Get("Sunset") != "" {
+		t.Errorf("headers = %v", w.Header())
+	}
+}
@@ -0,0 +1,204 @@
+// Package minify shrinks JavaScript and CSS by removing comments and
+// whitespace. It works on tokens rather than a full parse: identifiers
+// are not renamed and code is not restructured, which keeps the output
+// safe for any input the lexer accepts. Comments starting with /*! are
+// kept, as they conventionally carry licenses.
+package minify
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// Func minifies a whole source file
+type Func func(src []byte) ([]byte, error)
+
+// For returns the minifier for key's file type
+func For(key string) (Func, bool) {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".js", ".mjs":
+		return JS, true
+	case ".css":
+		return CSS, true
+	}
+	return nil, false
+}
+
+func isLicense(comment []byte) bool {
+	return bytes.HasPrefix(comment, []byte("/*!"))
+}
+
+// JS minifies JavaScript. A line break is kept wherever one separated
+// two tokens that could be affected by automatic semicolon insertion.
+func JS(src []byte) ([]byte, error) {
+	l := js.NewLexer(parse.NewInputBytes(src))
+	out := make([]byte, 0, len(src))
+
+	var prev js.TokenType
+	var prevText []byte
+	space, newline := false, false
+	for {
+		tt, text := l.Next()
+		switch tt {
+		case js.ErrorToken:
+			if err := l.Err(); err != io.EOF && err != nil {
+				return nil, err
+			}
+			return out, nil
+		case js.WhitespaceToken:
+			space = true
+			continue
+		case js.LineTerminatorToken, js.CommentLineTerminatorToken:
+			if tt == js.CommentLineTerminatorToken && isLicense(text) {
+				out = append(out, text...)
+			}
+			newline = true
+			continue
+		case js.CommentToken:
+			if isLicense(text) {
+				out = append(out, text...)
+			}
+			space = true
+			continue
+		case js.DivToken, js.DivEqToken:
+			if regexpAllowed(prev, prevText) {
+				if tt, text = l.RegExp(); tt == js.ErrorToken {
+					return nil, l.Err()
+				}
+			}
+		}
+
+		switch {
+		case prevText == nil:
+		case newline && !jsNewlineRedundant(prev, tt):
+			out = append(out, '\n')
+		case (space || newline) && jsNeedsSpace(prev, prevText, tt, text):
+			out = append(out, ' ')
+		}
+		out = append(out, text...)
+		prev, prevText = tt, text
+		space, newline = false, false
+	}
+}
+
+// regexpAllowed reports whether a "/" after prev starts a regular
+// expression rather than a division: at the start, after an operator or
+// opening punctuator, or after a keyword that takes an expression
+func regexpAllowed(prev js.TokenType, prevText []byte) bool {
+	switch {
+	case prevText == nil:
+		return true
+	case prev == js.CloseParenToken, prev == js.CloseBracketToken, prev == js.CloseBraceToken,
+		prev == js.IncrToken, prev == js.DecrToken:
+		return false
+	case js.IsPunctuator(prev):
+		return true
+	}
+	switch prev {
+	case js.ReturnToken, js.TypeofToken, js.CaseToken, js.DoToken, js.ElseToken, js.InToken,
+		js.InstanceofToken, js.NewToken, js.DeleteToken, js.VoidToken, js.ThrowToken,
+		js.YieldToken, js.AwaitToken:
+		return true
+	}
+	return false
+}
+
+// jsNewlineRedundant reports whether a line break between prev and next
+// can never change how the code parses
+func jsNewlineRedundant(prev, next js.TokenType) bool {
+	switch prev {
+	case js.OpenBraceToken, js.OpenParenToken, js.OpenBracketToken, js.CommaToken, js.SemicolonToken, js.ColonToken:
+		return true
+	}
+	switch next {
+	case js.CloseBraceToken, js.CloseParenToken, js.CloseBracketToken, js.CommaToken, js.SemicolonToken:
+		return true
+	}
+	return false
+}
+
+// jsNeedsSpace reports whether removing the whitespace between two
+// tokens would merge them into different tokens
+func jsNeedsSpace(prev js.TokenType, prevText []byte, next js.TokenType, text []byte) bool {
+	last, first := prevText[len(prevText)-1], text[0]
+	switch {
+	case isWordByte(last) && isWordByte(first):
+		return true
+	case (last == '+' || last == '-') && first == last:
+		return true
+	case last == '/' && (first == '/' || first == '*'):
+		return true
+	case last == '<' && first == '!', last == '-' && first == '>':
+		return true
+	case js.IsNumeric(prev) && first == '.':
+		return true
+	}
+	return false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c == '\\' || c >= 0x80 ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// CSS minifies a stylesheet. Whitespace is only removed next to
+// punctuation where it never matters; spaces inside selectors and
+// values, such as before ":" or around "+" in calc(), are collapsed but
+// kept.
+func CSS(src []byte) ([]byte, error) {
+	l := css.NewLexer(parse.NewInputBytes(src))
+	out := make([]byte, 0, len(src))
+
+	var prev css.TokenType
+	started, space := false, false
+	for {
+		tt, text := l.Next()
+		switch tt {
+		case css.ErrorToken:
+			if err := l.Err(); err != io.EOF && err != nil {
+				return nil, err
+			}
+			return out, nil
+		case css.WhitespaceToken:
+			space = true
+			continue
+		case css.CommentToken:
+			if isLicense(text) {
+				out = append(out, text...)
+			}
+			space = true
+			continue
+		case css.RightBraceToken:
+			// The last declaration in a block needs no semicolon
+			if prev == css.SemicolonToken {
+				out = out[:len(out)-1]
+			}
+		}
+
+		if started && space && !cssSpaceRedundant(prev, tt) {
+			out = append(out, ' ')
+		}
+		out = append(out, text...)
+		prev, started, space = tt, true, false
+	}
+}
+
+func cssSpaceRedundant(prev, next css.TokenType) bool {
+	switch prev {
+	case css.LeftBraceToken, css.RightBraceToken, css.SemicolonToken, css.CommaToken,
+		css.ColonToken, css.LeftParenthesisToken, css.FunctionToken:
+		return true
+	}
+	switch next {
+	case css.LeftBraceToken, css.RightBraceToken, css.SemicolonToken, css.CommaToken,
+		css.RightParenthesisToken:
+		return true
+	}
+	return false
+}
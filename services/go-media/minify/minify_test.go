@@ -0,0 +1,68 @@
+package minify
+
+import "testing"
+
+func TestJS(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"comments and indentation", "/* header */\nfunction add(a, b) {\n  // sum\n  return a + b;\n}\n", "function add(a,b){return a+b;}"},
+		{"license kept", "/*! MIT */\nvar x = 1;", "/*! MIT */var x=1;"},
+		{"words stay apart", "const  x = typeof  y", "const x=typeof y"},
+		{"operators stay apart", "a = b + +c - -d", "a=b+ +c- -d"},
+		{"asi newline kept", "let a = 1\nlet b = a\n++b", "let a=1\nlet b=a\n++b"},
+		{"return newline kept", "function f() {\n  return\n  x\n}", "function f(){return\nx}"},
+		{"regexp", "var re = / +\\/ x/g; var q = a / b / c", "var re=/ +\\/ x/g;var q=a/b/c"},
+		{"regexp after return", "return /a b/.test(s) / / x/.source", "return/a b/.test(s)/ / x/.source"},
+		{"strings untouched", `s = "a  /* b */  c" + 'd // e'`, `s="a  /* b */  c"+'d // e'`},
+		{"template untouched", "t = `x  ${ a + 1 }  y`", "t=`x  ${a+1}  y`"},
+		{"number member", "n = 1 .toString()", "n=1 .toString()"},
+	}
+	for _, tt := range tests {
+		got, err := JS([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJSError(t *testing.T) {
+	if _, err := JS([]byte("var s = 'unterminated")); err == nil {
+		t.Error("expected an error for an unterminated string")
+	}
+}
+
+func TestCSS(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"rules", "/* theme */\nbody {\n  color: red;\n  margin: 0 auto;\n}\n", "body{color:red;margin:0 auto}"},
+		{"selectors", "nav  a:hover, .x > .y ,p :first-child { top: 0 }", "nav a:hover,.x > .y,p :first-child{top:0}"},
+		{"calc spacing kept", "div { width: calc( 100% - 2px ); }", "div{width:calc(100% - 2px)}"},
+		{"media query", "@media (min-width: 600px) and (max-width: 900px) { a { b: c } }", "@media (min-width:600px) and (max-width:900px){a{b:c}}"},
+		{"license kept", "/*! v1 */ a { b: c }", "/*! v1 */a{b:c}"},
+		{"strings untouched", `a::after { content: "x  ;  y"; }`, `a::after{content:"x  ;  y"}`},
+	}
+	for _, tt := range tests {
+		got, err := CSS([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	for key, want := range map[string]bool{"a/app.js": true, "a/APP.MJS": true, "site.css": true, "x.html": false, "js": false} {
+		if _, ok := For(key); ok != want {
+			t.Errorf("For(%q) ok = %v, want %v", key, ok, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package offload hands image and video uploads to Cloudflare Images
+// and Stream, which serve resized and transcoded variants so the service
+// doesn't have to.
+package offload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Services an asset can be offloaded to
+const (
+	ServiceImages = "images"
+	ServiceStream = "stream"
+)
+
+// Asset states. Images are ready as soon as they are uploaded; Stream
+// reports queued and inprogress while transcoding.
+const (
+	StateReady = "ready"
+	StateError = "error"
+)
+
+// maxImageBytes is the largest file Cloudflare Images accepts; larger
+// images stay on R2 only
+const maxImageBytes = 10 << 20
+
+// maxWatch bounds how long a transcoding video is polled
+const maxWatch = 6 * time.Hour
+
+// ErrNotFound is returned for an asset Cloudflare no longer has
+var ErrNotFound = errors.New("offloaded asset not found")
+
+// Config selects what is offloaded for a Cloudflare account
+type Config struct {
+	AccountID string
+	APIToken  string
+	Images    bool
+	// ImagesVariant is the Images variant whose URL is returned for
+	// uploads, "public" if empty
+	ImagesVariant string
+	Stream        bool
+	// APIBase overrides the Cloudflare API endpoint, for tests
+	APIBase string
+}
+
+// Asset is an offloaded copy of an object
+type Asset struct {
+	Service string `json:"service"`
+	ID      string `json:"id"`
+	State   string `json:"state"`
+	// URL delivers the asset from Cloudflare: the configured Images
+	// variant, or Stream's HLS manifest
+	URL string `json:"url,omitempty"`
+}
+
+// Final reports whether the asset's state will not change
+func (a Asset) Final() bool {
+	return a.State == StateReady || a.State == StateError
+}
+
+// Client uploads to and polls Cloudflare Images and Stream. A nil
+// *Client offloads nothing.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu      sync.Mutex
+	watched map[string]watch
+}
+
+type watch struct {
+	asset Asset
+	since time.Time
+}
+
+// New returns a client for cfg, or nil if neither service is enabled
+func New(cfg Config) *Client {
+	if !cfg.Images && !cfg.Stream {
+		return nil
+	}
+	if cfg.ImagesVariant == "" {
+		cfg.ImagesVariant = "public"
+	}
+	if cfg.APIBase == "" {
+		cfg.APIBase = "https://api.cloudflare.com/client/v4"
+	}
+	return &Client{
+		cfg:     cfg,
+		http:    &http.Client{Timeout: 5 * time.Minute},
+		watched: make(map[string]watch),
+	}
+}
+
+// Service returns the service that takes objects of contentType and
+// size, or "" if they stay on R2 only
+func (c *Client) Service(contentType string, size int) string {
+	switch {
+	case c == nil:
+		return ""
+	case c.cfg.Images && strings.HasPrefix(contentType, "image/") && size <= maxImageBytes:
+		return ServiceImages
+	case c.cfg.Stream && strings.HasPrefix(contentType, "video/"):
+		return ServiceStream
+	}
+	return ""
+}
+
+// Upload sends data to service
+func (c *Client) Upload(ctx context.Context, service, filename string, data []byte) (*Asset, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(data)
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var result apiAsset
+	if err := c.do(ctx, http.MethodPost, c.path(service, ""), mw.FormDataContentType(), &body, &result); err != nil {
+		return nil, err
+	}
+	return c.asset(service, result), nil
+}
+
+// Status fetches the current state of an offloaded asset
+func (c *Client) Status(ctx context.Context, service, id string) (*Asset, error) {
+	var result apiAsset
+	if err := c.do(ctx, http.MethodGet, c.path(service, id), "", nil, &result); err != nil {
+		return nil, err
+	}
+	return c.asset(service, result), nil
+}
+
+// Delete removes an offloaded asset; one already gone is not an error
+func (c *Client) Delete(ctx context.Context, service, id string) error {
+	err := c.do(ctx, http.MethodDelete, c.path(service, id), "", nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Watch polls a's state, on behalf of the object at key, until it is
+// final (see Run)
+func (c *Client) Watch(key string, a Asset) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.watched[key] = watch{asset: a, since: time.Now()}
+	c.mu.Unlock()
+}
+
+// Run polls watched assets every interval until ctx is cancelled,
+// calling done for each once its state is final. Watches are kept in
+// memory, so videos still transcoding at shutdown are not reported.
+func (c *Client) Run(ctx context.Context, interval time.Duration, done func(key string, a Asset)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.poll(ctx, done)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Client) poll(ctx context.Context, done func(key string, a Asset)) {
+	c.mu.Lock()
+	pending := make(map[string]watch, len(c.watched))
+	for key, w := range c.watched {
+		pending[key] = w
+	}
+	c.mu.Unlock()
+
+	for key, w := range pending {
+		a, err := c.Status(ctx, w.asset.Service, w.asset.ID)
+		switch {
+		case err == nil && a.Final():
+			done(key, *a)
+		case errors.Is(err, ErrNotFound):
+		case time.Since(w.since) > maxWatch:
+			log.Printf("Offload: gave up waiting for %s %s (%s)", w.asset.Service, w.asset.ID, key)
+		default:
+			if err != nil {
+				log.Printf("Offload: polling %s %s: %v", w.asset.Service, w.asset.ID, err)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		// A newer upload of the same key keeps its own watch
+		if c.watched[key].asset.ID == w.asset.ID {
+			delete(c.watched, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *Client) path(service, id string) string {
+	p := "/accounts/" + c.cfg.AccountID + "/images/v1"
+	if service == ServiceStream {
+		p = "/accounts/" + c.cfg.AccountID + "/stream"
+	}
+	if id != "" {
+		p += "/" + id
+	}
+	return p
+}
+
+// apiAsset holds the fields used from Images and Stream results
+type apiAsset struct {
+	// Images
+	ID       string   `json:"id"`
+	Variants []string `json:"variants"`
+
+	// Stream
+	UID    string `json:"uid"`
+	Status struct {
+		State string `json:"state"`
+	} `json:"status"`
+	Playback struct {
+		HLS string `json:"hls"`
+	} `json:"playback"`
+}
+
+func (c *Client) asset(service string, r apiAsset) *Asset {
+	if service == ServiceStream {
+		state := r.Status.State
+		if state == "" {
+			state = "queued"
+		}
+		return &Asset{Service: service, ID: r.UID, State: state, URL: r.Playback.HLS}
+	}
+
+	a := &Asset{Service: service, ID: r.ID, State: StateReady}
+	for _, v := range r.Variants {
+		if strings.HasSuffix(v, "/"+c.cfg.ImagesVariant) {
+			a.URL = v
+		}
+	}
+	return a
+}
+
+type apiResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// do calls the Cloudflare API and decodes the result into out, if set
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.APIBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.cfg.APIToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var ar apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return fmt.Errorf("cloudflare API error (status %d)", resp.StatusCode)
+	}
+	if !ar.Success || resp.StatusCode >= 300 {
+		msg := "unknown error"
+		if len(ar.Errors) > 0 {
+			msg = fmt.Sprintf("%s (code %d)", ar.Errors[0].Message, ar.Errors[0].Code)
+		}
+		return fmt.Errorf("cloudflare API error (status %d): %s", resp.StatusCode, msg)
+	}
+	if out != nil {
+		return json.Unmarshal(ar.Result, out)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package offload
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAPI serves a minimal Cloudflare Images and Stream API. Videos are
+// in progress until polled twice.
+func fakeAPI(t *testing.T) (*httptest.Server, map[string]int) {
+	t.Helper()
+	polls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 10000, "message": "Authentication error"}}})
+			return
+		}
+
+		var result interface{}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/accounts/acct/images/v1":
+			if _, _, err := r.FormFile("file"); err != nil {
+				t.Errorf("image upload without a file: %v", err)
+			}
+			result = map[string]interface{}{"id": "img1", "variants": []string{
+				"https://imagedelivery.net/hash/img1/thumb",
+				"https://imagedelivery.net/hash/img1/public",
+			}}
+		case r.Method == "POST" && r.URL.Path == "/accounts/acct/stream":
+			result = map[string]interface{}{"uid": "vid1", "status": map[string]string{"state": "queued"},
+				"playback": map[string]string{"hls": "https://customer-x.cloudflarestream.com/vid1/manifest/video.m3u8"}}
+		case r.Method == "GET" && r.URL.Path == "/accounts/acct/stream/vid1":
+			polls["vid1"]++
+			state := "inprogress"
+			if polls["vid1"] >= 2 {
+				state = "ready"
+			}
+			result = map[string]interface{}{"uid": "vid1", "status": map[string]string{"state": state}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, polls
+}
+
+func TestService(t *testing.T) {
+	c := New(Config{Images: true, Stream: true})
+	tests := []struct {
+		contentType string
+		size        int
+		want        string
+	}{
+		{"image/png", 1 << 20, ServiceImages},
+		{"image/jpeg", maxImageBytes + 1, ""},
+		{"video/mp4", 50 << 20, ServiceStream},
+		{"application/pdf", 1 << 20, ""},
+	}
+	for _, tt := range tests {
+		if got := c.Service(tt.contentType, tt.size); got != tt.want {
+			t.Errorf("Service(%s, %d) = %q, want %q", tt.contentType, tt.size, got, tt.want)
+		}
+	}
+
+	if New(Config{}) != nil {
+		t.Error("New without services should return nil")
+	}
+	var disabled *Client
+	if got := disabled.Service("image/png", 1); got != "" {
+		t.Errorf("nil client Service = %q", got)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	srv, _ := fakeAPI(t)
+	c := New(Config{AccountID: "acct", APIToken: "token", Images: true, Stream: true, APIBase: srv.URL})
+	ctx := context.Background()
+
+	img, err := c.Upload(ctx, ServiceImages, "a.png", []byte("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ID != "img1" || img.State != StateReady || img.URL != "https://imagedelivery.net/hash/img1/public" {
+		t.Errorf("image = %+v", img)
+	}
+
+	vid, err := c.Upload(ctx, ServiceStream, "a.mp4", []byte("mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vid.ID != "vid1" || vid.Final() || !strings.HasSuffix(vid.URL, "/video.m3u8") {
+		t.Errorf("video = %+v", vid)
+	}
+
+	if _, err := c.Status(ctx, ServiceImages, "missing"); err != ErrNotFound {
+		t.Errorf("Status of a missing image = %v, want ErrNotFound", err)
+	}
+	if err := c.Delete(ctx, ServiceImages, "missing"); err != nil {
+		t.Errorf("Delete of a missing image = %v", err)
+	}
+
+	bad := New(Config{AccountID: "acct", APIToken: "wrong", Images: true, APIBase: srv.URL})
+	if _, err := bad.Upload(ctx, ServiceImages, "a.png", []byte("png")); err == nil || !strings.Contains(err.Error(), "Authentication error") {
+		t.Errorf("upload with a bad token = %v", err)
+	}
+}
+
+func TestPollReportsFinalState(t *testing.T) {
+	srv, polls := fakeAPI(t)
+	c := New(Config{AccountID: "acct", APIToken: "token", Stream: true, APIBase: srv.URL})
+	c.Watch("assets/a.mp4", Asset{Service: ServiceStream, ID: "vid1", State: "queued"})
+
+	var done []string
+	record := func(key string, a Asset) { done = append(done, key+" "+a.State) }
+
+	c.poll(context.Background(), record)
+	if len(done) != 0 {
+		t.Fatalf("reported %v while transcoding", done)
+	}
+	c.poll(context.Background(), record)
+	if len(done) != 1 || done[0] != "assets/a.mp4 ready" {
+		t.Fatalf("reported %v, want the ready video", done)
+	}
+
+	c.poll(context.Background(), record)
+	if polls["vid1"] != 2 {
+		t.Errorf("polled %d times, want the watch dropped after 2", polls["vid1"])
+	}
+}
@@ -0,0 +1,484 @@
+// Package qrcode encodes data as QR codes (ISO/IEC 18004) and draws them
+// as PNG or SVG images. Data is always encoded in byte mode, which every
+// reader supports, at the smallest version that holds it.
+package qrcode
+
+import (
+	"errors"
+)
+
+// Level is how much of a code can be damaged and still read
+type Level int
+
+const (
+	Low      Level = iota // about 7% of codewords
+	Medium                // about 15%
+	Quartile              // about 25%
+	High                  // about 30%
+)
+
+// ErrTooLong is returned for data that no version holds at the level
+var ErrTooLong = errors.New("data is too long for a QR code")
+
+// formatBits identifies each level in the format information
+var formatBits = [...]int{Low: 1, Medium: 0, Quartile: 3, High: 2}
+
+// eccPerBlock and numBlocks are the error correction codewords in each
+// block, and the number of blocks, indexed by level and version
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code is an encoded QR code: a square of Size modules, without the quiet
+// zone around it
+type Code struct {
+	Size     int
+	modules  []bool // dark modules, row by row
+	function []bool // finder, timing, alignment and format modules
+}
+
+// Dark reports whether the module in column x of row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Encode encodes data at level, choosing the smallest version that holds
+// it and the mask that reads best
+func Encode(data []byte, level Level) (*Code, error) {
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+countBits(version)+8*len(data) <= 8*dataCodewords(version, level) {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, ErrTooLong
+	}
+
+	// Byte mode, the length, the data, a terminator and padding
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * dataCodewords(version, level)
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, -len(bits)&7)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	size := 4*version + 17
+	c := &Code{Size: size, modules: make([]bool, size*size), function: make([]bool, size*size)}
+	c.drawFunctionPatterns(version)
+	c.drawCodewords(addErrorCorrection(codewords, version, level))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(level, mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // masks undo themselves
+	}
+	c.applyMask(best)
+	c.drawFormat(level, best)
+	return c, nil
+}
+
+// countBits is the width of the length in byte mode
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules is how many modules of a version hold codewords
+func rawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// dataCodewords is how many codewords of a version hold data at level
+func dataCodewords(version int, level Level) int {
+	return rawModules(version)/8 - eccPerBlock[level][version]*numBlocks[level][version]
+}
+
+// alignmentPositions returns the centers of the alignment patterns in
+// each direction
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, 4*version+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns
+// and the version, and reserves the format modules
+func (c *Code) drawFunctionPatterns(version int) {
+	n := c.Size
+	for i := 0; i < n; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	for _, f := range [][2]int{{3, 3}, {n - 4, 3}, {3, n - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := f[0]+dx, f[1]+dy
+				if x < 0 || x >= n || y < 0 || y >= n {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				c.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	pos := alignmentPositions(version)
+	last := len(pos) - 1
+	for i, x := range pos {
+		for j, y := range pos {
+			// The corners with finder patterns have none
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormat(Low, 0)
+	if version >= 7 {
+		bits := version<<12 | bchRemainder(version, 0x1F25, 12)
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 != 0
+			a, b := n-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// bchRemainder returns the check bits of the format or version
+// information: the remainder of data, shifted by degree, divided by
+// generator
+func bchRemainder(data, generator, degree int) int {
+	rem := data
+	for i := 0; i < degree; i++ {
+		rem = rem<<1 ^ (rem>>(degree-1))*generator
+	}
+	return rem & (1<<degree - 1)
+}
+
+// formatInfo returns the 15 format bits for level and mask
+func formatInfo(level Level, mask int) int {
+	data := formatBits[level]<<3 | mask
+	return (data<<10 | bchRemainder(data, 0x537, 10)) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information, and the
+// module beside them that is always dark
+func (c *Code) drawFormat(level Level, mask int) {
+	bits := formatInfo(level, mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+	n := c.Size
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(n-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, n-15+i, bit(i))
+	}
+	c.set(8, n-8, true)
+}
+
+// drawCodewords fills the modules outside the function patterns with
+// codewords, in two-module-wide columns zigzagging up and down from the
+// bottom right
+func (c *Code) drawCodewords(data []byte) {
+	n := c.Size
+	i := 0
+	for right := n - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// The vertical timing pattern is skipped
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < n; vert++ {
+			y := vert
+			if upward {
+				y = n - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y*n+x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y*n+x] = data[i>>3]>>(7-i&7)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the modules outside the function patterns that mask
+// selects
+func (c *Code) applyMask(mask int) {
+	n := c.Size
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if c.function[y*n+x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				c.modules[y*n+x] = !c.modules[y*n+x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read: long runs of one color,
+// 2×2 blocks of one color, patterns that look like finders and an
+// imbalance of dark and light modules
+func (c *Code) penalty() int {
+	n := c.Size
+	p := 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			x, y = y, x
+		}
+		return c.Dark(x, y)
+	}
+
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// 1:1:3:1:1 with four light modules on either side
+			for x := 0; x+7 <= n; x++ {
+				match := true
+				for i, dark := range finder {
+					if at(x+i, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				if c.light(x-4, x, y, vertical) || c.light(x+7, x+11, y, vertical) {
+					p += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			d := c.Dark(x, y)
+			if d {
+				dark++
+			}
+			if x+1 < n && y+1 < n && d == c.Dark(x+1, y) && d == c.Dark(x, y+1) && d == c.Dark(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+	// 10 for each 5% away from half dark
+	p += 10 * (abs(dark*20-n*n*10) / (n * n))
+	return p
+}
+
+// light reports whether modules from to to, in row y or column y, are all
+// light. The quiet zone beyond the edges is light.
+func (c *Code) light(from, to, y int, vertical bool) bool {
+	for x := max(0, from); x < min(c.Size, to); x++ {
+		dark := c.Dark(x, y)
+		if vertical {
+			dark = c.Dark(y, x)
+		}
+		if dark {
+			return false
+		}
+	}
+	return true
+}
+
+// addErrorCorrection splits data into blocks, adds Reed-Solomon error
+// correction to each and interleaves them. Blocks differ in length by one
+// data codeword at most, the longer ones last.
+func addErrorCorrection(data []byte, version int, level Level) []byte {
+	blocks := numBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawModules(version) / 8
+	short := blocks - raw%blocks
+	shortLen := raw / blocks
+	divisor := rsDivisor(eccLen)
+
+	split := make([][]byte, blocks)
+	k := 0
+	for i := range split {
+		n := shortLen - eccLen
+		if i >= short {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < short {
+			block = append(block, 0) // the gap is skipped below
+		}
+		split[i] = append(block, ecc...)
+	}
+
+	out := make([]byte, 0, raw)
+	for i := range split[0] {
+		for j, block := range split {
+			if i != shortLen-eccLen || j >= short {
+				out = append(out, block[i])
+			}
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the generator polynomial of degree, highest term
+// first without its coefficient of 1
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2⁸) modulo x⁸ + x⁴ + x³ + x² + 1
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// bitBuffer is a sequence of bits, most significant first
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>i&1 != 0)
+	}
+}
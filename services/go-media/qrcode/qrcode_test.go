@@ -0,0 +1,202 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestErrorCorrection(t *testing.T) {
+	// "HELLO WORLD" at 1-M, from the standard's worked example
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionInfo(t *testing.T) {
+	for _, tt := range []struct {
+		level Level
+		mask  int
+		want  int
+	}{
+		{Low, 0, 0b111011111000100},
+		{Low, 4, 0b110011000101111},
+		{Medium, 0, 0b101010000010010},
+		{Quartile, 0, 0b011010101011111},
+		{High, 0, 0b001011010001001},
+	} {
+		if got := formatInfo(tt.level, tt.mask); got != tt.want {
+			t.Errorf("formatInfo(%d, %d) = %015b, want %015b", tt.level, tt.mask, got, tt.want)
+		}
+	}
+	if got := 7<<12 | bchRemainder(7, 0x1F25, 12); got != 0b000111110010010100 {
+		t.Errorf("version 7 = %018b", got)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for _, tt := range []struct {
+		version int
+		level   Level
+		want    int
+	}{
+		{1, Low, 19}, {1, High, 9}, {10, Medium, 216}, {40, Low, 2956}, {40, High, 1276},
+	} {
+		if got := dataCodewords(tt.version, tt.level); got != tt.want {
+			t.Errorf("dataCodewords(%d, %d) = %d, want %d", tt.version, tt.level, got, tt.want)
+		}
+	}
+
+	for version, want := range map[int][]int{
+		1:  nil,
+		2:  {6, 18},
+		7:  {6, 22, 38},
+		32: {6, 34, 60, 86, 112, 138},
+		40: {6, 30, 58, 86, 114, 142, 170},
+	} {
+		if got := alignmentPositions(version); !reflect.DeepEqual(got, want) {
+			t.Errorf("alignmentPositions(%d) = %v, want %v", version, got, want)
+		}
+	}
+
+	if _, err := Encode(make([]byte, 2953), Low); err != nil {
+		t.Errorf("largest code: %v", err)
+	}
+	if _, err := Encode(make([]byte, 2954), Low); !errors.Is(err, ErrTooLong) {
+		t.Errorf("too long: %v", err)
+	}
+}
+
+// TestRoundTrip reads codes back the way a reader does: the format from
+// both copies, then the codewords under the mask
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		data    string
+		level   Level
+		version int
+	}{
+		{"HELLO WORLD", Medium, 1},
+		{"https://cdn.mikeodnis.dev/v1/media/private/tickets/4821.pdf?exp=1767225600&sig=0f3a9c", Quartile, 7},
+		{strings.Repeat("ticket ", 150), Low, 23},
+	} {
+		c, err := Encode([]byte(tt.data), tt.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Size != 4*tt.version+17 {
+			t.Errorf("%q: size %d, want version %d", tt.data, c.Size, tt.version)
+		}
+
+		n := c.Size
+		var first, second int
+		for i := 0; i <= 5; i++ {
+			first |= b2i(c.Dark(8, i)) << i
+		}
+		first |= b2i(c.Dark(8, 7))<<6 | b2i(c.Dark(8, 8))<<7 | b2i(c.Dark(7, 8))<<8
+		for i := 9; i < 15; i++ {
+			first |= b2i(c.Dark(14-i, 8)) << i
+		}
+		for i := 0; i < 8; i++ {
+			second |= b2i(c.Dark(n-1-i, 8)) << i
+		}
+		for i := 8; i < 15; i++ {
+			second |= b2i(c.Dark(8, n-15+i)) << i
+		}
+		if first != second {
+			t.Fatalf("%q: format copies differ: %015b, %015b", tt.data, first, second)
+		}
+		mask := (first ^ 0x5412) >> 10 & 7
+		if first != formatInfo(tt.level, mask) {
+			t.Fatalf("%q: format %015b", tt.data, first)
+		}
+
+		c.applyMask(mask)
+		raw := make([]byte, rawModules(tt.version)/8)
+		i := 0
+		for right := n - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := 0; vert < n; vert++ {
+				y := vert
+				if (right+1)&2 == 0 {
+					y = n - 1 - vert
+				}
+				for x := right; x > right-2; x-- {
+					if !c.function[y*n+x] && i < len(raw)*8 {
+						raw[i>>3] |= byte(b2i(c.Dark(x, y))) << (7 - i&7)
+						i++
+					}
+				}
+			}
+		}
+
+		// Undo the interleaving of the data codewords
+		blocks := numBlocks[tt.level][tt.version]
+		short := blocks - len(raw)%blocks
+		shortData := len(raw)/blocks - eccPerBlock[tt.level][tt.version]
+		split := make([][]byte, blocks)
+		k := 0
+		for col := 0; col <= shortData; col++ {
+			for j := range split {
+				if col < shortData || j >= short {
+					split[j] = append(split[j], raw[k])
+					k++
+				}
+			}
+		}
+		data := bytes.Join(split, nil)
+
+		var header []byte
+		if tt.version < 10 {
+			header = []byte{0x40 | byte(len(tt.data)>>4), byte(len(tt.data) << 4)}
+		} else {
+			header = []byte{0x40 | byte(len(tt.data)>>12), byte(len(tt.data) >> 4), byte(len(tt.data) << 4)}
+		}
+		// The data follows the header four bits into its last byte
+		var decoded []byte
+		body := data[len(header)-1:]
+		for j := 0; j < len(tt.data); j++ {
+			decoded = append(decoded, body[j]<<4|body[j+1]>>4)
+		}
+		if !bytes.Equal(data[:len(header)-1], header[:len(header)-1]) || data[len(header)-1]>>4 != header[len(header)-1]>>4 || string(decoded) != tt.data {
+			t.Errorf("decoded %q", decoded)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode([]byte("HELLO WORLD"), Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := c.Image(300)
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 300 {
+		t.Fatalf("bounds = %v", b)
+	}
+	// 29 modules at 10 pixels each, centered: the top left finder starts
+	// at 45
+	scale, offset := 300/29, (300-300/29*21)/2
+	if img.ColorIndexAt(offset, offset) != 1 || img.ColorIndexAt(offset-1, offset) != 0 || img.ColorIndexAt(offset+scale, offset+scale) != 0 {
+		t.Error("finder pattern isn't where it should be")
+	}
+	if got := c.Image(10).Bounds().Dx(); got != c.MinSize() {
+		t.Errorf("Image(10) is %d wide", got)
+	}
+
+	svg := string(c.SVG(256))
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 29 29"`) || !strings.Contains(svg, `d="M4 4h7v1h-7z`) {
+		t.Errorf("SVG() = %s", svg)
+	}
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
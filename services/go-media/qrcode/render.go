@@ -0,0 +1,68 @@
+package qrcode
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// QuietZone is the light border, in modules, that readers need around a
+// code
+const QuietZone = 4
+
+// MinSize is the smallest image, in pixels, that draws each module of c
+// at least one pixel wide, quiet zone included
+func (c *Code) MinSize() int {
+	return c.Size + 2*QuietZone
+}
+
+// Image draws c, with its quiet zone, on a square of size pixels. Modules
+// are whole pixels wide, so what doesn't divide evenly is added to the
+// border. size is raised to MinSize if it is smaller.
+func (c *Code) Image(size int) *image.Paletted {
+	size = max(size, c.MinSize())
+	scale := size / c.MinSize()
+	offset := (size - scale*c.Size) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				row := img.Pix[(offset+y*scale+py)*img.Stride:]
+				for px := 0; px < scale; px++ {
+					row[offset+x*scale+px] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+// SVG draws c, with its quiet zone, as an SVG document size pixels wide.
+// Each run of dark modules in a row is one rectangle of the path, which
+// scales without blurring.
+func (c *Code) SVG(size int) []byte {
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Dark(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < c.Size && c.Dark(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x+QuietZone, y+QuietZone, run, run)
+			x += run
+		}
+	}
+	view := c.MinSize()
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`+"\n",
+		size, size, view, view, path.String()))
+}
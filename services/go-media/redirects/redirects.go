@@ -0,0 +1,195 @@
+package redirects
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/gorilla/mux"
+)
+
+// Table is a compiled, immutable set of rules
+type Table struct {
+	rules         []compiledRule
+	trailingSlash string
+}
+
+// NewTable compiles rules, which are tried in order
+func NewTable(rules []Rule, trailingSlash string) (*Table, error) {
+	switch trailingSlash {
+	case "", TrailingSlashAdd, TrailingSlashRemove:
+	default:
+		return nil, fmt.Errorf("trailing slash policy must be add or remove, got %q", trailingSlash)
+	}
+
+	t := &Table{trailingSlash: trailingSlash}
+	for _, rule := range rules {
+		c, err := compile(rule)
+		if err != nil {
+			return nil, err
+		}
+		t.rules = append(t.rules, c)
+	}
+	return t, nil
+}
+
+// Load builds a table from cfg: the rules in cfg.File (if set) come
+// first, followed by the rules in the config file itself
+func Load(cfg config.RedirectConfig) (*Table, error) {
+	var rules []Rule
+	if cfg.File != "" {
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open redirects file: %w", err)
+		}
+		defer f.Close()
+
+		rules, err = Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.File, err)
+		}
+	}
+	for _, r := range cfg.Rules {
+		status := r.Status
+		if status == 0 {
+			status = http.StatusMovedPermanently
+		}
+		rules = append(rules, Rule{From: r.From, To: r.To, Status: status})
+	}
+	return NewTable(rules, cfg.TrailingSlash)
+}
+
+// Result is the outcome of looking up a path
+type Result struct {
+	// Status is the redirect status, or 200 for rewrites and proxies
+	Status int
+	// Target is the redirect location or rewritten path
+	Target string
+	// Proxy is set when the target is fetched from another origin
+	Proxy bool
+}
+
+// Lookup returns what to do with a request for p, a path beginning
+// with "/", or false when no rule applies
+func (t *Table) Lookup(p string) (Result, bool) {
+	switch {
+	case t.trailingSlash == TrailingSlashRemove && len(p) > 1 && strings.HasSuffix(p, "/"):
+		return Result{Status: http.StatusMovedPermanently, Target: strings.TrimRight(p, "/")}, true
+	case t.trailingSlash == TrailingSlashAdd && !strings.HasSuffix(p, "/") && !strings.Contains(path.Base(p), "."):
+		return Result{Status: http.StatusMovedPermanently, Target: p + "/"}, true
+	}
+
+	for _, rule := range t.rules {
+		params, ok := rule.match(p)
+		if !ok {
+			continue
+		}
+		return Result{Status: rule.Status, Target: rule.expand(params), Proxy: rule.proxy != nil}, true
+	}
+	return Result{}, false
+}
+
+// Rewriter applies the current table to requests. The table can be
+// swapped on config reload.
+type Rewriter struct {
+	table atomic.Pointer[Table]
+}
+
+// NewRewriter returns a rewriter applying table
+func NewRewriter(table *Table) *Rewriter {
+	rw := &Rewriter{}
+	rw.SetTable(table)
+	return rw
+}
+
+// SetTable replaces the rules for subsequent requests
+func (rw *Rewriter) SetTable(table *Table) {
+	rw.table.Store(table)
+}
+
+// Middleware applies the rules to routes under mount (e.g.
+// "/v1/media/assets") whose key is the "path" route variable. Redirects
+// keep the mount, so "/old/*  /new/:splat" sends /v1/media/assets/old/a
+// to /v1/media/assets/new/a. Rewrites swap the key before next runs.
+// A nil Rewriter passes requests through.
+func (rw *Rewriter) Middleware(mount string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if rw == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			table := rw.table.Load()
+			if table == nil || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := mux.Vars(r)["path"]
+			res, ok := table.Lookup("/" + key)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch {
+			case res.Proxy:
+				proxy(w, r, res.Target)
+			case res.Status == http.StatusOK:
+				target, _, _ := strings.Cut(res.Target, "?")
+				newKey := strings.TrimPrefix(target, "/")
+				r.URL.Path = mount + target
+				r.URL.RawPath = ""
+				next.ServeHTTP(w, mux.SetURLVars(r, map[string]string{"path": newKey}))
+			default:
+				http.Redirect(w, r, location(mount, res.Target, r.URL.RawQuery), res.Status)
+			}
+		})
+	}
+}
+
+// location builds the redirect Location: absolute targets as given, and
+// paths under mount, keeping the request's query unless the target has one
+func location(mount, target, rawQuery string) string {
+	if !strings.HasPrefix(target, "/") {
+		return target
+	}
+	u := &url.URL{Path: mount + target}
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		u.Path = mount + target[:i]
+		u.RawQuery = target[i+1:]
+	} else {
+		u.RawQuery = rawQuery
+	}
+	return u.String()
+}
+
+// proxy fetches target from another origin and relays the response
+func proxy(w http.ResponseWriter, r *http.Request, target string) {
+	u, err := url.Parse(target)
+	if err != nil {
+		apierror.Write(w, http.StatusBadGateway, apierror.Upstream, "Invalid rewrite target")
+		return
+	}
+	if u.RawQuery == "" {
+		u.RawQuery = r.URL.RawQuery
+	}
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = u
+			pr.Out.Host = u.Host
+			// Credentials for this API must not reach the other origin
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("Cookie")
+			pr.SetXForwarded()
+		},
+	}
+	rp.ServeHTTP(w, r)
+}
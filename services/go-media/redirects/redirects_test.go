@@ -0,0 +1,146 @@
+package redirects
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const rulesFile = `
+# Migrated from the old CDN
+/old/logo.png      /brand/logo.png           301
+/blog/*            /posts/:splat             302
+/img/:year/:name   /images/:year/:name       200!
+/legacy/*          %s/files/:splat           200
+/docs              https://docs.example.com  308
+`
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(strings.NewReader(strings.Replace(rulesFile, "%s", "https://old.example", 1)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(rules) != 5 {
+		t.Fatalf("len(rules) = %d, want 5", len(rules))
+	}
+	if got := rules[2]; got.From != "/img/:year/:name" || got.Status != 200 {
+		t.Errorf("rules[2] = %+v, want forced 200 rewrite", got)
+	}
+
+	for _, bad := range []string{"/only-from\n", "/a /b moved\n"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("Parse(%q) error = %v, want a line 1 error", bad, err)
+		}
+	}
+}
+
+func TestNewTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"bad status", Rule{From: "/a", To: "/b", Status: 404}},
+		{"relative from", Rule{From: "a", To: "/b", Status: 301}},
+		{"inner splat", Rule{From: "/a/*/b", To: "/b", Status: 301}},
+		{"relative target", Rule{From: "/a", To: "b", Status: 301}},
+		{"bad scheme", Rule{From: "/a", To: "ftp://x/b", Status: 200}},
+	}
+	for _, tt := range tests {
+		if _, err := NewTable([]Rule{tt.rule}, ""); err == nil {
+			t.Errorf("%s: NewTable() error = nil", tt.name)
+		}
+	}
+	if _, err := NewTable(nil, "sometimes"); err == nil {
+		t.Error("NewTable() accepted an unknown trailing slash policy")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	rules, _ := Parse(strings.NewReader(strings.Replace(rulesFile, "%s", "https://old.example", 1)))
+
+	tests := []struct {
+		trailingSlash string
+		path          string
+		want          Result
+		wantOK        bool
+	}{
+		{"", "/old/logo.png", Result{Status: 301, Target: "/brand/logo.png"}, true},
+		{"", "/blog/2024/hello world.html", Result{Status: 302, Target: "/posts/2024/hello world.html"}, true},
+		{"", "/blog", Result{Status: 302, Target: "/posts/"}, true},
+		{"", "/blogger/a", Result{}, false},
+		{"", "/img/2023/cat.png", Result{Status: 200, Target: "/images/2023/cat.png"}, true},
+		{"", "/img/2023", Result{}, false},
+		{"", "/legacy/a b.zip", Result{Status: 200, Target: "https://old.example/files/a%20b.zip", Proxy: true}, true},
+		{"", "/docs", Result{Status: 308, Target: "https://docs.example.com"}, true},
+		{"remove", "/downloads/", Result{Status: 301, Target: "/downloads"}, true},
+		{"add", "/downloads", Result{Status: 301, Target: "/downloads/"}, true},
+		{"add", "/downloads/app.zip", Result{}, false},
+	}
+	for _, tt := range tests {
+		table, err := NewTable(rules, tt.trailingSlash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := table.Lookup(tt.path)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Lookup(%q) with trailing slash %q = %+v, %v, want %+v, %v", tt.path, tt.trailingSlash, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Error("proxied request kept the Authorization header")
+		}
+		io.WriteString(w, "old:"+r.URL.Path)
+	}))
+	defer origin.Close()
+
+	rules, _ := Parse(strings.NewReader(strings.Replace(rulesFile, "%s", origin.URL, 1)))
+	table, err := NewTable(rules, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := NewRewriter(table)
+
+	router := mux.NewRouter()
+	serve := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "key:"+mux.Vars(r)["path"])
+	})
+	router.Handle("/v1/media/assets/{path:.+}", rw.Middleware("/v1/media/assets")(serve))
+
+	tests := []struct {
+		target       string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"/v1/media/assets/old/logo.png?v=2", 301, "/v1/media/assets/brand/logo.png?v=2", ""},
+		{"/v1/media/assets/blog/a/b.html", 302, "/v1/media/assets/posts/a/b.html", ""},
+		{"/v1/media/assets/docs", 308, "https://docs.example.com", ""},
+		{"/v1/media/assets/img/2023/cat.png", 200, "", "key:images/2023/cat.png"},
+		{"/v1/media/assets/legacy/app.zip", 200, "", "old:/files/app.zip"},
+		{"/v1/media/assets/assets/a.png", 200, "", "key:assets/a.png"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.target, rec.Code, tt.wantCode)
+		}
+		if got := rec.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("%s: Location = %q, want %q", tt.target, got, tt.wantLocation)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.target, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package redirects applies redirect and rewrite rules to public asset
+// paths before they are looked up in storage, so URLs from an old CDN
+// keep working after a migration. Rules use the Netlify _redirects
+// syntax:
+//
+//	# from                 to                          status
+//	/old/logo.png          /brand/logo.png             301
+//	/blog/*                /posts/:splat               302
+//	/img/:year/:name       /images/:year/:name         200
+//	/legacy/*              https://old-cdn.example/:splat  200
+//
+// A status of 200 serves the target without redirecting: an internal
+// path is served in place of the requested key, and an absolute URL is
+// fetched through a reverse proxy.
+package redirects
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rule maps requests matching From to To
+type Rule struct {
+	From   string
+	To     string
+	Status int
+}
+
+// Trailing-slash policies applied before the rules
+const (
+	TrailingSlashAdd    = "add"
+	TrailingSlashRemove = "remove"
+)
+
+// Parse reads rules in _redirects format: one "from to [status]" rule
+// per line, with blank lines and # comments ignored. The status defaults
+// to 301; a trailing "!" (force) is accepted and ignored, because rules
+// are always evaluated before storage.
+func Parse(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want \"from to [status]\", got %q", line, strings.TrimSpace(text))
+		}
+
+		rule := Rule{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(strings.TrimSuffix(fields[2], "!"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid status %q", line, fields[2])
+			}
+			rule.Status = status
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// compiledRule is a Rule with its From pattern split into segments
+type compiledRule struct {
+	Rule
+	segments []string
+	splat    bool
+	proxy    *url.URL
+}
+
+func compile(rule Rule) (compiledRule, error) {
+	switch rule.Status {
+	case http.StatusOK, http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return compiledRule{}, fmt.Errorf("rule %s: status must be 200, 301, 302, 307 or 308, got %d", rule.From, rule.Status)
+	}
+	if !strings.HasPrefix(rule.From, "/") {
+		return compiledRule{}, fmt.Errorf("rule %s: from must start with /", rule.From)
+	}
+
+	c := compiledRule{Rule: rule}
+	from := rule.From
+	if strings.HasSuffix(from, "/*") {
+		c.splat = true
+		from = strings.TrimSuffix(from, "*")
+	} else if strings.Contains(from, "*") {
+		return compiledRule{}, fmt.Errorf("rule %s: * is only allowed as the last segment", rule.From)
+	}
+	c.segments = strings.Split(from, "/")
+
+	target, err := url.Parse(rule.To)
+	switch {
+	case err != nil:
+		return compiledRule{}, fmt.Errorf("rule %s: invalid target: %w", rule.From, err)
+	case target.IsAbs():
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return compiledRule{}, fmt.Errorf("rule %s: target scheme must be http or https", rule.From)
+		}
+		if rule.Status == http.StatusOK {
+			c.proxy = target
+		}
+	case !strings.HasPrefix(rule.To, "/"):
+		return compiledRule{}, fmt.Errorf("rule %s: target must be an absolute URL or start with /", rule.From)
+	}
+	return c, nil
+}
+
+// match returns the placeholder values when path matches the rule
+func (c compiledRule) match(path string) (map[string]string, bool) {
+	parts := strings.Split(path, "/")
+	fixed := c.segments
+	if c.splat {
+		// "/blog/*" matches "/blog" and everything under "/blog/"
+		fixed = c.segments[:len(c.segments)-1]
+		if len(parts) < len(fixed) {
+			return nil, false
+		}
+	} else if len(parts) != len(fixed) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, seg := range fixed {
+		switch {
+		case strings.HasPrefix(seg, ":") && len(seg) > 1:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg[1:]] = parts[i]
+		case seg != parts[i]:
+			return nil, false
+		}
+	}
+	if c.splat {
+		params["splat"] = strings.Join(parts[len(fixed):], "/")
+	}
+	return params, true
+}
+
+// expand substitutes :name placeholders in the rule's target, longest
+// names first so :splat is not read as :s followed by "plat". Values
+// are escaped for absolute URL targets; path targets stay decoded.
+func (c compiledRule) expand(params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	absolute := !strings.HasPrefix(c.To, "/")
+	to := c.To
+	for _, name := range names {
+		value := params[name]
+		if absolute {
+			value = strings.ReplaceAll(url.PathEscape(value), "%2F", "/")
+		}
+		to = strings.ReplaceAll(to, ":"+name, value)
+	}
+	return to
+}
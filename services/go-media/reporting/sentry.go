@@ -0,0 +1,361 @@
+package reporting
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers that must never leave the process in an error report
+var scrubbedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"Proxy-Authorization": true,
+}
+
+type Config struct {
+	DSN         string
+	Environment string
+	Release     string
+	ServerName  string
+}
+
+// Reporter sends errors and panics to a Sentry-compatible endpoint.
+// A nil *Reporter is valid and discards everything, so callers never
+// need to check whether reporting is configured.
+type Reporter struct {
+	storeURL   string
+	authHeader string
+	cfg        Config
+	client     *http.Client
+	events     chan *Event
+	wg         sync.WaitGroup
+
+	// mu guards closed, so nothing is sent on events once it is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+type Event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *RequestInfo      `json:"request,omitempty"`
+	Exception   *ExceptionList    `json:"exception,omitempty"`
+}
+
+type RequestInfo struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+type ExceptionList struct {
+	Values []Exception `json:"values"`
+}
+
+type Exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *Stacktrace `json:"stacktrace,omitempty"`
+}
+
+type Stacktrace struct {
+	Frames []Frame `json:"frames"`
+}
+
+type Frame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// New creates a reporter from a DSN of the form
+// https://<public_key>@<host>/<project_id>. An empty DSN returns a nil
+// reporter, which disables reporting.
+func New(cfg Config) (*Reporter, error) {
+	if cfg.DSN == "" {
+		return nil, nil
+	}
+
+	storeURL, key, err := parseDSN(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName, _ = os.Hostname()
+	}
+
+	r := &Reporter{
+		storeURL:   storeURL,
+		authHeader: fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=cdn-go-media/1.0", key),
+		cfg:        cfg,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		events:     make(chan *Event, 100),
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r, nil
+}
+
+func parseDSN(dsn string) (storeURL string, publicKey string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid DSN: missing public key")
+	}
+
+	path := strings.Trim(u.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	projectID := path[idx+1:]
+	if projectID == "" {
+		return "", "", fmt.Errorf("invalid DSN: missing project id")
+	}
+
+	prefix := ""
+	if idx >= 0 {
+		prefix = "/" + path[:idx]
+	}
+
+	storeURL = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, projectID)
+	return storeURL, u.User.Username(), nil
+}
+
+// CaptureError reports a handler error with the request attached
+func (r *Reporter) CaptureError(req *http.Request, err error) {
+	if r == nil || err == nil {
+		return
+	}
+
+	event := r.newEvent("error", req)
+	event.Exception = &ExceptionList{Values: []Exception{{
+		Type:       fmt.Sprintf("%T", err),
+		Value:      err.Error(),
+		Stacktrace: stacktrace(3),
+	}}}
+	r.enqueue(event)
+}
+
+// CapturePanic reports a recovered panic value with the request attached
+func (r *Reporter) CapturePanic(req *http.Request, recovered interface{}) {
+	if r == nil {
+		return
+	}
+
+	event := r.newEvent("fatal", req)
+	event.Exception = &ExceptionList{Values: []Exception{{
+		Type:       "panic",
+		Value:      fmt.Sprint(recovered),
+		Stacktrace: stacktrace(4),
+	}}}
+	r.enqueue(event)
+}
+
+// CaptureMessage reports a plain message, e.g. a 5xx response without
+// an underlying Go error
+func (r *Reporter) CaptureMessage(req *http.Request, level, message string) {
+	if r == nil {
+		return
+	}
+
+	event := r.newEvent(level, req)
+	event.Message = message
+	r.enqueue(event)
+}
+
+// Close drains queued events, waiting at most timeout. Events captured
+// afterwards are dropped.
+func (r *Reporter) Close(timeout time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.events)
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Error reporter: timed out flushing events")
+	}
+}
+
+func (r *Reporter) newEvent(level string, req *http.Request) *Event {
+	event := &Event{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC(),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "go-media",
+		ServerName:  r.cfg.ServerName,
+		Release:     r.cfg.Release,
+		Environment: r.cfg.Environment,
+	}
+
+	if req != nil {
+		event.Request = requestInfo(req)
+		event.Tags = map[string]string{
+			"method": req.Method,
+		}
+		if route := req.URL.Path; route != "" {
+			event.Tags["path"] = route
+		}
+	}
+
+	return event
+}
+
+func (r *Reporter) enqueue(event *Event) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.events <- event:
+	default:
+		// Never block a request on error reporting
+		log.Printf("Error reporter: queue full, dropping event %s", event.EventID)
+	}
+}
+
+func (r *Reporter) run() {
+	defer r.wg.Done()
+	for event := range r.events {
+		if err := r.send(event); err != nil {
+			log.Printf("Error reporter: failed to send event %s: %v", event.EventID, err)
+		}
+	}
+}
+
+func (r *Reporter) send(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.authHeader)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func requestInfo(req *http.Request) *RequestInfo {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	headers := make(map[string]string, len(req.Header))
+	for name, values := range req.Header {
+		if scrubbedHeaders[http.CanonicalHeaderKey(name)] {
+			headers[name] = "[Filtered]"
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	return &RequestInfo{
+		URL:         fmt.Sprintf("%s://%s%s", scheme, req.Host, req.URL.Path),
+		Method:      req.Method,
+		QueryString: scrubQuery(req.URL.Query()),
+		Headers:     headers,
+		Env:         map[string]string{"REMOTE_ADDR": req.RemoteAddr},
+	}
+}
+
+// scrubQuery removes signatures from signed URLs before they are reported
+func scrubQuery(q url.Values) string {
+	if q.Has("sig") {
+		q.Set("sig", "[Filtered]")
+	}
+	return q.Encode()
+}
+
+func stacktrace(skip int) *Stacktrace {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var out []Frame
+	for {
+		frame, more := frames.Next()
+		out = append(out, Frame{
+			Function: frame.Function,
+			Filename: frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.Contains(frame.Function, "cdn/services/go-media"),
+		})
+		if !more {
+			break
+		}
+	}
+
+	// Sentry expects the innermost frame last
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return &Stacktrace{Frames: out}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package reporting
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		name      string
+		dsn       string
+		wantURL   string
+		wantKey   string
+		wantError bool
+	}{
+		{
+			name:    "standard DSN",
+			dsn:     "https://abc123@o1.ingest.sentry.io/42",
+			wantURL: "https://o1.ingest.sentry.io/api/42/store/",
+			wantKey: "abc123",
+		},
+		{
+			name:    "DSN with path prefix",
+			dsn:     "https://abc123@sentry.example.com/sentry/7",
+			wantURL: "https://sentry.example.com/sentry/api/7/store/",
+			wantKey: "abc123",
+		},
+		{
+			name:      "missing key",
+			dsn:       "https://sentry.example.com/7",
+			wantError: true,
+		},
+		{
+			name:      "missing project",
+			dsn:       "https://abc123@sentry.example.com/",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotURL, gotKey, err := parseDSN(tt.dsn)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("parseDSN() error = %v, wantError %v", err, tt.wantError)
+			}
+			if gotURL != tt.wantURL || gotKey != tt.wantKey {
+				t.Errorf("parseDSN() = %q, %q, want %q, %q", gotURL, gotKey, tt.wantURL, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestNilReporterIsNoop(t *testing.T) {
+	var r *Reporter
+	req := httptest.NewRequest("GET", "/test", nil)
+
+	r.CaptureError(req, errors.New("boom"))
+	r.CapturePanic(req, "boom")
+	r.CaptureMessage(req, "error", "boom")
+	r.Close(time.Second)
+}
+
+func TestCaptureErrorSendsEvent(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("missing auth header, got %q", r.Header.Get("X-Sentry-Auth"))
+		}
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "http://", "http://public@", 1) + "/1"
+	r, err := New(Config{DSN: dsn, Environment: "test"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/media/private/doc.pdf?exp=1&sig=secret", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	r.CaptureError(req, errors.New("storage unavailable"))
+	r.Close(5 * time.Second)
+
+	event := <-received
+	if event.Exception == nil || event.Exception.Values[0].Value != "storage unavailable" {
+		t.Errorf("unexpected exception: %+v", event.Exception)
+	}
+	if event.Request.Headers["Authorization"] != "[Filtered]" {
+		t.Errorf("Authorization header was not scrubbed: %q", event.Request.Headers["Authorization"])
+	}
+	if strings.Contains(event.Request.QueryString, "secret") {
+		t.Errorf("signature was not scrubbed: %q", event.Request.QueryString)
+	}
+	if event.Environment != "test" {
+		t.Errorf("Environment = %q, want test", event.Environment)
+	}
+}
+
+func TestCaptureAfterCloseIsNoop(t *testing.T) {
+	r, err := New(Config{DSN: "http://public@127.0.0.1:1/1"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r.Close(time.Second)
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	r.CaptureError(req, errors.New("late"))
+	r.CapturePanic(req, "late")
+	r.CaptureMessage(req, "error", "late")
+	r.Close(time.Second)
+}
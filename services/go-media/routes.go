@@ -0,0 +1,360 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/adminui"
+	"github.com/WomB0ComB0/cdn/services/go-media/apierror"
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/dav"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/headerrules"
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+	"github.com/WomB0ComB0/cdn/services/go-media/middleware"
+	"github.com/WomB0ComB0/cdn/services/go-media/openapi"
+	"github.com/WomB0ComB0/cdn/services/go-media/redirects"
+	"github.com/WomB0ComB0/cdn/services/go-media/reporting"
+	"github.com/gorilla/mux"
+)
+
+// routeDeps are the components the HTTP routes are wired to
+type routeDeps struct {
+	cfg         *config.Config
+	media       *handlers.MediaHandler
+	probes      *handlers.Probes
+	auditLog    *audit.Log
+	reporter    *reporting.Reporter
+	drainer     *middleware.Drainer
+	uploadLimit func(http.Handler) http.Handler
+	redirects   *redirects.Rewriter
+	headers     *headerrules.Injector
+	qos         *middleware.Limiter
+	metrics     *metrics.Registry
+	delivery    *middleware.Delivery
+	mirror      *middleware.Mirror
+	chaos       *middleware.Chaos
+}
+
+// newHandler is the router behind API versioning: /v2 requests are
+// served by the /v1 routes, and /v1 API responses announce its
+// retirement once configured. Asset URLs, which pages embed and handlers
+// hand out, stay under /v1 for good.
+func newHandler(d routeDeps) http.Handler {
+	deprecated, sunset, _ := d.cfg.API.V1Dates()
+	versions := middleware.Versions{
+		Deprecated: deprecated,
+		Sunset:     sunset,
+		Link:       d.cfg.API.MigrationURL,
+		Stable:     []string{"/v1/media/assets/", "/v1/media/private/", "/v1/media/og", "/v1/media/qr", "/v1/media/placeholder/"},
+		Skip: func(r *http.Request) bool {
+			return d.media.OnCustomDomain(r, nil)
+		},
+	}
+	return versions.Middleware(newRouter(d))
+}
+
+// newRouter registers every route with its per-route middleware. Routes
+// must stay in sync with openapi/openapi.json (checked in routes_test.go).
+func newRouter(d routeDeps) *mux.Router {
+	cfg, mediaHandler, auditLog := d.cfg, d.media, d.auditLog
+
+	router := mux.NewRouter()
+	// Requests no route matches get the same error bodies as the rest
+	router.NotFoundHandler = middleware.RequestID(apierror.Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Not found")
+	})))
+	router.MethodNotAllowedHandler = middleware.RequestID(apierror.Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+	})))
+
+	// Apply middleware
+	router.Use(middleware.RequestID)
+	router.Use(apierror.Negotiate)
+	router.Use(middleware.Logger)
+	router.Use(middleware.Recovery(d.reporter))
+	router.Use(middleware.SecurityHeaders)
+
+	// Per-route CORS: public assets for any origin by default, uploads and
+	// the JSON API only for configured origins
+	corsPolicy := func(origins []string, credentials bool, methods ...string) func(http.Handler) http.Handler {
+		return middleware.CORS(middleware.CORSPolicy{
+			AllowedOrigins:   origins,
+			AllowedMethods:   methods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   cfg.CORS.ExposedHeaders,
+			MaxAge:           time.Duration(cfg.CORS.MaxAgeSeconds) * time.Second,
+			AllowCredentials: credentials,
+		})
+	}
+	assetCORS := corsPolicy(cfg.CORS.AssetOrigins, false, "GET", "HEAD")
+	uploadCORS := corsPolicy(cfg.CORS.UploadOrigins, cfg.CORS.AllowCredentials, "POST")
+	apiCORS := corsPolicy(cfg.CORS.APIOrigins, cfg.CORS.AllowCredentials, "GET", "POST", "DELETE")
+
+	// Per-route limits: JSON APIs are short and bounded, uploads and asset
+	// streams may run far longer than the server-wide timeouts
+	timeouts := cfg.Server.Timeouts
+	apiTimeout := time.Duration(timeouts.APISeconds) * time.Second
+	uploadTimeout := time.Duration(timeouts.UploadSeconds) * time.Second
+	assetTimeout := time.Duration(timeouts.AssetSeconds) * time.Second
+	jsonAPI := func(next http.Handler) http.Handler {
+		return apiCORS(middleware.Timeout(apiTimeout)(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(next)))
+	}
+	streaming := func(next http.Handler) http.Handler {
+		return assetCORS(d.mirror.Middleware(middleware.Deadlines(apiTimeout, assetTimeout)(d.delivery.Middleware(next))))
+	}
+
+	// Load shedding classes: asset reads are interactive; listings,
+	// archives and other batch work are bulk; the rest is standard.
+	// Injected faults, in staging, apply within them, so an injected delay
+	// holds a slot as a real one would.
+	class := func(p middleware.Priority) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return d.qos.Middleware(p)(d.chaos.Middleware(next))
+		}
+	}
+	interactive := class(middleware.PriorityInteractive)
+	standard := class(middleware.PriorityStandard)
+	bulk := class(middleware.PriorityBulk)
+
+	// Read-only replicas refuse every route that modifies the bucket or
+	// the edge cache
+	mutating := middleware.ReadOnly(cfg.Server.ReadOnly)
+
+	// Verified custom domains serve their prefix at their root, in place
+	// of every other route
+	router.MatcherFunc(mediaHandler.OnCustomDomain).Handler(
+		interactive(streaming(d.headers.Middleware(http.HandlerFunc(mediaHandler.ServeCustomDomain)))))
+
+	// Configured files at well-known paths, such as /robots.txt
+	router.MatcherFunc(mediaHandler.OnWellKnown).Methods("GET", "HEAD", "OPTIONS").Handler(
+		interactive(streaming(http.HandlerFunc(mediaHandler.ServeWellKnown))))
+
+	// Health checks: /healthz for liveness, /readyz for readiness
+	router.HandleFunc("/health", handlers.HealthCheck).Methods("GET")
+	router.HandleFunc("/healthz", d.probes.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", d.probes.Readiness).Methods("GET")
+	router.HandleFunc("/health/detailed", d.probes.Readiness).Methods("GET")
+
+	// API description and interactive docs
+	router.HandleFunc("/v1/openapi.json", openapi.ServeSpec).Methods("GET")
+	router.HandleFunc("/docs", openapi.ServeDocs).Methods("GET")
+
+	// Media routes (under /v1/media)
+	api := router.PathPrefix("/v1/media").Subrouter()
+
+	// Upload tokens let browsers upload directly without credentials;
+	// issuing them takes the admin token
+	api.Handle("/upload-token", standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("upload.token")(http.HandlerFunc(mediaHandler.IssueUploadToken)))))).Methods("POST", "OPTIONS")
+
+	// Chunked uploads. Only starting one counts against the upload rate
+	// limit; its chunks, status checks and completion don't.
+	chunkCORS := corsPolicy(cfg.CORS.UploadOrigins, cfg.CORS.AllowCredentials, "GET", "POST", "PUT", "DELETE")
+	chunked := func(next http.Handler) http.Handler {
+		return mutating(standard(chunkCORS(middleware.Deadlines(uploadTimeout, uploadTimeout)(d.drainer.Middleware(next)))))
+	}
+	api.Handle("/upload/chunk", chunked(d.uploadLimit(http.HandlerFunc(mediaHandler.StartChunkedUpload)))).Methods("POST", "OPTIONS")
+	api.Handle("/upload/chunk/{id}", chunked(http.HandlerFunc(mediaHandler.ChunkedUploadStatus))).Methods("GET", "OPTIONS")
+	api.Handle("/upload/chunk/{id}", chunked(http.HandlerFunc(mediaHandler.AbortChunkedUpload))).Methods("DELETE")
+	api.Handle("/upload/chunk/{id}/complete", chunked(auditLog.Middleware("asset.upload")(http.HandlerFunc(mediaHandler.CompleteChunkedUpload)))).Methods("POST", "OPTIONS")
+	api.Handle("/upload/chunk/{id}/{n}", chunked(http.HandlerFunc(mediaHandler.PutChunk))).Methods("PUT", "OPTIONS")
+
+	// Upload endpoints (with rate limiting)
+	uploadRouter := api.PathPrefix("/upload").Subrouter()
+	uploadRouter.Use(mutating)
+	uploadRouter.Use(standard)
+	uploadRouter.Use(uploadCORS)
+	uploadRouter.Use(middleware.Deadlines(uploadTimeout, uploadTimeout))
+	uploadRouter.Use(d.drainer.Middleware)
+	uploadRouter.Use(d.uploadLimit)
+	uploadRouter.Handle("", auditLog.Middleware("asset.upload")(http.HandlerFunc(mediaHandler.Upload))).Methods("POST", "OPTIONS")
+	uploadRouter.Handle("/multipart", auditLog.Middleware("asset.upload")(http.HandlerFunc(mediaHandler.MultipartUpload))).Methods("POST", "OPTIONS")
+
+	// Asset serving with ETag and Range support, after redirect rules,
+	// with the configured extra headers
+	assetRedirects := d.redirects.Middleware("/v1/media/assets")
+	assetHeaders := d.headers.Middleware
+	api.Handle("/assets/{path:.+}", interactive(streaming(assetRedirects(assetHeaders(http.HandlerFunc(mediaHandler.ServeAsset)))))).Methods("GET", "HEAD", "OPTIONS")
+
+	// Social cards for Open Graph previews, rendered once per set of
+	// parameters
+	api.Handle("/og", interactive(streaming(http.HandlerFunc(mediaHandler.SocialCard)))).Methods("GET", "HEAD", "OPTIONS")
+	// QR codes, for share links and tickets
+	api.Handle("/qr", interactive(streaming(http.HandlerFunc(mediaHandler.QRCode)))).Methods("GET", "HEAD", "OPTIONS")
+	// Placeholder images, at /placeholder/600x400
+	api.Handle("/placeholder/{size}", interactive(streaming(http.HandlerFunc(mediaHandler.Placeholder)))).Methods("GET", "HEAD", "OPTIONS")
+
+	// Signed URL generation
+	api.Handle("/sign", standard(jsonAPI(auditLog.Middleware("url.sign")(http.HandlerFunc(mediaHandler.GenerateSignedURL))))).Methods("POST", "OPTIONS")
+
+	// Signed image variant URLs, for deployments requiring them
+	api.Handle("/images/sign", standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("image.sign")(http.HandlerFunc(mediaHandler.SignImageURL)))))).Methods("POST", "OPTIONS")
+
+	// Focal points kept in view by cover crops
+	api.Handle("/images/focal-point", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("image.focal_point")(http.HandlerFunc(mediaHandler.UpdateFocalPoint))))))).Methods("POST", "OPTIONS")
+
+	// Private asset serving (requires signature validation)
+	api.Handle("/private/{path:.+}", interactive(streaming(assetHeaders(http.HandlerFunc(mediaHandler.ServePrivateAsset))))).Methods("GET", "HEAD", "OPTIONS")
+
+	// Cache purge endpoint
+	api.Handle("/purge", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("cache.purge")(http.HandlerFunc(mediaHandler.PurgeCache))))))).Methods("POST", "OPTIONS")
+
+	// List assets
+	api.Handle("/list", bulk(jsonAPI(http.HandlerFunc(mediaHandler.ListAssets)))).Methods("GET", "OPTIONS")
+
+	// Full-text search of asset keys and extracted text
+	api.Handle("/search", bulk(jsonAPI(http.HandlerFunc(mediaHandler.Search)))).Methods("GET", "OPTIONS")
+
+	// Logical paths of the assets under a prefix, mapped to fingerprinted
+	// URLs and integrity hashes for build tools
+	api.Handle("/manifest", bulk(jsonAPI(http.HandlerFunc(mediaHandler.AssetManifest)))).Methods("GET", "OPTIONS")
+
+	// Which of a batch of keys exist, with their ETags
+	api.Handle("/exists", bulk(jsonAPI(http.HandlerFunc(mediaHandler.Exists)))).Methods("POST", "OPTIONS")
+
+	// Zip bundle of several assets, streamed as it is assembled
+	api.Handle("/bundle", bulk(apiCORS(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(
+		middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.Bundle)))))).Methods("POST", "OPTIONS")
+
+	// Collections: named, ordered sets of assets, zipped on request
+	collectionCORS := corsPolicy(cfg.CORS.APIOrigins, cfg.CORS.AllowCredentials, "GET", "POST", "PUT", "DELETE")
+	collectionAPI := func(next http.Handler) http.Handler {
+		return collectionCORS(middleware.Timeout(apiTimeout)(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(next)))
+	}
+	api.Handle("/collections", standard(collectionAPI(http.HandlerFunc(mediaHandler.ListCollections)))).Methods("GET", "OPTIONS")
+	api.Handle("/collections", mutating(standard(collectionAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("collection.create")(http.HandlerFunc(mediaHandler.CreateCollection))))))).Methods("POST")
+	api.Handle("/collections/{id}", standard(collectionAPI(http.HandlerFunc(mediaHandler.GetCollection)))).Methods("GET", "OPTIONS")
+	api.Handle("/collections/{id}", mutating(standard(collectionAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("collection.update")(http.HandlerFunc(mediaHandler.UpdateCollection))))))).Methods("PUT")
+	api.Handle("/collections/{id}", mutating(standard(collectionAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("collection.delete")(http.HandlerFunc(mediaHandler.DeleteCollection))))))).Methods("DELETE")
+	api.Handle("/collections/{id}/bundle", bulk(collectionCORS(middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.CollectionBundle))))).Methods("GET", "OPTIONS")
+
+	// Delete asset
+	api.Handle("/delete/{path:.+}", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("asset.delete")(http.HandlerFunc(mediaHandler.DeleteAsset))))))).Methods("DELETE", "OPTIONS")
+
+	// Delete everything under a prefix: a dry run lists it and issues the
+	// token that confirms the deletion. Up to a thousand deletes can
+	// outlast the API timeout.
+	api.Handle("/prefix/{path:.+}", mutating(bulk(apiCORS(middleware.Deadlines(apiTimeout, assetTimeout)(
+		middleware.AdminAuth(cfg.AdminToken)(auditLog.Middleware("asset.delete_prefix")(http.HandlerFunc(mediaHandler.DeletePrefix)))))))).Methods("DELETE", "OPTIONS")
+
+	// Publish a draft or archived asset, or archive one
+	api.Handle("/promote/{path:.+}", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("asset.promote")(http.HandlerFunc(mediaHandler.PromoteAsset))))))).Methods("POST", "OPTIONS")
+	api.Handle("/archive/{path:.+}", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("asset.archive")(http.HandlerFunc(mediaHandler.ArchiveAsset))))))).Methods("POST", "OPTIONS")
+
+	// Favicon and app icon set of an image
+	api.Handle("/favicons/{path:.+}", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("asset.favicons")(http.HandlerFunc(mediaHandler.Favicons))))))).Methods("POST", "OPTIONS")
+
+	// Size, type, checksums, dimensions, metadata and URLs of an asset
+	api.Handle("/info/{path:.+}", standard(jsonAPI(http.HandlerFunc(mediaHandler.AssetInfo)))).Methods("GET", "OPTIONS")
+
+	// Cloudflare Images / Stream copy of an asset and its state
+	api.Handle("/offload/{path:.+}", standard(jsonAPI(http.HandlerFunc(mediaHandler.OffloadStatus)))).Methods("GET", "OPTIONS")
+
+	// Per-asset analytics and top-N report
+	api.Handle("/analytics", standard(jsonAPI(http.HandlerFunc(mediaHandler.TopAssets)))).Methods("GET", "OPTIONS")
+	api.Handle("/analytics/{path:.+}", standard(jsonAPI(http.HandlerFunc(mediaHandler.AssetAnalytics)))).Methods("GET", "OPTIONS")
+
+	// Static site deploys, each served immutably at /deploys/{id}/ and the
+	// live one at /latest/. Creating a deploy copies up to a thousand
+	// objects, so it isn't bound by the API timeout.
+	api.Handle("/deploys", standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(http.HandlerFunc(mediaHandler.ListDeploys))))).Methods("GET", "OPTIONS")
+	api.Handle("/deploys", mutating(bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(
+			middleware.Deadlines(apiTimeout, assetTimeout)(auditLog.Middleware("deploy.create")(http.HandlerFunc(mediaHandler.CreateDeploy))))))))).Methods("POST")
+	api.Handle("/deploys/{id}/activate", mutating(standard(jsonAPI(middleware.AdminAuth(cfg.AdminToken)(
+		auditLog.Middleware("deploy.activate")(http.HandlerFunc(mediaHandler.ActivateDeploy))))))).Methods("POST", "OPTIONS")
+	router.Handle("/deploys/{id}/", interactive(streaming(assetHeaders(http.HandlerFunc(mediaHandler.ServeDeploy))))).Methods("GET", "HEAD", "OPTIONS")
+	router.Handle("/deploys/{id}/{path:.+}", interactive(streaming(assetHeaders(http.HandlerFunc(mediaHandler.ServeDeploy))))).Methods("GET", "HEAD", "OPTIONS")
+	router.Handle("/latest/", interactive(streaming(assetHeaders(http.HandlerFunc(mediaHandler.ServeLatest))))).Methods("GET", "HEAD", "OPTIONS")
+	router.Handle("/latest/{path:.+}", interactive(streaming(assetHeaders(http.HandlerFunc(mediaHandler.ServeLatest))))).Methods("GET", "HEAD", "OPTIONS")
+
+	// WebDAV mount of the bucket (Basic auth, disabled without a password)
+	davHandler := dav.NewHandler("/dav", mediaHandler,
+		dav.WithAuditLog(auditLog),
+		dav.WithReadOnly(cfg.DAV.ReadOnly || cfg.Server.ReadOnly),
+		dav.WithMaxFileSize(int64(cfg.Server.Limits.MaxUploadBytes)),
+	)
+	davRoute := standard(middleware.BasicAuth("cdn", cfg.DAV.Username, cfg.DAV.Password)(
+		middleware.Deadlines(uploadTimeout, assetTimeout)(d.drainer.Middleware(davHandler))))
+	router.Handle("/dav", davRoute)
+	router.PathPrefix("/dav/").Handler(davRoute)
+
+	// Embedded admin UI; the page asks for the admin token and sends it
+	// with every API call
+	router.Handle("/admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+	router.PathPrefix("/admin/").Handler(adminui.Handler("/admin/"))
+
+	// Tar export of a prefix for backups. It streams for as long as the
+	// export takes, so it is registered apart from the buffered admin
+	// JSON routes below.
+	router.Handle("/v1/admin/export", bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.Export)))))).Methods("GET", "HEAD")
+
+	// Edge cache prewarming fetches up to a thousand objects, which can
+	// outlast the buffered admin routes' timeout
+	router.Handle("/v1/admin/prewarm", bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(
+			middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.Prewarm))))))).Methods("POST")
+
+	// Variant regeneration rebuilds up to a thousand assets' variants
+	router.Handle("/v1/media/derived/regenerate", mutating(bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(
+			middleware.Deadlines(apiTimeout, assetTimeout)(auditLog.Middleware("derived.regenerate")(http.HandlerFunc(mediaHandler.RegenerateDerived))))))))).Methods("POST")
+
+	// Index reconciliation walks the whole bucket, so it is not bound
+	// by the API timeout either
+	router.Handle("/v1/admin/reconcile", bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.ReconcileStatus)))))).Methods("GET", "POST")
+
+	// The metadata index, for cdn-backup to save with the bucket's
+	// objects and put back after restoring them
+	router.Handle("/v1/admin/index", bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.ExportIndex)))))).Methods("GET")
+	router.Handle("/v1/admin/index", mutating(bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(
+			middleware.Deadlines(apiTimeout, assetTimeout)(auditLog.Middleware("index.import")(http.HandlerFunc(mediaHandler.ImportIndex))))))))).Methods("POST")
+
+	// Restoring from the trash and measuring it take as long as the
+	// objects they cover, like reconciliation
+	router.Handle("/v1/admin/trash/usage", bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.Deadlines(apiTimeout, assetTimeout)(http.HandlerFunc(mediaHandler.TrashUsage)))))).Methods("GET")
+	router.Handle("/v1/admin/trash/restore", mutating(bulk(middleware.AdminAuth(cfg.AdminToken)(
+		apiCORS(middleware.MaxBodySize(int64(cfg.Server.Limits.MaxJSONBodyBytes))(
+			middleware.Deadlines(apiTimeout, assetTimeout)(auditLog.Middleware("trash.restore")(http.HandlerFunc(mediaHandler.RestoreTrash))))))))).Methods("POST")
+
+	// Admin routes (under /v1/admin, bearer token required)
+	admin := router.PathPrefix("/v1/admin").Subrouter()
+	admin.Use(standard)
+	admin.Use(middleware.AdminAuth(cfg.AdminToken))
+	admin.Use(jsonAPI)
+	admin.HandleFunc("/usage", mediaHandler.Usage).Methods("GET")
+	admin.HandleFunc("/audit", mediaHandler.AuditLog).Methods("GET")
+	admin.HandleFunc("/webhooks/deliveries", mediaHandler.WebhookDeliveries).Methods("GET")
+	admin.HandleFunc("/jobs", mediaHandler.Jobs).Methods("GET")
+	admin.Handle("/metrics", d.metrics).Methods("GET")
+
+	// Deleted objects kept in the trash, where enabled
+	admin.HandleFunc("/trash", mediaHandler.ListTrash).Methods("GET")
+
+	// Custom domains serving a prefix, verified by a DNS TXT record. They
+	// are kept by each instance, so replicas manage their own.
+	admin.HandleFunc("/domains", mediaHandler.ListDomains).Methods("GET")
+	admin.Handle("/domains", auditLog.Middleware("domain.add")(http.HandlerFunc(mediaHandler.AddDomain))).Methods("POST")
+	admin.HandleFunc("/domains/{host}", mediaHandler.GetDomain).Methods("GET")
+	admin.Handle("/domains/{host}", auditLog.Middleware("domain.delete")(http.HandlerFunc(mediaHandler.DeleteDomain))).Methods("DELETE")
+	admin.Handle("/domains/{host}/verify", auditLog.Middleware("domain.verify")(http.HandlerFunc(mediaHandler.VerifyDomain))).Methods("POST")
+
+	return router
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/middleware"
+	"github.com/WomB0ComB0/cdn/services/go-media/openapi"
+	"github.com/gorilla/mux"
+)
+
+// TestRoutesMatchOpenAPI keeps openapi/openapi.json in sync with the router
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	router := newRouter(routeDeps{
+		cfg:         config.Default(),
+		media:       handlers.NewMediaHandler(nil, "secret"),
+		probes:      handlers.NewProbes("test", nil),
+		drainer:     middleware.NewDrainer(),
+		uploadLimit: func(next http.Handler) http.Handler { return next },
+	})
+
+	routes := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // subrouter prefix
+		}
+		if path == "/docs" {
+			return nil
+		}
+		path = strings.ReplaceAll(path, "{path:.+}", "{path}")
+		for _, m := range methods {
+			if m != http.MethodOptions {
+				routes[strings.ToLower(m)+" "+path] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.Spec, &spec); err != nil {
+		t.Fatalf("invalid openapi.json: %v", err)
+	}
+	if spec.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", spec.OpenAPI)
+	}
+
+	documented := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[method+" "+path] = true
+			}
+		}
+	}
+
+	var missing, stale []string
+	for op := range routes {
+		if !documented[op] {
+			missing = append(missing, op)
+		}
+	}
+	for op := range documented {
+		if !routes[op] {
+			stale = append(stale, op)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	for _, op := range missing {
+		t.Errorf("route %s is not documented in openapi.json", op)
+	}
+	for _, op := range stale {
+		t.Errorf("openapi.json documents %s but no route serves it", op)
+	}
+}
@@ -0,0 +1,92 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+	"github.com/aws/smithy-go"
+)
+
+// apiError is an S3 error response
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errAccessDenied                      = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errInvalidAccessKeyID                = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist in our records."}
+	errSignatureDoesNotMatch             = &apiError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+	errRequestTimeTooSkewed              = &apiError{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large."}
+	errExpiredRequest                    = &apiError{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	errSignatureVersionNotSupported      = &apiError{http.StatusBadRequest, "InvalidRequest", "Only AWS Signature Version 4 is supported."}
+	errAuthorizationHeaderMalformed      = &apiError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	errAuthorizationQueryParametersError = &apiError{http.StatusBadRequest, "AuthorizationQueryParametersError", "The presigned URL parameters are malformed."}
+	errMissingContentSHA256              = &apiError{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256"}
+	errContentSHA256Mismatch             = &apiError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+	errBadDigest                         = &apiError{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received."}
+	errInvalidDigest                     = &apiError{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified is not valid."}
+	errIncompleteBody                    = &apiError{http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header."}
+	errEntityTooLarge                    = &apiError{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size."}
+	errInvalidArgument                   = &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid argument."}
+	errQuotaExceeded                     = &apiError{http.StatusForbidden, "QuotaExceeded", "The upload would exceed the storage quota of this access key."}
+	errNoSuchBucket                      = &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist."}
+	errNoSuchKey                         = &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errBucketAlreadyOwnedByYou           = &apiError{http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it."}
+	errPreconditionFailed                = &apiError{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold."}
+	errInvalidRange                      = &apiError{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable."}
+	errMethodNotAllowed                  = &apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource."}
+	errSlowDown                          = &apiError{http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate."}
+	errNotImplemented                    = &apiError{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented."}
+	errInternal                          = &apiError{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+)
+
+// storageError maps R2 errors that clients should see to S3 errors
+func storageError(err error) error {
+	if storage.IsNotFound(err) {
+		return errNoSuchKey
+	}
+	if storage.IsPreconditionFailed(err) {
+		return errPreconditionFailed
+	}
+	if errors.Is(err, handlers.ErrReservedKey) {
+		return errAccessDenied
+	}
+	if errors.Is(err, handlers.ErrInvalidKey) {
+		return &apiError{http.StatusBadRequest, "InvalidArgument", err.Error()}
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "InvalidRange" {
+		return errInvalidRange
+	}
+	return err
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, e *apiError) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, errorResponse{
+		Code:      e.code,
+		Message:   e.message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("x-amz-request-id"),
+	})
+}
@@ -0,0 +1,580 @@
+// Package s3api serves a minimal S3-compatible API (path-style requests
+// signed with SigV4) so tools like rclone and the AWS CLI can manage
+// assets directly, subject to per-key prefix policies and quotas.
+package s3api
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/audit"
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/WomB0ComB0/cdn/services/go-media/middleware"
+	"github.com/WomB0ComB0/cdn/services/go-media/reporting"
+	"github.com/WomB0ComB0/cdn/services/go-media/storage"
+)
+
+const (
+	s3Namespace  = "http://s3.amazonaws.com/doc/2006-03-01/"
+	timeFormat   = "2006-01-02T15:04:05.000Z"
+	maxListKeys  = 1000
+	storageClass = "STANDARD"
+)
+
+// Key is an access key and the policy applied to its requests
+type Key struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefixes limits the key to object keys under these prefixes; empty
+	// allows the whole bucket
+	Prefixes []string
+	ReadOnly bool
+	// MaxObjectBytes and QuotaBytes of 0 mean no per-key limit
+	MaxObjectBytes int64
+	QuotaBytes     int64
+	// Priority is the key's class when load is shed
+	Priority middleware.Priority
+}
+
+// allows reports whether the key may access objectKey
+func (k Key) allows(objectKey string) bool {
+	if len(k.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range k.Prefixes {
+		if strings.HasPrefix(objectKey, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Server implements the S3 API on top of the HTTP handlers' asset
+// operations, so uploads are indexed, published and audited the same way
+type Server struct {
+	bucket        string
+	keys          map[string]Key
+	media         *handlers.MediaHandler
+	index         *index.Index
+	auditLog      *audit.Log
+	reporter      *reporting.Reporter
+	limiter       *middleware.Limiter
+	maxUploadSize int64
+	readOnly      bool
+	created       time.Time
+	now           func() time.Time
+}
+
+// Option configures optional Server dependencies
+type Option func(*Server)
+
+// WithIndex enables per-key quotas, computed from the metadata index
+func WithIndex(idx *index.Index) Option {
+	return func(s *Server) {
+		s.index = idx
+	}
+}
+
+// WithAuditLog records puts and deletes
+func WithAuditLog(l *audit.Log) Option {
+	return func(s *Server) {
+		s.auditLog = l
+	}
+}
+
+// WithReporter forwards storage errors to an error reporter
+func WithReporter(r *reporting.Reporter) Option {
+	return func(s *Server) {
+		s.reporter = r
+	}
+}
+
+// WithLimiter sheds requests by each key's priority under load
+func WithLimiter(l *middleware.Limiter) Option {
+	return func(s *Server) {
+		s.limiter = l
+	}
+}
+
+// WithMaxUploadSize limits the size of a single object for every key
+func WithMaxUploadSize(n int64) Option {
+	return func(s *Server) {
+		s.maxUploadSize = n
+	}
+}
+
+// WithReadOnly denies puts and deletes to every key, for read-only
+// replicas
+func WithReadOnly(readOnly bool) Option {
+	return func(s *Server) {
+		s.readOnly = readOnly
+	}
+}
+
+// NewServer creates an S3 API serving bucket to the given keys
+func NewServer(media *handlers.MediaHandler, bucket string, keys []Key, opts ...Option) *Server {
+	s := &Server{
+		bucket:        bucket,
+		keys:          make(map[string]Key, len(keys)),
+		media:         media,
+		maxUploadSize: 100 << 20,
+		created:       time.Now().UTC(),
+		now:           time.Now,
+	}
+	for _, k := range keys {
+		s.keys[k.AccessKeyID] = k
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Server) lookup(accessKeyID string) (Key, bool) {
+	k, ok := s.keys[accessKeyID]
+	return k, ok
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	rec.Header().Set("x-amz-request-id", newRequestID())
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	sr, err := verifyRequest(r, s.lookup, s.now())
+	if err == nil {
+		err = s.serve(rec, r, sr, bucket, key)
+	}
+	if err != nil {
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			s.reporter.CaptureError(r, err)
+			ae = errInternal
+		}
+		writeError(rec, r, ae)
+	}
+
+	if key != "" && (r.Method == http.MethodPut || r.Method == http.MethodDelete) {
+		s.audit(r, sr, key, rec.status)
+	}
+}
+
+// serve routes a verified request unless its key's priority is being
+// shed
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, sr *signedRequest, bucket, key string) error {
+	if !s.limiter.Acquire(sr.key.Priority) {
+		return errSlowDown
+	}
+	defer s.limiter.Release()
+	return s.route(w, r, sr, bucket, key)
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, sr *signedRequest, bucket, key string) error {
+	query := r.URL.Query()
+
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			return errMethodNotAllowed
+		}
+		return s.listBuckets(w)
+	}
+	if bucket != s.bucket {
+		return errNoSuchBucket
+	}
+
+	// Multipart uploads, batch deletes, ACLs, tagging and versioning are
+	// not supported
+	for _, sub := range []string{"uploads", "uploadId", "delete", "acl", "tagging", "versioning", "versionId", "policy", "lifecycle", "cors"} {
+		if query.Has(sub) {
+			return errNotImplemented
+		}
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			return nil
+		case http.MethodPut:
+			return errBucketAlreadyOwnedByYou
+		case http.MethodGet:
+			if query.Has("location") {
+				writeXML(w, http.StatusOK, locationResponse{Xmlns: s3Namespace})
+				return nil
+			}
+			return s.listObjects(w, r, sr.key)
+		}
+		return errMethodNotAllowed
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		return s.putObject(w, r, sr, key)
+	case http.MethodGet, http.MethodHead:
+		return s.getObject(w, r, sr.key, key)
+	case http.MethodDelete:
+		return s.deleteObject(w, r, sr.key, key)
+	}
+	return errMethodNotAllowed
+}
+
+type listBucketsResponse struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   owner    `xml:"Owner"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type locationResponse struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter) error {
+	writeXML(w, http.StatusOK, listBucketsResponse{
+		Xmlns:   s3Namespace,
+		Owner:   owner{ID: s.bucket, DisplayName: s.bucket},
+		Buckets: []bucket{{Name: s.bucket, CreationDate: s.created.Format(timeFormat)}},
+	})
+	return nil
+}
+
+type listObjectsResponse struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjects serves ListObjectsV2 (list-type=2) and the original
+// ListObjects, whose marker maps onto StartAfter
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, k Key) error {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	if !k.allows(prefix) {
+		return errAccessDenied
+	}
+
+	maxKeys := maxListKeys
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	encode := func(s string) string { return s }
+	urlEncoding := query.Get("encoding-type") == "url"
+	if urlEncoding {
+		encode = func(s string) string { return strings.ReplaceAll(url.QueryEscape(s), "%2F", "/") }
+	}
+
+	v2 := query.Get("list-type") == "2"
+	opts := storage.ListOptions{
+		Prefix:    prefix,
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   int32(maxKeys),
+	}
+	if v2 {
+		opts.StartAfter = query.Get("start-after")
+		opts.ContinuationToken = query.Get("continuation-token")
+	} else {
+		opts.StartAfter = query.Get("marker")
+	}
+
+	page := &storage.ListPage{}
+	if maxKeys > 0 {
+		var err error
+		if page, err = s.media.ListPage(r.Context(), opts); err != nil {
+			return err
+		}
+	}
+
+	resp := listObjectsResponse{
+		Xmlns:       s3Namespace,
+		Name:        s.bucket,
+		Prefix:      encode(prefix),
+		Delimiter:   encode(opts.Delimiter),
+		MaxKeys:     maxKeys,
+		IsTruncated: page.IsTruncated,
+	}
+	if urlEncoding {
+		resp.EncodingType = "url"
+	}
+
+	last := ""
+	for _, o := range page.Objects {
+		resp.Contents = append(resp.Contents, object{
+			Key:          encode(o.Key),
+			LastModified: o.LastModified.UTC().Format(timeFormat),
+			ETag:         quoteETag(o.ETag),
+			Size:         o.Size,
+			StorageClass: storageClass,
+		})
+		if o.Key > last {
+			last = o.Key
+		}
+	}
+	for _, p := range page.CommonPrefixes {
+		resp.CommonPrefixes = append(resp.CommonPrefixes, commonPrefix{Prefix: encode(p)})
+		if p > last {
+			last = p
+		}
+	}
+
+	if v2 {
+		count := len(page.Objects) + len(page.CommonPrefixes)
+		resp.KeyCount = &count
+		resp.StartAfter = encode(opts.StartAfter)
+		resp.ContinuationToken = opts.ContinuationToken
+		resp.NextContinuationToken = page.NextContinuationToken
+	} else {
+		marker := encode(opts.StartAfter)
+		resp.Marker = &marker
+		if page.IsTruncated {
+			resp.NextMarker = encode(last)
+		}
+	}
+
+	writeXML(w, http.StatusOK, resp)
+	return nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, sr *signedRequest, key string) error {
+	k := sr.key
+	if s.readOnly || k.ReadOnly || !k.allows(key) {
+		return errAccessDenied
+	}
+	if r.Header.Get("x-amz-copy-source") != "" {
+		return errNotImplemented
+	}
+
+	limit := s.maxUploadSize
+	if k.MaxObjectBytes > 0 && k.MaxObjectBytes < limit {
+		limit = k.MaxObjectBytes
+	}
+	if r.ContentLength > limit && !strings.HasPrefix(sr.payloadHash, "STREAMING-") {
+		return errEntityTooLarge
+	}
+
+	data, err := sr.readBody(r, limit)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(data)
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		want, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(want) != md5.Size {
+			return errInvalidDigest
+		}
+		if string(want) != string(sum[:]) {
+			return errBadDigest
+		}
+	}
+
+	if err := s.checkQuota(k, key, int64(len(data))); err != nil {
+		return err
+	}
+
+	// If-Match makes the write conditional on the object being unchanged
+	// since the client read it
+	if _, err := s.media.StoreUploadIfMatch(r.Context(), key, path.Base(key), r.Header.Get("Content-Type"), r.Header.Get("If-Match"), data); err != nil {
+		return storageError(err)
+	}
+
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// checkQuota rejects a put of size bytes to objectKey if the key's
+// objects would exceed its quota. Replaced objects don't count twice.
+func (s *Server) checkQuota(k Key, objectKey string, size int64) error {
+	if k.QuotaBytes <= 0 || s.index == nil {
+		return nil
+	}
+
+	used := size
+	for _, e := range s.index.List("") {
+		if e.Key != objectKey && k.allows(e.Key) {
+			used += e.Size
+		}
+	}
+	if used > k.QuotaBytes {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, k Key, key string) error {
+	if !k.allows(key) {
+		return errAccessDenied
+	}
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+
+	if r.Method == http.MethodHead {
+		out, err := s.media.HeadAsset(r.Context(), key)
+		if err != nil {
+			return storageError(err)
+		}
+		setObjectHeaders(h, out.ContentType, out.ContentLength, out.ETag, out.LastModified)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	obj, err := s.media.OpenAssetRange(r.Context(), key, r.Header.Get("Range"))
+	if err != nil {
+		return storageError(err)
+	}
+	defer obj.Body.Close()
+
+	setObjectHeaders(h, obj.ContentType, obj.ContentLength, obj.ETag, obj.LastModified)
+	status := http.StatusOK
+	if obj.ContentRange != nil {
+		h.Set("Content-Range", *obj.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	n, _ := io.Copy(w, obj.Body)
+	s.media.RecordServed(key, n)
+	return nil
+}
+
+func setObjectHeaders(h http.Header, contentType *string, contentLength *int64, etag *string, lastModified *time.Time) {
+	if contentType != nil {
+		h.Set("Content-Type", *contentType)
+	}
+	if contentLength != nil {
+		h.Set("Content-Length", strconv.FormatInt(*contentLength, 10))
+	}
+	if etag != nil {
+		h.Set("ETag", quoteETag(*etag))
+	}
+	if lastModified != nil {
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, k Key, key string) error {
+	if s.readOnly || k.ReadOnly || !k.allows(key) {
+		return errAccessDenied
+	}
+	if err := s.media.RemoveAsset(r.Context(), key); err != nil {
+		return storageError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// audit records a put or delete, including rejected ones, with the
+// access key as the actor
+func (s *Server) audit(r *http.Request, sr *signedRequest, key string, status int) {
+	if s.auditLog == nil {
+		return
+	}
+
+	action := "asset.upload"
+	if r.Method == http.MethodDelete {
+		action = "asset.delete"
+	}
+	rec := audit.Record{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Key:       key,
+		Actor:     "anonymous",
+		SourceIP:  audit.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Status:    status,
+		Result:    "success",
+		Details:   map[string]string{"transport": "s3"},
+	}
+	if sr != nil {
+		rec.Actor = "s3:" + sr.key.AccessKeyID
+	}
+	if status >= 400 {
+		rec.Result = "failure"
+	}
+	s.auditLog.Append(rec)
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
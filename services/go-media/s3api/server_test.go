@@ -0,0 +1,216 @@
+package s3api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/handlers"
+	"github.com/WomB0ComB0/cdn/services/go-media/index"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go"
+)
+
+var testKeys = []Key{
+	{AccessKeyID: "admin", SecretAccessKey: "admin-secret"},
+	{AccessKeyID: "reader", SecretAccessKey: "reader-secret", Prefixes: []string{"static/"}, ReadOnly: true},
+	{AccessKeyID: "deploy", SecretAccessKey: "deploy-secret", Prefixes: []string{"static/"}, MaxObjectBytes: 16, QuotaBytes: 10},
+}
+
+// newSignedRequest builds a request as the server sees it, signed like the
+// AWS SDK signs S3 requests
+func newSignedRequest(t *testing.T, method, target, accessKeyID, secret string, body []byte, at time.Time) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, "http://s3.test"+target, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+
+	signer := v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+	creds := aws.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secret}
+	if err := signer.SignHTTP(context.Background(), creds, req, sha256Hex(body), "s3", "auto", at); err != nil {
+		t.Fatal(err)
+	}
+
+	req.RequestURI = req.URL.RequestURI()
+	return req
+}
+
+func lookupTestKey(id string) (Key, bool) {
+	for _, k := range testKeys {
+		if k.AccessKeyID == id {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+func TestVerifyRequest(t *testing.T) {
+	now := time.Now()
+	body := []byte("hello")
+
+	presign := func(at time.Time) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://s3.test/cdn/static/a%20b.txt?X-Amz-Expires=600", nil)
+		signer := v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+		signed, _, err := signer.PresignHTTP(context.Background(), aws.Credentials{AccessKeyID: "admin", SecretAccessKey: "admin-secret"}, req, unsignedPayload, "s3", "auto", at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, signed, nil)
+		r.Host = "s3.test"
+		return r
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want error
+	}{
+		{"valid", newSignedRequest(t, http.MethodPut, "/cdn/static/app.js", "admin", "admin-secret", body, now), nil},
+		{"escaped key", newSignedRequest(t, http.MethodPut, "/cdn/static/a%20b+c.txt?x-id=PutObject", "admin", "admin-secret", body, now), nil},
+		{"wrong secret", newSignedRequest(t, http.MethodPut, "/cdn/static/app.js", "admin", "nope", body, now), errSignatureDoesNotMatch},
+		{"unknown key", newSignedRequest(t, http.MethodPut, "/cdn/static/app.js", "ghost", "x", body, now), errInvalidAccessKeyID},
+		{"clock skew", newSignedRequest(t, http.MethodPut, "/cdn/static/app.js", "admin", "admin-secret", body, now.Add(-time.Hour)), errRequestTimeTooSkewed},
+		{"unsigned", httptest.NewRequest(http.MethodGet, "/cdn/", nil), errAccessDenied},
+		{"presigned", presign(now), nil},
+		{"presigned expired", presign(now.Add(-time.Hour)), errExpiredRequest},
+	}
+
+	for _, tt := range tests {
+		_, err := verifyRequest(tt.req, lookupTestKey, now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: verifyRequest() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// A tampered payload is caught when the body is read
+	req := newSignedRequest(t, http.MethodPut, "/cdn/static/app.js", "admin", "admin-secret", body, now)
+	req.Body = io.NopCloser(strings.NewReader("jello"))
+	sr, err := verifyRequest(req, lookupTestKey, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sr.readBody(req, 1<<20); err != errContentSHA256Mismatch {
+		t.Errorf("readBody() tampered error = %v, want %v", err, errContentSHA256Mismatch)
+	}
+}
+
+// TestReadChunkedSigned decodes the streaming upload example from the
+// SigV4 documentation (two chunks of 'a' plus the final empty chunk)
+func TestReadChunkedSigned(t *testing.T) {
+	sr := &signedRequest{
+		signingKey: deriveSigningKey("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", "20130524", "us-east-1"),
+		signature:  "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9",
+		amzDate:    "20130524T000000Z",
+		scope:      "20130524/us-east-1/s3/aws4_request",
+	}
+
+	var body bytes.Buffer
+	body.WriteString("10000;chunk-signature=ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648\r\n")
+	body.Write(bytes.Repeat([]byte("a"), 65536))
+	body.WriteString("\r\n400;chunk-signature=0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497\r\n")
+	body.Write(bytes.Repeat([]byte("a"), 1024))
+	body.WriteString("\r\n0;chunk-signature=b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9\r\n\r\n")
+	encoded := body.Bytes()
+
+	data, err := readChunked(bytes.NewReader(encoded), 1<<20, sr.verifyChunk)
+	if err != nil {
+		t.Fatalf("readChunked() error = %v", err)
+	}
+	if len(data) != 66560 {
+		t.Errorf("decoded %d bytes, want 66560", len(data))
+	}
+
+	tampered := bytes.Replace(encoded, []byte("aaaa\r\n400"), []byte("aaab\r\n400"), 1)
+	sr.signature = "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9"
+	if _, err := readChunked(bytes.NewReader(tampered), 1<<20, sr.verifyChunk); err != errSignatureDoesNotMatch {
+		t.Errorf("readChunked() tampered error = %v, want %v", err, errSignatureDoesNotMatch)
+	}
+
+	if _, err := readChunked(bytes.NewReader(encoded), 1000, nil); err != errEntityTooLarge {
+		t.Errorf("readChunked() over limit error = %v, want %v", err, errEntityTooLarge)
+	}
+}
+
+// TestPolicy checks requests that must be rejected before storage is
+// touched (the media handler has no storage client)
+func TestPolicy(t *testing.T) {
+	idx, err := index.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Update("static/existing.css", func(e *index.Entry) { e.Size = 8 })
+
+	srv := NewServer(handlers.NewMediaHandler(nil, "secret"), "cdn", testKeys, WithIndex(idx))
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		method      string
+		target, key string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"list buckets", http.MethodGet, "/", "admin", "", http.StatusOK, "<Name>cdn</Name>"},
+		{"unknown bucket", http.MethodGet, "/other/x.txt", "admin", "", http.StatusNotFound, "NoSuchBucket"},
+		{"multipart", http.MethodPost, "/cdn/static/big.bin?uploads", "admin", "", http.StatusNotImplemented, "NotImplemented"},
+		{"read-only put", http.MethodPut, "/cdn/static/app.js", "reader", "x", http.StatusForbidden, "AccessDenied"},
+		{"read-only delete", http.MethodDelete, "/cdn/static/app.js", "reader", "", http.StatusForbidden, "AccessDenied"},
+		{"outside prefix", http.MethodGet, "/cdn/assets/secret.pdf", "reader", "", http.StatusForbidden, "AccessDenied"},
+		{"list outside prefix", http.MethodGet, "/cdn?list-type=2&prefix=assets/", "reader", "", http.StatusForbidden, "AccessDenied"},
+		{"object too large", http.MethodPut, "/cdn/static/big.txt", "deploy", strings.Repeat("x", 17), http.StatusBadRequest, "EntityTooLarge"},
+		{"quota exceeded", http.MethodPut, "/cdn/static/new.txt", "deploy", "12345", http.StatusForbidden, "QuotaExceeded"},
+	}
+
+	for _, tt := range tests {
+		req := newSignedRequest(t, tt.method, tt.target, tt.key, tt.key+"-secret", []byte(tt.body), now)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantCode) {
+			t.Errorf("%s: got %d %s, want %d containing %q", tt.name, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	srv := NewServer(handlers.NewMediaHandler(nil, "secret"), "cdn", testKeys, WithReadOnly(true))
+	now := time.Now()
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, newSignedRequest(t, method, "/cdn/static/app.js", "admin", "admin-secret", []byte("x"), now))
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "AccessDenied") {
+			t.Errorf("%s on a replica: got %d %s, want 403 AccessDenied", method, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		code string
+		want error
+	}{
+		{"NoSuchKey", errNoSuchKey},
+		{"PreconditionFailed", errPreconditionFailed},
+		{"InvalidRange", errInvalidRange},
+	}
+	for _, tt := range tests {
+		if got := storageError(&smithy.GenericAPIError{Code: tt.code}); got != tt.want {
+			t.Errorf("storageError(%s) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+	if err := errors.New("boom"); storageError(err) != err {
+		t.Error("unknown errors should pass through")
+	}
+}
@@ -0,0 +1,355 @@
+package s3api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat      = "20060102T150405Z"
+	maxClockSkew       = 15 * time.Minute
+	maxPresignedExpiry = 7 * 24 * time.Hour
+
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingSignedPayload   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// signedRequest is a request whose SigV4 signature has been verified.
+// Its payload is checked separately by readBody.
+type signedRequest struct {
+	key         Key
+	payloadHash string
+	signingKey  []byte
+	signature   string
+	amzDate     string
+	scope       string
+}
+
+// verifyRequest authenticates r with a SigV4 Authorization header or
+// presigned query parameters, looking up secrets with lookup
+func verifyRequest(r *http.Request, lookup func(accessKeyID string) (Key, bool), now time.Time) (*signedRequest, error) {
+	query := r.URL.Query()
+	presigned := query.Get("X-Amz-Algorithm") != ""
+
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	if presigned {
+		if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+			return nil, errAuthorizationQueryParametersError
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		payloadHash = unsignedPayload
+		if v := query.Get("X-Amz-Content-Sha256"); v != "" {
+			payloadHash = v
+		}
+	} else {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			return nil, errAccessDenied
+		}
+		if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+			return nil, errSignatureVersionNotSupported
+		}
+		for _, part := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, errMissingContentSHA256
+		}
+	}
+	if credential == "" || signedHeaders == "" || signature == "" || amzDate == "" {
+		return nil, errAuthorizationHeaderMalformed
+	}
+
+	// Credential is <access key>/<date>/<region>/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return nil, errAuthorizationHeaderMalformed
+	}
+	accessKeyID, date, region := parts[0], parts[1], parts[2]
+
+	key, ok := lookup(accessKeyID)
+	if !ok {
+		return nil, errInvalidAccessKeyID
+	}
+
+	t, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, date) {
+		return nil, errAuthorizationHeaderMalformed
+	}
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedExpiry {
+			return nil, errAuthorizationQueryParametersError
+		}
+		if now.Before(t.Add(-maxClockSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+			return nil, errExpiredRequest
+		}
+	} else if d := now.Sub(t); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	headers := strings.Split(signedHeaders, ";")
+	if !containsString(headers, "host") {
+		return nil, errAuthorizationHeaderMalformed
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		canonicalURI(r),
+		canonicalQuery(r.URL.RawQuery),
+		canonicalHeaders(r, headers),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+	signingKey := deriveSigningKey(key.SecretAccessKey, date, region)
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, errSignatureDoesNotMatch
+	}
+
+	return &signedRequest{
+		key:         key,
+		payloadHash: payloadHash,
+		signingKey:  signingKey,
+		signature:   signature,
+		amzDate:     amzDate,
+		scope:       scope,
+	}, nil
+}
+
+// readBody reads up to limit bytes of payload, decoding aws-chunked
+// bodies and verifying the payload hash or chunk signatures
+func (sr *signedRequest) readBody(r *http.Request, limit int64) ([]byte, error) {
+	switch {
+	case sr.payloadHash == streamingSignedPayload:
+		return readChunked(r.Body, limit, sr.verifyChunk)
+	case sr.payloadHash == streamingUnsignedTrailer:
+		return readChunked(r.Body, limit, nil)
+	case strings.HasPrefix(sr.payloadHash, "STREAMING-"):
+		return nil, errNotImplemented
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, errIncompleteBody
+	}
+	if int64(len(data)) > limit {
+		return nil, errEntityTooLarge
+	}
+	if sr.payloadHash != unsignedPayload && sr.payloadHash != sha256Hex(data) {
+		return nil, errContentSHA256Mismatch
+	}
+	return data, nil
+}
+
+// verifyChunk checks one aws-chunked chunk signature, which chains from
+// the request signature through every previous chunk
+func (sr *signedRequest) verifyChunk(data []byte, signature string) error {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD", sr.amzDate, sr.scope, sr.signature, emptySHA256, sha256Hex(data),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(sr.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errSignatureDoesNotMatch
+	}
+	sr.signature = signature
+	return nil
+}
+
+// readChunked decodes an aws-chunked body:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;...]\r\n[trailers]\r\n
+//
+// verify, when set, is called for every chunk including the final empty one
+func readChunked(body io.Reader, limit int64, verify func(data []byte, signature string) error) ([]byte, error) {
+	br := bufio.NewReader(body)
+	var out bytes.Buffer
+	for {
+		line, err := readCRLFLine(br)
+		if err != nil {
+			return nil, errIncompleteBody
+		}
+		sizeHex, ext, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+		if err != nil || size < 0 {
+			return nil, errIncompleteBody
+		}
+		if int64(out.Len())+size > limit {
+			return nil, errEntityTooLarge
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, errIncompleteBody
+		}
+		if verify != nil {
+			signature := strings.TrimPrefix(ext, "chunk-signature=")
+			if err := verify(chunk, signature); err != nil {
+				return nil, err
+			}
+		}
+
+		if size == 0 {
+			// Skip trailers (e.g. x-amz-checksum-crc32) up to the blank line
+			for {
+				line, err := readCRLFLine(br)
+				if err != nil || line == "" {
+					return out.Bytes(), nil
+				}
+			}
+		}
+
+		out.Write(chunk)
+		if line, err := readCRLFLine(br); err != nil || line != "" {
+			return nil, errIncompleteBody
+		}
+	}
+}
+
+func readCRLFLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// canonicalURI is the path exactly as the client sent it: S3 signs the
+// URI-encoded path without normalizing or double-encoding it
+func canonicalURI(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if r.RequestURI != "" && !strings.Contains(r.RequestURI, "://") {
+		path, _, _ = strings.Cut(r.RequestURI, "?")
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// canonicalQuery sorts and re-encodes the query, leaving out the
+// signature of presigned URLs
+func canonicalQuery(rawQuery string) string {
+	type pair struct{ k, v string }
+	var pairs []pair
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, "=")
+		k, _ = url.QueryUnescape(k)
+		v, _ = url.QueryUnescape(v)
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		pairs = append(pairs, pair{awsEscape(k), awsEscape(v)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].k != pairs[j].k {
+			return pairs[i].k < pairs[j].k
+		}
+		return pairs[i].v < pairs[j].v
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.k + "=" + p.v
+	}
+	return strings.Join(encoded, "&")
+}
+
+// canonicalHeaders lists the signed headers as "name:value\n" lines
+func canonicalHeaders(r *http.Request, names []string) string {
+	var b strings.Builder
+	for _, name := range names {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" && r.ContentLength >= 0 {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			values := r.Header.Values(name)
+			for i, v := range values {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		fmt.Fprintf(&b, "%s:%s\n", name, value)
+	}
+	return b.String()
+}
+
+// awsEscape percent-encodes everything except RFC 3986 unreserved
+// characters, as SigV4 requires
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func deriveSigningKey(secret, date, region string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+// Package scheduler runs background jobs on cron schedules: one
+// goroutine wakes for whichever job is due next, and each run happens
+// in its own goroutine so a slow job delays no other.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job is one run of a scheduled task
+type Job func(ctx context.Context) error
+
+// JobStatus reports a job's schedule and its last run
+type JobStatus struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	Running      bool      `json:"running"`
+	NextRun      time.Time `json:"next_run"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastDuration string    `json:"last_duration,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	Runs         int       `json:"runs"`
+	Failures     int       `json:"failures"`
+}
+
+type job struct {
+	fn       Job
+	schedule *Schedule
+	status   JobStatus
+}
+
+// Scheduler runs jobs on their schedules until its context is
+// cancelled. A nil *Scheduler has no jobs.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	running sync.WaitGroup
+	now     func() time.Time
+	// wake interrupts the wait for the next run when jobs change
+	wake chan struct{}
+}
+
+func New() *Scheduler {
+	return &Scheduler{
+		jobs: make(map[string]*job),
+		now:  time.Now,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Add schedules fn under name with a cron expression (see Parse). An
+// empty spec leaves the job disabled.
+func (s *Scheduler) Add(name, spec string, fn Job) error {
+	if spec == "" {
+		return nil
+	}
+	sched, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.jobs[name] = &job{
+		fn:       fn,
+		schedule: sched,
+		status:   JobStatus{Name: name, Schedule: spec, NextRun: sched.Next(s.now())},
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run starts due jobs until ctx is cancelled, then waits for running
+// ones, whose context is ctx, to return
+func (s *Scheduler) Run(ctx context.Context) {
+	defer s.running.Wait()
+
+	for {
+		wait := time.Hour
+		if next, ok := s.nextRun(); ok {
+			wait = next.Sub(s.now())
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			s.startDue(ctx)
+		case <-s.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) nextRun() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, j := range s.jobs {
+		if t := j.status.NextRun; !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// startDue starts every job whose run time has come. A job still
+// running from its last run skips this one.
+func (s *Scheduler) startDue(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for name, j := range s.jobs {
+		if j.status.NextRun.IsZero() || j.status.NextRun.After(now) {
+			continue
+		}
+		j.status.NextRun = j.schedule.Next(now)
+		if j.status.Running {
+			log.Printf("Scheduler: %s is still running, skipping a run", name)
+			continue
+		}
+		j.status.Running = true
+		s.running.Add(1)
+		go s.run(ctx, name, j)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, name string, j *job) {
+	defer s.running.Done()
+
+	start := s.now()
+	err := j.fn(ctx)
+	elapsed := s.now().Sub(start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.status.Running = false
+	j.status.LastRun = start.UTC()
+	j.status.LastDuration = elapsed.Round(time.Millisecond).String()
+	j.status.Runs++
+	j.status.LastError = ""
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+		if ctx.Err() == nil {
+			log.Printf("Scheduler: %s failed: %v", name, err)
+		}
+	}
+}
+
+// Status reports every job, sorted by name
+func (s *Scheduler) Status() []JobStatus {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, j.status)
+	}
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].Name < statuses[b].Name })
+	return statuses
+}
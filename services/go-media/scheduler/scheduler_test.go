@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStartDue(t *testing.T) {
+	now := time.Date(2024, 5, 15, 10, 0, 30, 0, time.UTC)
+	s := New()
+	s.now = func() time.Time { return now }
+
+	release := make(chan struct{})
+	runs := make(chan string, 10)
+	s.Add("slow", "* * * * *", func(ctx context.Context) error {
+		runs <- "slow"
+		<-release
+		return nil
+	})
+	s.Add("failing", "*/2 * * * *", func(ctx context.Context) error {
+		runs <- "failing"
+		return errors.New("boom")
+	})
+	s.Add("disabled", "", func(ctx context.Context) error {
+		t.Error("disabled job ran")
+		return nil
+	})
+
+	if next, _ := s.nextRun(); !next.Equal(time.Date(2024, 5, 15, 10, 1, 0, 0, time.UTC)) {
+		t.Fatalf("next run %v", next)
+	}
+
+	now = now.Add(time.Minute) // 10:01:30, only slow is due
+	s.startDue(context.Background())
+	if got := <-runs; got != "slow" {
+		t.Fatalf("ran %s, want slow", got)
+	}
+
+	now = now.Add(time.Minute) // 10:02:30, slow is still running
+	s.startDue(context.Background())
+	if got := <-runs; got != "failing" {
+		t.Fatalf("ran %s, want failing", got)
+	}
+	close(release)
+	s.running.Wait()
+
+	select {
+	case got := <-runs:
+		t.Errorf("%s ran again", got)
+	default:
+	}
+
+	status := s.Status()
+	if len(status) != 2 || status[0].Name != "failing" || status[1].Name != "slow" {
+		t.Fatalf("status = %+v", status)
+	}
+	if f := status[0]; f.Runs != 1 || f.Failures != 1 || f.LastError != "boom" || !f.NextRun.Equal(time.Date(2024, 5, 15, 10, 4, 0, 0, time.UTC)) {
+		t.Errorf("failing job status = %+v", f)
+	}
+	if sl := status[1]; sl.Runs != 1 || sl.Running || !sl.NextRun.Equal(time.Date(2024, 5, 15, 10, 3, 0, 0, time.UTC)) {
+		t.Errorf("slow job status = %+v", sl)
+	}
+}
+
+func TestAddRejectsBadSchedule(t *testing.T) {
+	if err := New().Add("job", "every day", func(context.Context) error { return nil }); err == nil {
+		t.Error("Add with a bad schedule should fail")
+	}
+}
@@ -0,0 +1,127 @@
+package selfcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MinSigningSecretLength is the shortest accepted signing secret
+// (openssl rand -hex 16)
+const MinSigningSecretLength = 32
+
+// placeholderSecrets are the example values shipped in .env.example and
+// config.example.yaml
+var placeholderSecrets = map[string]bool{
+	"your-signing-secret-here": true,
+	"change-me":                true,
+}
+
+// cloudflareAPI is the Cloudflare API base URL, replaced in tests
+var cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Check is one startup verification
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Run executes every check and returns a single error describing all
+// failures, so operators can fix everything in one pass
+func Run(ctx context.Context, checks ...Check) error {
+	var problems []string
+	for _, c := range checks {
+		if err := c.Run(ctx); err != nil {
+			problems = append(problems, c.Name+": "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("startup self-check failed:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// BucketAccess verifies the bucket is reachable with the configured
+// credentials. head is typically R2Client.HeadBucket.
+func BucketAccess(head func(ctx context.Context) error) Check {
+	return Check{
+		Name: "r2 bucket",
+		Run: func(ctx context.Context) error {
+			if err := head(ctx); err != nil {
+				return fmt.Errorf("HEAD bucket failed: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// SigningSecret verifies the signed URL secret is set, long enough and
+// not an example value
+func SigningSecret(secret string) Check {
+	return Check{
+		Name: "signing secret",
+		Run: func(ctx context.Context) error {
+			switch {
+			case secret == "":
+				return errors.New("not set")
+			case placeholderSecrets[secret]:
+				return errors.New("still set to the example value")
+			case len(secret) < MinSigningSecretLength:
+				return fmt.Errorf("must be at least %d characters, got %d", MinSigningSecretLength, len(secret))
+			}
+			return nil
+		},
+	}
+}
+
+// CloudflareCredentials verifies the API token can read the zone used for
+// cache purges. Purging is considered enabled when either value is set.
+func CloudflareCredentials(zoneID, apiToken string) Check {
+	return Check{
+		Name: "cloudflare credentials",
+		Run: func(ctx context.Context) error {
+			if zoneID == "" && apiToken == "" {
+				return nil
+			}
+			if zoneID == "" || apiToken == "" {
+				return errors.New("both zone ID and API token are required for cache purges")
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, cloudflareAPI+"/zones/"+zoneID, nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+apiToken)
+
+			client := &http.Client{Timeout: 10 * time.Second}
+			resp, err := client.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to reach Cloudflare API: %w", err)
+			}
+			defer resp.Body.Close()
+
+			var result struct {
+				Success bool `json:"success"`
+				Errors  []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result)
+
+			if resp.StatusCode != http.StatusOK || !result.Success {
+				msg := http.StatusText(resp.StatusCode)
+				if len(result.Errors) > 0 {
+					msg = result.Errors[0].Message
+				}
+				return fmt.Errorf("zone %s not accessible (status %d): %s", zoneID, resp.StatusCode, msg)
+			}
+			return nil
+		},
+	}
+}
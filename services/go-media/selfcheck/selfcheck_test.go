@@ -0,0 +1,100 @@
+package selfcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSigningSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr string
+	}{
+		{name: "missing", secret: "", wantErr: "not set"},
+		{name: "placeholder", secret: "your-signing-secret-here", wantErr: "example value"},
+		{name: "too short", secret: "abc123", wantErr: "at least 32"},
+		{name: "valid", secret: strings.Repeat("a", 64)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SigningSecret(tt.secret).Run(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Run() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloudflareCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/zones/zone1" && r.Header.Get("Authorization") == "Bearer good" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"success":false,"errors":[{"message":"Authentication error"}]}`))
+	}))
+	defer srv.Close()
+
+	orig := cloudflareAPI
+	cloudflareAPI = srv.URL
+	defer func() { cloudflareAPI = orig }()
+
+	tests := []struct {
+		name    string
+		zone    string
+		token   string
+		wantErr string
+	}{
+		{name: "purge disabled"},
+		{name: "token only", token: "good", wantErr: "both zone ID and API token"},
+		{name: "valid", zone: "zone1", token: "good"},
+		{name: "bad token", zone: "zone1", token: "bad", wantErr: "Authentication error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CloudflareCredentials(tt.zone, tt.token).Run(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Run() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunReportsAllFailures(t *testing.T) {
+	err := Run(context.Background(),
+		BucketAccess(func(ctx context.Context) error { return errors.New("403 Forbidden") }),
+		SigningSecret(strings.Repeat("a", 64)),
+		SigningSecret(""),
+	)
+	if err == nil {
+		t.Fatal("Run() error = nil, want failures")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "r2 bucket: HEAD bucket failed: 403 Forbidden") || !strings.Contains(msg, "signing secret: not set") {
+		t.Errorf("Run() error = %q, want both failures", msg)
+	}
+
+	if err := Run(context.Background(), SigningSecret(strings.Repeat("a", 64))); err != nil {
+		t.Errorf("Run() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/config"
+	"github.com/WomB0ComB0/cdn/services/go-media/grpcapi"
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/listener"
+	"github.com/WomB0ComB0/cdn/services/go-media/middleware"
+	"github.com/WomB0ComB0/cdn/services/go-media/s3api"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+// startServers starts srv in the background, terminating TLS when
+// configured, and returns every server that must be shut down on exit
+// (including the ACME challenge listener). h3 is the HTTP/3 server when
+// cfg.HTTP3 is set, to be closed on exit as well.
+func startServers(srv *http.Server, cfg config.ServerConfig) (servers []*http.Server, h3 *http3.Server) {
+	servers = []*http.Server{srv}
+
+	addr := srv.Addr
+	if cfg.Listen != "" {
+		addr = cfg.Listen
+	}
+	ln, err := listener.Listen(addr, cfg.SocketFileMode())
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+
+	if cfg.H2C && !cfg.TLS.Enabled() {
+		srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{IdleTimeout: srv.IdleTimeout})
+		log.Println("Accepting cleartext HTTP/2 (h2c)")
+	}
+
+	certFile, keyFile := cfg.TLS.CertFile, cfg.TLS.KeyFile
+	if len(cfg.TLS.AutocertHosts) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLS.AutocertHosts...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertCacheDir),
+			Email:      cfg.TLS.AutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+
+		// HTTP-01 challenges, everything else is redirected to HTTPS
+		challenge := &http.Server{
+			Addr:              ":" + cfg.TLS.HTTPPort,
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers = append(servers, challenge)
+		go func() {
+			log.Printf("Serving ACME challenges on port %s", cfg.TLS.HTTPPort)
+			if err := challenge.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("ACME challenge server failed: %v", err)
+			}
+		}()
+	}
+
+	if cfg.TLS.Enabled() {
+		if srv.TLSConfig == nil {
+			srv.TLSConfig = &tls.Config{}
+		}
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+
+	// HTTP/3 on the UDP port of the same address, advertised to TCP
+	// clients with Alt-Svc
+	if cfg.HTTP3 {
+		if h3, err = http3Server(srv, certFile, keyFile); err != nil {
+			log.Fatalf("Failed to configure HTTP/3: %v", err)
+		}
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			log.Fatalf("Failed to listen on udp %s: %v", addr, err)
+		}
+		go func() {
+			log.Printf("Starting HTTP/3 server on udp %s", conn.LocalAddr())
+			if err := h3.Serve(conn); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTP/3 server failed: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		var err error
+		if cfg.TLS.Enabled() {
+			log.Printf("Starting HTTPS server on %s", ln.Addr())
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			log.Printf("Starting server on %s", ln.Addr())
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	return servers, h3
+}
+
+// http3Server returns an HTTP/3 server for srv's handler with its TLS
+// settings and the certificate in certFile and keyFile, if set, and makes
+// srv's responses advertise it once it's listening
+func http3Server(srv *http.Server, certFile, keyFile string) (*http3.Server, error) {
+	tlsConfig := &tls.Config{}
+	if srv.TLSConfig != nil {
+		tlsConfig = srv.TLSConfig.Clone()
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	h3 := &http3.Server{
+		Handler:        srv.Handler,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig),
+		QuicConfig:     &quic.Config{MaxIdleTimeout: srv.IdleTimeout},
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+	}
+	next := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fails only until the UDP listener is up, with nothing to announce
+		h3.SetQuicHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+	return h3, nil
+}
+
+// startGRPC serves the gRPC API on its own listener, or returns nil when
+// GRPC_LISTEN is not set
+func startGRPC(cfg *config.Config, svc *grpcapi.Server) *grpc.Server {
+	if cfg.GRPC.Listen == "" {
+		return nil
+	}
+
+	ln, err := listener.Listen(cfg.GRPC.Listen, cfg.Server.SocketFileMode())
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.GRPC.Listen, err)
+	}
+
+	srv := grpcapi.NewGRPCServer(svc, cfg.GRPC.Token)
+	go func() {
+		log.Printf("Starting gRPC server on %s", ln.Addr())
+		if err := srv.Serve(ln); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+	return srv
+}
+
+// startS3 serves the S3-compatible API on its own listener, or returns
+// nil when S3_LISTEN is not set. Uploads get the upload timeout and
+// downloads the asset timeout.
+func startS3(cfg *config.Config, handler http.Handler) *http.Server {
+	if cfg.S3.Listen == "" {
+		return nil
+	}
+
+	ln, err := listener.Listen(cfg.S3.Listen, cfg.Server.SocketFileMode())
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.S3.Listen, err)
+	}
+
+	timeouts := cfg.Server.Timeouts
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(timeouts.ReadHeaderSeconds) * time.Second,
+		ReadTimeout:       time.Duration(timeouts.UploadSeconds) * time.Second,
+		WriteTimeout:      time.Duration(timeouts.AssetSeconds) * time.Second,
+		IdleTimeout:       time.Duration(timeouts.IdleSeconds) * time.Second,
+		MaxHeaderBytes:    cfg.Server.Limits.MaxHeaderBytes,
+	}
+	go func() {
+		log.Printf("Starting S3 API on %s (bucket %q)", ln.Addr(), cfg.S3.Bucket)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("S3 API server failed: %v", err)
+		}
+	}()
+	return srv
+}
+
+// s3Keys converts the configured access keys (validated on load)
+func s3Keys(cfg config.S3Config) []s3api.Key {
+	var keys []s3api.Key
+	for _, k := range cfg.AllKeys() {
+		priority, _ := middleware.ParsePriority(k.Priority)
+		keys = append(keys, s3api.Key{
+			AccessKeyID:     k.AccessKeyID,
+			SecretAccessKey: k.SecretAccessKey,
+			Prefixes:        k.Prefixes,
+			ReadOnly:        k.ReadOnly,
+			MaxObjectBytes:  int64(k.MaxObjectBytes),
+			QuotaBytes:      int64(k.QuotaBytes),
+			Priority:        priority,
+		})
+	}
+	return keys
+}
+
+// stopGRPC lets in-flight calls finish until ctx expires, then closes
+// the remaining streams
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
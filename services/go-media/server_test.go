@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its
+// key to dir, and returns their paths and the certificate
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestHTTP3Server(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t, t.TempDir())
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})}
+
+	h3, err := http3Server(srv, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("http3Server() error = %v", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go h3.Serve(conn)
+	defer h3.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	rt := &http3.RoundTripper{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	defer rt.Close()
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	resp, err := (&http.Client{Transport: rt, Timeout: 10 * time.Second}).Get(fmt.Sprintf("https://127.0.0.1:%d/", port))
+	if err != nil {
+		t.Fatalf("HTTP/3 request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "HTTP/3.0" {
+		t.Errorf("HTTP/3 response = %d %q, want 200 HTTP/3.0", resp.StatusCode, body)
+	}
+
+	// Requests over TCP learn where HTTP/3 is
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if altSvc := rec.Header().Get("Alt-Svc"); !strings.Contains(altSvc, fmt.Sprintf(`h3=":%d"`, port)) {
+		t.Errorf("Alt-Svc = %q, want h3 on port %d", altSvc, port)
+	}
+}
+
+func TestHTTP3ServerBadCertificate(t *testing.T) {
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := http3Server(srv, missing, missing); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+// opMetrics measures the requests the client makes to R2, by S3
+// operation name
+type opMetrics struct {
+	operations *metrics.CounterVec
+	seconds    *metrics.HistogramVec
+	sent       *metrics.CounterVec
+	received   *metrics.CounterVec
+}
+
+// SetMetrics registers metrics of every request to R2 in reg: how many
+// there were and with what result (see ErrorClass), how long R2 took to
+// answer, retries included, and the bytes sent and received. Durations
+// end at the response headers; reading a body is measured by the bytes
+// received as the caller reads it.
+func (r *R2Client) SetMetrics(reg *metrics.Registry) {
+	if reg == nil {
+		r.metrics = nil
+		return
+	}
+	r.metrics = &opMetrics{
+		operations: reg.CounterVec("cdn_storage_operations_total", "Requests to R2 by operation and result", "op", "result"),
+		seconds: reg.HistogramVec("cdn_storage_operation_seconds", "Time R2 took to answer requests, until the response headers",
+			[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "op"),
+		sent:     reg.CounterVec("cdn_storage_sent_bytes_total", "Request body bytes sent to R2", "op"),
+		received: reg.CounterVec("cdn_storage_received_bytes_total", "Response body bytes read from R2", "op"),
+	}
+}
+
+// ErrorClass sorts the result of a request to R2 for metrics and logs:
+// "ok" without an error, else "not_found", "precondition_failed",
+// "throttled", "server_error", "client_error", "canceled" for the
+// caller giving up, or "network" when R2 never answered
+func ErrorClass(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "canceled"
+	}
+	if IsNotFound(err) {
+		return "not_found"
+	}
+	if IsPreconditionFailed(err) {
+		return "precondition_failed"
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "TooManyRequests", "TooManyRequestsException":
+			return "throttled"
+		}
+	}
+	var re *smithyhttp.ResponseError
+	if !errors.As(err, &re) {
+		return "network"
+	}
+	switch status := re.HTTPStatusCode(); {
+	case status == http.StatusTooManyRequests:
+		return "throttled"
+	case status == http.StatusNotFound:
+		return "not_found"
+	case status == http.StatusPreconditionFailed:
+		return "precondition_failed"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "client_error"
+	}
+}
+
+// instrument adds the client's metrics to every operation o makes
+func (r *R2Client) instrument(o *s3.Options) {
+	o.HTTPClient = &countingClient{client: o.HTTPClient, r: r}
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CDNStorageMetrics", r.measure), middleware.After)
+	})
+}
+
+// measure times an operation, retries and all, and counts its result
+func (r *R2Client) measure(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	m := r.metrics
+	if m == nil {
+		return next.HandleInitialize(ctx, in)
+	}
+	op := awsmiddleware.GetOperationName(ctx)
+	start := time.Now()
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	m.seconds.With(op).Observe(time.Since(start).Seconds())
+	m.operations.With(op, ErrorClass(err)).Inc()
+	return out, metadata, err
+}
+
+// countingClient counts the body bytes of each request to R2 and of its
+// response
+type countingClient struct {
+	client s3.HTTPClient
+	r      *R2Client
+}
+
+func (c *countingClient) Do(req *http.Request) (*http.Response, error) {
+	m := c.r.metrics
+	if m == nil {
+		return c.client.Do(req)
+	}
+	op := awsmiddleware.GetOperationName(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &countingBody{ReadCloser: req.Body, counter: m.sent.With(op)}
+	}
+	resp, err := c.client.Do(req)
+	if err == nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, counter: m.received.With(op)}
+	}
+	return resp, err
+}
+
+type countingBody struct {
+	io.ReadCloser
+	counter *metrics.Counter
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.counter.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/internal/s3fake"
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+func TestErrorClass(t *testing.T) {
+	responseError := func(status int, err error) error {
+		return &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}}, Err: err}
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{fmt.Errorf("get: %w", context.Canceled), "canceled"},
+		{&smithy.GenericAPIError{Code: "NoSuchKey"}, "not_found"},
+		{&smithy.GenericAPIError{Code: "PreconditionFailed"}, "precondition_failed"},
+		{responseError(503, &smithy.GenericAPIError{Code: "SlowDown"}), "throttled"},
+		{responseError(429, fmt.Errorf("rate limited")), "throttled"},
+		{responseError(404, fmt.Errorf("no body")), "not_found"},
+		{responseError(500, &smithy.GenericAPIError{Code: "InternalError"}), "server_error"},
+		{responseError(403, &smithy.GenericAPIError{Code: "AccessDenied"}), "client_error"},
+		{fmt.Errorf("dial tcp: connection refused"), "network"},
+	}
+	for _, tt := range tests {
+		if got := ErrorClass(tt.err); got != tt.want {
+			t.Errorf("ErrorClass(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	bucket := httptest.NewServer(s3fake.New("assets"))
+	defer bucket.Close()
+	r2, err := NewR2Client(R2Config{AccessKeyID: "test", SecretAccessKey: "test", BucketName: "assets", Endpoint: bucket.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := metrics.NewRegistry()
+	r2.SetMetrics(reg)
+
+	ctx := context.Background()
+	if err := r2.PutObject(ctx, "a.txt", strings.NewReader("hello, world"), "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := r2.GetObjectWithRange(ctx, "a.txt", "bytes=0-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if _, err := r2.HeadObject(ctx, "missing.txt"); !IsNotFound(err) {
+		t.Fatalf("HeadObject() = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`cdn_storage_operations_total{op="PutObject",result="ok"} 1`,
+		`cdn_storage_operations_total{op="GetObject",result="ok"} 1`,
+		`cdn_storage_operations_total{op="HeadObject",result="not_found"} 1`,
+		`cdn_storage_operation_seconds_count{op="GetObject"} 1`,
+		`cdn_storage_sent_bytes_total{op="PutObject"} 12`,
+		`cdn_storage_received_bytes_total{op="GetObject"} 5`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, w.Body)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -11,8 +14,30 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
+// IsNotFound reports whether err is R2's answer for a missing object or
+// multipart upload
+func IsNotFound(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "NoSuchKey", "NotFound", "NoSuchUpload":
+			return true
+		}
+	}
+	return false
+}
+
+// IsPreconditionFailed reports whether err is R2 refusing a conditional
+// write because the object no longer matches
+func IsPreconditionFailed(err error) bool {
+	var ae smithy.APIError
+	return errors.As(err, &ae) && ae.ErrorCode() == "PreconditionFailed"
+}
+
 type R2Config struct {
 	AccountID       string
 	AccessKeyID     string
@@ -22,8 +47,10 @@ type R2Config struct {
 }
 
 type R2Client struct {
-	client     *s3.Client
-	bucketName string
+	client      *s3.Client
+	bucketName  string
+	onOperation func(op string)
+	metrics     *opMetrics
 }
 
 type Object struct {
@@ -56,56 +83,172 @@ func NewR2Client(cfg R2Config) (*R2Client, error) {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	client := s3.NewFromConfig(awsCfg)
+	r := &R2Client{bucketName: cfg.BucketName}
+	r.client = s3.NewFromConfig(awsCfg, r.instrument)
+	return r, nil
+}
 
-	return &R2Client{
-		client:     client,
-		bucketName: cfg.BucketName,
-	}, nil
+// SetOperationHook registers fn to be called with the S3 operation name
+// (e.g. "PutObject") every time the client issues a request to R2
+func (r *R2Client) SetOperationHook(fn func(op string)) {
+	r.onOperation = fn
 }
 
+func (r *R2Client) record(op string) {
+	if r.onOperation != nil {
+		r.onOperation(op)
+	}
+}
+
+// Object reads and writes use the SSE-C key carried by ctx, if any (see
+// WithCustomerKey)
+
 func (r *R2Client) GetObject(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
-	return r.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(r.bucketName),
-		Key:    aws.String(key),
-	})
+	return r.GetObjectWithRange(ctx, key, "")
 }
 
+// GetObjectWithRange fetches key, or a byte range of it. If the
+// connection drops while the body is read, the rest is fetched again
+// from where it stopped.
 func (r *R2Client) GetObjectWithRange(ctx context.Context, key string, byteRange string) (*s3.GetObjectOutput, error) {
+	r.record("GetObject")
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
 	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
 	if byteRange != "" {
 		input.Range = aws.String(byteRange)
 	}
+	output, err := r.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	output.Body = r.resumable(ctx, key, output)
+	return output, nil
+}
+
+// GetObjectPart fetches a byte range of key only if its ETag is still
+// etag, so parts of one object fetched separately always come from the
+// same version
+func (r *R2Client) GetObjectPart(ctx context.Context, key, byteRange, etag string) (*s3.GetObjectOutput, error) {
+	r.record("GetObject")
+	input := &s3.GetObjectInput{
+		Bucket:  aws.String(r.bucketName),
+		Key:     aws.String(key),
+		Range:   aws.String(byteRange),
+		IfMatch: aws.String(etag),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
 	return r.client.GetObject(ctx, input)
 }
 
 func (r *R2Client) HeadObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
-	return r.client.HeadObject(ctx, &s3.HeadObjectInput{
+	r.record("HeadObject")
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
+	return r.client.HeadObject(ctx, input)
+}
+
+// MaxPresignExpiry is the longest validity R2 accepts for a presigned URL
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// PresignGetObject returns a URL that fetches key straight from R2 until
+// it expires, without credentials. Non-empty contentDisposition and
+// contentType replace the object's own in the response.
+func (r *R2Client) PresignGetObject(ctx context.Context, key string, expires time.Duration, contentDisposition, contentType string) (string, error) {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
+	}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+	if contentType != "" {
+		input.ResponseContentType = aws.String(contentType)
+	}
+	req, err := s3.NewPresignClient(r.client).PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// HeadBucket checks that the bucket exists and the credentials can access it
+func (r *R2Client) HeadBucket(ctx context.Context) error {
+	r.record("HeadBucket")
+	_, err := r.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(r.bucketName),
 	})
+	return err
 }
 
 func (r *R2Client) PutObject(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string) error {
+	return r.PutObjectIfMatch(ctx, key, body, contentType, metadata, "")
+}
+
+// PutObjectIfMatch writes key only if its current ETag matches ifMatch
+// ("*" matches any existing object); an empty ifMatch writes
+// unconditionally. R2 evaluates the condition, so of two writers holding
+// the same ETag only the first succeeds; the other gets an error for
+// which IsPreconditionFailed is true.
+func (r *R2Client) PutObjectIfMatch(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string, ifMatch string) error {
+	r.record("PutObject")
 	input := &s3.PutObjectInput{
 		Bucket:      aws.String(r.bucketName),
 		Key:         aws.String(key),
 		Body:        body,
 		ContentType: aws.String(contentType),
 	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
 
 	if len(metadata) > 0 {
 		input.Metadata = metadata
 	}
 
-	_, err := r.client.PutObject(ctx, input)
+	var optFns []func(*s3.Options)
+	if ifMatch != "" {
+		// This SDK version predates PutObjectInput.IfMatch
+		optFns = append(optFns, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-Match", ifMatch))
+		})
+	}
+
+	_, err := r.client.PutObject(ctx, input, optFns...)
+	return err
+}
+
+// ReplaceMetadata sets the content type and metadata of key, copying
+// the object onto itself, if its ETag still matches ifMatch. The content
+// and its ETag are unchanged.
+func (r *R2Client) ReplaceMetadata(ctx context.Context, key, contentType string, metadata map[string]string, ifMatch string) error {
+	return r.CopyObject(ctx, key, key, contentType, metadata, ifMatch)
+}
+
+// CopyObject copies src to dst within the bucket, with contentType and
+// metadata in place of src's, if src's ETag still matches ifMatch
+func (r *R2Client) CopyObject(ctx context.Context, src, dst, contentType string, metadata map[string]string, ifMatch string) error {
+	r.record("CopyObject")
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(r.bucketName),
+		Key:               aws.String(dst),
+		CopySource:        aws.String((&url.URL{Path: r.bucketName + "/" + src}).EscapedPath()),
+		CopySourceIfMatch: aws.String(ifMatch),
+		ContentType:       aws.String(contentType),
+		Metadata:          metadata,
+		MetadataDirective: types.MetadataDirectiveReplace,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = sseFields(ctx)
+	_, err := r.client.CopyObject(ctx, input)
 	return err
 }
 
 func (r *R2Client) DeleteObject(ctx context.Context, key string) error {
+	r.record("DeleteObject")
 	_, err := r.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
@@ -114,6 +257,7 @@ func (r *R2Client) DeleteObject(ctx context.Context, key string) error {
 }
 
 func (r *R2Client) ListObjects(ctx context.Context, prefix string, maxKeys int32) ([]Object, error) {
+	r.record("ListObjectsV2")
 	input := &s3.ListObjectsV2Input{
 		Bucket:  aws.String(r.bucketName),
 		Prefix:  aws.String(prefix),
@@ -138,22 +282,95 @@ func (r *R2Client) ListObjects(ctx context.Context, prefix string, maxKeys int32
 	return objects, nil
 }
 
-func (r *R2Client) CreateMultipartUpload(ctx context.Context, key string, contentType string) (*s3.CreateMultipartUploadOutput, error) {
-	return r.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+// ListOptions selects one page of a bucket listing
+type ListOptions struct {
+	Prefix            string
+	Delimiter         string
+	StartAfter        string
+	ContinuationToken string
+	MaxKeys           int32
+}
+
+// ListPage is one page of a bucket listing. With a delimiter, keys
+// sharing a prefix up to the delimiter are rolled up into CommonPrefixes.
+type ListPage struct {
+	Objects               []Object
+	CommonPrefixes        []string
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// ListObjectsPage lists one page of objects, for callers that paginate or
+// group keys by delimiter
+func (r *R2Client) ListObjectsPage(ctx context.Context, opts ListOptions) (*ListPage, error) {
+	r.record("ListObjectsV2")
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(r.bucketName),
+		Prefix:  aws.String(opts.Prefix),
+		MaxKeys: aws.Int32(opts.MaxKeys),
+	}
+	if opts.Delimiter != "" {
+		input.Delimiter = aws.String(opts.Delimiter)
+	}
+	if opts.StartAfter != "" {
+		input.StartAfter = aws.String(opts.StartAfter)
+	}
+	if opts.ContinuationToken != "" {
+		input.ContinuationToken = aws.String(opts.ContinuationToken)
+	}
+
+	output, err := r.client.ListObjectsV2(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ListPage{
+		Objects:               make([]Object, 0, len(output.Contents)),
+		IsTruncated:           aws.ToBool(output.IsTruncated),
+		NextContinuationToken: aws.ToString(output.NextContinuationToken),
+	}
+	for _, obj := range output.Contents {
+		page.Objects = append(page.Objects, Object{
+			Key:          aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			LastModified: aws.ToTime(obj.LastModified),
+			ETag:         aws.ToString(obj.ETag),
+		})
+	}
+	for _, p := range output.CommonPrefixes {
+		page.CommonPrefixes = append(page.CommonPrefixes, aws.ToString(p.Prefix))
+	}
+
+	return page, nil
+}
+
+func (r *R2Client) CreateMultipartUpload(ctx context.Context, key string, contentType string, metadata map[string]string) (*s3.CreateMultipartUploadOutput, error) {
+	r.record("CreateMultipartUpload")
+	input := &s3.CreateMultipartUploadInput{
 		Bucket:      aws.String(r.bucketName),
 		Key:         aws.String(key),
 		ContentType: aws.String(contentType),
-	})
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
+	if len(metadata) > 0 {
+		input.Metadata = metadata
+	}
+	return r.client.CreateMultipartUpload(ctx, input)
 }
 
-func (r *R2Client) UploadPart(ctx context.Context, key string, uploadID string, partNumber int32, body io.Reader) (*types.CompletedPart, error) {
-	output, err := r.client.UploadPart(ctx, &s3.UploadPartInput{
-		Bucket:     aws.String(r.bucketName),
-		Key:        aws.String(key),
-		UploadId:   aws.String(uploadID),
-		PartNumber: aws.Int32(partNumber),
-		Body:       body,
-	})
+// UploadPart uploads size bytes of body as part partNumber
+func (r *R2Client) UploadPart(ctx context.Context, key string, uploadID string, partNumber int32, body io.Reader, size int64) (*types.CompletedPart, error) {
+	r.record("UploadPart")
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(r.bucketName),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(partNumber),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
+	output, err := r.client.UploadPart(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -164,19 +381,28 @@ func (r *R2Client) UploadPart(ctx context.Context, key string, uploadID string,
 	}, nil
 }
 
-func (r *R2Client) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []types.CompletedPart) error {
-	_, err := r.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+// CompleteMultipartUpload assembles the parts into the object and
+// returns its ETag, without quotes
+func (r *R2Client) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []types.CompletedPart) (string, error) {
+	r.record("CompleteMultipartUpload")
+	input := &s3.CompleteMultipartUploadInput{
 		Bucket:   aws.String(r.bucketName),
 		Key:      aws.String(key),
 		UploadId: aws.String(uploadID),
 		MultipartUpload: &types.CompletedMultipartUpload{
 			Parts: parts,
 		},
-	})
-	return err
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseFields(ctx)
+	output, err := r.client.CompleteMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(aws.ToString(output.ETag), `"`), nil
 }
 
 func (r *R2Client) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	r.record("AbortMultipartUpload")
 	_, err := r.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
 		Bucket:   aws.String(r.bucketName),
 		Key:      aws.String(key),
@@ -184,3 +410,72 @@ func (r *R2Client) AbortMultipartUpload(ctx context.Context, key string, uploadI
 	})
 	return err
 }
+
+// MultipartUpload is a multipart upload that was started but not yet
+// completed or aborted
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// ListMultipartUploads lists every multipart upload in progress
+func (r *R2Client) ListMultipartUploads(ctx context.Context) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(r.bucketName)}
+	for {
+		r.record("ListMultipartUploads")
+		output, err := r.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range output.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Key:       aws.ToString(u.Key),
+				UploadID:  aws.ToString(u.UploadId),
+				Initiated: aws.ToTime(u.Initiated),
+			})
+		}
+		if !aws.ToBool(output.IsTruncated) {
+			return uploads, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int32
+	Size   int64
+	ETag   string
+}
+
+// ListParts lists the parts uploaded so far to a multipart upload, in
+// part number order
+func (r *R2Client) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(r.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}
+	for {
+		r.record("ListParts")
+		output, err := r.client.ListParts(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range output.Parts {
+			parts = append(parts, Part{
+				Number: aws.ToInt32(p.PartNumber),
+				Size:   aws.ToInt64(p.Size),
+				ETag:   aws.ToString(p.ETag),
+			})
+		}
+		if !aws.ToBool(output.IsTruncated) {
+			return parts, nil
+		}
+		input.PartNumberMarker = output.NextPartNumberMarker
+	}
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// maxResumes is how many times in a row a dropped stream is reopened
+	// without any bytes arriving in between
+	maxResumes = 3
+
+	// resumeBackoff is the wait before each further reopen attempt
+	resumeBackoff = 200 * time.Millisecond
+)
+
+// resumable wraps a GetObject response's body so that if the connection
+// to R2 drops part way through, the rest is fetched with a ranged GET
+// from the last byte read. The GET is pinned to the object's ETag, so an
+// object replaced meanwhile fails the read instead of splicing versions.
+func (r *R2Client) resumable(ctx context.Context, key string, obj *s3.GetObjectOutput) io.ReadCloser {
+	if obj.ContentLength == nil || obj.ETag == nil {
+		return obj.Body
+	}
+	var start int64
+	if obj.ContentRange != nil {
+		if _, err := fmt.Sscanf(*obj.ContentRange, "bytes %d-", &start); err != nil {
+			return obj.Body
+		}
+	}
+	etag := *obj.ETag
+	reopen := func(ctx context.Context, byteRange string) (io.ReadCloser, error) {
+		part, err := r.GetObjectPart(ctx, key, byteRange, etag)
+		if err != nil {
+			return nil, err
+		}
+		return part.Body, nil
+	}
+	return newResumingReader(ctx, key, obj.Body, start, start+*obj.ContentLength, reopen)
+}
+
+// resumingReader reads bytes [offset, end) of an object, reopening the
+// stream from offset whenever it fails short of end
+type resumingReader struct {
+	ctx    context.Context
+	key    string
+	body   io.ReadCloser
+	offset int64
+	end    int64
+	reopen func(ctx context.Context, byteRange string) (io.ReadCloser, error)
+
+	failures int
+	err      error // set once resuming has failed
+}
+
+func newResumingReader(ctx context.Context, key string, body io.ReadCloser, offset, end int64, reopen func(ctx context.Context, byteRange string) (io.ReadCloser, error)) *resumingReader {
+	return &resumingReader{ctx: ctx, key: key, body: body, offset: offset, end: end, reopen: reopen}
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.offset >= r.end {
+			return 0, io.EOF
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		switch {
+		case err == nil:
+			return n, nil
+		case err == io.EOF && r.offset >= r.end:
+			return n, io.EOF
+		case r.ctx.Err() != nil:
+			// The client went away; nothing to resume for
+			return n, err
+		}
+
+		// The stream ended or failed short of its length
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.resume(err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume replaces the failed body with a stream of the rest of the
+// range, setting r.err if it can't
+func (r *resumingReader) resume(cause error) {
+	r.body.Close()
+	for r.failures < maxResumes {
+		if r.failures > 0 {
+			select {
+			case <-time.After(time.Duration(r.failures) * resumeBackoff):
+			case <-r.ctx.Done():
+				r.err = r.ctx.Err()
+				return
+			}
+		}
+		r.failures++
+		log.Printf("R2 stream of %s failed at byte %d (%v), resuming", r.key, r.offset, cause)
+
+		body, err := r.reopen(r.ctx, fmt.Sprintf("bytes=%d-%d", r.offset, r.end-1))
+		if err == nil {
+			r.body = body
+			return
+		}
+		if IsPreconditionFailed(err) {
+			r.err = fmt.Errorf("resuming %s: object changed mid-stream", r.key)
+			return
+		}
+		cause = err
+	}
+	r.err = fmt.Errorf("resuming %s after %d attempts: %w", r.key, maxResumes, cause)
+}
+
+func (r *resumingReader) Close() error {
+	if r.err != nil {
+		// The last body was closed when resuming it failed
+		return nil
+	}
+	return r.body.Close()
+}
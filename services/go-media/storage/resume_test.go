@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+// flakyBody serves content and then fails with err
+type flakyBody struct {
+	r   io.Reader
+	err error
+}
+
+func (b *flakyBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *flakyBody) Close() error { return nil }
+
+func TestResumingReader(t *testing.T) {
+	const object = "0123456789abcdefghij"
+	reset := errors.New("connection reset by peer")
+
+	var ranges []string
+	reopen := func(ctx context.Context, byteRange string) (io.ReadCloser, error) {
+		ranges = append(ranges, byteRange)
+		var start, end int
+		if _, err := fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end); err != nil {
+			t.Fatalf("bad range %q", byteRange)
+		}
+		// The first reopen drops again after a few bytes
+		if len(ranges) == 1 {
+			return &flakyBody{r: strings.NewReader(object[start : start+3]), err: reset}, nil
+		}
+		return io.NopCloser(strings.NewReader(object[start : end+1])), nil
+	}
+
+	// A ranged read of bytes 2-17 whose connection drops after 5 bytes
+	first := &flakyBody{r: strings.NewReader(object[2:7]), err: reset}
+	r := newResumingReader(context.Background(), "video.mp4", first, 2, 18, reopen)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != object[2:18] {
+		t.Errorf("read %q, want %q", got, object[2:18])
+	}
+	if len(ranges) != 2 || ranges[0] != "bytes=7-17" || ranges[1] != "bytes=10-17" {
+		t.Errorf("reopened ranges = %v", ranges)
+	}
+}
+
+func TestResumingReaderShortEOF(t *testing.T) {
+	reopen := func(ctx context.Context, byteRange string) (io.ReadCloser, error) {
+		if byteRange != "bytes=3-5" {
+			t.Errorf("range = %q, want bytes=3-5", byteRange)
+		}
+		return io.NopCloser(strings.NewReader("def")), nil
+	}
+	r := newResumingReader(context.Background(), "a", io.NopCloser(strings.NewReader("abc")), 0, 6, reopen)
+	if got, err := io.ReadAll(r); err != nil || string(got) != "abcdef" {
+		t.Errorf("ReadAll() = %q, %v", got, err)
+	}
+}
+
+func TestResumingReaderGivesUp(t *testing.T) {
+	changed := &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	tests := []struct {
+		name    string
+		reopen  error
+		want    string
+		reopens int
+	}{
+		{name: "object replaced", reopen: changed, want: "object changed", reopens: 1},
+		{name: "R2 unreachable", reopen: errors.New("dial tcp: timeout"), want: "after 3 attempts", reopens: maxResumes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reopens := 0
+			reopen := func(ctx context.Context, byteRange string) (io.ReadCloser, error) {
+				reopens++
+				return nil, tt.reopen
+			}
+			first := &flakyBody{r: strings.NewReader("abc"), err: errors.New("reset")}
+			r := newResumingReader(context.Background(), "a", first, 0, 10, reopen)
+			got, err := io.ReadAll(r)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+			if string(got) != "abc" || reopens != tt.reopens {
+				t.Errorf("read %q with %d reopens", got, reopens)
+			}
+		})
+	}
+}
+
+func TestResumingReaderStopsForClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reopen := func(ctx context.Context, byteRange string) (io.ReadCloser, error) {
+		t.Error("reopened for a cancelled request")
+		return nil, errors.New("unreachable")
+	}
+	first := &flakyBody{r: strings.NewReader("abc"), err: context.Canceled}
+	r := newResumingReader(ctx, "a", first, 0, 10, reopen)
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
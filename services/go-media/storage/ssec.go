@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ErrInvalidCustomerKey is returned for an SSE-C key that isn't 32
+// base64-encoded bytes
+var ErrInvalidCustomerKey = errors.New("encryption key must be 32 base64-encoded bytes")
+
+// CustomerKey is a client's SSE-C key. R2 encrypts the object with it and
+// keeps only a hash, so the object can't be read without presenting the
+// key again.
+type CustomerKey struct {
+	key, md5 string
+}
+
+// ParseCustomerKey parses a base64-encoded 256-bit AES key
+func ParseCustomerKey(encoded string) (*CustomerKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 32 {
+		return nil, ErrInvalidCustomerKey
+	}
+	sum := md5.Sum(raw)
+	return &CustomerKey{key: encoded, md5: base64.StdEncoding.EncodeToString(sum[:])}, nil
+}
+
+type customerKeyKey struct{}
+
+// WithCustomerKey makes the object reads and writes made with ctx use k
+// for SSE-C
+func WithCustomerKey(ctx context.Context, k *CustomerKey) context.Context {
+	return context.WithValue(ctx, customerKeyKey{}, k)
+}
+
+// HasCustomerKey reports whether ctx carries an SSE-C key
+func HasCustomerKey(ctx context.Context) bool {
+	return customerKeyFrom(ctx) != nil
+}
+
+func customerKeyFrom(ctx context.Context) *CustomerKey {
+	k, _ := ctx.Value(customerKeyKey{}).(*CustomerKey)
+	return k
+}
+
+// sseFields returns the SSE-C request fields (algorithm, key, key MD5)
+// for ctx, all nil without a key
+func sseFields(ctx context.Context) (algorithm, key, keyMD5 *string) {
+	k := customerKeyFrom(ctx)
+	if k == nil {
+		return nil, nil, nil
+	}
+	return aws.String("AES256"), aws.String(k.key), aws.String(k.md5)
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// MinPartSize is R2's smallest multipart part; only the last part of
+	// an upload may be smaller
+	MinPartSize = 5 << 20
+
+	// MaxParts is the most parts one multipart upload may have
+	MaxParts = 10000
+)
+
+// multipartClient is the part of R2Client an Uploader drives
+type multipartClient interface {
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string) error
+	CreateMultipartUpload(ctx context.Context, key string, contentType string, metadata map[string]string) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, key string, uploadID string, partNumber int32, body io.Reader, size int64) (*types.CompletedPart, error)
+	CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []types.CompletedPart) (string, error)
+	AbortMultipartUpload(ctx context.Context, key string, uploadID string) error
+}
+
+// Uploader writes streams to R2, splitting any longer than PartSize into
+// parts of that size uploaded Concurrency at a time. It holds at most
+// Concurrency+1 parts in memory.
+type Uploader struct {
+	PartSize    int64
+	Concurrency int
+
+	client multipartClient
+}
+
+// NewUploader returns an Uploader for client. PartSize is raised to
+// MinPartSize and Concurrency to 1 if lower.
+func NewUploader(client *R2Client, partSize int64, concurrency int) *Uploader {
+	return newUploader(client, partSize, concurrency)
+}
+
+func newUploader(client multipartClient, partSize int64, concurrency int) *Uploader {
+	if partSize < MinPartSize {
+		partSize = MinPartSize
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Uploader{PartSize: partSize, Concurrency: concurrency, client: client}
+}
+
+// UploadResult describes an uploaded object
+type UploadResult struct {
+	// ETag is R2's ETag, without quotes: the content MD5 for a single
+	// part, otherwise that of the parts' MD5s followed by -<parts>
+	ETag  string
+	Size  int64
+	Parts int
+}
+
+// Upload writes body to key. A body that fits in one part is sent in a
+// single request; anything longer becomes a multipart upload, aborted if
+// any part fails.
+func (u *Uploader) Upload(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string) (*UploadResult, error) {
+	first := make([]byte, u.PartSize)
+	n, err := io.ReadFull(body, first)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		data := first[:n]
+		if err := u.client.PutObject(ctx, key, bytes.NewReader(data), contentType, metadata); err != nil {
+			return nil, err
+		}
+		sum := md5.Sum(data)
+		return &UploadResult{ETag: hex.EncodeToString(sum[:]), Size: int64(n), Parts: 1}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	created, err := u.client.CreateMultipartUpload(ctx, key, contentType, metadata)
+	if err != nil {
+		return nil, err
+	}
+	uploadID := aws.ToString(created.UploadId)
+
+	result, err := u.uploadParts(ctx, key, uploadID, first, body)
+	if err != nil {
+		// Otherwise the parts are kept, and billed, until the multipart
+		// GC job finds them
+		if abortErr := u.client.AbortMultipartUpload(context.WithoutCancel(ctx), key, uploadID); abortErr != nil && !IsNotFound(abortErr) {
+			log.Printf("Uploader: aborting upload of %s: %v", key, abortErr)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+type pendingPart struct {
+	number int32
+	data   []byte
+}
+
+// uploadParts uploads first and the rest of body as parts of uploadID
+// and completes it
+func (u *Uploader) uploadParts(parent context.Context, key, uploadID string, first []byte, body io.Reader) (*UploadResult, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	pending := make(chan pendingPart)
+	var workers sync.WaitGroup
+	for i := 0; i < u.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for p := range pending {
+				part, err := u.client.UploadPart(ctx, key, uploadID, p.number, bytes.NewReader(p.data), int64(len(p.data)))
+				if err != nil {
+					fail(fmt.Errorf("part %d: %w", p.number, err))
+					continue
+				}
+				mu.Lock()
+				parts = append(parts, *part)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Read the next part while the workers upload earlier ones
+	size := int64(len(first))
+	data, number := first, int32(1)
+	for data != nil {
+		select {
+		case pending <- pendingPart{number: number, data: data}:
+		case <-ctx.Done():
+		}
+		data = nil
+		if ctx.Err() != nil {
+			break
+		}
+
+		next := make([]byte, u.PartSize)
+		n, err := io.ReadFull(body, next)
+		switch {
+		case err == io.EOF:
+		case err != nil && !errors.Is(err, io.ErrUnexpectedEOF):
+			fail(err)
+		case number == MaxParts:
+			fail(fmt.Errorf("stream longer than %d parts of %d bytes", MaxParts, u.PartSize))
+		default:
+			data, number = next[:n], number+1
+			size += int64(n)
+		}
+	}
+	close(pending)
+	workers.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(a, b int) bool { return aws.ToInt32(parts[a].PartNumber) < aws.ToInt32(parts[b].PartNumber) })
+	etag, err := u.client.CompleteMultipartUpload(parent, key, uploadID, parts)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadResult{ETag: etag, Size: size, Parts: len(parts)}, nil
+}
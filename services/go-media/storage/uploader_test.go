@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeMultipart records what an Uploader sends
+type fakeMultipart struct {
+	mu        sync.Mutex
+	put       []byte
+	parts     map[int32][]byte
+	completed []types.CompletedPart
+	aborted   bool
+	inFlight  int
+	maxFlight int
+	failPart  int32
+}
+
+func (f *fakeMultipart) PutObject(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]string) error {
+	f.put, _ = io.ReadAll(body)
+	return nil
+}
+
+func (f *fakeMultipart) CreateMultipartUpload(ctx context.Context, key, contentType string, metadata map[string]string) (*s3.CreateMultipartUploadOutput, error) {
+	f.parts = make(map[int32][]byte)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (f *fakeMultipart) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, body io.Reader, size int64) (*types.CompletedPart, error) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxFlight {
+		f.maxFlight = f.inFlight
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	data, _ := io.ReadAll(body)
+	if int64(len(data)) != size {
+		return nil, errors.New("size mismatch")
+	}
+	if partNumber == f.failPart {
+		return nil, errors.New("part rejected")
+	}
+	f.mu.Lock()
+	f.parts[partNumber] = data
+	f.mu.Unlock()
+	return &types.CompletedPart{PartNumber: aws.Int32(partNumber), ETag: aws.String("etag")}, nil
+}
+
+func (f *fakeMultipart) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []types.CompletedPart) (string, error) {
+	f.completed = parts
+	return "abc-3", nil
+}
+
+func (f *fakeMultipart) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	f.aborted = true
+	return nil
+}
+
+func TestUploaderSinglePart(t *testing.T) {
+	fake := &fakeMultipart{}
+	u := newUploader(fake, MinPartSize, 4)
+
+	res, err := u.Upload(context.Background(), "assets/a.txt", bytes.NewReader([]byte("hello")), "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.put) != "hello" || fake.parts != nil {
+		t.Errorf("put %q, parts %v: want a single PUT", fake.put, fake.parts)
+	}
+	if res.ETag != "5d41402abc4b2a76b9719d911017c592" || res.Size != 5 || res.Parts != 1 {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestUploaderMultipart(t *testing.T) {
+	fake := &fakeMultipart{}
+	u := newUploader(fake, MinPartSize, 2)
+	data := bytes.Repeat([]byte("0123456789"), (2*MinPartSize+100)/10)
+
+	res, err := u.Upload(context.Background(), "assets/big.bin", bytes.NewReader(data), "application/octet-stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ETag != "abc-3" || res.Size != int64(len(data)) || res.Parts != 3 {
+		t.Errorf("result = %+v", res)
+	}
+	var joined []byte
+	for i, p := range fake.completed {
+		if aws.ToInt32(p.PartNumber) != int32(i+1) {
+			t.Fatalf("completed parts out of order: %v", fake.completed)
+		}
+		joined = append(joined, fake.parts[int32(i+1)]...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Error("parts don't reassemble the stream")
+	}
+	if len(fake.parts[1]) != MinPartSize || len(fake.parts[3]) != 100 {
+		t.Errorf("part sizes = %d, %d, %d", len(fake.parts[1]), len(fake.parts[2]), len(fake.parts[3]))
+	}
+	if fake.maxFlight > 2 {
+		t.Errorf("%d parts in flight, want at most 2", fake.maxFlight)
+	}
+}
+
+func TestUploaderAbortsOnFailure(t *testing.T) {
+	fake := &fakeMultipart{failPart: 2}
+	u := newUploader(fake, MinPartSize, 2)
+	data := make([]byte, 3*MinPartSize)
+
+	if _, err := u.Upload(context.Background(), "assets/big.bin", bytes.NewReader(data), "", nil); err == nil {
+		t.Fatal("Upload() succeeded with a failing part")
+	}
+	if !fake.aborted || fake.completed != nil {
+		t.Errorf("aborted = %v, completed = %v: want aborted, not completed", fake.aborted, fake.completed)
+	}
+}
+
+func TestNewUploaderMinimums(t *testing.T) {
+	u := NewUploader(nil, 1024, 0)
+	if u.PartSize != MinPartSize || u.Concurrency != 1 {
+		t.Errorf("PartSize = %d, Concurrency = %d", u.PartSize, u.Concurrency)
+	}
+}
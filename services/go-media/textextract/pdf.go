@@ -0,0 +1,229 @@
+package textextract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxStreamSize bounds what one PDF stream inflates to, so a small file
+// can't expand without end
+const maxStreamSize = 16 << 20
+
+// maxPDFText stops reading a PDF once this much text has been collected
+const maxPDFText = 1 << 20
+
+var (
+	flateFilter = regexp.MustCompile(`/Filter\s*\[?\s*/FlateDecode`)
+	otherFilter = regexp.MustCompile(`/Filter\s*\[?\s*/`)
+	imageStream = regexp.MustCompile(`/Subtype\s*/Image`)
+)
+
+// pdfText returns the text shown by the content streams of a PDF, in the
+// order the file stores them. It reads uncompressed and Flate-compressed
+// streams, and text in fonts with single-byte encodings; text drawn with
+// two-byte (CID) fonts, or only present as scanned images, isn't found.
+func pdfText(data []byte) string {
+	var b strings.Builder
+	for pos := 0; b.Len() < maxPDFText; {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		pos = start + len("stream")
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+		// The stream's data starts after the keyword's end of line
+		switch {
+		case bytes.HasPrefix(data[pos:], []byte("\r\n")):
+			pos += 2
+		case bytes.HasPrefix(data[pos:], []byte("\n")), bytes.HasPrefix(data[pos:], []byte("\r")):
+			pos++
+		default:
+			continue
+		}
+		end := bytes.Index(data[pos:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		dict := data[max(bytes.LastIndex(data[:start], []byte("obj")), 0):start]
+		raw := data[pos : pos+end]
+		pos += end + len("endstream")
+
+		if imageStream.Match(dict) {
+			continue
+		}
+		content := raw
+		if flateFilter.Match(dict) {
+			content = inflate(raw)
+		} else if otherFilter.Match(dict) {
+			continue
+		}
+		if bytes.Contains(content, []byte("BT")) {
+			b.WriteString(contentText(content))
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// inflate returns as much of a Flate stream as decompresses, since
+// streams cut short by a damaged file still hold readable text
+func inflate(raw []byte) []byte {
+	r, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	out, _ := io.ReadAll(io.LimitReader(r, maxStreamSize))
+	return out
+}
+
+// contentText returns the text a content stream shows with its Tj, TJ,
+// ' and " operators, between BT and ET
+func contentText(content []byte) string {
+	var b strings.Builder
+	var shown []string // strings for the next operator to show
+	inText := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := literalString(content[i:])
+			shown = append(shown, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<', c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			// Hex strings are glyph codes, unreadable without the font
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9':
+			start := i
+			for i++; i < len(content) && (content[i] == '.' || content[i] >= '0' && content[i] <= '9'); i++ {
+			}
+			// A wide negative adjustment between the strings of a TJ
+			// array is how many PDFs space words
+			if n, err := strconv.ParseFloat(string(content[start:i]), 64); err == nil && n <= -200 {
+				shown = append(shown, " ")
+			}
+		case isRegular(c) || c == '\'' || c == '"':
+			start := i
+			for i++; i < len(content) && isRegular(content[i]); i++ {
+			}
+			op := string(content[start:i])
+			switch op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				b.WriteByte('\n')
+			case "Tj", "TJ", "'", "\"":
+				if inText {
+					if op == "'" || op == "\"" {
+						b.WriteByte('\n')
+					}
+					b.WriteString(strings.Join(shown, ""))
+				}
+			case "Td", "TD", "T*", "Tm":
+				b.WriteByte(' ')
+			case "ID":
+				// Inline image data, which may hold anything, runs to EI
+				end := bytes.Index(content[i:], []byte("EI"))
+				if end < 0 {
+					return b.String()
+				}
+				i += end + 2
+			}
+			shown = shown[:0]
+		default:
+			i++
+		}
+	}
+	return b.String()
+}
+
+// isRegular reports whether c can be part of an operator or name
+func isRegular(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune("()<>[]{}/%'\"", rune(c))
+}
+
+// literalString reads the (string) at the start of s, returning its
+// text and the bytes read. Strings holding NUL bytes are two-byte glyph
+// codes, and read as "".
+func literalString(s []byte) (string, int) {
+	var raw []byte
+	depth := 0
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				raw = append(raw, '\n')
+			case 'r':
+				raw = append(raw, '\r')
+			case 't':
+				raw = append(raw, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+						n = n*8 + int(s[i]-'0')
+						i++
+					}
+					i--
+					raw = append(raw, byte(n))
+				} else {
+					raw = append(raw, e)
+				}
+			}
+			continue
+		case c == '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return latin1(raw), i + 1
+			}
+		}
+		raw = append(raw, c)
+	}
+	return latin1(raw), i
+}
+
+// latin1 decodes a string of single-byte codes, which for the standard
+// encodings of Western text are close enough to Latin-1
+func latin1(raw []byte) string {
+	if bytes.IndexByte(raw, 0) >= 0 {
+		return ""
+	}
+	r := make([]rune, len(raw))
+	for i, c := range raw {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
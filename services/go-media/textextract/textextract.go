@@ -0,0 +1,118 @@
+// Package textextract reads the text of text-bearing assets (plain text,
+// CSV, JSON and PDF) for the metadata index to search.
+package textextract
+
+import (
+	"encoding/json"
+	"mime"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// extractors read the text of each content type
+var extractors = map[string]func(data []byte) string{
+	"text/plain":       plainText,
+	"text/csv":         plainText,
+	"application/json": jsonText,
+	"application/pdf":  pdfText,
+}
+
+// Supported reports whether the text of contentType can be extracted
+func Supported(contentType string) bool {
+	return extractors[baseType(contentType)] != nil
+}
+
+// Extract returns the text of data, of type contentType, with runs of
+// whitespace collapsed and cut to at most limit bytes. It returns "" for
+// types it doesn't read and content it can't.
+func Extract(contentType string, data []byte, limit int) string {
+	extract := extractors[baseType(contentType)]
+	if extract == nil {
+		return ""
+	}
+	return clip(normalize(extract(data)), limit)
+}
+
+func baseType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+func plainText(data []byte) string {
+	return strings.ToValidUTF8(string(data), " ")
+}
+
+// jsonText returns the string values in a JSON document, one per line.
+// Keys are left out: they name fields rather than say anything.
+func jsonText(data []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return ""
+	}
+	var b strings.Builder
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			b.WriteString(v)
+			b.WriteByte('\n')
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k])
+			}
+		}
+	}
+	walk(v)
+	return b.String()
+}
+
+// normalize collapses runs of whitespace into a space, or a newline when
+// the run has one, and drops control characters
+func normalize(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	pending := rune(0)
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			if r == '\n' {
+				pending = '\n'
+			} else if pending == 0 {
+				pending = ' '
+			}
+		case unicode.IsControl(r):
+		default:
+			if pending != 0 && b.Len() > 0 {
+				b.WriteRune(pending)
+			}
+			pending = 0
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// clip cuts s to at most limit bytes, on a rune boundary
+func clip(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
@@ -0,0 +1,78 @@
+package textextract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testPDF returns a PDF whose page content is content, Flate-compressed
+func testPDF(content string) []byte {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(content))
+	w.Close()
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	b.WriteString("2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n")
+	b.WriteString("3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>\nendobj\n")
+	fmt.Fprintf(&b, "4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\r\n", z.Len())
+	b.Write(z.Bytes())
+	b.WriteString("\nendstream\nendobj\n")
+	b.WriteString("5 0 obj\n<< /Type /XObject /Subtype /Image /Length 16 >>\nstream\nBT (pixels) Tj ET\nendstream\nendobj\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestExtractPDF(t *testing.T) {
+	pdf := testPDF(`BT /F1 12 Tf 72 720 Td (Quarterly \(Q3\) report) Tj 0 -14 Td [(Reve) 20 (nue) -250 (grew)] TJ
+(caf\351 line) ' ET
+q 10 0 0 10 0 0 cm BI /W 1 /H 1 ID (junk) Tj EI Q
+BT <0041> Tj (\000\044\000\045) Tj ET`)
+	got := Extract("application/pdf", pdf, 1000)
+	want := "Quarterly (Q3) report Revenue grew\ncafé line"
+	if got != want {
+		t.Errorf("Extract = %q, want %q", got, want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		contentType, data, want string
+	}{
+		{"text/plain; charset=utf-8", "Hello,\n\n\tworld  again", "Hello,\nworld again"},
+		{"text/csv", "name,city\nAda,London\n", "name,city\nAda,London"},
+		{"application/json", `{"title": "Launch plan", "tags": ["q3", 4, {"owner": "ops"}], "draft": true}`, "q3\nops\nLaunch plan"},
+		{"application/json", `{"broken": `, ""},
+		{"image/png", "\x89PNG", ""},
+	}
+	for _, tt := range tests {
+		if got := Extract(tt.contentType, []byte(tt.data), 1000); got != tt.want {
+			t.Errorf("Extract(%s, %q) = %q, want %q", tt.contentType, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestExtractLimit(t *testing.T) {
+	got := Extract("text/plain", []byte(strings.Repeat("é", 10)), 5)
+	if got != "éé" {
+		t.Errorf("Extract cut to %q", got)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/pdf":          true,
+		"text/csv; charset=utf-8":  true,
+		"application/json":         true,
+		"text/html; charset=utf-8": false,
+		"application/octet-stream": false,
+		"":                         false,
+	} {
+		if got := Supported(contentType); got != want {
+			t.Errorf("Supported(%q) = %v", contentType, got)
+		}
+	}
+}
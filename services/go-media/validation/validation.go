@@ -0,0 +1,301 @@
+// Package validation checks decoded JSON requests against rules in their
+// struct tags and reports every field that breaks one, named as in the
+// JSON, so a client can fix a request in one go rather than one error at
+// a time.
+//
+// Rules are comma-separated in a validate tag:
+//
+//	required   set: not null, zero, "" or [], unless a pointer
+//	min=n      numbers at least n; strings and lists at least n long
+//	max=n      numbers at most n; strings and lists at most n long
+//	oneof=a b  a string that is one of the words
+//	dive       the rules after it apply to each item of the list
+//
+// Only required checks a field that isn't set. Structs, pointers to them
+// and lists of them are checked field by field, and types with rules the
+// tags can't express, such as fields that exclude each other, implement
+// Checker.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is a field that breaks a rule
+type FieldError struct {
+	// Field is the path to the field in the JSON, such as "keys[2]"
+	Field string `json:"field"`
+	// Rule is the name of the rule broken, or "type" for a value of the
+	// wrong JSON type
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Errors is every field of a request that breaks a rule
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Checker is implemented by requests with rules tags can't express.
+// Fields in the errors returned are named relative to the value checked.
+type Checker interface {
+	Check() Errors
+}
+
+// Decode decodes JSON from r into v, which must be a pointer, and checks
+// it. A value of the wrong type or a broken rule is reported as Errors;
+// anything else, such as malformed JSON, as the error decoding returned.
+func Decode(r io.Reader, v interface{}) error {
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return Errors{{Field: fieldPath(typeErr.Field), Rule: "type", Message: "must be " + jsonType(typeErr.Type)}}
+		}
+		return err
+	}
+	if errs := Struct(v); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Struct checks v, a struct or a pointer to one, and returns every field
+// that breaks a rule
+func Struct(v interface{}) Errors {
+	var errs Errors
+	check(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+// check checks the fields of v, or of the items of v, and then v itself
+// when it is a Checker
+func check(v reflect.Value, path string, errs *Errors) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	checkFields(v, path, errs)
+
+	var c Checker
+	switch {
+	case v.CanAddr() && v.Addr().CanInterface() && v.Addr().Type().Implements(reflect.TypeOf(&c).Elem()):
+		c = v.Addr().Interface().(Checker)
+	case v.CanInterface() && v.Type().Implements(reflect.TypeOf(&c).Elem()):
+		c = v.Interface().(Checker)
+	default:
+		return
+	}
+	for _, fe := range c.Check() {
+		fe.Field = join(path, fe.Field)
+		*errs = append(*errs, fe)
+	}
+}
+
+// checkFields checks the fields of v, or of the items of v
+func checkFields(v reflect.Value, path string, errs *Errors) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			// The fields of embedded structs are the outer struct's, even
+			// when the struct's type isn't exported. So is their Check
+			// method, unless the outer struct has its own.
+			if f.Anonymous && name == "" {
+				embedded := v.Field(i)
+				for embedded.Kind() == reflect.Pointer && !embedded.IsNil() {
+					embedded = embedded.Elem()
+				}
+				checkFields(embedded, path, errs)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			field := join(path, name)
+			checkRules(v.Field(i), field, f.Tag.Get("validate"), errs)
+			check(v.Field(i), field, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			check(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// fieldPath turns the path encoding/json gives a field, such as
+// "items.0.name", into the form of the other errors, "items[0].name"
+func fieldPath(jsonPath string) string {
+	var path string
+	for _, part := range strings.Split(jsonPath, ".") {
+		if _, err := strconv.Atoi(part); err == nil && path != "" {
+			path += "[" + part + "]"
+		} else {
+			path = join(path, part)
+		}
+	}
+	return path
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkRules checks v, the value of field, against tag. Only the first
+// rule broken is reported.
+func checkRules(v reflect.Value, field, tag string, errs *Errors) {
+	if tag == "" {
+		return
+	}
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		if rule == "dive" {
+			for v.Kind() == reflect.Pointer {
+				if v.IsNil() {
+					return
+				}
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				panic("validation: dive on " + field + ", which isn't a list")
+			}
+			itemTag := strings.Join(rules[i+1:], ",")
+			for j := 0; j < v.Len(); j++ {
+				checkRules(v.Index(j), fmt.Sprintf("%s[%d]", field, j), itemTag, errs)
+			}
+			return
+		}
+		name, arg, _ := strings.Cut(rule, "=")
+		if msg := apply(v, name, arg); msg != "" {
+			*errs = append(*errs, FieldError{Field: field, Rule: name, Message: msg})
+			return
+		}
+	}
+}
+
+// apply returns how v breaks the rule name with arg, or "" if it doesn't.
+// Unknown rules panic: they are mistakes in a tag.
+func apply(v reflect.Value, name, arg string) string {
+	// A pointer that isn't nil was set, even to zero
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		if name == "required" {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if isEmpty(v) {
+		if name == "required" {
+			return "is required"
+		}
+		return ""
+	}
+
+	switch name {
+	case "required":
+		return ""
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic("validation: bad " + name + " limit " + strconv.Quote(arg))
+		}
+		size, format := measure(v)
+		switch {
+		case name == "min" && size < limit:
+			return fmt.Sprintf(format, "at least "+arg)
+		case name == "max" && size > limit:
+			return fmt.Sprintf(format, "at most "+arg)
+		}
+		return ""
+	case "oneof":
+		words := strings.Fields(arg)
+		if v.Kind() != reflect.String {
+			panic("validation: oneof on a " + v.Kind().String())
+		}
+		for _, w := range words {
+			if v.String() == w {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(words, ", ")
+	}
+	panic("validation: unknown rule " + strconv.Quote(name))
+}
+
+// isEmpty reports whether v is unset: nil, zero or of length zero
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// measure returns the number min and max compare for v, and the format
+// of their messages, given the bound
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "must be %s"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "must be %s"
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "must be %s"
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "must be %s characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), "must have %s items"
+	}
+	panic("validation: min or max on a " + v.Kind().String())
+}
+
+// jsonType names the JSON type that decodes into t
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
@@ -0,0 +1,111 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type options struct {
+	Mode string `json:"mode,omitempty" validate:"oneof=fast slow"`
+}
+
+type item struct {
+	Name string `json:"name" validate:"required,max=4"`
+}
+
+type request struct {
+	Path   string   `json:"path" validate:"required"`
+	Count  int      `json:"count" validate:"min=1,max=10"`
+	Tags   []string `json:"tags" validate:"max=2,dive,required"`
+	Ratio  *float64 `json:"ratio" validate:"required,min=0,max=1"`
+	Items  []item   `json:"items"`
+	Hidden string   `json:"-" validate:"required"`
+	options
+}
+
+// Check makes tags and items exclude each other
+func (r request) Check() Errors {
+	if len(r.Tags) > 0 && len(r.Items) > 0 {
+		return Errors{{Field: "items", Rule: "excluded_with", Message: "must not be set with tags"}}
+	}
+	return nil
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Errors
+	}{
+		{name: "valid", body: `{"path": "a", "count": 3, "ratio": 0, "mode": "fast", "items": [{"name": "x"}]}`},
+		{name: "unset", body: `{}`, want: Errors{
+			{Field: "path", Rule: "required", Message: "is required"},
+			{Field: "ratio", Rule: "required", Message: "is required"},
+		}},
+		{name: "out of range", body: `{"path": "a", "count": 11, "tags": ["a", "", "c"], "ratio": 1.5, "mode": "medium"}`, want: Errors{
+			{Field: "count", Rule: "max", Message: "must be at most 10"},
+			{Field: "tags", Rule: "max", Message: "must have at most 2 items"},
+			{Field: "ratio", Rule: "max", Message: "must be at most 1"},
+			{Field: "mode", Rule: "oneof", Message: "must be one of fast, slow"},
+		}},
+		{name: "items", body: `{"path": "a", "tags": ["", "b"], "ratio": 0.5, "items": [{"name": "x"}, {"name": "toolong"}, {}]}`, want: Errors{
+			{Field: "tags[0]", Rule: "required", Message: "is required"},
+			{Field: "items[1].name", Rule: "max", Message: "must be at most 4 characters long"},
+			{Field: "items[2].name", Rule: "required", Message: "is required"},
+			{Field: "items", Rule: "excluded_with", Message: "must not be set with tags"},
+		}},
+		{name: "wrong type", body: `{"path": "a", "items": [{"name": 1}]}`, want: Errors{
+			{Field: "items[0].name", Rule: "type", Message: "must be a string"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req request
+			err := Decode(strings.NewReader(tt.body), &req)
+			var got Errors
+			if err != nil && !errors.As(err, &got) {
+				t.Fatalf("Decode() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	var req request
+	if err := Decode(strings.NewReader(`{"path": `), &req); err == nil || errors.As(err, new(Errors)) {
+		t.Errorf("Decode() of malformed JSON = %v", err)
+	}
+}
+
+type overrides struct {
+	Filename string `json:"filename"`
+}
+
+func (o overrides) Check() Errors {
+	if strings.Contains(o.Filename, "/") {
+		return Errors{{Field: "filename", Rule: "filename", Message: "must not contain slashes"}}
+	}
+	return nil
+}
+
+// Embedded checkers are promoted, and so checked once, as the outer struct
+func TestEmbeddedChecker(t *testing.T) {
+	var req struct {
+		Path string `json:"path"`
+		overrides
+	}
+	want := Errors{{Field: "filename", Rule: "filename", Message: "must not contain slashes"}}
+	if err := Decode(strings.NewReader(`{"path": "a", "filename": "a/b"}`), &req); !reflect.DeepEqual(err, want) {
+		t.Errorf("Decode() = %#v, want %#v", err, want)
+	}
+}
+
+func TestErrorsError(t *testing.T) {
+	errs := Errors{{Field: "path", Message: "is required"}, {Field: "count", Message: "must be at most 10"}}
+	if got := errs.Error(); got != "path is required; count must be at most 10" {
+		t.Errorf("Error() = %q", got)
+	}
+}
@@ -0,0 +1,279 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DASHManifest returns the name of the DASH manifest of the package
+// called name
+func DASHManifest(name string) string {
+	return name + "_manifest.mpd"
+}
+
+// defaultCodecs are the RFC 6381 codec strings manifests give encoders
+// the master playlist doesn't name the output of
+var defaultCodecs = map[string]string{
+	"libx264":   "avc1.640028",
+	"libx265":   "hvc1.1.6.L120.90",
+	"libsvtav1": "av01.0.08M.08",
+	"aac":       "mp4a.40.2",
+	"libopus":   "opus",
+}
+
+// mediaPlaylist is what a DASH representation needs from an HLS media
+// playlist: its init segment and its media segments with their lengths
+type mediaPlaylist struct {
+	init      string
+	segments  []string
+	durations []int64 // milliseconds
+}
+
+func (m mediaPlaylist) duration() int64 {
+	var total int64
+	for _, d := range m.durations {
+		total += d
+	}
+	return total
+}
+
+// parseMediaPlaylist reads a VOD media playlist of fragmented MP4
+// segments
+func parseMediaPlaylist(data []byte) (mediaPlaylist, error) {
+	var m mediaPlaylist
+	var pending int64 = -1
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			m.init = parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))["URI"]
+		case strings.HasPrefix(line, "#EXTINF:"):
+			d, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			seconds, err := strconv.ParseFloat(d, 64)
+			if err != nil {
+				return m, fmt.Errorf("bad segment duration %q", d)
+			}
+			pending = int64(math.Round(seconds * 1000))
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if pending < 0 {
+				return m, fmt.Errorf("segment %s has no duration", line)
+			}
+			m.segments = append(m.segments, line)
+			m.durations = append(m.durations, pending)
+			pending = -1
+		}
+	}
+	if m.init == "" || len(m.segments) == 0 {
+		return m, fmt.Errorf("not a fragmented MP4 playlist")
+	}
+	return m, sc.Err()
+}
+
+// parseMaster returns the attributes of the variants in a master
+// playlist, by their playlist's URI
+func parseMaster(data []byte) map[string]map[string]string {
+	variants := make(map[string]map[string]string)
+	var pending map[string]string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			pending = parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+		case line == "" || strings.HasPrefix(line, "#"):
+		case pending != nil:
+			variants[line] = pending
+			pending = nil
+		}
+	}
+	return variants
+}
+
+// parseAttributes splits an HLS attribute list, whose quoted values may
+// hold commas
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(key)] = value
+		s = rest
+	}
+	return attrs
+}
+
+// splitCodecs separates the video and audio codecs of a CODECS
+// attribute
+func splitCodecs(codecs string) (videoCodec, audioCodec string) {
+	for _, c := range strings.Split(codecs, ",") {
+		if c = strings.TrimSpace(c); strings.HasPrefix(c, "mp4a") || c == "opus" {
+			audioCodec = c
+		} else if videoCodec == "" {
+			videoCodec = c
+		}
+	}
+	return videoCodec, audioCodec
+}
+
+// The MPD elements MPD writes
+type (
+	mpdXML struct {
+		XMLName       xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+		Profiles      string   `xml:"profiles,attr"`
+		Type          string   `xml:"type,attr"`
+		Duration      string   `xml:"mediaPresentationDuration,attr"`
+		MinBufferTime string   `xml:"minBufferTime,attr"`
+		Period        struct {
+			ID   string          `xml:"id,attr"`
+			Sets []adaptationSet `xml:"AdaptationSet"`
+		} `xml:"Period"`
+	}
+	adaptationSet struct {
+		ID               int              `xml:"id,attr"`
+		ContentType      string           `xml:"contentType,attr"`
+		MimeType         string           `xml:"mimeType,attr"`
+		SegmentAlignment bool             `xml:"segmentAlignment,attr"`
+		StartWithSAP     int              `xml:"startWithSAP,attr"`
+		Representations  []representation `xml:"Representation"`
+	}
+	representation struct {
+		ID          string `xml:"id,attr"`
+		Bandwidth   int    `xml:"bandwidth,attr"`
+		Codecs      string `xml:"codecs,attr"`
+		Width       int    `xml:"width,attr,omitempty"`
+		Height      int    `xml:"height,attr,omitempty"`
+		SegmentList struct {
+			Timescale      int `xml:"timescale,attr"`
+			Initialization struct {
+				SourceURL string `xml:"sourceURL,attr"`
+			} `xml:"Initialization"`
+			Timeline []segmentTime `xml:"SegmentTimeline>S"`
+			URLs     []segmentURL  `xml:"SegmentURL"`
+		} `xml:"SegmentList"`
+	}
+	segmentTime struct {
+		D int64 `xml:"d,attr"`
+		R int   `xml:"r,attr,omitempty"`
+	}
+	segmentURL struct {
+		Media string `xml:"media,attr"`
+	}
+)
+
+// newRepresentation lists the segments of m, all relative to the
+// manifest, in a representation
+func newRepresentation(id string, bandwidth int, codecs string, m mediaPlaylist) representation {
+	r := representation{ID: id, Bandwidth: bandwidth, Codecs: codecs}
+	r.SegmentList.Timescale = 1000
+	r.SegmentList.Initialization.SourceURL = m.init
+	for i, seg := range m.segments {
+		d := m.durations[i]
+		if n := len(r.SegmentList.Timeline); n > 0 && r.SegmentList.Timeline[n-1].D == d {
+			r.SegmentList.Timeline[n-1].R++
+		} else {
+			r.SegmentList.Timeline = append(r.SegmentList.Timeline, segmentTime{D: d})
+		}
+		r.SegmentList.URLs = append(r.SegmentList.URLs, segmentURL{Media: seg})
+	}
+	return r
+}
+
+// MPD returns a DASH manifest for the package Args wrote into dir,
+// whose renditions it lists from the same init and media segments as
+// the HLS playlists, so one copy of the package serves both
+func MPD(p Preset, src Info, dir, name string) ([]byte, error) {
+	ladder := p.Ladder(src)
+	audio, group := audioRenditions(ladder)
+	master, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	variants := parseMaster(master)
+	read := func(rendition string) (mediaPlaylist, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name+"_"+rendition+".m3u8"))
+		if err != nil {
+			return mediaPlaylist{}, err
+		}
+		m, err := parseMediaPlaylist(data)
+		if err != nil {
+			return m, fmt.Errorf("%s playlist: %w", rendition, err)
+		}
+		return m, nil
+	}
+
+	var duration int64
+	videoSet := adaptationSet{ID: 0, ContentType: "video", MimeType: "video/mp4", SegmentAlignment: true, StartWithSAP: 1}
+	audioCodecs := make([]string, len(audio))
+	for i, r := range ladder {
+		m, err := read(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		duration = max(duration, m.duration())
+		attrs := variants[name+"_"+r.Name+".m3u8"]
+		videoCodec, audioCodec := splitCodecs(attrs["CODECS"])
+		if videoCodec == "" {
+			videoCodec = defaultCodecs[r.VideoCodec]
+		}
+		if audioCodec != "" {
+			audioCodecs[group[i]] = audioCodec
+		}
+		rep := newRepresentation(r.Name, r.VideoBitrate*1000, videoCodec, m)
+		if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+			rep.Width, _ = strconv.Atoi(w)
+			rep.Height, _ = strconv.Atoi(h)
+		}
+		videoSet.Representations = append(videoSet.Representations, rep)
+	}
+
+	var mpd mpdXML
+	mpd.Profiles = "urn:mpeg:dash:profile:isoff-main:2011"
+	mpd.Type = "static"
+	mpd.MinBufferTime = fmt.Sprintf("PT%dS", p.segmentSeconds())
+	mpd.Period.ID = "0"
+	mpd.Period.Sets = []adaptationSet{videoSet}
+	if src.HasAudio {
+		audioSet := adaptationSet{ID: 1, ContentType: "audio", MimeType: "audio/mp4", SegmentAlignment: true, StartWithSAP: 1}
+		for j, a := range audio {
+			m, err := read(a.Name())
+			if err != nil {
+				return nil, err
+			}
+			codec := audioCodecs[j]
+			if codec == "" {
+				codec = defaultCodecs[a.Codec]
+			}
+			audioSet.Representations = append(audioSet.Representations, newRepresentation(a.Name(), a.Bitrate*1000, codec, m))
+		}
+		mpd.Period.Sets = append(mpd.Period.Sets, audioSet)
+	}
+	mpd.Duration = fmt.Sprintf("PT%.3fS", float64(duration)/1000)
+
+	out, err := xml.MarshalIndent(mpd, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
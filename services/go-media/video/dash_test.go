@@ -0,0 +1,113 @@
+package video
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePackage writes the playlists ffmpeg would for two renditions
+// sharing one audio rendition
+func writePackage(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string]string{
+		"hls-web": `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_a0",NAME="audio-aac-128k",DEFAULT=YES,URI="hls-web_audio-aac-128k.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=3080000,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2",AUDIO="group_a0"
+hls-web_720p.m3u8
+
+#EXT-X-STREAM-INF:BANDWIDTH=880000,RESOLUTION=640x360,CODECS="avc1.64001e,mp4a.40.2",AUDIO="group_a0"
+hls-web_360p.m3u8
+`,
+		"hls-web_audio-aac-128k.m3u8": media("audio-aac-128k"),
+		"hls-web_720p.m3u8":           media("720p"),
+		"hls-web_360p.m3u8":           media("360p"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func media(rendition string) string {
+	return `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="hls-web_` + rendition + `_init.mp4"
+#EXTINF:6.000000,
+hls-web_` + rendition + `_0000.m4s
+#EXTINF:6.000000,
+hls-web_` + rendition + `_0001.m4s
+#EXTINF:2.500000,
+hls-web_` + rendition + `_0002.m4s
+#EXT-X-ENDLIST
+`
+}
+
+func TestMPD(t *testing.T) {
+	dir := t.TempDir()
+	writePackage(t, dir)
+	p := Preset{Name: "web", Renditions: []Rendition{
+		{Name: "720p", Height: 720, VideoCodec: "libx264", VideoBitrate: 2800, AudioCodec: "aac", AudioBitrate: 128},
+		{Name: "360p", Height: 360, VideoCodec: "libx264", VideoBitrate: 800, AudioCodec: "aac", AudioBitrate: 128},
+	}}
+	data, err := MPD(p, Info{Width: 1920, Height: 1080, HasAudio: true}, dir, "hls-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mpd mpdXML
+	if err := xml.Unmarshal(data, &mpd); err != nil {
+		t.Fatalf("%v:\n%s", err, data)
+	}
+	mpd.XMLName = xml.Name{}
+	if mpd.Type != "static" || mpd.Duration != "PT14.500S" || len(mpd.Period.Sets) != 2 {
+		t.Fatalf("unexpected manifest:\n%s", data)
+	}
+
+	videoSet, audioSet := mpd.Period.Sets[0], mpd.Period.Sets[1]
+	if len(videoSet.Representations) != 2 || len(audioSet.Representations) != 1 {
+		t.Fatalf("unexpected representations:\n%s", data)
+	}
+	hd := videoSet.Representations[0]
+	if hd.ID != "720p" || hd.Bandwidth != 2800000 || hd.Codecs != "avc1.64001f" || hd.Width != 1280 || hd.Height != 720 {
+		t.Errorf("720p representation = %+v", hd)
+	}
+	if got := hd.SegmentList.Initialization.SourceURL; got != "hls-web_720p_init.mp4" {
+		t.Errorf("720p init = %s", got)
+	}
+	if got := hd.SegmentList.Timeline; len(got) != 2 || got[0] != (segmentTime{D: 6000, R: 1}) || got[1] != (segmentTime{D: 2500}) {
+		t.Errorf("720p timeline = %+v", got)
+	}
+	if got := hd.SegmentList.URLs; len(got) != 3 || got[2].Media != "hls-web_720p_0002.m4s" {
+		t.Errorf("720p segments = %+v", got)
+	}
+	audio := audioSet.Representations[0]
+	if audio.ID != "audio-aac-128k" || audio.Bandwidth != 128000 || audio.Codecs != "mp4a.40.2" {
+		t.Errorf("audio representation = %+v", audio)
+	}
+
+	// Without audio in the source the audio playlist is never read
+	if err := os.Remove(filepath.Join(dir, "hls-web_audio-aac-128k.m3u8")); err != nil {
+		t.Fatal(err)
+	}
+	data, err = MPD(p, Info{Width: 1920, Height: 1080}, dir, "hls-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `contentType="audio"`) {
+		t.Errorf("audio adaptation set for a silent video:\n%s", data)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	got := parseAttributes(`BANDWIDTH=880000,CODECS="avc1.64001e,mp4a.40.2",RESOLUTION=640x360`)
+	if got["BANDWIDTH"] != "880000" || got["CODECS"] != "avc1.64001e,mp4a.40.2" || got["RESOLUTION"] != "640x360" {
+		t.Errorf("attributes = %v", got)
+	}
+}
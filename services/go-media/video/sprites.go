@@ -0,0 +1,74 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Seek-preview thumbnails are spriteWidth pixels wide, tiled into sheets
+// of spriteColumns by spriteRows
+const (
+	spriteWidth   = 160
+	spriteColumns = 10
+	spriteRows    = 10
+)
+
+// spriteSize returns the size of a thumbnail of src
+func spriteSize(src Info) (width, height int) {
+	if src.Width == 0 {
+		return spriteWidth, spriteWidth * 9 / 16
+	}
+	height = int(math.Round(spriteWidth*float64(src.Height)/float64(src.Width)/2)) * 2
+	return spriteWidth, max(2, height)
+}
+
+// SpriteArgs returns the ffmpeg arguments taking a thumbnail of input
+// every interval and tiling them into JPEG sheets in outDir, called
+// name_000.jpg onwards
+func SpriteArgs(src Info, interval time.Duration, input, outDir, name string) []string {
+	w, h := spriteSize(src)
+	return []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", input,
+		"-an", "-sn",
+		"-vf", fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d", strconv.FormatFloat(interval.Seconds(), 'f', -1, 64), w, h, spriteColumns, spriteRows),
+		"-q:v", "5",
+		"-start_number", "0",
+		filepath.Join(outDir, name+"_%03d.jpg"),
+	}
+}
+
+// SpriteVTT returns the WebVTT file pointing each interval of src at its
+// thumbnail in the sheets SpriteArgs writes, which it refers to by
+// their names, relative to itself
+func SpriteVTT(src Info, interval time.Duration, name string) []byte {
+	w, h := spriteSize(src)
+	perSheet := spriteColumns * spriteRows
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for i := 0; time.Duration(i)*interval < src.Duration; i++ {
+		start := time.Duration(i) * interval
+		end := min(start+interval, src.Duration)
+		pos := i % perSheet
+		fmt.Fprintf(&b, "\n%s --> %s\n%s_%03d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTime(start), vttTime(end), name, i/perSheet, pos%spriteColumns*w, pos/spriteColumns*h, w, h)
+	}
+	return b.Bytes()
+}
+
+// vttTime formats d as a WebVTT timestamp
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// Sprites writes the sprite sheets of src, as SpriteArgs describes
+func (t Transcoder) Sprites(ctx context.Context, src Info, interval time.Duration, input, outDir, name string) error {
+	if _, err := run(ctx, or(t.FFmpeg, "ffmpeg"), SpriteArgs(src, interval, input, outDir, name)...); err != nil {
+		return fmt.Errorf("making sprite sheets: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpriteArgs(t *testing.T) {
+	args := strings.Join(SpriteArgs(Info{Width: 1920, Height: 1080}, 5*time.Second, "in.mp4", "out", "sprites"), " ")
+	if want := "-vf fps=1/5,scale=160:90,tile=10x10 -q:v 5 -start_number 0 out/sprites_%03d.jpg"; !strings.Contains(args, want) {
+		t.Errorf("args missing %q:\n%s", want, args)
+	}
+	args = strings.Join(SpriteArgs(Info{Width: 1080, Height: 1920}, 2500*time.Millisecond, "in.mp4", "out", "sprites"), " ")
+	if want := "fps=1/2.5,scale=160:284,"; !strings.Contains(args, want) {
+		t.Errorf("portrait args missing %q:\n%s", want, args)
+	}
+}
+
+func TestSpriteVTT(t *testing.T) {
+	src := Info{Width: 1280, Height: 720, Duration: 1005 * time.Second}
+	vtt := string(SpriteVTT(src, 10*time.Second, "sprites"))
+	for _, want := range []string{
+		"WEBVTT\n\n00:00:00.000 --> 00:00:10.000\nsprites_000.jpg#xywh=0,0,160,90\n",
+		"\n00:00:10.000 --> 00:00:20.000\nsprites_000.jpg#xywh=160,0,160,90\n",
+		// The 12th thumbnail starts the second row
+		"\n00:01:50.000 --> 00:02:00.000\nsprites_000.jpg#xywh=160,90,160,90\n",
+		// The 101st starts the second sheet
+		"\n00:16:40.000 --> 00:16:45.000\nsprites_001.jpg#xywh=0,0,160,90\n",
+	} {
+		if !strings.Contains(vtt, want) {
+			t.Errorf("VTT missing %q", want)
+		}
+	}
+	if n := strings.Count(vtt, "-->"); n != 101 {
+		t.Errorf("%d cues, want 101", n)
+	}
+	if vtt := string(SpriteVTT(Info{Width: 1280, Height: 720}, 10*time.Second, "sprites")); vtt != "WEBVTT\n" {
+		t.Errorf("VTT for an unknown duration = %q", vtt)
+	}
+}
@@ -0,0 +1,75 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// Track kinds
+const (
+	KindSubtitles = "subtitles"
+	KindAudio     = "audio"
+)
+
+// textSubtitleCodecs are the subtitle formats ffmpeg can convert to
+// WebVTT. Bitmap ones, such as Blu-ray PGS and DVD subtitles, would need
+// OCR.
+var textSubtitleCodecs = []string{"subrip", "ass", "ssa", "mov_text", "webvtt", "text"}
+
+// Track is a subtitle track, or an audio track besides the first, of a
+// source video
+type Track struct {
+	// Index is the track's stream index in the source
+	Index    int    `json:"index"`
+	Kind     string `json:"kind"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+}
+
+// File returns the name the track is extracted to, beside the other
+// outputs called name: WebVTT for subtitles, AAC in MP4 for audio
+func (t Track) File(name string) string {
+	ext := ".m4a"
+	if t.Kind == KindSubtitles {
+		ext = ".vtt"
+	}
+	return name + "_" + strconv.Itoa(t.Index) + ext
+}
+
+// ExtractArgs returns the ffmpeg arguments extracting every track of src
+// from input into outDir in one pass, or nil if it has none
+func ExtractArgs(src Info, input, outDir, name string) []string {
+	if len(src.Tracks) == 0 {
+		return nil
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", input}
+	for _, t := range src.Tracks {
+		args = append(args, "-map", "0:"+strconv.Itoa(t.Index))
+		switch {
+		case t.Kind == KindSubtitles:
+			args = append(args, "-c:s", "webvtt")
+		case t.Codec == "aac":
+			args = append(args, "-c:a", "copy")
+		default:
+			args = append(args, "-c:a", "aac", "-b:a", "160k")
+		}
+		args = append(args, filepath.Join(outDir, t.File(name)))
+	}
+	return args
+}
+
+// Extract writes the tracks of src, as ExtractArgs describes
+func (t Transcoder) Extract(ctx context.Context, src Info, input, outDir, name string) error {
+	args := ExtractArgs(src, input, outDir, name)
+	if args == nil {
+		return nil
+	}
+	if _, err := run(ctx, or(t.FFmpeg, "ffmpeg"), args...); err != nil {
+		return fmt.Errorf("extracting tracks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package video
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const probeWithTracks = `{
+	"streams": [
+		{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080},
+		{"index": 1, "codec_type": "audio", "codec_name": "aac", "tags": {"language": "eng"}, "disposition": {"default": 1}},
+		{"index": 2, "codec_type": "audio", "codec_name": "ac3", "tags": {"language": "spa", "title": "Español"}},
+		{"index": 3, "codec_type": "subtitle", "codec_name": "subrip", "tags": {"language": "eng"}, "disposition": {"default": 1}},
+		{"index": 4, "codec_type": "subtitle", "codec_name": "hdmv_pgs_subtitle", "tags": {"language": "fra"}},
+		{"index": 5, "codec_type": "subtitle", "codec_name": "mov_text", "tags": {"language": "und", "title": "Commentary"}}
+	],
+	"format": {"duration": "60"}
+}`
+
+func TestParseProbeTracks(t *testing.T) {
+	info, err := ParseProbe([]byte(probeWithTracks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first audio track is part of the renditions, and bitmap
+	// subtitles can't become WebVTT
+	want := []Track{
+		{Index: 2, Kind: KindAudio, Codec: "ac3", Language: "spa", Title: "Español"},
+		{Index: 3, Kind: KindSubtitles, Codec: "subrip", Language: "eng", Default: true},
+		{Index: 5, Kind: KindSubtitles, Codec: "mov_text", Title: "Commentary"},
+	}
+	if !reflect.DeepEqual(info.Tracks, want) {
+		t.Errorf("tracks = %+v, want %+v", info.Tracks, want)
+	}
+}
+
+func TestExtractArgs(t *testing.T) {
+	info, err := ParseProbe([]byte(probeWithTracks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Tracks = append(info.Tracks, Track{Index: 6, Kind: KindAudio, Codec: "aac"})
+	args := strings.Join(ExtractArgs(info, "in.mkv", "out", "tracks"), " ")
+	for _, want := range []string{
+		"-map 0:2 -c:a aac -b:a 160k out/tracks_2.m4a",
+		"-map 0:3 -c:s webvtt out/tracks_3.vtt",
+		"-map 0:5 -c:s webvtt out/tracks_5.vtt",
+		"-map 0:6 -c:a copy out/tracks_6.m4a",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args missing %q:\n%s", want, args)
+		}
+	}
+
+	if args := ExtractArgs(Info{Width: 640, Height: 360, HasAudio: true}, "in.mp4", "out", "tracks"); args != nil {
+		t.Errorf("args for a video without tracks: %v", args)
+	}
+}
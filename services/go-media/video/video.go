@@ -0,0 +1,439 @@
+// Package video transcodes uploaded videos to HLS with ffmpeg. What is
+// encoded comes from presets, ladders of renditions each with its own
+// height, codecs and bitrates, so adding a rung or switching codec is a
+// configuration change rather than a code one.
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoders a rendition may use
+var (
+	videoCodecs = []string{"libx264", "libx265", "libsvtav1"}
+	audioCodecs = []string{"aac", "libopus"}
+)
+
+// defaultSegmentSeconds is the segment length of presets that don't set
+// one
+const defaultSegmentSeconds = 6
+
+// ErrNoVideo is returned for files without a video stream
+var ErrNoVideo = errors.New("no video stream")
+
+// Rendition is one rung of a ladder
+type Rendition struct {
+	// Name is the rendition's part of its playlist and segment names,
+	// such as 720p
+	Name string `json:"name"`
+	// Height is that of the shorter side, so a portrait video's 720p
+	// rendition is 720 pixels wide. The other side keeps the aspect
+	// ratio.
+	Height       int    `json:"height"`
+	VideoCodec   string `json:"video_codec"`
+	VideoBitrate int    `json:"video_bitrate_kbps"`
+	AudioCodec   string `json:"audio_codec"`
+	AudioBitrate int    `json:"audio_bitrate_kbps"`
+}
+
+// Preset is a named ladder of renditions
+type Preset struct {
+	Name string `json:"name"`
+	// SegmentSeconds is the target length of each segment, 6 when 0
+	SegmentSeconds int         `json:"segment_seconds"`
+	Renditions     []Rendition `json:"renditions"`
+}
+
+// DefaultPresets are used when the configuration defines none: standard,
+// an H.264 ladder from 1080p down to 360p, and mobile, two smaller rungs
+// for cellular connections
+func DefaultPresets() []Preset {
+	return []Preset{
+		{Name: "standard", SegmentSeconds: 6, Renditions: []Rendition{
+			{Name: "1080p", Height: 1080, VideoCodec: "libx264", VideoBitrate: 5000, AudioCodec: "aac", AudioBitrate: 128},
+			{Name: "720p", Height: 720, VideoCodec: "libx264", VideoBitrate: 2800, AudioCodec: "aac", AudioBitrate: 128},
+			{Name: "480p", Height: 480, VideoCodec: "libx264", VideoBitrate: 1400, AudioCodec: "aac", AudioBitrate: 96},
+			{Name: "360p", Height: 360, VideoCodec: "libx264", VideoBitrate: 800, AudioCodec: "aac", AudioBitrate: 96},
+		}},
+		{Name: "mobile", SegmentSeconds: 6, Renditions: []Rendition{
+			{Name: "720p", Height: 720, VideoCodec: "libx264", VideoBitrate: 2000, AudioCodec: "aac", AudioBitrate: 96},
+			{Name: "360p", Height: 360, VideoCodec: "libx264", VideoBitrate: 600, AudioCodec: "aac", AudioBitrate: 64},
+		}},
+	}
+}
+
+// Validate reports the first problem with p
+func (p Preset) Validate() error {
+	if !validName(p.Name) {
+		return fmt.Errorf("preset name %q must be lowercase letters, digits and dashes", p.Name)
+	}
+	if p.SegmentSeconds < 0 || p.SegmentSeconds > 60 {
+		return fmt.Errorf("preset %s: segment_seconds must be between 0 (for 6) and 60", p.Name)
+	}
+	if len(p.Renditions) == 0 {
+		return fmt.Errorf("preset %s has no renditions", p.Name)
+	}
+	seen := make(map[string]bool)
+	for _, r := range p.Renditions {
+		switch {
+		case !validName(r.Name):
+			return fmt.Errorf("preset %s: rendition name %q must be lowercase letters, digits and dashes", p.Name, r.Name)
+		case seen[r.Name]:
+			return fmt.Errorf("preset %s: rendition %s is defined twice", p.Name, r.Name)
+		case r.Height < 2 || r.Height > 4320 || r.Height%2 != 0:
+			return fmt.Errorf("preset %s: rendition %s: height must be even and at most 4320", p.Name, r.Name)
+		case !contains(videoCodecs, r.VideoCodec):
+			return fmt.Errorf("preset %s: rendition %s: video_codec must be one of %s", p.Name, r.Name, strings.Join(videoCodecs, ", "))
+		case !contains(audioCodecs, r.AudioCodec):
+			return fmt.Errorf("preset %s: rendition %s: audio_codec must be one of %s", p.Name, r.Name, strings.Join(audioCodecs, ", "))
+		case r.VideoBitrate < 1 || r.AudioBitrate < 1:
+			return fmt.Errorf("preset %s: rendition %s: bitrates must be positive", p.Name, r.Name)
+		}
+		seen[r.Name] = true
+	}
+	return nil
+}
+
+// validName reports whether s can be part of an object key as is, and
+// holds no underscore, which separates the parts of output names
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func contains[T comparable](list []T, v T) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Preset) segmentSeconds() int {
+	if p.SegmentSeconds == 0 {
+		return defaultSegmentSeconds
+	}
+	return p.SegmentSeconds
+}
+
+// Info describes a source video
+type Info struct {
+	// Width and Height are as displayed, after any rotation
+	Width, Height int
+	HasAudio      bool
+	Duration      time.Duration
+	// Tracks are those that can be extracted
+	Tracks []Track
+}
+
+// short is the length of the shorter side
+func (i Info) short() int {
+	return min(i.Width, i.Height)
+}
+
+// Ladder returns the renditions of p worth encoding from src: those no
+// larger than it, since upscaling only wastes bytes. A source smaller
+// than every rung gets the lowest one at its own size.
+func (p Preset) Ladder(src Info) []Rendition {
+	var ladder []Rendition
+	for _, r := range p.Renditions {
+		if r.Height <= src.short() {
+			ladder = append(ladder, r)
+		}
+	}
+	if len(ladder) == 0 && len(p.Renditions) > 0 {
+		low := p.Renditions[0]
+		for _, r := range p.Renditions[1:] {
+			if r.Height < low.Height {
+				low = r
+			}
+		}
+		low.Height = max(2, src.short()&^1)
+		ladder = []Rendition{low}
+	}
+	return ladder
+}
+
+// probeOutput is the part of ffprobe's JSON output Probe reads
+type probeOutput struct {
+	Streams []struct {
+		Index     int    `json:"index"`
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		Tags      struct {
+			Rotate   string `json:"rotate"`
+			Language string `json:"language"`
+			Title    string `json:"title"`
+		} `json:"tags"`
+		Disposition struct {
+			Default int `json:"default"`
+		} `json:"disposition"`
+		SideData []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// ParseProbe reads the output of ffprobe -print_format json -show_streams
+// -show_format
+func ParseProbe(out []byte) (Info, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return Info{}, fmt.Errorf("reading ffprobe output: %w", err)
+	}
+	var info Info
+	found := false
+	for _, s := range probe.Streams {
+		track := Track{
+			Index:    s.Index,
+			Codec:    s.CodecName,
+			Language: s.Tags.Language,
+			Title:    s.Tags.Title,
+			Default:  s.Disposition.Default == 1,
+		}
+		if track.Language == "und" {
+			track.Language = ""
+		}
+		switch s.CodecType {
+		case "audio":
+			// The first audio track is the one encoded with the video
+			if info.HasAudio {
+				track.Kind = KindAudio
+				info.Tracks = append(info.Tracks, track)
+			}
+			info.HasAudio = true
+		case "subtitle":
+			if contains(textSubtitleCodecs, s.CodecName) {
+				track.Kind = KindSubtitles
+				info.Tracks = append(info.Tracks, track)
+			}
+		case "video":
+			if found || s.Width == 0 || s.Height == 0 {
+				continue
+			}
+			found = true
+			info.Width, info.Height = s.Width, s.Height
+			// Phones record portrait videos as rotated landscape ones;
+			// ffmpeg applies the rotation when decoding
+			rotation, _ := strconv.ParseFloat(s.Tags.Rotate, 64)
+			for _, d := range s.SideData {
+				if d.Rotation != 0 {
+					rotation = d.Rotation
+				}
+			}
+			if int(math.Abs(rotation))%180 == 90 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+		}
+	}
+	if !found {
+		return Info{}, ErrNoVideo
+	}
+	if d, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(d * float64(time.Second))
+	}
+	return info, nil
+}
+
+// audioRendition is an audio encoding renditions share
+type audioRendition struct {
+	Codec   string
+	Bitrate int
+}
+
+// Name is the audio rendition's part of its playlist and segment names
+func (a audioRendition) Name() string {
+	return "audio-" + a.Codec + "-" + kbps(a.Bitrate)
+}
+
+// audioRenditions returns the distinct audio encodings of ladder, and
+// the index in them of each rendition's
+func audioRenditions(ladder []Rendition) (audio []audioRendition, group []int) {
+	group = make([]int, len(ladder))
+	for i, r := range ladder {
+		a := audioRendition{r.AudioCodec, r.AudioBitrate}
+		group[i] = len(audio)
+		for j, b := range audio {
+			if a == b {
+				group[i] = j
+			}
+		}
+		if group[i] == len(audio) {
+			audio = append(audio, a)
+		}
+	}
+	return audio, group
+}
+
+// Args returns the ffmpeg arguments encoding input with p in one pass,
+// writing into outDir a master playlist called name, and beside it
+// name_<rendition>.m3u8 playlists with their fragmented MP4 init
+// segments and media segments. Audio is packaged separately, CMAF
+// style, as one rendition per distinct codec and bitrate in the ladder,
+// which the video renditions refer to as their audio group. Keyframes
+// are forced at every segment boundary so all renditions switch
+// cleanly.
+func Args(p Preset, src Info, input, outDir, name string) []string {
+	ladder := p.Ladder(src)
+	seg := p.segmentSeconds()
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v:0]split=%d", len(ladder))
+	for i := range ladder {
+		fmt.Fprintf(&filter, "[s%d]", i)
+	}
+	for i, r := range ladder {
+		size := fmt.Sprintf("-2:%d", r.Height)
+		if src.Width < src.Height {
+			size = fmt.Sprintf("%d:-2", r.Height)
+		}
+		fmt.Fprintf(&filter, ";[s%d]scale=%s,format=yuv420p[v%d]", i, size, i)
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", input,
+		"-filter_complex", filter.String()}
+	audio, group := audioRenditions(ladder)
+	var streams []string
+	for i, r := range ladder {
+		n := strconv.Itoa(i)
+		args = append(args, "-map", "[v"+n+"]",
+			"-c:v:"+n, r.VideoCodec,
+			"-b:v:"+n, kbps(r.VideoBitrate),
+			"-maxrate:v:"+n, kbps(r.VideoBitrate*107/100),
+			"-bufsize:v:"+n, kbps(r.VideoBitrate*3/2))
+		if r.VideoCodec == "libx265" {
+			// Apple players want HEVC tagged hvc1 rather than hev1
+			args = append(args, "-tag:v:"+n, "hvc1")
+		}
+		stream := "v:" + n
+		if src.HasAudio {
+			stream += ",agroup:a" + strconv.Itoa(group[i])
+		}
+		streams = append(streams, stream+",name:"+r.Name)
+	}
+	if src.HasAudio {
+		for j, a := range audio {
+			n := strconv.Itoa(j)
+			args = append(args, "-map", "0:a:0", "-c:a:"+n, a.Codec, "-b:a:"+n, kbps(a.Bitrate))
+			streams = append(streams, "a:"+n+",agroup:a"+n+",name:"+a.Name())
+		}
+		args = append(args, "-ac", "2")
+	}
+	return append(args,
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", seg),
+		"-sc_threshold", "0",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(seg),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", name+"_%v_init.mp4",
+		"-hls_segment_filename", filepath.Join(outDir, name+"_%v_%04d.m4s"),
+		"-master_pl_name", name,
+		"-var_stream_map", strings.Join(streams, " "),
+		filepath.Join(outDir, name+"_%v.m3u8"),
+	)
+}
+
+func kbps(n int) string {
+	return strconv.Itoa(n) + "k"
+}
+
+// ContentType returns the content type of a file Transcode, Extract or
+// Sprites writes, given its name. The master playlist has no extension.
+func ContentType(file string) string {
+	switch path.Ext(file) {
+	case ".vtt":
+		return "text/vtt; charset=utf-8"
+	case ".m4a":
+		return "audio/mp4"
+	case ".jpg":
+		return "image/jpeg"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	case ".mpd":
+		return "application/dash+xml"
+	}
+	return "application/vnd.apple.mpegurl"
+}
+
+// Transcoder runs ffprobe and ffmpeg
+type Transcoder struct {
+	// FFmpeg and FFprobe are the binaries' paths, looked up on the PATH
+	// when empty
+	FFmpeg  string
+	FFprobe string
+}
+
+// Probe describes the video at input
+func (t Transcoder) Probe(ctx context.Context, input string) (Info, error) {
+	out, err := run(ctx, or(t.FFprobe, "ffprobe"), "-v", "error", "-print_format", "json", "-show_streams", "-show_format", input)
+	if err != nil {
+		return Info{}, err
+	}
+	return ParseProbe(out)
+}
+
+// Transcode encodes input, described by src, with p into outDir, as
+// Args describes, then writes the package's DASH manifest beside the
+// HLS playlists
+func (t Transcoder) Transcode(ctx context.Context, p Preset, src Info, input, outDir, name string) error {
+	if _, err := run(ctx, or(t.FFmpeg, "ffmpeg"), Args(p, src, input, outDir, name)...); err != nil {
+		return err
+	}
+	mpd, err := MPD(p, src, outDir, name)
+	if err != nil {
+		return fmt.Errorf("writing DASH manifest: %w", err)
+	}
+	return os.WriteFile(filepath.Join(outDir, DASHManifest(name)), mpd, 0o644)
+}
+
+// run runs a command, returning its output or an error carrying the end
+// of what it logged
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := bytes.TrimSpace(stderr.Bytes())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return nil, fmt.Errorf("%s: %w: %s", filepath.Base(name), err, msg)
+	}
+	return out, nil
+}
+
+func or(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package video
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultPresetsValid(t *testing.T) {
+	for _, p := range DefaultPresets() {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%s: %v", p.Name, err)
+		}
+	}
+}
+
+func TestPresetValidate(t *testing.T) {
+	ok := Rendition{Name: "720p", Height: 720, VideoCodec: "libx264", VideoBitrate: 2800, AudioCodec: "aac", AudioBitrate: 128}
+	with := func(edit func(*Rendition)) []Rendition {
+		r := ok
+		edit(&r)
+		return []Rendition{r}
+	}
+	tests := []struct {
+		name   string
+		preset Preset
+	}{
+		{"bad name", Preset{Name: "Web_HD", Renditions: []Rendition{ok}}},
+		{"no renditions", Preset{Name: "web"}},
+		{"long segments", Preset{Name: "web", SegmentSeconds: 120, Renditions: []Rendition{ok}}},
+		{"duplicate rendition", Preset{Name: "web", Renditions: []Rendition{ok, ok}}},
+		{"odd height", Preset{Name: "web", Renditions: with(func(r *Rendition) { r.Height = 721 })}},
+		{"unknown codec", Preset{Name: "web", Renditions: with(func(r *Rendition) { r.VideoCodec = "h264; rm -rf /" })}},
+		{"unknown audio codec", Preset{Name: "web", Renditions: with(func(r *Rendition) { r.AudioCodec = "mp3" })}},
+		{"no bitrate", Preset{Name: "web", Renditions: with(func(r *Rendition) { r.VideoBitrate = 0 })}},
+	}
+	for _, tt := range tests {
+		if err := tt.preset.Validate(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestLadder(t *testing.T) {
+	standard := DefaultPresets()[0]
+	tests := []struct {
+		name string
+		src  Info
+		want []string // rendition name:height
+	}{
+		{"full HD", Info{Width: 1920, Height: 1080}, []string{"1080p:1080", "720p:720", "480p:480", "360p:360"}},
+		{"no upscaling", Info{Width: 1280, Height: 720}, []string{"720p:720", "480p:480", "360p:360"}},
+		{"portrait", Info{Width: 720, Height: 1280}, []string{"720p:720", "480p:480", "360p:360"}},
+		{"tiny", Info{Width: 320, Height: 181}, []string{"360p:180"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range standard.Ladder(tt.src) {
+			got = append(got, r.Name+":"+strconv.Itoa(r.Height))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: ladder %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	out := `{
+		"streams": [
+			{"codec_type": "video", "width": 1920, "height": 1080, "side_data_list": [{"rotation": -90}]},
+			{"codec_type": "audio"}
+		],
+		"format": {"duration": "12.500000"}
+	}`
+	info, err := ParseProbe([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{Width: 1080, Height: 1920, HasAudio: true, Duration: 12500 * time.Millisecond}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+
+	if _, err := ParseProbe([]byte(`{"streams": [{"codec_type": "audio"}], "format": {}}`)); err != ErrNoVideo {
+		t.Errorf("audio only: err = %v, want ErrNoVideo", err)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	p := Preset{Name: "web", SegmentSeconds: 4, Renditions: []Rendition{
+		{Name: "720p", Height: 720, VideoCodec: "libx265", VideoBitrate: 2000, AudioCodec: "aac", AudioBitrate: 128},
+		{Name: "360p", Height: 360, VideoCodec: "libx264", VideoBitrate: 600, AudioCodec: "libopus", AudioBitrate: 64},
+	}}
+	args := strings.Join(Args(p, Info{Width: 1280, Height: 720, HasAudio: true}, "/tmp/in.mov", "/tmp/out", "hls-web"), " ")
+	for _, want := range []string{
+		"-filter_complex [0:v:0]split=2[s0][s1];[s0]scale=-2:720,format=yuv420p[v0];[s1]scale=-2:360,format=yuv420p[v1]",
+		"-map [v0] -c:v:0 libx265 -b:v:0 2000k -maxrate:v:0 2140k -bufsize:v:0 3000k -tag:v:0 hvc1",
+		"-map 0:a:0 -c:a:1 libopus -b:a:1 64k",
+		"-force_key_frames expr:gte(t,n_forced*4)",
+		"-hls_time 4",
+		"-hls_fmp4_init_filename hls-web_%v_init.mp4",
+		"-hls_segment_filename /tmp/out/hls-web_%v_%04d.m4s",
+		"-master_pl_name hls-web",
+		"-var_stream_map v:0,agroup:a0,name:720p v:1,agroup:a1,name:360p a:0,agroup:a0,name:audio-aac-128k a:1,agroup:a1,name:audio-libopus-64k /tmp/out/hls-web_%v.m3u8",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args missing %q:\n%s", want, args)
+		}
+	}
+
+	silent := strings.Join(Args(p, Info{Width: 360, Height: 640}, "in.mp4", "out", "hls-web"), " ")
+	if strings.Contains(silent, "0:a:0") || !strings.Contains(silent, "scale=360:-2") || !strings.Contains(silent, "-var_stream_map v:0,name:360p out/hls-web_%v.m3u8") {
+		t.Errorf("portrait video without audio:\n%s", silent)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for file, want := range map[string]string{
+		"hls-web":               "application/vnd.apple.mpegurl",
+		"hls-web_720p.m3u8":     "application/vnd.apple.mpegurl",
+		"hls-web_720p_0003.m4s": "video/iso.segment",
+		"hls-web_720p_init.mp4": "video/mp4",
+		"hls-web_manifest.mpd":  "application/dash+xml",
+		"tracks_3.vtt":          "text/vtt; charset=utf-8",
+		"sprites_000.jpg":       "image/jpeg",
+	} {
+		if got := ContentType(file); got != want {
+			t.Errorf("ContentType(%s) = %s, want %s", file, got, want)
+		}
+	}
+}
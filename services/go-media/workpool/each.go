@@ -0,0 +1,39 @@
+package workpool
+
+import "sync"
+
+// KeyFailure is a key that batch work failed on, and why
+type KeyFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// Each calls work for every key, on up to workers goroutines at once,
+// and returns once all calls have. The function work returns, if not
+// nil, records the key's result: those calls are serialized, so they
+// can update shared state without a lock of their own.
+func Each(keys []string, workers int, work func(key string) (record func())) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				if record := work(key); record != nil {
+					mu.Lock()
+					record()
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		queue <- key
+	}
+	close(queue)
+	wg.Wait()
+}
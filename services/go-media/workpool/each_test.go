@@ -0,0 +1,36 @@
+package workpool
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEach(t *testing.T) {
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+
+	var running, peak int32
+	var done []string
+	Each(keys, 3, func(key string) func() {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		if key == "7" {
+			return nil
+		}
+		return func() { done = append(done, key) }
+	})
+
+	if peak > 3 {
+		t.Errorf("peak concurrency = %d, want at most 3", peak)
+	}
+	if len(done) != 19 {
+		t.Errorf("recorded %d keys, want 19: %v", len(done), done)
+	}
+}
@@ -0,0 +1,84 @@
+// Package workpool bounds CPU- and memory-heavy work such as building
+// image and video variants. A burst of uncached transformations would
+// otherwise decode every source at once; the pool runs a fixed number
+// of them, queues a bounded number more and turns the rest away. Each
+// spreads batch work on a list of keys over a few goroutines.
+package workpool
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/WomB0ComB0/cdn/services/go-media/metrics"
+)
+
+// ErrSaturated is returned for a job that found the queue full, or
+// waited in it longer than the pool allows
+var ErrSaturated = errors.New("transformation queue is full")
+
+// Pool runs jobs on at most workers goroutines at once. A nil *Pool
+// runs every job immediately.
+type Pool struct {
+	slots   chan struct{} // one per running job
+	admit   chan struct{} // one per running or queued job
+	maxWait time.Duration
+
+	jobs     *metrics.Counter
+	rejected *metrics.Counter
+	wait     *metrics.Histogram
+}
+
+// New returns a pool running up to workers jobs, with up to queue more
+// waiting, each for at most maxWait. It registers its metrics in reg
+// and returns nil, which bounds nothing, when workers is not positive.
+func New(workers, queue int, maxWait time.Duration, reg *metrics.Registry) *Pool {
+	if workers <= 0 {
+		return nil
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &Pool{
+		slots:    make(chan struct{}, workers),
+		admit:    make(chan struct{}, workers+queue),
+		maxWait:  maxWait,
+		jobs:     reg.Counter("cdn_transform_jobs_total", "Transformations run"),
+		rejected: reg.Counter("cdn_transform_rejected_total", "Transformations turned away because the queue was full or too slow"),
+		wait: reg.Histogram("cdn_transform_queue_wait_seconds", "Time transformations waited for a worker",
+			[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}),
+	}
+}
+
+// Do runs fn once a worker is free and returns its error. It returns
+// ErrSaturated without running fn when the queue is full or no worker
+// frees up within the pool's wait, and ctx's error if ctx ends first.
+func (p *Pool) Do(ctx context.Context, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+	select {
+	case p.admit <- struct{}{}:
+	default:
+		p.rejected.Inc()
+		return ErrSaturated
+	}
+	defer func() { <-p.admit }()
+
+	start := time.Now()
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		p.rejected.Inc()
+		return ErrSaturated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	p.wait.Observe(time.Since(start).Seconds())
+	p.jobs.Inc()
+	return fn()
+}
@@ -0,0 +1,83 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	p := New(2, 8, time.Second, nil)
+
+	var (
+		mu            sync.Mutex
+		running, peak int
+		wg            sync.WaitGroup
+	)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Do(context.Background(), func() error {
+				mu.Lock()
+				running++
+				if running > peak {
+					peak = running
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak)
+	}
+}
+
+func TestPoolRejectsWhenSaturated(t *testing.T) {
+	p := New(1, 1, 20*time.Millisecond, nil)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.Do(context.Background(), func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// The queued job gives up after the pool's wait
+	queued := make(chan error)
+	go func() { queued <- p.Do(context.Background(), func() error { return nil }) }()
+	time.Sleep(5 * time.Millisecond)
+
+	// With the worker busy and the queue full, a third is refused at once
+	if err := p.Do(context.Background(), func() error { return nil }); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Do with a full queue = %v, want ErrSaturated", err)
+	}
+	if err := <-queued; !errors.Is(err, ErrSaturated) {
+		t.Errorf("Do after waiting = %v, want ErrSaturated", err)
+	}
+	close(release)
+}
+
+func TestNilPool(t *testing.T) {
+	var p *Pool
+	ran := false
+	if err := p.Do(context.Background(), func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("nil pool: ran = %v, err = %v", ran, err)
+	}
+	if New(0, 10, time.Second, nil) != nil {
+		t.Error("New with no workers returned a pool")
+	}
+}